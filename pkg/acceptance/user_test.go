@@ -15,9 +15,8 @@ import (
 	bstorage "github.com/elixirhealth/service-base/pkg/server/storage"
 	"github.com/elixirhealth/user/pkg/server"
 	"github.com/elixirhealth/user/pkg/server/storage"
-	"github.com/elixirhealth/user/pkg/server/storage/postgres/migrations"
+	"github.com/elixirhealth/user/pkg/server/storage/postgres"
 	api "github.com/elixirhealth/user/pkg/userapi"
-	bindata "github.com/mattes/migrate/source/go-bindata"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
@@ -65,6 +64,18 @@ func TestAcceptance(t *testing.T) {
 	// get entities for each user
 	testGetEntities(t, params, st)
 
+	// get entities for all users at once
+	testGetEntitiesBatch(t, params, st)
+
+	// get users for each entity
+	testGetUsers(t, params, st)
+
+	// remove an entity from each user
+	testRemoveEntity(t, params, st)
+
+	// get entities for each user again
+	testGetEntities(t, params, st)
+
 	tearDown(t, st)
 }
 
@@ -85,15 +96,43 @@ func testAddEntity(t *testing.T, params *parameters, st *state) {
 				EntityId: entityID,
 			}
 			ctx, cancel := context.WithTimeout(context.Background(), params.timeout)
-			_, err := st.randClient().AddEntity(ctx, rq)
+			rp, err := st.randClient().AddEntity(ctx, rq)
 			cancel()
 			assert.Nil(t, err)
+			assert.True(t, rp.Created)
+
+			// retrying idempotently succeeds without creating the association again
+			rq.IfNotExists = true
+			ctx, cancel = context.WithTimeout(context.Background(), params.timeout)
+			rp, err = st.randClient().AddEntity(ctx, rq)
+			cancel()
+			assert.Nil(t, err)
+			assert.False(t, rp.Created)
 
 			st.userEntities[userID][entityID] = struct{}{}
 		}
 	}
 }
 
+func testRemoveEntity(t *testing.T, params *parameters, st *state) {
+	for c := 0; c < int(params.nUserIDs); c++ {
+		userID := getUserID(c)
+		for entityID := range st.userEntities[userID] {
+			rq := &api.RemoveEntityRequest{
+				UserId:   userID,
+				EntityId: entityID,
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), params.timeout)
+			_, err := st.randClient().RemoveEntity(ctx, rq)
+			cancel()
+			assert.Nil(t, err)
+
+			delete(st.userEntities[userID], entityID)
+			break
+		}
+	}
+}
+
 func testGetEntities(t *testing.T, params *parameters, st *state) {
 	for c := 0; c < int(params.nUserIDs); c++ {
 		userID := getUserID(c)
@@ -113,6 +152,56 @@ func testGetEntities(t *testing.T, params *parameters, st *state) {
 	}
 }
 
+func testGetEntitiesBatch(t *testing.T, params *parameters, st *state) {
+	rq := &api.GetEntitiesBatchRequest{
+		UserIds: make([]string, params.nUserIDs),
+	}
+	for c := range rq.UserIds {
+		rq.UserIds[c] = getUserID(c)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), params.timeout)
+	rp, err := st.randClient().GetEntitiesBatch(ctx, rq)
+	cancel()
+	assert.Nil(t, err)
+
+	for c, result := range rp.Results {
+		assert.Equal(t, rq.UserIds[c], result.UserId)
+		assert.Empty(t, result.Error)
+		rpEntityIDs := make(map[string]struct{})
+		for _, entityID := range result.EntityIds {
+			rpEntityIDs[entityID] = struct{}{}
+		}
+		assert.Equal(t, st.userEntities[result.UserId], rpEntityIDs)
+	}
+}
+
+func testGetUsers(t *testing.T, params *parameters, st *state) {
+	entityUsers := make(map[string]map[string]struct{})
+	for userID, entityIDs := range st.userEntities {
+		for entityID := range entityIDs {
+			if _, in := entityUsers[entityID]; !in {
+				entityUsers[entityID] = make(map[string]struct{})
+			}
+			entityUsers[entityID][userID] = struct{}{}
+		}
+	}
+	for entityID, userIDs := range entityUsers {
+		rq := &api.GetUsersRequest{
+			EntityId: entityID,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), params.timeout)
+		rp, err := st.randClient().GetUsers(ctx, rq)
+		cancel()
+		assert.Nil(t, err)
+
+		rpUserIDs := make(map[string]struct{})
+		for _, userID := range rp.UserIds {
+			rpUserIDs[userID] = struct{}{}
+		}
+		assert.Equal(t, userIDs, rpUserIDs)
+	}
+}
+
 func getUserID(i int) string {
 	return fmt.Sprintf("User-%d", i)
 }
@@ -169,7 +258,7 @@ func newUserConfigs(params *parameters, st *state) ([]*server.Config, []*net.TCP
 	addrs := make([]*net.TCPAddr, params.nUsers)
 
 	storageParams := storage.NewDefaultParameters()
-	storageParams.Type = bstorage.Postgres
+	storageParams.Type = storage.Postgres
 
 	for i := uint(0); i < params.nUsers; i++ {
 		serverPort, metricsPort := startPort+i*10, startPort+i*10+1
@@ -188,12 +277,7 @@ func tearDown(t *testing.T, st *state) {
 	for _, c := range st.users {
 		c.StopServer()
 	}
-	logger := &bstorage.ZapLogger{Logger: logging.NewDevInfoLogger()}
-	m := bstorage.NewBindataMigrator(
-		st.dbURL,
-		bindata.Resource(migrations.AssetNames(), migrations.Asset),
-		logger,
-	)
+	m := postgres.NewMigrator(st.dbURL, logging.NewDevInfoLogger())
 	err := m.Down()
 	assert.Nil(t, err)
 
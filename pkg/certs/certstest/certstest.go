@@ -0,0 +1,114 @@
+// Package certstest writes certificates for testing TLS between servers and clients.
+package certstest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+const (
+	certValidity = 24 * time.Hour
+	filePerm     = 0600
+)
+
+// Files are the paths of the PEM files of a test CA and the server and client certificates and
+// keys it signed.
+type Files struct {
+	CAFile         string
+	ServerCertFile string
+	ServerKeyFile  string
+	ClientCertFile string
+	ClientKeyFile  string
+}
+
+// Write writes a new CA and server and client certificates signed by it to PEM files in the
+// directory, replacing any written before. The server certificate is for localhost, and the
+// client certificate has the given common name and organizational units.
+func Write(dir, clientCN string, clientOUs ...string) (*Files, error) {
+	files := &Files{
+		CAFile:         filepath.Join(dir, "ca.pem"),
+		ServerCertFile: filepath.Join(dir, "server.pem"),
+		ServerKeyFile:  filepath.Join(dir, "server-key.pem"),
+		ClientCertFile: filepath.Join(dir, "client.pem"),
+		ClientKeyFile:  filepath.Join(dir, "client-key.pem"),
+	}
+	ca := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caKey, err := writeCert(ca, nil, nil, files.CAFile, "")
+	if err != nil {
+		return nil, err
+	}
+	server := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	_, err = writeCert(server, ca, caKey, files.ServerCertFile, files.ServerKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	client := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: clientCN, OrganizationalUnit: clientOUs},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	_, err = writeCert(client, ca, caKey, files.ClientCertFile, files.ClientKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// writeCert creates a new key and the certificate for it from the template, signed by the parent
+// or self-signed if the parent is nil, and writes them to the files. The key is only written if
+// its file is given.
+func writeCert(
+	template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, certFile, keyFile string,
+) (*ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(certValidity)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(certFile, certPEM, filePerm); err != nil {
+		return nil, err
+	}
+	if keyFile == "" {
+		return key, nil
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return key, ioutil.WriteFile(keyFile, keyPEM, filePerm)
+}
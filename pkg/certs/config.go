@@ -0,0 +1,56 @@
+package certs
+
+import (
+	"crypto/tls"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// http2Proto is the ALPN protocol gRPC connections negotiate.
+	http2Proto = "h2"
+)
+
+// ErrMissingServerCert indicates when creating a server TLS config from a Reloader without a
+// certificate.
+var ErrMissingServerCert = errors.New("server TLS requires a certificate and key")
+
+// NewServerTLSConfig returns a *tls.Config serving the Reloader's current certificate. If the
+// Reloader has a CA pool, clients must present certificates signed by one of its CAs.
+func NewServerTLSConfig(r *Reloader) (*tls.Config, error) {
+	if r.Certificate() == nil {
+		return nil, ErrMissingServerCert
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{http2Proto},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   []string{http2Proto},
+				Certificates: []tls.Certificate{*r.Certificate()},
+			}
+			if caPool := r.CAPool(); caPool != nil {
+				config.ClientCAs = caPool
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return config, nil
+		},
+	}, nil
+}
+
+// NewClientTLSConfig returns a *tls.Config verifying servers with the Reloader's CA pool, or the
+// system's if it has none, and presenting its current certificate, if it has one, to servers
+// requiring client certificates. The CA pool is the one current when the config is created.
+func NewClientTLSConfig(r *Reloader) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    r.CAPool(),
+	}
+	if r.Certificate() != nil {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.Certificate(), nil
+		}
+	}
+	return config
+}
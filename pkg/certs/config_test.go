@@ -0,0 +1,82 @@
+package certs
+
+import (
+	"crypto/tls"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestNewServerTLSConfig_err(t *testing.T) {
+	dir, files := writeTestFiles(t)
+	defer os.RemoveAll(dir)
+	r, err := NewReloader("", "", files.CAFile, zap.NewNop())
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, r.Close()) }()
+
+	config, err := NewServerTLSConfig(r)
+	assert.Equal(t, ErrMissingServerCert, err)
+	assert.Nil(t, config)
+}
+
+func TestTLSConfig_handshake(t *testing.T) {
+	dir, files := writeTestFiles(t)
+	defer os.RemoveAll(dir)
+	serverReloader, err := NewReloader(files.ServerCertFile, files.ServerKeyFile, files.CAFile,
+		zap.NewNop())
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, serverReloader.Close()) }()
+	serverConfig, err := NewServerTLSConfig(serverReloader)
+	assert.Nil(t, err)
+
+	cases := map[string]struct {
+		certFile, keyFile string
+		expectOK          bool
+	}{
+		"mutual TLS": {
+			certFile: files.ClientCertFile,
+			keyFile:  files.ClientKeyFile,
+			expectOK: true,
+		},
+		"no client cert": {},
+	}
+	for desc, c := range cases {
+		clientReloader, err := NewReloader(c.certFile, c.keyFile, files.CAFile, zap.NewNop())
+		assert.Nil(t, err, desc)
+		clientConfig := NewClientTLSConfig(clientReloader)
+		clientConfig.ServerName = "localhost"
+		clientConfig.NextProtos = []string{http2Proto} // as gRPC clients do
+
+		lis, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+		assert.Nil(t, err, desc)
+		serverErrs := make(chan error, 1)
+		go func() {
+			conn, err := lis.Accept()
+			if err != nil {
+				serverErrs <- err
+				return
+			}
+			serverErrs <- conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}()
+
+		conn, err := tls.Dial("tcp", lis.Addr().String(), clientConfig)
+		if err == nil {
+			// the client learns of a rejected certificate on its first read
+			_, err = conn.Read(make([]byte, 1))
+			_ = conn.Close()
+		}
+		serverErr := <-serverErrs
+		assert.Equal(t, c.expectOK, serverErr == nil, desc)
+		if c.expectOK {
+			state := conn.ConnectionState()
+			assert.Equal(t, http2Proto, state.NegotiatedProtocol, desc)
+		} else {
+			assert.NotNil(t, err, desc)
+		}
+		assert.Nil(t, lis.Close(), desc)
+		assert.Nil(t, clientReloader.Close(), desc)
+	}
+}
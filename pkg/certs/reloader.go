@@ -0,0 +1,159 @@
+// Package certs loads the TLS certificates of servers and clients and reloads them when their
+// files change, so that rotating certificates doesn't require a restart.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	logCertFile = "cert_file"
+	logKeyFile  = "key_file"
+	logCAFile   = "ca_file"
+	logEvent    = "event"
+)
+
+var (
+	// ErrMissingKeyPair indicates when only one of a certificate file and a key file is given.
+	ErrMissingKeyPair = errors.New("certificate and key files must be given together")
+
+	// ErrNoCACerts indicates when a CA file has no PEM certificates.
+	ErrNoCACerts = errors.New("no PEM certificates in CA file")
+)
+
+// Reloader holds a certificate and key pair and/or a pool of CA certificates loaded from files,
+// and reloads them when the files change. If a reload fails, e.g., because a new certificate was
+// written before its key, the previous ones are kept until the next change.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	logger   *zap.Logger
+	watcher  *fsnotify.Watcher
+
+	cert   *tls.Certificate
+	caPool *x509.CertPool
+	mu     sync.RWMutex
+}
+
+// NewReloader creates a new *Reloader of the given certificate and key files and CA file, any of
+// which may be empty as long as the certificate and key files are given together.
+func NewReloader(certFile, keyFile, caFile string, logger *zap.Logger) (*Reloader, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, ErrMissingKeyPair
+	}
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		logger:   logger,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// watch the directories rather than the files, since files are often rotated by renaming new
+	// ones over them or, in Kubernetes, by swapping a symlink to their directory
+	dirs := make(map[string]struct{})
+	for _, file := range []string{certFile, keyFile, caFile} {
+		if file != "" {
+			dirs[filepath.Dir(file)] = struct{}{}
+		}
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			_ = watcher.Close()
+			return nil, err
+		}
+	}
+	r.watcher = watcher
+	go r.watch()
+	return r, nil
+}
+
+// Reload loads the certificate and key pair and the CA pool from their files again.
+func (r *Reloader) Reload() error {
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		loaded, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return err
+		}
+		cert = &loaded
+	}
+	var caPool *x509.CertPool
+	if r.caFile != "" {
+		caPEM, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caPEM) {
+			return ErrNoCACerts
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.caPool = cert, caPool
+	return nil
+}
+
+// Certificate returns the current certificate and key pair, or nil if there is none.
+func (r *Reloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// CAPool returns the current pool of CA certificates, or nil if there is none.
+func (r *Reloader) CAPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.caPool
+}
+
+// Close stops watching the files for changes.
+func (r *Reloader) Close() error {
+	return r.watcher.Close()
+}
+
+func (r *Reloader) watch() {
+	for {
+		select {
+		case event, ok := <-r.watcher.Events:
+			if !ok {
+				return
+			}
+			if err := r.Reload(); err != nil {
+				r.logger.Error("unable to reload certificates", append(r.logFields(),
+					zap.Stringer(logEvent, event), zap.Error(err))...)
+				continue
+			}
+			r.logger.Info("reloaded certificates", r.logFields()...)
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return
+			}
+			r.logger.Error("certificate watcher error", zap.Error(err))
+		}
+	}
+}
+
+func (r *Reloader) logFields() []zap.Field {
+	return []zap.Field{
+		zap.String(logCertFile, r.certFile),
+		zap.String(logKeyFile, r.keyFile),
+		zap.String(logCAFile, r.caFile),
+	}
+}
@@ -0,0 +1,106 @@
+package certs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/elixirhealth/user/pkg/certs/certstest"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestNewReloader_ok(t *testing.T) {
+	dir, files := writeTestFiles(t)
+	defer os.RemoveAll(dir)
+
+	r, err := NewReloader(files.ServerCertFile, files.ServerKeyFile, files.CAFile, zap.NewNop())
+	assert.Nil(t, err)
+	assert.NotNil(t, r.Certificate())
+	assert.NotNil(t, r.CAPool())
+	assert.Nil(t, r.Close())
+
+	// CA only
+	r, err = NewReloader("", "", files.CAFile, zap.NewNop())
+	assert.Nil(t, err)
+	assert.Nil(t, r.Certificate())
+	assert.NotNil(t, r.CAPool())
+	assert.Nil(t, r.Close())
+}
+
+func TestNewReloader_err(t *testing.T) {
+	dir, files := writeTestFiles(t)
+	defer os.RemoveAll(dir)
+	notPEMFile := filepath.Join(dir, "not-pem.txt")
+	assert.Nil(t, ioutil.WriteFile(notPEMFile, []byte("not PEM"), 0600))
+
+	cases := map[string]struct {
+		certFile, keyFile, caFile string
+		expectedErr               error
+	}{
+		"cert without key": {
+			certFile:    files.ServerCertFile,
+			expectedErr: ErrMissingKeyPair,
+		},
+		"key without cert": {
+			keyFile:     files.ServerKeyFile,
+			expectedErr: ErrMissingKeyPair,
+		},
+		"mismatched key": {
+			certFile: files.ServerCertFile,
+			keyFile:  files.ClientKeyFile,
+		},
+		"missing CA file": {
+			caFile: filepath.Join(dir, "missing.pem"),
+		},
+		"no CA certs": {
+			caFile:      notPEMFile,
+			expectedErr: ErrNoCACerts,
+		},
+	}
+	for desc, c := range cases {
+		r, err := NewReloader(c.certFile, c.keyFile, c.caFile, zap.NewNop())
+		assert.NotNil(t, err, desc)
+		if c.expectedErr != nil {
+			assert.Equal(t, c.expectedErr, err, desc)
+		}
+		assert.Nil(t, r, desc)
+	}
+}
+
+func TestReloader_watch(t *testing.T) {
+	dir, files := writeTestFiles(t)
+	defer os.RemoveAll(dir)
+	r, err := NewReloader(files.ServerCertFile, files.ServerKeyFile, files.CAFile, zap.NewNop())
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, r.Close()) }()
+	original := r.Certificate()
+
+	// rotate the certificates
+	_, err = certstest.Write(dir, "client")
+	assert.Nil(t, err)
+	reloaded := false
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if r.Certificate() != original {
+			reloaded = true
+			break
+		}
+	}
+	assert.True(t, reloaded)
+
+	// a failed reload keeps the current certificate
+	current := r.Certificate()
+	assert.Nil(t, ioutil.WriteFile(files.ServerKeyFile, []byte("not PEM"), 0600))
+	assert.NotNil(t, r.Reload())
+	assert.Equal(t, current, r.Certificate())
+}
+
+func writeTestFiles(t *testing.T) (string, *certstest.Files) {
+	dir, err := ioutil.TempDir("", "certs")
+	assert.Nil(t, err)
+	files, err := certstest.Write(dir, "client", "user.service")
+	assert.Nil(t, err)
+	return dir, files
+}
@@ -1,15 +1,417 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
 	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/pkg/errors"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultTimeout is the default timeout of a call, including its retries, when its context
+	// has no deadline.
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultMaxRetries is the default max number of times an idempotent call is retried after
+	// a transient failure.
+	DefaultMaxRetries = uint(3)
+
+	// DefaultRetryBaseDelay is the default delay before the first retry, which doubles with each
+	// further retry.
+	DefaultRetryBaseDelay = 100 * time.Millisecond
+
+	// DefaultRetryMaxDelay is the default max delay before a retry.
+	DefaultRetryMaxDelay = 2 * time.Second
 )
 
+// ErrNoAddresses indicates when creating a client without any server addresses.
+var ErrNoAddresses = errors.New("no server addresses given")
+
+// Parameters defines the timeouts and retries of a Client's calls.
+type Parameters struct {
+	// Timeout is the timeout of a call, including its retries, when its context has no
+	// deadline, or zero for none. It doesn't apply to watches.
+	Timeout time.Duration
+
+	// MaxRetries is the max number of times an idempotent call is retried after a transient
+	// failure, e.g., when a server is unavailable.
+	MaxRetries uint
+
+	// RetryBaseDelay is the delay before the first retry, which doubles with each further retry
+	// up to RetryMaxDelay. Each delay is jittered by up to half.
+	RetryBaseDelay time.Duration
+
+	// RetryMaxDelay is the max delay before a retry.
+	RetryMaxDelay time.Duration
+}
+
+// NewDefaultParameters returns a *Parameters object with default values.
+func NewDefaultParameters() *Parameters {
+	return &Parameters{
+		Timeout:        DefaultTimeout,
+		MaxRetries:     DefaultMaxRetries,
+		RetryBaseDelay: DefaultRetryBaseDelay,
+		RetryMaxDelay:  DefaultRetryMaxDelay,
+	}
+}
+
+// Client is a UserClient that balances its calls across servers, retries idempotent calls after
+// transient failures, and returns errors that match the userapi and server sentinel errors they
+// correspond to with errors.Is.
+type Client struct {
+	clients []api.UserClient
+	conns   []*grpc.ClientConn
+	params  *Parameters
+	next    uint32
+}
+
+// New creates a new *Client of the servers at the given addresses, using TLS with the given
+// config if it's non-nil.
+func New(addresses []string, tlsConfig *tls.Config, params *Parameters) (*Client, error) {
+	if len(addresses) == 0 {
+		return nil, ErrNoAddresses
+	}
+	dialOpt := grpc.WithInsecure()
+	if tlsConfig != nil {
+		dialOpt = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+	}
+	c := &Client{
+		clients: make([]api.UserClient, len(addresses)),
+		conns:   make([]*grpc.ClientConn, len(addresses)),
+		params:  params,
+	}
+	for i, address := range addresses {
+		cc, err := grpc.Dial(address, dialOpt)
+		if err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		c.conns[i], c.clients[i] = cc, api.NewUserClient(cc)
+	}
+	return c, nil
+}
+
 // NewInsecure returns a new UserClient without any TLS on the connection.
 func NewInsecure(address string) (api.UserClient, error) {
-	cc, err := grpc.Dial(address, grpc.WithInsecure())
+	return New([]string{address}, nil, NewDefaultParameters())
+}
+
+// NewTLS returns a new UserClient whose connection uses TLS with the given config, which may
+// include a client certificate for servers requiring mutual TLS (see certs.NewClientTLSConfig).
+func NewTLS(address string, tlsConfig *tls.Config) (api.UserClient, error) {
+	return New([]string{address}, tlsConfig, NewDefaultParameters())
+}
+
+// Close closes the connections to the servers.
+func (c *Client) Close() error {
+	var err error
+	for _, cc := range c.conns {
+		if cc == nil {
+			continue
+		}
+		if closeErr := cc.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// AddEntity associates an entity ID with the given user ID. It's only retried if the request's
+// IfNotExists flag is set.
+func (c *Client) AddEntity(
+	ctx context.Context, rq *api.AddEntityRequest, opts ...grpc.CallOption,
+) (*api.AddEntityResponse, error) {
+	var rp *api.AddEntityResponse
+	err := c.call(ctx, rq.IfNotExists, func(ctx context.Context, uc api.UserClient) (err error) {
+		rp, err = uc.AddEntity(ctx, rq, opts...)
+		return err
+	})
+	return rp, err
+}
+
+// AddEntities associates each entity ID with its user ID. It isn't retried, and the errors of its
+// results can be converted with ResultErr.
+func (c *Client) AddEntities(
+	ctx context.Context, rq *api.AddEntitiesRequest, opts ...grpc.CallOption,
+) (*api.AddEntitiesResponse, error) {
+	var rp *api.AddEntitiesResponse
+	err := c.call(ctx, false, func(ctx context.Context, uc api.UserClient) (err error) {
+		rp, err = uc.AddEntities(ctx, rq, opts...)
+		return err
+	})
+	return rp, err
+}
+
+// RemoveEntity dissociates an entity ID from the given user ID. It isn't retried, since a retry
+// after the removal succeeded would fail.
+func (c *Client) RemoveEntity(
+	ctx context.Context, rq *api.RemoveEntityRequest, opts ...grpc.CallOption,
+) (*api.RemoveEntityResponse, error) {
+	var rp *api.RemoveEntityResponse
+	err := c.call(ctx, false, func(ctx context.Context, uc api.UserClient) (err error) {
+		rp, err = uc.RemoveEntity(ctx, rq, opts...)
+		return err
+	})
+	return rp, err
+}
+
+// GetEntities returns a page of the entity IDs associated with the given user ID.
+func (c *Client) GetEntities(
+	ctx context.Context, rq *api.GetEntitiesRequest, opts ...grpc.CallOption,
+) (*api.GetEntitiesResponse, error) {
+	var rp *api.GetEntitiesResponse
+	err := c.call(ctx, true, func(ctx context.Context, uc api.UserClient) (err error) {
+		rp, err = uc.GetEntities(ctx, rq, opts...)
+		return err
+	})
+	return rp, err
+}
+
+// GetEntitiesBatch returns the entity IDs associated with each of the given user IDs.
+func (c *Client) GetEntitiesBatch(
+	ctx context.Context, rq *api.GetEntitiesBatchRequest, opts ...grpc.CallOption,
+) (*api.GetEntitiesBatchResponse, error) {
+	var rp *api.GetEntitiesBatchResponse
+	err := c.call(ctx, true, func(ctx context.Context, uc api.UserClient) (err error) {
+		rp, err = uc.GetEntitiesBatch(ctx, rq, opts...)
+		return err
+	})
+	return rp, err
+}
+
+// GetUsers returns the user IDs associated with the given entity ID.
+func (c *Client) GetUsers(
+	ctx context.Context, rq *api.GetUsersRequest, opts ...grpc.CallOption,
+) (*api.GetUsersResponse, error) {
+	var rp *api.GetUsersResponse
+	err := c.call(ctx, true, func(ctx context.Context, uc api.UserClient) (err error) {
+		rp, err = uc.GetUsers(ctx, rq, opts...)
+		return err
+	})
+	return rp, err
+}
+
+// GetAssociationHistory returns the events for the associations of the given user ID and/or
+// entity ID.
+func (c *Client) GetAssociationHistory(
+	ctx context.Context, rq *api.GetAssociationHistoryRequest, opts ...grpc.CallOption,
+) (*api.GetAssociationHistoryResponse, error) {
+	var rp *api.GetAssociationHistoryResponse
+	err := c.call(ctx, true, func(ctx context.Context, uc api.UserClient) (err error) {
+		rp, err = uc.GetAssociationHistory(ctx, rq, opts...)
+		return err
+	})
+	return rp, err
+}
+
+// WatchEntities streams the events for the associations of the given user ID and/or entity ID.
+// It isn't retried or given the default timeout, so callers resume interrupted watches from the
+// revision of the last event they received.
+func (c *Client) WatchEntities(
+	ctx context.Context, rq *api.WatchEntitiesRequest, opts ...grpc.CallOption,
+) (api.User_WatchEntitiesClient, error) {
+	stream, err := c.pick().WatchEntities(ctx, rq, opts...)
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return &watchEntitiesClient{User_WatchEntitiesClient: stream}, nil
+}
+
+// SetUserLimit overrides the max number of entities the given user ID may be associated with.
+func (c *Client) SetUserLimit(
+	ctx context.Context, rq *api.SetUserLimitRequest, opts ...grpc.CallOption,
+) (*api.SetUserLimitResponse, error) {
+	var rp *api.SetUserLimitResponse
+	err := c.call(ctx, true, func(ctx context.Context, uc api.UserClient) (err error) {
+		rp, err = uc.SetUserLimit(ctx, rq, opts...)
+		return err
+	})
+	return rp, err
+}
+
+// SetEntityLimit overrides the max number of users the given entity ID may be associated with.
+func (c *Client) SetEntityLimit(
+	ctx context.Context, rq *api.SetEntityLimitRequest, opts ...grpc.CallOption,
+) (*api.SetEntityLimitResponse, error) {
+	var rp *api.SetEntityLimitResponse
+	err := c.call(ctx, true, func(ctx context.Context, uc api.UserClient) (err error) {
+		rp, err = uc.SetEntityLimit(ctx, rq, opts...)
+		return err
+	})
+	return rp, err
+}
+
+// UpdateRole changes the role of the given user ID for the associated entity ID.
+func (c *Client) UpdateRole(
+	ctx context.Context, rq *api.UpdateRoleRequest, opts ...grpc.CallOption,
+) (*api.UpdateRoleResponse, error) {
+	var rp *api.UpdateRoleResponse
+	err := c.call(ctx, true, func(ctx context.Context, uc api.UserClient) (err error) {
+		rp, err = uc.UpdateRole(ctx, rq, opts...)
+		return err
+	})
+	return rp, err
+}
+
+// PutUser creates the profile of a user or replaces it if one already exists.
+func (c *Client) PutUser(
+	ctx context.Context, rq *api.PutUserRequest, opts ...grpc.CallOption,
+) (*api.PutUserResponse, error) {
+	var rp *api.PutUserResponse
+	err := c.call(ctx, true, func(ctx context.Context, uc api.UserClient) (err error) {
+		rp, err = uc.PutUser(ctx, rq, opts...)
+		return err
+	})
+	return rp, err
+}
+
+// GetUser returns the profile of the given user ID.
+func (c *Client) GetUser(
+	ctx context.Context, rq *api.GetUserRequest, opts ...grpc.CallOption,
+) (*api.GetUserResponse, error) {
+	var rp *api.GetUserResponse
+	err := c.call(ctx, true, func(ctx context.Context, uc api.UserClient) (err error) {
+		rp, err = uc.GetUser(ctx, rq, opts...)
+		return err
+	})
+	return rp, err
+}
+
+// UpdateUser merges the populated fields of the given profile into the user's existing profile.
+func (c *Client) UpdateUser(
+	ctx context.Context, rq *api.UpdateUserRequest, opts ...grpc.CallOption,
+) (*api.UpdateUserResponse, error) {
+	var rp *api.UpdateUserResponse
+	err := c.call(ctx, true, func(ctx context.Context, uc api.UserClient) (err error) {
+		rp, err = uc.UpdateUser(ctx, rq, opts...)
+		return err
+	})
+	return rp, err
+}
+
+// ExportAssociations streams every association with its full history. Like WatchEntities, it
+// isn't retried or given the default timeout, since it lasts as long as the export.
+func (c *Client) ExportAssociations(
+	ctx context.Context, rq *api.ExportAssociationsRequest, opts ...grpc.CallOption,
+) (api.User_ExportAssociationsClient, error) {
+	stream, err := c.pick().ExportAssociations(ctx, rq, opts...)
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return &exportAssociationsClient{User_ExportAssociationsClient: stream}, nil
+}
+
+// ImportAssociations opens a stream to import associations with their full history. It isn't
+// retried or given the default timeout, since it lasts as long as the import.
+func (c *Client) ImportAssociations(
+	ctx context.Context, opts ...grpc.CallOption,
+) (api.User_ImportAssociationsClient, error) {
+	stream, err := c.pick().ImportAssociations(ctx, opts...)
 	if err != nil {
-		return nil, err
+		return nil, fromStatus(err)
+	}
+	return &importAssociationsClient{User_ImportAssociationsClient: stream}, nil
+}
+
+// call invokes the call on the next server with the default timeout, if the context has no
+// deadline. If the call is idempotent, it's retried on the following servers after transient
+// failures.
+func (c *Client) call(
+	ctx context.Context,
+	idempotent bool,
+	invoke func(ctx context.Context, uc api.UserClient) error,
+) error {
+	if _, ok := ctx.Deadline(); !ok && c.params.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.params.Timeout)
+		defer cancel()
+	}
+	for retry := uint(0); ; retry++ {
+		err := invoke(ctx, c.pick())
+		if err == nil || !idempotent || retry == c.params.MaxRetries || !transient(err) {
+			return fromStatus(err)
+		}
+		select {
+		case <-ctx.Done():
+			return fromStatus(err)
+		case <-time.After(c.retryDelay(retry)):
+		}
+	}
+}
+
+// pick returns the client of the next server in round-robin order.
+func (c *Client) pick() api.UserClient {
+	i := atomic.AddUint32(&c.next, 1) - 1
+	return c.clients[int(i%uint32(len(c.clients)))]
+}
+
+// retryDelay returns the jittered delay before the given retry.
+func (c *Client) retryDelay(retry uint) time.Duration {
+	delay := c.params.RetryMaxDelay
+	if retry < 32 && c.params.RetryBaseDelay<<retry < delay {
+		delay = c.params.RetryBaseDelay << retry
+	}
+	if delay <= 1 {
+		return delay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+}
+
+// transient returns whether the error is one a retry might not get.
+func transient(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	switch st.Code() {
+	case codes.Unavailable, codes.Aborted:
+		return true
 	}
-	return api.NewUserClient(cc), nil
+	return false
+}
+
+// watchEntitiesClient is a User_WatchEntitiesClient whose errors match the sentinel errors they
+// correspond to.
+type watchEntitiesClient struct {
+	api.User_WatchEntitiesClient
+}
+
+func (s *watchEntitiesClient) Recv() (*api.WatchEntitiesResponse, error) {
+	rp, err := s.User_WatchEntitiesClient.Recv()
+	return rp, fromStatus(err)
+}
+
+// exportAssociationsClient is a User_ExportAssociationsClient whose errors match the sentinel
+// errors they correspond to.
+type exportAssociationsClient struct {
+	api.User_ExportAssociationsClient
+}
+
+func (s *exportAssociationsClient) Recv() (*api.ExportAssociationsResponse, error) {
+	rp, err := s.User_ExportAssociationsClient.Recv()
+	return rp, fromStatus(err)
+}
+
+// importAssociationsClient is a User_ImportAssociationsClient whose errors match the sentinel
+// errors they correspond to. When Send returns io.EOF, the server has ended the stream, and
+// CloseAndRecv returns the error it ended it with.
+type importAssociationsClient struct {
+	api.User_ImportAssociationsClient
+}
+
+func (s *importAssociationsClient) Send(rq *api.ImportAssociationsRequest) error {
+	return fromStatus(s.User_ImportAssociationsClient.Send(rq))
+}
+
+func (s *importAssociationsClient) CloseAndRecv() (*api.ImportAssociationsResponse, error) {
+	rp, err := s.User_ImportAssociationsClient.CloseAndRecv()
+	return rp, fromStatus(err)
 }
@@ -0,0 +1,293 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/elixirhealth/user/pkg/server/storage"
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errUnavailable = status.Error(codes.Unavailable, "connection refused")
+
+func TestNew_err(t *testing.T) {
+	c, err := New(nil, nil, NewDefaultParameters())
+	assert.Equal(t, ErrNoAddresses, err)
+	assert.Nil(t, c)
+}
+
+func TestNew_ok(t *testing.T) {
+	// dialing doesn't block, so the servers needn't be up
+	c, err := New([]string{"localhost:10300", "localhost:10301"}, nil, NewDefaultParameters())
+	assert.Nil(t, err)
+	assert.Len(t, c.clients, 2)
+	assert.Nil(t, c.Close())
+}
+
+func TestClient_balance(t *testing.T) {
+	ucs := []*fixedUserClient{{}, {}, {}}
+	c := newTestClient(ucs...)
+	for i := 0; i < 6; i++ {
+		_, err := c.GetEntities(context.Background(), &api.GetEntitiesRequest{})
+		assert.Nil(t, err)
+	}
+	for i, uc := range ucs {
+		assert.Equal(t, 2, uc.nCalls, i)
+	}
+}
+
+func TestClient_retry(t *testing.T) {
+	cases := map[string]struct {
+		errs           []error
+		call           func(c *Client) error
+		expectedNCalls int
+		expectedErr    error
+	}{
+		"idempotent succeeds after retries": {
+			errs:           []error{errUnavailable, errUnavailable},
+			call:           getEntities,
+			expectedNCalls: 3,
+		},
+		"idempotent fails after max retries": {
+			errs:           []error{errUnavailable, errUnavailable, errUnavailable, errUnavailable},
+			call:           getEntities,
+			expectedNCalls: 4,
+			expectedErr:    errUnavailable,
+		},
+		"idempotent not retried after non-transient error": {
+			errs:           []error{status.Error(codes.Internal, "some internal error")},
+			call:           getEntities,
+			expectedNCalls: 1,
+			expectedErr:    status.Error(codes.Internal, "some internal error"),
+		},
+		"AddEntity retried if not exists": {
+			errs: []error{errUnavailable},
+			call: func(c *Client) error {
+				rq := &api.AddEntityRequest{IfNotExists: true}
+				_, err := c.AddEntity(context.Background(), rq)
+				return err
+			},
+			expectedNCalls: 2,
+		},
+		"AddEntity not retried": {
+			errs: []error{errUnavailable},
+			call: func(c *Client) error {
+				_, err := c.AddEntity(context.Background(), &api.AddEntityRequest{})
+				return err
+			},
+			expectedNCalls: 1,
+			expectedErr:    errUnavailable,
+		},
+		"RemoveEntity not retried": {
+			errs: []error{errUnavailable},
+			call: func(c *Client) error {
+				_, err := c.RemoveEntity(context.Background(), &api.RemoveEntityRequest{})
+				return err
+			},
+			expectedNCalls: 1,
+			expectedErr:    errUnavailable,
+		},
+	}
+	for desc, cs := range cases {
+		uc := &fixedUserClient{errs: cs.errs}
+		c := newTestClient(uc)
+		err := cs.call(c)
+		assert.Equal(t, cs.expectedErr, err, desc)
+		assert.Equal(t, cs.expectedNCalls, uc.nCalls, desc)
+	}
+}
+
+func TestClient_retryOtherServer(t *testing.T) {
+	down, up := &fixedUserClient{errs: []error{errUnavailable}}, &fixedUserClient{}
+	c := newTestClient(down, up)
+	err := getEntities(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, down.nCalls)
+	assert.Equal(t, 1, up.nCalls)
+}
+
+func TestClient_timeout(t *testing.T) {
+	uc := &fixedUserClient{block: true}
+	c := newTestClient(uc)
+	c.params.Timeout = 10 * time.Millisecond
+
+	// default timeout
+	err := getEntities(c)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// context's deadline takes precedence
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	cancel()
+	_, err = c.GetEntities(ctx, &api.GetEntitiesRequest{})
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestClient_errors(t *testing.T) {
+	uc := &fixedUserClient{errs: []error{
+		status.Error(codes.ResourceExhausted, storage.ErrTooManyUserEntities.Error()),
+	}}
+	c := newTestClient(uc)
+	_, err := c.AddEntity(context.Background(), &api.AddEntityRequest{})
+	assert.True(t, errors.Is(err, storage.ErrTooManyUserEntities))
+	st, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+}
+
+func TestClient_WatchEntities(t *testing.T) {
+	uc := &fixedUserClient{
+		stream: &fixedWatchEntitiesClient{
+			err: status.Error(codes.OutOfRange, storage.ErrRevisionCompacted.Error()),
+		},
+	}
+	c := newTestClient(uc)
+	stream, err := c.WatchEntities(context.Background(), &api.WatchEntitiesRequest{})
+	assert.Nil(t, err)
+	rp, err := stream.Recv()
+	assert.Nil(t, rp)
+	assert.True(t, errors.Is(err, storage.ErrRevisionCompacted))
+}
+
+func TestClient_ImportAssociations(t *testing.T) {
+	uc := &fixedUserClient{
+		importStream: &fixedImportAssociationsClient{
+			err: status.Error(codes.AlreadyExists, storage.ErrUserEntityExists.Error()),
+		},
+	}
+	c := newTestClient(uc)
+	stream, err := c.ImportAssociations(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, stream.Send(&api.ImportAssociationsRequest{}))
+	rp, err := stream.CloseAndRecv()
+	assert.Nil(t, rp)
+	assert.True(t, errors.Is(err, storage.ErrUserEntityExists))
+}
+
+func TestClient_retryDelay(t *testing.T) {
+	c := newTestClient()
+	c.params.RetryBaseDelay = 100 * time.Millisecond
+	c.params.RetryMaxDelay = time.Second
+	cases := map[uint]time.Duration{
+		0:  100 * time.Millisecond,
+		1:  200 * time.Millisecond,
+		3:  800 * time.Millisecond,
+		4:  time.Second,
+		64: time.Second,
+	}
+	for retry, maxDelay := range cases {
+		delay := c.retryDelay(retry)
+		assert.True(t, delay >= maxDelay/2, retry)
+		assert.True(t, delay <= maxDelay, retry)
+	}
+}
+
+func getEntities(c *Client) error {
+	_, err := c.GetEntities(context.Background(), &api.GetEntitiesRequest{})
+	return err
+}
+
+func newTestClient(ucs ...*fixedUserClient) *Client {
+	clients := make([]api.UserClient, len(ucs))
+	for i, uc := range ucs {
+		clients[i] = uc
+	}
+	params := NewDefaultParameters()
+	params.RetryBaseDelay = time.Millisecond
+	params.RetryMaxDelay = time.Millisecond
+	return &Client{clients: clients, params: params}
+}
+
+type fixedUserClient struct {
+	api.UserClient
+	errs         []error
+	block        bool
+	stream       api.User_WatchEntitiesClient
+	importStream api.User_ImportAssociationsClient
+	nCalls       int
+}
+
+func (f *fixedUserClient) AddEntity(
+	ctx context.Context, rq *api.AddEntityRequest, opts ...grpc.CallOption,
+) (*api.AddEntityResponse, error) {
+	if err := f.next(ctx); err != nil {
+		return nil, err
+	}
+	return &api.AddEntityResponse{}, nil
+}
+
+func (f *fixedUserClient) RemoveEntity(
+	ctx context.Context, rq *api.RemoveEntityRequest, opts ...grpc.CallOption,
+) (*api.RemoveEntityResponse, error) {
+	if err := f.next(ctx); err != nil {
+		return nil, err
+	}
+	return &api.RemoveEntityResponse{}, nil
+}
+
+func (f *fixedUserClient) GetEntities(
+	ctx context.Context, rq *api.GetEntitiesRequest, opts ...grpc.CallOption,
+) (*api.GetEntitiesResponse, error) {
+	if err := f.next(ctx); err != nil {
+		return nil, err
+	}
+	return &api.GetEntitiesResponse{}, nil
+}
+
+func (f *fixedUserClient) WatchEntities(
+	ctx context.Context, rq *api.WatchEntitiesRequest, opts ...grpc.CallOption,
+) (api.User_WatchEntitiesClient, error) {
+	return f.stream, nil
+}
+
+func (f *fixedUserClient) ImportAssociations(
+	ctx context.Context, opts ...grpc.CallOption,
+) (api.User_ImportAssociationsClient, error) {
+	return f.importStream, nil
+}
+
+// next returns the call's error, which is the next fixed error, if any are left, or the context's
+// error when blocking until it's done, as gRPC does.
+func (f *fixedUserClient) next(ctx context.Context) error {
+	f.nCalls++
+	if f.block {
+		<-ctx.Done()
+		if ctx.Err() == context.Canceled {
+			return status.Error(codes.Canceled, ctx.Err().Error())
+		}
+		return status.Error(codes.DeadlineExceeded, ctx.Err().Error())
+	}
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+type fixedWatchEntitiesClient struct {
+	api.User_WatchEntitiesClient
+	err error
+}
+
+func (f *fixedWatchEntitiesClient) Recv() (*api.WatchEntitiesResponse, error) {
+	return nil, f.err
+}
+
+type fixedImportAssociationsClient struct {
+	api.User_ImportAssociationsClient
+	err error
+}
+
+func (f *fixedImportAssociationsClient) Send(rq *api.ImportAssociationsRequest) error {
+	return nil
+}
+
+func (f *fixedImportAssociationsClient) CloseAndRecv() (*api.ImportAssociationsResponse, error) {
+	return nil, f.err
+}
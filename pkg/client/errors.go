@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+
+	"github.com/elixirhealth/user/pkg/server/storage"
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusKey identifies the sentinel error a server status was converted from.
+type statusKey struct {
+	code    codes.Code
+	message string
+}
+
+// sentinels maps the code and message of each status the server converts a sentinel error into
+// back to that error.
+var sentinels = make(map[statusKey]error)
+
+func init() {
+	register := func(code codes.Code, errs ...error) {
+		for _, err := range errs {
+			sentinels[statusKey{code, err.Error()}] = err
+		}
+	}
+	register(codes.InvalidArgument,
+		api.ErrEmptyUserID,
+		api.ErrEmptyEntityID,
+		api.ErrEmptyUserAndEntityID,
+		api.ErrInvalidAsOf,
+		api.ErrEmptyRole,
+		api.ErrInvalidRole,
+		api.ErrEmptyUser,
+		api.ErrInvalidEmail,
+		api.ErrEmptyAttributeKey,
+		api.ErrInvalidPageToken,
+		api.ErrEmptyBatch,
+		api.ErrBatchTooLarge,
+		api.ErrInvalidConflictMode,
+		api.ErrEmptyVersions,
+		api.ErrInvalidVersions,
+		api.ErrDuplicateAssociation,
+		api.ErrImportTooLarge,
+	)
+	register(codes.ResourceExhausted, storage.ErrTooManyUserEntities, storage.ErrTooManyEntityUsers)
+	register(codes.AlreadyExists, storage.ErrUserEntityExists)
+	register(codes.NotFound, storage.ErrUserEntityNotExists, storage.ErrUserNotExists)
+	register(codes.OutOfRange, storage.ErrRevisionCompacted, storage.ErrFutureRevision)
+	register(codes.Aborted, storage.ErrWatchInterrupted)
+	register(codes.DeadlineExceeded, context.DeadlineExceeded)
+	register(codes.Canceled, context.Canceled)
+}
+
+// StatusError is an error from a server whose status corresponds to a sentinel error. It matches
+// that error with errors.Is and errors.Cause, and keeps the status, including any details, for
+// status.FromError and status.Code.
+type StatusError struct {
+	status *status.Status
+	err    error
+}
+
+// Error returns the status message, which is the same as the sentinel error's.
+func (e *StatusError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the sentinel error.
+func (e *StatusError) Unwrap() error {
+	return e.err
+}
+
+// Cause returns the sentinel error.
+func (e *StatusError) Cause() error {
+	return e.err
+}
+
+// GRPCStatus returns the status the server returned.
+func (e *StatusError) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// fromStatus converts a status error into a *StatusError if its status corresponds to a sentinel
+// error. Other errors are returned as is.
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	if sentinel, in := sentinels[statusKey{st.Code(), st.Message()}]; in {
+		return &StatusError{status: st, err: sentinel}
+	}
+	return err
+}
+
+// ResultErr returns the error of an AddEntities result, converted as the errors of calls are, or
+// nil if its association was added.
+func ResultErr(result *api.AddEntityResult) error {
+	if codes.Code(result.Code) == codes.OK {
+		return nil
+	}
+	return fromStatus(status.Error(codes.Code(result.Code), result.Error))
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/elixirhealth/user/pkg/server/storage"
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFromStatus(t *testing.T) {
+	otherErr := errors.New("some other error")
+	cases := map[string]struct {
+		err         error
+		expectedErr error
+	}{
+		"nil": {},
+		"not a status": {
+			err:         otherErr,
+			expectedErr: otherErr,
+		},
+		"invalid argument": {
+			err:         status.Error(codes.InvalidArgument, api.ErrEmptyUserID.Error()),
+			expectedErr: api.ErrEmptyUserID,
+		},
+		"too many user entities": {
+			err: status.Error(codes.ResourceExhausted,
+				storage.ErrTooManyUserEntities.Error()),
+			expectedErr: storage.ErrTooManyUserEntities,
+		},
+		"association exists": {
+			err:         status.Error(codes.AlreadyExists, storage.ErrUserEntityExists.Error()),
+			expectedErr: storage.ErrUserEntityExists,
+		},
+		"user not exists": {
+			err:         status.Error(codes.NotFound, storage.ErrUserNotExists.Error()),
+			expectedErr: storage.ErrUserNotExists,
+		},
+		"deadline exceeded": {
+			err:         status.Error(codes.DeadlineExceeded, context.DeadlineExceeded.Error()),
+			expectedErr: context.DeadlineExceeded,
+		},
+	}
+	for desc, c := range cases {
+		err := fromStatus(c.err)
+		if c.expectedErr == nil {
+			assert.Nil(t, err, desc)
+			continue
+		}
+		assert.True(t, errors.Is(err, c.expectedErr), desc)
+		assert.Equal(t, c.expectedErr.Error(), err.Error(), desc)
+	}
+
+	// statuses not from sentinel errors are kept as is
+	err := status.Error(codes.NotFound, "some other resource does not exist")
+	assert.Equal(t, err, fromStatus(err))
+
+	// the same message with another code isn't converted
+	err = status.Error(codes.Internal, storage.ErrUserNotExists.Error())
+	assert.Equal(t, err, fromStatus(err))
+}
+
+func TestResultErr(t *testing.T) {
+	assert.Nil(t, ResultErr(&api.AddEntityResult{}))
+
+	err := ResultErr(&api.AddEntityResult{
+		Error: storage.ErrTooManyEntityUsers.Error(),
+		Code:  uint32(codes.ResourceExhausted),
+	})
+	assert.True(t, errors.Is(err, storage.ErrTooManyEntityUsers))
+}
@@ -9,8 +9,8 @@ import (
 	"github.com/drausin/libri/libri/common/logging"
 	"github.com/elixirhealth/service-base/pkg/cmd"
 	bserver "github.com/elixirhealth/service-base/pkg/server"
-	bstorage "github.com/elixirhealth/service-base/pkg/server/storage"
 	"github.com/elixirhealth/user/pkg/server"
+	"github.com/elixirhealth/user/pkg/server/storage"
 	"github.com/elixirhealth/user/version"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -27,6 +27,39 @@ const (
 	dbURLFlag           = "dbURL"
 	dbPasswordFlag      = "dbPassword"
 	storagePostgresFlag = "storagePostgres"
+	maxUserEntitiesFlag = "maxUserEntities"
+	maxEntityUsersFlag  = "maxEntityUsers"
+	cacheSizeFlag       = "cacheSize"
+	cacheTTLFlag        = "cacheTTL"
+
+	storageDataStoreFlag      = "storageDataStore"
+	gcpProjectIDFlag          = "gcpProjectID"
+	datastoreEmulatorHostFlag = "datastoreEmulatorHost"
+
+	storageLocalFlag = "storageLocal"
+	dataDirFlag      = "dataDir"
+
+	authJWKSFileFlag    = "authJWKSFile"
+	authJWTIssuerFlag   = "authJWTIssuer"
+	authJWTAudienceFlag = "authJWTAudience"
+	authMTLSFlag        = "authMTLS"
+
+	tlsCertFileFlag     = "tlsCertFile"
+	tlsKeyFileFlag      = "tlsKeyFile"
+	tlsClientCAFileFlag = "tlsClientCAFile"
+
+	tlsFlag            = "tls"
+	tlsCAFileFlag      = "tlsCAFile"
+	clientCertFileFlag = "clientCertFile"
+	clientKeyFileFlag  = "clientKeyFile"
+
+	formatFlag   = "format"
+	outputFlag   = "output"
+	inputFlag    = "input"
+	conflictFlag = "conflict"
+	dryRunFlag   = "dryRun"
+
+	defaultDataDir = "data"
 )
 
 var (
@@ -46,21 +79,51 @@ func init() {
 		func(flags *pflag.FlagSet) {
 			flags.Bool(storageMemoryFlag, false, "use in-memory storage")
 			flags.Bool(storagePostgresFlag, false, "use Postgres DB storage")
+			flags.Bool(storageDataStoreFlag, false, "use GCP DataStore storage")
+			flags.Bool(storageLocalFlag, false, "use embedded on-disk storage")
 			flags.String(dbURLFlag, "", "Postgres DB URL, including username")
 			flags.String(dbPasswordFlag, "", "DB user's password")
+			flags.String(gcpProjectIDFlag, "", "GCP project ID for DataStore storage")
+			flags.String(datastoreEmulatorHostFlag, "",
+				"host:port of a DataStore emulator to use instead of GCP DataStore")
+			flags.String(dataDirFlag, defaultDataDir, "data directory for local storage")
+			flags.Uint(maxUserEntitiesFlag, server.DefaultMaxUserEntities,
+				"max number of entities a user can be associated with")
+			flags.Uint(maxEntityUsersFlag, server.DefaultMaxEntityUsers,
+				"max number of users an entity can be associated with")
+			flags.Int(cacheSizeFlag, storage.DefaultCacheSize,
+				"max number of entity lists and counts to cache for reads, or 0 to "+
+					"disable caching; adds still check limits in storage; postgres "+
+					"invalidates them from its change feed of all servers' writes, and "+
+					"datastore doesn't support caching since other servers' writes would "+
+					"go unseen")
+			flags.Duration(cacheTTLFlag, storage.DefaultCacheTTL,
+				"how long to cache entity lists and counts")
+			flags.String(authJWKSFileFlag, "",
+				"local JWKS file with the keys signing callers' bearer tokens")
+			flags.String(authJWTIssuerFlag, "", "issuer callers' bearer tokens must have")
+			flags.String(authJWTAudienceFlag, "", "audience callers' bearer tokens must have")
+			flags.Bool(authMTLSFlag, false,
+				"authenticate callers by their verified TLS client certificates")
+			flags.String(tlsCertFileFlag, "", "PEM certificate file for serving TLS")
+			flags.String(tlsKeyFileFlag, "", "PEM key file for serving TLS")
+			flags.String(tlsClientCAFileFlag, "",
+				"PEM file of CA certificates that must sign client certificates (mutual TLS)")
 		})
 
 	testCmd := cmd.Test(serviceNameLower, rootCmd)
 	cmd.TestHealth(serviceNameLower, testCmd)
-	cmd.TestIO(serviceNameLower, testCmd, testIO, func(flags *pflag.FlagSet) {
-		// add additional test flags here if needed
-	})
+	cmd.TestIO(serviceNameLower, testCmd, testIO, defineClientTLSFlags)
+
+	rootCmd.AddCommand(exportCmd, importCmd)
 
 	cmd.Version(serviceNameLower, rootCmd, version.Current)
 
 	// bind viper flags
 	viper.SetEnvPrefix(envVarPrefix) // look for env vars with prefix
 	viper.AutomaticEnv()             // read in environment variables that match
+	// also accept the emulator env var used by the gcloud CLI and DataStore client library
+	cerrors.MaybePanic(viper.BindEnv(datastoreEmulatorHostFlag, server.DatastoreEmulatorHostEnv))
 	cerrors.MaybePanic(viper.BindPFlags(rootCmd.Flags()))
 }
 
@@ -71,6 +134,15 @@ func Execute() {
 	}
 }
 
+// defineClientTLSFlags defines the flags for connecting to the servers with TLS.
+func defineClientTLSFlags(flags *pflag.FlagSet) {
+	flags.Bool(tlsFlag, false, "connect to the servers with TLS")
+	flags.String(tlsCAFileFlag, "",
+		"PEM file of CA certificates to verify the servers with instead of the system's")
+	flags.String(clientCertFileFlag, "", "PEM client certificate file for mutual TLS")
+	flags.String(clientKeyFileFlag, "", "PEM client key file for mutual TLS")
+}
+
 func start() error {
 	config, err := getUserConfig()
 	if err != nil {
@@ -86,12 +158,25 @@ func getUserConfig() (*server.Config, error) {
 		WithProfilerPort(uint(viper.GetInt(cmd.ProfilerPortFlag))).
 		WithLogLevel(logging.GetLogLevel(viper.GetString(logLevelFlag))).
 		WithProfile(viper.GetBool(cmd.ProfileFlag))
+	c.WithMaxUserEntities(uint(viper.GetInt(maxUserEntitiesFlag))).
+		WithMaxEntityUsers(uint(viper.GetInt(maxEntityUsersFlag)))
 	st, err := getStorageType()
 	if err != nil {
 		return nil, err
 	}
 	c.Storage.Type = st
-	c.DBUrl = getDBUrl()
+	c.Storage.CacheSize = viper.GetInt(cacheSizeFlag)
+	c.Storage.CacheTTL = viper.GetDuration(cacheTTLFlag)
+	c.WithDBUrl(getDBUrl()).
+		WithGCPProjectID(viper.GetString(gcpProjectIDFlag)).
+		WithDatastoreEmulatorHost(viper.GetString(datastoreEmulatorHostFlag)).
+		WithDataDir(viper.GetString(dataDirFlag))
+	c.WithAuthJWKSFile(viper.GetString(authJWKSFileFlag)).
+		WithAuthJWTIssuer(viper.GetString(authJWTIssuerFlag)).
+		WithAuthJWTAudience(viper.GetString(authJWTAudienceFlag)).
+		WithAuthMTLS(viper.GetBool(authMTLSFlag)).
+		WithTLSKeyPair(viper.GetString(tlsCertFileFlag), viper.GetString(tlsKeyFileFlag)).
+		WithTLSClientCAFile(viper.GetString(tlsClientCAFileFlag))
 	return c, nil
 }
 
@@ -104,15 +189,25 @@ func getDBUrl() string {
 	return dbURL
 }
 
-func getStorageType() (bstorage.Type, error) {
-	if viper.GetBool(storageMemoryFlag) && viper.GetBool(storagePostgresFlag) {
-		return bstorage.Unspecified, errMultipleStorageTypes
+func getStorageType() (storage.Type, error) {
+	storageTypes := map[string]storage.Type{
+		storageMemoryFlag:    storage.Memory,
+		storagePostgresFlag:  storage.Postgres,
+		storageDataStoreFlag: storage.DataStore,
+		storageLocalFlag:     storage.Local,
 	}
-	if viper.GetBool(storageMemoryFlag) {
-		return bstorage.Memory, nil
+	st := storage.Unspecified
+	for flag, flagST := range storageTypes {
+		if !viper.GetBool(flag) {
+			continue
+		}
+		if st != storage.Unspecified {
+			return storage.Unspecified, errMultipleStorageTypes
+		}
+		st = flagST
 	}
-	if viper.GetBool(storagePostgresFlag) {
-		return bstorage.Postgres, nil
+	if st == storage.Unspecified {
+		return storage.Unspecified, errNoStorageType
 	}
-	return bstorage.Unspecified, errNoStorageType
+	return st, nil
 }
@@ -2,9 +2,10 @@ package cmd
 
 import (
 	"testing"
+	"time"
 
 	"github.com/elixirhealth/service-base/pkg/cmd"
-	bstorage "github.com/elixirhealth/service-base/pkg/server/storage"
+	"github.com/elixirhealth/user/pkg/server/storage"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap/zapcore"
@@ -19,6 +20,20 @@ func TestGetUserConfig(t *testing.T) {
 	dbURL := "some DB URL"
 	storageInMemory := false
 	storagePostgres := true
+	maxUserEntities := uint(32)
+	maxEntityUsers := uint(4096)
+	gcpProjectID := "some-project"
+	datastoreEmulatorHost := "localhost:8081"
+	dataDir := "/var/lib/user"
+	cacheSize := 1024
+	cacheTTL := 5 * time.Second
+	authJWKSFile := "/etc/user/jwks.json"
+	authJWTIssuer := "some issuer"
+	authJWTAudience := "some audience"
+	authMTLS := true
+	tlsCertFile := "/etc/user/server.pem"
+	tlsKeyFile := "/etc/user/server-key.pem"
+	tlsClientCAFile := "/etc/user/client-ca.pem"
 
 	viper.Set(cmd.ServerPortFlag, serverPort)
 	viper.Set(cmd.MetricsPortFlag, metricsPort)
@@ -28,6 +43,20 @@ func TestGetUserConfig(t *testing.T) {
 	viper.Set(dbURLFlag, dbURL)
 	viper.Set(storageMemoryFlag, storageInMemory)
 	viper.Set(storagePostgresFlag, storagePostgres)
+	viper.Set(maxUserEntitiesFlag, maxUserEntities)
+	viper.Set(maxEntityUsersFlag, maxEntityUsers)
+	viper.Set(gcpProjectIDFlag, gcpProjectID)
+	viper.Set(datastoreEmulatorHostFlag, datastoreEmulatorHost)
+	viper.Set(dataDirFlag, dataDir)
+	viper.Set(cacheSizeFlag, cacheSize)
+	viper.Set(cacheTTLFlag, cacheTTL)
+	viper.Set(authJWKSFileFlag, authJWKSFile)
+	viper.Set(authJWTIssuerFlag, authJWTIssuer)
+	viper.Set(authJWTAudienceFlag, authJWTAudience)
+	viper.Set(authMTLSFlag, authMTLS)
+	viper.Set(tlsCertFileFlag, tlsCertFile)
+	viper.Set(tlsKeyFileFlag, tlsKeyFile)
+	viper.Set(tlsClientCAFileFlag, tlsClientCAFile)
 
 	c, err := getUserConfig()
 	assert.Nil(t, err)
@@ -37,5 +66,51 @@ func TestGetUserConfig(t *testing.T) {
 	assert.Equal(t, logLevel, c.LogLevel.String())
 	assert.Equal(t, profile, c.Profile)
 	assert.Equal(t, dbURL, c.DBUrl)
-	assert.Equal(t, bstorage.Postgres, c.Storage.Type)
+	assert.Equal(t, storage.Postgres, c.Storage.Type)
+	assert.Equal(t, maxUserEntities, c.MaxUserEntities)
+	assert.Equal(t, maxEntityUsers, c.MaxEntityUsers)
+	assert.Equal(t, gcpProjectID, c.GCPProjectID)
+	assert.Equal(t, datastoreEmulatorHost, c.DatastoreEmulatorHost)
+	assert.Equal(t, dataDir, c.DataDir)
+	assert.Equal(t, cacheSize, c.Storage.CacheSize)
+	assert.Equal(t, cacheTTL, c.Storage.CacheTTL)
+	assert.Equal(t, authJWKSFile, c.AuthJWKSFile)
+	assert.Equal(t, authJWTIssuer, c.AuthJWTIssuer)
+	assert.Equal(t, authJWTAudience, c.AuthJWTAudience)
+	assert.Equal(t, authMTLS, c.AuthMTLS)
+	assert.Equal(t, tlsCertFile, c.TLSCertFile)
+	assert.Equal(t, tlsKeyFile, c.TLSKeyFile)
+	assert.Equal(t, tlsClientCAFile, c.TLSClientCAFile)
+}
+
+func TestGetStorageType(t *testing.T) {
+	cases := map[string]struct {
+		memory, postgres, datastore, local bool
+		expected                           storage.Type
+		expectedErr                        error
+	}{
+		"memory":    {memory: true, expected: storage.Memory},
+		"postgres":  {postgres: true, expected: storage.Postgres},
+		"datastore": {datastore: true, expected: storage.DataStore},
+		"local":     {local: true, expected: storage.Local},
+		"none": {
+			expected:    storage.Unspecified,
+			expectedErr: errNoStorageType,
+		},
+		"multiple": {
+			postgres:    true,
+			datastore:   true,
+			expected:    storage.Unspecified,
+			expectedErr: errMultipleStorageTypes,
+		},
+	}
+	for desc, c := range cases {
+		viper.Set(storageMemoryFlag, c.memory)
+		viper.Set(storagePostgresFlag, c.postgres)
+		viper.Set(storageDataStoreFlag, c.datastore)
+		viper.Set(storageLocalFlag, c.local)
+		st, err := getStorageType()
+		assert.Equal(t, c.expectedErr, err, desc)
+		assert.Equal(t, c.expected, st, desc)
+	}
 }
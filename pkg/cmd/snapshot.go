@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/drausin/libri/libri/common/logging"
+	"github.com/elixirhealth/service-base/pkg/cmd"
+	"github.com/elixirhealth/user/pkg/snapshot"
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	// stdio is the file name meaning stdin or stdout.
+	stdio = "-"
+
+	logNAssociations = "n_associations"
+	logNImported     = "n_imported"
+	logNOverwritten  = "n_overwritten"
+	logNSkipped      = "n_skipped"
+	logDryRun        = "dry_run"
+)
+
+var (
+	errInvalidConflictMode = errors.New("conflict must be one of skip, overwrite, or fail")
+
+	exportCmd = &cobra.Command{
+		Use:     "export",
+		Short:   "export all associations with their history to a snapshot file",
+		PreRunE: bindFlags,
+		RunE: func(*cobra.Command, []string) error {
+			return exportAssociations()
+		},
+	}
+
+	importCmd = &cobra.Command{
+		Use:     "import",
+		Short:   "import associations with their history from a snapshot file",
+		PreRunE: bindFlags,
+		RunE: func(*cobra.Command, []string) error {
+			return importAssociations()
+		},
+	}
+)
+
+func init() {
+	defineSnapshotFlags(exportCmd.Flags())
+	exportCmd.Flags().String(outputFlag, stdio, "snapshot file to write, or - for stdout")
+
+	defineSnapshotFlags(importCmd.Flags())
+	importCmd.Flags().String(inputFlag, stdio, "snapshot file to read, or - for stdin")
+	importCmd.Flags().String(conflictFlag, "fail",
+		"what to do with associations that already exist: skip, overwrite, or fail")
+	importCmd.Flags().Bool(dryRunFlag, false,
+		"only count what would be imported, overwritten, and skipped")
+}
+
+// defineSnapshotFlags defines the flags shared by the export and import commands.
+func defineSnapshotFlags(flags *pflag.FlagSet) {
+	flags.StringSlice(cmd.AddressesFlag, nil, "comma-separated addresses of servers")
+	flags.String(formatFlag, string(snapshot.NDJSON), "snapshot format: ndjson or proto")
+	defineClientTLSFlags(flags)
+}
+
+func bindFlags(c *cobra.Command, _ []string) error {
+	return viper.BindPFlags(c.Flags())
+}
+
+func exportAssociations() error {
+	logger := logging.NewDevLogger(logging.GetLogLevel(viper.GetString(logLevelFlag)))
+	format, err := snapshot.ParseFormat(viper.GetString(formatFlag))
+	if err != nil {
+		return err
+	}
+	c, err := getClient(logger, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.Close() }()
+	out, err := openOutput(viper.GetString(outputFlag))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = out.Close() }()
+
+	w, err := snapshot.NewWriter(out, format, time.Now())
+	if err != nil {
+		return err
+	}
+	stream, err := c.ExportAssociations(context.Background(), &api.ExportAssociationsRequest{})
+	if err != nil {
+		return err
+	}
+	n := 0
+	for {
+		rp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Error("exporting associations failed", zap.Error(err))
+			return err
+		}
+		if err := w.Write(rp.Association); err != nil {
+			return err
+		}
+		n++
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	logger.Info("exported associations", zap.Int(logNAssociations, n))
+	return out.Close()
+}
+
+func importAssociations() error {
+	logger := logging.NewDevLogger(logging.GetLogLevel(viper.GetString(logLevelFlag)))
+	format, err := snapshot.ParseFormat(viper.GetString(formatFlag))
+	if err != nil {
+		return err
+	}
+	opts, err := getImportOptions()
+	if err != nil {
+		return err
+	}
+	in, err := openInput(viper.GetString(inputFlag))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	r, err := snapshot.NewReader(in, format)
+	if err != nil {
+		return err
+	}
+	c, err := getClient(logger, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.Close() }()
+
+	stream, err := c.ImportAssociations(context.Background())
+	if err != nil {
+		return err
+	}
+	rq := &api.ImportAssociationsRequest{Options: opts}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rq.Associations = append(rq.Associations, record)
+		if len(rq.Associations) == api.MaxBatchSize {
+			if err := stream.Send(rq); err != nil {
+				return err
+			}
+			rq = &api.ImportAssociationsRequest{}
+		}
+	}
+	// the first request always carries the options, even if the snapshot has no records
+	if rq.Options != nil || len(rq.Associations) > 0 {
+		if err := stream.Send(rq); err != nil {
+			return err
+		}
+	}
+	rp, err := stream.CloseAndRecv()
+	if err != nil {
+		logger.Error("importing associations failed", zap.Error(err))
+		return err
+	}
+	logger.Info("imported associations",
+		zap.Uint64(logNImported, rp.NImported),
+		zap.Uint64(logNOverwritten, rp.NOverwritten),
+		zap.Uint64(logNSkipped, rp.NSkipped),
+		zap.Bool(logDryRun, opts.DryRun),
+	)
+	return nil
+}
+
+func getImportOptions() (*api.ImportOptions, error) {
+	mode := api.ConflictMode(api.ConflictMode_value[strings.ToUpper(viper.GetString(conflictFlag))])
+	if mode == api.ConflictMode_UNSPECIFIED_CONFLICT_MODE {
+		return nil, errInvalidConflictMode
+	}
+	return &api.ImportOptions{
+		ConflictMode: mode,
+		DryRun:       viper.GetBool(dryRunFlag),
+	}, nil
+}
+
+func openOutput(name string) (io.WriteCloser, error) {
+	if name == stdio {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(name)
+}
+
+func openInput(name string) (io.ReadCloser, error) {
+	if name == stdio {
+		return os.Stdin, nil
+	}
+	return os.Open(name)
+}
+
+// nopCloser leaves stdout open when the export closes its output.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
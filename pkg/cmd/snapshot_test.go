@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/elixirhealth/service-base/pkg/cmd"
+	"github.com/elixirhealth/user/pkg/client"
+	"github.com/elixirhealth/user/pkg/server"
+	"github.com/elixirhealth/user/pkg/server/storage"
+	"github.com/elixirhealth/user/pkg/snapshot"
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestExportImportAssociations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// start in-memory user
+	config := server.NewDefaultConfig()
+	config.LogLevel = zapcore.DebugLevel
+	config.ServerPort = 10204
+	config.MetricsPort = 10205
+
+	up := make(chan *server.User, 1)
+	wg1 := new(sync.WaitGroup)
+	wg1.Add(1)
+	go func(wg2 *sync.WaitGroup) {
+		defer wg2.Done()
+		err := server.Start(config, up)
+		assert.Nil(t, err)
+	}(wg1)
+
+	x := <-up
+	addr := fmt.Sprintf("localhost:%d", config.ServerPort)
+	c, err := client.NewInsecure(addr)
+	assert.Nil(t, err)
+	_, err = c.AddEntity(context.Background(),
+		&api.AddEntityRequest{UserId: "User-0", EntityId: "Entity-0"})
+	assert.Nil(t, err)
+
+	viper.Set(cmd.AddressesFlag, addr)
+	defer func() {
+		viper.Set(formatFlag, string(snapshot.NDJSON))
+		viper.Set(conflictFlag, "fail")
+		viper.Set(dryRunFlag, false)
+	}()
+	for _, format := range []snapshot.Format{snapshot.NDJSON, snapshot.Proto} {
+		file := filepath.Join(dir, "snapshot."+string(format))
+		viper.Set(formatFlag, string(format))
+		viper.Set(outputFlag, file)
+		viper.Set(inputFlag, file)
+
+		err = exportAssociations()
+		assert.Nil(t, err, string(format))
+
+		// the association already exists
+		viper.Set(conflictFlag, "fail")
+		viper.Set(dryRunFlag, false)
+		err = importAssociations()
+		assert.True(t, errors.Is(err, storage.ErrUserEntityExists), string(format))
+
+		viper.Set(dryRunFlag, true)
+		err = importAssociations()
+		assert.True(t, errors.Is(err, storage.ErrUserEntityExists), string(format))
+
+		viper.Set(conflictFlag, "skip")
+		err = importAssociations()
+		assert.Nil(t, err, string(format))
+
+		viper.Set(conflictFlag, "overwrite")
+		viper.Set(dryRunFlag, false)
+		err = importAssociations()
+		assert.Nil(t, err, string(format))
+	}
+
+	x.StopServer()
+	wg1.Wait()
+}
+
+func TestGetImportOptions(t *testing.T) {
+	defer func() {
+		viper.Set(conflictFlag, "fail")
+		viper.Set(dryRunFlag, false)
+	}()
+	cases := map[string]struct {
+		conflict string
+		dryRun   bool
+		expected *api.ImportOptions
+		err      error
+	}{
+		"skip": {
+			conflict: "skip",
+			expected: &api.ImportOptions{ConflictMode: api.ConflictMode_SKIP},
+		},
+		"overwrite dry run": {
+			conflict: "OVERWRITE",
+			dryRun:   true,
+			expected: &api.ImportOptions{ConflictMode: api.ConflictMode_OVERWRITE, DryRun: true},
+		},
+		"fail": {
+			conflict: "fail",
+			expected: &api.ImportOptions{ConflictMode: api.ConflictMode_FAIL},
+		},
+		"unspecified": {
+			conflict: "unspecified_conflict_mode",
+			err:      errInvalidConflictMode,
+		},
+		"unknown": {
+			conflict: "merge",
+			err:      errInvalidConflictMode,
+		},
+	}
+	for desc, c := range cases {
+		viper.Set(conflictFlag, c.conflict)
+		viper.Set(dryRunFlag, c.dryRun)
+		opts, err := getImportOptions()
+		assert.Equal(t, c.err, err, desc)
+		assert.Equal(t, c.expected, opts, desc)
+	}
+}
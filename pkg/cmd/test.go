@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"math/rand"
 	"time"
@@ -9,8 +10,8 @@ import (
 	"github.com/drausin/libri/libri/common/logging"
 	"github.com/drausin/libri/libri/common/parse"
 	"github.com/elixirhealth/service-base/pkg/cmd"
-	"github.com/elixirhealth/service-base/pkg/server"
-	"github.com/elixirhealth/user/pkg/userapi"
+	"github.com/elixirhealth/user/pkg/certs"
+	"github.com/elixirhealth/user/pkg/client"
 	api "github.com/elixirhealth/user/pkg/userapi"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -34,14 +35,15 @@ func testIO() error {
 
 	userEntities := make(map[string]map[string]struct{})
 
-	clients, err := getClients()
+	c, err := getClient(logger, timeout)
 	if err != nil {
 		return err
 	}
+	defer func() { _ = c.Close() }()
 
 	// add entities
-	for c := 0; c < nUserIDs; c++ {
-		userID := getUserID(c)
+	for u := 0; u < nUserIDs; u++ {
+		userID := getUserID(u)
 		userEntities[userID] = make(map[string]struct{})
 		nEntities := rng.Intn(maxUserEntities) + 1
 		for len(userEntities[userID]) < nEntities {
@@ -55,10 +57,7 @@ func testIO() error {
 				UserId:   userID,
 				EntityId: entityID,
 			}
-			client := clients[rng.Int31n(int32(len(clients)))]
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
-			_, err := client.AddEntity(ctx, rq)
-			cancel()
+			_, err := c.AddEntity(context.Background(), rq)
 			if err2 := logAddEntityKeysRp(logger, rq, err); err2 != nil {
 				return err2
 			}
@@ -67,15 +66,12 @@ func testIO() error {
 	}
 
 	// get entities
-	for c := 0; c < nUserIDs; c++ {
-		userID := getUserID(c)
+	for u := 0; u < nUserIDs; u++ {
+		userID := getUserID(u)
 		rq := &api.GetEntitiesRequest{
 			UserId: userID,
 		}
-		client := clients[rng.Int31n(int32(len(clients)))]
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		rp, err := client.GetEntities(ctx, rq)
-		cancel()
+		rp, err := c.GetEntities(context.Background(), rq)
 		if err2 := logGetEntitiesRp(logger, rq, rp, err); err2 != nil {
 			return err2
 		}
@@ -118,19 +114,38 @@ func getEntityID(i int) string {
 	return fmt.Sprintf("Entity-%d", i)
 }
 
-func getClients() ([]userapi.UserClient, error) {
+// getClient returns a client balancing its calls across the servers, each with the given
+// timeout.
+func getClient(logger *zap.Logger, timeout time.Duration) (*client.Client, error) {
 	addrs, err := parse.Addrs(viper.GetStringSlice(cmd.AddressesFlag))
 	if err != nil {
 		return nil, err
 	}
-	dialer := server.NewInsecureDialer()
-	clients := make([]userapi.UserClient, len(addrs))
+	tlsConfig, err := getClientTLSConfig(logger)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, len(addrs))
 	for i, addr := range addrs {
-		conn, err2 := dialer.Dial(addr.String())
-		if err != nil {
-			return nil, err2
-		}
-		clients[i] = userapi.NewUserClient(conn)
+		addresses[i] = addr.String()
+	}
+	params := client.NewDefaultParameters()
+	params.Timeout = timeout
+	return client.New(addresses, tlsConfig, params)
+}
+
+// getClientTLSConfig returns the TLS config for connecting to the servers, or nil if TLS isn't
+// enabled.
+func getClientTLSConfig(logger *zap.Logger) (*tls.Config, error) {
+	caFile := viper.GetString(tlsCAFileFlag)
+	certFile, keyFile := viper.GetString(clientCertFileFlag), viper.GetString(clientKeyFileFlag)
+	if !viper.GetBool(tlsFlag) && caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	reloader, err := certs.NewReloader(certFile, keyFile, caFile, logger)
+	if err != nil {
+		return nil, err
 	}
-	return clients, nil
+	// the reloader watches the files until the command exits
+	return certs.NewClientTLSConfig(reloader), nil
 }
@@ -2,11 +2,15 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"sync"
 	"testing"
 
 	"github.com/elixirhealth/service-base/pkg/cmd"
+	"github.com/elixirhealth/user/pkg/certs/certstest"
 	"github.com/elixirhealth/user/pkg/server"
+	"github.com/elixirhealth/user/pkg/server/auth"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap/zapcore"
@@ -37,3 +41,46 @@ func TestTestIO(t *testing.T) {
 	x.StopServer()
 	wg1.Wait()
 }
+
+func TestTestIO_mTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	files, err := certstest.Write(dir, "test-io", auth.ServiceScope)
+	assert.Nil(t, err)
+
+	// start in-memory user requiring mutual TLS and authenticating callers by their certificates
+	config := server.NewDefaultConfig().
+		WithTLSKeyPair(files.ServerCertFile, files.ServerKeyFile).
+		WithTLSClientCAFile(files.CAFile).
+		WithAuthMTLS(true)
+	config.LogLevel = zapcore.DebugLevel
+	config.ServerPort = 10202
+	config.MetricsPort = 10203
+
+	up := make(chan *server.User, 1)
+	wg1 := new(sync.WaitGroup)
+	wg1.Add(1)
+	go func(wg2 *sync.WaitGroup) {
+		defer wg2.Done()
+		err := server.Start(config, up)
+		assert.Nil(t, err)
+	}(wg1)
+
+	x := <-up
+	viper.Set(cmd.AddressesFlag, fmt.Sprintf("localhost:%d", config.ServerPort))
+	viper.Set(tlsCAFileFlag, files.CAFile)
+	viper.Set(clientCertFileFlag, files.ClientCertFile)
+	viper.Set(clientKeyFileFlag, files.ClientKeyFile)
+	defer func() {
+		viper.Set(tlsCAFileFlag, "")
+		viper.Set(clientCertFileFlag, "")
+		viper.Set(clientKeyFileFlag, "")
+	}()
+
+	err = testIO()
+	assert.Nil(t, err)
+
+	x.StopServer()
+	wg1.Wait()
+}
@@ -0,0 +1,195 @@
+// Package auth authenticates the callers of User RPCs and authorizes their requests.
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// AdminScope is the scope of callers that may make any request, including changing limits.
+	AdminScope = "user.admin"
+
+	// ServiceScope is the scope of services that may read and change the associations of any
+	// user or entity.
+	ServiceScope = "user.service"
+
+	// userServicePrefix is the prefix of the full method names of the User service, whose
+	// requests are authenticated and authorized.
+	userServicePrefix = "/userapi.User/"
+)
+
+var (
+	// ErrMissingCredentials indicates when a caller gave neither a bearer token nor a verified
+	// client certificate.
+	ErrMissingCredentials = errors.New("missing bearer token or client certificate")
+
+	// ErrInvalidToken indicates when a caller's bearer token is malformed, isn't signed by a
+	// known key, or has invalid claims.
+	ErrInvalidToken = errors.New("invalid bearer token")
+
+	// ErrPermissionDenied indicates when the policy doesn't permit a caller's request.
+	ErrPermissionDenied = errors.New("caller not permitted to make request")
+)
+
+// Identity is the authenticated identity of a caller.
+type Identity struct {
+	// Subject identifies the caller, and for end users is their user ID.
+	Subject string
+
+	// Scopes are the scopes granted to the caller.
+	Scopes map[string]struct{}
+}
+
+// NewIdentity creates a new *Identity with the given subject and scopes.
+func NewIdentity(subject string, scopes ...string) *Identity {
+	id := &Identity{
+		Subject: subject,
+		Scopes:  make(map[string]struct{}),
+	}
+	for _, scope := range scopes {
+		if scope != "" {
+			id.Scopes[scope] = struct{}{}
+		}
+	}
+	return id
+}
+
+// HasScope returns whether the caller was granted any of the given scopes.
+func (id *Identity) HasScope(scopes ...string) bool {
+	for _, scope := range scopes {
+		if _, in := id.Scopes[scope]; in {
+			return true
+		}
+	}
+	return false
+}
+
+type identityKey struct{}
+
+// NewContext returns a copy of the context carrying the caller's identity.
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the caller's identity carried by the context, if any.
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// Authenticator determines the identity of the caller of an RPC from its context.
+type Authenticator interface {
+	// Authenticate returns the caller's identity or ErrMissingCredentials if the caller gave
+	// none of the credentials the Authenticator checks.
+	Authenticate(ctx context.Context) (*Identity, error)
+}
+
+type chainAuthenticator []Authenticator
+
+// NewChain returns an Authenticator that tries each of the given Authenticators in order until
+// one finds credentials to check.
+func NewChain(authenticators ...Authenticator) Authenticator {
+	return chainAuthenticator(authenticators)
+}
+
+func (c chainAuthenticator) Authenticate(ctx context.Context) (*Identity, error) {
+	for _, a := range c {
+		id, err := a.Authenticate(ctx)
+		if err != ErrMissingCredentials {
+			return id, err
+		}
+	}
+	return nil, ErrMissingCredentials
+}
+
+// UnaryServerInterceptor returns an interceptor that authenticates the caller of each User RPC,
+// authorizes the request with the policy, and then handles it with the caller's identity in the
+// context. RPCs of other services, e.g., health checks, are handled as is.
+func UnaryServerInterceptor(authn Authenticator, policy Policy) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		rq interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, userServicePrefix) {
+			return handler(ctx, rq)
+		}
+		id, err := authenticate(ctx, authn)
+		if err != nil {
+			return nil, err
+		}
+		if err := authorize(policy, id, info.FullMethod, rq); err != nil {
+			return nil, err
+		}
+		return handler(NewContext(ctx, id), rq)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor that authenticates the caller of each User
+// streaming RPC and authorizes each request it receives with the policy. RPCs of other services
+// are handled as is.
+func StreamServerInterceptor(authn Authenticator, policy Policy) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if !strings.HasPrefix(info.FullMethod, userServicePrefix) {
+			return handler(srv, ss)
+		}
+		id, err := authenticate(ss.Context(), authn)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authorizingStream{
+			ServerStream: ss,
+			ctx:          NewContext(ss.Context(), id),
+			id:           id,
+			fullMethod:   info.FullMethod,
+			policy:       policy,
+		})
+	}
+}
+
+// authorizingStream is a grpc.ServerStream that authorizes each request it receives.
+type authorizingStream struct {
+	grpc.ServerStream
+	ctx        context.Context
+	id         *Identity
+	fullMethod string
+	policy     Policy
+}
+
+func (s *authorizingStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authorizingStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return authorize(s.policy, s.id, s.fullMethod, m)
+}
+
+func authenticate(ctx context.Context, authn Authenticator) (*Identity, error) {
+	id, err := authn.Authenticate(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return id, nil
+}
+
+func authorize(policy Policy, id *Identity, fullMethod string, rq interface{}) error {
+	if err := policy.Authorize(id, fullMethod, rq); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
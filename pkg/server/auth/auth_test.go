@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errTest = errors.New("some test error")
+
+func TestIdentity_HasScope(t *testing.T) {
+	id := NewIdentity("some-service", ServiceScope, "")
+	assert.True(t, id.HasScope(ServiceScope))
+	assert.True(t, id.HasScope(AdminScope, ServiceScope))
+	assert.False(t, id.HasScope(AdminScope))
+	assert.False(t, id.HasScope(""))
+	assert.False(t, id.HasScope())
+}
+
+func TestFromContext(t *testing.T) {
+	id, ok := FromContext(context.Background())
+	assert.False(t, ok)
+	assert.Nil(t, id)
+
+	expected := NewIdentity("user 1")
+	id, ok = FromContext(NewContext(context.Background(), expected))
+	assert.True(t, ok)
+	assert.Equal(t, expected, id)
+}
+
+func TestChainAuthenticator_Authenticate(t *testing.T) {
+	user1, user2 := NewIdentity("user 1"), NewIdentity("user 2")
+	missing := &fixedAuthenticator{err: ErrMissingCredentials}
+	cases := map[string]struct {
+		authns      []Authenticator
+		expected    *Identity
+		expectedErr error
+	}{
+		"first": {
+			authns:   []Authenticator{&fixedAuthenticator{id: user1}, missing},
+			expected: user1,
+		},
+		"second": {
+			authns:   []Authenticator{missing, &fixedAuthenticator{id: user2}},
+			expected: user2,
+		},
+		"first invalid": {
+			authns: []Authenticator{
+				&fixedAuthenticator{err: ErrInvalidToken},
+				&fixedAuthenticator{id: user2},
+			},
+			expectedErr: ErrInvalidToken,
+		},
+		"none": {
+			authns:      []Authenticator{missing, missing},
+			expectedErr: ErrMissingCredentials,
+		},
+	}
+	for desc, c := range cases {
+		id, err := NewChain(c.authns...).Authenticate(context.Background())
+		assert.Equal(t, c.expectedErr, err, desc)
+		assert.Equal(t, c.expected, id, desc)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	user := NewIdentity("user 1")
+	cases := map[string]struct {
+		authn        Authenticator
+		fullMethod   string
+		rq           interface{}
+		expectedCode codes.Code
+		handled      bool
+	}{
+		"ok": {
+			authn:        &fixedAuthenticator{id: user},
+			fullMethod:   "/userapi.User/GetEntities",
+			rq:           &api.GetEntitiesRequest{UserId: "user 1"},
+			expectedCode: codes.OK,
+			handled:      true,
+		},
+		"other service": {
+			authn:        &fixedAuthenticator{err: ErrMissingCredentials},
+			fullMethod:   "/grpc.health.v1.Health/Check",
+			expectedCode: codes.OK,
+			handled:      true,
+		},
+		"unauthenticated": {
+			authn:        &fixedAuthenticator{err: ErrMissingCredentials},
+			fullMethod:   "/userapi.User/GetEntities",
+			rq:           &api.GetEntitiesRequest{UserId: "user 1"},
+			expectedCode: codes.Unauthenticated,
+		},
+		"denied": {
+			authn:        &fixedAuthenticator{id: user},
+			fullMethod:   "/userapi.User/GetEntities",
+			rq:           &api.GetEntitiesRequest{UserId: "user 2"},
+			expectedCode: codes.PermissionDenied,
+		},
+	}
+	for desc, c := range cases {
+		interceptor := UnaryServerInterceptor(c.authn, NewDefaultPolicy())
+		handled := false
+		handler := func(ctx context.Context, rq interface{}) (interface{}, error) {
+			handled = true
+			if id, ok := FromContext(ctx); ok {
+				assert.Equal(t, user, id, desc)
+			}
+			return rq, nil
+		}
+		info := &grpc.UnaryServerInfo{FullMethod: c.fullMethod}
+		rp, err := interceptor(context.Background(), c.rq, info, handler)
+		assert.Equal(t, c.expectedCode, status.Code(err), desc)
+		assert.Equal(t, c.handled, handled, desc)
+		if c.handled {
+			assert.Equal(t, c.rq, rp, desc)
+		}
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	user := NewIdentity("user 1")
+	info := &grpc.StreamServerInfo{FullMethod: "/userapi.User/WatchEntities"}
+	recv := func(ss grpc.ServerStream) error {
+		id, ok := FromContext(ss.Context())
+		assert.True(t, ok)
+		assert.Equal(t, user, id)
+		return ss.RecvMsg(&api.WatchEntitiesRequest{})
+	}
+
+	// own entities
+	interceptor := StreamServerInterceptor(&fixedAuthenticator{id: user}, NewDefaultPolicy())
+	ss := &fixedServerStream{ctx: context.Background(), userID: "user 1"}
+	err := interceptor(nil, ss, info, func(srv interface{}, ss grpc.ServerStream) error {
+		return recv(ss)
+	})
+	assert.Nil(t, err)
+
+	// other user's entities
+	ss.userID = "user 2"
+	err = interceptor(nil, ss, info, func(srv interface{}, ss grpc.ServerStream) error {
+		return recv(ss)
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// receive error
+	ss.recvErr = errTest
+	err = interceptor(nil, ss, info, func(srv interface{}, ss grpc.ServerStream) error {
+		return recv(ss)
+	})
+	assert.Equal(t, errTest, err)
+
+	// unauthenticated
+	interceptor = StreamServerInterceptor(&fixedAuthenticator{err: ErrInvalidToken},
+		NewDefaultPolicy())
+	err = interceptor(nil, ss, info, func(srv interface{}, ss grpc.ServerStream) error {
+		assert.Fail(t, "unauthenticated stream handled")
+		return nil
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+type fixedAuthenticator struct {
+	id  *Identity
+	err error
+}
+
+func (f *fixedAuthenticator) Authenticate(ctx context.Context) (*Identity, error) {
+	return f.id, f.err
+}
+
+type fixedServerStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	userID  string
+	recvErr error
+}
+
+func (f *fixedServerStream) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fixedServerStream) RecvMsg(m interface{}) error {
+	if f.recvErr != nil {
+		return f.recvErr
+	}
+	m.(*api.WatchEntitiesRequest).UserId = f.userID
+	return nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// authorizationKey is the metadata key of the bearer token.
+	authorizationKey = "authorization"
+
+	bearerPrefix = "bearer "
+
+	// clockLeeway is how much the token times may differ from the local clock.
+	clockLeeway = time.Minute
+)
+
+var (
+	// ErrEmptyJWKS indicates when a JWKS file has no keys to verify bearer tokens with.
+	ErrEmptyJWKS = errors.New("JWKS has no keys")
+
+	// ErrMissingExpiry indicates when a bearer token doesn't expire.
+	ErrMissingExpiry = errors.New("bearer token has no expiry")
+)
+
+// tokenClaims are the claims of a bearer token used to identify its caller, where the scope is a
+// space-separated list of scopes as in OAuth 2.0.
+type tokenClaims struct {
+	jwt.Claims
+	Scope string `json:"scope,omitempty"`
+}
+
+type jwtAuthenticator struct {
+	keys     *jose.JSONWebKeySet
+	expected jwt.Expected
+	now      func() time.Time
+}
+
+// NewJWT returns an Authenticator that identifies callers by the subject and scope claims of
+// their bearer tokens, which must be signed by one of the keys in the given local JWKS file and
+// have the given issuer and audience, if they're non-empty.
+func NewJWT(jwksFile, issuer, audience string) (Authenticator, error) {
+	jwksJSON, err := ioutil.ReadFile(jwksFile)
+	if err != nil {
+		return nil, err
+	}
+	keys := &jose.JSONWebKeySet{}
+	if err := json.Unmarshal(jwksJSON, keys); err != nil {
+		return nil, err
+	}
+	if len(keys.Keys) == 0 {
+		return nil, ErrEmptyJWKS
+	}
+	expected := jwt.Expected{Issuer: issuer}
+	if audience != "" {
+		expected.Audience = jwt.Audience{audience}
+	}
+	return &jwtAuthenticator{
+		keys:     keys,
+		expected: expected,
+		now:      time.Now,
+	}, nil
+}
+
+func (a *jwtAuthenticator) Authenticate(ctx context.Context) (*Identity, error) {
+	token, err := bearerToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+	claims := &tokenClaims{}
+	if err := parsed.Claims(a.keys, claims); err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+	if claims.Expiry == nil {
+		return nil, errors.Wrap(ErrInvalidToken, ErrMissingExpiry.Error())
+	}
+	if err := claims.ValidateWithLeeway(a.expected.WithTime(a.now()), clockLeeway); err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+	if claims.Subject == "" {
+		return nil, errors.Wrap(ErrInvalidToken, "empty subject")
+	}
+	return NewIdentity(claims.Subject, strings.Fields(claims.Scope)...), nil
+}
+
+// bearerToken returns the bearer token in the authorization metadata of the context.
+func bearerToken(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md[authorizationKey]
+	if len(values) == 0 {
+		return "", ErrMissingCredentials
+	}
+	if !strings.HasPrefix(strings.ToLower(values[0]), bearerPrefix) {
+		return "", errors.Wrap(ErrInvalidToken, "authorization is not a bearer token")
+	}
+	return strings.TrimSpace(values[0][len(bearerPrefix):]), nil
+}
@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	testKeyID    = "key 1"
+	testIssuer   = "some issuer"
+	testAudience = "some audience"
+)
+
+func TestNewJWT_err(t *testing.T) {
+	emptyJWKSFile := writeTestJWKS(t, &jose.JSONWebKeySet{})
+	defer os.Remove(emptyJWKSFile)
+	invalidJWKSFile := writeTestFile(t, []byte("not JSON"))
+	defer os.Remove(invalidJWKSFile)
+
+	cases := map[string]struct {
+		jwksFile    string
+		expectedErr error
+	}{
+		"missing file": {jwksFile: "/nonexistent/jwks.json"},
+		"invalid JWKS": {jwksFile: invalidJWKSFile},
+		"empty JWKS": {
+			jwksFile:    emptyJWKSFile,
+			expectedErr: ErrEmptyJWKS,
+		},
+	}
+	for desc, c := range cases {
+		a, err := NewJWT(c.jwksFile, testIssuer, testAudience)
+		assert.NotNil(t, err, desc)
+		if c.expectedErr != nil {
+			assert.Equal(t, c.expectedErr, err, desc)
+		}
+		assert.Nil(t, a, desc)
+	}
+}
+
+func TestJWTAuthenticator_Authenticate_ok(t *testing.T) {
+	key := newTestKey(t)
+	a := newTestJWTAuthenticator(t, key)
+	claims := newTestClaims()
+	claims.Scope = ServiceScope + " other.scope"
+
+	id, err := a.Authenticate(withBearerToken(signTestToken(t, key, testKeyID, claims)))
+	assert.Nil(t, err)
+	assert.Equal(t, "user 1", id.Subject)
+	assert.True(t, id.HasScope(ServiceScope))
+	assert.True(t, id.HasScope("other.scope"))
+	assert.False(t, id.HasScope(AdminScope))
+}
+
+func TestJWTAuthenticator_Authenticate_err(t *testing.T) {
+	key, otherKey := newTestKey(t), newTestKey(t)
+	a := newTestJWTAuthenticator(t, key)
+	now := time.Now()
+
+	cases := map[string]struct {
+		ctx         func() context.Context
+		expectedErr error
+	}{
+		"no token": {
+			ctx:         context.Background,
+			expectedErr: ErrMissingCredentials,
+		},
+		"not bearer": {
+			ctx: func() context.Context {
+				return metadata.NewIncomingContext(context.Background(),
+					metadata.Pairs(authorizationKey, "Basic dXNlcjpwYXNz"))
+			},
+			expectedErr: ErrInvalidToken,
+		},
+		"malformed": {
+			ctx:         func() context.Context { return withBearerToken("not a JWT") },
+			expectedErr: ErrInvalidToken,
+		},
+		"unknown key": {
+			ctx: func() context.Context {
+				return withBearerToken(signTestToken(t, otherKey, "key 2", newTestClaims()))
+			},
+			expectedErr: ErrInvalidToken,
+		},
+		"wrong key": {
+			ctx: func() context.Context {
+				return withBearerToken(signTestToken(t, otherKey, testKeyID, newTestClaims()))
+			},
+			expectedErr: ErrInvalidToken,
+		},
+		"expired": {
+			ctx: func() context.Context {
+				claims := newTestClaims()
+				claims.Expiry = jwt.NewNumericDate(now.Add(-2 * clockLeeway))
+				return withBearerToken(signTestToken(t, key, testKeyID, claims))
+			},
+			expectedErr: ErrInvalidToken,
+		},
+		"no expiry": {
+			ctx: func() context.Context {
+				claims := newTestClaims()
+				claims.Expiry = nil
+				return withBearerToken(signTestToken(t, key, testKeyID, claims))
+			},
+			expectedErr: ErrInvalidToken,
+		},
+		"wrong issuer": {
+			ctx: func() context.Context {
+				claims := newTestClaims()
+				claims.Issuer = "other issuer"
+				return withBearerToken(signTestToken(t, key, testKeyID, claims))
+			},
+			expectedErr: ErrInvalidToken,
+		},
+		"wrong audience": {
+			ctx: func() context.Context {
+				claims := newTestClaims()
+				claims.Audience = jwt.Audience{"other audience"}
+				return withBearerToken(signTestToken(t, key, testKeyID, claims))
+			},
+			expectedErr: ErrInvalidToken,
+		},
+		"no subject": {
+			ctx: func() context.Context {
+				claims := newTestClaims()
+				claims.Subject = ""
+				return withBearerToken(signTestToken(t, key, testKeyID, claims))
+			},
+			expectedErr: ErrInvalidToken,
+		},
+	}
+	for desc, c := range cases {
+		id, err := a.Authenticate(c.ctx())
+		assert.Equal(t, c.expectedErr, errors.Cause(err), desc)
+		assert.Nil(t, id, desc)
+	}
+}
+
+func newTestJWTAuthenticator(t *testing.T, key *ecdsa.PrivateKey) Authenticator {
+	jwksFile := writeTestJWKS(t, &jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: testKeyID, Algorithm: string(jose.ES256), Use: "sig"},
+		},
+	})
+	defer os.Remove(jwksFile)
+	a, err := NewJWT(jwksFile, testIssuer, testAudience)
+	assert.Nil(t, err)
+	return a
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	return key
+}
+
+func newTestClaims() *tokenClaims {
+	now := time.Now()
+	return &tokenClaims{
+		Claims: jwt.Claims{
+			Subject:  "user 1",
+			Issuer:   testIssuer,
+			Audience: jwt.Audience{testAudience},
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	}
+}
+
+func signTestToken(t *testing.T, key *ecdsa.PrivateKey, keyID string, claims *tokenClaims) string {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: jose.JSONWebKey{Key: key, KeyID: keyID}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	assert.Nil(t, err)
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	assert.Nil(t, err)
+	return token
+}
+
+func withBearerToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(authorizationKey, "Bearer "+token))
+}
+
+func writeTestJWKS(t *testing.T, keys *jose.JSONWebKeySet) string {
+	jwksJSON, err := json.Marshal(keys)
+	assert.Nil(t, err)
+	return writeTestFile(t, jwksJSON)
+}
+
+func writeTestFile(t *testing.T, content []byte) string {
+	f, err := ioutil.TempFile("", "jwks")
+	assert.Nil(t, err)
+	_, err = f.Write(content)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	return f.Name()
+}
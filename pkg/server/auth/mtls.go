@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// ErrEmptyCommonName indicates when a verified client certificate has no common name to identify
+// its caller by.
+var ErrEmptyCommonName = errors.New("client certificate has no common name")
+
+type mtlsAuthenticator struct{}
+
+// NewMTLS returns an Authenticator that identifies callers by the client certificates verified
+// when the server requires mutual TLS. The certificate's common name is the caller's subject,
+// and its organizational units are the caller's scopes.
+func NewMTLS() Authenticator {
+	return mtlsAuthenticator{}
+}
+
+func (mtlsAuthenticator) Authenticate(ctx context.Context) (*Identity, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, ErrMissingCredentials
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 {
+		return nil, ErrMissingCredentials
+	}
+	cert := tlsInfo.State.VerifiedChains[0][0]
+	if cert.Subject.CommonName == "" {
+		return nil, ErrEmptyCommonName
+	}
+	return NewIdentity(cert.Subject.CommonName, cert.Subject.OrganizationalUnit...), nil
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func TestMTLSAuthenticator_Authenticate(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10100}
+	cases := map[string]struct {
+		ctx             context.Context
+		expectedSubject string
+		expectedService bool
+		expectedErr     error
+	}{
+		"verified cert": {
+			ctx: withPeerCert(&pkix.Name{
+				CommonName:         "some-service",
+				OrganizationalUnit: []string{ServiceScope},
+			}),
+			expectedSubject: "some-service",
+			expectedService: true,
+		},
+		"no peer": {
+			ctx:         context.Background(),
+			expectedErr: ErrMissingCredentials,
+		},
+		"no TLS": {
+			ctx:         peer.NewContext(context.Background(), &peer.Peer{Addr: addr}),
+			expectedErr: ErrMissingCredentials,
+		},
+		"unverified cert": {
+			ctx:         withPeerCert(nil),
+			expectedErr: ErrMissingCredentials,
+		},
+		"no common name": {
+			ctx:         withPeerCert(&pkix.Name{OrganizationalUnit: []string{ServiceScope}}),
+			expectedErr: ErrEmptyCommonName,
+		},
+	}
+	for desc, c := range cases {
+		id, err := NewMTLS().Authenticate(c.ctx)
+		assert.Equal(t, c.expectedErr, err, desc)
+		if c.expectedErr != nil {
+			assert.Nil(t, id, desc)
+			continue
+		}
+		assert.Equal(t, c.expectedSubject, id.Subject, desc)
+		assert.Equal(t, c.expectedService, id.HasScope(ServiceScope), desc)
+	}
+}
+
+// withPeerCert returns a context whose peer has a verified TLS client certificate with the given
+// subject, or none if it's nil.
+func withPeerCert(subject *pkix.Name) context.Context {
+	state := tls.ConnectionState{}
+	if subject != nil {
+		cert := &x509.Certificate{Subject: *subject}
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10100},
+		AuthInfo: credentials.TLSInfo{State: state},
+	})
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	api "github.com/elixirhealth/user/pkg/userapi"
+)
+
+// Policy authorizes the requests of authenticated callers.
+type Policy interface {
+	// Authorize returns ErrPermissionDenied if the caller with the given identity may not make
+	// the request to the RPC with the given full method name.
+	Authorize(id *Identity, fullMethod string, rq interface{}) error
+}
+
+// Rule returns whether the caller with the given identity may make the request.
+type Rule func(id *Identity, rq interface{}) bool
+
+// rulesPolicy is a Policy with a rule for each RPC, which denies requests to RPCs without one.
+type rulesPolicy map[string]Rule
+
+// NewPolicy returns a Policy authorizing the requests to each RPC, given by full method name, with
+// its rule. Requests to RPCs without a rule are denied.
+func NewPolicy(rules map[string]Rule) Policy {
+	return rulesPolicy(rules)
+}
+
+// NewDefaultPolicy returns the default Policy, under which callers may read their own
+// associations, history, and profile and write their own profile. Callers with the service scope
+// may also read and change the associations and profiles of any user or entity, and those with
+// the admin scope may also change the user and entity limits and export and import all of the
+// associations.
+func NewDefaultPolicy() Policy {
+	return NewPolicy(map[string]Rule{
+		userServicePrefix + "AddEntity":             Privileged,
+		userServicePrefix + "AddEntities":           Privileged,
+		userServicePrefix + "RemoveEntity":          Privileged,
+		userServicePrefix + "UpdateRole":            Privileged,
+		userServicePrefix + "GetEntities":           SelfOrPrivileged,
+		userServicePrefix + "GetEntitiesBatch":      SelfOrPrivileged,
+		userServicePrefix + "GetUsers":              Privileged,
+		userServicePrefix + "GetAssociationHistory": SelfOrPrivileged,
+		userServicePrefix + "WatchEntities":         SelfOrPrivileged,
+		userServicePrefix + "SetUserLimit":          Admin,
+		userServicePrefix + "SetEntityLimit":        Admin,
+		userServicePrefix + "PutUser":               SelfOrPrivileged,
+		userServicePrefix + "GetUser":               SelfOrPrivileged,
+		userServicePrefix + "UpdateUser":            SelfOrPrivileged,
+		userServicePrefix + "ExportAssociations":    Admin,
+		userServicePrefix + "ImportAssociations":    Admin,
+	})
+}
+
+func (p rulesPolicy) Authorize(id *Identity, fullMethod string, rq interface{}) error {
+	if rule, in := p[fullMethod]; in && rule(id, rq) {
+		return nil
+	}
+	return ErrPermissionDenied
+}
+
+// Admin permits callers with the admin scope.
+func Admin(id *Identity, rq interface{}) bool {
+	return id.HasScope(AdminScope)
+}
+
+// Privileged permits callers with the service or admin scope.
+func Privileged(id *Identity, rq interface{}) bool {
+	return id.HasScope(ServiceScope, AdminScope)
+}
+
+// SelfOrPrivileged permits callers whose requests only concern their own user ID as well as those
+// with the service or admin scope.
+func SelfOrPrivileged(id *Identity, rq interface{}) bool {
+	if Privileged(id, rq) {
+		return true
+	}
+	userIDs := requestUserIDs(rq)
+	if len(userIDs) == 0 {
+		return false
+	}
+	for _, userID := range userIDs {
+		if userID != id.Subject {
+			return false
+		}
+	}
+	return true
+}
+
+// requestUserIDs returns the user IDs the request concerns, or none if it doesn't concern
+// particular users, e.g., when it's for the history of all of an entity's associations.
+func requestUserIDs(rq interface{}) []string {
+	switch rq := rq.(type) {
+	case *api.GetEntitiesBatchRequest:
+		return rq.UserIds
+	case *api.PutUserRequest:
+		return []string{rq.GetUser().GetUserId()}
+	case *api.UpdateUserRequest:
+		return []string{rq.GetUser().GetUserId()}
+	}
+	if rq, ok := rq.(interface{ GetUserId() string }); ok && rq.GetUserId() != "" {
+		return []string{rq.GetUserId()}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"testing"
+
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultPolicy_Authorize(t *testing.T) {
+	user := NewIdentity("user 1")
+	service := NewIdentity("some-service", ServiceScope)
+	admin := NewIdentity("some-admin", AdminScope)
+	cases := map[string]struct {
+		id         *Identity
+		fullMethod string
+		rq         interface{}
+		permitted  bool
+	}{
+		"own entities": {
+			id:         user,
+			fullMethod: "/userapi.User/GetEntities",
+			rq:         &api.GetEntitiesRequest{UserId: "user 1"},
+			permitted:  true,
+		},
+		"other user's entities": {
+			id:         user,
+			fullMethod: "/userapi.User/GetEntities",
+			rq:         &api.GetEntitiesRequest{UserId: "user 2"},
+		},
+		"service other user's entities": {
+			id:         service,
+			fullMethod: "/userapi.User/GetEntities",
+			rq:         &api.GetEntitiesRequest{UserId: "user 2"},
+			permitted:  true,
+		},
+		"own entities batch": {
+			id:         user,
+			fullMethod: "/userapi.User/GetEntitiesBatch",
+			rq:         &api.GetEntitiesBatchRequest{UserIds: []string{"user 1", "user 1"}},
+			permitted:  true,
+		},
+		"batch with other user": {
+			id:         user,
+			fullMethod: "/userapi.User/GetEntitiesBatch",
+			rq:         &api.GetEntitiesBatchRequest{UserIds: []string{"user 1", "user 2"}},
+		},
+		"own association history": {
+			id:         user,
+			fullMethod: "/userapi.User/GetAssociationHistory",
+			rq: &api.GetAssociationHistoryRequest{
+				UserId:   "user 1",
+				EntityId: "entity 1",
+			},
+			permitted: true,
+		},
+		"entity history": {
+			id:         user,
+			fullMethod: "/userapi.User/GetAssociationHistory",
+			rq:         &api.GetAssociationHistoryRequest{EntityId: "entity 1"},
+		},
+		"watch own entities": {
+			id:         user,
+			fullMethod: "/userapi.User/WatchEntities",
+			rq:         &api.WatchEntitiesRequest{UserId: "user 1"},
+			permitted:  true,
+		},
+		"entity users": {
+			id:         user,
+			fullMethod: "/userapi.User/GetUsers",
+			rq:         &api.GetUsersRequest{EntityId: "entity 1"},
+		},
+		"add own entity": {
+			id:         user,
+			fullMethod: "/userapi.User/AddEntity",
+			rq:         &api.AddEntityRequest{UserId: "user 1", EntityId: "entity 1"},
+		},
+		"service add entity": {
+			id:         service,
+			fullMethod: "/userapi.User/AddEntity",
+			rq:         &api.AddEntityRequest{UserId: "user 1", EntityId: "entity 1"},
+			permitted:  true,
+		},
+		"admin add entity": {
+			id:         admin,
+			fullMethod: "/userapi.User/AddEntity",
+			rq:         &api.AddEntityRequest{UserId: "user 1", EntityId: "entity 1"},
+			permitted:  true,
+		},
+		"service set limit": {
+			id:         service,
+			fullMethod: "/userapi.User/SetUserLimit",
+			rq:         &api.SetUserLimitRequest{UserId: "user 1", MaxEntities: 64},
+		},
+		"admin set limit": {
+			id:         admin,
+			fullMethod: "/userapi.User/SetEntityLimit",
+			rq:         &api.SetEntityLimitRequest{EntityId: "entity 1", MaxUsers: 64},
+			permitted:  true,
+		},
+		"service export": {
+			id:         service,
+			fullMethod: "/userapi.User/ExportAssociations",
+			rq:         &api.ExportAssociationsRequest{},
+		},
+		"admin import": {
+			id:         admin,
+			fullMethod: "/userapi.User/ImportAssociations",
+			rq:         &api.ImportAssociationsRequest{},
+			permitted:  true,
+		},
+		"update own profile": {
+			id:         user,
+			fullMethod: "/userapi.User/UpdateUser",
+			rq: &api.UpdateUserRequest{
+				User: &api.UserProfile{UserId: "user 1"},
+			},
+			permitted: true,
+		},
+		"put other profile": {
+			id:         user,
+			fullMethod: "/userapi.User/PutUser",
+			rq: &api.PutUserRequest{
+				User: &api.UserProfile{UserId: "user 2"},
+			},
+		},
+		"put empty profile": {
+			id:         user,
+			fullMethod: "/userapi.User/PutUser",
+			rq:         &api.PutUserRequest{},
+		},
+		"unknown method": {
+			id:         admin,
+			fullMethod: "/userapi.User/DeleteEverything",
+			rq:         &api.GetUserRequest{UserId: "user 1"},
+		},
+	}
+	p := NewDefaultPolicy()
+	for desc, c := range cases {
+		err := p.Authorize(c.id, c.fullMethod, c.rq)
+		if c.permitted {
+			assert.Nil(t, err, desc)
+		} else {
+			assert.Equal(t, ErrPermissionDenied, err, desc)
+		}
+	}
+}
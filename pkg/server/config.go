@@ -7,12 +7,64 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
+const (
+	// DefaultMaxUserEntities is the default maximum number of entities a user can be associated
+	// with.
+	DefaultMaxUserEntities = uint(16)
+
+	// DefaultMaxEntityUsers is the default maximum number of users that can be associated with a
+	// single entity.
+	DefaultMaxEntityUsers = uint(256)
+
+	// DatastoreEmulatorHostEnv is the env var giving the host:port of a DataStore emulator, which
+	// the DataStore client uses instead of GCP DataStore when set.
+	DatastoreEmulatorHostEnv = "DATASTORE_EMULATOR_HOST"
+)
+
 // Config is the config for a User instance.
 type Config struct {
 	*server.BaseConfig
 	Storage      *storage.Parameters
 	GCPProjectID string
 	DBUrl        string
+
+	// DatastoreEmulatorHost is the host:port of a DataStore emulator to use instead of GCP
+	// DataStore, if set.
+	DatastoreEmulatorHost string
+
+	// DataDir is the directory of the local storage's database files.
+	DataDir string
+
+	// MaxUserEntities is the maximum number of entities a user can be associated with, unless
+	// overridden for that user.
+	MaxUserEntities uint
+
+	// MaxEntityUsers is the maximum number of users that can be associated with a single
+	// entity, unless overridden for that entity.
+	MaxEntityUsers uint
+
+	// AuthJWKSFile is the path of a local JWKS file with the keys that sign callers' bearer
+	// tokens. If set, callers may authenticate with bearer tokens.
+	AuthJWKSFile string
+
+	// AuthJWTIssuer is the issuer bearer tokens must have, if set.
+	AuthJWTIssuer string
+
+	// AuthJWTAudience is the audience bearer tokens must have, if set.
+	AuthJWTAudience string
+
+	// AuthMTLS is whether callers may authenticate with verified TLS client certificates. When
+	// it or AuthJWKSFile is set, every request must be authenticated and authorized.
+	AuthMTLS bool
+
+	// TLSCertFile and TLSKeyFile are the PEM certificate and key files the server uses for TLS,
+	// if set. They're reloaded when they change.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// TLSClientCAFile is the PEM file of the CA certificates that must sign clients'
+	// certificates, if set, in which case clients must use mutual TLS.
+	TLSClientCAFile string
 }
 
 // NewDefaultConfig create a new config instance with default values.
@@ -21,7 +73,9 @@ func NewDefaultConfig() *Config {
 		BaseConfig: server.NewDefaultBaseConfig(),
 	}
 	return config.
-		WithDefaultStorage()
+		WithDefaultStorage().
+		WithDefaultMaxUserEntities().
+		WithDefaultMaxEntityUsers()
 }
 
 // MarshalLogObject writes the config to the given object encoder.
@@ -30,6 +84,15 @@ func (c *Config) MarshalLogObject(oe zapcore.ObjectEncoder) error {
 	errors.MaybePanic(err) // should never happen
 	err = oe.AddObject(logStorage, c.Storage)
 	errors.MaybePanic(err) // should never happen
+	oe.AddUint(logMaxUserEntities, c.MaxUserEntities)
+	oe.AddUint(logMaxEntityUsers, c.MaxEntityUsers)
+	oe.AddString(logAuthJWKSFile, c.AuthJWKSFile)
+	oe.AddString(logAuthJWTIssuer, c.AuthJWTIssuer)
+	oe.AddString(logAuthJWTAudience, c.AuthJWTAudience)
+	oe.AddBool(logAuthMTLS, c.AuthMTLS)
+	oe.AddString(logTLSCertFile, c.TLSCertFile)
+	oe.AddString(logTLSKeyFile, c.TLSKeyFile)
+	oe.AddString(logTLSClientCAFile, c.TLSClientCAFile)
 	return nil
 }
 
@@ -54,8 +117,96 @@ func (c *Config) WithGCPProjectID(id string) *Config {
 	return c
 }
 
+// WithDatastoreEmulatorHost sets the DataStore emulator host to the given value.
+func (c *Config) WithDatastoreEmulatorHost(host string) *Config {
+	c.DatastoreEmulatorHost = host
+	return c
+}
+
+// WithDataDir sets the local storage data directory to the given value.
+func (c *Config) WithDataDir(dataDir string) *Config {
+	c.DataDir = dataDir
+	return c
+}
+
 // WithDBUrl sets the DB URL to the given value.
 func (c *Config) WithDBUrl(dbURL string) *Config {
 	c.DBUrl = dbURL
 	return c
 }
+
+// WithMaxUserEntities sets the max number of entities per user to the given value or the default
+// if it is zero.
+func (c *Config) WithMaxUserEntities(max uint) *Config {
+	if max == 0 {
+		return c.WithDefaultMaxUserEntities()
+	}
+	c.MaxUserEntities = max
+	return c
+}
+
+// WithDefaultMaxUserEntities sets the max number of entities per user to the default value.
+func (c *Config) WithDefaultMaxUserEntities() *Config {
+	c.MaxUserEntities = DefaultMaxUserEntities
+	return c
+}
+
+// WithMaxEntityUsers sets the max number of users per entity to the given value or the default
+// if it is zero.
+func (c *Config) WithMaxEntityUsers(max uint) *Config {
+	if max == 0 {
+		return c.WithDefaultMaxEntityUsers()
+	}
+	c.MaxEntityUsers = max
+	return c
+}
+
+// WithDefaultMaxEntityUsers sets the max number of users per entity to the default value.
+func (c *Config) WithDefaultMaxEntityUsers() *Config {
+	c.MaxEntityUsers = DefaultMaxEntityUsers
+	return c
+}
+
+// WithAuthJWKSFile sets the JWKS file of the keys signing bearer tokens to the given value.
+func (c *Config) WithAuthJWKSFile(jwksFile string) *Config {
+	c.AuthJWKSFile = jwksFile
+	return c
+}
+
+// WithAuthJWTIssuer sets the issuer bearer tokens must have to the given value.
+func (c *Config) WithAuthJWTIssuer(issuer string) *Config {
+	c.AuthJWTIssuer = issuer
+	return c
+}
+
+// WithAuthJWTAudience sets the audience bearer tokens must have to the given value.
+func (c *Config) WithAuthJWTAudience(audience string) *Config {
+	c.AuthJWTAudience = audience
+	return c
+}
+
+// WithAuthMTLS sets whether callers may authenticate with TLS client certificates.
+func (c *Config) WithAuthMTLS(mtls bool) *Config {
+	c.AuthMTLS = mtls
+	return c
+}
+
+// WithTLSKeyPair sets the certificate and key files the server uses for TLS to the given values.
+func (c *Config) WithTLSKeyPair(certFile, keyFile string) *Config {
+	c.TLSCertFile, c.TLSKeyFile = certFile, keyFile
+	return c
+}
+
+// WithTLSClientCAFile sets the file of the CA certificates that must sign clients' certificates
+// to the given value.
+func (c *Config) WithTLSClientCAFile(caFile string) *Config {
+	c.TLSClientCAFile = caFile
+	return c
+}
+
+func (c *Config) limits() *storage.Limits {
+	return &storage.Limits{
+		MaxUserEntities: int(c.MaxUserEntities),
+		MaxEntityUsers:  int(c.MaxEntityUsers),
+	}
+}
@@ -3,7 +3,6 @@ package server
 import (
 	"testing"
 
-	bstorage "github.com/elixirhealth/service-base/pkg/server/storage"
 	"github.com/elixirhealth/user/pkg/server/storage"
 	"github.com/stretchr/testify/assert"
 )
@@ -12,6 +11,8 @@ func TestNewDefaultConfig(t *testing.T) {
 	c := NewDefaultConfig()
 	assert.NotNil(t, c)
 	assert.NotNil(t, c.Storage)
+	assert.Equal(t, DefaultMaxUserEntities, c.MaxUserEntities)
+	assert.Equal(t, DefaultMaxEntityUsers, c.MaxEntityUsers)
 }
 
 func TestConfig_WithStorage(t *testing.T) {
@@ -21,7 +22,7 @@ func TestConfig_WithStorage(t *testing.T) {
 	assert.NotEqual(t,
 		c1.Storage.Type,
 		c3.WithStorage(
-			&storage.Parameters{Type: bstorage.DataStore},
+			&storage.Parameters{Type: storage.DataStore},
 		).Storage.Type,
 	)
 }
@@ -33,9 +34,59 @@ func TestConfig_WithGCPProjectID(t *testing.T) {
 	assert.Equal(t, p, c1.GCPProjectID)
 }
 
+func TestConfig_WithDatastoreEmulatorHost(t *testing.T) {
+	c1 := &Config{}
+	host := "localhost:8081"
+	c1.WithDatastoreEmulatorHost(host)
+	assert.Equal(t, host, c1.DatastoreEmulatorHost)
+}
+
+func TestConfig_WithDataDir(t *testing.T) {
+	c1 := &Config{}
+	dataDir := "/var/lib/user"
+	c1.WithDataDir(dataDir)
+	assert.Equal(t, dataDir, c1.DataDir)
+}
+
 func TestConfig_WithDBUrl(t *testing.T) {
 	c1 := &Config{}
 	dbURL := "some DB URL"
 	c1.WithDBUrl(dbURL)
 	assert.Equal(t, dbURL, c1.DBUrl)
 }
+
+func TestConfig_WithMaxUserEntities(t *testing.T) {
+	c1, c2, c3 := &Config{}, &Config{}, &Config{}
+	c1.WithDefaultMaxUserEntities()
+	assert.Equal(t, c1.MaxUserEntities, c2.WithMaxUserEntities(0).MaxUserEntities)
+	assert.NotEqual(t, c1.MaxUserEntities, c3.WithMaxUserEntities(64).MaxUserEntities)
+}
+
+func TestConfig_WithMaxEntityUsers(t *testing.T) {
+	c1, c2, c3 := &Config{}, &Config{}, &Config{}
+	c1.WithDefaultMaxEntityUsers()
+	assert.Equal(t, c1.MaxEntityUsers, c2.WithMaxEntityUsers(0).MaxEntityUsers)
+	assert.NotEqual(t, c1.MaxEntityUsers, c3.WithMaxEntityUsers(4096).MaxEntityUsers)
+}
+
+func TestConfig_WithAuth(t *testing.T) {
+	c1 := &Config{}
+	jwksFile, issuer, audience := "/etc/user/jwks.json", "some issuer", "some audience"
+	c1.WithAuthJWKSFile(jwksFile).
+		WithAuthJWTIssuer(issuer).
+		WithAuthJWTAudience(audience).
+		WithAuthMTLS(true)
+	assert.Equal(t, jwksFile, c1.AuthJWKSFile)
+	assert.Equal(t, issuer, c1.AuthJWTIssuer)
+	assert.Equal(t, audience, c1.AuthJWTAudience)
+	assert.True(t, c1.AuthMTLS)
+}
+
+func TestConfig_WithTLS(t *testing.T) {
+	c1 := &Config{}
+	certFile, keyFile, caFile := "server.pem", "server-key.pem", "client-ca.pem"
+	c1.WithTLSKeyPair(certFile, keyFile).WithTLSClientCAFile(caFile)
+	assert.Equal(t, certFile, c1.TLSCertFile)
+	assert.Equal(t, keyFile, c1.TLSKeyFile)
+	assert.Equal(t, caFile, c1.TLSClientCAFile)
+}
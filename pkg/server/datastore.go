@@ -0,0 +1,24 @@
+// +build !nogcp
+
+package server
+
+import (
+	"os"
+
+	"github.com/elixirhealth/user/pkg/server/storage"
+	"github.com/elixirhealth/user/pkg/server/storage/datastore"
+	"go.uber.org/zap"
+)
+
+// newDatastoreStorer creates the DataStore Storer, pointing its client at the emulator if one is
+// configured.
+func newDatastoreStorer(config *Config, logger *zap.Logger) (storage.Storer, error) {
+	if config.DatastoreEmulatorHost != "" {
+		// the DataStore client connects to the emulator when this env var is set
+		err := os.Setenv(DatastoreEmulatorHostEnv, config.DatastoreEmulatorHost)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return datastore.New(config.GCPProjectID, config.Storage, logger)
+}
@@ -0,0 +1,14 @@
+// +build nogcp
+
+package server
+
+import (
+	"github.com/elixirhealth/user/pkg/server/storage"
+	"go.uber.org/zap"
+)
+
+// newDatastoreStorer fails since builds with the nogcp tag leave out the DataStore storer and the
+// GCP client libraries it depends on.
+func newDatastoreStorer(config *Config, logger *zap.Logger) (storage.Storer, error) {
+	return nil, ErrDataStoreNotBuilt
+}
@@ -3,28 +3,165 @@ package server
 import (
 	"errors"
 
-	bstorage "github.com/elixirhealth/service-base/pkg/server/storage"
+	"github.com/elixirhealth/user/pkg/server/auth"
 	"github.com/elixirhealth/user/pkg/server/storage"
-	"github.com/elixirhealth/user/pkg/server/storage/datastore"
+	"github.com/elixirhealth/user/pkg/server/storage/local"
 	"github.com/elixirhealth/user/pkg/server/storage/memory"
 	"github.com/elixirhealth/user/pkg/server/storage/postgres"
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/golang/protobuf/ptypes"
 	"go.uber.org/zap"
 )
 
 var (
 	// ErrInvalidStorageType indicates when a storage type is not expected.
 	ErrInvalidStorageType = errors.New("invalid storage type")
+
+	// ErrDataStoreNotBuilt indicates when DataStore storage is configured in a build with the
+	// nogcp tag, which leaves it out.
+	ErrDataStoreNotBuilt = errors.New("DataStore storage not included in this build")
+
+	// ErrCacheWithoutChangeFeed indicates when caching is configured for a storage type whose
+	// backend may be shared with other servers but which has no change feed to see their
+	// changes.
+	ErrCacheWithoutChangeFeed = errors.New("caching requires a storage type with a change feed")
+
+	// ErrClientCAWithoutTLS indicates when a client CA file is configured without the server's
+	// TLS certificate and key.
+	ErrClientCAWithoutTLS = errors.New("client CA file requires TLS certificate and key files")
+
+	// ErrMTLSWithoutClientCA indicates when callers may authenticate with client certificates
+	// but no client CA file is configured to verify them.
+	ErrMTLSWithoutClientCA = errors.New("mTLS authentication requires a client CA file")
 )
 
+func toAPIEvent(e *storage.AssociationEvent) (*api.AssociationEvent, error) {
+	ts, err := ptypes.TimestampProto(e.Time)
+	if err != nil {
+		return nil, err
+	}
+	return &api.AssociationEvent{
+		Type:     e.Type,
+		Time:     ts,
+		UserId:   e.UserID,
+		EntityId: e.EntityID,
+		Role:     e.Role,
+	}, nil
+}
+
+// toAPIRecord converts the versions of a single association into an *api.AssociationRecord.
+func toAPIRecord(versions []*storage.AssociationVersion) (*api.AssociationRecord, error) {
+	r := &api.AssociationRecord{
+		UserId:   versions[0].UserID,
+		EntityId: versions[0].EntityID,
+		Versions: make([]*api.AssociationVersion, len(versions)),
+	}
+	for i, v := range versions {
+		added, err := ptypes.TimestampProto(v.AddedTime)
+		if err != nil {
+			return nil, err
+		}
+		r.Versions[i] = &api.AssociationVersion{
+			Role:        v.Role,
+			AddedTime:   added,
+			RoleUpdated: v.RoleUpdated,
+			Superseded:  v.Superseded,
+		}
+		if v.Removed {
+			if r.Versions[i].RemovedTime, err = ptypes.TimestampProto(v.RemovedTime); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return r, nil
+}
+
+// fromAPIRecord converts a validated *api.AssociationRecord into the versions of its association.
+func fromAPIRecord(r *api.AssociationRecord) ([]*storage.AssociationVersion, error) {
+	versions := make([]*storage.AssociationVersion, len(r.Versions))
+	for i, v := range r.Versions {
+		added, err := ptypes.Timestamp(v.AddedTime)
+		if err != nil {
+			return nil, err
+		}
+		versions[i] = &storage.AssociationVersion{
+			UserID:      r.UserId,
+			EntityID:    r.EntityId,
+			Role:        v.Role,
+			AddedTime:   added,
+			RoleUpdated: v.RoleUpdated,
+			Superseded:  v.Superseded,
+		}
+		if v.RemovedTime != nil {
+			versions[i].Removed = true
+			if versions[i].RemovedTime, err = ptypes.Timestamp(v.RemovedTime); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return versions, nil
+}
+
+// getStorer returns the instrumented Storer for the configured storage type, wrapped in a cache
+// if the cache size is non-zero. The cache follows the backend's change feed, if it has one, and
+// is refused for DataStore, whose backend is shared but has none.
 func getStorer(config *Config, logger *zap.Logger) (storage.Storer, error) {
+	if config.Storage.CacheSize != 0 && config.Storage.Type == storage.DataStore {
+		return nil, ErrCacheWithoutChangeFeed
+	}
+	backend, err := getBackendStorer(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	storer := storage.NewInstrumented(backend, config.Storage.Type)
+	if config.Storage.CacheSize == 0 {
+		return storer, nil
+	}
+	changes, _ := backend.(storage.ChangeFeed)
+	return storage.NewCaching(storer, config.Storage, changes), nil
+}
+
+func getBackendStorer(config *Config, logger *zap.Logger) (storage.Storer, error) {
 	switch config.Storage.Type {
-	case bstorage.Memory:
+	case storage.Memory:
 		return memory.New(config.Storage, logger), nil
-	case bstorage.Postgres:
+	case storage.Postgres:
 		return postgres.New(config.DBUrl, config.Storage, logger)
-	case bstorage.DataStore:
-		return datastore.New(config.GCPProjectID, config.Storage, logger)
+	case storage.DataStore:
+		return newDatastoreStorer(config, logger)
+	case storage.Local:
+		return local.New(config.DataDir, config.Storage, logger)
 	default:
 		return nil, ErrInvalidStorageType
 	}
 }
+
+// getAuthenticator returns the Authenticator for the configured bearer token keys and/or client
+// certificates, or nil if neither is configured.
+func getAuthenticator(config *Config) (auth.Authenticator, error) {
+	authenticators := make([]auth.Authenticator, 0, 2)
+	if config.AuthJWKSFile != "" {
+		jwtAuthn, err := auth.NewJWT(config.AuthJWKSFile, config.AuthJWTIssuer,
+			config.AuthJWTAudience)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, jwtAuthn)
+	}
+	if config.AuthMTLS {
+		authenticators = append(authenticators, auth.NewMTLS())
+	}
+	if len(authenticators) == 0 {
+		return nil, nil
+	}
+	return auth.NewChain(authenticators...), nil
+}
+
+// pageSize returns the number of items in a page given the requested size. Zero, when not given,
+// doesn't limit the page, so requests from before paging still get every item.
+func pageSize(requested uint32) int {
+	if requested > api.MaxPageSize {
+		return api.MaxPageSize
+	}
+	return int(requested)
+}
@@ -1,12 +1,28 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+
 	errors2 "github.com/drausin/libri/libri/common/errors"
-	bstorage "github.com/elixirhealth/service-base/pkg/server/storage"
-	"github.com/elixirhealth/user/pkg/server/storage/postgres/migrations"
+	"github.com/elixirhealth/user/pkg/certs"
+	"github.com/elixirhealth/user/pkg/server/auth"
+	"github.com/elixirhealth/user/pkg/server/storage"
+	"github.com/elixirhealth/user/pkg/server/storage/postgres"
 	api "github.com/elixirhealth/user/pkg/userapi"
-	bindata "github.com/mattes/migrate/source/go-bindata"
+	"github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// metricsNamespace is the namespace of the RPC metrics, shared with the storage metrics.
+	metricsNamespace = "user"
+
+	// backendLabel is the constant label of the RPC metrics giving the storage backend type,
+	// matching that of the storage metrics.
+	backendLabel = "backend"
 )
 
 // Start starts the server and eviction routines.
@@ -16,30 +32,171 @@ func Start(config *Config, up chan *User) error {
 		return err
 	}
 
+	opts, err := c.serverOptions()
+	if err != nil {
+		return err
+	}
 	if err := c.maybeMigrateDB(); err != nil {
 		return err
 	}
+	if err := c.registerMetrics(); err != nil {
+		return err
+	}
 
-	registerServer := func(s *grpc.Server) { api.RegisterUserServer(s, c) }
-	return c.Serve(registerServer, func() { up <- c })
+	registerServer := func(s *grpc.Server) {
+		api.RegisterUserServer(s, c)
+		c.rpcMetrics.InitializeMetrics(s)
+	}
+	return c.Serve(registerServer, func() { up <- c }, opts...)
 }
 
 // StopServer handles cleanup involved in closing down the server.
 func (u *User) StopServer() {
 	u.BaseServer.StopServer()
+	u.unregisterMetrics()
+	if u.certs != nil {
+		errors2.MaybePanic(u.certs.Close())
+	}
 	err := u.storer.Close()
 	errors2.MaybePanic(err)
 }
 
+// serverOptions returns the gRPC server options with the TLS credentials, if TLS is configured,
+// and the interceptors that record the metrics of each request and then authenticate and
+// authorize it, if authentication is configured.
+func (u *User) serverOptions() ([]grpc.ServerOption, error) {
+	opts := make([]grpc.ServerOption, 0, 3)
+	tlsConfig, err := u.getTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	authn, err := getAuthenticator(u.config)
+	if err != nil {
+		return nil, err
+	}
+	var unary grpc.UnaryServerInterceptor = u.rpcMetrics.UnaryServerInterceptor()
+	var stream grpc.StreamServerInterceptor = u.rpcMetrics.StreamServerInterceptor()
+	if authn == nil {
+		u.Logger.Warn("no authentication configured, so requests will not be authorized")
+	} else {
+		policy := auth.NewDefaultPolicy()
+		unary = chainUnary(unary, auth.UnaryServerInterceptor(authn, policy))
+		stream = chainStream(stream, auth.StreamServerInterceptor(authn, policy))
+	}
+	return append(opts, grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream)), nil
+}
+
+// chainUnary returns the unary interceptor that calls the outer interceptor with a handler
+// calling the inner one.
+func chainUnary(outer, inner grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		rq interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		return outer(ctx, rq, info, func(ctx context.Context, rq interface{}) (interface{}, error) {
+			return inner(ctx, rq, info, handler)
+		})
+	}
+}
+
+// chainStream returns the stream interceptor that calls the outer interceptor with a handler
+// calling the inner one.
+func chainStream(outer, inner grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		return outer(srv, ss, info, func(srv interface{}, ss grpc.ServerStream) error {
+			return inner(srv, ss, info, handler)
+		})
+	}
+}
+
+// newRPCMetrics returns the metrics of the started and handled gRPC calls by method and code,
+// along with their latencies, labelled with the storage backend type like the storage metrics.
+func newRPCMetrics(backend storage.Type) *grpc_prometheus.ServerMetrics {
+	backendLabels := prometheus.Labels{backendLabel: backend.String()}
+	m := grpc_prometheus.NewServerMetrics(func(opts *prometheus.CounterOpts) {
+		opts.Namespace = metricsNamespace
+		opts.ConstLabels = backendLabels
+	})
+	m.EnableHandlingTimeHistogram(func(opts *prometheus.HistogramOpts) {
+		opts.Namespace = metricsNamespace
+		opts.ConstLabels = backendLabels
+	})
+	return m
+}
+
+// getTLSConfig returns the server's TLS config, whose certificates are reloaded when their files
+// change, or nil if TLS isn't configured.
+func (u *User) getTLSConfig() (*tls.Config, error) {
+	if u.config.TLSCertFile == "" && u.config.TLSKeyFile == "" {
+		if u.config.TLSClientCAFile != "" {
+			return nil, ErrClientCAWithoutTLS
+		}
+		if u.config.AuthMTLS {
+			return nil, ErrMTLSWithoutClientCA
+		}
+		return nil, nil
+	}
+	if u.config.AuthMTLS && u.config.TLSClientCAFile == "" {
+		return nil, ErrMTLSWithoutClientCA
+	}
+	reloader, err := certs.NewReloader(u.config.TLSCertFile, u.config.TLSKeyFile,
+		u.config.TLSClientCAFile, u.Logger)
+	if err != nil {
+		return nil, err
+	}
+	u.certs = reloader
+	return certs.NewServerTLSConfig(reloader)
+}
+
+// registerMetrics registers the RPC metrics and the storer's metrics, if it has any, so they're
+// served on the metrics port. Metrics already registered by another server in the same process
+// are left as they are.
+func (u *User) registerMetrics() error {
+	for _, collector := range u.collectors() {
+		err := prometheus.Register(collector)
+		if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			u.Logger.Info("metrics already registered")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *User) unregisterMetrics() {
+	for _, collector := range u.collectors() {
+		prometheus.Unregister(collector)
+	}
+}
+
+func (u *User) collectors() []prometheus.Collector {
+	collectors := make([]prometheus.Collector, 0, 2)
+	if u.rpcMetrics != nil {
+		collectors = append(collectors, u.rpcMetrics)
+	}
+	if collector, ok := u.storer.(prometheus.Collector); ok {
+		collectors = append(collectors, collector)
+	}
+	return collectors
+}
+
 func (u *User) maybeMigrateDB() error {
-	if u.config.Storage.Type != bstorage.Postgres {
+	if u.config.Storage.Type != storage.Postgres {
 		return nil
 	}
 
-	m := bstorage.NewBindataMigrator(
-		u.config.DBUrl,
-		bindata.Resource(migrations.AssetNames(), migrations.Asset),
-		&bstorage.ZapLogger{Logger: u.Logger},
-	)
-	return m.Up()
+	return postgres.NewMigrator(u.config.DBUrl, u.Logger).Up()
 }
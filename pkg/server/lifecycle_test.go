@@ -1,10 +1,24 @@
 package server
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/elixirhealth/user/pkg/certs"
+	"github.com/elixirhealth/user/pkg/certs/certstest"
+	"github.com/elixirhealth/user/pkg/client"
+	"github.com/elixirhealth/user/pkg/server/auth"
+	"github.com/elixirhealth/user/pkg/server/storage"
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
 )
 
 func TestStart(t *testing.T) {
@@ -23,3 +37,198 @@ func TestStart(t *testing.T) {
 	x.StopServer()
 	wg1.Wait()
 }
+
+func TestStart_rpcMetrics(t *testing.T) {
+	config := NewDefaultConfig()
+	config.ServerPort = 10206
+	config.MetricsPort = 10207
+	up := make(chan *User, 1)
+	wg1 := new(sync.WaitGroup)
+	wg1.Add(1)
+	go func(wg2 *sync.WaitGroup) {
+		defer wg2.Done()
+		err := Start(config, up)
+		assert.Nil(t, err)
+	}(wg1)
+
+	x := <-up
+	c, err := client.NewInsecure(fmt.Sprintf("localhost:%d", config.ServerPort))
+	assert.Nil(t, err)
+	ctx := context.Background()
+	_, err = c.AddEntity(ctx, &api.AddEntityRequest{UserId: "user 1", EntityId: "entity 1"})
+	assert.Nil(t, err)
+	_, err = c.AddEntity(ctx, &api.AddEntityRequest{UserId: "user 1", EntityId: "entity 1"})
+	assert.NotNil(t, err)
+
+	added, err := ptypes.TimestampProto(time.Now())
+	assert.Nil(t, err)
+	stream, err := c.ImportAssociations(ctx)
+	assert.Nil(t, err)
+	err = stream.Send(&api.ImportAssociationsRequest{
+		Options: &api.ImportOptions{ConflictMode: api.ConflictMode_FAIL},
+		Associations: []*api.AssociationRecord{{
+			UserId:   "user 1",
+			EntityId: "entity 2",
+			Versions: []*api.AssociationVersion{{Role: api.Role_OWNER, AddedTime: added}},
+		}},
+	})
+	assert.Nil(t, err)
+	_, err = stream.CloseAndRecv()
+	assert.Nil(t, err)
+
+	handled := gatherCounters(t, x.rpcMetrics, "user_grpc_server_handled_total")
+	assert.Equal(t, float64(1), handled["AddEntity OK"])
+	assert.Equal(t, float64(1), handled["AddEntity AlreadyExists"])
+	assert.Equal(t, float64(1), handled["ImportAssociations OK"])
+	assert.Zero(t, handled["GetEntities OK"]) // initialized but not called
+	assert.Contains(t, handled, "GetEntities OK")
+
+	x.StopServer()
+	wg1.Wait()
+}
+
+func TestNewRPCMetrics(t *testing.T) {
+	m := newRPCMetrics(storage.Postgres)
+	info := &grpc.UnaryServerInfo{FullMethod: "/userapi.User/AddEntity"}
+	handler := func(ctx context.Context, rq interface{}) (interface{}, error) { return nil, nil }
+	_, err := m.UnaryServerInterceptor()(context.Background(), nil, info, handler)
+	assert.Nil(t, err)
+
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, registry.Register(m))
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, families)
+	for _, family := range families {
+		for _, m := range family.Metric {
+			labels := make(map[string]string)
+			for _, label := range m.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			assert.Equal(t, storage.Postgres.String(), labels[backendLabel], family.GetName())
+		}
+	}
+}
+
+func TestUser_registerMetrics(t *testing.T) {
+	config := NewDefaultConfig()
+	config.Storage.CacheSize = 16
+	u1, err := newUser(config)
+	assert.Nil(t, err)
+	u2, err := newUser(config)
+	assert.Nil(t, err)
+	assert.Implements(t, (*prometheus.Collector)(nil), u1.storer)
+
+	assert.Nil(t, u1.registerMetrics())
+	assert.Nil(t, u2.registerMetrics()) // already registered by u1
+	u1.unregisterMetrics()
+	assert.Nil(t, u2.registerMetrics())
+	u2.unregisterMetrics()
+
+	// uncached storers still have their storage metrics
+	u3, err := newUser(NewDefaultConfig())
+	assert.Nil(t, err)
+	assert.Implements(t, (*prometheus.Collector)(nil), u3.storer)
+	assert.Nil(t, u3.registerMetrics())
+	u3.unregisterMetrics()
+
+	// storers without metrics only have the RPC metrics to register
+	u4 := &User{storer: &fixedStorer{}}
+	assert.Nil(t, u4.registerMetrics())
+	u5 := &User{storer: &fixedStorer{}, rpcMetrics: newRPCMetrics(storage.Memory)}
+	assert.Len(t, u5.collectors(), 1)
+	assert.Nil(t, u5.registerMetrics())
+	u5.unregisterMetrics()
+}
+
+func TestUser_serverOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	files, err := certstest.Write(dir, "some-service", auth.ServiceScope)
+	assert.Nil(t, err)
+
+	cases := map[string]struct {
+		config       *Config
+		expectedOpts int
+		expectedErr  error
+	}{
+		"no TLS or auth": {
+			config:       NewDefaultConfig(),
+			expectedOpts: 2,
+		},
+		"TLS": {
+			config: NewDefaultConfig().
+				WithTLSKeyPair(files.ServerCertFile, files.ServerKeyFile),
+			expectedOpts: 3,
+		},
+		"mTLS auth": {
+			config: NewDefaultConfig().
+				WithTLSKeyPair(files.ServerCertFile, files.ServerKeyFile).
+				WithTLSClientCAFile(files.CAFile).
+				WithAuthMTLS(true),
+			expectedOpts: 3,
+		},
+		"mTLS auth without client CA": {
+			config: NewDefaultConfig().
+				WithTLSKeyPair(files.ServerCertFile, files.ServerKeyFile).
+				WithAuthMTLS(true),
+			expectedErr: ErrMTLSWithoutClientCA,
+		},
+		"mTLS auth without TLS": {
+			config:      NewDefaultConfig().WithAuthMTLS(true),
+			expectedErr: ErrMTLSWithoutClientCA,
+		},
+		"client CA without TLS": {
+			config:      NewDefaultConfig().WithTLSClientCAFile(files.CAFile),
+			expectedErr: ErrClientCAWithoutTLS,
+		},
+		"cert without key": {
+			config:      NewDefaultConfig().WithTLSKeyPair(files.ServerCertFile, ""),
+			expectedErr: certs.ErrMissingKeyPair,
+		},
+	}
+	for desc, c := range cases {
+		u, err := newUser(c.config)
+		assert.Nil(t, err, desc)
+		opts, err := u.serverOptions()
+		assert.Equal(t, c.expectedErr, err, desc)
+		assert.Len(t, opts, c.expectedOpts, desc)
+		if u.certs != nil {
+			assert.Nil(t, u.certs.Close(), desc)
+		}
+	}
+
+	// missing JWKS file
+	u, err := newUser(NewDefaultConfig().WithAuthJWKSFile("/nonexistent/jwks.json"))
+	assert.Nil(t, err)
+	opts, err := u.serverOptions()
+	assert.NotNil(t, err)
+	assert.Nil(t, opts)
+}
+
+// gatherCounters returns the values of the counter with the given name from the collector,
+// keyed by the method and code labels of each.
+func gatherCounters(
+	t *testing.T, collector prometheus.Collector, name string,
+) map[string]float64 {
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, registry.Register(collector))
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	counters := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.Metric {
+			labels := make(map[string]string)
+			for _, label := range m.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			key := labels["grpc_method"] + " " + labels["grpc_code"]
+			counters[key] = m.Counter.GetValue()
+		}
+	}
+	return counters
+}
@@ -11,9 +11,58 @@ const (
 	logEntityID  = "entity_id"
 	logUserID    = "user_id"
 	logNEntities = "n_entities"
+	logNUsers    = "n_users"
+	logNEvents   = "n_events"
+
+	logMaxUserEntities = "max_user_entities"
+	logMaxEntityUsers  = "max_entity_users"
+	logMaxEntities     = "max_entities"
+	logMaxUsers        = "max_users"
+
+	logNAssociations = "n_associations"
+	logNAdded        = "n_added"
+	logNFailed       = "n_failed"
+
+	logIfNotExists = "if_not_exists"
+	logCreated     = "created"
+	logRole        = "role"
+
+	logNAttributes = "n_attributes"
+	logMorePages   = "more_pages"
+
+	logFromRevision = "from_revision"
+	logRevision     = "revision"
+
+	logConflictMode = "conflict_mode"
+	logDryRun       = "dry_run"
+	logNImported    = "n_imported"
+	logNOverwritten = "n_overwritten"
+	logNSkipped     = "n_skipped"
+
+	logAuthJWKSFile    = "auth_jwks_file"
+	logAuthJWTIssuer   = "auth_jwt_issuer"
+	logAuthJWTAudience = "auth_jwt_audience"
+	logAuthMTLS        = "auth_mtls"
+
+	logTLSCertFile     = "tls_cert_file"
+	logTLSKeyFile      = "tls_key_file"
+	logTLSClientCAFile = "tls_client_ca_file"
 )
 
 func logAddEntityRq(rq *api.AddEntityRequest) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logUserID, rq.UserId),
+		zap.String(logEntityID, rq.EntityId),
+		zap.Bool(logIfNotExists, rq.IfNotExists),
+		zap.Stringer(logRole, rq.Role),
+	}
+}
+
+func logAddEntityRp(rq *api.AddEntityRequest, rp *api.AddEntityResponse) []zapcore.Field {
+	return append(logAddEntityRq(rq), zap.Bool(logCreated, rp.Created))
+}
+
+func logRemoveEntityRq(rq *api.RemoveEntityRequest) []zapcore.Field {
 	return []zapcore.Field{
 		zap.String(logUserID, rq.UserId),
 		zap.String(logEntityID, rq.EntityId),
@@ -24,5 +73,128 @@ func logGetEntityRp(rq *api.GetEntitiesRequest, rp *api.GetEntitiesResponse) []z
 	return []zapcore.Field{
 		zap.String(logUserID, rq.UserId),
 		zap.Int(logNEntities, len(rp.EntityIds)),
+		zap.Bool(logMorePages, rp.NextPageToken != ""),
+	}
+}
+
+func logGetUsersRp(rq *api.GetUsersRequest, rp *api.GetUsersResponse) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logEntityID, rq.EntityId),
+		zap.Int(logNUsers, len(rp.UserIds)),
+		zap.Bool(logMorePages, rp.NextPageToken != ""),
+	}
+}
+
+func logGetHistoryRq(rq *api.GetAssociationHistoryRequest) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logUserID, rq.UserId),
+		zap.String(logEntityID, rq.EntityId),
+	}
+}
+
+func logGetHistoryRp(
+	rq *api.GetAssociationHistoryRequest, rp *api.GetAssociationHistoryResponse,
+) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logUserID, rq.UserId),
+		zap.String(logEntityID, rq.EntityId),
+		zap.Int(logNEvents, len(rp.Events)),
+	}
+}
+
+func logWatchEntitiesRq(rq *api.WatchEntitiesRequest) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logUserID, rq.UserId),
+		zap.String(logEntityID, rq.EntityId),
+		zap.Uint64(logFromRevision, rq.FromRevision),
+	}
+}
+
+func logWatchEntitiesEnd(
+	rq *api.WatchEntitiesRequest, nSent int, lastRevision uint64, err error,
+) []zapcore.Field {
+	return append(logWatchEntitiesRq(rq),
+		zap.Int(logNEvents, nSent),
+		zap.Uint64(logRevision, lastRevision),
+		zap.Error(err),
+	)
+}
+
+func logExportAssociationsEnd(nSent int, err error) []zapcore.Field {
+	return []zapcore.Field{
+		zap.Int(logNAssociations, nSent),
+		zap.Error(err),
+	}
+}
+
+func logImportAssociationsEnd(
+	opts *api.ImportOptions, rp *api.ImportAssociationsResponse, err error,
+) []zapcore.Field {
+	return []zapcore.Field{
+		zap.Stringer(logConflictMode, opts.ConflictMode),
+		zap.Bool(logDryRun, opts.DryRun),
+		zap.Uint64(logNImported, rp.NImported),
+		zap.Uint64(logNOverwritten, rp.NOverwritten),
+		zap.Uint64(logNSkipped, rp.NSkipped),
+		zap.Error(err),
+	}
+}
+
+func logSetUserLimitRq(rq *api.SetUserLimitRequest) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logUserID, rq.UserId),
+		zap.Uint32(logMaxEntities, rq.MaxEntities),
+	}
+}
+
+func logSetEntityLimitRq(rq *api.SetEntityLimitRequest) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logEntityID, rq.EntityId),
+		zap.Uint32(logMaxUsers, rq.MaxUsers),
+	}
+}
+
+func logUpdateRoleRq(rq *api.UpdateRoleRequest) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logUserID, rq.UserId),
+		zap.String(logEntityID, rq.EntityId),
+		zap.Stringer(logRole, rq.Role),
+	}
+}
+
+// logUser logs the user ID and number of attributes of a possibly nil profile, leaving out its
+// personal details.
+func logUser(u *api.UserProfile) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logUserID, u.GetUserId()),
+		zap.Int(logNAttributes, len(u.GetAttributes())),
+	}
+}
+
+func logAddEntitiesRp(rp *api.AddEntitiesResponse) []zapcore.Field {
+	nFailed := 0
+	for _, r := range rp.Results {
+		if r.Error != "" {
+			nFailed++
+		}
+	}
+	return []zapcore.Field{
+		zap.Int(logNAdded, len(rp.Results)-nFailed),
+		zap.Int(logNFailed, nFailed),
+	}
+}
+
+func logGetEntitiesBatchRp(rp *api.GetEntitiesBatchResponse) []zapcore.Field {
+	nEntities, nFailed := 0, 0
+	for _, r := range rp.Results {
+		nEntities += len(r.EntityIds)
+		if r.Error != "" {
+			nFailed++
+		}
+	}
+	return []zapcore.Field{
+		zap.Int(logNUsers, len(rp.Results)),
+		zap.Int(logNEntities, nEntities),
+		zap.Int(logNFailed, nFailed),
 	}
 }
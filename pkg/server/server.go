@@ -1,31 +1,26 @@
 package server
 
 import (
+	"io"
+
 	"github.com/elixirhealth/service-base/pkg/server"
+	"github.com/elixirhealth/user/pkg/certs"
 	"github.com/elixirhealth/user/pkg/server/storage"
 	api "github.com/elixirhealth/user/pkg/userapi"
-	"github.com/pkg/errors"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/grpc-ecosystem/go-grpc-prometheus"
 	"go.uber.org/zap"
 	"golang.org/x/net/context"
 )
 
-const (
-	// MaxUserEntities is the maximum number of entities a user can be associated with.
-	MaxUserEntities = 16
-
-	// MaxEntityUsers is the maximum number of users that can be associated with a single
-	// entity.
-	MaxEntityUsers = 256
-)
-
 var (
 	// ErrTooManyUserEntities indicates when associating another entity ID with a given user ID
-	// would put the total associated entities above the max value (MaxUserEntities).
-	ErrTooManyUserEntities = errors.New("too many associated entities for user ID")
+	// would put the total associated entities above the max value (see Config.MaxUserEntities).
+	ErrTooManyUserEntities = storage.ErrTooManyUserEntities
 
 	// ErrTooManyEntityUsers indicates when associating another user ID with the given entity ID
-	// would put the total associated users above the max value (MaxEntityUsers).
-	ErrTooManyEntityUsers = errors.New("too many associated users for entity ID")
+	// would put the total associated users above the max value (see Config.MaxEntityUsers).
+	ErrTooManyEntityUsers = storage.ErrTooManyEntityUsers
 )
 
 // User implements the UserServer interface.
@@ -33,7 +28,9 @@ type User struct {
 	*server.BaseServer
 	config *Config
 
-	storer storage.Storer
+	storer     storage.Storer
+	certs      *certs.Reloader
+	rpcMetrics *grpc_prometheus.ServerMetrics
 }
 
 // newUser creates a new UserServer from the given config.
@@ -47,47 +44,461 @@ func newUser(config *Config) (*User, error) {
 		BaseServer: baseServer,
 		config:     config,
 		storer:     storer,
+		rpcMetrics: newRPCMetrics(config.Storage.Type),
 	}, nil
 }
 
-// AddEntity associates an entity ID with the given user ID.
+// AddEntity associates an entity ID with the given user ID. If the request's IfNotExists flag is
+// set, an existing association succeeds without being created again.
 func (u *User) AddEntity(
 	ctx context.Context, rq *api.AddEntityRequest,
 ) (*api.AddEntityResponse, error) {
 	u.Logger.Debug("received add entity request", logAddEntityRq(rq)...)
 	if err := api.ValidateAddEntityRequest(rq); err != nil {
-		return nil, err
+		return nil, statusErr(err)
 	}
-	if n, err := u.storer.CountUsers(rq.EntityId); err != nil {
-		return nil, err
-	} else if n+1 > MaxEntityUsers {
-		return nil, ErrTooManyEntityUsers
+	rp := &api.AddEntityResponse{Created: true}
+	err := u.storer.AddEntity(ctx, rq.UserId, rq.EntityId, rq.Role, u.config.limits())
+	if err == storage.ErrUserEntityExists && rq.IfNotExists {
+		rp.Created = false
+	} else if err != nil {
+		return nil, statusErr(err)
 	}
-	if n, err := u.storer.CountEntities(rq.UserId); err != nil {
-		return nil, err
-	} else if n+1 > MaxUserEntities {
-		return nil, ErrTooManyUserEntities
+	u.Logger.Info("added entity to user", logAddEntityRp(rq, rp)...)
+	return rp, nil
+}
+
+// AddEntities associates each entity ID with its user ID, reporting the outcome for each
+// association separately.
+func (u *User) AddEntities(
+	ctx context.Context, rq *api.AddEntitiesRequest,
+) (*api.AddEntitiesResponse, error) {
+	u.Logger.Debug("received add entities request",
+		zap.Int(logNAssociations, len(rq.Associations)))
+	if err := api.ValidateAddEntitiesRequest(rq); err != nil {
+		return nil, statusErr(err)
 	}
-	if err := u.storer.AddEntity(rq.UserId, rq.EntityId); err != nil {
-		return nil, err
+	as := make([]*storage.Association, len(rq.Associations))
+	for i, a := range rq.Associations {
+		as[i] = &storage.Association{UserID: a.UserId, EntityID: a.EntityId, Role: a.Role}
+	}
+	errs, err := u.storer.AddEntities(ctx, as, u.config.limits())
+	if err != nil {
+		return nil, statusErr(err)
 	}
-	u.Logger.Info("added entity to user", logAddEntityRq(rq)...)
-	return &api.AddEntityResponse{}, nil
+	rp := &api.AddEntitiesResponse{
+		Results: make([]*api.AddEntityResult, len(as)),
+	}
+	for i, a := range as {
+		rp.Results[i] = &api.AddEntityResult{UserId: a.UserID, EntityId: a.EntityID}
+		if errs[i] != nil {
+			rp.Results[i].Error = errs[i].Error()
+			rp.Results[i].Code = uint32(statusCode(errs[i]))
+		}
+	}
+	u.Logger.Info("added entities to users", logAddEntitiesRp(rp)...)
+	return rp, nil
+}
+
+// RemoveEntity dissociates an entity ID from the given user ID.
+func (u *User) RemoveEntity(
+	ctx context.Context, rq *api.RemoveEntityRequest,
+) (*api.RemoveEntityResponse, error) {
+	u.Logger.Debug("received remove entity request", logRemoveEntityRq(rq)...)
+	if err := api.ValidateRemoveEntityRequest(rq); err != nil {
+		return nil, statusErr(err)
+	}
+	if err := u.storer.RemoveEntity(ctx, rq.UserId, rq.EntityId); err != nil {
+		return nil, statusErr(err)
+	}
+	u.Logger.Info("removed entity from user", logRemoveEntityRq(rq)...)
+	return &api.RemoveEntityResponse{}, nil
 }
 
-// GetEntities gets the associated entity IDs for the given user ID.
+// GetEntities gets a page of the associated entity IDs for the given user ID, either currently or
+// as of a given time.
 func (u *User) GetEntities(
 	ctx context.Context, rq *api.GetEntitiesRequest,
 ) (*api.GetEntitiesResponse, error) {
 	u.Logger.Debug("received get entities request", zap.String(logUserID, rq.UserId))
 	if err := api.ValidateGetEntitiesRequest(rq); err != nil {
-		return nil, err
+		return nil, statusErr(err)
 	}
-	entityIDs, err := u.storer.GetEntities(rq.UserId)
+	as, next, err := u.getEntities(ctx, rq)
 	if err != nil {
-		return nil, err
+		return nil, statusErr(err)
+	}
+	rp := &api.GetEntitiesResponse{
+		EntityIds:     make([]string, len(as)),
+		Roles:         make([]api.Role, len(as)),
+		NextPageToken: next,
+	}
+	for i, a := range as {
+		rp.EntityIds[i], rp.Roles[i] = a.EntityID, a.Role
 	}
-	rp := &api.GetEntitiesResponse{EntityIds: entityIDs}
 	u.Logger.Info("got entities for user", logGetEntityRp(rq, rp)...)
 	return rp, nil
 }
+
+func (u *User) getEntities(
+	ctx context.Context, rq *api.GetEntitiesRequest,
+) ([]*storage.Association, string, error) {
+	page := &storage.Page{Size: pageSize(rq.PageSize), Token: rq.PageToken}
+	if rq.AsOf == nil {
+		return u.storer.GetEntities(ctx, rq.UserId, page)
+	}
+	asOf, err := ptypes.Timestamp(rq.AsOf)
+	if err != nil {
+		return nil, "", err
+	}
+	return u.storer.GetEntitiesAsOf(ctx, rq.UserId, asOf, page)
+}
+
+// GetEntitiesBatch gets the entity IDs associated with each of the given user IDs.
+func (u *User) GetEntitiesBatch(
+	ctx context.Context, rq *api.GetEntitiesBatchRequest,
+) (*api.GetEntitiesBatchResponse, error) {
+	u.Logger.Debug("received get entities batch request", zap.Int(logNUsers, len(rq.UserIds)))
+	if err := api.ValidateGetEntitiesBatchRequest(rq); err != nil {
+		return nil, statusErr(err)
+	}
+	userIDs := make([]string, 0, len(rq.UserIds))
+	for _, userID := range rq.UserIds {
+		if userID != "" {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	entityIDs, err := u.storer.GetEntitiesBatch(ctx, userIDs)
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	rp := &api.GetEntitiesBatchResponse{
+		Results: make([]*api.UserEntities, len(rq.UserIds)),
+	}
+	for i, userID := range rq.UserIds {
+		rp.Results[i] = &api.UserEntities{UserId: userID}
+		if userID == "" {
+			rp.Results[i].Error = api.ErrEmptyUserID.Error()
+			rp.Results[i].Code = uint32(statusCode(api.ErrEmptyUserID))
+			continue
+		}
+		rp.Results[i].EntityIds = entityIDs[userID]
+	}
+	u.Logger.Info("got entities for users", logGetEntitiesBatchRp(rp)...)
+	return rp, nil
+}
+
+// GetUsers gets a page of the associated user IDs for the given entity ID.
+func (u *User) GetUsers(
+	ctx context.Context, rq *api.GetUsersRequest,
+) (*api.GetUsersResponse, error) {
+	u.Logger.Debug("received get users request", zap.String(logEntityID, rq.EntityId))
+	if err := api.ValidateGetUsersRequest(rq); err != nil {
+		return nil, statusErr(err)
+	}
+	page := &storage.Page{Size: pageSize(rq.PageSize), Token: rq.PageToken}
+	userIDs, next, err := u.storer.GetUsers(ctx, rq.EntityId, page)
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	rp := &api.GetUsersResponse{UserIds: userIDs, NextPageToken: next}
+	u.Logger.Info("got users for entity", logGetUsersRp(rq, rp)...)
+	return rp, nil
+}
+
+// GetAssociationHistory gets the time-ordered add, remove, and role update events for the
+// associations of the given user ID and/or entity ID.
+func (u *User) GetAssociationHistory(
+	ctx context.Context, rq *api.GetAssociationHistoryRequest,
+) (*api.GetAssociationHistoryResponse, error) {
+	u.Logger.Debug("received get association history request", logGetHistoryRq(rq)...)
+	if err := api.ValidateGetAssociationHistoryRequest(rq); err != nil {
+		return nil, statusErr(err)
+	}
+	events, err := u.storer.GetHistory(ctx, rq.UserId, rq.EntityId)
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	rp := &api.GetAssociationHistoryResponse{
+		Events: make([]*api.AssociationEvent, len(events)),
+	}
+	for i, e := range events {
+		if rp.Events[i], err = toAPIEvent(e); err != nil {
+			return nil, statusErr(err)
+		}
+	}
+	u.Logger.Info("got association history", logGetHistoryRp(rq, rp)...)
+	return rp, nil
+}
+
+// WatchEntities streams the add, remove, and role update events for the associations of the
+// given user ID and/or entity ID as they happen, first sending the retained events after the
+// given revision if it's non-zero.
+func (u *User) WatchEntities(
+	rq *api.WatchEntitiesRequest, stream api.User_WatchEntitiesServer,
+) error {
+	u.Logger.Debug("received watch entities request", logWatchEntitiesRq(rq)...)
+	if err := api.ValidateWatchEntitiesRequest(rq); err != nil {
+		return statusErr(err)
+	}
+	var nSent int
+	var lastRevision uint64
+	err := u.storer.Watch(stream.Context(), rq.UserId, rq.EntityId, rq.FromRevision,
+		func(e *storage.AssociationEvent) error {
+			event, err := toAPIEvent(e)
+			if err != nil {
+				return err
+			}
+			rp := &api.WatchEntitiesResponse{Event: event, Revision: e.Revision}
+			if err := stream.Send(rp); err != nil {
+				return err
+			}
+			nSent++
+			lastRevision = e.Revision
+			return nil
+		})
+	u.Logger.Info("ended entities watch", logWatchEntitiesEnd(rq, nSent, lastRevision, err)...)
+	return statusErr(err)
+}
+
+// SetUserLimit overrides the max number of entities the given user ID may be associated with.
+func (u *User) SetUserLimit(
+	ctx context.Context, rq *api.SetUserLimitRequest,
+) (*api.SetUserLimitResponse, error) {
+	u.Logger.Debug("received set user limit request", logSetUserLimitRq(rq)...)
+	if err := api.ValidateSetUserLimitRequest(rq); err != nil {
+		return nil, statusErr(err)
+	}
+	if err := u.storer.SetUserLimit(ctx, rq.UserId, int(rq.MaxEntities)); err != nil {
+		return nil, statusErr(err)
+	}
+	u.Logger.Info("set user limit", logSetUserLimitRq(rq)...)
+	return &api.SetUserLimitResponse{}, nil
+}
+
+// SetEntityLimit overrides the max number of users the given entity ID may be associated with.
+func (u *User) SetEntityLimit(
+	ctx context.Context, rq *api.SetEntityLimitRequest,
+) (*api.SetEntityLimitResponse, error) {
+	u.Logger.Debug("received set entity limit request", logSetEntityLimitRq(rq)...)
+	if err := api.ValidateSetEntityLimitRequest(rq); err != nil {
+		return nil, statusErr(err)
+	}
+	if err := u.storer.SetEntityLimit(ctx, rq.EntityId, int(rq.MaxUsers)); err != nil {
+		return nil, statusErr(err)
+	}
+	u.Logger.Info("set entity limit", logSetEntityLimitRq(rq)...)
+	return &api.SetEntityLimitResponse{}, nil
+}
+
+// UpdateRole changes the role of the given user ID for the associated entity ID.
+func (u *User) UpdateRole(
+	ctx context.Context, rq *api.UpdateRoleRequest,
+) (*api.UpdateRoleResponse, error) {
+	u.Logger.Debug("received update role request", logUpdateRoleRq(rq)...)
+	if err := api.ValidateUpdateRoleRequest(rq); err != nil {
+		return nil, statusErr(err)
+	}
+	if err := u.storer.UpdateRole(ctx, rq.UserId, rq.EntityId, rq.Role); err != nil {
+		return nil, statusErr(err)
+	}
+	u.Logger.Info("updated role", logUpdateRoleRq(rq)...)
+	return &api.UpdateRoleResponse{}, nil
+}
+
+// PutUser creates the profile of a user or replaces it if one already exists.
+func (u *User) PutUser(
+	ctx context.Context, rq *api.PutUserRequest,
+) (*api.PutUserResponse, error) {
+	u.Logger.Debug("received put user request", logUser(rq.User)...)
+	if err := api.ValidatePutUserRequest(rq); err != nil {
+		return nil, statusErr(err)
+	}
+	if err := u.storer.PutUser(ctx, rq.User); err != nil {
+		return nil, statusErr(err)
+	}
+	u.Logger.Info("put user", logUser(rq.User)...)
+	return &api.PutUserResponse{}, nil
+}
+
+// GetUser gets the profile of the given user ID.
+func (u *User) GetUser(
+	ctx context.Context, rq *api.GetUserRequest,
+) (*api.GetUserResponse, error) {
+	u.Logger.Debug("received get user request", zap.String(logUserID, rq.UserId))
+	if err := api.ValidateGetUserRequest(rq); err != nil {
+		return nil, statusErr(err)
+	}
+	user, err := u.storer.GetUser(ctx, rq.UserId)
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	u.Logger.Info("got user", logUser(user)...)
+	return &api.GetUserResponse{User: user}, nil
+}
+
+// UpdateUser merges the populated fields of the given profile into the user's existing profile.
+func (u *User) UpdateUser(
+	ctx context.Context, rq *api.UpdateUserRequest,
+) (*api.UpdateUserResponse, error) {
+	u.Logger.Debug("received update user request", logUser(rq.User)...)
+	if err := api.ValidateUpdateUserRequest(rq); err != nil {
+		return nil, statusErr(err)
+	}
+	user, err := u.storer.UpdateUser(ctx, rq.User)
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	u.Logger.Info("updated user", logUser(user)...)
+	return &api.UpdateUserResponse{User: user}, nil
+}
+
+// ExportAssociations streams every association with its full history, ordered by user ID and then
+// entity ID.
+func (u *User) ExportAssociations(
+	rq *api.ExportAssociationsRequest, stream api.User_ExportAssociationsServer,
+) error {
+	u.Logger.Debug("received export associations request")
+	var nSent int
+	err := u.storer.ExportAssociations(stream.Context(),
+		func(versions []*storage.AssociationVersion) error {
+			r, err := toAPIRecord(versions)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(&api.ExportAssociationsResponse{Association: r}); err != nil {
+				return err
+			}
+			nSent++
+			return nil
+		})
+	u.Logger.Info("exported associations", logExportAssociationsEnd(nSent, err)...)
+	return statusErr(err)
+}
+
+// ImportAssociations stores the streamed associations with their history, resolving those that
+// already exist according to the conflict mode of the first request. The whole stream is received
+// and checked before any association is stored, so an invalid association or, in FAIL mode, an
+// existing one fails the import without changing any. A dry run only counts what would be
+// imported.
+func (u *User) ImportAssociations(stream api.User_ImportAssociationsServer) error {
+	u.Logger.Debug("received import associations request")
+	var opts *api.ImportOptions
+	groups := make([][]*storage.AssociationVersion, 0)
+	seen := make(map[storage.AssociationID]struct{})
+	for {
+		rq, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := api.ValidateImportAssociationsRequest(rq, opts == nil); err != nil {
+			return statusErr(err)
+		}
+		if opts == nil {
+			opts = rq.Options
+		}
+		if len(groups)+len(rq.Associations) > api.MaxImportSize {
+			return statusErr(api.ErrImportTooLarge)
+		}
+		for _, r := range rq.Associations {
+			versions, err := fromAPIRecord(r)
+			if err != nil {
+				return statusErr(err)
+			}
+			key := storage.AssociationID{UserID: r.UserId, EntityID: r.EntityId}
+			if _, in := seen[key]; in {
+				return statusErr(api.ErrDuplicateAssociation)
+			}
+			seen[key] = struct{}{}
+			groups = append(groups, versions)
+		}
+	}
+	if opts == nil {
+		// the stream ended without any requests
+		return statusErr(api.ErrInvalidConflictMode)
+	}
+	rp := &api.ImportAssociationsResponse{}
+	if opts.ConflictMode == api.ConflictMode_FAIL {
+		if err := u.checkImportConflicts(stream.Context(), groups); err != nil {
+			u.Logger.Info("stopped associations import",
+				logImportAssociationsEnd(opts, rp, err)...)
+			return statusErr(err)
+		}
+	}
+	for _, versions := range groups {
+		if err := u.importAssociation(stream.Context(), versions, opts, rp); err != nil {
+			u.Logger.Info("stopped associations import",
+				logImportAssociationsEnd(opts, rp, err)...)
+			return statusErr(err)
+		}
+	}
+	u.Logger.Info("imported associations", logImportAssociationsEnd(opts, rp, nil)...)
+	return stream.SendAndClose(rp)
+}
+
+// checkImportConflicts returns the AlreadyExists error for the first of the associations that
+// already exists, if any.
+func (u *User) checkImportConflicts(
+	ctx context.Context, groups [][]*storage.AssociationVersion,
+) error {
+	for _, versions := range groups {
+		userID, entityID := versions[0].UserID, versions[0].EntityID
+		exists, err := u.associationExists(ctx, userID, entityID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return importConflictErr(userID, entityID)
+		}
+	}
+	return nil
+}
+
+// associationExists returns whether the user and entity have any history.
+func (u *User) associationExists(ctx context.Context, userID, entityID string) (bool, error) {
+	events, err := u.storer.GetHistory(ctx, userID, entityID)
+	if err != nil {
+		return false, err
+	}
+	return len(events) > 0, nil
+}
+
+// importAssociation imports the association's versions or, for a dry run, checks whether it
+// exists, and counts the outcome in the response.
+func (u *User) importAssociation(
+	ctx context.Context,
+	versions []*storage.AssociationVersion,
+	opts *api.ImportOptions,
+	rp *api.ImportAssociationsResponse,
+) error {
+	userID, entityID := versions[0].UserID, versions[0].EntityID
+	overwrite := opts.ConflictMode == api.ConflictMode_OVERWRITE
+	var exists bool
+	var err error
+	if opts.DryRun {
+		exists, err = u.associationExists(ctx, userID, entityID)
+		if err != nil {
+			return err
+		}
+	} else {
+		exists, err = u.storer.ImportAssociation(ctx, versions, overwrite)
+		if err == storage.ErrUserEntityExists {
+			exists = true
+		} else if err != nil {
+			return err
+		}
+	}
+	switch {
+	case !exists:
+		rp.NImported++
+	case overwrite:
+		rp.NOverwritten++
+	case opts.ConflictMode == api.ConflictMode_SKIP:
+		rp.NSkipped++
+	default:
+		return importConflictErr(userID, entityID)
+	}
+	return nil
+}
@@ -2,14 +2,24 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	bserver "github.com/elixirhealth/service-base/pkg/server"
-	bstorage "github.com/elixirhealth/service-base/pkg/server/storage"
 	"github.com/elixirhealth/user/pkg/server/storage"
+	"github.com/elixirhealth/user/pkg/server/storage/memory"
 	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 const (
@@ -32,7 +42,7 @@ func TestNewUser_ok(t *testing.T) {
 func TestNewUser_err(t *testing.T) {
 	badConfigs := map[string]*Config{
 		"empty ProjectID": NewDefaultConfig().WithStorage(
-			&storage.Parameters{Type: bstorage.DataStore},
+			&storage.Parameters{Type: storage.DataStore},
 		),
 	}
 	for desc, badConfig := range badConfigs {
@@ -40,23 +50,71 @@ func TestNewUser_err(t *testing.T) {
 		assert.NotNil(t, err, desc)
 		assert.Nil(t, c)
 	}
+
+	// config errors are returned as they are rather than as gRPC statuses
+	c, err := newUser(NewDefaultConfig().WithStorage(
+		&storage.Parameters{Type: storage.Unspecified},
+	))
+	assert.Equal(t, ErrInvalidStorageType, err)
+	assert.Nil(t, c)
+
+	// DataStore has no change feed to invalidate cached entries from other servers' writes
+	c, err = newUser(NewDefaultConfig().WithStorage(
+		&storage.Parameters{Type: storage.DataStore, CacheSize: 16},
+	))
+	assert.Equal(t, ErrCacheWithoutChangeFeed, err)
+	assert.Nil(t, c)
 }
 
 func TestUser_AddEntity_ok(t *testing.T) {
 	u := &User{
 		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		config:     NewDefaultConfig(),
 		storer:     &fixedStorer{},
 	}
 	rq := &api.AddEntityRequest{
 		UserId:   testUserID,
 		EntityId: testEntityID,
 	}
-	_, err := u.AddEntity(context.Background(), rq)
+	rp, err := u.AddEntity(context.Background(), rq)
+	assert.Nil(t, err)
+	assert.True(t, rp.Created)
+}
+
+func TestUser_AddEntity_ifNotExists(t *testing.T) {
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		config:     NewDefaultConfig(),
+		storer:     memory.New(storage.NewDefaultParameters(), zap.NewNop()),
+	}
+	rq := &api.AddEntityRequest{
+		UserId:      testUserID,
+		EntityId:    testEntityID,
+		IfNotExists: true,
+	}
+	rp, err := u.AddEntity(context.Background(), rq)
+	assert.Nil(t, err)
+	assert.True(t, rp.Created)
+
+	// repeat add succeeds without creating another association
+	rp, err = u.AddEntity(context.Background(), rq)
 	assert.Nil(t, err)
+	assert.False(t, rp.Created)
+
+	as, _, err := u.storer.GetEntities(context.Background(), testUserID, nil)
+	assert.Nil(t, err)
+	assert.Len(t, as, 1)
+
+	// without the flag, the repeat add fails
+	rq.IfNotExists = false
+	rp, err = u.AddEntity(context.Background(), rq)
+	assert.Equal(t, statusErr(storage.ErrUserEntityExists), err)
+	assert.Nil(t, rp)
 }
 
 func TestUser_AddEntity_err(t *testing.T) {
 	baseServer := bserver.NewBaseServer(bserver.NewDefaultBaseConfig())
+	config := NewDefaultConfig()
 	okRq := &api.AddEntityRequest{
 		EntityId: testEntityID,
 		UserId:   testUserID,
@@ -73,39 +131,43 @@ func TestUser_AddEntity_err(t *testing.T) {
 			},
 			expected: api.ErrEmptyUserID,
 		},
-		"count users err": {
+		"too many users for entity": {
 			u: &User{
 				BaseServer: baseServer,
+				config:     config,
 				storer: &fixedStorer{
-					countUsersErr: errTest,
+					addEntityErr: storage.ErrTooManyEntityUsers,
 				},
 			},
 			rq:       okRq,
-			expected: errTest,
+			expected: ErrTooManyEntityUsers,
 		},
-		"too many users for entity": {
+		"too many entities for user": {
 			u: &User{
 				BaseServer: baseServer,
+				config:     config,
 				storer: &fixedStorer{
-					countUsersValue: MaxEntityUsers,
+					addEntityErr: storage.ErrTooManyUserEntities,
 				},
 			},
 			rq:       okRq,
-			expected: ErrTooManyEntityUsers,
+			expected: ErrTooManyUserEntities,
 		},
-		"too many entities for user": {
+		"already exists": {
 			u: &User{
 				BaseServer: baseServer,
+				config:     config,
 				storer: &fixedStorer{
-					countEntitiesValue: MaxUserEntities,
+					addEntityErr: storage.ErrUserEntityExists,
 				},
 			},
 			rq:       okRq,
-			expected: ErrTooManyUserEntities,
+			expected: storage.ErrUserEntityExists,
 		},
 		"add entity err": {
 			u: &User{
 				BaseServer: baseServer,
+				config:     config,
 				storer: &fixedStorer{
 					addEntityErr: errTest,
 				},
@@ -116,7 +178,181 @@ func TestUser_AddEntity_err(t *testing.T) {
 	}
 	for desc, c := range cases {
 		_, err := c.u.AddEntity(context.Background(), c.rq)
-		assert.Equal(t, c.expected, err, desc)
+		assert.Equal(t, statusErr(c.expected), err, desc)
+	}
+}
+
+func TestUser_AddEntity_concurrent(t *testing.T) {
+	maxUserEntities, maxEntityUsers := 8, 32
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		config: NewDefaultConfig().
+			WithMaxUserEntities(uint(maxUserEntities)).
+			WithMaxEntityUsers(uint(maxEntityUsers)),
+		storer: memory.New(storage.NewDefaultParameters(), zap.NewNop()),
+	}
+
+	// many users concurrently adding the same entity
+	nAdded := addEntitiesConcurrently(u, 2*maxEntityUsers, func(i int) *api.AddEntityRequest {
+		return &api.AddEntityRequest{
+			UserId:   fmt.Sprintf("user %d", i),
+			EntityId: testEntityID,
+		}
+	})
+	assert.Equal(t, maxEntityUsers, nAdded)
+
+	// a single user concurrently adding many entities
+	nAdded = addEntitiesConcurrently(u, 2*maxUserEntities, func(i int) *api.AddEntityRequest {
+		return &api.AddEntityRequest{
+			UserId:   testUserID,
+			EntityId: fmt.Sprintf("entity %d", i),
+		}
+	})
+	assert.Equal(t, maxUserEntities, nAdded)
+}
+
+func addEntitiesConcurrently(u *User, n int, newRq func(i int) *api.AddEntityRequest) int {
+	var nAdded int32
+	wg := new(sync.WaitGroup)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(rq *api.AddEntityRequest) {
+			defer wg.Done()
+			if _, err := u.AddEntity(context.Background(), rq); err == nil {
+				atomic.AddInt32(&nAdded, 1)
+			}
+		}(newRq(i))
+	}
+	wg.Wait()
+	return int(nAdded)
+}
+
+func TestUser_AddEntities_ok(t *testing.T) {
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		config:     NewDefaultConfig(),
+		storer: &fixedStorer{
+			addEntitiesValue: []error{nil, storage.ErrTooManyEntityUsers},
+		},
+	}
+	rq := &api.AddEntitiesRequest{
+		Associations: []*api.AddEntityRequest{
+			{UserId: "user 1", EntityId: testEntityID},
+			{UserId: "user 2", EntityId: testEntityID},
+		},
+	}
+	rp, err := u.AddEntities(context.Background(), rq)
+	assert.Nil(t, err)
+	assert.Equal(t, []*api.AddEntityResult{
+		{UserId: "user 1", EntityId: testEntityID},
+		{
+			UserId:   "user 2",
+			EntityId: testEntityID,
+			Error:    storage.ErrTooManyEntityUsers.Error(),
+			Code:     uint32(codes.ResourceExhausted),
+		},
+	}, rp.Results)
+}
+
+func TestUser_AddEntities_memory(t *testing.T) {
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		config:     NewDefaultConfig().WithMaxEntityUsers(2),
+		storer:     memory.New(storage.NewDefaultParameters(), zap.NewNop()),
+	}
+	rq := &api.AddEntitiesRequest{
+		Associations: []*api.AddEntityRequest{
+			{UserId: "user 1", EntityId: testEntityID},
+			{UserId: "", EntityId: testEntityID},
+			{UserId: "user 2", EntityId: testEntityID},
+			{UserId: "user 3", EntityId: testEntityID},
+		},
+	}
+	rp, err := u.AddEntities(context.Background(), rq)
+	assert.Nil(t, err)
+	assert.Empty(t, rp.Results[0].Error)
+	assert.Equal(t, api.ErrEmptyUserID.Error(), rp.Results[1].Error)
+	assert.Empty(t, rp.Results[2].Error)
+	assert.Equal(t, storage.ErrTooManyEntityUsers.Error(), rp.Results[3].Error)
+}
+
+func TestUser_AddEntities_err(t *testing.T) {
+	baseServer := bserver.NewBaseServer(bserver.NewDefaultBaseConfig())
+	cases := map[string]struct {
+		u        *User
+		rq       *api.AddEntitiesRequest
+		expected error
+	}{
+		"bad rq": {
+			u:        &User{BaseServer: baseServer},
+			rq:       &api.AddEntitiesRequest{},
+			expected: api.ErrEmptyBatch,
+		},
+		"add entities err": {
+			u: &User{
+				BaseServer: baseServer,
+				config:     NewDefaultConfig(),
+				storer:     &fixedStorer{addEntitiesErr: errTest},
+			},
+			rq: &api.AddEntitiesRequest{
+				Associations: []*api.AddEntityRequest{
+					{UserId: testUserID, EntityId: testEntityID},
+				},
+			},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		rp, err := c.u.AddEntities(context.Background(), c.rq)
+		assert.Equal(t, statusErr(c.expected), err, desc)
+		assert.Nil(t, rp, desc)
+	}
+}
+
+func TestUser_RemoveEntity_ok(t *testing.T) {
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		storer:     &fixedStorer{},
+	}
+	rq := &api.RemoveEntityRequest{
+		UserId:   testUserID,
+		EntityId: testEntityID,
+	}
+	_, err := u.RemoveEntity(context.Background(), rq)
+	assert.Nil(t, err)
+}
+
+func TestUser_RemoveEntity_err(t *testing.T) {
+	baseServer := bserver.NewBaseServer(bserver.NewDefaultBaseConfig())
+	cases := map[string]struct {
+		u        *User
+		rq       *api.RemoveEntityRequest
+		expected error
+	}{
+		"bad rq": {
+			u: &User{BaseServer: baseServer},
+			rq: &api.RemoveEntityRequest{
+				EntityId: testEntityID,
+			},
+			expected: api.ErrEmptyUserID,
+		},
+		"remove entity err": {
+			u: &User{
+				BaseServer: baseServer,
+				storer: &fixedStorer{
+					removeEntityErr: errTest,
+				},
+			},
+			rq: &api.RemoveEntityRequest{
+				EntityId: testEntityID,
+				UserId:   testUserID,
+			},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		_, err := c.u.RemoveEntity(context.Background(), c.rq)
+		assert.Equal(t, statusErr(c.expected), err, desc)
 	}
 }
 
@@ -124,15 +360,85 @@ func TestUser_GetEntities_ok(t *testing.T) {
 	u := &User{
 		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
 		storer: &fixedStorer{
-			getEntitiesValue: []string{"entity ID 1", "entity ID 2"},
+			getEntitiesValue: []*storage.Association{
+				{UserID: testUserID, EntityID: "entity ID 1", Role: api.Role_OWNER},
+				{UserID: testUserID, EntityID: "entity ID 2", Role: api.Role_CLINICIAN},
+			},
+			getEntitiesNext: "some next page token",
+		},
+	}
+	rq := &api.GetEntitiesRequest{
+		UserId: testUserID,
+	}
+	rp, err := u.GetEntities(context.Background(), rq)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"entity ID 1", "entity ID 2"}, rp.EntityIds)
+	assert.Equal(t, []api.Role{api.Role_OWNER, api.Role_CLINICIAN}, rp.Roles)
+	assert.Equal(t, "some next page token", rp.NextPageToken)
+
+	// page size is unlimited when not given and is capped at the max
+	page := u.storer.(*fixedStorer).getEntitiesPage
+	assert.Equal(t, &storage.Page{}, page)
+	rq = &api.GetEntitiesRequest{
+		UserId:    testUserID,
+		PageSize:  api.MaxPageSize + 1,
+		PageToken: "some page token",
+	}
+	_, err = u.GetEntities(context.Background(), rq)
+	assert.Nil(t, err)
+	page = u.storer.(*fixedStorer).getEntitiesPage
+	assert.Equal(t, &storage.Page{Size: api.MaxPageSize, Token: "some page token"}, page)
+}
+
+func TestUser_GetEntities_paging(t *testing.T) {
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		config:     NewDefaultConfig(),
+		storer:     memory.New(storage.NewDefaultParameters(), zap.NewNop()),
+	}
+	entityIDs := []string{"entity ID 3", "entity ID 1", "entity ID 5", "entity ID 2", "entity ID 4"}
+	for _, entityID := range entityIDs {
+		_, err := u.AddEntity(context.Background(), &api.AddEntityRequest{
+			UserId:   testUserID,
+			EntityId: entityID,
+		})
+		assert.Nil(t, err)
+	}
+
+	rq := &api.GetEntitiesRequest{UserId: testUserID, PageSize: 2}
+	var pages [][]string
+	for {
+		rp, err := u.GetEntities(context.Background(), rq)
+		assert.Nil(t, err)
+		pages = append(pages, rp.EntityIds)
+		if rp.NextPageToken == "" {
+			break
+		}
+		rq.PageToken = rp.NextPageToken
+	}
+	assert.Equal(t, [][]string{
+		{"entity ID 1", "entity ID 2"},
+		{"entity ID 3", "entity ID 4"},
+		{"entity ID 5"},
+	}, pages)
+}
+
+func TestUser_GetEntities_asOf(t *testing.T) {
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		storer: &fixedStorer{
+			getEntitiesAsOfValue: []*storage.Association{
+				{UserID: testUserID, EntityID: "entity ID 1"},
+			},
 		},
 	}
 	rq := &api.GetEntitiesRequest{
 		UserId: testUserID,
+		AsOf:   ptypes.TimestampNow(),
 	}
 	rp, err := u.GetEntities(context.Background(), rq)
 	assert.Nil(t, err)
-	assert.NotZero(t, len(rp.EntityIds))
+	assert.Equal(t, []string{"entity ID 1"}, rp.EntityIds)
 }
 
 func TestUser_GetEntities_err(t *testing.T) {
@@ -147,7 +453,7 @@ func TestUser_GetEntities_err(t *testing.T) {
 			rq:       &api.GetEntitiesRequest{},
 			expected: api.ErrEmptyUserID,
 		},
-		"add entity err": {
+		"get entities err": {
 			u: &User{
 				BaseServer: baseServer,
 				storer: &fixedStorer{
@@ -159,39 +465,1056 @@ func TestUser_GetEntities_err(t *testing.T) {
 			},
 			expected: errTest,
 		},
+		"get entities as of err": {
+			u: &User{
+				BaseServer: baseServer,
+				storer: &fixedStorer{
+					getEntitiesAsOfErr: errTest,
+				},
+			},
+			rq: &api.GetEntitiesRequest{
+				UserId: testUserID,
+				AsOf:   ptypes.TimestampNow(),
+			},
+			expected: errTest,
+		},
+		"invalid page token": {
+			u: &User{
+				BaseServer: baseServer,
+				storer:     memory.New(storage.NewDefaultParameters(), zap.NewNop()),
+			},
+			rq: &api.GetEntitiesRequest{
+				UserId:    testUserID,
+				PageToken: "not a page token!",
+			},
+			expected: api.ErrInvalidPageToken,
+		},
 	}
 	for desc, c := range cases {
 		_, err := c.u.GetEntities(context.Background(), c.rq)
-		assert.Equal(t, c.expected, err, desc)
+		assert.Equal(t, statusErr(c.expected), err, desc)
 	}
 }
 
-type fixedStorer struct {
-	addEntityErr       error
-	getEntitiesValue   []string
-	getEntitiesErr     error
-	countEntitiesValue int
-	countEntitiesErr   error
-	countUsersValue    int
-	countUsersErr      error
+func TestUser_GetEntitiesBatch_ok(t *testing.T) {
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		storer: &fixedStorer{
+			getEntitiesBatch: map[string][]string{
+				"user 1": {"entity 1", "entity 2"},
+				"user 2": {},
+			},
+		},
+	}
+	rq := &api.GetEntitiesBatchRequest{UserIds: []string{"user 1", "", "user 2"}}
+	rp, err := u.GetEntitiesBatch(context.Background(), rq)
+	assert.Nil(t, err)
+	assert.Equal(t, []*api.UserEntities{
+		{UserId: "user 1", EntityIds: []string{"entity 1", "entity 2"}},
+		{
+			UserId: "",
+			Error:  api.ErrEmptyUserID.Error(),
+			Code:   uint32(codes.InvalidArgument),
+		},
+		{UserId: "user 2", EntityIds: []string{}},
+	}, rp.Results)
 }
 
-func (f *fixedStorer) AddEntity(userID, entityID string) error {
-	return f.addEntityErr
+func TestUser_GetEntitiesBatch_err(t *testing.T) {
+	baseServer := bserver.NewBaseServer(bserver.NewDefaultBaseConfig())
+	cases := map[string]struct {
+		u        *User
+		rq       *api.GetEntitiesBatchRequest
+		expected error
+	}{
+		"bad rq": {
+			u:        &User{BaseServer: baseServer},
+			rq:       &api.GetEntitiesBatchRequest{},
+			expected: api.ErrEmptyBatch,
+		},
+		"get entities batch err": {
+			u: &User{
+				BaseServer: baseServer,
+				storer:     &fixedStorer{getEntitiesBatchErr: errTest},
+			},
+			rq:       &api.GetEntitiesBatchRequest{UserIds: []string{testUserID}},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		rp, err := c.u.GetEntitiesBatch(context.Background(), c.rq)
+		assert.Equal(t, statusErr(c.expected), err, desc)
+		assert.Nil(t, rp, desc)
+	}
 }
 
-func (f *fixedStorer) GetEntities(userID string) ([]string, error) {
-	return f.getEntitiesValue, f.getEntitiesErr
+func TestUser_GetUsers_ok(t *testing.T) {
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		storer: &fixedStorer{
+			getUsersValue: []string{"user ID 1", "user ID 2"},
+			getUsersNext:  "some next page token",
+		},
+	}
+	rq := &api.GetUsersRequest{
+		EntityId: testEntityID,
+	}
+	rp, err := u.GetUsers(context.Background(), rq)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user ID 1", "user ID 2"}, rp.UserIds)
+	assert.Equal(t, "some next page token", rp.NextPageToken)
+
+	// page size is unlimited when not given and is capped at the max
+	page := u.storer.(*fixedStorer).getUsersPage
+	assert.Equal(t, &storage.Page{}, page)
+	rq = &api.GetUsersRequest{
+		EntityId:  testEntityID,
+		PageSize:  api.MaxPageSize + 1,
+		PageToken: "some page token",
+	}
+	_, err = u.GetUsers(context.Background(), rq)
+	assert.Nil(t, err)
+	page = u.storer.(*fixedStorer).getUsersPage
+	assert.Equal(t, &storage.Page{Size: api.MaxPageSize, Token: "some page token"}, page)
 }
 
-func (f *fixedStorer) CountEntities(userID string) (int, error) {
-	return f.countEntitiesValue, f.countEntitiesErr
+func TestUser_GetUsers_err(t *testing.T) {
+	baseServer := bserver.NewBaseServer(bserver.NewDefaultBaseConfig())
+	cases := map[string]struct {
+		u        *User
+		rq       *api.GetUsersRequest
+		expected error
+	}{
+		"bad rq": {
+			u:        &User{BaseServer: baseServer},
+			rq:       &api.GetUsersRequest{},
+			expected: api.ErrEmptyEntityID,
+		},
+		"get users err": {
+			u: &User{
+				BaseServer: baseServer,
+				storer: &fixedStorer{
+					getUsersErr: errTest,
+				},
+			},
+			rq: &api.GetUsersRequest{
+				EntityId: testEntityID,
+			},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		_, err := c.u.GetUsers(context.Background(), c.rq)
+		assert.Equal(t, statusErr(c.expected), err, desc)
+	}
 }
 
-func (f *fixedStorer) CountUsers(entityID string) (int, error) {
-	return f.countUsersValue, f.countUsersErr
+func TestUser_GetAssociationHistory_ok(t *testing.T) {
+	now := time.Now()
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		storer: &fixedStorer{
+			getHistoryValue: []*storage.AssociationEvent{
+				{
+					Type:     api.EventType_ADDED,
+					Time:     now,
+					UserID:   testUserID,
+					EntityID: testEntityID,
+				},
+				{
+					Type:     api.EventType_REMOVED,
+					Time:     now.Add(time.Second),
+					UserID:   testUserID,
+					EntityID: testEntityID,
+				},
+			},
+		},
+	}
+	rq := &api.GetAssociationHistoryRequest{
+		EntityId: testEntityID,
+	}
+	rp, err := u.GetAssociationHistory(context.Background(), rq)
+	assert.Nil(t, err)
+	assert.Len(t, rp.Events, 2)
+	assert.Equal(t, api.EventType_ADDED, rp.Events[0].Type)
+	assert.Equal(t, api.EventType_REMOVED, rp.Events[1].Type)
+	assert.Equal(t, testUserID, rp.Events[0].UserId)
+	assert.Equal(t, testEntityID, rp.Events[0].EntityId)
+	addedTime, err := ptypes.Timestamp(rp.Events[0].Time)
+	assert.Nil(t, err)
+	assert.True(t, now.Equal(addedTime))
 }
 
-func (f *fixedStorer) Close() error {
+func TestUser_GetAssociationHistory_err(t *testing.T) {
+	baseServer := bserver.NewBaseServer(bserver.NewDefaultBaseConfig())
+	cases := map[string]struct {
+		u        *User
+		rq       *api.GetAssociationHistoryRequest
+		expected error
+	}{
+		"bad rq": {
+			u:        &User{BaseServer: baseServer},
+			rq:       &api.GetAssociationHistoryRequest{},
+			expected: api.ErrEmptyUserAndEntityID,
+		},
+		"get history err": {
+			u: &User{
+				BaseServer: baseServer,
+				storer: &fixedStorer{
+					getHistoryErr: errTest,
+				},
+			},
+			rq: &api.GetAssociationHistoryRequest{
+				UserId: testUserID,
+			},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		rp, err := c.u.GetAssociationHistory(context.Background(), c.rq)
+		assert.Equal(t, statusErr(c.expected), err, desc)
+		assert.Nil(t, rp, desc)
+	}
+}
+
+func TestUser_WatchEntities_ok(t *testing.T) {
+	now := time.Now()
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		storer: &fixedStorer{
+			watchValue: []*storage.AssociationEvent{
+				{
+					Type:     api.EventType_ADDED,
+					Time:     now,
+					UserID:   testUserID,
+					EntityID: testEntityID,
+					Revision: 3,
+				},
+				{
+					Type:     api.EventType_REMOVED,
+					Time:     now.Add(time.Second),
+					UserID:   testUserID,
+					EntityID: testEntityID,
+					Revision: 4,
+				},
+			},
+			watchErr: context.Canceled,
+		},
+	}
+	rq := &api.WatchEntitiesRequest{
+		UserId:       testUserID,
+		FromRevision: 2,
+	}
+	stream := &fixedWatchEntitiesServer{ctx: context.Background()}
+	err := u.WatchEntities(rq, stream)
+	assert.Equal(t, statusErr(context.Canceled), err)
+	assert.Len(t, stream.sent, 2)
+	assert.Equal(t, api.EventType_ADDED, stream.sent[0].Event.Type)
+	assert.Equal(t, uint64(3), stream.sent[0].Revision)
+	assert.Equal(t, api.EventType_REMOVED, stream.sent[1].Event.Type)
+	assert.Equal(t, uint64(4), stream.sent[1].Revision)
+	assert.Equal(t, testUserID, stream.sent[0].Event.UserId)
+	assert.Equal(t, testEntityID, stream.sent[0].Event.EntityId)
+}
+
+func TestUser_WatchEntities_err(t *testing.T) {
+	baseServer := bserver.NewBaseServer(bserver.NewDefaultBaseConfig())
+	events := []*storage.AssociationEvent{
+		{Type: api.EventType_ADDED, UserID: testUserID, EntityID: testEntityID, Revision: 1},
+	}
+	cases := map[string]struct {
+		u        *User
+		rq       *api.WatchEntitiesRequest
+		stream   *fixedWatchEntitiesServer
+		expected error
+	}{
+		"bad rq": {
+			u:        &User{BaseServer: baseServer},
+			rq:       &api.WatchEntitiesRequest{},
+			stream:   &fixedWatchEntitiesServer{},
+			expected: api.ErrEmptyUserAndEntityID,
+		},
+		"watch err": {
+			u: &User{
+				BaseServer: baseServer,
+				storer:     &fixedStorer{watchErr: storage.ErrRevisionCompacted},
+			},
+			rq:       &api.WatchEntitiesRequest{EntityId: testEntityID, FromRevision: 1},
+			stream:   &fixedWatchEntitiesServer{},
+			expected: storage.ErrRevisionCompacted,
+		},
+		"send err": {
+			u: &User{
+				BaseServer: baseServer,
+				storer:     &fixedStorer{watchValue: events},
+			},
+			rq:       &api.WatchEntitiesRequest{UserId: testUserID},
+			stream:   &fixedWatchEntitiesServer{sendErr: errTest},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		c.stream.ctx = context.Background()
+		err := c.u.WatchEntities(c.rq, c.stream)
+		assert.Equal(t, statusErr(c.expected), err, desc)
+		assert.Empty(t, c.stream.sent, desc)
+	}
+}
+
+func TestUser_SetUserLimit_ok(t *testing.T) {
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		storer:     &fixedStorer{},
+	}
+	rq := &api.SetUserLimitRequest{
+		UserId:      testUserID,
+		MaxEntities: 32,
+	}
+	rp, err := u.SetUserLimit(context.Background(), rq)
+	assert.Nil(t, err)
+	assert.NotNil(t, rp)
+}
+
+func TestUser_SetUserLimit_err(t *testing.T) {
+	baseServer := bserver.NewBaseServer(bserver.NewDefaultBaseConfig())
+	cases := map[string]struct {
+		u        *User
+		rq       *api.SetUserLimitRequest
+		expected error
+	}{
+		"bad rq": {
+			u:        &User{BaseServer: baseServer},
+			rq:       &api.SetUserLimitRequest{MaxEntities: 32},
+			expected: api.ErrEmptyUserID,
+		},
+		"set user limit err": {
+			u: &User{
+				BaseServer: baseServer,
+				storer:     &fixedStorer{setUserLimitErr: errTest},
+			},
+			rq:       &api.SetUserLimitRequest{UserId: testUserID, MaxEntities: 32},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		rp, err := c.u.SetUserLimit(context.Background(), c.rq)
+		assert.Equal(t, statusErr(c.expected), err, desc)
+		assert.Nil(t, rp, desc)
+	}
+}
+
+func TestUser_SetEntityLimit_ok(t *testing.T) {
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		storer:     &fixedStorer{},
+	}
+	rq := &api.SetEntityLimitRequest{
+		EntityId: testEntityID,
+		MaxUsers: 1024,
+	}
+	rp, err := u.SetEntityLimit(context.Background(), rq)
+	assert.Nil(t, err)
+	assert.NotNil(t, rp)
+}
+
+func TestUser_SetEntityLimit_err(t *testing.T) {
+	baseServer := bserver.NewBaseServer(bserver.NewDefaultBaseConfig())
+	cases := map[string]struct {
+		u        *User
+		rq       *api.SetEntityLimitRequest
+		expected error
+	}{
+		"bad rq": {
+			u:        &User{BaseServer: baseServer},
+			rq:       &api.SetEntityLimitRequest{MaxUsers: 1024},
+			expected: api.ErrEmptyEntityID,
+		},
+		"set entity limit err": {
+			u: &User{
+				BaseServer: baseServer,
+				storer:     &fixedStorer{setEntityLimitErr: errTest},
+			},
+			rq:       &api.SetEntityLimitRequest{EntityId: testEntityID, MaxUsers: 1024},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		rp, err := c.u.SetEntityLimit(context.Background(), c.rq)
+		assert.Equal(t, statusErr(c.expected), err, desc)
+		assert.Nil(t, rp, desc)
+	}
+}
+
+func TestUser_UpdateRole_ok(t *testing.T) {
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		config:     NewDefaultConfig(),
+		storer:     memory.New(storage.NewDefaultParameters(), zap.NewNop()),
+	}
+	_, err := u.AddEntity(context.Background(), &api.AddEntityRequest{
+		UserId:   testUserID,
+		EntityId: testEntityID,
+		Role:     api.Role_CLINICIAN,
+	})
+	assert.Nil(t, err)
+
+	rq := &api.UpdateRoleRequest{UserId: testUserID, EntityId: testEntityID, Role: api.Role_ADMIN}
+	_, err = u.UpdateRole(context.Background(), rq)
+	assert.Nil(t, err)
+
+	rp, err := u.GetEntities(context.Background(), &api.GetEntitiesRequest{UserId: testUserID})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{testEntityID}, rp.EntityIds)
+	assert.Equal(t, []api.Role{api.Role_ADMIN}, rp.Roles)
+
+	historyRp, err := u.GetAssociationHistory(context.Background(),
+		&api.GetAssociationHistoryRequest{UserId: testUserID})
+	assert.Nil(t, err)
+	assert.Len(t, historyRp.Events, 2)
+	assert.Equal(t, api.EventType_ADDED, historyRp.Events[0].Type)
+	assert.Equal(t, api.Role_CLINICIAN, historyRp.Events[0].Role)
+	assert.Equal(t, api.EventType_ROLE_UPDATED, historyRp.Events[1].Type)
+	assert.Equal(t, api.Role_ADMIN, historyRp.Events[1].Role)
+}
+
+func TestUser_UpdateRole_err(t *testing.T) {
+	baseServer := bserver.NewBaseServer(bserver.NewDefaultBaseConfig())
+	okRq := &api.UpdateRoleRequest{UserId: testUserID, EntityId: testEntityID, Role: api.Role_ADMIN}
+	cases := map[string]struct {
+		u        *User
+		rq       *api.UpdateRoleRequest
+		expected error
+	}{
+		"bad rq": {
+			u:        &User{BaseServer: baseServer},
+			rq:       &api.UpdateRoleRequest{UserId: testUserID, EntityId: testEntityID},
+			expected: api.ErrEmptyRole,
+		},
+		"not exists": {
+			u: &User{
+				BaseServer: baseServer,
+				storer: &fixedStorer{
+					updateRoleErr: storage.ErrUserEntityNotExists,
+				},
+			},
+			rq:       okRq,
+			expected: storage.ErrUserEntityNotExists,
+		},
+		"update role err": {
+			u: &User{
+				BaseServer: baseServer,
+				storer: &fixedStorer{
+					updateRoleErr: errTest,
+				},
+			},
+			rq:       okRq,
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		_, err := c.u.UpdateRole(context.Background(), c.rq)
+		assert.Equal(t, statusErr(c.expected), err, desc)
+	}
+}
+
+func TestUser_PutGetUpdateUser_ok(t *testing.T) {
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		config:     NewDefaultConfig(),
+		storer:     memory.New(storage.NewDefaultParameters(), zap.NewNop()),
+	}
+	profile := &api.UserProfile{
+		UserId:      testUserID,
+		DisplayName: "Some User",
+		Email:       "some.user@example.com",
+		Attributes:  map[string]string{"key1": "value1"},
+	}
+	_, err := u.PutUser(context.Background(), &api.PutUserRequest{User: profile})
+	assert.Nil(t, err)
+
+	getRp, err := u.GetUser(context.Background(), &api.GetUserRequest{UserId: testUserID})
+	assert.Nil(t, err)
+	assert.Equal(t, profile, getRp.User)
+
+	updateRp, err := u.UpdateUser(context.Background(), &api.UpdateUserRequest{
+		User: &api.UserProfile{UserId: testUserID, Timezone: "America/New_York"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "America/New_York", updateRp.User.Timezone)
+	assert.Equal(t, profile.DisplayName, updateRp.User.DisplayName)
+}
+
+func TestUser_PutGetUpdateUser_err(t *testing.T) {
+	baseServer := bserver.NewBaseServer(bserver.NewDefaultBaseConfig())
+	okProfile := &api.UserProfile{UserId: testUserID}
+	cases := map[string]struct {
+		u        *User
+		put      *api.PutUserRequest
+		get      *api.GetUserRequest
+		update   *api.UpdateUserRequest
+		expected error
+	}{
+		"bad rq": {
+			u:        &User{BaseServer: baseServer},
+			put:      &api.PutUserRequest{User: &api.UserProfile{}},
+			get:      &api.GetUserRequest{},
+			update:   &api.UpdateUserRequest{User: &api.UserProfile{}},
+			expected: api.ErrEmptyUserID,
+		},
+		"not exists": {
+			u: &User{
+				BaseServer: baseServer,
+				storer: &fixedStorer{
+					putUserErr:    storage.ErrUserNotExists,
+					getUserErr:    storage.ErrUserNotExists,
+					updateUserErr: storage.ErrUserNotExists,
+				},
+			},
+			put:      &api.PutUserRequest{User: okProfile},
+			get:      &api.GetUserRequest{UserId: testUserID},
+			update:   &api.UpdateUserRequest{User: okProfile},
+			expected: storage.ErrUserNotExists,
+		},
+		"storer err": {
+			u: &User{
+				BaseServer: baseServer,
+				storer: &fixedStorer{
+					putUserErr:    errTest,
+					getUserErr:    errTest,
+					updateUserErr: errTest,
+				},
+			},
+			put:      &api.PutUserRequest{User: okProfile},
+			get:      &api.GetUserRequest{UserId: testUserID},
+			update:   &api.UpdateUserRequest{User: okProfile},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		putRp, err := c.u.PutUser(context.Background(), c.put)
+		assert.Equal(t, statusErr(c.expected), err, desc)
+		assert.Nil(t, putRp, desc)
+
+		getRp, err := c.u.GetUser(context.Background(), c.get)
+		assert.Equal(t, statusErr(c.expected), err, desc)
+		assert.Nil(t, getRp, desc)
+
+		updateRp, err := c.u.UpdateUser(context.Background(), c.update)
+		assert.Equal(t, statusErr(c.expected), err, desc)
+		assert.Nil(t, updateRp, desc)
+	}
+}
+
+func TestUser_ExportAssociations_ok(t *testing.T) {
+	now := time.Now()
+	u := &User{
+		BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+		storer: &fixedStorer{
+			exportValue: [][]*storage.AssociationVersion{
+				{
+					{UserID: testUserID, EntityID: testEntityID, Role: api.Role_OWNER,
+						AddedTime: now, Removed: true, RemovedTime: now.Add(time.Second),
+						Superseded: true},
+					{UserID: testUserID, EntityID: testEntityID, Role: api.Role_ADMIN,
+						AddedTime: now.Add(time.Second), RoleUpdated: true},
+				},
+				{
+					{UserID: testUserID, EntityID: "other entity ID", AddedTime: now},
+				},
+			},
+		},
+	}
+	stream := &fixedExportAssociationsServer{}
+	err := u.ExportAssociations(&api.ExportAssociationsRequest{}, stream)
+	assert.Nil(t, err)
+	assert.Len(t, stream.sent, 2)
+
+	r := stream.sent[0].Association
+	assert.Equal(t, testUserID, r.UserId)
+	assert.Equal(t, testEntityID, r.EntityId)
+	assert.Len(t, r.Versions, 2)
+	assert.Equal(t, api.Role_OWNER, r.Versions[0].Role)
+	assert.True(t, r.Versions[0].Superseded)
+	assert.NotNil(t, r.Versions[0].RemovedTime)
+	assert.Equal(t, api.Role_ADMIN, r.Versions[1].Role)
+	assert.True(t, r.Versions[1].RoleUpdated)
+	assert.Nil(t, r.Versions[1].RemovedTime)
+	versions, err := fromAPIRecord(r)
+	assert.Nil(t, err)
+	assert.True(t, now.Equal(versions[0].AddedTime))
+	assert.True(t, now.Add(time.Second).Equal(versions[0].RemovedTime))
+	assert.Equal(t, "other entity ID", stream.sent[1].Association.EntityId)
+}
+
+func TestUser_ExportAssociations_err(t *testing.T) {
+	baseServer := bserver.NewBaseServer(bserver.NewDefaultBaseConfig())
+	versions := []*storage.AssociationVersion{
+		{UserID: testUserID, EntityID: testEntityID, AddedTime: time.Now()},
+	}
+	cases := map[string]struct {
+		storer   *fixedStorer
+		stream   *fixedExportAssociationsServer
+		expected error
+	}{
+		"export err": {
+			storer:   &fixedStorer{exportErr: context.Canceled},
+			stream:   &fixedExportAssociationsServer{},
+			expected: context.Canceled,
+		},
+		"send err": {
+			storer: &fixedStorer{
+				exportValue: [][]*storage.AssociationVersion{versions},
+			},
+			stream:   &fixedExportAssociationsServer{sendErr: errTest},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		u := &User{BaseServer: baseServer, storer: c.storer}
+		err := u.ExportAssociations(&api.ExportAssociationsRequest{}, c.stream)
+		assert.Equal(t, statusErr(c.expected), err, desc)
+		assert.Empty(t, c.stream.sent, desc)
+	}
+}
+
+func TestUser_ImportAssociations_ok(t *testing.T) {
+	t0 := ptypes.TimestampNow()
+	t1 := &timestamp.Timestamp{Seconds: t0.Seconds + 1}
+	newRecord := func(entityID string) *api.AssociationRecord {
+		return &api.AssociationRecord{
+			UserId:   testUserID,
+			EntityId: entityID,
+			Versions: []*api.AssociationVersion{
+				{Role: api.Role_OWNER, AddedTime: t0, RemovedTime: t1, Superseded: true},
+				{Role: api.Role_ADMIN, AddedTime: t1, RoleUpdated: true},
+			},
+		}
+	}
+	cases := map[string]struct {
+		opts          *api.ImportOptions
+		expected      *api.ImportAssociationsResponse
+		expectedErr   error
+		expectedRoles map[string]api.Role
+	}{
+		"skip": {
+			opts:     &api.ImportOptions{ConflictMode: api.ConflictMode_SKIP},
+			expected: &api.ImportAssociationsResponse{NImported: 1, NSkipped: 1},
+			expectedRoles: map[string]api.Role{
+				"entity 1": api.Role_CLINICIAN,
+				"entity 2": api.Role_ADMIN,
+			},
+		},
+		"overwrite": {
+			opts:     &api.ImportOptions{ConflictMode: api.ConflictMode_OVERWRITE},
+			expected: &api.ImportAssociationsResponse{NImported: 1, NOverwritten: 1},
+			expectedRoles: map[string]api.Role{
+				"entity 1": api.Role_ADMIN,
+				"entity 2": api.Role_ADMIN,
+			},
+		},
+		"dry run": {
+			opts: &api.ImportOptions{
+				ConflictMode: api.ConflictMode_OVERWRITE,
+				DryRun:       true,
+			},
+			expected: &api.ImportAssociationsResponse{NImported: 1, NOverwritten: 1},
+			expectedRoles: map[string]api.Role{
+				"entity 1": api.Role_CLINICIAN,
+			},
+		},
+		"fail": {
+			opts:        &api.ImportOptions{ConflictMode: api.ConflictMode_FAIL},
+			expectedErr: importConflictErr(testUserID, "entity 1"),
+			expectedRoles: map[string]api.Role{
+				"entity 1": api.Role_CLINICIAN,
+			},
+		},
+	}
+	for desc, c := range cases {
+		u := &User{
+			BaseServer: bserver.NewBaseServer(bserver.NewDefaultBaseConfig()),
+			storer:     memory.New(storage.NewDefaultParameters(), zap.NewNop()),
+		}
+		err := u.storer.AddEntity(context.Background(), testUserID, "entity 1",
+			api.Role_CLINICIAN, &storage.Limits{MaxUserEntities: 8, MaxEntityUsers: 8})
+		assert.Nil(t, err, desc)
+		stream := &fixedImportAssociationsServer{
+			rqs: []*api.ImportAssociationsRequest{
+				{Options: c.opts},
+				{Associations: []*api.AssociationRecord{newRecord("entity 2")}},
+				{Associations: []*api.AssociationRecord{newRecord("entity 1")}},
+			},
+		}
+		err = u.ImportAssociations(stream)
+		assert.Equal(t, c.expectedErr, err, desc)
+		assert.Equal(t, c.expected, stream.rp, desc)
+
+		as, _, err := u.storer.GetEntities(context.Background(), testUserID, nil)
+		assert.Nil(t, err, desc)
+		roles := make(map[string]api.Role)
+		for _, a := range as {
+			roles[a.EntityID] = a.Role
+		}
+		assert.Equal(t, c.expectedRoles, roles, desc)
+	}
+}
+
+func TestUser_ImportAssociations_err(t *testing.T) {
+	baseServer := bserver.NewBaseServer(bserver.NewDefaultBaseConfig())
+	okOptions := &api.ImportOptions{ConflictMode: api.ConflictMode_FAIL}
+	okRecord := &api.AssociationRecord{
+		UserId:   testUserID,
+		EntityId: testEntityID,
+		Versions: []*api.AssociationVersion{{AddedTime: ptypes.TimestampNow()}},
+	}
+	tooLarge := make([]*api.ImportAssociationsRequest, api.MaxImportSize/api.MaxBatchSize+1)
+	for i := range tooLarge {
+		tooLarge[i] = &api.ImportAssociationsRequest{Options: okOptions}
+		for j := 0; j < api.MaxBatchSize; j++ {
+			tooLarge[i].Associations = append(tooLarge[i].Associations,
+				&api.AssociationRecord{
+					UserId:   fmt.Sprintf("user %d", i),
+					EntityId: fmt.Sprintf("entity %d", j),
+					Versions: okRecord.Versions,
+				})
+		}
+	}
+	cases := map[string]struct {
+		storer   *fixedStorer
+		stream   *fixedImportAssociationsServer
+		expected error
+	}{
+		"no rqs": {
+			storer:   &fixedStorer{},
+			stream:   &fixedImportAssociationsServer{},
+			expected: statusErr(api.ErrInvalidConflictMode),
+		},
+		"bad first rq": {
+			storer: &fixedStorer{},
+			stream: &fixedImportAssociationsServer{
+				rqs: []*api.ImportAssociationsRequest{
+					{Associations: []*api.AssociationRecord{okRecord}},
+				},
+			},
+			expected: statusErr(api.ErrInvalidConflictMode),
+		},
+		"bad record": {
+			storer: &fixedStorer{},
+			stream: &fixedImportAssociationsServer{
+				rqs: []*api.ImportAssociationsRequest{
+					{Options: okOptions, Associations: []*api.AssociationRecord{{}}},
+				},
+			},
+			expected: statusErr(api.ErrEmptyEntityID),
+		},
+		"duplicate record": {
+			storer: &fixedStorer{importErr: errTest},
+			stream: &fixedImportAssociationsServer{
+				rqs: []*api.ImportAssociationsRequest{
+					{
+						Options: &api.ImportOptions{
+							ConflictMode: api.ConflictMode_OVERWRITE,
+						},
+						Associations: []*api.AssociationRecord{okRecord},
+					},
+					{Associations: []*api.AssociationRecord{okRecord}},
+				},
+			},
+			expected: statusErr(api.ErrDuplicateAssociation),
+		},
+		"too many records": {
+			storer:   &fixedStorer{importErr: errTest},
+			stream:   &fixedImportAssociationsServer{rqs: tooLarge},
+			expected: statusErr(api.ErrImportTooLarge),
+		},
+		"recv err": {
+			storer: &fixedStorer{},
+			stream: &fixedImportAssociationsServer{
+				rqs:     []*api.ImportAssociationsRequest{{Options: okOptions}},
+				recvErr: statusErr(context.Canceled),
+			},
+			expected: statusErr(context.Canceled),
+		},
+		"conflict": {
+			storer: &fixedStorer{importErr: storage.ErrUserEntityExists},
+			stream: &fixedImportAssociationsServer{
+				rqs: []*api.ImportAssociationsRequest{
+					{Options: okOptions, Associations: []*api.AssociationRecord{okRecord}},
+				},
+			},
+			expected: importConflictErr(testUserID, testEntityID),
+		},
+		"existing conflict": {
+			storer: &fixedStorer{
+				getHistoryValue: []*storage.AssociationEvent{{Type: api.EventType_ADDED}},
+			},
+			stream: &fixedImportAssociationsServer{
+				rqs: []*api.ImportAssociationsRequest{
+					{Options: okOptions, Associations: []*api.AssociationRecord{okRecord}},
+				},
+			},
+			expected: importConflictErr(testUserID, testEntityID),
+		},
+		"dry run conflict": {
+			storer: &fixedStorer{
+				getHistoryValue: []*storage.AssociationEvent{{Type: api.EventType_ADDED}},
+			},
+			stream: &fixedImportAssociationsServer{
+				rqs: []*api.ImportAssociationsRequest{{
+					Options: &api.ImportOptions{
+						ConflictMode: api.ConflictMode_FAIL,
+						DryRun:       true,
+					},
+					Associations: []*api.AssociationRecord{okRecord},
+				}},
+			},
+			expected: importConflictErr(testUserID, testEntityID),
+		},
+		"get history err": {
+			storer: &fixedStorer{getHistoryErr: errTest},
+			stream: &fixedImportAssociationsServer{
+				rqs: []*api.ImportAssociationsRequest{
+					{Options: okOptions, Associations: []*api.AssociationRecord{okRecord}},
+				},
+			},
+			expected: statusErr(errTest),
+		},
+		"import err": {
+			storer: &fixedStorer{importErr: errTest},
+			stream: &fixedImportAssociationsServer{
+				rqs: []*api.ImportAssociationsRequest{
+					{Options: okOptions, Associations: []*api.AssociationRecord{okRecord}},
+				},
+			},
+			expected: statusErr(errTest),
+		},
+	}
+	for desc, c := range cases {
+		u := &User{BaseServer: baseServer, storer: c.storer}
+		err := u.ImportAssociations(c.stream)
+		assert.Equal(t, c.expected, err, desc)
+		assert.Nil(t, c.stream.rp, desc)
+	}
+}
+
+type fixedStorer struct {
+	addEntityErr         error
+	removeEntityErr      error
+	getEntitiesValue     []*storage.Association
+	getEntitiesNext      string
+	getEntitiesPage      *storage.Page
+	getEntitiesErr       error
+	getEntitiesAsOfValue []*storage.Association
+	getEntitiesAsOfErr   error
+	getUsersValue        []string
+	getUsersNext         string
+	getUsersPage         *storage.Page
+	getUsersErr          error
+	countEntitiesValue   int
+	countEntitiesErr     error
+	countUsersValue      int
+	countUsersErr        error
+	getHistoryValue      []*storage.AssociationEvent
+	getHistoryErr        error
+	setUserLimitErr      error
+	setEntityLimitErr    error
+	addEntitiesValue     []error
+	addEntitiesErr       error
+	getEntitiesBatch     map[string][]string
+	getEntitiesBatchErr  error
+	updateRoleErr        error
+	putUserErr           error
+	getUserValue         *api.UserProfile
+	getUserErr           error
+	updateUserValue      *api.UserProfile
+	updateUserErr        error
+	watchValue           []*storage.AssociationEvent
+	watchErr             error
+	exportValue          [][]*storage.AssociationVersion
+	exportErr            error
+	importErr            error
+}
+
+func (f *fixedStorer) AddEntity(
+	ctx context.Context, userID, entityID string, role api.Role, limits *storage.Limits,
+) error {
+	return f.addEntityErr
+}
+
+func (f *fixedStorer) AddEntities(
+	ctx context.Context, as []*storage.Association, limits *storage.Limits,
+) ([]error, error) {
+	return f.addEntitiesValue, f.addEntitiesErr
+}
+
+func (f *fixedStorer) RemoveEntity(ctx context.Context, userID, entityID string) error {
+	return f.removeEntityErr
+}
+
+func (f *fixedStorer) GetEntities(
+	ctx context.Context, userID string, page *storage.Page,
+) ([]*storage.Association, string, error) {
+	f.getEntitiesPage = page
+	return f.getEntitiesValue, f.getEntitiesNext, f.getEntitiesErr
+}
+
+func (f *fixedStorer) GetEntitiesAsOf(
+	ctx context.Context, userID string, asOf time.Time, page *storage.Page,
+) ([]*storage.Association, string, error) {
+	return f.getEntitiesAsOfValue, "", f.getEntitiesAsOfErr
+}
+
+func (f *fixedStorer) GetEntitiesBatch(
+	ctx context.Context, userIDs []string,
+) (map[string][]string, error) {
+	return f.getEntitiesBatch, f.getEntitiesBatchErr
+}
+
+func (f *fixedStorer) UpdateRole(
+	ctx context.Context, userID, entityID string, role api.Role,
+) error {
+	return f.updateRoleErr
+}
+
+func (f *fixedStorer) PutUser(ctx context.Context, u *api.UserProfile) error {
+	return f.putUserErr
+}
+
+func (f *fixedStorer) GetUser(ctx context.Context, userID string) (*api.UserProfile, error) {
+	return f.getUserValue, f.getUserErr
+}
+
+func (f *fixedStorer) UpdateUser(
+	ctx context.Context, u *api.UserProfile,
+) (*api.UserProfile, error) {
+	return f.updateUserValue, f.updateUserErr
+}
+
+func (f *fixedStorer) GetUsers(
+	ctx context.Context, entityID string, page *storage.Page,
+) ([]string, string, error) {
+	f.getUsersPage = page
+	return f.getUsersValue, f.getUsersNext, f.getUsersErr
+}
+
+func (f *fixedStorer) CountEntities(ctx context.Context, userID string) (int, error) {
+	return f.countEntitiesValue, f.countEntitiesErr
+}
+
+func (f *fixedStorer) CountUsers(ctx context.Context, entityID string) (int, error) {
+	return f.countUsersValue, f.countUsersErr
+}
+
+func (f *fixedStorer) GetHistory(
+	ctx context.Context, userID, entityID string,
+) ([]*storage.AssociationEvent, error) {
+	return f.getHistoryValue, f.getHistoryErr
+}
+
+func (f *fixedStorer) SetUserLimit(ctx context.Context, userID string, maxEntities int) error {
+	return f.setUserLimitErr
+}
+
+func (f *fixedStorer) SetEntityLimit(ctx context.Context, entityID string, maxUsers int) error {
+	return f.setEntityLimitErr
+}
+
+func (f *fixedStorer) Watch(
+	ctx context.Context,
+	userID, entityID string,
+	fromRevision uint64,
+	send func(*storage.AssociationEvent) error,
+) error {
+	for _, e := range f.watchValue {
+		if err := send(e); err != nil {
+			return err
+		}
+	}
+	return f.watchErr
+}
+
+func (f *fixedStorer) ExportAssociations(
+	ctx context.Context, send func([]*storage.AssociationVersion) error,
+) error {
+	for _, versions := range f.exportValue {
+		if err := send(versions); err != nil {
+			return err
+		}
+	}
+	return f.exportErr
+}
+
+func (f *fixedStorer) ImportAssociation(
+	ctx context.Context, versions []*storage.AssociationVersion, overwrite bool,
+) (bool, error) {
+	return false, f.importErr
+}
+
+func (f *fixedStorer) Close() error {
+	return nil
+}
+
+type fixedWatchEntitiesServer struct {
+	grpc.ServerStream
+	ctx     context.Context
+	sendErr error
+	sent    []*api.WatchEntitiesResponse
+}
+
+func (f *fixedWatchEntitiesServer) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fixedWatchEntitiesServer) Send(rp *api.WatchEntitiesResponse) error {
+	if f.sendErr != nil {
+		return f.sendErr
+	}
+	f.sent = append(f.sent, rp)
+	return nil
+}
+
+type fixedExportAssociationsServer struct {
+	grpc.ServerStream
+	sendErr error
+	sent    []*api.ExportAssociationsResponse
+}
+
+func (f *fixedExportAssociationsServer) Context() context.Context {
+	return context.Background()
+}
+
+func (f *fixedExportAssociationsServer) Send(rp *api.ExportAssociationsResponse) error {
+	if f.sendErr != nil {
+		return f.sendErr
+	}
+	f.sent = append(f.sent, rp)
+	return nil
+}
+
+type fixedImportAssociationsServer struct {
+	grpc.ServerStream
+	rqs     []*api.ImportAssociationsRequest
+	recvErr error
+	rp      *api.ImportAssociationsResponse
+}
+
+func (f *fixedImportAssociationsServer) Context() context.Context {
+	return context.Background()
+}
+
+func (f *fixedImportAssociationsServer) Recv() (*api.ImportAssociationsRequest, error) {
+	if len(f.rqs) == 0 {
+		if f.recvErr != nil {
+			return nil, f.recvErr
+		}
+		return nil, io.EOF
+	}
+	rq := f.rqs[0]
+	f.rqs = f.rqs[1:]
+	return rq, nil
+}
+
+func (f *fixedImportAssociationsServer) SendAndClose(rp *api.ImportAssociationsResponse) error {
+	f.rp = rp
 	return nil
 }
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+
+	"github.com/elixirhealth/user/pkg/server/storage"
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// invalidFields maps each request validation error to the request fields it concerns.
+var invalidFields = map[error][]string{
+	api.ErrEmptyUserID:          {"user_id"},
+	api.ErrEmptyEntityID:        {"entity_id"},
+	api.ErrEmptyUserAndEntityID: {"user_id", "entity_id"},
+	api.ErrInvalidAsOf:          {"as_of"},
+	api.ErrEmptyRole:            {"role"},
+	api.ErrInvalidRole:          {"role"},
+	api.ErrInvalidPageToken:     {"page_token"},
+	api.ErrEmptyUser:            {"user"},
+	api.ErrInvalidEmail:         {"user.email"},
+	api.ErrEmptyAttributeKey:    {"user.attributes"},
+	api.ErrEmptyBatch:           {},
+	api.ErrBatchTooLarge:        {},
+	api.ErrInvalidConflictMode:  {"options.conflict_mode"},
+	api.ErrEmptyVersions:        {"associations.versions"},
+	api.ErrInvalidVersions:      {"associations.versions"},
+	api.ErrDuplicateAssociation: {"associations"},
+	api.ErrImportTooLarge:       {},
+}
+
+// quotaSubjects maps each limit error to the QuotaFailure subject of the limit it hit.
+var quotaSubjects = map[error]string{
+	storage.ErrTooManyUserEntities: api.UserEntitiesQuotaSubject,
+	storage.ErrTooManyEntityUsers:  api.EntityUsersQuotaSubject,
+}
+
+// statusErr converts an error from validating or handling a request into a gRPC status error with
+// the matching code and details describing it. Errors that are already statuses are returned as
+// is, and any others without a matching code become Unknown.
+func statusErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return toStatus(err).Err()
+}
+
+// statusCode returns the gRPC status code statusErr would give the error.
+func statusCode(err error) codes.Code {
+	st, _ := status.FromError(statusErr(err))
+	return st.Code()
+}
+
+func toStatus(err error) *status.Status {
+	if fields, in := invalidFields[err]; in {
+		if len(fields) == 0 {
+			return status.New(codes.InvalidArgument, err.Error())
+		}
+		br := &errdetails.BadRequest{}
+		for _, field := range fields {
+			br.FieldViolations = append(br.FieldViolations,
+				&errdetails.BadRequest_FieldViolation{
+					Field:       field,
+					Description: err.Error(),
+				})
+		}
+		return withDetails(codes.InvalidArgument, err, br)
+	}
+	if subject, in := quotaSubjects[err]; in {
+		return withDetails(codes.ResourceExhausted, err, &errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{
+				{Subject: subject, Description: err.Error()},
+			},
+		})
+	}
+	switch err {
+	case storage.ErrUserEntityExists:
+		return withDetails(codes.AlreadyExists, err, &errdetails.ResourceInfo{
+			ResourceType: api.UserEntityResourceType,
+			Description:  err.Error(),
+		})
+	case storage.ErrUserEntityNotExists:
+		return withDetails(codes.NotFound, err, &errdetails.ResourceInfo{
+			ResourceType: api.UserEntityResourceType,
+			Description:  err.Error(),
+		})
+	case storage.ErrUserNotExists:
+		return withDetails(codes.NotFound, err, &errdetails.ResourceInfo{
+			ResourceType: api.UserResourceType,
+			Description:  err.Error(),
+		})
+	case storage.ErrRevisionCompacted, storage.ErrFutureRevision:
+		return status.New(codes.OutOfRange, err.Error())
+	case storage.ErrWatchInterrupted:
+		return status.New(codes.Aborted, err.Error())
+	case context.DeadlineExceeded:
+		return status.New(codes.DeadlineExceeded, err.Error())
+	case context.Canceled:
+		return status.New(codes.Canceled, err.Error())
+	}
+	return status.New(codes.Unknown, err.Error())
+}
+
+// importConflictErr returns the AlreadyExists status error for an imported association that
+// already exists, naming the association by its user and entity IDs.
+func importConflictErr(userID, entityID string) error {
+	err := storage.ErrUserEntityExists
+	return withDetails(codes.AlreadyExists, err, &errdetails.ResourceInfo{
+		ResourceType: api.UserEntityResourceType,
+		ResourceName: userID + "/" + entityID,
+		Description:  err.Error(),
+	}).Err()
+}
+
+// withDetails creates a status with the given code and details, falling back to one without the
+// details if they can't be attached.
+func withDetails(code codes.Code, err error, details ...proto.Message) *status.Status {
+	st := status.New(code, err.Error())
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		return withDetails
+	}
+	return st
+}
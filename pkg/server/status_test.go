@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/elixirhealth/user/pkg/server/storage"
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatusErr(t *testing.T) {
+	cases := map[string]struct {
+		err     error
+		code    codes.Code
+		details []proto.Message
+	}{
+		"empty user ID": {
+			err:  api.ErrEmptyUserID,
+			code: codes.InvalidArgument,
+			details: []proto.Message{&errdetails.BadRequest{
+				FieldViolations: []*errdetails.BadRequest_FieldViolation{
+					{Field: "user_id", Description: api.ErrEmptyUserID.Error()},
+				},
+			}},
+		},
+		"empty batch": {
+			err:  api.ErrEmptyBatch,
+			code: codes.InvalidArgument,
+		},
+		"too many user entities": {
+			err:  storage.ErrTooManyUserEntities,
+			code: codes.ResourceExhausted,
+			details: []proto.Message{&errdetails.QuotaFailure{
+				Violations: []*errdetails.QuotaFailure_Violation{{
+					Subject:     api.UserEntitiesQuotaSubject,
+					Description: storage.ErrTooManyUserEntities.Error(),
+				}},
+			}},
+		},
+		"too many entity users": {
+			err:  storage.ErrTooManyEntityUsers,
+			code: codes.ResourceExhausted,
+			details: []proto.Message{&errdetails.QuotaFailure{
+				Violations: []*errdetails.QuotaFailure_Violation{{
+					Subject:     api.EntityUsersQuotaSubject,
+					Description: storage.ErrTooManyEntityUsers.Error(),
+				}},
+			}},
+		},
+		"exists": {
+			err:  storage.ErrUserEntityExists,
+			code: codes.AlreadyExists,
+			details: []proto.Message{&errdetails.ResourceInfo{
+				ResourceType: api.UserEntityResourceType,
+				Description:  storage.ErrUserEntityExists.Error(),
+			}},
+		},
+		"not exists": {
+			err:  storage.ErrUserEntityNotExists,
+			code: codes.NotFound,
+			details: []proto.Message{&errdetails.ResourceInfo{
+				ResourceType: api.UserEntityResourceType,
+				Description:  storage.ErrUserEntityNotExists.Error(),
+			}},
+		},
+		"invalid email": {
+			err:  api.ErrInvalidEmail,
+			code: codes.InvalidArgument,
+			details: []proto.Message{&errdetails.BadRequest{
+				FieldViolations: []*errdetails.BadRequest_FieldViolation{
+					{Field: "user.email", Description: api.ErrInvalidEmail.Error()},
+				},
+			}},
+		},
+		"user not exists": {
+			err:  storage.ErrUserNotExists,
+			code: codes.NotFound,
+			details: []proto.Message{&errdetails.ResourceInfo{
+				ResourceType: api.UserResourceType,
+				Description:  storage.ErrUserNotExists.Error(),
+			}},
+		},
+		"revision compacted": {
+			err:  storage.ErrRevisionCompacted,
+			code: codes.OutOfRange,
+		},
+		"future revision": {
+			err:  storage.ErrFutureRevision,
+			code: codes.OutOfRange,
+		},
+		"watch interrupted": {
+			err:  storage.ErrWatchInterrupted,
+			code: codes.Aborted,
+		},
+		"deadline exceeded": {
+			err:  context.DeadlineExceeded,
+			code: codes.DeadlineExceeded,
+		},
+		"canceled": {
+			err:  context.Canceled,
+			code: codes.Canceled,
+		},
+		"already status": {
+			err:  status.Error(codes.Unavailable, "some error"),
+			code: codes.Unavailable,
+		},
+		"other": {
+			err:  errors.New("some error"),
+			code: codes.Unknown,
+		},
+	}
+	for desc, c := range cases {
+		st, ok := status.FromError(statusErr(c.err))
+		assert.True(t, ok, desc)
+		assert.Equal(t, c.code, st.Code(), desc)
+		if c.details == nil {
+			assert.Empty(t, st.Details(), desc)
+			continue
+		}
+		assert.Equal(t, len(c.details), len(st.Details()), desc)
+		for i, d := range st.Details() {
+			assert.True(t, proto.Equal(c.details[i], d.(proto.Message)), desc)
+		}
+	}
+
+	assert.Nil(t, statusErr(nil))
+}
@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultRetainedEvents is the default number of recent events a Broadcaster retains for
+	// watches resuming from a revision.
+	DefaultRetainedEvents = 1024
+
+	// subscriptionBufferSize is the number of events a subscription can buffer before it's
+	// interrupted for falling behind.
+	subscriptionBufferSize = 64
+)
+
+var (
+	// ErrRevisionCompacted indicates when watching from a revision whose following events are no
+	// longer retained.
+	ErrRevisionCompacted = errors.New("events after revision are no longer retained")
+
+	// ErrFutureRevision indicates when watching from a revision after the latest event, e.g.,
+	// one from before a server restarted or from another server with its own revisions.
+	ErrFutureRevision = errors.New("revision is after the latest event")
+
+	// ErrWatchInterrupted indicates when a watch ended before sending all of its events, e.g.,
+	// because it fell behind. Watching again from the revision of the last event sent resumes
+	// it.
+	ErrWatchInterrupted = errors.New("watch interrupted")
+)
+
+// Broadcaster is an in-process feed of association events to subscribed watches. It either
+// assigns the events' revisions itself (see Publish), for storers without a shared change feed,
+// or passes along events whose revisions come from one (see Forward).
+type Broadcaster struct {
+	retained int
+	revision uint64
+	recent   []*AssociationEvent
+	subs     map[*Subscription]struct{}
+	mu       sync.Mutex
+}
+
+// NewBroadcaster creates a new *Broadcaster that retains the given number of most recent events.
+func NewBroadcaster(retained int) *Broadcaster {
+	return &Broadcaster{
+		retained: retained,
+		recent:   make([]*AssociationEvent, 0),
+		subs:     make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish assigns the next revisions to the events and sends them to the matching subscriptions.
+func (b *Broadcaster) Publish(events ...*AssociationEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, e := range events {
+		b.revision++
+		e.Revision = b.revision
+		b.send(e)
+	}
+}
+
+// Forward sends the event, whose revision has already been assigned, to the matching
+// subscriptions.
+func (b *Broadcaster) Forward(e *AssociationEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e.Revision > b.revision {
+		b.revision = e.Revision
+	}
+	b.send(e)
+}
+
+// send retains the event and sends it to the matching subscriptions, interrupting any that have
+// fallen behind. The caller must hold b.mu.
+func (b *Broadcaster) send(e *AssociationEvent) {
+	if b.retained > 0 {
+		b.recent = append(b.recent, e)
+		if len(b.recent) > b.retained {
+			b.recent = b.recent[1:]
+		}
+	}
+	for sub := range b.subs {
+		if !sub.matches(e) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			b.end(sub, ErrWatchInterrupted)
+		}
+	}
+}
+
+// Subscribe starts buffering the events for the user ID and/or entity ID.
+func (b *Broadcaster) Subscribe(userID, entityID string) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.subscribe(userID, entityID)
+}
+
+func (b *Broadcaster) subscribe(userID, entityID string) *Subscription {
+	sub := &Subscription{
+		userID:   userID,
+		entityID: entityID,
+		events:   make(chan *AssociationEvent, subscriptionBufferSize),
+		done:     make(chan struct{}),
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe stops buffering events for the subscription.
+func (b *Broadcaster) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, in := b.subs[sub]; in {
+		delete(b.subs, sub)
+		close(sub.done)
+	}
+}
+
+// Interrupt ends every subscription with ErrWatchInterrupted, e.g., when events may have been
+// missed.
+func (b *Broadcaster) Interrupt() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		b.end(sub, ErrWatchInterrupted)
+	}
+}
+
+// end removes the subscription and ends its stream with the given error. The caller must hold
+// b.mu.
+func (b *Broadcaster) end(sub *Subscription, err error) {
+	delete(b.subs, sub)
+	sub.err = err
+	close(sub.done)
+}
+
+// Watch sends the retained events for the user ID and/or entity ID after fromRevision, if it's
+// non-zero, and then the new events as they're published, until the context is done or sending
+// fails.
+func (b *Broadcaster) Watch(
+	ctx context.Context,
+	userID, entityID string,
+	fromRevision uint64,
+	send func(*AssociationEvent) error,
+) error {
+	b.mu.Lock()
+	backlog, err := b.since(userID, entityID, fromRevision)
+	if err != nil {
+		b.mu.Unlock()
+		return err
+	}
+	sub := b.subscribe(userID, entityID)
+	b.mu.Unlock()
+	defer b.Unsubscribe(sub)
+	return sub.Stream(ctx, fromRevision, backlog, send)
+}
+
+// since returns the retained events for the user ID and/or entity ID after fromRevision, or
+// ErrRevisionCompacted if some of those events are no longer retained and ErrFutureRevision if
+// fromRevision is after the latest event, whose following events would otherwise be skipped.
+// The caller must hold b.mu.
+func (b *Broadcaster) since(
+	userID, entityID string, fromRevision uint64,
+) ([]*AssociationEvent, error) {
+	if fromRevision == 0 {
+		return nil, nil
+	}
+	if fromRevision > b.revision {
+		return nil, ErrFutureRevision
+	}
+	if oldest := b.revision - uint64(len(b.recent)) + 1; fromRevision+1 < oldest {
+		return nil, ErrRevisionCompacted
+	}
+	backlog := make([]*AssociationEvent, 0)
+	for _, e := range b.recent {
+		if e.Revision > fromRevision && matchesEvent(userID, entityID, e) {
+			backlog = append(backlog, e)
+		}
+	}
+	return backlog, nil
+}
+
+// Subscription buffers the events for a watch until they're streamed.
+type Subscription struct {
+	userID   string
+	entityID string
+	events   chan *AssociationEvent
+	done     chan struct{}
+	err      error
+}
+
+// Stream sends the backlog events and then the subscribed events, skipping those at or before
+// fromRevision and those already sent in the backlog, until the context is done, sending fails,
+// or the subscription ends and its buffered events are sent.
+func (s *Subscription) Stream(
+	ctx context.Context,
+	fromRevision uint64,
+	backlog []*AssociationEvent,
+	send func(*AssociationEvent) error,
+) error {
+	sent := make(map[uint64]struct{}, len(backlog))
+	for _, e := range backlog {
+		if err := send(e); err != nil {
+			return err
+		}
+		sent[e.Revision] = struct{}{}
+	}
+	sendNew := func(e *AssociationEvent) error {
+		if _, in := sent[e.Revision]; in || e.Revision <= fromRevision {
+			return nil
+		}
+		return send(e)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.done:
+			// send the events buffered before the subscription ended so a resumed watch
+			// doesn't have to
+			for len(s.events) > 0 {
+				if err := sendNew(<-s.events); err != nil {
+					return err
+				}
+			}
+			return s.err
+		case e := <-s.events:
+			if err := sendNew(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Subscription) matches(e *AssociationEvent) bool {
+	return matchesEvent(s.userID, s.entityID, e)
+}
+
+// matchesEvent returns whether the event is for the user ID and/or entity ID, where an empty ID
+// matches any.
+func matchesEvent(userID, entityID string, e *AssociationEvent) bool {
+	return (userID == "" || e.UserID == userID) && (entityID == "" || e.EntityID == entityID)
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var errStopWatch = errors.New("stop watch")
+
+func TestBroadcaster_Watch_fromRevision(t *testing.T) {
+	b := NewBroadcaster(DefaultRetainedEvents)
+	b.Publish(
+		&AssociationEvent{Type: api.EventType_ADDED, UserID: "user 1", EntityID: "entity 1"},
+		&AssociationEvent{Type: api.EventType_ADDED, UserID: "user 2", EntityID: "entity 1"},
+		&AssociationEvent{Type: api.EventType_ADDED, UserID: "user 1", EntityID: "entity 2"},
+		&AssociationEvent{Type: api.EventType_REMOVED, UserID: "user 1", EntityID: "entity 1"},
+	)
+	cases := map[string]struct {
+		userID       string
+		entityID     string
+		fromRevision uint64
+		expected     []uint64
+	}{
+		"user": {
+			userID:       "user 1",
+			fromRevision: 1,
+			expected:     []uint64{3, 4},
+		},
+		"entity": {
+			entityID:     "entity 1",
+			fromRevision: 1,
+			expected:     []uint64{2, 4},
+		},
+		"user and entity": {
+			userID:       "user 1",
+			entityID:     "entity 1",
+			fromRevision: 3,
+			expected:     []uint64{4},
+		},
+	}
+	for desc, c := range cases {
+		sent := make([]uint64, 0)
+		err := b.Watch(context.Background(), c.userID, c.entityID, c.fromRevision,
+			func(e *AssociationEvent) error {
+				sent = append(sent, e.Revision)
+				if len(sent) == len(c.expected) {
+					return errStopWatch
+				}
+				return nil
+			})
+		assert.Equal(t, errStopWatch, err, desc)
+		assert.Equal(t, c.expected, sent, desc)
+	}
+	assert.Empty(t, b.subs)
+}
+
+func TestBroadcaster_Watch_published(t *testing.T) {
+	b := NewBroadcaster(DefaultRetainedEvents)
+	ctx, cancel := context.WithCancel(context.Background())
+	sent := make(chan *AssociationEvent)
+	errs := make(chan error)
+	go func() {
+		errs <- b.Watch(ctx, "user 1", "", 0, func(e *AssociationEvent) error {
+			sent <- e
+			return nil
+		})
+	}()
+	for subscribed := false; !subscribed; time.Sleep(time.Millisecond) {
+		b.mu.Lock()
+		subscribed = len(b.subs) == 1
+		b.mu.Unlock()
+	}
+
+	b.Publish(
+		&AssociationEvent{Type: api.EventType_ADDED, UserID: "user 2", EntityID: "entity 1"},
+		&AssociationEvent{Type: api.EventType_ADDED, UserID: "user 1", EntityID: "entity 1"},
+	)
+	e := <-sent
+	assert.Equal(t, "user 1", e.UserID)
+	assert.Equal(t, uint64(2), e.Revision)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-errs)
+}
+
+func TestBroadcaster_Watch_compacted(t *testing.T) {
+	b := NewBroadcaster(2)
+	for i := 0; i < 4; i++ {
+		b.Publish(&AssociationEvent{UserID: "user 1", EntityID: "entity 1"})
+	}
+	send := func(e *AssociationEvent) error { return errStopWatch }
+
+	err := b.Watch(context.Background(), "user 1", "", 1, send)
+	assert.Equal(t, ErrRevisionCompacted, err)
+
+	// events 3 and 4 are still retained
+	err = b.Watch(context.Background(), "user 1", "", 2, send)
+	assert.Equal(t, errStopWatch, err)
+}
+
+func TestBroadcaster_Watch_future(t *testing.T) {
+	b := NewBroadcaster(DefaultRetainedEvents)
+	send := func(e *AssociationEvent) error { return errStopWatch }
+
+	// e.g., a revision from before a restart
+	err := b.Watch(context.Background(), "user 1", "", 2, send)
+	assert.Equal(t, ErrFutureRevision, err)
+
+	b.Publish(&AssociationEvent{UserID: "user 1", EntityID: "entity 1"})
+	err = b.Watch(context.Background(), "user 1", "", 2, send)
+	assert.Equal(t, ErrFutureRevision, err)
+
+	// the latest revision is caught up, so the watch waits for the next event
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = b.Watch(ctx, "user 1", "", 1, send)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Empty(t, b.subs)
+}
+
+func TestBroadcaster_Forward(t *testing.T) {
+	b := NewBroadcaster(DefaultRetainedEvents)
+	b.Forward(&AssociationEvent{UserID: "user 1", Revision: 10})
+	e := &AssociationEvent{UserID: "user 1"}
+	b.Publish(e)
+	assert.Equal(t, uint64(11), e.Revision)
+}
+
+func TestBroadcaster_Interrupt(t *testing.T) {
+	b := NewBroadcaster(0)
+	sub := b.Subscribe("user 1", "")
+	b.Interrupt()
+	err := sub.Stream(context.Background(), 0, nil, func(e *AssociationEvent) error {
+		return nil
+	})
+	assert.Equal(t, ErrWatchInterrupted, err)
+	assert.Empty(t, b.subs)
+
+	// unsubscribing after the subscription ended does nothing
+	b.Unsubscribe(sub)
+}
+
+func TestBroadcaster_send_fullBuffer(t *testing.T) {
+	b := NewBroadcaster(0)
+	sub := b.Subscribe("user 1", "")
+	for i := 0; i < subscriptionBufferSize+1; i++ {
+		b.Publish(&AssociationEvent{UserID: "user 1"})
+	}
+	assert.Empty(t, b.subs)
+	nSent := 0
+	err := sub.Stream(context.Background(), 0, nil, func(e *AssociationEvent) error {
+		nSent++
+		return nil
+	})
+	assert.Equal(t, ErrWatchInterrupted, err)
+	assert.Equal(t, subscriptionBufferSize, nSent)
+}
+
+func TestSubscription_Stream(t *testing.T) {
+	b := NewBroadcaster(0)
+	sub := b.Subscribe("", "entity 1")
+	e1 := &AssociationEvent{UserID: "user 1", EntityID: "entity 1"}
+	e2 := &AssociationEvent{UserID: "user 2", EntityID: "entity 2"}
+	e3 := &AssociationEvent{UserID: "user 2", EntityID: "entity 1"}
+	e4 := &AssociationEvent{UserID: "user 3", EntityID: "entity 1"}
+	b.Publish(e1, e2, e3, e4)
+
+	// e1 is before the revision, and e3 was already sent in the backlog
+	sent := make([]*AssociationEvent, 0)
+	err := sub.Stream(context.Background(), 1, []*AssociationEvent{e3},
+		func(e *AssociationEvent) error {
+			sent = append(sent, e)
+			if len(sent) == 2 {
+				return errStopWatch
+			}
+			return nil
+		})
+	assert.Equal(t, errStopWatch, err)
+	assert.Equal(t, []*AssociationEvent{e3, e4}, sent)
+}
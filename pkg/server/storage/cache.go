@@ -0,0 +1,408 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultCacheSize is the default max number of entries in the cache, where zero disables
+	// caching.
+	DefaultCacheSize = 0
+
+	// DefaultCacheTTL is the default time a cache entry is used before it's read again from the
+	// underlying Storer.
+	DefaultCacheTTL = 30 * time.Second
+
+	cacheNamespace = "user"
+	cacheSubsystem = "storage_cache"
+
+	// changeFeedRetryDelay is how long the cache waits before subscribing to the change feed
+	// again after its subscription ends.
+	changeFeedRetryDelay = 1 * time.Second
+
+	kindLabel   = "kind"
+	resultLabel = "result"
+	hitResult   = "hit"
+	missResult  = "miss"
+)
+
+// cacheKind is the kind of value a cache entry holds.
+type cacheKind string
+
+const (
+	entitiesKind    cacheKind = "entities"
+	entityCountKind cacheKind = "entity_count"
+	usersKind       cacheKind = "users"
+	userCountKind   cacheKind = "user_count"
+)
+
+// cacheScope identifies the user or entity whose associations a cache entry holds.
+type cacheScope struct {
+	entity bool
+	id     string
+}
+
+type cacheKey struct {
+	scope cacheScope
+	kind  cacheKind
+	page  Page
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	value   interface{}
+	expires time.Time
+}
+
+// cachingStorer is a Storer that caches the entity lists and counts of users and the user lists
+// and counts of entities from the Storer it wraps. It only serves reads: AddEntity and
+// AddEntities still go to the wrapped Storer with their limit checks, which must happen
+// atomically with the insert and against per-user and per-entity limit overrides the cache
+// doesn't hold, so caching doesn't reduce the load of adds. They and the other writes
+// invalidate the cached entries of the users and entities they change. Writes
+// through other Storers, e.g., on other servers sharing the backend, are only seen through the
+// backend's change feed, without which the cache may only wrap a backend no other Storer
+// writes to.
+type cachingStorer struct {
+	Storer
+
+	// changes is the change feed of the users and entities changed through other Storers, if
+	// any, and following is whether the cache is subscribed to it, without which nothing is
+	// cached since changes may be missed
+	changes   ChangeFeed
+	following bool
+	cancel    context.CancelFunc
+	stopped   chan struct{}
+
+	size    int
+	ttl     time.Duration
+	now     func() time.Time
+	order   *list.List // most recently used first
+	entries map[cacheKey]*list.Element
+	scopes  map[cacheScope]map[cacheKey]struct{}
+
+	// generation increments with each invalidation, so that a value read from the wrapped
+	// Storer before an invalidation isn't cached after it
+	generation uint64
+	mu         sync.Mutex
+
+	lookups   *prometheus.CounterVec
+	evictions prometheus.Counter
+}
+
+// NewCaching returns a Storer that caches entity lists and counts from the given Storer in a
+// bounded LRU cache whose size and TTL are set in the parameters. Only reads are served from
+// the cache; the limit checks of adds still go to the given Storer. If the given Storer's backend
+// is shared with other servers, the change feed must be given so that their changes invalidate
+// cached entries; otherwise it's nil. The returned Storer is also a prometheus.Collector of the
+// cache's hit, miss, and eviction counts along with any metrics of the given Storer.
+func NewCaching(storer Storer, params *Parameters, changes ChangeFeed) Storer {
+	s := &cachingStorer{
+		Storer:  storer,
+		changes: changes,
+		size:    params.CacheSize,
+		ttl:     params.CacheTTL,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[cacheKey]*list.Element),
+		scopes:  make(map[cacheScope]map[cacheKey]struct{}),
+		lookups: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: cacheNamespace,
+				Subsystem: cacheSubsystem,
+				Name:      "lookups_total",
+				Help:      "Number of storage cache lookups, by kind and hit or miss.",
+			},
+			[]string{kindLabel, resultLabel},
+		),
+		evictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: cacheNamespace,
+			Subsystem: cacheSubsystem,
+			Name:      "evictions_total",
+			Help:      "Number of entries evicted from the storage cache to stay within its size.",
+		}),
+	}
+	if changes == nil {
+		s.following = true
+		return s
+	}
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	s.stopped = make(chan struct{})
+	go s.followChanges(ctx)
+	return s
+}
+
+// followChanges invalidates the cached entries of the users and entities in the change feed's
+// events until the context is done. Whenever its subscription ends, events may have been
+// missed, so the cache is cleared and bypassed until it subscribes again.
+func (s *cachingStorer) followChanges(ctx context.Context) {
+	defer close(s.stopped)
+	for {
+		sub, err := s.changes.SubscribeChanges()
+		if err == nil {
+			s.setFollowing(true)
+			_ = sub.Stream(ctx, 0, nil, func(e *AssociationEvent) error {
+				s.invalidate([]string{e.UserID}, []string{e.EntityID})
+				return nil
+			})
+			s.changes.UnsubscribeChanges(sub)
+		}
+		s.setFollowing(false)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(changeFeedRetryDelay):
+		}
+	}
+}
+
+// setFollowing sets whether the cache is subscribed to the change feed, clearing it if not.
+func (s *cachingStorer) setFollowing(following bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.following = following
+	if !following {
+		s.generation++
+		s.order.Init()
+		s.entries = make(map[cacheKey]*list.Element)
+		s.scopes = make(map[cacheScope]map[cacheKey]struct{})
+	}
+}
+
+// Close stops following the change feed, if any, and closes the wrapped Storer.
+func (s *cachingStorer) Close() error {
+	if s.cancel != nil {
+		s.cancel()
+		<-s.stopped
+	}
+	return s.Storer.Close()
+}
+
+func (s *cachingStorer) AddEntity(
+	ctx context.Context, userID, entityID string, role api.Role, limits *Limits,
+) error {
+	defer s.invalidate([]string{userID}, []string{entityID})
+	return s.Storer.AddEntity(ctx, userID, entityID, role, limits)
+}
+
+func (s *cachingStorer) AddEntities(
+	ctx context.Context, as []*Association, limits *Limits,
+) ([]error, error) {
+	userIDs, entityIDs := make([]string, len(as)), make([]string, len(as))
+	for i, a := range as {
+		userIDs[i], entityIDs[i] = a.UserID, a.EntityID
+	}
+	defer s.invalidate(userIDs, entityIDs)
+	return s.Storer.AddEntities(ctx, as, limits)
+}
+
+func (s *cachingStorer) RemoveEntity(ctx context.Context, userID, entityID string) error {
+	defer s.invalidate([]string{userID}, []string{entityID})
+	return s.Storer.RemoveEntity(ctx, userID, entityID)
+}
+
+func (s *cachingStorer) UpdateRole(
+	ctx context.Context, userID, entityID string, role api.Role,
+) error {
+	// entity lists include roles, but the user lists and counts are unchanged
+	defer s.invalidate([]string{userID}, nil)
+	return s.Storer.UpdateRole(ctx, userID, entityID, role)
+}
+
+func (s *cachingStorer) ImportAssociation(
+	ctx context.Context, versions []*AssociationVersion, overwrite bool,
+) (bool, error) {
+	if len(versions) > 0 {
+		defer s.invalidate([]string{versions[0].UserID}, []string{versions[0].EntityID})
+	}
+	return s.Storer.ImportAssociation(ctx, versions, overwrite)
+}
+
+// cachedEntities is a cached page of a user's entities.
+type cachedEntities struct {
+	as   []*Association
+	next string
+}
+
+func (s *cachingStorer) GetEntities(
+	ctx context.Context, userID string, page *Page,
+) ([]*Association, string, error) {
+	key := cacheKey{scope: cacheScope{id: userID}, kind: entitiesKind}
+	if page != nil {
+		key.page = *page
+	}
+	value, err := s.get(key, func() (interface{}, error) {
+		as, next, err := s.Storer.GetEntities(ctx, userID, page)
+		if err != nil {
+			return nil, err
+		}
+		return &cachedEntities{as: as, next: next}, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	ces := value.(*cachedEntities)
+	as := make([]*Association, len(ces.as))
+	for i, a := range ces.as {
+		copied := *a
+		as[i] = &copied
+	}
+	return as, ces.next, nil
+}
+
+// cachedUsers is a cached page of an entity's users.
+type cachedUsers struct {
+	userIDs []string
+	next    string
+}
+
+func (s *cachingStorer) GetUsers(
+	ctx context.Context, entityID string, page *Page,
+) ([]string, string, error) {
+	key := cacheKey{scope: cacheScope{entity: true, id: entityID}, kind: usersKind}
+	if page != nil {
+		key.page = *page
+	}
+	value, err := s.get(key, func() (interface{}, error) {
+		userIDs, next, err := s.Storer.GetUsers(ctx, entityID, page)
+		if err != nil {
+			return nil, err
+		}
+		return &cachedUsers{userIDs: userIDs, next: next}, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	cus := value.(*cachedUsers)
+	return append([]string(nil), cus.userIDs...), cus.next, nil
+}
+
+func (s *cachingStorer) CountEntities(ctx context.Context, userID string) (int, error) {
+	key := cacheKey{scope: cacheScope{id: userID}, kind: entityCountKind}
+	value, err := s.get(key, func() (interface{}, error) {
+		return s.Storer.CountEntities(ctx, userID)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return value.(int), nil
+}
+
+func (s *cachingStorer) CountUsers(ctx context.Context, entityID string) (int, error) {
+	key := cacheKey{scope: cacheScope{entity: true, id: entityID}, kind: userCountKind}
+	value, err := s.get(key, func() (interface{}, error) {
+		return s.Storer.CountUsers(ctx, entityID)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return value.(int), nil
+}
+
+// Describe sends the descriptors of the cache metrics, and of the wrapped Storer's metrics if it
+// has any, to the given channel.
+func (s *cachingStorer) Describe(ch chan<- *prometheus.Desc) {
+	s.lookups.Describe(ch)
+	s.evictions.Describe(ch)
+	if collector, ok := s.Storer.(prometheus.Collector); ok {
+		collector.Describe(ch)
+	}
+}
+
+// Collect sends the current cache metrics, and the wrapped Storer's metrics if it has any, to the
+// given channel.
+func (s *cachingStorer) Collect(ch chan<- prometheus.Metric) {
+	s.lookups.Collect(ch)
+	s.evictions.Collect(ch)
+	if collector, ok := s.Storer.(prometheus.Collector); ok {
+		collector.Collect(ch)
+	}
+}
+
+// get returns the unexpired cached value for the key, or else loads it and caches it. Errors
+// from loading aren't cached, and nothing is while the cache isn't following the change feed.
+func (s *cachingStorer) get(key cacheKey, load func() (interface{}, error)) (interface{}, error) {
+	s.mu.Lock()
+	if !s.following {
+		s.mu.Unlock()
+		return load()
+	}
+	if elem, in := s.entries[key]; in {
+		entry := elem.Value.(*cacheEntry)
+		if s.now().Before(entry.expires) {
+			s.order.MoveToFront(elem)
+			s.mu.Unlock()
+			s.lookups.WithLabelValues(string(key.kind), hitResult).Inc()
+			return entry.value, nil
+		}
+		s.remove(elem)
+	}
+	generation := s.generation
+	s.mu.Unlock()
+	s.lookups.WithLabelValues(string(key.kind), missResult).Inc()
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if generation == s.generation && s.following {
+		s.put(key, value)
+	}
+	return value, nil
+}
+
+// put caches the value for the key, evicting the least recently used entries to stay within the
+// cache size. The caller must hold s.mu.
+func (s *cachingStorer) put(key cacheKey, value interface{}) {
+	if elem, in := s.entries[key]; in {
+		s.remove(elem)
+	}
+	entry := &cacheEntry{key: key, value: value, expires: s.now().Add(s.ttl)}
+	s.entries[key] = s.order.PushFront(entry)
+	if _, in := s.scopes[key.scope]; !in {
+		s.scopes[key.scope] = make(map[cacheKey]struct{})
+	}
+	s.scopes[key.scope][key] = struct{}{}
+	for s.order.Len() > s.size {
+		s.remove(s.order.Back())
+		s.evictions.Inc()
+	}
+}
+
+// remove removes the entry from the cache. The caller must hold s.mu.
+func (s *cachingStorer) remove(elem *list.Element) {
+	key := s.order.Remove(elem).(*cacheEntry).key
+	delete(s.entries, key)
+	delete(s.scopes[key.scope], key)
+	if len(s.scopes[key.scope]) == 0 {
+		delete(s.scopes, key.scope)
+	}
+}
+
+// invalidate removes the cached entries of the given users and entities.
+func (s *cachingStorer) invalidate(userIDs, entityIDs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation++
+	for _, userID := range userIDs {
+		s.invalidateScope(cacheScope{id: userID})
+	}
+	for _, entityID := range entityIDs {
+		s.invalidateScope(cacheScope{entity: true, id: entityID})
+	}
+}
+
+func (s *cachingStorer) invalidateScope(scope cacheScope) {
+	for key := range s.scopes[scope] {
+		s.remove(s.entries[key])
+	}
+}
@@ -0,0 +1,423 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+var errTest = errors.New("some test error")
+
+func TestCachingStorer_GetEntities(t *testing.T) {
+	inner := &countingStorer{
+		entities: []*Association{{UserID: "user 1", EntityID: "entity 1"}},
+	}
+	s := newTestCachingStorer(inner, 16)
+	ctx := context.Background()
+
+	as, _, err := s.GetEntities(ctx, "user 1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, inner.entities, as)
+	as[0].EntityID = "modified" // doesn't change the cached value
+	as, _, err = s.GetEntities(ctx, "user 1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "entity 1", as[0].EntityID)
+	assert.Equal(t, 1, inner.nGetEntities)
+
+	// other pages are cached separately
+	_, _, err = s.GetEntities(ctx, "user 1", &Page{Size: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, inner.nGetEntities)
+
+	// adding an entity invalidates all of the user's pages
+	err = s.AddEntity(ctx, "user 1", "entity 2", api.Role_OWNER, &Limits{})
+	assert.Nil(t, err)
+	_, _, err = s.GetEntities(ctx, "user 1", nil)
+	assert.Nil(t, err)
+	_, _, err = s.GetEntities(ctx, "user 1", &Page{Size: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, inner.nGetEntities)
+
+	assert.Equal(t, float64(1), counterValue(s.lookups.WithLabelValues("entities", "hit")))
+	assert.Equal(t, float64(4), counterValue(s.lookups.WithLabelValues("entities", "miss")))
+}
+
+func TestCachingStorer_invalidate(t *testing.T) {
+	ctx := context.Background()
+	cases := map[string]struct {
+		write             func(s Storer) error
+		userInvalidated   bool
+		entityInvalidated bool
+	}{
+		"add entity": {
+			write: func(s Storer) error {
+				return s.AddEntity(ctx, "user 1", "entity 1", api.Role_OWNER, &Limits{})
+			},
+			userInvalidated:   true,
+			entityInvalidated: true,
+		},
+		"add entities": {
+			write: func(s Storer) error {
+				_, err := s.AddEntities(ctx, []*Association{
+					{UserID: "user 1", EntityID: "entity 2"},
+					{UserID: "user 2", EntityID: "entity 1"},
+				}, &Limits{})
+				return err
+			},
+			userInvalidated:   true,
+			entityInvalidated: true,
+		},
+		"remove entity": {
+			write: func(s Storer) error {
+				return s.RemoveEntity(ctx, "user 1", "entity 1")
+			},
+			userInvalidated:   true,
+			entityInvalidated: true,
+		},
+		"update role": {
+			write: func(s Storer) error {
+				return s.UpdateRole(ctx, "user 1", "entity 1", api.Role_ADMIN)
+			},
+			userInvalidated: true,
+		},
+		"import association": {
+			write: func(s Storer) error {
+				_, err := s.ImportAssociation(ctx, []*AssociationVersion{
+					NewAssociationVersion("user 1", "entity 1", api.Role_OWNER),
+				}, true)
+				return err
+			},
+			userInvalidated:   true,
+			entityInvalidated: true,
+		},
+		"other user and entity": {
+			write: func(s Storer) error {
+				return s.RemoveEntity(ctx, "user 2", "entity 2")
+			},
+		},
+	}
+	for desc, c := range cases {
+		inner := &countingStorer{}
+		s := newTestCachingStorer(inner, 16)
+		read := func() {
+			_, err := s.CountEntities(ctx, "user 1")
+			assert.Nil(t, err, desc)
+			_, err = s.CountUsers(ctx, "entity 1")
+			assert.Nil(t, err, desc)
+			_, _, err = s.GetUsers(ctx, "entity 1", nil)
+			assert.Nil(t, err, desc)
+		}
+		read()
+		assert.Nil(t, c.write(s), desc)
+		read()
+
+		expectedUserReads, expectedEntityReads := 1, 1
+		if c.userInvalidated {
+			expectedUserReads++
+		}
+		if c.entityInvalidated {
+			expectedEntityReads++
+		}
+		assert.Equal(t, expectedUserReads, inner.nCountEntities, desc)
+		assert.Equal(t, expectedEntityReads, inner.nCountUsers, desc)
+		assert.Equal(t, expectedEntityReads, inner.nGetUsers, desc)
+	}
+}
+
+func TestCachingStorer_ttl(t *testing.T) {
+	inner := &countingStorer{}
+	s := newTestCachingStorer(inner, 16)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, err := s.CountEntities(ctx, "user 1")
+	assert.Nil(t, err)
+	now = now.Add(s.ttl - time.Nanosecond)
+	_, err = s.CountEntities(ctx, "user 1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, inner.nCountEntities)
+
+	now = now.Add(time.Nanosecond)
+	_, err = s.CountEntities(ctx, "user 1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, inner.nCountEntities)
+}
+
+func TestCachingStorer_evict(t *testing.T) {
+	inner := &countingStorer{}
+	s := newTestCachingStorer(inner, 2)
+	ctx := context.Background()
+
+	for _, userID := range []string{"user 1", "user 2", "user 1", "user 3"} {
+		_, err := s.CountEntities(ctx, userID)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 3, inner.nCountEntities)
+	assert.Equal(t, float64(1), counterValue(s.evictions))
+
+	// user 2 was least recently used when user 3 was added
+	_, err := s.CountEntities(ctx, "user 2")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, inner.nCountEntities)
+	_, err = s.CountEntities(ctx, "user 3")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, inner.nCountEntities)
+	assert.Len(t, s.entries, 2)
+	assert.Len(t, s.scopes, 2)
+}
+
+func TestCachingStorer_err(t *testing.T) {
+	inner := &countingStorer{err: errTest}
+	s := newTestCachingStorer(inner, 16)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		as, next, err := s.GetEntities(ctx, "user 1", nil)
+		assert.Equal(t, errTest, err)
+		assert.Nil(t, as)
+		assert.Empty(t, next)
+		users, _, err := s.GetUsers(ctx, "entity 1", nil)
+		assert.Equal(t, errTest, err)
+		assert.Nil(t, users)
+		n, err := s.CountEntities(ctx, "user 1")
+		assert.Equal(t, errTest, err)
+		assert.Zero(t, n)
+		n, err = s.CountUsers(ctx, "entity 1")
+		assert.Equal(t, errTest, err)
+		assert.Zero(t, n)
+	}
+	assert.Equal(t, 2, inner.nGetEntities)
+	assert.Empty(t, s.entries)
+}
+
+func TestCachingStorer_invalidatedWhileLoading(t *testing.T) {
+	inner := &countingStorer{}
+	s := newTestCachingStorer(inner, 16)
+	ctx := context.Background()
+	inner.onCountEntities = func() {
+		inner.onCountEntities = nil
+		assert.Nil(t, s.RemoveEntity(ctx, "user 1", "entity 1"))
+	}
+
+	// the value read before the removal isn't cached
+	_, err := s.CountEntities(ctx, "user 1")
+	assert.Nil(t, err)
+	_, err = s.CountEntities(ctx, "user 1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, inner.nCountEntities)
+}
+
+func TestCachingStorer_changes(t *testing.T) {
+	inner := &countingStorer{}
+	changes := &broadcasterFeed{b: NewBroadcaster(0)}
+	params := NewDefaultParameters()
+	params.CacheSize = 16
+	s := NewCaching(inner, params, changes).(*cachingStorer)
+	ctx := context.Background()
+	waitFollowing(s, true)
+
+	_, err := s.CountEntities(ctx, "user 1")
+	assert.Nil(t, err)
+	_, err = s.CountEntities(ctx, "user 1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, inner.nCountEntities)
+
+	// a change made through another Storer invalidates the cached entry
+	changes.b.Forward(&AssociationEvent{UserID: "user 1", EntityID: "entity 1", Revision: 1})
+	waitEmpty(s)
+	_, err = s.CountEntities(ctx, "user 1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, inner.nCountEntities)
+
+	// nothing is cached while events may be missed
+	changes.b.Interrupt()
+	waitFollowing(s, false)
+	assert.Empty(t, s.entries)
+	for i := 0; i < 2; i++ {
+		_, err = s.CountEntities(ctx, "user 1")
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 4, inner.nCountEntities)
+
+	// until the cache subscribes again
+	waitFollowing(s, true)
+	for i := 0; i < 2; i++ {
+		_, err = s.CountEntities(ctx, "user 1")
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 5, inner.nCountEntities)
+
+	assert.Nil(t, s.Close())
+	assert.Equal(t, 0, changes.nSubscribed())
+}
+
+func TestCachingStorer_Collect(t *testing.T) {
+	s := newTestCachingStorer(&countingStorer{}, 16)
+	_, err := s.CountUsers(context.Background(), "entity 1")
+	assert.Nil(t, err)
+
+	descs := make(chan *prometheus.Desc, 8)
+	s.Describe(descs)
+	close(descs)
+	assert.Len(t, descs, 2)
+
+	metrics := make(chan prometheus.Metric, 8)
+	s.Collect(metrics)
+	close(metrics)
+	assert.Len(t, metrics, 2) // one lookup label combination and the evictions
+}
+
+func newTestCachingStorer(inner Storer, size int) *cachingStorer {
+	params := NewDefaultParameters()
+	params.CacheSize = size
+	return NewCaching(inner, params, nil).(*cachingStorer)
+}
+
+func waitFollowing(s *cachingStorer, following bool) {
+	for {
+		s.mu.Lock()
+		done := s.following == following
+		s.mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func waitEmpty(s *cachingStorer) {
+	for {
+		s.mu.Lock()
+		done := len(s.entries) == 0
+		s.mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// broadcasterFeed is a ChangeFeed of the events forwarded to its Broadcaster.
+type broadcasterFeed struct {
+	b  *Broadcaster
+	mu sync.Mutex
+	n  int
+}
+
+func (f *broadcasterFeed) SubscribeChanges() (*Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.n++
+	return f.b.Subscribe("", ""), nil
+}
+
+func (f *broadcasterFeed) UnsubscribeChanges(sub *Subscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.n--
+	f.b.Unsubscribe(sub)
+}
+
+func (f *broadcasterFeed) nSubscribed() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.n
+}
+
+func counterValue(c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	if err := c.Write(m); err != nil {
+		panic(err)
+	}
+	return m.Counter.GetValue()
+}
+
+// countingStorer counts the reads of the methods the cache wraps.
+type countingStorer struct {
+	Storer
+	entities        []*Association
+	err             error
+	nGetEntities    int
+	nGetUsers       int
+	nCountEntities  int
+	nCountUsers     int
+	onCountEntities func()
+}
+
+func (f *countingStorer) AddEntity(
+	ctx context.Context, userID, entityID string, role api.Role, limits *Limits,
+) error {
+	return nil
+}
+
+func (f *countingStorer) AddEntities(
+	ctx context.Context, as []*Association, limits *Limits,
+) ([]error, error) {
+	return make([]error, len(as)), nil
+}
+
+func (f *countingStorer) RemoveEntity(ctx context.Context, userID, entityID string) error {
+	return nil
+}
+
+func (f *countingStorer) UpdateRole(
+	ctx context.Context, userID, entityID string, role api.Role,
+) error {
+	return nil
+}
+
+func (f *countingStorer) ImportAssociation(
+	ctx context.Context, versions []*AssociationVersion, overwrite bool,
+) (bool, error) {
+	return false, nil
+}
+
+func (f *countingStorer) GetEntities(
+	ctx context.Context, userID string, page *Page,
+) ([]*Association, string, error) {
+	f.nGetEntities++
+	if f.err != nil {
+		return nil, "", f.err
+	}
+	as := make([]*Association, len(f.entities))
+	for i, a := range f.entities {
+		copied := *a
+		as[i] = &copied
+	}
+	return as, "", nil
+}
+
+func (f *countingStorer) GetUsers(
+	ctx context.Context, entityID string, page *Page,
+) ([]string, string, error) {
+	f.nGetUsers++
+	if f.err != nil {
+		return nil, "", f.err
+	}
+	return []string{"user 1"}, "", nil
+}
+
+func (f *countingStorer) CountEntities(ctx context.Context, userID string) (int, error) {
+	f.nCountEntities++
+	if f.onCountEntities != nil {
+		f.onCountEntities()
+	}
+	return len(f.entities), f.err
+}
+
+func (f *countingStorer) Close() error {
+	return nil
+}
+
+func (f *countingStorer) CountUsers(ctx context.Context, entityID string) (int, error) {
+	f.nCountUsers++
+	return 1, f.err
+}
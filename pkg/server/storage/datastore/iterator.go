@@ -0,0 +1,27 @@
+package datastore
+
+import (
+	"cloud.google.com/go/datastore"
+)
+
+// cursorIterator is a DataStore iterator that can also give its cursor, for paging.
+type cursorIterator interface {
+	// Next loads the next result into dst and returns its key, or iterator.Done when there
+	// are no more results.
+	Next(dst interface{}) (*datastore.Key, error)
+
+	// Cursor returns the cursor for the iterator's position after the last result returned.
+	Cursor() (datastore.Cursor, error)
+}
+
+// iteratorFactory creates the cursorIterator over the results of each query, so concurrent
+// queries never share one.
+type iteratorFactory interface {
+	Iterator(iter *datastore.Iterator) cursorIterator
+}
+
+type iteratorFactoryImpl struct{}
+
+func (iteratorFactoryImpl) Iterator(iter *datastore.Iterator) cursorIterator {
+	return iter
+}
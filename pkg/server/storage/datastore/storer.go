@@ -2,47 +2,93 @@ package datastore
 
 import (
 	"context"
+	"sort"
 	"time"
 
 	"cloud.google.com/go/datastore"
 	bstorage "github.com/elixirhealth/service-base/pkg/server/storage"
 	"github.com/elixirhealth/user/pkg/server/storage"
 	api "github.com/elixirhealth/user/pkg/userapi"
-	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"google.golang.org/api/iterator"
 )
 
 const (
-	userEntityKind = "user_entity"
+	// EmulatorHostEnv is the env var giving the host:port of a DataStore emulator, which the
+	// DataStore client uses instead of GCP DataStore when set.
+	EmulatorHostEnv = "DATASTORE_EMULATOR_HOST"
+
+	userEntityKind   = "user_entity"
+	userEntitiesKind = "user_entities"
+	entityUsersKind  = "entity_users"
+	userKind         = "user"
 
 	secsPerDay = int64(3600 * 24 * 24)
 )
 
 var (
-	// ErrUserEntityExists indicates when a (user ID, entity ID) pair already exists
-	ErrUserEntityExists = errors.New("user-entity association already exists")
+	// ErrUserEntityExists indicates when a (user ID, entity ID) pair already exists. It's the
+	// same as storage.ErrUserEntityExists, which other backends return.
+	ErrUserEntityExists = storage.ErrUserEntityExists
 )
 
-// UserEntity represents an entity-user association.
+// UserEntity is how DataStore stores a storage.AssociationVersion, with the modified date and
+// time indexed for queries.
 type UserEntity struct {
 	UserID       string    `datastore:"user_id"`
 	EntityID     string    `datastore:"entity_id"`
+	Role         int32     `datastore:"role,noindex"`
 	Removed      bool      `datastore:"removed"`
 	ModifiedDate int32     `datastore:"modified_date"`
 	ModifiedTime time.Time `datastore:"modified_time,noindex"`
 	AddedTime    time.Time `datastore:"added_time,noindex"`
 	RemovedTime  time.Time `datastore:"removed_time,noindex"`
+
+	// RoleUpdated and Superseded indicate whether the association was added or removed,
+	// respectively, by a role update rather than by an add or remove
+	RoleUpdated bool `datastore:"role_updated,noindex"`
+	Superseded  bool `datastore:"superseded,noindex"`
+}
+
+// associationIndex holds the IDs currently associated with a single user or entity along with
+// any override of the default max number of associated IDs. It is stored under a key named by
+// that user or entity ID so that limits can be checked and updated within a transaction, which
+// DataStore queries can't take part in. Associations added before the indices existed aren't in
+// them until they're built from the user_entity entities, which Built marks.
+type associationIndex struct {
+	IDs   []string `datastore:"ids,noindex"`
+	Max   int      `datastore:"max,noindex"`
+	Built bool     `datastore:"built,noindex"`
+}
+
+// userProfile represents a user's profile, stored under a key named by the user ID. Attributes are
+// stored as key-value pairs sorted by key since DataStore doesn't support map properties.
+type userProfile struct {
+	DisplayName  string          `datastore:"display_name,noindex"`
+	Email        string          `datastore:"email,noindex"`
+	Phone        string          `datastore:"phone,noindex"`
+	Locale       string          `datastore:"locale,noindex"`
+	Timezone     string          `datastore:"timezone,noindex"`
+	Attributes   []userAttribute `datastore:"attributes,noindex"`
+	ModifiedTime time.Time       `datastore:"modified_time,noindex"`
+}
+
+type userAttribute struct {
+	Key   string `datastore:"key,noindex"`
+	Value string `datastore:"value,noindex"`
 }
 
 type storer struct {
 	params *storage.Parameters
 	client bstorage.DatastoreClient
-	iter   bstorage.DatastoreIterator
+	tx     transactor
+	iters  iteratorFactory
+	feed   *storage.Broadcaster
 	logger *zap.Logger
 }
 
-// New creates a new Storer backed by a GCP DataStore instance.
+// New creates a new Storer backed by a GCP DataStore instance. DataStore has no change feed, so
+// Watch only sends the events for changes made through this Storer.
 func New(
 	gcpProjectID string, params *storage.Parameters, logger *zap.Logger,
 ) (storage.Storer, error) {
@@ -53,66 +99,371 @@ func New(
 	return &storer{
 		params: params,
 		client: &bstorage.DatastoreClientImpl{Inner: client},
-		iter:   &bstorage.DatastoreIteratorImpl{},
+		tx:     &transactorImpl{client: client},
+		iters:  iteratorFactoryImpl{},
+		feed:   storage.NewBroadcaster(storage.DefaultRetainedEvents),
 		logger: logger,
 	}, nil
 }
 
-func (s *storer) AddEntity(userID, entityID string) error {
+func (s *storer) AddEntity(
+	ctx context.Context, userID, entityID string, role api.Role, limits *storage.Limits,
+) error {
 	if userID == "" {
 		return api.ErrEmptyUserID
 	}
 	if entityID == "" {
 		return api.ErrEmptyEntityID
 	}
-
-	// check user-entity association doesn't already exist
-	if n, err := s.countUserEntities(userID, entityID); err != nil {
+	if err := s.buildIndices(ctx, indexKeys([]string{userID}, []string{entityID})); err != nil {
 		return err
-	} else if n > 0 {
-		return ErrUserEntityExists
 	}
+	ctx, cancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
+	defer cancel()
+	ue := NewUserEntity(userID, entityID, role)
+	err := s.tx.RunInTransaction(ctx, func(tx transaction) error {
+		userEntities, entityUsers, err := getIndices(tx, userID, entityID)
+		if err != nil {
+			return err
+		}
+		if contains(userEntities.IDs, entityID) {
+			return storage.ErrUserEntityExists
+		}
+		if len(entityUsers.IDs)+1 > entityUsers.max(limits.MaxEntityUsers) {
+			return storage.ErrTooManyEntityUsers
+		}
+		if len(userEntities.IDs)+1 > userEntities.max(limits.MaxUserEntities) {
+			return storage.ErrTooManyUserEntities
+		}
+		userEntities.IDs = append(userEntities.IDs, entityID)
+		entityUsers.IDs = append(entityUsers.IDs, userID)
+		if err := putIndices(tx, userID, entityID, userEntities, entityUsers); err != nil {
+			return err
+		}
+		key := datastore.IncompleteKey(userEntityKind, nil)
+		_, err = tx.Put(key, ue)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.feed.Publish(ue.LastEvent())
+	s.logger.Debug("storer added entity to user", logUserEntityFields(userID, entityID)...)
+	return nil
+}
 
-	key := datastore.IncompleteKey(userEntityKind, nil)
-	ue := NewUserEntity(userID, entityID)
-	ctx, cancel := context.WithTimeout(context.Background(), s.params.AddQueryTimeout)
+func (s *storer) AddEntities(
+	ctx context.Context, as []*storage.Association, limits *storage.Limits,
+) ([]error, error) {
+	userIDs, entityIDs := distinctIDs(as)
+	userKeys := nameKeys(userEntitiesKind, userIDs)
+	entityKeys := nameKeys(entityUsersKind, entityIDs)
+	if err := s.buildIndices(ctx, indexKeys(userIDs, entityIDs)); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
 	defer cancel()
-	if _, err := s.client.Put(ctx, key, ue); err != nil {
+	var errs []error
+	var ues []*UserEntity
+	err := s.tx.RunInTransaction(ctx, func(tx transaction) error {
+		userEntities := newIndices(len(userKeys))
+		if err := resolveMultiErr(tx.GetMulti(userKeys, userEntities), userEntities); err != nil {
+			return err
+		}
+		entityUsers := newIndices(len(entityKeys))
+		if err := resolveMultiErr(tx.GetMulti(entityKeys, entityUsers), entityUsers); err != nil {
+			return err
+		}
+		userIdx := make(map[string]*associationIndex, len(userIDs))
+		entityIdx := make(map[string]*associationIndex, len(entityIDs))
+		counts := storage.NewAssociationCounts()
+		for i, userID := range userIDs {
+			userIdx[userID] = userEntities[i]
+			counts.UserEntities[userID] = len(userEntities[i].IDs)
+			counts.MaxUserEntities[userID] = userEntities[i].Max
+			for _, entityID := range userEntities[i].IDs {
+				counts.Existing[storage.AssociationID{UserID: userID, EntityID: entityID}] =
+					struct{}{}
+			}
+		}
+		for i, entityID := range entityIDs {
+			entityIdx[entityID] = entityUsers[i]
+			counts.EntityUsers[entityID] = len(entityUsers[i].IDs)
+			counts.MaxEntityUsers[entityID] = entityUsers[i].Max
+		}
+
+		errs = counts.CheckAdds(as, limits)
+		ueKeys := make([]*datastore.Key, 0, len(as))
+		ues = make([]*UserEntity, 0, len(as))
+		for i, a := range as {
+			if errs[i] != nil {
+				continue
+			}
+			userIdx[a.UserID].IDs = append(userIdx[a.UserID].IDs, a.EntityID)
+			entityIdx[a.EntityID].IDs = append(entityIdx[a.EntityID].IDs, a.UserID)
+			ueKeys = append(ueKeys, datastore.IncompleteKey(userEntityKind, nil))
+			ues = append(ues, NewUserEntity(a.UserID, a.EntityID, a.Role))
+		}
+		if len(ues) == 0 {
+			return nil
+		}
+		if _, err := tx.PutMulti(userKeys, userEntities); err != nil {
+			return err
+		}
+		if _, err := tx.PutMulti(entityKeys, entityUsers); err != nil {
+			return err
+		}
+		_, err := tx.PutMulti(ueKeys, ues)
 		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*storage.AssociationEvent, len(ues))
+	for i, ue := range ues {
+		events[i] = ue.LastEvent()
+	}
+	s.feed.Publish(events...)
+	s.logger.Debug("storer added entities to users", logAddEntities(as, errs)...)
+	return errs, nil
+}
+
+func (s *storer) RemoveEntity(ctx context.Context, userID, entityID string) error {
+	if userID == "" {
+		return api.ErrEmptyUserID
 	}
-	s.logger.Debug("storer added entity to user", logAddEntityFields(userID, entityID)...)
+	if entityID == "" {
+		return api.ErrEmptyEntityID
+	}
+	var removed *UserEntity
+	err := s.updateCurrent(ctx, userID, entityID, func(tx transaction, ue *UserEntity) error {
+		userEntities, entityUsers, err := getIndices(tx, userID, entityID)
+		if err != nil {
+			return err
+		}
+		userEntities.IDs = without(userEntities.IDs, entityID)
+		entityUsers.IDs = without(entityUsers.IDs, userID)
+		if err := putIndices(tx, userID, entityID, userEntities, entityUsers); err != nil {
+			return err
+		}
+		ue.MarkRemoved()
+		removed = ue
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.feed.Publish(removed.LastEvent())
+	s.logger.Debug("storer removed entity from user", logUserEntityFields(userID, entityID)...)
 	return nil
 }
 
-func (s *storer) GetEntities(userID string) ([]string, error) {
+func (s *storer) UpdateRole(ctx context.Context, userID, entityID string, role api.Role) error {
 	if userID == "" {
-		return nil, api.ErrEmptyUserID
+		return api.ErrEmptyUserID
+	}
+	if entityID == "" {
+		return api.ErrEmptyEntityID
+	}
+	var updated *UserEntity
+	err := s.updateCurrent(ctx, userID, entityID, func(tx transaction, ue *UserEntity) error {
+		updated = nil
+		if api.Role(ue.Role) == role {
+			return nil
+		}
+		updated = ue.UpdateRole(role)
+		key := datastore.IncompleteKey(userEntityKind, nil)
+		_, err := tx.Put(key, updated)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if updated != nil {
+		s.feed.Publish(updated.LastEvent())
+	}
+	s.logger.Debug("storer updated role", logUpdateRole(userID, entityID, role)...)
+	return nil
+}
+
+// updateCurrent applies the update to the user's current association with the entity and puts
+// it back, all within a transaction.
+func (s *storer) updateCurrent(
+	ctx context.Context, userID, entityID string, update func(transaction, *UserEntity) error,
+) error {
+	q := getEntitiesQuery(userID).Filter("entity_id = ", entityID)
+	getCtx, getCancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
+	defer getCancel()
+	iter := s.iters.Iterator(s.client.Run(getCtx, q))
+	key, err := iter.Next(&UserEntity{})
+	if err == iterator.Done {
+		return storage.ErrUserEntityNotExists
+	} else if err != nil {
+		return err
+	}
+
+	putCtx, putCancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
+	defer putCancel()
+	return s.tx.RunInTransaction(putCtx, func(tx transaction) error {
+		// re-read the association in case it was removed since the query above
+		ue := &UserEntity{}
+		if err := tx.Get(key, ue); err != nil {
+			return err
+		}
+		if ue.Removed {
+			return storage.ErrUserEntityNotExists
+		}
+		if err := update(tx, ue); err != nil {
+			return err
+		}
+		_, err := tx.Put(key, ue)
+		return err
+	})
+}
+
+func (s *storer) GetEntities(
+	ctx context.Context, userID string, page *storage.Page,
+) ([]*storage.Association, string, error) {
+	if userID == "" {
+		return nil, "", api.ErrEmptyUserID
+	}
+	q := getEntitiesQuery(userID).Order("entity_id")
+	as, next, err := s.queryAssociations(ctx, q, page, includeAll)
+	if err != nil {
+		return nil, "", err
+	}
+	s.logger.Debug("storer got entities for user", logGetEntities(userID, as)...)
+	return as, next, nil
+}
+
+func (s *storer) GetEntitiesAsOf(
+	ctx context.Context, userID string, asOf time.Time, page *storage.Page,
+) ([]*storage.Association, string, error) {
+	if userID == "" {
+		return nil, "", api.ErrEmptyUserID
 	}
-	q := getEntitiesQuery(userID)
-	ctx, cancel := context.WithTimeout(context.Background(), s.params.GetQueryTimeout)
+	// added and removed times aren't indexed, so we filter on them here
+	q := getAllEntitiesQuery(userID).Order("entity_id")
+	as, next, err := s.queryAssociations(ctx, q, page, func(ue *UserEntity) bool {
+		return ue.ActiveAt(asOf)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	s.logger.Debug("storer got entities for user as of time",
+		logGetEntitiesAsOf(userID, asOf, as)...)
+	return as, next, nil
+}
+
+// queryAssociations returns the page of associations from the query that are included, in the
+// query's order, along with the next page token, which is the encoded cursor after the page's
+// last association. Since not every queried association may be included, the query is read past
+// the end of a full page to tell whether there is a next page.
+func (s *storer) queryAssociations(
+	ctx context.Context,
+	q *datastore.Query,
+	page *storage.Page,
+	include func(ue *UserEntity) bool,
+) ([]*storage.Association, string, error) {
+	if page != nil && page.Token != "" {
+		cursor, err := datastore.DecodeCursor(page.Token)
+		if err != nil {
+			return nil, "", storage.ErrInvalidPageToken
+		}
+		q = q.Start(cursor)
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
 	defer cancel()
-	iter := s.client.Run(ctx, q)
-	s.iter.Init(iter)
-	entityIDs := make([]string, 0)
+	iter := s.iters.Iterator(s.client.Run(ctx, q))
+	as := make([]*storage.Association, 0)
+	next := ""
 	for {
 		ue := &UserEntity{}
-		if _, err := s.iter.Next(ue); err == iterator.Done {
+		if _, err := iter.Next(ue); err == iterator.Done {
 			// no more results
-			break
+			return as, "", nil
 		} else if err != nil {
+			return nil, "", err
+		}
+		if !include(ue) {
+			continue
+		}
+		if page.Limited() && len(as) == page.Size {
+			// page is full and there's at least one more association
+			return as, next, nil
+		}
+		as = append(as, ue.Association())
+		if page.Limited() && len(as) == page.Size {
+			cursor, err := iter.Cursor()
+			if err != nil {
+				return nil, "", err
+			}
+			next = cursor.String()
+		}
+	}
+}
+
+// includeAll includes every queried association.
+func includeAll(*UserEntity) bool {
+	return true
+}
+
+func (s *storer) GetEntitiesBatch(
+	ctx context.Context, userIDs []string,
+) (map[string][]string, error) {
+	for _, userID := range userIDs {
+		if userID == "" {
+			return nil, api.ErrEmptyUserID
+		}
+	}
+	// each built user index holds their current entity IDs, so they can all be read at once
+	keys := nameKeys(userEntitiesKind, userIDs)
+	userEntities := newIndices(len(keys))
+	ctx, cancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
+	defer cancel()
+	err := resolveMultiErr(s.client.GetMulti(ctx, keys, userEntities), userEntities)
+	if err != nil {
+		return nil, err
+	}
+	entityIDs := make(map[string][]string, len(userIDs))
+	for i, userID := range userIDs {
+		if userEntities[i].Built {
+			entityIDs[userID] = append(make([]string, 0), userEntities[i].IDs...)
+			continue
+		}
+		// the user's index may be missing associations added before the indices existed
+		if entityIDs[userID], err = s.queryIndexIDs(ctx, keys[i]); err != nil {
 			return nil, err
 		}
-		entityIDs = append(entityIDs, ue.EntityID)
 	}
-	s.logger.Debug("storer got entities for user", logGetEntities(userID, entityIDs)...)
+	s.logger.Debug("storer got entities for users", logGetEntitiesBatch(entityIDs)...)
 	return entityIDs, nil
 }
 
-func (s *storer) CountEntities(userID string) (int, error) {
+func (s *storer) GetUsers(
+	ctx context.Context, entityID string, page *storage.Page,
+) ([]string, string, error) {
+	if entityID == "" {
+		return nil, "", api.ErrEmptyEntityID
+	}
+	q := getUsersQuery(entityID).Order("user_id")
+	as, next, err := s.queryAssociations(ctx, q, page, includeAll)
+	if err != nil {
+		return nil, "", err
+	}
+	userIDs := make([]string, len(as))
+	for i, a := range as {
+		userIDs[i] = a.UserID
+	}
+	s.logger.Debug("storer got users for entity", logGetUsers(entityID, userIDs)...)
+	return userIDs, next, nil
+}
+
+func (s *storer) CountEntities(ctx context.Context, userID string) (int, error) {
 	if userID == "" {
 		return 0, api.ErrEmptyUserID
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), s.params.GetQueryTimeout)
+	ctx, cancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
 	defer cancel()
 	n, err := s.client.Count(ctx, getEntitiesQuery(userID))
 	if err != nil {
@@ -122,11 +473,11 @@ func (s *storer) CountEntities(userID string) (int, error) {
 	return n, nil
 }
 
-func (s *storer) CountUsers(entityID string) (int, error) {
+func (s *storer) CountUsers(ctx context.Context, entityID string) (int, error) {
 	if entityID == "" {
 		return 0, api.ErrEmptyEntityID
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), s.params.GetQueryTimeout)
+	ctx, cancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
 	defer cancel()
 	n, err := s.client.Count(ctx, getUsersQuery(entityID))
 	if err != nil {
@@ -136,41 +487,588 @@ func (s *storer) CountUsers(entityID string) (int, error) {
 	return n, nil
 }
 
-func (s *storer) countUserEntities(userID, entityID string) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), s.params.CountQueryTimeout)
+func (s *storer) GetHistory(
+	ctx context.Context, userID, entityID string,
+) ([]*storage.AssociationEvent, error) {
+	if userID == "" && entityID == "" {
+		return nil, api.ErrEmptyUserAndEntityID
+	}
+	q := getHistoryQuery(userID, entityID)
+	ctx, cancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
 	defer cancel()
-	q := getEntitiesQuery(userID).Filter("entity_id = ", entityID)
-	n, err := s.client.Count(ctx, q)
+	iter := s.iters.Iterator(s.client.Run(ctx, q))
+	events := make([]*storage.AssociationEvent, 0)
+	for {
+		ue := &UserEntity{}
+		if _, err := iter.Next(ue); err == iterator.Done {
+			// no more results
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		events = append(events, ue.Events()...)
+	}
+	storage.SortEvents(events)
+	s.logger.Debug("storer got association history", logGetHistory(userID, entityID, events)...)
+	return events, nil
+}
+
+func (s *storer) SetUserLimit(ctx context.Context, userID string, maxEntities int) error {
+	if userID == "" {
+		return api.ErrEmptyUserID
+	}
+	key := datastore.NameKey(userEntitiesKind, userID, nil)
+	if err := s.setLimit(ctx, key, maxEntities); err != nil {
+		return err
+	}
+	s.logger.Debug("storer set user limit", logSetUserLimit(userID, maxEntities)...)
+	return nil
+}
+
+func (s *storer) SetEntityLimit(ctx context.Context, entityID string, maxUsers int) error {
+	if entityID == "" {
+		return api.ErrEmptyEntityID
+	}
+	key := datastore.NameKey(entityUsersKind, entityID, nil)
+	if err := s.setLimit(ctx, key, maxUsers); err != nil {
+		return err
+	}
+	s.logger.Debug("storer set entity limit", logSetEntityLimit(entityID, maxUsers)...)
+	return nil
+}
+
+func (s *storer) setLimit(ctx context.Context, key *datastore.Key, max int) error {
+	ctx, cancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
+	defer cancel()
+	return s.tx.RunInTransaction(ctx, func(tx transaction) error {
+		idx, err := getIndex(tx, key)
+		if err != nil {
+			return err
+		}
+		idx.Max = max
+		_, err = tx.Put(key, idx)
+		return err
+	})
+}
+
+func (s *storer) PutUser(ctx context.Context, u *api.UserProfile) error {
+	if u.UserId == "" {
+		return api.ErrEmptyUserID
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
+	defer cancel()
+	if _, err := s.client.Put(ctx, userKey(u.UserId), newUserProfile(u)); err != nil {
+		return err
+	}
+	s.logger.Debug("storer put user", logUser(u)...)
+	return nil
+}
+
+func (s *storer) GetUser(ctx context.Context, userID string) (*api.UserProfile, error) {
+	if userID == "" {
+		return nil, api.ErrEmptyUserID
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
+	defer cancel()
+	up := &userProfile{}
+	if err := s.client.Get(ctx, userKey(userID), up); err == datastore.ErrNoSuchEntity {
+		return nil, storage.ErrUserNotExists
+	} else if err != nil {
+		return nil, err
+	}
+	u := up.profile(userID)
+	s.logger.Debug("storer got user", logUser(u)...)
+	return u, nil
+}
+
+func (s *storer) UpdateUser(
+	ctx context.Context, u *api.UserProfile,
+) (*api.UserProfile, error) {
+	if u.UserId == "" {
+		return nil, api.ErrEmptyUserID
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
+	defer cancel()
+	key := userKey(u.UserId)
+	var updated *api.UserProfile
+	err := s.tx.RunInTransaction(ctx, func(tx transaction) error {
+		up := &userProfile{}
+		if err := tx.Get(key, up); err == datastore.ErrNoSuchEntity {
+			return storage.ErrUserNotExists
+		} else if err != nil {
+			return err
+		}
+		updated = up.profile(u.UserId)
+		storage.MergeUser(updated, u)
+		_, err := tx.Put(key, newUserProfile(updated))
+		return err
+	})
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	s.logger.Debug("storer counted user entities", logCountUserEntities(userID, entityID, n)...)
-	return n, nil
+	s.logger.Debug("storer updated user", logUser(updated)...)
+	return updated, nil
+}
+
+func (s *storer) Watch(
+	ctx context.Context,
+	userID, entityID string,
+	fromRevision uint64,
+	send func(*storage.AssociationEvent) error,
+) error {
+	if userID == "" && entityID == "" {
+		return api.ErrEmptyUserAndEntityID
+	}
+	s.logger.Debug("storer watching associations",
+		logWatch(userID, entityID, fromRevision)...)
+	return s.feed.Watch(ctx, userID, entityID, fromRevision, send)
+}
+
+func (s *storer) ExportAssociations(
+	ctx context.Context, send func([]*storage.AssociationVersion) error,
+) error {
+	// DataStore orders by user ID without a composite index, so each user's versions are
+	// sorted by entity ID and time once all of them have been read
+	q := datastore.NewQuery(userEntityKind).Order("user_id")
+	iter := s.iters.Iterator(s.client.Run(ctx, q))
+	n := 0
+	var userVersions []*storage.AssociationVersion
+	sendUser := func() error {
+		storage.SortVersions(userVersions)
+		for _, group := range storage.GroupVersions(userVersions) {
+			if err := send(group); err != nil {
+				return err
+			}
+			n++
+		}
+		userVersions = nil
+		return nil
+	}
+	for {
+		ue := &UserEntity{}
+		if _, err := iter.Next(ue); err == iterator.Done {
+			// no more results
+			break
+		} else if err != nil {
+			return err
+		}
+		if len(userVersions) > 0 && userVersions[0].UserID != ue.UserID {
+			if err := sendUser(); err != nil {
+				return err
+			}
+		}
+		userVersions = append(userVersions, ue.Version())
+	}
+	if err := sendUser(); err != nil {
+		return err
+	}
+	s.logger.Debug("storer exported associations", logExportAssociations(n)...)
+	return nil
+}
+
+func (s *storer) ImportAssociation(
+	ctx context.Context, versions []*storage.AssociationVersion, overwrite bool,
+) (bool, error) {
+	if len(versions) == 0 {
+		return false, nil
+	}
+	userID, entityID := versions[0].UserID, versions[0].EntityID
+	q := getHistoryQuery(userID, entityID)
+	getCtx, getCancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
+	defer getCancel()
+	iter := s.iters.Iterator(s.client.Run(getCtx, q))
+	existing := make([]*datastore.Key, 0)
+	for {
+		key, err := iter.Next(&UserEntity{})
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return false, err
+		}
+		existing = append(existing, key)
+	}
+	exists := len(existing) > 0
+	if exists && !overwrite {
+		return false, storage.ErrUserEntityExists
+	}
+
+	ues := make([]*UserEntity, len(versions))
+	keys := make([]*datastore.Key, len(versions))
+	for i, v := range versions {
+		ues[i] = newUserEntity(v)
+		keys[i] = datastore.IncompleteKey(userEntityKind, nil)
+	}
+	putCtx, putCancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
+	defer putCancel()
+	err := s.tx.RunInTransaction(putCtx, func(tx transaction) error {
+		userEntities, entityUsers, err := getIndices(tx, userID, entityID)
+		if err != nil {
+			return err
+		}
+		if contains(userEntities.IDs, entityID) && !overwrite {
+			return storage.ErrUserEntityExists
+		}
+		if err := tx.DeleteMulti(existing); err != nil {
+			return err
+		}
+		userEntities.IDs = without(userEntities.IDs, entityID)
+		entityUsers.IDs = without(entityUsers.IDs, userID)
+		if !versions[len(versions)-1].Removed {
+			userEntities.IDs = append(userEntities.IDs, entityID)
+			entityUsers.IDs = append(entityUsers.IDs, userID)
+		}
+		if err := putIndices(tx, userID, entityID, userEntities, entityUsers); err != nil {
+			return err
+		}
+		_, err = tx.PutMulti(keys, ues)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	s.feed.Publish(storage.ImportedEvent(versions))
+	s.logger.Debug("storer imported association",
+		logImportAssociation(userID, entityID, len(versions), exists)...)
+	return exists, nil
 }
 
 func (s *storer) Close() error {
 	return nil
 }
 
-// NewUserEntity creates a new *UserEntity from the given user and entity ID.
-func NewUserEntity(userID, entityID string) *UserEntity {
-	now := time.Now()
+// buildIndices builds each of the indices with the given keys that hasn't been built yet from
+// the current user_entity entities of its user or entity, so that it includes the associations
+// added before the indices existed. Only adds, which check limits and duplicates against the
+// indices, need them built first. Other updates keep an index unbuilt, since building it later
+// finds the same associations.
+func (s *storer) buildIndices(ctx context.Context, keys []*datastore.Key) error {
+	ctx, cancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
+	defer cancel()
+	idxs := newIndices(len(keys))
+	err := s.tx.RunInTransaction(ctx, func(tx transaction) error {
+		return resolveMultiErr(tx.GetMulti(keys, idxs), idxs)
+	})
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		if idxs[i].Built {
+			continue
+		}
+		if err := s.buildIndex(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildIndex builds the index with the given key from the current user_entity entities of its
+// user or entity, unless another call has built it in the meantime. Since associations are
+// only added to built indices, the query only needs to find those added before the indices
+// existed.
+func (s *storer) buildIndex(ctx context.Context, key *datastore.Key) error {
+	ids, err := s.queryIndexIDs(ctx, key)
+	if err != nil {
+		return err
+	}
+	err = s.tx.RunInTransaction(ctx, func(tx transaction) error {
+		idx, err := getIndex(tx, key)
+		if err != nil {
+			return err
+		}
+		if idx.Built {
+			return nil
+		}
+		idx.IDs, idx.Built = ids, true
+		_, err = tx.Put(key, idx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.logger.Debug("storer built association index", logBuildIndex(key, ids)...)
+	return nil
+}
+
+// queryIndexIDs returns the IDs the index with the given key should hold, i.e., those of the
+// current associations of its user or entity, by querying the user_entity entities.
+func (s *storer) queryIndexIDs(ctx context.Context, key *datastore.Key) ([]string, error) {
+	q, id := getUsersQuery(key.Name), func(ue *UserEntity) string { return ue.UserID }
+	if key.Kind == userEntitiesKind {
+		q, id = getEntitiesQuery(key.Name), func(ue *UserEntity) string { return ue.EntityID }
+	}
+	iter := s.iters.Iterator(s.client.Run(ctx, q))
+	ids := make([]string, 0)
+	for {
+		ue := &UserEntity{}
+		if _, err := iter.Next(ue); err == iterator.Done {
+			// no more results
+			return ids, nil
+		} else if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id(ue))
+	}
+}
+
+// getIndices gets the user's entities and entity's users indices within the transaction. A
+// missing index is treated as empty.
+func getIndices(tx transaction, userID, entityID string) (
+	*associationIndex, *associationIndex, error,
+) {
+	userEntities, err := getIndex(tx, datastore.NameKey(userEntitiesKind, userID, nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	entityUsers, err := getIndex(tx, datastore.NameKey(entityUsersKind, entityID, nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	return userEntities, entityUsers, nil
+}
+
+func getIndex(tx transaction, key *datastore.Key) (*associationIndex, error) {
+	idx := &associationIndex{}
+	if err := tx.Get(key, idx); err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+	return idx, nil
+}
+
+func newIndices(n int) []*associationIndex {
+	idxs := make([]*associationIndex, n)
+	for i := range idxs {
+		idxs[i] = &associationIndex{}
+	}
+	return idxs
+}
+
+// resolveMultiErr returns the error from a GetMulti call for the given indices, ignoring any
+// missing ones, which are left empty.
+func resolveMultiErr(err error, idxs []*associationIndex) error {
+	if err == nil {
+		return nil
+	}
+	merr, ok := err.(datastore.MultiError)
+	if !ok {
+		return err
+	}
+	for i, err := range merr {
+		if err == datastore.ErrNoSuchEntity {
+			idxs[i] = &associationIndex{}
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putIndices(
+	tx transaction, userID, entityID string, userEntities, entityUsers *associationIndex,
+) error {
+	userKey := datastore.NameKey(userEntitiesKind, userID, nil)
+	if _, err := tx.Put(userKey, userEntities); err != nil {
+		return err
+	}
+	entityKey := datastore.NameKey(entityUsersKind, entityID, nil)
+	_, err := tx.Put(entityKey, entityUsers)
+	return err
+}
+
+// max returns the index's override of the max number of IDs, if it has one, and the given
+// default otherwise.
+func (idx *associationIndex) max(dflt int) int {
+	if idx.Max > 0 {
+		return idx.Max
+	}
+	return dflt
+}
+
+// distinctIDs returns the distinct, non-empty user and entity IDs of the associations.
+func distinctIDs(as []*storage.Association) ([]string, []string) {
+	userIDs, entityIDs := make([]string, 0), make([]string, 0)
+	seenUsers, seenEntities := make(map[string]bool), make(map[string]bool)
+	for _, a := range as {
+		if a.UserID != "" && !seenUsers[a.UserID] {
+			seenUsers[a.UserID] = true
+			userIDs = append(userIDs, a.UserID)
+		}
+		if a.EntityID != "" && !seenEntities[a.EntityID] {
+			seenEntities[a.EntityID] = true
+			entityIDs = append(entityIDs, a.EntityID)
+		}
+	}
+	return userIDs, entityIDs
+}
+
+// indexKeys returns the keys of the users' entities and entities' users indices.
+func indexKeys(userIDs, entityIDs []string) []*datastore.Key {
+	return append(nameKeys(userEntitiesKind, userIDs), nameKeys(entityUsersKind, entityIDs)...)
+}
+
+func nameKeys(kind string, names []string) []*datastore.Key {
+	keys := make([]*datastore.Key, len(names))
+	for i, name := range names {
+		keys[i] = datastore.NameKey(kind, name, nil)
+	}
+	return keys
+}
+
+func contains(ids []string, id string) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+func without(ids []string, id string) []string {
+	kept := make([]string, 0, len(ids))
+	for _, other := range ids {
+		if other != id {
+			kept = append(kept, other)
+		}
+	}
+	return kept
+}
+
+// NewUserEntity creates a new *UserEntity from the given user and entity ID and role.
+func NewUserEntity(userID, entityID string, role api.Role) *UserEntity {
+	return newUserEntity(storage.NewAssociationVersion(userID, entityID, role))
+}
+
+// newUserEntity converts the association version into the *UserEntity that stores it.
+func newUserEntity(v *storage.AssociationVersion) *UserEntity {
+	modified := v.ModifiedTime()
 	return &UserEntity{
-		UserID:       userID,
-		EntityID:     entityID,
-		Removed:      false,
-		ModifiedDate: int32(now.Unix() / secsPerDay),
-		ModifiedTime: now,
-		AddedTime:    now,
+		UserID:       v.UserID,
+		EntityID:     v.EntityID,
+		Role:         int32(v.Role),
+		Removed:      v.Removed,
+		ModifiedDate: int32(modified.Unix() / secsPerDay),
+		ModifiedTime: modified,
+		AddedTime:    v.AddedTime,
+		RemovedTime:  v.RemovedTime,
+		RoleUpdated:  v.RoleUpdated,
+		Superseded:   v.Superseded,
+	}
+}
+
+// Version returns the association version the *UserEntity stores.
+func (ue *UserEntity) Version() *storage.AssociationVersion {
+	return &storage.AssociationVersion{
+		UserID:      ue.UserID,
+		EntityID:    ue.EntityID,
+		Role:        api.Role(ue.Role),
+		Removed:     ue.Removed,
+		AddedTime:   ue.AddedTime,
+		RemovedTime: ue.RemovedTime,
+		RoleUpdated: ue.RoleUpdated,
+		Superseded:  ue.Superseded,
+	}
+}
+
+// MarkRemoved marks the association as removed as of now.
+func (ue *UserEntity) MarkRemoved() {
+	v := ue.Version()
+	v.MarkRemoved()
+	*ue = *newUserEntity(v)
+}
+
+// UpdateRole marks the association as superseded as of now and returns the association that
+// replaces it with the given role.
+func (ue *UserEntity) UpdateRole(role api.Role) *UserEntity {
+	v := ue.Version()
+	updated := v.UpdateRole(role)
+	*ue = *newUserEntity(v)
+	return newUserEntity(updated)
+}
+
+// Association returns the user-entity association with its role.
+func (ue *UserEntity) Association() *storage.Association {
+	return ue.Version().Association()
+}
+
+// ActiveAt returns whether the association was active (i.e., added and not yet removed) at the
+// given time.
+func (ue *UserEntity) ActiveAt(t time.Time) bool {
+	return ue.Version().ActiveAt(t)
+}
+
+// Events returns the add (or role update) and, if removed, remove events for the association.
+func (ue *UserEntity) Events() []*storage.AssociationEvent {
+	return ue.Version().Events()
+}
+
+// LastEvent returns the association's most recent event.
+func (ue *UserEntity) LastEvent() *storage.AssociationEvent {
+	return ue.Version().LastEvent()
+}
+
+func newUserProfile(u *api.UserProfile) *userProfile {
+	up := &userProfile{
+		DisplayName:  u.DisplayName,
+		Email:        u.Email,
+		Phone:        u.Phone,
+		Locale:       u.Locale,
+		Timezone:     u.Timezone,
+		Attributes:   make([]userAttribute, 0, len(u.Attributes)),
+		ModifiedTime: time.Now(),
+	}
+	for k, v := range u.Attributes {
+		up.Attributes = append(up.Attributes, userAttribute{Key: k, Value: v})
+	}
+	sort.Slice(up.Attributes, func(i, j int) bool {
+		return up.Attributes[i].Key < up.Attributes[j].Key
+	})
+	return up
+}
+
+// profile returns the API representation of the user's profile.
+func (up *userProfile) profile(userID string) *api.UserProfile {
+	u := &api.UserProfile{
+		UserId:      userID,
+		DisplayName: up.DisplayName,
+		Email:       up.Email,
+		Phone:       up.Phone,
+		Locale:      up.Locale,
+		Timezone:    up.Timezone,
+	}
+	if len(up.Attributes) > 0 {
+		u.Attributes = make(map[string]string, len(up.Attributes))
+		for _, a := range up.Attributes {
+			u.Attributes[a.Key] = a.Value
+		}
 	}
+	return u
+}
+
+func userKey(userID string) *datastore.Key {
+	return datastore.NameKey(userKind, userID, nil)
 }
 
 func getEntitiesQuery(userID string) *datastore.Query {
-	return datastore.NewQuery(userEntityKind).
-		Filter("user_id = ", userID).
+	return getAllEntitiesQuery(userID).
 		Filter("removed = ", false)
 }
 
+func getAllEntitiesQuery(userID string) *datastore.Query {
+	return datastore.NewQuery(userEntityKind).
+		Filter("user_id = ", userID)
+}
+
+func getHistoryQuery(userID, entityID string) *datastore.Query {
+	q := datastore.NewQuery(userEntityKind)
+	if userID != "" {
+		q = q.Filter("user_id = ", userID)
+	}
+	if entityID != "" {
+		q = q.Filter("entity_id = ", entityID)
+	}
+	return q
+}
+
 func getUsersQuery(entityID string) *datastore.Query {
 	return datastore.NewQuery(userEntityKind).
 		Filter("entity_id = ", entityID).
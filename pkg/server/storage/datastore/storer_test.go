@@ -2,10 +2,17 @@ package datastore
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/datastore"
 	"github.com/elixirhealth/user/pkg/server/storage"
+	"github.com/elixirhealth/user/pkg/server/storage/storagetest"
 	api "github.com/elixirhealth/user/pkg/userapi"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
@@ -17,112 +24,880 @@ var (
 	errTest = errors.New("some test error")
 )
 
+func TestDatastoreStorer_Conformance(t *testing.T) {
+	if os.Getenv(EmulatorHostEnv) == "" {
+		t.Skipf("%s not set", EmulatorHostEnv)
+	}
+	n := 0
+	storagetest.Run(t, func() storage.Storer {
+		// each project is a separate namespace in the emulator, so each test starts empty
+		n++
+		gcpProjectID := fmt.Sprintf("storagetest-%d-%d", time.Now().UnixNano(), n)
+		s, err := New(gcpProjectID, storage.NewDefaultParameters(), zap.NewNop())
+		assert.Nil(t, err)
+		return s
+	})
+}
+
 func TestDatastoreStorer_AddEntity_ok(t *testing.T) {
 	params := storage.NewDefaultParameters()
 	lg := zap.NewNop()
-	client := &fixedDatastoreClient{
-		putValues: make([]*UserEntity, 0),
+	tx := newFixedTransactor()
+	s := &storer{
+		params: params,
+		client: &fixedDatastoreClient{},
+		tx:     tx,
+		iters:  &fixedDatastoreIter{},
+		feed:   storage.NewBroadcaster(0),
+		logger: lg,
+	}
+	limits := &storage.Limits{MaxUserEntities: 2, MaxEntityUsers: 2}
+
+	userID, entityID := "some user", "some entity"
+	err := s.AddEntity(context.Background(), userID, entityID, api.Role_OWNER, limits)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tx.putUserEntities))
+
+	putValue := tx.putUserEntities[0]
+	assert.Equal(t, userID, putValue.UserID)
+	assert.Equal(t, entityID, putValue.EntityID)
+	assert.False(t, putValue.Removed)
+	assert.NotZero(t, putValue.ModifiedDate)
+	assert.NotZero(t, putValue.ModifiedTime)
+	assert.NotZero(t, putValue.AddedTime)
+	assert.Zero(t, putValue.RemovedTime)
+
+	assert.Equal(t, []string{entityID}, tx.index(userEntitiesKind, userID).IDs)
+	assert.Equal(t, []string{userID}, tx.index(entityUsersKind, entityID).IDs)
+	assert.True(t, tx.index(userEntitiesKind, userID).Built)
+	assert.True(t, tx.index(entityUsersKind, entityID).Built)
+}
+
+func TestDatastoreStorer_AddEntity_legacy(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	userID, entityID := "some user", "some entity"
+	limits := &storage.Limits{MaxUserEntities: 2, MaxEntityUsers: 2}
+	newStorer := func(tx *fixedTransactor, userEntities, entityUsers []*UserEntity) *storer {
+		return &storer{
+			params: params,
+			client: &fixedDatastoreClient{},
+			tx:     tx,
+			iters:  &fixedDatastoreIter{next: [][]*UserEntity{userEntities, entityUsers}},
+			feed:   storage.NewBroadcaster(0),
+			logger: lg,
+		}
+	}
+
+	// associations added before the indices existed count toward the limits
+	tx := newFixedTransactor()
+	s := newStorer(tx, []*UserEntity{
+		{UserID: userID, EntityID: "entity 1"},
+		{UserID: userID, EntityID: "entity 2"},
+	}, nil)
+	err := s.AddEntity(context.Background(), userID, entityID, api.Role_OWNER, limits)
+	assert.Equal(t, storage.ErrTooManyUserEntities, err)
+	assert.Equal(t, []string{"entity 1", "entity 2"}, tx.index(userEntitiesKind, userID).IDs)
+	assert.True(t, tx.index(userEntitiesKind, userID).Built)
+	assert.Empty(t, tx.index(entityUsersKind, entityID).IDs)
+	assert.True(t, tx.index(entityUsersKind, entityID).Built)
+	assert.Empty(t, tx.putUserEntities)
+
+	// and are found as duplicates
+	tx = newFixedTransactor()
+	legacy := &UserEntity{UserID: userID, EntityID: entityID}
+	s = newStorer(tx, []*UserEntity{legacy}, []*UserEntity{legacy})
+	err = s.AddEntity(context.Background(), userID, entityID, api.Role_OWNER, limits)
+	assert.Equal(t, storage.ErrUserEntityExists, err)
+
+	// an index with only a limit override set is built while keeping the override
+	tx = newFixedTransactor()
+	entityKey := datastore.NameKey(entityUsersKind, entityID, nil)
+	tx.indices[entityKey.String()] = &associationIndex{Max: 1}
+	s = newStorer(tx, nil, []*UserEntity{{UserID: "user 1", EntityID: entityID}})
+	err = s.AddEntity(context.Background(), userID, entityID, api.Role_OWNER, limits)
+	assert.Equal(t, storage.ErrTooManyEntityUsers, err)
+	assert.Equal(t, []string{"user 1"}, tx.index(entityUsersKind, entityID).IDs)
+	assert.Equal(t, 1, tx.index(entityUsersKind, entityID).Max)
+
+	// built indices aren't rebuilt
+	tx = newFixedTransactor()
+	tx.putIndex(userEntitiesKind, userID, []string{})
+	tx.putIndex(entityUsersKind, entityID, []string{})
+	s = newStorer(tx, []*UserEntity{legacy}, []*UserEntity{legacy})
+	err = s.AddEntity(context.Background(), userID, entityID, api.Role_OWNER, limits)
+	assert.Nil(t, err)
+	assert.Len(t, tx.putUserEntities, 1)
+
+	// query err
+	s = newStorer(newFixedTransactor(), nil, nil)
+	s.iters = &fixedDatastoreIter{err: errTest}
+	err = s.AddEntity(context.Background(), userID, entityID, api.Role_OWNER, limits)
+	assert.Equal(t, errTest, err)
+}
+
+func TestDatastoreStorer_AddEntity_err(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	userID, entityID := "some user", "some entity"
+	limits := &storage.Limits{MaxUserEntities: 2, MaxEntityUsers: 2}
+	withIndices := func(userEntities, entityUsers []string) *fixedTransactor {
+		tx := newFixedTransactor()
+		tx.putIndex(userEntitiesKind, userID, userEntities)
+		tx.putIndex(entityUsersKind, entityID, entityUsers)
+		return tx
+	}
+	cases := map[string]struct {
+		s        *storer
+		userID   string
+		entityID string
+		expected error
+	}{
+		"empty user ID": {
+			s:        &storer{params: params, logger: lg},
+			userID:   "",
+			entityID: entityID,
+			expected: api.ErrEmptyUserID,
+		},
+		"empty entity ID": {
+			s:        &storer{params: params, logger: lg},
+			userID:   userID,
+			entityID: "",
+			expected: api.ErrEmptyEntityID,
+		},
+		"get index err": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				tx:     &fixedTransactor{getErr: errTest},
+				iters:  &fixedDatastoreIter{},
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: errTest,
+		},
+		"already exists": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				tx:     withIndices([]string{entityID}, []string{userID}),
+				iters:  &fixedDatastoreIter{},
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: ErrUserEntityExists,
+		},
+		"too many entity users": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				tx:     withIndices(nil, []string{"user 1", "user 2"}),
+				iters:  &fixedDatastoreIter{},
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: storage.ErrTooManyEntityUsers,
+		},
+		"too many user entities": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				tx:     withIndices([]string{"entity 1", "entity 2"}, nil),
+				iters:  &fixedDatastoreIter{},
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: storage.ErrTooManyUserEntities,
+		},
+		"too many entity users for override": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				iters:  &fixedDatastoreIter{},
+				tx: &fixedTransactor{
+					indices: map[string]*associationIndex{
+						datastore.NameKey(entityUsersKind, entityID, nil).String(): {
+							IDs:   []string{"user 1"},
+							Max:   1,
+							Built: true,
+						},
+					},
+				},
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: storage.ErrTooManyEntityUsers,
+		},
+		"put err": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				tx:     &fixedTransactor{putErr: errTest},
+				iters:  &fixedDatastoreIter{},
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		err := c.s.AddEntity(context.Background(), c.userID, c.entityID, api.Role_OWNER, limits)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestDatastoreStorer_SetLimit_ok(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	userID, entityID := "some user", "some entity"
+	tx := newFixedTransactor()
+	tx.putIndex(userEntitiesKind, userID, []string{entityID})
+	s := &storer{
+		params: params,
+		tx:     tx,
+		logger: lg,
+	}
+
+	err := s.SetUserLimit(context.Background(), userID, 32)
+	assert.Nil(t, err)
+	assert.Equal(t, 32, tx.index(userEntitiesKind, userID).Max)
+	assert.Equal(t, []string{entityID}, tx.index(userEntitiesKind, userID).IDs)
+
+	err = s.SetEntityLimit(context.Background(), entityID, 1024)
+	assert.Nil(t, err)
+	assert.Equal(t, 1024, tx.index(entityUsersKind, entityID).Max)
+
+	err = s.SetUserLimit(context.Background(), userID, 0)
+	assert.Nil(t, err)
+	assert.Zero(t, tx.index(userEntitiesKind, userID).Max)
+}
+
+func TestDatastoreStorer_SetLimit_err(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	s := &storer{params: params, logger: lg}
+	assert.Equal(t, api.ErrEmptyUserID, s.SetUserLimit(context.Background(), "", 32))
+	assert.Equal(t, api.ErrEmptyEntityID, s.SetEntityLimit(context.Background(), "", 1024))
+
+	s.tx = &fixedTransactor{getErr: errTest}
+	assert.Equal(t, errTest, s.SetUserLimit(context.Background(), "some user", 32))
+
+	s.tx = &fixedTransactor{putErr: errTest}
+	assert.Equal(t, errTest, s.SetEntityLimit(context.Background(), "some entity", 1024))
+}
+
+func TestDatastoreStorer_AddEntities_ok(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	userID, entityID := "some user", "some entity"
+	tx := newFixedTransactor()
+	tx.putIndex(userEntitiesKind, userID, []string{entityID})
+	tx.putIndex(entityUsersKind, entityID, []string{userID})
+	s := &storer{
+		params: params,
+		client: &fixedDatastoreClient{},
+		tx:     tx,
+		iters:  &fixedDatastoreIter{},
+		feed:   storage.NewBroadcaster(0),
+		logger: lg,
+	}
+	limits := &storage.Limits{MaxUserEntities: 2, MaxEntityUsers: 2}
+
+	as := []*storage.Association{
+		{UserID: userID, EntityID: entityID},
+		{UserID: userID, EntityID: ""},
+		{UserID: "user 2", EntityID: entityID},
+		{UserID: "user 3", EntityID: entityID},
+		{UserID: userID, EntityID: "entity 2"},
+	}
+	errs, err := s.AddEntities(context.Background(), as, limits)
+	assert.Nil(t, err)
+	assert.Equal(t, []error{
+		storage.ErrUserEntityExists,
+		api.ErrEmptyEntityID,
+		nil,
+		storage.ErrTooManyEntityUsers,
+		nil,
+	}, errs)
+
+	assert.Equal(t, 2, len(tx.putUserEntities))
+	assert.Equal(t, []string{entityID, "entity 2"}, tx.index(userEntitiesKind, userID).IDs)
+	assert.Equal(t, []string{entityID}, tx.index(userEntitiesKind, "user 2").IDs)
+	assert.Equal(t, []string{userID, "user 2"}, tx.index(entityUsersKind, entityID).IDs)
+	assert.Equal(t, []string{userID}, tx.index(entityUsersKind, "entity 2").IDs)
+}
+
+func TestDatastoreStorer_AddEntities_err(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	limits := &storage.Limits{MaxUserEntities: 2, MaxEntityUsers: 2}
+	as := []*storage.Association{{UserID: "some user", EntityID: "some entity"}}
+	client, iter := &fixedDatastoreClient{}, &fixedDatastoreIter{}
+	cases := map[string]*storer{
+		"get err": {
+			params: params,
+			logger: lg,
+			client: client,
+			tx:     &fixedTransactor{getErr: errTest},
+			iters:  iter,
+		},
+		"put err": {
+			params: params,
+			logger: lg,
+			client: client,
+			tx:     &fixedTransactor{putErr: errTest},
+			iters:  iter,
+		},
+	}
+	for desc, s := range cases {
+		errs, err := s.AddEntities(context.Background(), as, limits)
+		assert.Equal(t, errTest, err, desc)
+		assert.Nil(t, errs, desc)
+	}
+}
+
+func TestDatastoreStorer_GetEntitiesBatch(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	userID1, userID2 := "user 1", "user 2"
+	userID3 := "user 3"
+	s := &storer{
+		params: params,
+		logger: lg,
+		client: &fixedDatastoreClient{
+			indices: map[string]*associationIndex{
+				datastore.NameKey(userEntitiesKind, userID1, nil).String(): {
+					IDs:   []string{"entity 1", "entity 2"},
+					Built: true,
+				},
+				datastore.NameKey(userEntitiesKind, userID3, nil).String(): {
+					Max: 8,
+				},
+			},
+		},
+		iters: &fixedDatastoreIter{
+			// user 2 has no index and user 3's hasn't been built
+			next: [][]*UserEntity{
+				nil,
+				{{UserID: userID3, EntityID: "entity 3"}},
+			},
+		},
+	}
+	entityIDs, err := s.GetEntitiesBatch(context.Background(),
+		[]string{userID1, userID2, userID3})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		userID1: {"entity 1", "entity 2"},
+		userID2: {},
+		userID3: {"entity 3"},
+	}, entityIDs)
+
+	s.iters = &fixedDatastoreIter{err: errTest}
+	entityIDs, err = s.GetEntitiesBatch(context.Background(), []string{userID2})
+	assert.Equal(t, errTest, err)
+	assert.Nil(t, entityIDs)
+
+	entityIDs, err = s.GetEntitiesBatch(context.Background(), []string{userID1, ""})
+	assert.Equal(t, api.ErrEmptyUserID, err)
+	assert.Nil(t, entityIDs)
+
+	s.client = &fixedDatastoreClient{getMultiErr: errTest}
+	entityIDs, err = s.GetEntitiesBatch(context.Background(), []string{userID1})
+	assert.Equal(t, errTest, err)
+	assert.Nil(t, entityIDs)
+}
+
+func TestDatastoreStorer_RemoveEntity_ok(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	userID, entityID := "some user", "some entity"
+	key := datastore.IDKey(userEntityKind, 1, nil)
+	tx := newFixedTransactor()
+	tx.userEntities[key.String()] = &UserEntity{UserID: userID, EntityID: entityID}
+	tx.putIndex(userEntitiesKind, userID, []string{entityID, "other entity"})
+	tx.putIndex(entityUsersKind, entityID, []string{userID})
+	s := &storer{
+		params: params,
+		client: &fixedDatastoreClient{},
+		tx:     tx,
+		feed:   storage.NewBroadcaster(0),
+		iters: &fixedDatastoreIter{
+			keys: []*datastore.Key{key},
+			values: []*UserEntity{
+				{UserID: userID, EntityID: entityID},
+			},
+		},
+		logger: lg,
+	}
+
+	err := s.RemoveEntity(context.Background(), userID, entityID)
+	assert.Nil(t, err)
+
+	putValue := tx.userEntities[key.String()]
+	assert.Equal(t, userID, putValue.UserID)
+	assert.Equal(t, entityID, putValue.EntityID)
+	assert.True(t, putValue.Removed)
+	assert.NotZero(t, putValue.ModifiedTime)
+	assert.NotZero(t, putValue.RemovedTime)
+
+	assert.Equal(t, []string{"other entity"}, tx.index(userEntitiesKind, userID).IDs)
+	assert.Empty(t, tx.index(entityUsersKind, entityID).IDs)
+}
+
+func TestDatastoreStorer_RemoveEntity_err(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	userID, entityID := "some user", "some entity"
+	key := datastore.IDKey(userEntityKind, 1, nil)
+	okIter := func() *fixedDatastoreIter {
+		return &fixedDatastoreIter{
+			keys: []*datastore.Key{key},
+			values: []*UserEntity{
+				{UserID: userID, EntityID: entityID},
+			},
+		}
+	}
+	withUserEntity := func(ue *UserEntity, putErr error) *fixedTransactor {
+		tx := newFixedTransactor()
+		tx.userEntities[key.String()] = ue
+		tx.putErr = putErr
+		return tx
+	}
+	cases := map[string]struct {
+		s        *storer
+		userID   string
+		entityID string
+		expected error
+	}{
+		"empty user ID": {
+			s:        &storer{params: params, logger: lg},
+			userID:   "",
+			entityID: entityID,
+			expected: api.ErrEmptyUserID,
+		},
+		"empty entity ID": {
+			s:        &storer{params: params, logger: lg},
+			userID:   userID,
+			entityID: "",
+			expected: api.ErrEmptyEntityID,
+		},
+		"iter next err": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				iters:  &fixedDatastoreIter{err: errTest},
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: errTest,
+		},
+		"not exists": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				iters:  &fixedDatastoreIter{},
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: storage.ErrUserEntityNotExists,
+		},
+		"get err": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				tx:     &fixedTransactor{getErr: errTest},
+				iters:  okIter(),
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: errTest,
+		},
+		"removed concurrently": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				tx:     withUserEntity(&UserEntity{Removed: true}, nil),
+				iters:  okIter(),
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: storage.ErrUserEntityNotExists,
+		},
+		"put err": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				tx:     withUserEntity(&UserEntity{}, errTest),
+				iters:  okIter(),
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		err := c.s.RemoveEntity(context.Background(), c.userID, c.entityID)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestDatastoreStorer_UpdateRole_ok(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	userID, entityID := "some user", "some entity"
+	key := datastore.IDKey(userEntityKind, 1, nil)
+	tx := newFixedTransactor()
+	tx.userEntities[key.String()] = NewUserEntity(userID, entityID, api.Role_OWNER)
+	s := &storer{
+		params: params,
+		client: &fixedDatastoreClient{},
+		tx:     tx,
+		feed:   storage.NewBroadcaster(0),
+		iters: &fixedDatastoreIter{
+			keys:   []*datastore.Key{key},
+			values: []*UserEntity{tx.userEntities[key.String()]},
+		},
+		logger: lg,
+	}
+
+	err := s.UpdateRole(context.Background(), userID, entityID, api.Role_ADMIN)
+	assert.Nil(t, err)
+
+	prevValue := tx.userEntities[key.String()]
+	assert.True(t, prevValue.Removed)
+	assert.True(t, prevValue.Superseded)
+	assert.Equal(t, int32(api.Role_OWNER), prevValue.Role)
+
+	assert.Len(t, tx.putUserEntities, 1)
+	putValue := tx.putUserEntities[0]
+	assert.Equal(t, userID, putValue.UserID)
+	assert.Equal(t, entityID, putValue.EntityID)
+	assert.Equal(t, int32(api.Role_ADMIN), putValue.Role)
+	assert.True(t, putValue.RoleUpdated)
+	assert.False(t, putValue.Removed)
+	assert.Equal(t, prevValue.RemovedTime, putValue.AddedTime)
+}
+
+func TestDatastoreStorer_UpdateRole_err(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	userID, entityID := "some user", "some entity"
+	key := datastore.IDKey(userEntityKind, 1, nil)
+	okIter := func() *fixedDatastoreIter {
+		return &fixedDatastoreIter{
+			keys: []*datastore.Key{key},
+			values: []*UserEntity{
+				{UserID: userID, EntityID: entityID},
+			},
+		}
+	}
+	withUserEntity := func(ue *UserEntity, putErr error) *fixedTransactor {
+		tx := newFixedTransactor()
+		tx.userEntities[key.String()] = ue
+		tx.putErr = putErr
+		return tx
+	}
+	cases := map[string]struct {
+		s        *storer
+		userID   string
+		entityID string
+		expected error
+	}{
+		"empty user ID": {
+			s:        &storer{params: params, logger: lg},
+			userID:   "",
+			entityID: entityID,
+			expected: api.ErrEmptyUserID,
+		},
+		"empty entity ID": {
+			s:        &storer{params: params, logger: lg},
+			userID:   userID,
+			entityID: "",
+			expected: api.ErrEmptyEntityID,
+		},
+		"not exists": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				iters:  &fixedDatastoreIter{},
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: storage.ErrUserEntityNotExists,
+		},
+		"removed concurrently": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				tx:     withUserEntity(&UserEntity{Removed: true}, nil),
+				iters:  okIter(),
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: storage.ErrUserEntityNotExists,
+		},
+		"put err": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				tx:     withUserEntity(&UserEntity{}, errTest),
+				iters:  okIter(),
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		err := c.s.UpdateRole(context.Background(), c.userID, c.entityID, api.Role_ADMIN)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestDatastoreStorer_GetEntities_ok(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+
+	userID := "some user ID"
+	entityID1, entityID2 := "some entity ID", "another entity ID"
+	getResult := []*UserEntity{
+		{UserID: userID, EntityID: entityID1, Role: int32(api.Role_OWNER)},
+		{UserID: userID, EntityID: entityID2, Role: int32(api.Role_ADMIN)},
+	}
+	s := &storer{
+		params: params,
+		client: &fixedDatastoreClient{},
+		iters: &fixedDatastoreIter{
+			keys: []*datastore.Key{
+				datastore.IDKey(userEntityKind, 0, nil),
+				datastore.IDKey(userEntityKind, 1, nil),
+			},
+			values: getResult,
+		},
+		logger: lg,
+	}
+
+	as, _, err := s.GetEntities(context.Background(), userID, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Association{
+		{UserID: userID, EntityID: entityID1, Role: api.Role_OWNER},
+		{UserID: userID, EntityID: entityID2, Role: api.Role_ADMIN},
+	}, as)
+}
+
+func TestDatastoreStorer_GetEntities_err(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	userID := "some user"
+	cases := map[string]struct {
+		s        *storer
+		userID   string
+		page     *storage.Page
+		expected error
+	}{
+		"empty user ID": {
+			s:        &storer{params: params, logger: lg},
+			userID:   "",
+			expected: api.ErrEmptyUserID,
+		},
+		"iter next err": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				iters: &fixedDatastoreIter{
+					err: errTest,
+				},
+			},
+			userID:   userID,
+			expected: errTest,
+		},
+		"invalid page token": {
+			s:        &storer{params: params, logger: lg},
+			userID:   userID,
+			page:     &storage.Page{Size: 1, Token: "not a cursor!"},
+			expected: storage.ErrInvalidPageToken,
+		},
+		"cursor err": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				iters: &fixedDatastoreIter{
+					keys:      []*datastore.Key{datastore.IDKey(userEntityKind, 0, nil)},
+					values:    []*UserEntity{{UserID: userID, EntityID: "some entity"}},
+					cursorErr: errTest,
+				},
+			},
+			userID:   userID,
+			page:     &storage.Page{Size: 1},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		entityIDs, _, err := c.s.GetEntities(context.Background(), c.userID, c.page)
+		assert.Equal(t, c.expected, err, desc)
+		assert.Nil(t, entityIDs)
+	}
+}
+
+func TestDatastoreStorer_GetEntities_paging(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	userID := "some user ID"
+	entityID1, entityID2, entityID3 := "entity ID 1", "entity ID 2", "entity ID 3"
+	t0 := time.Now()
+	t1, t2 := t0.Add(1*time.Second), t0.Add(2*time.Second)
+	newStorer := func(values ...*UserEntity) *storer {
+		keys := make([]*datastore.Key, len(values))
+		for i := range values {
+			keys[i] = datastore.IDKey(userEntityKind, int64(i), nil)
+		}
+		return &storer{
+			params: params,
+			client: &fixedDatastoreClient{},
+			iters:  &fixedDatastoreIter{keys: keys, values: values},
+			logger: lg,
+		}
+	}
+	ue1 := &UserEntity{UserID: userID, EntityID: entityID1, AddedTime: t0}
+	ue2 := &UserEntity{UserID: userID, EntityID: entityID2, AddedTime: t0}
+	ue3 := &UserEntity{UserID: userID, EntityID: entityID3, AddedTime: t2}
+	page := &storage.Page{Size: 2, Token: testCursor(0)}
+
+	// more than a page's worth gives a token at the cursor after the page's last association
+	s := newStorer(ue1, ue2, ue3)
+	as, next, err := s.GetEntities(context.Background(), userID, page)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID1, entityID2}, storage.EntityIDs(as))
+	assert.Equal(t, testCursor(2), next)
+
+	// exactly a page's worth has no next page
+	s = newStorer(ue1, ue2)
+	as, next, err = s.GetEntities(context.Background(), userID, page)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID1, entityID2}, storage.EntityIDs(as))
+	assert.Empty(t, next)
+
+	// excluded associations after a full page don't make a next page
+	s = newStorer(ue1, ue2, ue3)
+	as, next, err = s.GetEntitiesAsOf(context.Background(), userID, t1, page)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID1, entityID2}, storage.EntityIDs(as))
+	assert.Empty(t, next)
+}
+
+func TestDatastoreStorer_GetEntitiesAsOf_ok(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+
+	userID := "some user ID"
+	entityID1, entityID2, entityID3 := "entity ID 1", "entity ID 2", "entity ID 3"
+	t0 := time.Now()
+	t1, t2 := t0.Add(1*time.Second), t0.Add(2*time.Second)
+	getResult := []*UserEntity{
+		{UserID: userID, EntityID: entityID1, AddedTime: t0},
+		{UserID: userID, EntityID: entityID2, AddedTime: t0, Removed: true, RemovedTime: t2},
+		{UserID: userID, EntityID: entityID3, AddedTime: t2},
 	}
 	s := &storer{
 		params: params,
-		client: client,
+		client: &fixedDatastoreClient{},
+		iters: &fixedDatastoreIter{
+			keys: []*datastore.Key{
+				datastore.IDKey(userEntityKind, 0, nil),
+				datastore.IDKey(userEntityKind, 1, nil),
+				datastore.IDKey(userEntityKind, 2, nil),
+			},
+			values: getResult,
+		},
 		logger: lg,
 	}
 
-	userID, entityID := "some user", "some entity"
-	err := s.AddEntity(userID, entityID)
+	as, _, err := s.GetEntitiesAsOf(context.Background(), userID, t1, nil)
 	assert.Nil(t, err)
-	assert.Equal(t, 1, len(client.putValues))
-
-	putValue := client.putValues[0]
-	assert.Equal(t, userID, putValue.UserID)
-	assert.Equal(t, entityID, putValue.EntityID)
-	assert.False(t, putValue.Removed)
-	assert.NotZero(t, putValue.ModifiedDate)
-	assert.NotZero(t, putValue.ModifiedTime)
-	assert.NotZero(t, putValue.AddedTime)
-	assert.Zero(t, putValue.RemovedTime)
+	assert.Equal(t, []string{entityID1, entityID2}, storage.EntityIDs(as))
 }
 
-func TestDatastoreStorer_AddEntity_err(t *testing.T) {
+func TestDatastoreStorer_GetEntitiesAsOf_err(t *testing.T) {
 	params := storage.NewDefaultParameters()
 	lg := zap.NewNop()
-	userID, entityID := "some user", "some entity"
 	cases := map[string]struct {
 		s        *storer
 		userID   string
-		entityID string
 		expected error
 	}{
 		"empty user ID": {
 			s:        &storer{params: params, logger: lg},
 			userID:   "",
-			entityID: entityID,
 			expected: api.ErrEmptyUserID,
 		},
-		"empty entity ID": {
-			s:        &storer{params: params, logger: lg},
-			userID:   userID,
-			entityID: "",
-			expected: api.ErrEmptyEntityID,
-		},
-		"user entities count err": {
-			s: &storer{
-				params: params,
-				logger: lg,
-				client: &fixedDatastoreClient{
-					countErr: errTest,
-				},
-			},
-			userID:   userID,
-			entityID: entityID,
-			expected: errTest,
-		},
-		"non-zero user entities count": {
-			s: &storer{
-				params: params,
-				logger: lg,
-				client: &fixedDatastoreClient{
-					countValue: 1,
-				},
-			},
-			userID:   userID,
-			entityID: entityID,
-			expected: ErrUserEntityExists,
-		},
-		"put err": {
+		"iter next err": {
 			s: &storer{
 				params: params,
 				logger: lg,
-				client: &fixedDatastoreClient{
-					putErr: errTest,
-				},
+				client: &fixedDatastoreClient{},
+				iters:  &fixedDatastoreIter{err: errTest},
 			},
-			userID:   userID,
-			entityID: entityID,
+			userID:   "some user",
 			expected: errTest,
 		},
 	}
 	for desc, c := range cases {
-		err := c.s.AddEntity(c.userID, c.entityID)
+		entityIDs, _, err := c.s.GetEntitiesAsOf(context.Background(), c.userID, time.Now(), nil)
 		assert.Equal(t, c.expected, err, desc)
+		assert.Nil(t, entityIDs)
 	}
 }
 
-func TestDatastoreStorer_GetEntities_ok(t *testing.T) {
+func TestUserEntity_Version(t *testing.T) {
+	v := storage.NewAssociationVersion("some user", "some entity", api.Role_OWNER)
+	updated := v.UpdateRole(api.Role_ADMIN)
+	updated.MarkRemoved()
+	for _, v := range []*storage.AssociationVersion{v, updated} {
+		ue := newUserEntity(v)
+		assert.Equal(t, v, ue.Version())
+		assert.Equal(t, v.RemovedTime, ue.ModifiedTime)
+		assert.Equal(t, int32(v.RemovedTime.Unix()/secsPerDay), ue.ModifiedDate)
+	}
+
+	ue := NewUserEntity("some user", "some entity", api.Role_OWNER)
+	assert.Equal(t, ue.AddedTime, ue.ModifiedTime)
+	updatedUE := ue.UpdateRole(api.Role_ADMIN)
+	assert.True(t, ue.Superseded)
+	assert.Equal(t, ue.RemovedTime, ue.ModifiedTime)
+	assert.True(t, updatedUE.RoleUpdated)
+	assert.Equal(t, int32(api.Role_ADMIN), updatedUE.Role)
+}
+
+func TestDatastoreStorer_GetUsers_ok(t *testing.T) {
 	params := storage.NewDefaultParameters()
 	lg := zap.NewNop()
 
-	userID := "some user ID"
-	entityID1, entityID2 := "some entity ID", "another entity ID"
+	entityID := "some entity ID"
+	userID1, userID2 := "some user ID", "another user ID"
 	getResult := []*UserEntity{
-		{UserID: userID, EntityID: entityID1},
-		{UserID: userID, EntityID: entityID2},
+		{UserID: userID1, EntityID: entityID},
+		{UserID: userID2, EntityID: entityID},
 	}
 	s := &storer{
 		params: params,
 		client: &fixedDatastoreClient{},
-		iter: &fixedDatastoreIter{
+		iters: &fixedDatastoreIter{
 			keys: []*datastore.Key{
 				datastore.IDKey(userEntityKind, 0, nil),
 				datastore.IDKey(userEntityKind, 1, nil),
@@ -132,42 +907,42 @@ func TestDatastoreStorer_GetEntities_ok(t *testing.T) {
 		logger: lg,
 	}
 
-	entityIDs, err := s.GetEntities(userID)
+	userIDs, _, err := s.GetUsers(context.Background(), entityID, nil)
 	assert.Nil(t, err)
-	assert.Equal(t, []string{entityID1, entityID2}, entityIDs)
+	assert.Equal(t, []string{userID1, userID2}, userIDs)
 }
 
-func TestDatastoreStorer_GetEntities_err(t *testing.T) {
+func TestDatastoreStorer_GetUsers_err(t *testing.T) {
 	params := storage.NewDefaultParameters()
 	lg := zap.NewNop()
-	userID := "some user"
+	entityID := "some entity"
 	cases := map[string]struct {
 		s        *storer
-		userID   string
+		entityID string
 		expected error
 	}{
-		"empty user ID": {
+		"empty entity ID": {
 			s:        &storer{params: params, logger: lg},
-			userID:   "",
-			expected: api.ErrEmptyUserID,
+			entityID: "",
+			expected: api.ErrEmptyEntityID,
 		},
 		"iter next err": {
 			s: &storer{
 				params: params,
 				logger: lg,
 				client: &fixedDatastoreClient{},
-				iter: &fixedDatastoreIter{
+				iters: &fixedDatastoreIter{
 					err: errTest,
 				},
 			},
-			userID:   userID,
+			entityID: entityID,
 			expected: errTest,
 		},
 	}
 	for desc, c := range cases {
-		entityIDs, err := c.s.GetEntities(c.userID)
+		userIDs, _, err := c.s.GetUsers(context.Background(), c.entityID, nil)
 		assert.Equal(t, c.expected, err, desc)
-		assert.Nil(t, entityIDs)
+		assert.Nil(t, userIDs)
 	}
 }
 
@@ -183,7 +958,7 @@ func TestDatastoreStorer_CountEntities_ok(t *testing.T) {
 		logger: lg,
 	}
 
-	n, err := s.CountEntities("some user ID")
+	n, err := s.CountEntities(context.Background(), "some user ID")
 	assert.Nil(t, err)
 	assert.Equal(t, client.countValue, n)
 }
@@ -215,7 +990,7 @@ func TestDatastoreStorer_CountEntities_err(t *testing.T) {
 		},
 	}
 	for desc, c := range cases {
-		n, err := c.s.CountEntities(c.userID)
+		n, err := c.s.CountEntities(context.Background(), c.userID)
 		assert.Equal(t, c.expected, err, desc)
 		assert.Zero(t, n)
 	}
@@ -233,7 +1008,7 @@ func TestDatastoreStorer_CountUsers_ok(t *testing.T) {
 		logger: lg,
 	}
 
-	n, err := s.CountUsers("some entity ID")
+	n, err := s.CountUsers(context.Background(), "some entity ID")
 	assert.Nil(t, err)
 	assert.Equal(t, client.countValue, n)
 }
@@ -265,24 +1040,249 @@ func TestDatastoreStorer_CountUsers_err(t *testing.T) {
 		},
 	}
 	for desc, c := range cases {
-		n, err := c.s.CountUsers(c.entityID)
+		n, err := c.s.CountUsers(context.Background(), c.entityID)
 		assert.Equal(t, c.expected, err, desc)
 		assert.Zero(t, n)
 	}
 }
 
+func TestDatastoreStorer_GetHistory_ok(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+
+	userID := "some user ID"
+	entityID1, entityID2 := "entity ID 1", "entity ID 2"
+	t0 := time.Now()
+	t1, t2 := t0.Add(1*time.Second), t0.Add(2*time.Second)
+	s := &storer{
+		params: params,
+		client: &fixedDatastoreClient{},
+		iters: &fixedDatastoreIter{
+			keys: []*datastore.Key{
+				datastore.IDKey(userEntityKind, 0, nil),
+				datastore.IDKey(userEntityKind, 1, nil),
+			},
+			values: []*UserEntity{
+				{UserID: userID, EntityID: entityID1, AddedTime: t0, Removed: true,
+					RemovedTime: t2},
+				{UserID: userID, EntityID: entityID2, AddedTime: t1},
+			},
+		},
+		logger: lg,
+	}
+
+	events, err := s.GetHistory(context.Background(), userID, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.AssociationEvent{
+		{Type: api.EventType_ADDED, Time: t0, UserID: userID, EntityID: entityID1},
+		{Type: api.EventType_ADDED, Time: t1, UserID: userID, EntityID: entityID2},
+		{Type: api.EventType_REMOVED, Time: t2, UserID: userID, EntityID: entityID1},
+	}, events)
+}
+
+func TestDatastoreStorer_GetHistory_concurrent(t *testing.T) {
+	userID := "some user ID"
+	t0 := time.Now()
+	s := &storer{
+		params: storage.NewDefaultParameters(),
+		client: &fixedDatastoreClient{},
+		iters: &freshDatastoreIters{values: []*UserEntity{
+			{UserID: userID, EntityID: "entity ID 1", AddedTime: t0},
+			{UserID: userID, EntityID: "entity ID 2", AddedTime: t0.Add(1 * time.Second)},
+		}},
+		logger: zap.NewNop(),
+	}
+
+	// each query gets its own iterator, so concurrent ones all read every result
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			events, err := s.GetHistory(context.Background(), userID, "")
+			assert.Nil(t, err)
+			assert.Len(t, events, 2)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDatastoreStorer_GetHistory_err(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	cases := map[string]struct {
+		s        *storer
+		userID   string
+		entityID string
+		expected error
+	}{
+		"empty user and entity ID": {
+			s:        &storer{params: params, logger: lg},
+			expected: api.ErrEmptyUserAndEntityID,
+		},
+		"iter next err": {
+			s: &storer{
+				params: params,
+				logger: lg,
+				client: &fixedDatastoreClient{},
+				iters:  &fixedDatastoreIter{err: errTest},
+			},
+			entityID: "some entity ID",
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		events, err := c.s.GetHistory(context.Background(), c.userID, c.entityID)
+		assert.Equal(t, c.expected, err, desc)
+		assert.Nil(t, events, desc)
+	}
+}
+
+func TestDatastoreStorer_PutGetUser(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	userID := "some user"
+	s := &storer{params: params, client: &fixedDatastoreClient{}, logger: lg}
+
+	u, err := s.GetUser(context.Background(), userID)
+	assert.Equal(t, storage.ErrUserNotExists, err)
+	assert.Nil(t, u)
+
+	put := &api.UserProfile{
+		UserId:      userID,
+		DisplayName: "Some User",
+		Email:       "some.user@example.com",
+		Phone:       "555-555-5555",
+		Locale:      "en-US",
+		Timezone:    "America/New_York",
+		Attributes:  map[string]string{"key2": "value2", "key1": "value1"},
+	}
+	err = s.PutUser(context.Background(), put)
+	assert.Nil(t, err)
+
+	stored := s.client.(*fixedDatastoreClient).users[userKey(userID).String()]
+	assert.Equal(t, []userAttribute{
+		{Key: "key1", Value: "value1"},
+		{Key: "key2", Value: "value2"},
+	}, stored.Attributes)
+	assert.NotZero(t, stored.ModifiedTime)
+
+	u, err = s.GetUser(context.Background(), userID)
+	assert.Nil(t, err)
+	assert.Equal(t, put, u)
+}
+
+func TestDatastoreStorer_PutGetUser_err(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	s := &storer{params: params, logger: lg}
+
+	err := s.PutUser(context.Background(), &api.UserProfile{})
+	assert.Equal(t, api.ErrEmptyUserID, err)
+	u, err := s.GetUser(context.Background(), "")
+	assert.Equal(t, api.ErrEmptyUserID, err)
+	assert.Nil(t, u)
+
+	s.client = &fixedDatastoreClient{putErr: errTest, getErr: errTest}
+	err = s.PutUser(context.Background(), &api.UserProfile{UserId: "some user"})
+	assert.Equal(t, errTest, err)
+	u, err = s.GetUser(context.Background(), "some user")
+	assert.Equal(t, errTest, err)
+	assert.Nil(t, u)
+}
+
+func TestDatastoreStorer_UpdateUser_ok(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	userID := "some user"
+	tx := newFixedTransactor()
+	tx.users[userKey(userID).String()] = newUserProfile(&api.UserProfile{
+		UserId:      userID,
+		DisplayName: "Some User",
+		Attributes:  map[string]string{"key1": "value1"},
+	})
+	s := &storer{params: params, tx: tx, logger: lg}
+
+	u, err := s.UpdateUser(context.Background(), &api.UserProfile{
+		UserId:     userID,
+		Locale:     "en-US",
+		Attributes: map[string]string{"key1": "", "key2": "value2"},
+	})
+	assert.Nil(t, err)
+	expected := &api.UserProfile{
+		UserId:      userID,
+		DisplayName: "Some User",
+		Locale:      "en-US",
+		Attributes:  map[string]string{"key2": "value2"},
+	}
+	assert.Equal(t, expected, u)
+	assert.Equal(t, expected, tx.users[userKey(userID).String()].profile(userID))
+}
+
+func TestDatastoreStorer_UpdateUser_err(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	userID := "some user"
+	withUser := func(putErr error) *fixedTransactor {
+		tx := newFixedTransactor()
+		tx.users[userKey(userID).String()] = &userProfile{}
+		tx.putErr = putErr
+		return tx
+	}
+	cases := map[string]struct {
+		s        *storer
+		userID   string
+		expected error
+	}{
+		"empty user ID": {
+			s:        &storer{params: params, logger: lg},
+			userID:   "",
+			expected: api.ErrEmptyUserID,
+		},
+		"not exists": {
+			s:        &storer{params: params, logger: lg, tx: newFixedTransactor()},
+			userID:   userID,
+			expected: storage.ErrUserNotExists,
+		},
+		"get err": {
+			s:        &storer{params: params, logger: lg, tx: &fixedTransactor{getErr: errTest}},
+			userID:   userID,
+			expected: errTest,
+		},
+		"put err": {
+			s:        &storer{params: params, logger: lg, tx: withUser(errTest)},
+			userID:   userID,
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		u, err := c.s.UpdateUser(context.Background(), &api.UserProfile{UserId: c.userID})
+		assert.Equal(t, c.expected, err, desc)
+		assert.Nil(t, u, desc)
+	}
+}
+
 type fixedDatastoreClient struct {
-	putValues  []*UserEntity
-	putErr     error
-	countValue int
-	countErr   error
+	countValue  int
+	countErr    error
+	indices     map[string]*associationIndex
+	getMultiErr error
+	users       map[string]*userProfile
+	getErr      error
+	putErr      error
 }
 
 func (f *fixedDatastoreClient) Put(
 	ctx context.Context, key *datastore.Key, value interface{},
 ) (*datastore.Key, error) {
-	f.putValues = append(f.putValues, value.(*UserEntity))
-	return nil, f.putErr
+	if f.putErr != nil {
+		return nil, f.putErr
+	}
+	if f.users == nil {
+		f.users = make(map[string]*userProfile)
+	}
+	f.users[key.String()] = value.(*userProfile)
+	return key, nil
 }
 
 func (f *fixedDatastoreClient) PutMulti(
@@ -294,13 +1294,37 @@ func (f *fixedDatastoreClient) PutMulti(
 func (f *fixedDatastoreClient) Get(
 	ctx context.Context, key *datastore.Key, dest interface{},
 ) error {
-	panic("implement me")
+	if f.getErr != nil {
+		return f.getErr
+	}
+	up, in := f.users[key.String()]
+	if !in {
+		return datastore.ErrNoSuchEntity
+	}
+	*dest.(*userProfile) = *up
+	return nil
 }
 
 func (f *fixedDatastoreClient) GetMulti(
 	ctx context.Context, keys []*datastore.Key, dst interface{},
 ) error {
-	panic("implement me")
+	if f.getMultiErr != nil {
+		return f.getMultiErr
+	}
+	idxs := dst.([]*associationIndex)
+	merr := make(datastore.MultiError, len(keys))
+	missing := false
+	for i, key := range keys {
+		if idx, in := f.indices[key.String()]; in {
+			*idxs[i] = *idx
+		} else {
+			merr[i], missing = datastore.ErrNoSuchEntity, true
+		}
+	}
+	if missing {
+		return merr
+	}
+	return nil
 }
 
 func (f *fixedDatastoreClient) Delete(ctx context.Context, keys []*datastore.Key) error {
@@ -316,24 +1340,186 @@ func (f *fixedDatastoreClient) Run(ctx context.Context, q *datastore.Query) *dat
 }
 
 type fixedDatastoreIter struct {
-	err    error
-	keys   []*datastore.Key
-	values []*UserEntity
-	offset int
+	err       error
+	cursorErr error
+	keys      []*datastore.Key
+	values    []*UserEntity
+	offset    int
+
+	// results of the following queries, in order, each replacing values when it's run
+	next [][]*UserEntity
 }
 
-func (f *fixedDatastoreIter) Init(iter *datastore.Iterator) {}
+func (f *fixedDatastoreIter) Iterator(iter *datastore.Iterator) cursorIterator {
+	if len(f.next) > 0 {
+		f.values, f.next, f.offset = f.next[0], f.next[1:], 0
+	}
+	return f
+}
 
 func (f *fixedDatastoreIter) Next(dst interface{}) (*datastore.Key, error) {
 	if f.err != nil {
 		return nil, f.err
 	}
 	defer func() { f.offset++ }()
-	if f.offset == len(f.values) {
+	if f.offset >= len(f.values) {
 		return nil, iterator.Done
 	}
-	v := f.values[f.offset]
-	dst.(*UserEntity).EntityID = v.EntityID
-	dst.(*UserEntity).UserID = v.UserID
+	*dst.(*UserEntity) = *f.values[f.offset]
+	if f.offset >= len(f.keys) {
+		return nil, nil
+	}
 	return f.keys[f.offset], nil
 }
+
+func (f *fixedDatastoreIter) Cursor() (datastore.Cursor, error) {
+	if f.cursorErr != nil {
+		return datastore.Cursor{}, f.cursorErr
+	}
+	return datastore.DecodeCursor(testCursor(f.offset))
+}
+
+// freshDatastoreIters creates a new fixedDatastoreIter over the same values for each query.
+type freshDatastoreIters struct {
+	values []*UserEntity
+}
+
+func (f *freshDatastoreIters) Iterator(iter *datastore.Iterator) cursorIterator {
+	return &fixedDatastoreIter{values: f.values}
+}
+
+// testCursor returns the encoded cursor of the fixedDatastoreIter after the given number of
+// results.
+func testCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+type fixedTransactor struct {
+	indices         map[string]*associationIndex
+	userEntities    map[string]*UserEntity
+	putUserEntities []*UserEntity
+	deletedKeys     []*datastore.Key
+	users           map[string]*userProfile
+	getErr          error
+	putErr          error
+}
+
+func newFixedTransactor() *fixedTransactor {
+	return &fixedTransactor{
+		indices:      make(map[string]*associationIndex),
+		userEntities: make(map[string]*UserEntity),
+		users:        make(map[string]*userProfile),
+	}
+}
+
+func (f *fixedTransactor) RunInTransaction(
+	ctx context.Context, fn func(tx transaction) error,
+) error {
+	return fn(f)
+}
+
+func (f *fixedTransactor) Get(key *datastore.Key, dst interface{}) error {
+	if f.getErr != nil {
+		return f.getErr
+	}
+	switch dst := dst.(type) {
+	case *associationIndex:
+		idx, in := f.indices[key.String()]
+		if !in {
+			return datastore.ErrNoSuchEntity
+		}
+		*dst = *idx
+	case *UserEntity:
+		ue, in := f.userEntities[key.String()]
+		if !in {
+			return datastore.ErrNoSuchEntity
+		}
+		*dst = *ue
+	case *userProfile:
+		up, in := f.users[key.String()]
+		if !in {
+			return datastore.ErrNoSuchEntity
+		}
+		*dst = *up
+	}
+	return nil
+}
+
+func (f *fixedTransactor) GetMulti(keys []*datastore.Key, dst interface{}) error {
+	idxs := dst.([]*associationIndex)
+	merr := make(datastore.MultiError, len(keys))
+	missing := false
+	for i, key := range keys {
+		if err := f.Get(key, idxs[i]); err == datastore.ErrNoSuchEntity {
+			merr[i], missing = err, true
+		} else if err != nil {
+			return err
+		}
+	}
+	if missing {
+		return merr
+	}
+	return nil
+}
+
+func (f *fixedTransactor) PutMulti(
+	keys []*datastore.Key, src interface{},
+) ([]*datastore.PendingKey, error) {
+	var srcs []interface{}
+	switch src := src.(type) {
+	case []*associationIndex:
+		for _, idx := range src {
+			srcs = append(srcs, idx)
+		}
+	case []*UserEntity:
+		for _, ue := range src {
+			srcs = append(srcs, ue)
+		}
+	}
+	for i, key := range keys {
+		if _, err := f.Put(key, srcs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (f *fixedTransactor) Put(
+	key *datastore.Key, src interface{},
+) (*datastore.PendingKey, error) {
+	if f.putErr != nil {
+		return nil, f.putErr
+	}
+	switch src := src.(type) {
+	case *associationIndex:
+		f.indices[key.String()] = src
+	case *UserEntity:
+		if key.Incomplete() {
+			f.putUserEntities = append(f.putUserEntities, src)
+		} else {
+			f.userEntities[key.String()] = src
+		}
+	case *userProfile:
+		f.users[key.String()] = src
+	}
+	return nil, nil
+}
+
+func (f *fixedTransactor) DeleteMulti(keys []*datastore.Key) error {
+	for _, key := range keys {
+		delete(f.userEntities, key.String())
+	}
+	f.deletedKeys = append(f.deletedKeys, keys...)
+	return nil
+}
+
+func (f *fixedTransactor) putIndex(kind, name string, ids []string) {
+	f.indices[datastore.NameKey(kind, name, nil).String()] = &associationIndex{
+		IDs:   ids,
+		Built: true,
+	}
+}
+
+func (f *fixedTransactor) index(kind, name string) *associationIndex {
+	return f.indices[datastore.NameKey(kind, name, nil).String()]
+}
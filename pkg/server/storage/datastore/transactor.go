@@ -0,0 +1,34 @@
+package datastore
+
+import (
+	"context"
+
+	"cloud.google.com/go/datastore"
+)
+
+// transaction is the subset of *datastore.Transaction used by the storer.
+type transaction interface {
+	Get(key *datastore.Key, dst interface{}) error
+	GetMulti(keys []*datastore.Key, dst interface{}) error
+	Put(key *datastore.Key, src interface{}) (*datastore.PendingKey, error)
+	PutMulti(keys []*datastore.Key, src interface{}) ([]*datastore.PendingKey, error)
+	DeleteMulti(keys []*datastore.Key) error
+}
+
+// transactor runs functions within a DataStore transaction, retrying on contention.
+type transactor interface {
+	RunInTransaction(ctx context.Context, f func(tx transaction) error) error
+}
+
+type transactorImpl struct {
+	client *datastore.Client
+}
+
+func (t *transactorImpl) RunInTransaction(
+	ctx context.Context, f func(tx transaction) error,
+) error {
+	_, err := t.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		return f(tx)
+	})
+	return err
+}
@@ -0,0 +1,358 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	storageNamespace = "user"
+	storageSubsystem = "storage"
+
+	backendLabel   = "backend"
+	operationLabel = "operation"
+	errorLabel     = "error"
+
+	opAddEntity        = "add_entity"
+	opAddEntities      = "add_entities"
+	opRemoveEntity     = "remove_entity"
+	opGetEntities      = "get_entities"
+	opGetEntitiesAsOf  = "get_entities_as_of"
+	opGetEntitiesBatch = "get_entities_batch"
+	opGetUsers         = "get_users"
+	opCountEntities    = "count_entities"
+	opCountUsers       = "count_users"
+	opGetHistory       = "get_history"
+	opUpdateRole       = "update_role"
+	opSetUserLimit     = "set_user_limit"
+	opSetEntityLimit   = "set_entity_limit"
+	opWatch            = "watch"
+	opExport           = "export_associations"
+	opImport           = "import_association"
+	opPutUser          = "put_user"
+	opGetUser          = "get_user"
+	opUpdateUser       = "update_user"
+	opClose            = "close"
+
+	otherErrorType = "other"
+)
+
+// errorTypes maps each expected error to the error label value of its count.
+var errorTypes = map[error]string{
+	ErrTooManyUserEntities:      "too_many_user_entities",
+	ErrTooManyEntityUsers:       "too_many_entity_users",
+	ErrUserEntityExists:         "user_entity_exists",
+	ErrUserEntityNotExists:      "user_entity_not_exists",
+	ErrUserNotExists:            "user_not_exists",
+	ErrRevisionCompacted:        "revision_compacted",
+	ErrFutureRevision:           "future_revision",
+	ErrWatchInterrupted:         "watch_interrupted",
+	ErrInvalidRole:              "invalid_argument",
+	ErrInvalidPageToken:         "invalid_argument",
+	api.ErrEmptyUserID:          "invalid_argument",
+	api.ErrEmptyEntityID:        "invalid_argument",
+	api.ErrEmptyUserAndEntityID: "invalid_argument",
+	context.DeadlineExceeded:    "deadline_exceeded",
+	context.Canceled:            "canceled",
+}
+
+// ErrorType returns the label value describing the error in metrics, which is "other" for
+// unexpected errors.
+func ErrorType(err error) string {
+	if errType, in := errorTypes[err]; in {
+		return errType
+	}
+	return otherErrorType
+}
+
+// instrumentedStorer is a Storer that records the latency and errors of each call to the Storer
+// it wraps as well as the number of associations added and removed, where an import counts as
+// added or removed when it makes an association current or not current, respectively.
+type instrumentedStorer struct {
+	storer   Storer
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+	added    prometheus.Counter
+	removed  prometheus.Counter
+}
+
+// NewInstrumented returns a Storer that records Prometheus metrics for each call to the given
+// Storer, labelled with its backend type. The returned Storer is also a prometheus.Collector of
+// those metrics.
+func NewInstrumented(storer Storer, backend Type) Storer {
+	backendLabels := prometheus.Labels{backendLabel: backend.String()}
+	return &instrumentedStorer{
+		storer: storer,
+		duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   storageNamespace,
+				Subsystem:   storageSubsystem,
+				Name:        "operation_duration_seconds",
+				Help:        "Duration of storage operations.",
+				ConstLabels: backendLabels,
+				Buckets:     prometheus.DefBuckets,
+			},
+			[]string{operationLabel},
+		),
+		errors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   storageNamespace,
+				Subsystem:   storageSubsystem,
+				Name:        "operation_errors_total",
+				Help:        "Number of failed storage operations, by operation and error type.",
+				ConstLabels: backendLabels,
+			},
+			[]string{operationLabel, errorLabel},
+		),
+		added: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   storageNamespace,
+			Subsystem:   storageSubsystem,
+			Name:        "associations_added_total",
+			Help:        "Number of user-entity associations added.",
+			ConstLabels: backendLabels,
+		}),
+		removed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   storageNamespace,
+			Subsystem:   storageSubsystem,
+			Name:        "associations_removed_total",
+			Help:        "Number of user-entity associations removed.",
+			ConstLabels: backendLabels,
+		}),
+	}
+}
+
+func (s *instrumentedStorer) AddEntity(
+	ctx context.Context, userID, entityID string, role api.Role, limits *Limits,
+) error {
+	start := time.Now()
+	err := s.storer.AddEntity(ctx, userID, entityID, role, limits)
+	s.observe(opAddEntity, start, err)
+	if err == nil {
+		s.added.Inc()
+	}
+	return err
+}
+
+func (s *instrumentedStorer) AddEntities(
+	ctx context.Context, as []*Association, limits *Limits,
+) ([]error, error) {
+	start := time.Now()
+	errs, err := s.storer.AddEntities(ctx, as, limits)
+	s.observe(opAddEntities, start, err)
+	for _, addErr := range errs {
+		if addErr == nil {
+			s.added.Inc()
+		} else {
+			s.errors.WithLabelValues(opAddEntities, ErrorType(addErr)).Inc()
+		}
+	}
+	return errs, err
+}
+
+func (s *instrumentedStorer) RemoveEntity(ctx context.Context, userID, entityID string) error {
+	start := time.Now()
+	err := s.storer.RemoveEntity(ctx, userID, entityID)
+	s.observe(opRemoveEntity, start, err)
+	if err == nil {
+		s.removed.Inc()
+	}
+	return err
+}
+
+func (s *instrumentedStorer) GetEntities(
+	ctx context.Context, userID string, page *Page,
+) ([]*Association, string, error) {
+	start := time.Now()
+	as, next, err := s.storer.GetEntities(ctx, userID, page)
+	s.observe(opGetEntities, start, err)
+	return as, next, err
+}
+
+func (s *instrumentedStorer) GetEntitiesAsOf(
+	ctx context.Context, userID string, asOf time.Time, page *Page,
+) ([]*Association, string, error) {
+	start := time.Now()
+	as, next, err := s.storer.GetEntitiesAsOf(ctx, userID, asOf, page)
+	s.observe(opGetEntitiesAsOf, start, err)
+	return as, next, err
+}
+
+func (s *instrumentedStorer) GetEntitiesBatch(
+	ctx context.Context, userIDs []string,
+) (map[string][]string, error) {
+	start := time.Now()
+	entityIDs, err := s.storer.GetEntitiesBatch(ctx, userIDs)
+	s.observe(opGetEntitiesBatch, start, err)
+	return entityIDs, err
+}
+
+func (s *instrumentedStorer) GetUsers(
+	ctx context.Context, entityID string, page *Page,
+) ([]string, string, error) {
+	start := time.Now()
+	userIDs, next, err := s.storer.GetUsers(ctx, entityID, page)
+	s.observe(opGetUsers, start, err)
+	return userIDs, next, err
+}
+
+func (s *instrumentedStorer) CountEntities(ctx context.Context, userID string) (int, error) {
+	start := time.Now()
+	n, err := s.storer.CountEntities(ctx, userID)
+	s.observe(opCountEntities, start, err)
+	return n, err
+}
+
+func (s *instrumentedStorer) CountUsers(ctx context.Context, entityID string) (int, error) {
+	start := time.Now()
+	n, err := s.storer.CountUsers(ctx, entityID)
+	s.observe(opCountUsers, start, err)
+	return n, err
+}
+
+func (s *instrumentedStorer) GetHistory(
+	ctx context.Context, userID, entityID string,
+) ([]*AssociationEvent, error) {
+	start := time.Now()
+	events, err := s.storer.GetHistory(ctx, userID, entityID)
+	s.observe(opGetHistory, start, err)
+	return events, err
+}
+
+func (s *instrumentedStorer) UpdateRole(
+	ctx context.Context, userID, entityID string, role api.Role,
+) error {
+	start := time.Now()
+	err := s.storer.UpdateRole(ctx, userID, entityID, role)
+	s.observe(opUpdateRole, start, err)
+	return err
+}
+
+func (s *instrumentedStorer) SetUserLimit(
+	ctx context.Context, userID string, maxEntities int,
+) error {
+	start := time.Now()
+	err := s.storer.SetUserLimit(ctx, userID, maxEntities)
+	s.observe(opSetUserLimit, start, err)
+	return err
+}
+
+func (s *instrumentedStorer) SetEntityLimit(
+	ctx context.Context, entityID string, maxUsers int,
+) error {
+	start := time.Now()
+	err := s.storer.SetEntityLimit(ctx, entityID, maxUsers)
+	s.observe(opSetEntityLimit, start, err)
+	return err
+}
+
+// Watch records only the errors of the wrapped Storer's Watch, since its duration is that of the
+// watch rather than of a storage operation.
+func (s *instrumentedStorer) Watch(
+	ctx context.Context,
+	userID, entityID string,
+	fromRevision uint64,
+	send func(*AssociationEvent) error,
+) error {
+	err := s.storer.Watch(ctx, userID, entityID, fromRevision, send)
+	if err != nil {
+		s.errors.WithLabelValues(opWatch, ErrorType(err)).Inc()
+	}
+	return err
+}
+
+func (s *instrumentedStorer) ExportAssociations(
+	ctx context.Context, send func([]*AssociationVersion) error,
+) error {
+	start := time.Now()
+	err := s.storer.ExportAssociations(ctx, send)
+	s.observe(opExport, start, err)
+	return err
+}
+
+func (s *instrumentedStorer) ImportAssociation(
+	ctx context.Context, versions []*AssociationVersion, overwrite bool,
+) (bool, error) {
+	wasCurrent := false
+	if overwrite && len(versions) > 0 {
+		wasCurrent = s.isCurrent(ctx, versions[0].UserID, versions[0].EntityID)
+	}
+	start := time.Now()
+	overwritten, err := s.storer.ImportAssociation(ctx, versions, overwrite)
+	s.observe(opImport, start, err)
+	if err != nil || len(versions) == 0 {
+		return overwritten, err
+	}
+	if isCurrent := !versions[len(versions)-1].Removed; isCurrent && !wasCurrent {
+		s.added.Inc()
+	} else if !isCurrent && wasCurrent {
+		s.removed.Inc()
+	}
+	return overwritten, err
+}
+
+// isCurrent returns whether the association's latest event isn't a removal, so an overwriting
+// import isn't counted again. An error getting its history is recorded and treated as it not
+// being current, since the import reports any storage failure itself.
+func (s *instrumentedStorer) isCurrent(ctx context.Context, userID, entityID string) bool {
+	start := time.Now()
+	events, err := s.storer.GetHistory(ctx, userID, entityID)
+	s.observe(opGetHistory, start, err)
+	return err == nil && len(events) > 0 && events[len(events)-1].Type != api.EventType_REMOVED
+}
+
+func (s *instrumentedStorer) PutUser(ctx context.Context, u *api.UserProfile) error {
+	start := time.Now()
+	err := s.storer.PutUser(ctx, u)
+	s.observe(opPutUser, start, err)
+	return err
+}
+
+func (s *instrumentedStorer) GetUser(ctx context.Context, userID string) (*api.UserProfile, error) {
+	start := time.Now()
+	u, err := s.storer.GetUser(ctx, userID)
+	s.observe(opGetUser, start, err)
+	return u, err
+}
+
+func (s *instrumentedStorer) UpdateUser(
+	ctx context.Context, u *api.UserProfile,
+) (*api.UserProfile, error) {
+	start := time.Now()
+	updated, err := s.storer.UpdateUser(ctx, u)
+	s.observe(opUpdateUser, start, err)
+	return updated, err
+}
+
+func (s *instrumentedStorer) Close() error {
+	start := time.Now()
+	err := s.storer.Close()
+	s.observe(opClose, start, err)
+	return err
+}
+
+// Describe sends the descriptors of the storage metrics to the given channel.
+func (s *instrumentedStorer) Describe(ch chan<- *prometheus.Desc) {
+	s.duration.Describe(ch)
+	s.errors.Describe(ch)
+	s.added.Describe(ch)
+	s.removed.Describe(ch)
+}
+
+// Collect sends the current storage metrics to the given channel.
+func (s *instrumentedStorer) Collect(ch chan<- prometheus.Metric) {
+	s.duration.Collect(ch)
+	s.errors.Collect(ch)
+	s.added.Collect(ch)
+	s.removed.Collect(ch)
+}
+
+// observe records the duration of the operation since the start and, if it failed, its error.
+func (s *instrumentedStorer) observe(op string, start time.Time, err error) {
+	s.duration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		s.errors.WithLabelValues(op, ErrorType(err)).Inc()
+	}
+}
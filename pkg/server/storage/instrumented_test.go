@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrumentedStorer_AddEntity(t *testing.T) {
+	cases := map[string]struct {
+		err           error
+		expectedAdded float64
+		expectedErrs  map[string]float64
+	}{
+		"ok": {
+			expectedAdded: 1,
+		},
+		"exists": {
+			err:          ErrUserEntityExists,
+			expectedErrs: map[string]float64{"user_entity_exists": 1},
+		},
+		"other err": {
+			err:          errTest,
+			expectedErrs: map[string]float64{"other": 1},
+		},
+	}
+	for desc, c := range cases {
+		s := newTestInstrumentedStorer(&erringStorer{err: c.err})
+		err := s.AddEntity(context.Background(), "user 1", "entity 1", api.Role_OWNER, &Limits{})
+		assert.Equal(t, c.err, err, desc)
+		assert.Equal(t, c.expectedAdded, counterValue(s.added), desc)
+		assert.Equal(t, uint64(1), histogramCount(s.duration, opAddEntity), desc)
+		for errType, expected := range c.expectedErrs {
+			errs := s.errors.WithLabelValues(opAddEntity, errType)
+			assert.Equal(t, expected, counterValue(errs), desc)
+		}
+	}
+}
+
+func TestInstrumentedStorer_AddEntities(t *testing.T) {
+	inner := &erringStorer{
+		addErrs: []error{nil, ErrTooManyUserEntities, nil, ErrUserEntityExists},
+	}
+	s := newTestInstrumentedStorer(inner)
+	as := make([]*Association, len(inner.addErrs))
+	errs, err := s.AddEntities(context.Background(), as, &Limits{})
+	assert.Nil(t, err)
+	assert.Equal(t, inner.addErrs, errs)
+
+	assert.Equal(t, float64(2), counterValue(s.added))
+	tooMany := s.errors.WithLabelValues(opAddEntities, "too_many_user_entities")
+	assert.Equal(t, float64(1), counterValue(tooMany))
+	exists := s.errors.WithLabelValues(opAddEntities, "user_entity_exists")
+	assert.Equal(t, float64(1), counterValue(exists))
+	assert.Equal(t, uint64(1), histogramCount(s.duration, opAddEntities))
+}
+
+func TestInstrumentedStorer_RemoveEntity(t *testing.T) {
+	s := newTestInstrumentedStorer(&erringStorer{})
+	err := s.RemoveEntity(context.Background(), "user 1", "entity 1")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), counterValue(s.removed))
+
+	s = newTestInstrumentedStorer(&erringStorer{err: ErrUserEntityNotExists})
+	err = s.RemoveEntity(context.Background(), "user 1", "entity 1")
+	assert.Equal(t, ErrUserEntityNotExists, err)
+	assert.Zero(t, counterValue(s.removed))
+	notExists := s.errors.WithLabelValues(opRemoveEntity, "user_entity_not_exists")
+	assert.Equal(t, float64(1), counterValue(notExists))
+}
+
+func TestInstrumentedStorer_ImportAssociation(t *testing.T) {
+	removed := NewAssociationVersion("user 1", "entity 1", api.Role_OWNER)
+	removed.Removed = true
+	current := NewAssociationVersion("user 1", "entity 1", api.Role_OWNER)
+	addedEvent := &AssociationEvent{Type: api.EventType_ADDED}
+	removedEvent := &AssociationEvent{Type: api.EventType_REMOVED}
+	cases := map[string]struct {
+		versions        []*AssociationVersion
+		overwrite       bool
+		history         []*AssociationEvent
+		err             error
+		expectedAdded   float64
+		expectedRemoved float64
+		expectedErrs    map[string]float64
+	}{
+		"current": {
+			versions:      []*AssociationVersion{current},
+			expectedAdded: 1,
+		},
+		"removed": {
+			versions: []*AssociationVersion{removed},
+		},
+		"exists": {
+			versions:     []*AssociationVersion{current},
+			err:          ErrUserEntityExists,
+			expectedErrs: map[string]float64{"user_entity_exists": 1},
+		},
+		"overwrite current with current": {
+			versions:  []*AssociationVersion{current},
+			overwrite: true,
+			history:   []*AssociationEvent{addedEvent},
+		},
+		"overwrite removed with current": {
+			versions:      []*AssociationVersion{current},
+			overwrite:     true,
+			history:       []*AssociationEvent{addedEvent, removedEvent},
+			expectedAdded: 1,
+		},
+		"overwrite current with removed": {
+			versions:        []*AssociationVersion{removed},
+			overwrite:       true,
+			history:         []*AssociationEvent{addedEvent},
+			expectedRemoved: 1,
+		},
+		"overwrite missing with current": {
+			versions:      []*AssociationVersion{current},
+			overwrite:     true,
+			expectedAdded: 1,
+		},
+	}
+	for desc, c := range cases {
+		s := newTestInstrumentedStorer(&erringStorer{err: c.err, history: c.history})
+		_, err := s.ImportAssociation(context.Background(), c.versions, c.overwrite)
+		assert.Equal(t, c.err, err, desc)
+		assert.Equal(t, c.expectedAdded, counterValue(s.added), desc)
+		assert.Equal(t, c.expectedRemoved, counterValue(s.removed), desc)
+		assert.Equal(t, uint64(1), histogramCount(s.duration, opImport), desc)
+		for errType, expected := range c.expectedErrs {
+			errs := s.errors.WithLabelValues(opImport, errType)
+			assert.Equal(t, expected, counterValue(errs), desc)
+		}
+	}
+}
+
+func TestInstrumentedStorer_Collect(t *testing.T) {
+	s := newTestInstrumentedStorer(&erringStorer{err: errTest})
+	_, err := s.CountEntities(context.Background(), "user 1")
+	assert.Equal(t, errTest, err)
+
+	descs := make(chan *prometheus.Desc, 8)
+	s.Describe(descs)
+	close(descs)
+	assert.Len(t, descs, 4)
+
+	metrics := make(chan prometheus.Metric, 8)
+	s.Collect(metrics)
+	close(metrics)
+	assert.Len(t, metrics, 4) // one duration, one error, and the added and removed counts
+
+	m := &dto.Metric{}
+	assert.Nil(t, (<-metrics).Write(m))
+	assert.Equal(t, backendLabel, m.Label[0].GetName())
+	assert.Equal(t, Memory.String(), m.Label[0].GetValue())
+}
+
+func TestErrorType(t *testing.T) {
+	cases := map[string]struct {
+		err      error
+		expected string
+	}{
+		"expected": {
+			err:      ErrTooManyEntityUsers,
+			expected: "too_many_entity_users",
+		},
+		"invalid argument": {
+			err:      api.ErrEmptyUserID,
+			expected: "invalid_argument",
+		},
+		"context": {
+			err:      context.Canceled,
+			expected: "canceled",
+		},
+		"other": {
+			err:      errTest,
+			expected: "other",
+		},
+	}
+	for desc, c := range cases {
+		assert.Equal(t, c.expected, ErrorType(c.err), desc)
+	}
+}
+
+func newTestInstrumentedStorer(inner Storer) *instrumentedStorer {
+	return NewInstrumented(inner, Memory).(*instrumentedStorer)
+}
+
+func histogramCount(h *prometheus.HistogramVec, op string) uint64 {
+	m := &dto.Metric{}
+	if err := h.WithLabelValues(op).(prometheus.Histogram).Write(m); err != nil {
+		panic(err)
+	}
+	return m.Histogram.GetSampleCount()
+}
+
+// erringStorer returns the given errors from the methods the instrumenting tests call.
+type erringStorer struct {
+	Storer
+	err     error
+	addErrs []error
+	history []*AssociationEvent
+}
+
+func (f *erringStorer) AddEntity(
+	ctx context.Context, userID, entityID string, role api.Role, limits *Limits,
+) error {
+	return f.err
+}
+
+func (f *erringStorer) AddEntities(
+	ctx context.Context, as []*Association, limits *Limits,
+) ([]error, error) {
+	return f.addErrs, f.err
+}
+
+func (f *erringStorer) RemoveEntity(ctx context.Context, userID, entityID string) error {
+	return f.err
+}
+
+func (f *erringStorer) ImportAssociation(
+	ctx context.Context, versions []*AssociationVersion, overwrite bool,
+) (bool, error) {
+	return false, f.err
+}
+
+func (f *erringStorer) GetHistory(
+	ctx context.Context, userID, entityID string,
+) ([]*AssociationEvent, error) {
+	return f.history, nil
+}
+
+func (f *erringStorer) CountEntities(ctx context.Context, userID string) (int, error) {
+	return 0, f.err
+}
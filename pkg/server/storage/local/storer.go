@@ -0,0 +1,831 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/elixirhealth/user/pkg/server/storage"
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/golang/protobuf/proto"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+const (
+	// DBFilename is the name of the database file in the data directory.
+	DBFilename = "user.db"
+
+	dirPerm     = 0700
+	filePerm    = 0600
+	openTimeout = 1 * time.Second
+
+	// exportBatchSize is the max number of associations ExportAssociations reads per
+	// transaction, so that it doesn't hold one open while sending all of them.
+	exportBatchSize = 1024
+)
+
+var (
+	// userEntitiesBucket holds each version of each association as JSON, keyed by its sequence
+	// number. The JSON field names are those of storage.AssociationVersion.
+	userEntitiesBucket = []byte("user_entities")
+
+	// userCurrentBucket and entityCurrentBucket index the current associations by user then
+	// entity ID and by entity then user ID, respectively, with the sequence number of the
+	// association as the value.
+	userCurrentBucket   = []byte("user_current")
+	entityCurrentBucket = []byte("entity_current")
+
+	// userHistoryBucket and entityHistoryBucket index every version of the associations by user
+	// then entity ID and by entity then user ID, respectively, followed by the sequence number.
+	userHistoryBucket   = []byte("user_history")
+	entityHistoryBucket = []byte("entity_history")
+
+	userLimitsBucket   = []byte("user_limits")
+	entityLimitsBucket = []byte("entity_limits")
+	usersBucket        = []byte("users")
+
+	buckets = [][]byte{
+		userEntitiesBucket,
+		userCurrentBucket,
+		entityCurrentBucket,
+		userHistoryBucket,
+		entityHistoryBucket,
+		userLimitsBucket,
+		entityLimitsBucket,
+		usersBucket,
+	}
+)
+
+type storer struct {
+	params *storage.Parameters
+	logger *zap.Logger
+	db     *bolt.DB
+	feed   *storage.Broadcaster
+
+	// mu serializes writes with publishing their events, so the feed's order is the order in
+	// which they were committed
+	mu sync.Mutex
+}
+
+// New creates a new Storer backed by an embedded key-value store in the given data directory,
+// which is created if it doesn't exist. Only one Storer may have the directory open at a time.
+// Watch revisions start over each time the Storer is created.
+func New(dataDir string, params *storage.Parameters, logger *zap.Logger) (storage.Storer, error) {
+	if err := os.MkdirAll(dataDir, dirPerm); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dataDir, DBFilename)
+	db, err := bolt.Open(path, filePerm, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	logger.Info("opened local storage", zap.String(logPath, path))
+	return &storer{
+		params: params,
+		logger: logger,
+		db:     db,
+		feed:   storage.NewBroadcaster(storage.DefaultRetainedEvents),
+	}, nil
+}
+
+func (s *storer) AddEntity(
+	ctx context.Context, userID, entityID string, role api.Role, limits *storage.Limits,
+) error {
+	if userID == "" {
+		return api.ErrEmptyUserID
+	}
+	if entityID == "" {
+		return api.ErrEmptyEntityID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ue := storage.NewAssociationVersion(userID, entityID, role)
+
+	// the checks and the insert share a write transaction, which excludes any others
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(userCurrentBucket).Get(key(userID, entityID)) != nil {
+			return storage.ErrUserEntityExists
+		}
+		nUsers := countPrefix(tx.Bucket(entityCurrentBucket), key(entityID))
+		maxUsers := getLimit(tx.Bucket(entityLimitsBucket), entityID, limits.MaxEntityUsers)
+		if nUsers+1 > maxUsers {
+			return storage.ErrTooManyEntityUsers
+		}
+		nEntities := countPrefix(tx.Bucket(userCurrentBucket), key(userID))
+		maxEntities := getLimit(tx.Bucket(userLimitsBucket), userID, limits.MaxUserEntities)
+		if nEntities+1 > maxEntities {
+			return storage.ErrTooManyUserEntities
+		}
+		return insert(tx, ue)
+	})
+	if err != nil {
+		return err
+	}
+	s.feed.Publish(ue.LastEvent())
+	s.logger.Debug("storer added entity to user", logUserEntityFields(userID, entityID)...)
+	return nil
+}
+
+func (s *storer) AddEntities(
+	ctx context.Context, as []*storage.Association, limits *storage.Limits,
+) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	events := make([]*storage.AssociationEvent, 0, len(as))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		errs = getCounts(tx, as).CheckAdds(as, limits)
+		for i, a := range as {
+			if errs[i] != nil {
+				continue
+			}
+			ue := storage.NewAssociationVersion(a.UserID, a.EntityID, a.Role)
+			if err := insert(tx, ue); err != nil {
+				return err
+			}
+			events = append(events, ue.LastEvent())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.feed.Publish(events...)
+	s.logger.Debug("storer added entities to users", logAddEntities(as, errs)...)
+	return errs, nil
+}
+
+func (s *storer) RemoveEntity(ctx context.Context, userID, entityID string) error {
+	if userID == "" {
+		return api.ErrEmptyUserID
+	}
+	if entityID == "" {
+		return api.ErrEmptyEntityID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var removed *storage.AssociationVersion
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		seq, ue, err := getCurrent(tx, userID, entityID)
+		if err != nil {
+			return err
+		}
+		ue.MarkRemoved()
+		if err := putUserEntity(tx, seq, ue); err != nil {
+			return err
+		}
+		if err := tx.Bucket(userCurrentBucket).Delete(key(userID, entityID)); err != nil {
+			return err
+		}
+		removed = ue
+		return tx.Bucket(entityCurrentBucket).Delete(key(entityID, userID))
+	})
+	if err != nil {
+		return err
+	}
+	s.feed.Publish(removed.LastEvent())
+	s.logger.Debug("storer removed entity from user", logUserEntityFields(userID, entityID)...)
+	return nil
+}
+
+func (s *storer) UpdateRole(ctx context.Context, userID, entityID string, role api.Role) error {
+	if userID == "" {
+		return api.ErrEmptyUserID
+	}
+	if entityID == "" {
+		return api.ErrEmptyEntityID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var updated *storage.AssociationVersion
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		seq, ue, err := getCurrent(tx, userID, entityID)
+		if err != nil {
+			return err
+		}
+		if ue.Role == role {
+			return nil
+		}
+		updated = ue.UpdateRole(role)
+		if err := putUserEntity(tx, seq, ue); err != nil {
+			return err
+		}
+		return insert(tx, updated)
+	})
+	if err != nil {
+		return err
+	}
+	if updated != nil {
+		s.feed.Publish(updated.LastEvent())
+	}
+	s.logger.Debug("storer updated role", logUpdateRole(userID, entityID, role)...)
+	return nil
+}
+
+func (s *storer) GetEntities(
+	ctx context.Context, userID string, page *storage.Page,
+) ([]*storage.Association, string, error) {
+	if userID == "" {
+		return nil, "", api.ErrEmptyUserID
+	}
+	as, next, err := s.getEntities(userCurrentBucket, userID, page,
+		func(*storage.AssociationVersion) bool { return true })
+	if err != nil {
+		return nil, "", err
+	}
+	s.logger.Debug("storer got entities for user", logGetEntities(userID, as)...)
+	return as, next, nil
+}
+
+func (s *storer) GetEntitiesAsOf(
+	ctx context.Context, userID string, asOf time.Time, page *storage.Page,
+) ([]*storage.Association, string, error) {
+	if userID == "" {
+		return nil, "", api.ErrEmptyUserID
+	}
+	as, next, err := s.getEntities(userHistoryBucket, userID, page,
+		func(ue *storage.AssociationVersion) bool { return ue.ActiveAt(asOf) })
+	if err != nil {
+		return nil, "", err
+	}
+	s.logger.Debug("storer got entities for user as of time",
+		logGetEntitiesAsOf(userID, asOf, as)...)
+	return as, next, nil
+}
+
+// getEntities returns the page of the user's associations in the index bucket that match the
+// predicate, which the index orders by entity ID, along with the next page token.
+func (s *storer) getEntities(
+	index []byte, userID string, page *storage.Page, include func(*storage.AssociationVersion) bool,
+) ([]*storage.Association, string, error) {
+	after, err := page.ParseKeysetToken()
+	if err != nil {
+		return nil, "", err
+	}
+	as := make([]*storage.Association, 0)
+	err = s.db.View(func(tx *bolt.Tx) error {
+		start := key(userID)
+		if after != "" {
+			// the least key after those for the last entity ID, whose terminator it increments
+			start = key(userID, after)
+			start[len(start)-1]++
+		}
+		return scan(tx, index, key(userID), start, func(ue *storage.AssociationVersion) bool {
+			if include(ue) {
+				as = append(as, ue.Association())
+			}
+			return !page.Limited() || len(as) <= page.Size
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if !page.Limited() || len(as) <= page.Size {
+		return as, "", nil
+	}
+	as = as[:page.Size]
+	return as, storage.NewKeysetToken(as[page.Size-1].EntityID), nil
+}
+
+func (s *storer) GetEntitiesBatch(
+	ctx context.Context, userIDs []string,
+) (map[string][]string, error) {
+	entityIDs := make(map[string][]string, len(userIDs))
+	for _, userID := range userIDs {
+		if userID == "" {
+			return nil, api.ErrEmptyUserID
+		}
+		entityIDs[userID] = make([]string, 0)
+	}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for userID := range entityIDs {
+			err := scan(tx, userCurrentBucket, key(userID), key(userID),
+				func(ue *storage.AssociationVersion) bool {
+					entityIDs[userID] = append(entityIDs[userID], ue.EntityID)
+					return true
+				})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Debug("storer got entities for users", logGetEntitiesBatch(entityIDs)...)
+	return entityIDs, nil
+}
+
+func (s *storer) GetUsers(
+	ctx context.Context, entityID string, page *storage.Page,
+) ([]string, string, error) {
+	if entityID == "" {
+		return nil, "", api.ErrEmptyEntityID
+	}
+	after, err := page.ParseKeysetToken()
+	if err != nil {
+		return nil, "", err
+	}
+	userIDs := make([]string, 0)
+	err = s.db.View(func(tx *bolt.Tx) error {
+		start := key(entityID)
+		if after != "" {
+			// the least key after those for the last user ID, whose terminator it increments
+			start = key(entityID, after)
+			start[len(start)-1]++
+		}
+		return scan(tx, entityCurrentBucket, key(entityID), start,
+			func(ue *storage.AssociationVersion) bool {
+				userIDs = append(userIDs, ue.UserID)
+				return !page.Limited() || len(userIDs) <= page.Size
+			})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if page.Limited() && len(userIDs) > page.Size {
+		userIDs = userIDs[:page.Size]
+		next = storage.NewKeysetToken(userIDs[page.Size-1])
+	}
+	s.logger.Debug("storer got users for entity", logGetUsers(entityID, userIDs)...)
+	return userIDs, next, nil
+}
+
+func (s *storer) CountEntities(ctx context.Context, userID string) (int, error) {
+	if userID == "" {
+		return 0, api.ErrEmptyUserID
+	}
+	var n int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		n = countPrefix(tx.Bucket(userCurrentBucket), key(userID))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.logger.Debug("storer counted entities for user", logCountEntities(userID, n)...)
+	return n, nil
+}
+
+func (s *storer) CountUsers(ctx context.Context, entityID string) (int, error) {
+	if entityID == "" {
+		return 0, api.ErrEmptyEntityID
+	}
+	var n int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		n = countPrefix(tx.Bucket(entityCurrentBucket), key(entityID))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.logger.Debug("storer counted users for entity", logCountUsers(entityID, n)...)
+	return n, nil
+}
+
+func (s *storer) GetHistory(
+	ctx context.Context, userID, entityID string,
+) ([]*storage.AssociationEvent, error) {
+	var index, prefix []byte
+	switch {
+	case userID != "" && entityID != "":
+		index, prefix = userHistoryBucket, key(userID, entityID)
+	case userID != "":
+		index, prefix = userHistoryBucket, key(userID)
+	case entityID != "":
+		index, prefix = entityHistoryBucket, key(entityID)
+	default:
+		return nil, api.ErrEmptyUserAndEntityID
+	}
+	events := make([]*storage.AssociationEvent, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return scan(tx, index, prefix, prefix, func(ue *storage.AssociationVersion) bool {
+			events = append(events, ue.Events()...)
+			return true
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	storage.SortEvents(events)
+	s.logger.Debug("storer got association history", logGetHistory(userID, entityID, events)...)
+	return events, nil
+}
+
+func (s *storer) SetUserLimit(ctx context.Context, userID string, maxEntities int) error {
+	if userID == "" {
+		return api.ErrEmptyUserID
+	}
+	if err := s.setLimit(userLimitsBucket, userID, maxEntities); err != nil {
+		return err
+	}
+	s.logger.Debug("storer set user limit", logSetUserLimit(userID, maxEntities)...)
+	return nil
+}
+
+func (s *storer) SetEntityLimit(ctx context.Context, entityID string, maxUsers int) error {
+	if entityID == "" {
+		return api.ErrEmptyEntityID
+	}
+	if err := s.setLimit(entityLimitsBucket, entityID, maxUsers); err != nil {
+		return err
+	}
+	s.logger.Debug("storer set entity limit", logSetEntityLimit(entityID, maxUsers)...)
+	return nil
+}
+
+func (s *storer) setLimit(bucket []byte, id string, max int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if max == 0 {
+			return tx.Bucket(bucket).Delete([]byte(id))
+		}
+		return tx.Bucket(bucket).Put([]byte(id), encodeUint64(uint64(max)))
+	})
+}
+
+func (s *storer) PutUser(ctx context.Context, u *api.UserProfile) error {
+	if u.UserId == "" {
+		return api.ErrEmptyUserID
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return putUser(tx, u)
+	})
+	if err != nil {
+		return err
+	}
+	s.logger.Debug("storer put user", logUser(u)...)
+	return nil
+}
+
+func (s *storer) GetUser(ctx context.Context, userID string) (*api.UserProfile, error) {
+	if userID == "" {
+		return nil, api.ErrEmptyUserID
+	}
+	var u *api.UserProfile
+	err := s.db.View(func(tx *bolt.Tx) (err error) {
+		u, err = getUser(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Debug("storer got user", logUser(u)...)
+	return u, nil
+}
+
+func (s *storer) UpdateUser(
+	ctx context.Context, u *api.UserProfile,
+) (*api.UserProfile, error) {
+	if u.UserId == "" {
+		return nil, api.ErrEmptyUserID
+	}
+	var existing *api.UserProfile
+	err := s.db.Update(func(tx *bolt.Tx) (err error) {
+		existing, err = getUser(tx, u.UserId)
+		if err != nil {
+			return err
+		}
+		storage.MergeUser(existing, u)
+		return putUser(tx, existing)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Debug("storer updated user", logUser(existing)...)
+	return existing, nil
+}
+
+func (s *storer) Watch(
+	ctx context.Context,
+	userID, entityID string,
+	fromRevision uint64,
+	send func(*storage.AssociationEvent) error,
+) error {
+	if userID == "" && entityID == "" {
+		return api.ErrEmptyUserAndEntityID
+	}
+	s.logger.Debug("storer watching associations",
+		logWatch(userID, entityID, fromRevision)...)
+	return s.feed.Watch(ctx, userID, entityID, fromRevision, send)
+}
+
+func (s *storer) ExportAssociations(
+	ctx context.Context, send func([]*storage.AssociationVersion) error,
+) error {
+	n := 0
+	for start := []byte{}; start != nil; {
+		groups, next, err := s.exportBatch(start)
+		if err != nil {
+			return err
+		}
+		for _, group := range groups {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := send(group); err != nil {
+				return err
+			}
+		}
+		n += len(groups)
+		start = next
+	}
+	s.logger.Debug("storer exported associations", logExportAssociations(n)...)
+	return nil
+}
+
+// exportBatch returns the versions of up to exportBatchSize associations, starting at the given
+// user history key, along with the key the next batch starts at, which is nil after the last
+// batch.
+func (s *storer) exportBatch(start []byte) ([][]*storage.AssociationVersion, []byte, error) {
+	groups := make([][]*storage.AssociationVersion, 0, exportBatchSize)
+	var next []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(userHistoryBucket).Cursor()
+		for k, seqBytes := c.Seek(start); k != nil; k, seqBytes = c.Next() {
+			ue, err := getUserEntity(tx, seqBytes)
+			if err != nil {
+				return err
+			}
+			if last := len(groups) - 1; last >= 0 && sameAssociation(groups[last][0], ue) {
+				groups[last] = append(groups[last], ue)
+				continue
+			}
+			if len(groups) == exportBatchSize {
+				next = append([]byte{}, k...)
+				return nil
+			}
+			groups = append(groups, []*storage.AssociationVersion{ue})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return groups, next, nil
+}
+
+func (s *storer) ImportAssociation(
+	ctx context.Context, versions []*storage.AssociationVersion, overwrite bool,
+) (bool, error) {
+	if len(versions) == 0 {
+		return false, nil
+	}
+	userID, entityID := versions[0].UserID, versions[0].EntityID
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exists := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		exists = countPrefix(tx.Bucket(userHistoryBucket), key(userID, entityID)) > 0
+		if exists && !overwrite {
+			return storage.ErrUserEntityExists
+		}
+		if exists {
+			if err := deleteVersions(tx, userID, entityID); err != nil {
+				return err
+			}
+		}
+		for _, ue := range versions {
+			if err := insert(tx, ue); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	s.feed.Publish(storage.ImportedEvent(versions))
+	s.logger.Debug("storer imported association",
+		logImportAssociation(userID, entityID, len(versions), exists)...)
+	return exists, nil
+}
+
+func (s *storer) Close() error {
+	return s.db.Close()
+}
+
+// insert stores the new association version under the next sequence number and indexes it in
+// the history and, unless it's removed, as current.
+func insert(tx *bolt.Tx, ue *storage.AssociationVersion) error {
+	seq, err := tx.Bucket(userEntitiesBucket).NextSequence()
+	if err != nil {
+		return err
+	}
+	if err = putUserEntity(tx, seq, ue); err != nil {
+		return err
+	}
+	seqBytes := encodeUint64(seq)
+	indexes := []indexEntry{
+		{userHistoryBucket, append(key(ue.UserID, ue.EntityID), seqBytes...), seqBytes},
+		{entityHistoryBucket, append(key(ue.EntityID, ue.UserID), seqBytes...), seqBytes},
+	}
+	if !ue.Removed {
+		indexes = append(indexes,
+			indexEntry{userCurrentBucket, key(ue.UserID, ue.EntityID), seqBytes},
+			indexEntry{entityCurrentBucket, key(ue.EntityID, ue.UserID), seqBytes},
+		)
+	}
+	for _, idx := range indexes {
+		if err = tx.Bucket(idx.bucket).Put(idx.key, idx.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexEntry is a key and value to put in an index bucket.
+type indexEntry struct {
+	bucket, key, value []byte
+}
+
+// deleteVersions deletes every version of the user's association with the entity along with
+// their index entries.
+func deleteVersions(tx *bolt.Tx, userID, entityID string) error {
+	prefix := key(userID, entityID)
+	seqs := make([][]byte, 0)
+	c := tx.Bucket(userHistoryBucket).Cursor()
+	for k, seqBytes := c.Seek(prefix); hasPrefix(k, prefix); k, seqBytes = c.Next() {
+		seqs = append(seqs, append([]byte{}, seqBytes...))
+	}
+	for _, seqBytes := range seqs {
+		if err := tx.Bucket(userEntitiesBucket).Delete(seqBytes); err != nil {
+			return err
+		}
+		userKey := append(key(userID, entityID), seqBytes...)
+		if err := tx.Bucket(userHistoryBucket).Delete(userKey); err != nil {
+			return err
+		}
+		entityKey := append(key(entityID, userID), seqBytes...)
+		if err := tx.Bucket(entityHistoryBucket).Delete(entityKey); err != nil {
+			return err
+		}
+	}
+	if err := tx.Bucket(userCurrentBucket).Delete(key(userID, entityID)); err != nil {
+		return err
+	}
+	return tx.Bucket(entityCurrentBucket).Delete(key(entityID, userID))
+}
+
+// getCurrent returns the sequence number and version of the user's current association with the
+// entity, or ErrUserEntityNotExists if there isn't one.
+func getCurrent(tx *bolt.Tx, userID, entityID string) (uint64, *storage.AssociationVersion, error) {
+	seqBytes := tx.Bucket(userCurrentBucket).Get(key(userID, entityID))
+	if seqBytes == nil {
+		return 0, nil, storage.ErrUserEntityNotExists
+	}
+	ue, err := getUserEntity(tx, seqBytes)
+	if err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint64(seqBytes), ue, nil
+}
+
+// getCounts returns the existing associations, association counts, and limit overrides of the
+// users and entities in the batch.
+func getCounts(tx *bolt.Tx, as []*storage.Association) *storage.AssociationCounts {
+	counts := storage.NewAssociationCounts()
+	userCurrent, entityCurrent := tx.Bucket(userCurrentBucket), tx.Bucket(entityCurrentBucket)
+	for _, a := range as {
+		if userCurrent.Get(key(a.UserID, a.EntityID)) != nil {
+			counts.Existing[a.ID()] = struct{}{}
+		}
+		if _, in := counts.UserEntities[a.UserID]; !in {
+			counts.UserEntities[a.UserID] = countPrefix(userCurrent, key(a.UserID))
+			if max := getLimit(tx.Bucket(userLimitsBucket), a.UserID, 0); max > 0 {
+				counts.MaxUserEntities[a.UserID] = max
+			}
+		}
+		if _, in := counts.EntityUsers[a.EntityID]; !in {
+			counts.EntityUsers[a.EntityID] = countPrefix(entityCurrent, key(a.EntityID))
+			if max := getLimit(tx.Bucket(entityLimitsBucket), a.EntityID, 0); max > 0 {
+				counts.MaxEntityUsers[a.EntityID] = max
+			}
+		}
+	}
+	return counts
+}
+
+// scan calls the visit function with the association version each key in the index bucket with
+// the given prefix refers to, starting at the given key and in key order, until the function
+// returns false.
+func scan(
+	tx *bolt.Tx, index, prefix, start []byte, visit func(ue *storage.AssociationVersion) bool,
+) error {
+	c := tx.Bucket(index).Cursor()
+	for k, seqBytes := c.Seek(start); hasPrefix(k, prefix); k, seqBytes = c.Next() {
+		ue, err := getUserEntity(tx, seqBytes)
+		if err != nil {
+			return err
+		}
+		if !visit(ue) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// countPrefix returns the number of keys in the bucket with the given prefix.
+func countPrefix(b *bolt.Bucket, prefix []byte) int {
+	n := 0
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); hasPrefix(k, prefix); k, _ = c.Next() {
+		n++
+	}
+	return n
+}
+
+// getLimit returns the limit override for the ID in the bucket, or the default if it has none.
+func getLimit(b *bolt.Bucket, id string, dflt int) int {
+	if value := b.Get([]byte(id)); value != nil {
+		return int(binary.BigEndian.Uint64(value))
+	}
+	return dflt
+}
+
+func sameAssociation(v1, v2 *storage.AssociationVersion) bool {
+	return v1.UserID == v2.UserID && v1.EntityID == v2.EntityID
+}
+
+// hasPrefix returns whether the cursor key, which is nil past the last key, has the prefix.
+func hasPrefix(k, prefix []byte) bool {
+	return k != nil && bytes.HasPrefix(k, prefix)
+}
+
+func putUserEntity(tx *bolt.Tx, seq uint64, ue *storage.AssociationVersion) error {
+	value, err := json.Marshal(ue)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(userEntitiesBucket).Put(encodeUint64(seq), value)
+}
+
+func getUserEntity(tx *bolt.Tx, seqBytes []byte) (*storage.AssociationVersion, error) {
+	ue := &storage.AssociationVersion{}
+	if err := json.Unmarshal(tx.Bucket(userEntitiesBucket).Get(seqBytes), ue); err != nil {
+		return nil, err
+	}
+	return ue, nil
+}
+
+func putUser(tx *bolt.Tx, u *api.UserProfile) error {
+	value, err := proto.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(usersBucket).Put([]byte(u.UserId), value)
+}
+
+func getUser(tx *bolt.Tx, userID string) (*api.UserProfile, error) {
+	value := tx.Bucket(usersBucket).Get([]byte(userID))
+	if value == nil {
+		return nil, storage.ErrUserNotExists
+	}
+	u := &api.UserProfile{}
+	if err := proto.Unmarshal(value, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// key returns the index key of the IDs. Each ID is escaped and terminated so that keys sort by
+// their IDs in order and the key of some IDs is a prefix of those that begin with them.
+func key(ids ...string) []byte {
+	k := make([]byte, 0, 32)
+	for _, id := range ids {
+		for i := 0; i < len(id); i++ {
+			if id[i] == 0x00 {
+				k = append(k, 0x00, 0xff)
+				continue
+			}
+			k = append(k, id[i])
+		}
+		k = append(k, 0x00, 0x01)
+	}
+	return k
+}
+
+func encodeUint64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,605 @@
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/elixirhealth/user/pkg/server/storage"
+	"github.com/elixirhealth/user/pkg/server/storage/storagetest"
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+var testLimits = &storage.Limits{MaxUserEntities: 2, MaxEntityUsers: 2}
+
+func TestNew_ok(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	dataDir := filepath.Join(dir, "not", "yet", "created")
+
+	s, err := New(dataDir, storage.NewDefaultParameters(), zap.NewNop())
+	assert.Nil(t, err)
+	err = s.AddEntity(context.Background(), "some user", "some entity", api.Role_OWNER,
+		testLimits)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Close())
+
+	// associations persist across restarts
+	s, err = New(dataDir, storage.NewDefaultParameters(), zap.NewNop())
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, s.Close()) }()
+	as, _, err := s.GetEntities(context.Background(), "some user", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"some entity"}, storage.EntityIDs(as))
+}
+
+func TestNew_err(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	notDir := filepath.Join(dir, "not-a-dir")
+	assert.Nil(t, ioutil.WriteFile(notDir, []byte("some file"), 0600))
+
+	s, err := New(notDir, storage.NewDefaultParameters(), zap.NewNop())
+	assert.NotNil(t, err)
+	assert.Nil(t, s)
+}
+
+func TestLocalStorer_Conformance(t *testing.T) {
+	dir := tempDir(t)
+	defer func() { assert.Nil(t, os.RemoveAll(dir)) }()
+	n := 0
+	storagetest.Run(t, func() storage.Storer {
+		n++
+		dataDir := filepath.Join(dir, strconv.Itoa(n))
+		s, err := New(dataDir, storage.NewDefaultParameters(), zap.NewNop())
+		assert.Nil(t, err)
+		return s
+	})
+}
+
+func TestLocalStorer_AddEntity_ok(t *testing.T) {
+	s, cleanup := newTestStorer(t)
+	defer cleanup()
+	userID, entityID := "some user", "some entity"
+
+	err := s.AddEntity(context.Background(), userID, entityID, api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+
+	as, _, err := s.GetEntities(context.Background(), userID, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Association{
+		{UserID: userID, EntityID: entityID, Role: api.Role_OWNER},
+	}, as)
+	userIDs, _, err := s.GetUsers(context.Background(), entityID, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{userID}, userIDs)
+}
+
+func TestLocalStorer_AddEntity_err(t *testing.T) {
+	userID, entityID := "some user", "some entity"
+	cases := map[string]struct {
+		existing []*storage.Association
+		userID   string
+		entityID string
+		expected error
+	}{
+		"empty user ID": {
+			entityID: entityID,
+			expected: api.ErrEmptyUserID,
+		},
+		"empty entity ID": {
+			userID:   userID,
+			expected: api.ErrEmptyEntityID,
+		},
+		"association exists": {
+			existing: []*storage.Association{{UserID: userID, EntityID: entityID}},
+			userID:   userID,
+			entityID: entityID,
+			expected: storage.ErrUserEntityExists,
+		},
+		"too many entity users": {
+			existing: []*storage.Association{
+				{UserID: "user 1", EntityID: entityID},
+				{UserID: "user 2", EntityID: entityID},
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: storage.ErrTooManyEntityUsers,
+		},
+		"too many user entities": {
+			existing: []*storage.Association{
+				{UserID: userID, EntityID: "entity 1"},
+				{UserID: userID, EntityID: "entity 2"},
+			},
+			userID:   userID,
+			entityID: entityID,
+			expected: storage.ErrTooManyUserEntities,
+		},
+	}
+	for desc, c := range cases {
+		s, cleanup := newTestStorer(t)
+		errs, err := s.AddEntities(context.Background(), c.existing, testLimits)
+		assert.Nil(t, err, desc)
+		assert.Equal(t, make([]error, len(c.existing)), errs, desc)
+
+		err = s.AddEntity(context.Background(), c.userID, c.entityID, api.Role_OWNER,
+			testLimits)
+		assert.Equal(t, c.expected, err, desc)
+		cleanup()
+	}
+}
+
+func TestLocalStorer_SetLimit(t *testing.T) {
+	s, cleanup := newTestStorer(t)
+	defer cleanup()
+	ctx := context.Background()
+	userID := "some user"
+
+	// override raises the user's limit above the default
+	err := s.SetUserLimit(ctx, userID, 3)
+	assert.Nil(t, err)
+	for _, entityID := range []string{"entity 1", "entity 2", "entity 3"} {
+		err = s.AddEntity(ctx, userID, entityID, api.Role_OWNER, testLimits)
+		assert.Nil(t, err)
+	}
+	err = s.AddEntity(ctx, userID, "entity 4", api.Role_OWNER, testLimits)
+	assert.Equal(t, storage.ErrTooManyUserEntities, err)
+
+	// override lowers the entity's limit below the default
+	err = s.SetEntityLimit(ctx, "entity 1", 1)
+	assert.Nil(t, err)
+	err = s.AddEntity(ctx, "another user", "entity 1", api.Role_OWNER, testLimits)
+	assert.Equal(t, storage.ErrTooManyEntityUsers, err)
+
+	// removing the override restores the default
+	err = s.SetEntityLimit(ctx, "entity 1", 0)
+	assert.Nil(t, err)
+	err = s.AddEntity(ctx, "another user", "entity 1", api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+
+	assert.Equal(t, api.ErrEmptyUserID, s.SetUserLimit(ctx, "", 1))
+	assert.Equal(t, api.ErrEmptyEntityID, s.SetEntityLimit(ctx, "", 1))
+}
+
+func TestLocalStorer_AddEntities(t *testing.T) {
+	s, cleanup := newTestStorer(t)
+	defer cleanup()
+	ctx := context.Background()
+	err := s.AddEntity(ctx, "user 1", "entity 1", api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+	err = s.SetEntityLimit(ctx, "entity 3", 1)
+	assert.Nil(t, err)
+
+	errs, err := s.AddEntities(ctx, []*storage.Association{
+		{UserID: "user 1", EntityID: "entity 1"},
+		{UserID: "user 1", EntityID: "entity 2"},
+		{UserID: "user 1", EntityID: "entity 3"},
+		{UserID: "user 2", EntityID: "entity 3"},
+		{UserID: "user 3", EntityID: "entity 3"},
+		{UserID: "", EntityID: "entity 4"},
+	}, testLimits)
+	assert.Nil(t, err)
+	assert.Equal(t, []error{
+		storage.ErrUserEntityExists,
+		nil,
+		storage.ErrTooManyUserEntities,
+		nil,
+		storage.ErrTooManyEntityUsers,
+		api.ErrEmptyUserID,
+	}, errs)
+
+	batch, err := s.GetEntitiesBatch(ctx, []string{"user 1", "user 2", "user 3"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		"user 1": {"entity 1", "entity 2"},
+		"user 2": {"entity 3"},
+		"user 3": {},
+	}, batch)
+
+	batch, err = s.GetEntitiesBatch(ctx, []string{"user 1", ""})
+	assert.Equal(t, api.ErrEmptyUserID, err)
+	assert.Nil(t, batch)
+}
+
+func TestLocalStorer_RemoveEntity(t *testing.T) {
+	s, cleanup := newTestStorer(t)
+	defer cleanup()
+	ctx := context.Background()
+	userID, entityID := "some user", "some entity"
+
+	err := s.RemoveEntity(ctx, userID, entityID)
+	assert.Equal(t, storage.ErrUserEntityNotExists, err)
+	assert.Equal(t, api.ErrEmptyUserID, s.RemoveEntity(ctx, "", entityID))
+	assert.Equal(t, api.ErrEmptyEntityID, s.RemoveEntity(ctx, userID, ""))
+
+	err = s.AddEntity(ctx, userID, entityID, api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+	err = s.RemoveEntity(ctx, userID, entityID)
+	assert.Nil(t, err)
+	err = s.RemoveEntity(ctx, userID, entityID)
+	assert.Equal(t, storage.ErrUserEntityNotExists, err)
+
+	nEntities, err := s.CountEntities(ctx, userID)
+	assert.Nil(t, err)
+	assert.Zero(t, nEntities)
+	nUsers, err := s.CountUsers(ctx, entityID)
+	assert.Nil(t, err)
+	assert.Zero(t, nUsers)
+
+	// the association can be added again
+	err = s.AddEntity(ctx, userID, entityID, api.Role_CLINICIAN, testLimits)
+	assert.Nil(t, err)
+	events, err := s.GetHistory(ctx, userID, entityID)
+	assert.Nil(t, err)
+	assert.Equal(t, []api.EventType{
+		api.EventType_ADDED, api.EventType_REMOVED, api.EventType_ADDED,
+	}, eventTypes(events))
+}
+
+func TestLocalStorer_UpdateRole(t *testing.T) {
+	s, cleanup := newTestStorer(t)
+	defer cleanup()
+	ctx := context.Background()
+	userID, entityID := "some user", "some entity"
+
+	err := s.UpdateRole(ctx, userID, entityID, api.Role_ADMIN)
+	assert.Equal(t, storage.ErrUserEntityNotExists, err)
+	assert.Equal(t, api.ErrEmptyUserID, s.UpdateRole(ctx, "", entityID, api.Role_ADMIN))
+	assert.Equal(t, api.ErrEmptyEntityID, s.UpdateRole(ctx, userID, "", api.Role_ADMIN))
+
+	err = s.AddEntity(ctx, userID, entityID, api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+	err = s.UpdateRole(ctx, userID, entityID, api.Role_ADMIN)
+	assert.Nil(t, err)
+
+	// updating to the current role does nothing
+	err = s.UpdateRole(ctx, userID, entityID, api.Role_ADMIN)
+	assert.Nil(t, err)
+
+	as, _, err := s.GetEntities(ctx, userID, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Association{
+		{UserID: userID, EntityID: entityID, Role: api.Role_ADMIN},
+	}, as)
+	nUsers, err := s.CountUsers(ctx, entityID)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, nUsers)
+
+	events, err := s.GetHistory(ctx, "", entityID)
+	assert.Nil(t, err)
+	assert.Equal(t, []api.EventType{
+		api.EventType_ADDED, api.EventType_ROLE_UPDATED,
+	}, eventTypes(events))
+	assert.Equal(t, api.Role_ADMIN, events[1].Role)
+}
+
+func TestLocalStorer_GetEntities_paging(t *testing.T) {
+	s, cleanup := newTestStorer(t)
+	defer cleanup()
+	userID := "some user ID"
+
+	// IDs that are prefixes of others or contain null bytes still sort as strings
+	entityIDs := []string{"entity 3", "entity 1", "entity 1\x00", "entity 12", "entity 2"}
+	limits := &storage.Limits{MaxUserEntities: len(entityIDs), MaxEntityUsers: 1}
+	for _, entityID := range entityIDs {
+		err := s.AddEntity(context.Background(), userID, entityID, api.Role_OWNER, limits)
+		assert.Nil(t, err)
+	}
+	err := s.AddEntity(context.Background(), "some user", "another entity", api.Role_OWNER,
+		limits)
+	assert.Nil(t, err)
+
+	page := &storage.Page{Size: 2}
+	var pages [][]string
+	for {
+		as, next, err := s.GetEntities(context.Background(), userID, page)
+		assert.Nil(t, err)
+		pages = append(pages, storage.EntityIDs(as))
+		if next == "" {
+			break
+		}
+		page.Token = next
+	}
+	assert.Equal(t, [][]string{
+		{"entity 1", "entity 1\x00"},
+		{"entity 12", "entity 2"},
+		{"entity 3"},
+	}, pages)
+
+	sorted := append([]string{}, entityIDs...)
+	sort.Strings(sorted)
+	as, next, err := s.GetEntities(context.Background(), userID, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, sorted, storage.EntityIDs(as))
+	assert.Empty(t, next)
+}
+
+func TestLocalStorer_GetEntities_err(t *testing.T) {
+	s, cleanup := newTestStorer(t)
+	defer cleanup()
+	as, _, err := s.GetEntities(context.Background(), "", nil)
+	assert.Equal(t, api.ErrEmptyUserID, err)
+	assert.Nil(t, as)
+
+	page := &storage.Page{Size: 1, Token: "not a page token!"}
+	as, _, err = s.GetEntities(context.Background(), "some user ID", page)
+	assert.Equal(t, storage.ErrInvalidPageToken, err)
+	assert.Nil(t, as)
+
+	as, _, err = s.GetEntitiesAsOf(context.Background(), "", time.Now(), nil)
+	assert.Equal(t, api.ErrEmptyUserID, err)
+	assert.Nil(t, as)
+}
+
+func TestLocalStorer_GetEntitiesAsOf(t *testing.T) {
+	s, cleanup := newTestStorer(t)
+	defer cleanup()
+	userID := "some user ID"
+	entityID1, entityID2, entityID3 := "entity ID 1", "entity ID 2", "entity ID 3"
+	t0 := time.Now().UTC()
+	t1, t2, t3 := t0.Add(1*time.Second), t0.Add(2*time.Second), t0.Add(3*time.Second)
+	putUserEntities(t, s, []*storage.AssociationVersion{
+		{UserID: userID, EntityID: entityID1, AddedTime: t0},
+		{UserID: userID, EntityID: entityID2, AddedTime: t0, Removed: true, RemovedTime: t2},
+		{UserID: userID, EntityID: entityID3, AddedTime: t2},
+	})
+
+	as, _, err := s.GetEntitiesAsOf(context.Background(), userID, t1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID1, entityID2}, storage.EntityIDs(as))
+
+	as, _, err = s.GetEntitiesAsOf(context.Background(), userID, t3, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID1, entityID3}, storage.EntityIDs(as))
+
+	page := &storage.Page{Size: 1}
+	as, next, err := s.GetEntitiesAsOf(context.Background(), userID, t3, page)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID1}, storage.EntityIDs(as))
+	page.Token = next
+	as, next, err = s.GetEntitiesAsOf(context.Background(), userID, t3, page)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID3}, storage.EntityIDs(as))
+	assert.Empty(t, next)
+
+	as, _, err = s.GetEntitiesAsOf(context.Background(), userID, t0.Add(-1*time.Second), nil)
+	assert.Nil(t, err)
+	assert.Empty(t, as)
+}
+
+func TestLocalStorer_GetUsers_Count(t *testing.T) {
+	s, cleanup := newTestStorer(t)
+	defer cleanup()
+	ctx := context.Background()
+	errs, err := s.AddEntities(ctx, []*storage.Association{
+		{UserID: "user 1", EntityID: "entity 1"},
+		{UserID: "user 2", EntityID: "entity 1"},
+		{UserID: "user 1", EntityID: "entity 2"},
+	}, testLimits)
+	assert.Nil(t, err)
+	assert.Equal(t, make([]error, 3), errs)
+
+	userIDs, _, err := s.GetUsers(ctx, "entity 1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user 1", "user 2"}, userIDs)
+	nUsers, err := s.CountUsers(ctx, "entity 1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, nUsers)
+	nEntities, err := s.CountEntities(ctx, "user 1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, nEntities)
+
+	// counts don't include IDs the given one is a prefix of
+	nEntities, err = s.CountEntities(ctx, "user")
+	assert.Nil(t, err)
+	assert.Zero(t, nEntities)
+
+	userIDs, _, err = s.GetUsers(ctx, "", nil)
+	assert.Equal(t, api.ErrEmptyEntityID, err)
+	assert.Nil(t, userIDs)
+	_, err = s.CountUsers(ctx, "")
+	assert.Equal(t, api.ErrEmptyEntityID, err)
+	_, err = s.CountEntities(ctx, "")
+	assert.Equal(t, api.ErrEmptyUserID, err)
+}
+
+func TestLocalStorer_GetHistory(t *testing.T) {
+	s, cleanup := newTestStorer(t)
+	defer cleanup()
+	userID1, userID2 := "some user ID", "another user ID"
+	entityID1, entityID2 := "some entity ID", "another entity ID"
+	t0 := time.Now().UTC()
+	t1, t2 := t0.Add(1*time.Second), t0.Add(2*time.Second)
+	putUserEntities(t, s, []*storage.AssociationVersion{
+		{UserID: userID1, EntityID: entityID1, AddedTime: t0, Removed: true, RemovedTime: t2},
+		{UserID: userID1, EntityID: entityID2, AddedTime: t1},
+		{UserID: userID2, EntityID: entityID1, AddedTime: t1},
+	})
+
+	events, err := s.GetHistory(context.Background(), userID1, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.AssociationEvent{
+		{Type: api.EventType_ADDED, Time: t0, UserID: userID1, EntityID: entityID1},
+		{Type: api.EventType_ADDED, Time: t1, UserID: userID1, EntityID: entityID2},
+		{Type: api.EventType_REMOVED, Time: t2, UserID: userID1, EntityID: entityID1},
+	}, events)
+
+	events, err = s.GetHistory(context.Background(), "", entityID1)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.AssociationEvent{
+		{Type: api.EventType_ADDED, Time: t0, UserID: userID1, EntityID: entityID1},
+		{Type: api.EventType_ADDED, Time: t1, UserID: userID2, EntityID: entityID1},
+		{Type: api.EventType_REMOVED, Time: t2, UserID: userID1, EntityID: entityID1},
+	}, events)
+
+	events, err = s.GetHistory(context.Background(), userID2, entityID1)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.AssociationEvent{
+		{Type: api.EventType_ADDED, Time: t1, UserID: userID2, EntityID: entityID1},
+	}, events)
+
+	events, err = s.GetHistory(context.Background(), "", "")
+	assert.Equal(t, api.ErrEmptyUserAndEntityID, err)
+	assert.Nil(t, events)
+}
+
+func TestLocalStorer_Watch(t *testing.T) {
+	s, cleanup := newTestStorer(t)
+	defer cleanup()
+	userID, entityID := "some user", "some entity"
+	ctx := context.Background()
+	send := func(e *storage.AssociationEvent) error { return nil }
+	err := s.Watch(ctx, "", "", 0, send)
+	assert.Equal(t, api.ErrEmptyUserAndEntityID, err)
+
+	err = s.AddEntity(ctx, userID, entityID, api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+	err = s.UpdateRole(ctx, userID, entityID, api.Role_ADMIN)
+	assert.Nil(t, err)
+	err = s.RemoveEntity(ctx, userID, entityID)
+	assert.Nil(t, err)
+	err = s.AddEntity(ctx, "another user", entityID, api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+
+	errStop := errors.New("stop watch")
+	events := make([]*storage.AssociationEvent, 0)
+	err = s.Watch(ctx, userID, "", 1, func(e *storage.AssociationEvent) error {
+		events = append(events, e)
+		if len(events) == 2 {
+			return errStop
+		}
+		return nil
+	})
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, api.EventType_ROLE_UPDATED, events[0].Type)
+	assert.Equal(t, api.Role_ADMIN, events[0].Role)
+	assert.Equal(t, uint64(2), events[0].Revision)
+	assert.Equal(t, api.EventType_REMOVED, events[1].Type)
+	assert.Equal(t, uint64(3), events[1].Revision)
+}
+
+func TestLocalStorer_PutGetUpdateUser(t *testing.T) {
+	s, cleanup := newTestStorer(t)
+	defer cleanup()
+	ctx := context.Background()
+	userID := "some user"
+
+	u, err := s.GetUser(ctx, userID)
+	assert.Equal(t, storage.ErrUserNotExists, err)
+	assert.Nil(t, u)
+	u, err = s.UpdateUser(ctx, &api.UserProfile{UserId: userID})
+	assert.Equal(t, storage.ErrUserNotExists, err)
+	assert.Nil(t, u)
+
+	err = s.PutUser(ctx, &api.UserProfile{
+		UserId:      userID,
+		DisplayName: "Some User",
+		Attributes:  map[string]string{"key1": "value1"},
+	})
+	assert.Nil(t, err)
+
+	u, err = s.UpdateUser(ctx, &api.UserProfile{
+		UserId:     userID,
+		Email:      "some.user@example.com",
+		Attributes: map[string]string{"key1": "", "key2": "value2"},
+	})
+	assert.Nil(t, err)
+	expected := &api.UserProfile{
+		UserId:      userID,
+		DisplayName: "Some User",
+		Email:       "some.user@example.com",
+		Attributes:  map[string]string{"key2": "value2"},
+	}
+	assert.Equal(t, expected, u)
+	u, err = s.GetUser(ctx, userID)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, u)
+
+	// put replaces the whole profile
+	err = s.PutUser(ctx, &api.UserProfile{UserId: userID, Locale: "en-US"})
+	assert.Nil(t, err)
+	u, err = s.GetUser(ctx, userID)
+	assert.Nil(t, err)
+	assert.Equal(t, &api.UserProfile{UserId: userID, Locale: "en-US"}, u)
+}
+
+func TestLocalStorer_User_err(t *testing.T) {
+	s, cleanup := newTestStorer(t)
+	defer cleanup()
+
+	err := s.PutUser(context.Background(), &api.UserProfile{})
+	assert.Equal(t, api.ErrEmptyUserID, err)
+
+	u, err := s.GetUser(context.Background(), "")
+	assert.Equal(t, api.ErrEmptyUserID, err)
+	assert.Nil(t, u)
+
+	u, err = s.UpdateUser(context.Background(), &api.UserProfile{})
+	assert.Equal(t, api.ErrEmptyUserID, err)
+	assert.Nil(t, u)
+}
+
+func TestKey(t *testing.T) {
+	ids := []string{"", "\x00", "\x00\x00", "\x00a", "a", "a\x00", "a\x00b", "ab", "b"}
+	for i := 1; i < len(ids); i++ {
+		assert.True(t, string(key(ids[i-1])) < string(key(ids[i])), i)
+	}
+	assert.NotEqual(t, key("a", "b"), key("a\x00", "b"))
+}
+
+func newTestStorer(t *testing.T) (*storer, func()) {
+	dir := tempDir(t)
+	s, err := New(dir, storage.NewDefaultParameters(), zap.NewNop())
+	assert.Nil(t, err)
+	return s.(*storer), func() {
+		assert.Nil(t, s.Close())
+		assert.Nil(t, os.RemoveAll(dir))
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "local-storer")
+	assert.Nil(t, err)
+	return dir
+}
+
+// putUserEntities inserts the association versions, indexing any that aren't removed as current.
+func putUserEntities(t *testing.T, s *storer, ues []*storage.AssociationVersion) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, ue := range ues {
+			if err := insert(tx, ue); err != nil {
+				return err
+			}
+			if !ue.Removed {
+				continue
+			}
+			userCurrent := tx.Bucket(userCurrentBucket)
+			if err := userCurrent.Delete(key(ue.UserID, ue.EntityID)); err != nil {
+				return err
+			}
+			entityCurrent := tx.Bucket(entityCurrentBucket)
+			if err := entityCurrent.Delete(key(ue.EntityID, ue.UserID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func eventTypes(events []*storage.AssociationEvent) []api.EventType {
+	types := make([]api.EventType, len(events))
+	for i, e := range events {
+		types[i] = e.Type
+	}
+	return types
+}
@@ -4,4 +4,6 @@ const (
 	logType            = "type"
 	logAddQueryTimeout = "add_query_timeout"
 	logGetQueryTimeout = "get_query_timeout"
+	logCacheSize       = "cache_size"
+	logCacheTTL        = "cache_ttl"
 )
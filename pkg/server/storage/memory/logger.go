@@ -1,31 +1,83 @@
 package memory
 
 import (
+	"time"
+
+	"github.com/elixirhealth/user/pkg/server/storage"
+	api "github.com/elixirhealth/user/pkg/userapi"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 const (
-	logEntityID  = "entity_id"
-	logUserID    = "user_id"
-	logNEntities = "n_entities"
-	logCount     = "count"
+	logEntityID      = "entity_id"
+	logUserID        = "user_id"
+	logNEntities     = "n_entities"
+	logNUsers        = "n_users"
+	logAsOf          = "as_of"
+	logNEvents       = "n_events"
+	logCount         = "count"
+	logMax           = "max"
+	logNAdded        = "n_added"
+	logNFailed       = "n_failed"
+	logRole          = "role"
+	logNAttributes   = "n_attributes"
+	logFromRevision  = "from_revision"
+	logNAssociations = "n_associations"
+	logNVersions     = "n_versions"
+	logOverwritten   = "overwritten"
 )
 
-func logAddEntityFields(userID, entityID string) []zapcore.Field {
+func logUserEntityFields(userID, entityID string) []zapcore.Field {
 	return []zapcore.Field{
 		zap.String(logEntityID, entityID),
 		zap.String(logUserID, userID),
 	}
 }
 
-func logGetEntities(userID string, entityIDs []string) []zapcore.Field {
+func logGetEntities(userID string, as []*storage.Association) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logUserID, userID),
+		zap.Int(logNEntities, len(as)),
+	}
+}
+
+func logGetEntitiesAsOf(
+	userID string, asOf time.Time, as []*storage.Association,
+) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logUserID, userID),
+		zap.Time(logAsOf, asOf),
+		zap.Int(logNEntities, len(as)),
+	}
+}
+
+func logUpdateRole(userID, entityID string, role api.Role) []zapcore.Field {
+	return append(logUserEntityFields(userID, entityID), zap.Stringer(logRole, role))
+}
+
+func logGetUsers(entityID string, userIDs []string) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logEntityID, entityID),
+		zap.Int(logNUsers, len(userIDs)),
+	}
+}
+
+func logGetHistory(
+	userID, entityID string, events []*storage.AssociationEvent,
+) []zapcore.Field {
 	return []zapcore.Field{
 		zap.String(logUserID, userID),
-		zap.Int(logNEntities, len(entityIDs)),
+		zap.String(logEntityID, entityID),
+		zap.Int(logNEvents, len(events)),
 	}
 }
 
+func logWatch(userID, entityID string, fromRevision uint64) []zapcore.Field {
+	return append(logUserEntityFields(userID, entityID),
+		zap.Uint64(logFromRevision, fromRevision))
+}
+
 func logCountEntities(userID string, n int) []zapcore.Field {
 	return []zapcore.Field{
 		zap.String(logUserID, userID),
@@ -39,3 +91,63 @@ func logCountUsers(entityID string, n int) []zapcore.Field {
 		zap.Int(logCount, n),
 	}
 }
+
+func logSetUserLimit(userID string, max int) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logUserID, userID),
+		zap.Int(logMax, max),
+	}
+}
+
+func logSetEntityLimit(entityID string, max int) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logEntityID, entityID),
+		zap.Int(logMax, max),
+	}
+}
+
+func logAddEntities(as []*storage.Association, errs []error) []zapcore.Field {
+	nFailed := 0
+	for _, err := range errs {
+		if err != nil {
+			nFailed++
+		}
+	}
+	return []zapcore.Field{
+		zap.Int(logNAdded, len(as)-nFailed),
+		zap.Int(logNFailed, nFailed),
+	}
+}
+
+func logGetEntitiesBatch(entityIDs map[string][]string) []zapcore.Field {
+	nEntities := 0
+	for _, ids := range entityIDs {
+		nEntities += len(ids)
+	}
+	return []zapcore.Field{
+		zap.Int(logNUsers, len(entityIDs)),
+		zap.Int(logNEntities, nEntities),
+	}
+}
+
+func logUser(u *api.UserProfile) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logUserID, u.UserId),
+		zap.Int(logNAttributes, len(u.Attributes)),
+	}
+}
+
+func logExportAssociations(nAssociations int) []zapcore.Field {
+	return []zapcore.Field{
+		zap.Int(logNAssociations, nAssociations),
+	}
+}
+
+func logImportAssociation(
+	userID, entityID string, nVersions int, overwritten bool,
+) []zapcore.Field {
+	return append(logUserEntityFields(userID, entityID),
+		zap.Int(logNVersions, nVersions),
+		zap.Bool(logOverwritten, overwritten),
+	)
+}
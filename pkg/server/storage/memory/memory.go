@@ -1,31 +1,57 @@
 package memory
 
 import (
+	"context"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/elixirhealth/user/pkg/server/storage"
-	"github.com/elixirhealth/user/pkg/server/storage/datastore"
 	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/golang/protobuf/proto"
 	"go.uber.org/zap"
 )
 
 type storer struct {
-	params       *storage.Parameters
-	logger       *zap.Logger
-	userEntities []*datastore.UserEntity
-	mu           sync.Mutex
+	params *storage.Parameters
+	logger *zap.Logger
+
+	// userEntities and entityUsers index the current associations by user and entity ID and
+	// then by entity and user ID, respectively.
+	userEntities versionIndex
+	entityUsers  versionIndex
+
+	// userHistory and entityHistory contain every version of each user's and entity's
+	// associations, respectively, in the order they were added.
+	userHistory   map[string][]*storage.AssociationVersion
+	entityHistory map[string][]*storage.AssociationVersion
+
+	userLimits   map[string]int
+	entityLimits map[string]int
+	users        map[string]*api.UserProfile
+	feed         *storage.Broadcaster
+	mu           sync.RWMutex
 }
 
-// New creates a new Storer backed by an in-memory list.
+// New creates a new Storer backed by in-memory indexes of the associations by user and entity.
 func New(params *storage.Parameters, logger *zap.Logger) storage.Storer {
 	return &storer{
-		userEntities: make([]*datastore.UserEntity, 0),
-		params:       params,
-		logger:       logger,
+		userEntities:  make(versionIndex),
+		entityUsers:   make(versionIndex),
+		userHistory:   make(map[string][]*storage.AssociationVersion),
+		entityHistory: make(map[string][]*storage.AssociationVersion),
+		userLimits:    make(map[string]int),
+		entityLimits:  make(map[string]int),
+		users:         make(map[string]*api.UserProfile),
+		feed:          storage.NewBroadcaster(storage.DefaultRetainedEvents),
+		params:        params,
+		logger:        logger,
 	}
 }
 
-func (s *storer) AddEntity(userID, entityID string) error {
+func (s *storer) AddEntity(
+	ctx context.Context, userID, entityID string, role api.Role, limits *storage.Limits,
+) error {
 	if userID == "" {
 		return api.ErrEmptyUserID
 	}
@@ -33,67 +59,475 @@ func (s *storer) AddEntity(userID, entityID string) error {
 		return api.ErrEmptyEntityID
 	}
 
-	// check user-entity association doesn't already exist
-	pred := func(ue *datastore.UserEntity) bool {
-		return ue.EntityID == entityID && ue.UserID == userID
+	// hold the lock through the checks and the insert so concurrent adds can't exceed limits
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current(userID, entityID) != nil {
+		return storage.ErrUserEntityExists
 	}
-	if n := s.count(pred); n > 0 {
-		return datastore.ErrUserEntityExists
+	maxUsers := limits.MaxEntityUsers
+	if override, in := s.entityLimits[entityID]; in {
+		maxUsers = override
+	}
+	if len(s.entityUsers[entityID])+1 > maxUsers {
+		return storage.ErrTooManyEntityUsers
+	}
+	maxEntities := limits.MaxUserEntities
+	if override, in := s.userLimits[userID]; in {
+		maxEntities = override
+	}
+	if len(s.userEntities[userID])+1 > maxEntities {
+		return storage.ErrTooManyUserEntities
 	}
 
+	ue := storage.NewAssociationVersion(userID, entityID, role)
+	s.insert(ue)
+	s.feed.Publish(ue.LastEvent())
+	s.logger.Debug("storer added entity to user", logUserEntityFields(userID, entityID)...)
+	return nil
+}
+
+func (s *storer) AddEntities(
+	ctx context.Context, as []*storage.Association, limits *storage.Limits,
+) ([]error, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.userEntities = append(s.userEntities, datastore.NewUserEntity(userID, entityID))
-	s.logger.Debug("storer added entity to user", logAddEntityFields(userID, entityID)...)
+	counts := storage.NewAssociationCounts()
+	counts.MaxEntityUsers, counts.MaxUserEntities = s.entityLimits, s.userLimits
+	for _, a := range as {
+		if s.current(a.UserID, a.EntityID) != nil {
+			counts.Existing[a.ID()] = struct{}{}
+		}
+		counts.EntityUsers[a.EntityID] = len(s.entityUsers[a.EntityID])
+		counts.UserEntities[a.UserID] = len(s.userEntities[a.UserID])
+	}
+	errs := counts.CheckAdds(as, limits)
+	events := make([]*storage.AssociationEvent, 0, len(as))
+	for i, a := range as {
+		if errs[i] == nil {
+			ue := storage.NewAssociationVersion(a.UserID, a.EntityID, a.Role)
+			s.insert(ue)
+			events = append(events, ue.LastEvent())
+		}
+	}
+	s.feed.Publish(events...)
+	s.logger.Debug("storer added entities to users", logAddEntities(as, errs)...)
+	return errs, nil
+}
+
+func (s *storer) RemoveEntity(ctx context.Context, userID, entityID string) error {
+	if userID == "" {
+		return api.ErrEmptyUserID
+	}
+	if entityID == "" {
+		return api.ErrEmptyEntityID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ue := s.current(userID, entityID)
+	if ue == nil {
+		return storage.ErrUserEntityNotExists
+	}
+	ue.MarkRemoved()
+	s.unindex(ue)
+	s.feed.Publish(ue.LastEvent())
+	s.logger.Debug("storer removed entity from user", logUserEntityFields(userID, entityID)...)
 	return nil
 }
 
-func (s *storer) GetEntities(userID string) ([]string, error) {
+func (s *storer) UpdateRole(ctx context.Context, userID, entityID string, role api.Role) error {
 	if userID == "" {
-		return nil, api.ErrEmptyUserID
+		return api.ErrEmptyUserID
+	}
+	if entityID == "" {
+		return api.ErrEmptyEntityID
 	}
-	entityIDs := make([]string, 0)
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	for _, ue := range s.userEntities {
-		if ue.UserID == userID {
-			entityIDs = append(entityIDs, ue.EntityID)
+	ue := s.current(userID, entityID)
+	if ue == nil {
+		return storage.ErrUserEntityNotExists
+	}
+	if ue.Role != role {
+		updated := ue.UpdateRole(role)
+		s.unindex(ue)
+		s.insert(updated)
+		s.feed.Publish(updated.LastEvent())
+	}
+	s.logger.Debug("storer updated role", logUpdateRole(userID, entityID, role)...)
+	return nil
+}
+
+func (s *storer) GetEntities(
+	ctx context.Context, userID string, page *storage.Page,
+) ([]*storage.Association, string, error) {
+	if userID == "" {
+		return nil, "", api.ErrEmptyUserID
+	}
+	after, err := page.ParseKeysetToken()
+	if err != nil {
+		return nil, "", err
+	}
+	s.mu.RLock()
+	as := make([]*storage.Association, 0, len(s.userEntities[userID]))
+	for entityID, ue := range s.userEntities[userID] {
+		if entityID > after {
+			as = append(as, ue.Association())
+		}
+	}
+	s.mu.RUnlock()
+	as, next := pageEntities(as, page)
+	s.logger.Debug("storer got entities for user", logGetEntities(userID, as)...)
+	return as, next, nil
+}
+
+func (s *storer) GetEntitiesAsOf(
+	ctx context.Context, userID string, asOf time.Time, page *storage.Page,
+) ([]*storage.Association, string, error) {
+	if userID == "" {
+		return nil, "", api.ErrEmptyUserID
+	}
+	after, err := page.ParseKeysetToken()
+	if err != nil {
+		return nil, "", err
+	}
+	as := make([]*storage.Association, 0)
+	s.mu.RLock()
+	for _, ue := range s.userHistory[userID] {
+		if ue.EntityID > after && ue.ActiveAt(asOf) {
+			as = append(as, ue.Association())
 		}
 	}
-	s.logger.Debug("storer got entities for user", logGetEntities(userID, entityIDs)...)
+	s.mu.RUnlock()
+	as, next := pageEntities(as, page)
+	s.logger.Debug("storer got entities for user as of time",
+		logGetEntitiesAsOf(userID, asOf, as)...)
+	return as, next, nil
+}
+
+// pageEntities sorts the associations by entity ID and returns the page of them along with the
+// next page token.
+func pageEntities(
+	as []*storage.Association, page *storage.Page,
+) ([]*storage.Association, string) {
+	sort.Slice(as, func(i, j int) bool { return as[i].EntityID < as[j].EntityID })
+	if !page.Limited() || len(as) <= page.Size {
+		return as, ""
+	}
+	as = as[:page.Size]
+	return as, storage.NewKeysetToken(as[page.Size-1].EntityID)
+}
+
+func (s *storer) GetEntitiesBatch(
+	ctx context.Context, userIDs []string,
+) (map[string][]string, error) {
+	for _, userID := range userIDs {
+		if userID == "" {
+			return nil, api.ErrEmptyUserID
+		}
+	}
+	entityIDs := make(map[string][]string, len(userIDs))
+	s.mu.RLock()
+	for _, userID := range userIDs {
+		entityIDs[userID] = sortedKeys(s.userEntities[userID])
+	}
+	s.mu.RUnlock()
+	s.logger.Debug("storer got entities for users", logGetEntitiesBatch(entityIDs)...)
 	return entityIDs, nil
 }
 
-func (s *storer) CountEntities(userID string) (int, error) {
+func (s *storer) GetUsers(
+	ctx context.Context, entityID string, page *storage.Page,
+) ([]string, string, error) {
+	if entityID == "" {
+		return nil, "", api.ErrEmptyEntityID
+	}
+	after, err := page.ParseKeysetToken()
+	if err != nil {
+		return nil, "", err
+	}
+	s.mu.RLock()
+	userIDs := make([]string, 0, len(s.entityUsers[entityID]))
+	for _, userID := range sortedKeys(s.entityUsers[entityID]) {
+		if userID > after {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	s.mu.RUnlock()
+	next := ""
+	if page.Limited() && len(userIDs) > page.Size {
+		userIDs = userIDs[:page.Size]
+		next = storage.NewKeysetToken(userIDs[page.Size-1])
+	}
+	s.logger.Debug("storer got users for entity", logGetUsers(entityID, userIDs)...)
+	return userIDs, next, nil
+}
+
+func (s *storer) CountEntities(ctx context.Context, userID string) (int, error) {
 	if userID == "" {
 		return 0, api.ErrEmptyUserID
 	}
-	n := s.count(func(ue *datastore.UserEntity) bool { return ue.UserID == userID })
+	s.mu.RLock()
+	n := len(s.userEntities[userID])
+	s.mu.RUnlock()
 	s.logger.Debug("storer counted entities for user", logCountEntities(userID, n)...)
 	return n, nil
 }
 
-func (s *storer) CountUsers(entityID string) (int, error) {
+func (s *storer) CountUsers(ctx context.Context, entityID string) (int, error) {
 	if entityID == "" {
 		return 0, api.ErrEmptyEntityID
 	}
-	n := s.count(func(ue *datastore.UserEntity) bool { return ue.EntityID == entityID })
+	s.mu.RLock()
+	n := len(s.entityUsers[entityID])
+	s.mu.RUnlock()
 	s.logger.Debug("storer counted users for entity", logCountUsers(entityID, n)...)
 	return n, nil
 }
 
-func (s *storer) Close() error {
+func (s *storer) GetHistory(
+	ctx context.Context, userID, entityID string,
+) ([]*storage.AssociationEvent, error) {
+	if userID == "" && entityID == "" {
+		return nil, api.ErrEmptyUserAndEntityID
+	}
+	events := make([]*storage.AssociationEvent, 0)
+	s.mu.RLock()
+	versions := s.entityHistory[entityID]
+	if userID != "" {
+		versions = s.userHistory[userID]
+	}
+	for _, ue := range versions {
+		if entityID == "" || ue.EntityID == entityID {
+			events = append(events, ue.Events()...)
+		}
+	}
+	s.mu.RUnlock()
+	storage.SortEvents(events)
+	s.logger.Debug("storer got association history", logGetHistory(userID, entityID, events)...)
+	return events, nil
+}
+
+func (s *storer) SetUserLimit(ctx context.Context, userID string, maxEntities int) error {
+	if userID == "" {
+		return api.ErrEmptyUserID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	setLimit(s.userLimits, userID, maxEntities)
+	s.logger.Debug("storer set user limit", logSetUserLimit(userID, maxEntities)...)
+	return nil
+}
+
+func (s *storer) SetEntityLimit(ctx context.Context, entityID string, maxUsers int) error {
+	if entityID == "" {
+		return api.ErrEmptyEntityID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	setLimit(s.entityLimits, entityID, maxUsers)
+	s.logger.Debug("storer set entity limit", logSetEntityLimit(entityID, maxUsers)...)
+	return nil
+}
+
+func (s *storer) PutUser(ctx context.Context, u *api.UserProfile) error {
+	if u.UserId == "" {
+		return api.ErrEmptyUserID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.UserId] = proto.Clone(u).(*api.UserProfile)
+	s.logger.Debug("storer put user", logUser(u)...)
+	return nil
+}
+
+func (s *storer) GetUser(ctx context.Context, userID string) (*api.UserProfile, error) {
+	if userID == "" {
+		return nil, api.ErrEmptyUserID
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, in := s.users[userID]
+	if !in {
+		return nil, storage.ErrUserNotExists
+	}
+	s.logger.Debug("storer got user", logUser(u)...)
+	return proto.Clone(u).(*api.UserProfile), nil
+}
+
+func (s *storer) UpdateUser(
+	ctx context.Context, u *api.UserProfile,
+) (*api.UserProfile, error) {
+	if u.UserId == "" {
+		return nil, api.ErrEmptyUserID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, in := s.users[u.UserId]
+	if !in {
+		return nil, storage.ErrUserNotExists
+	}
+	storage.MergeUser(existing, u)
+	s.logger.Debug("storer updated user", logUser(existing)...)
+	return proto.Clone(existing).(*api.UserProfile), nil
+}
+
+func (s *storer) Watch(
+	ctx context.Context,
+	userID, entityID string,
+	fromRevision uint64,
+	send func(*storage.AssociationEvent) error,
+) error {
+	if userID == "" && entityID == "" {
+		return api.ErrEmptyUserAndEntityID
+	}
+	s.logger.Debug("storer watching associations",
+		logWatch(userID, entityID, fromRevision)...)
+	return s.feed.Watch(ctx, userID, entityID, fromRevision, send)
+}
+
+func (s *storer) ExportAssociations(
+	ctx context.Context, send func([]*storage.AssociationVersion) error,
+) error {
+	// copy the versions so they can be sent without holding the lock
+	s.mu.RLock()
+	versions := make([]*storage.AssociationVersion, 0)
+	for _, uvs := range s.userHistory {
+		for _, ue := range uvs {
+			copied := *ue
+			versions = append(versions, &copied)
+		}
+	}
+	s.mu.RUnlock()
+	storage.SortVersions(versions)
+	groups := storage.GroupVersions(versions)
+	for _, group := range groups {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := send(group); err != nil {
+			return err
+		}
+	}
+	s.logger.Debug("storer exported associations", logExportAssociations(len(groups))...)
 	return nil
 }
 
-func (s *storer) count(predicate func(ue *datastore.UserEntity) bool) int {
+func (s *storer) ImportAssociation(
+	ctx context.Context, versions []*storage.AssociationVersion, overwrite bool,
+) (bool, error) {
+	if len(versions) == 0 {
+		return false, nil
+	}
+	userID, entityID := versions[0].UserID, versions[0].EntityID
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	n := 0
-	for _, ue := range s.userEntities {
-		if predicate(ue) {
-			n++
+	exists := false
+	for _, ue := range s.userHistory[userID] {
+		if ue.EntityID == entityID {
+			exists = true
+			break
+		}
+	}
+	if exists && !overwrite {
+		return false, storage.ErrUserEntityExists
+	}
+	if exists {
+		s.userHistory[userID] = withoutVersions(s.userHistory[userID], userID, entityID)
+		s.entityHistory[entityID] = withoutVersions(s.entityHistory[entityID], userID,
+			entityID)
+		unindex(s.userEntities, userID, entityID)
+		unindex(s.entityUsers, entityID, userID)
+	}
+	for _, ue := range versions {
+		copied := *ue
+		s.insert(&copied)
+	}
+	s.feed.Publish(storage.ImportedEvent(versions))
+	s.logger.Debug("storer imported association",
+		logImportAssociation(userID, entityID, len(versions), exists)...)
+	return exists, nil
+}
+
+func (s *storer) Close() error {
+	return nil
+}
+
+// current returns the user's current association with the entity, or nil if there isn't one. The
+// caller must hold s.mu.
+func (s *storer) current(userID, entityID string) *storage.AssociationVersion {
+	return s.userEntities[userID][entityID]
+}
+
+// insert adds the association version to the history and, unless it's removed, to the current
+// associations. The caller must hold s.mu for writing.
+func (s *storer) insert(ue *storage.AssociationVersion) {
+	s.userHistory[ue.UserID] = append(s.userHistory[ue.UserID], ue)
+	s.entityHistory[ue.EntityID] = append(s.entityHistory[ue.EntityID], ue)
+	if ue.Removed {
+		return
+	}
+	index(s.userEntities, ue.UserID, ue.EntityID, ue)
+	index(s.entityUsers, ue.EntityID, ue.UserID, ue)
+}
+
+// unindex removes the association from the current associations. The caller must hold s.mu for
+// writing.
+func (s *storer) unindex(ue *storage.AssociationVersion) {
+	unindex(s.userEntities, ue.UserID, ue.EntityID)
+	unindex(s.entityUsers, ue.EntityID, ue.UserID)
+}
+
+// versionIndex maps the first ID and then the second ID of each current association, i.e., the
+// user and entity IDs or vice versa, to its version.
+type versionIndex map[string]map[string]*storage.AssociationVersion
+
+func index(idx versionIndex, id1, id2 string, ue *storage.AssociationVersion) {
+	inner, in := idx[id1]
+	if !in {
+		inner = make(map[string]*storage.AssociationVersion)
+		idx[id1] = inner
+	}
+	inner[id2] = ue
+}
+
+// unindex deletes the association from the index, along with its inner map once it's empty so
+// users and entities without associations don't take up space.
+func unindex(idx versionIndex, id1, id2 string) {
+	inner := idx[id1]
+	delete(inner, id2)
+	if len(inner) == 0 {
+		delete(idx, id1)
+	}
+}
+
+// sortedKeys returns the IDs the inner index is keyed by in ascending order.
+func sortedKeys(inner map[string]*storage.AssociationVersion) []string {
+	ids := make([]string, 0, len(inner))
+	for id := range inner {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// withoutVersions returns the versions other than those of the given association.
+func withoutVersions(
+	versions []*storage.AssociationVersion, userID, entityID string,
+) []*storage.AssociationVersion {
+	kept := make([]*storage.AssociationVersion, 0, len(versions))
+	for _, ue := range versions {
+		if ue.UserID != userID || ue.EntityID != entityID {
+			kept = append(kept, ue)
 		}
 	}
-	return n
+	return kept
+}
+
+func setLimit(limits map[string]int, id string, max int) {
+	if max == 0 {
+		delete(limits, id)
+		return
+	}
+	limits[id] = max
 }
@@ -1,28 +1,39 @@
 package memory
 
 import (
+	"context"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/elixirhealth/user/pkg/server/storage"
-	"github.com/elixirhealth/user/pkg/server/storage/datastore"
+	"github.com/elixirhealth/user/pkg/server/storage/storagetest"
 	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 )
 
+var testLimits = &storage.Limits{MaxUserEntities: 2, MaxEntityUsers: 2}
+
+func TestMemoryStorer_Conformance(t *testing.T) {
+	storagetest.Run(t, func() storage.Storer {
+		return New(storage.NewDefaultParameters(), zap.NewNop())
+	})
+}
+
 func TestMemoryStorer_AddEntity_ok(t *testing.T) {
 	s := New(storage.NewDefaultParameters(), zap.NewNop())
 
 	userID, entityID := "some user", "some entity"
-	err := s.AddEntity(userID, entityID)
+	err := s.AddEntity(context.Background(), userID, entityID, api.Role_OWNER, testLimits)
 	assert.Nil(t, err)
 
-	putValue := s.(*storer).userEntities[0]
+	putValue := s.(*storer).userEntities[userID][entityID]
 	assert.Equal(t, userID, putValue.UserID)
 	assert.Equal(t, entityID, putValue.EntityID)
 	assert.False(t, putValue.Removed)
-	assert.NotZero(t, putValue.ModifiedDate)
-	assert.NotZero(t, putValue.ModifiedTime)
 	assert.NotZero(t, putValue.AddedTime)
 	assert.Zero(t, putValue.RemovedTime)
 }
@@ -50,111 +61,640 @@ func TestMemoryStorer_AddEntity_err(t *testing.T) {
 			expected: api.ErrEmptyEntityID,
 		},
 		"non-zero user entities count": {
-			s: &storer{
-				params: params,
-				logger: lg,
-				userEntities: []*datastore.UserEntity{
-					{UserID: userID, EntityID: entityID},
-				},
-			},
+			s: newTestStorer(
+				&storage.AssociationVersion{UserID: userID, EntityID: entityID},
+			),
+			userID:   userID,
+			entityID: entityID,
+			expected: storage.ErrUserEntityExists,
+		},
+		"too many entity users": {
+			s: newTestStorer(
+				&storage.AssociationVersion{UserID: "user 1", EntityID: entityID},
+				&storage.AssociationVersion{UserID: "user 2", EntityID: entityID},
+			),
+			userID:   userID,
+			entityID: entityID,
+			expected: storage.ErrTooManyEntityUsers,
+		},
+		"too many user entities": {
+			s: newTestStorer(
+				&storage.AssociationVersion{UserID: userID, EntityID: "entity 1"},
+				&storage.AssociationVersion{UserID: userID, EntityID: "entity 2"},
+				&storage.AssociationVersion{UserID: userID, EntityID: "entity 3", Removed: true},
+			),
 			userID:   userID,
 			entityID: entityID,
-			expected: datastore.ErrUserEntityExists,
+			expected: storage.ErrTooManyUserEntities,
 		},
 	}
 	for desc, c := range cases {
-		err := c.s.AddEntity(c.userID, c.entityID)
+		err := c.s.AddEntity(context.Background(), c.userID, c.entityID, api.Role_OWNER, testLimits)
 		assert.Equal(t, c.expected, err, desc)
 	}
 }
 
-func TestMemoryStorer_GetEntities_ok(t *testing.T) {
+func TestMemoryStorer_SetLimit(t *testing.T) {
+	s := New(storage.NewDefaultParameters(), zap.NewNop())
+	userID, entityID := "some user", "some entity"
+	limits := &storage.Limits{MaxUserEntities: 1, MaxEntityUsers: 1}
+
+	err := s.SetEntityLimit(context.Background(), entityID, 2)
+	assert.Nil(t, err)
+	err = s.AddEntity(context.Background(), "user 1", entityID, api.Role_OWNER, limits)
+	assert.Nil(t, err)
+	err = s.AddEntity(context.Background(), "user 2", entityID, api.Role_OWNER, limits)
+	assert.Nil(t, err)
+	err = s.AddEntity(context.Background(), userID, entityID, api.Role_OWNER, limits)
+	assert.Equal(t, storage.ErrTooManyEntityUsers, err)
+
+	err = s.SetUserLimit(context.Background(), userID, 2)
+	assert.Nil(t, err)
+	err = s.AddEntity(context.Background(), userID, "entity 1", api.Role_OWNER, limits)
+	assert.Nil(t, err)
+	err = s.AddEntity(context.Background(), userID, "entity 2", api.Role_OWNER, limits)
+	assert.Nil(t, err)
+	err = s.AddEntity(context.Background(), userID, "entity 3", api.Role_OWNER, limits)
+	assert.Equal(t, storage.ErrTooManyUserEntities, err)
+
+	// zero removes the override, so the default applies again
+	err = s.SetUserLimit(context.Background(), userID, 0)
+	assert.Nil(t, err)
+	_, in := s.(*storer).userLimits[userID]
+	assert.False(t, in)
+
+	err = s.SetUserLimit(context.Background(), "", 2)
+	assert.Equal(t, api.ErrEmptyUserID, err)
+	err = s.SetEntityLimit(context.Background(), "", 2)
+	assert.Equal(t, api.ErrEmptyEntityID, err)
+}
+
+func TestMemoryStorer_AddEntities(t *testing.T) {
+	s := New(storage.NewDefaultParameters(), zap.NewNop())
+	ctx := context.Background()
+	userID, entityID := "some user", "some entity"
+	err := s.AddEntity(ctx, userID, entityID, api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+
+	as := []*storage.Association{
+		{UserID: userID, EntityID: entityID},
+		{UserID: "", EntityID: entityID},
+		{UserID: "user 2", EntityID: entityID},
+		{UserID: "user 3", EntityID: entityID},
+		{UserID: userID, EntityID: "entity 2"},
+	}
+	errs, err := s.AddEntities(ctx, as, testLimits)
+	assert.Nil(t, err)
+	assert.Equal(t, []error{
+		storage.ErrUserEntityExists,
+		api.ErrEmptyUserID,
+		nil,
+		storage.ErrTooManyEntityUsers,
+		nil,
+	}, errs)
+
+	entityIDs, err := s.GetEntitiesBatch(ctx, []string{userID, "user 2", "user 3"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		userID:   {"entity 2", entityID},
+		"user 2": {entityID},
+		"user 3": {},
+	}, entityIDs)
+
+	entityIDs, err = s.GetEntitiesBatch(ctx, []string{userID, ""})
+	assert.Equal(t, api.ErrEmptyUserID, err)
+	assert.Nil(t, entityIDs)
+}
+
+func TestMemoryStorer_RemoveEntity_ok(t *testing.T) {
+	s := New(storage.NewDefaultParameters(), zap.NewNop())
+
+	userID, entityID1, entityID2 := "some user", "some entity", "another entity"
+	err := s.AddEntity(context.Background(), userID, entityID1, api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+	err = s.AddEntity(context.Background(), userID, entityID2, api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+
+	err = s.RemoveEntity(context.Background(), userID, entityID1)
+	assert.Nil(t, err)
+
+	removedValue := s.(*storer).userHistory[userID][0]
+	assert.True(t, removedValue.Removed)
+	assert.NotZero(t, removedValue.RemovedTime)
+
+	as, _, err := s.GetEntities(context.Background(), userID, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID2}, storage.EntityIDs(as))
+
+	n, err := s.CountUsers(context.Background(), entityID1)
+	assert.Nil(t, err)
+	assert.Zero(t, n)
+
+	// removed association can be added again
+	err = s.AddEntity(context.Background(), userID, entityID1, api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+}
+
+func TestMemoryStorer_RemoveEntity_err(t *testing.T) {
 	params := storage.NewDefaultParameters()
 	lg := zap.NewNop()
+	userID, entityID := "some user", "some entity"
+	cases := map[string]struct {
+		s        storage.Storer
+		userID   string
+		entityID string
+		expected error
+	}{
+		"empty user ID": {
+			s:        New(params, lg),
+			userID:   "",
+			entityID: entityID,
+			expected: api.ErrEmptyUserID,
+		},
+		"empty entity ID": {
+			s:        New(params, lg),
+			userID:   userID,
+			entityID: "",
+			expected: api.ErrEmptyEntityID,
+		},
+		"not exists": {
+			s:        New(params, lg),
+			userID:   userID,
+			entityID: entityID,
+			expected: storage.ErrUserEntityNotExists,
+		},
+		"already removed": {
+			s: newTestStorer(
+				&storage.AssociationVersion{UserID: userID, EntityID: entityID, Removed: true},
+			),
+			userID:   userID,
+			entityID: entityID,
+			expected: storage.ErrUserEntityNotExists,
+		},
+	}
+	for desc, c := range cases {
+		err := c.s.RemoveEntity(context.Background(), c.userID, c.entityID)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestMemoryStorer_UpdateRole(t *testing.T) {
+	s := New(storage.NewDefaultParameters(), zap.NewNop())
+	userID, entityID := "some user", "some entity"
+
+	err := s.UpdateRole(context.Background(), "", entityID, api.Role_ADMIN)
+	assert.Equal(t, api.ErrEmptyUserID, err)
+	err = s.UpdateRole(context.Background(), userID, "", api.Role_ADMIN)
+	assert.Equal(t, api.ErrEmptyEntityID, err)
+	err = s.UpdateRole(context.Background(), userID, entityID, api.Role_ADMIN)
+	assert.Equal(t, storage.ErrUserEntityNotExists, err)
+
+	err = s.AddEntity(context.Background(), userID, entityID, api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+	err = s.UpdateRole(context.Background(), userID, entityID, api.Role_ADMIN)
+	assert.Nil(t, err)
+	err = s.UpdateRole(context.Background(), userID, entityID, api.Role_ADMIN) // no-op
+	assert.Nil(t, err)
+
+	as, _, err := s.GetEntities(context.Background(), userID, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Association{
+		{UserID: userID, EntityID: entityID, Role: api.Role_ADMIN},
+	}, as)
+
+	n, err := s.CountEntities(context.Background(), userID)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	events, err := s.GetHistory(context.Background(), userID, entityID)
+	assert.Nil(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, api.EventType_ADDED, events[0].Type)
+	assert.Equal(t, api.EventType_ROLE_UPDATED, events[1].Type)
+}
 
+func TestMemoryStorer_GetEntities_ok(t *testing.T) {
 	userID := "some user ID"
 	entityID1, entityID2 := "some entity ID", "another entity ID"
-	s := &storer{
-		params: params,
-		logger: lg,
-		userEntities: []*datastore.UserEntity{
-			{UserID: userID, EntityID: entityID1},
-			{UserID: userID, EntityID: entityID2},
-		},
+	s := newTestStorer(
+		&storage.AssociationVersion{UserID: userID, EntityID: entityID1, Role: api.Role_OWNER},
+		&storage.AssociationVersion{UserID: userID, EntityID: entityID2, Role: api.Role_ADMIN},
+	)
+
+	// ordered by entity ID
+	as, next, err := s.GetEntities(context.Background(), userID, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Association{
+		{UserID: userID, EntityID: entityID2, Role: api.Role_ADMIN},
+		{UserID: userID, EntityID: entityID1, Role: api.Role_OWNER},
+	}, as)
+	assert.Empty(t, next)
+}
+
+func TestMemoryStorer_GetEntities_paging(t *testing.T) {
+	s := New(storage.NewDefaultParameters(), zap.NewNop())
+	userID := "some user ID"
+	entityIDs := []string{"entity ID 3", "entity ID 1", "entity ID 5", "entity ID 2", "entity ID 4"}
+	limits := &storage.Limits{MaxUserEntities: len(entityIDs), MaxEntityUsers: 1}
+	for _, entityID := range entityIDs {
+		err := s.AddEntity(context.Background(), userID, entityID, api.Role_OWNER, limits)
+		assert.Nil(t, err)
+	}
+
+	page := &storage.Page{Size: 2}
+	var pages [][]string
+	for {
+		as, next, err := s.GetEntities(context.Background(), userID, page)
+		assert.Nil(t, err)
+		pages = append(pages, storage.EntityIDs(as))
+		if next == "" {
+			break
+		}
+		page.Token = next
 	}
+	assert.Equal(t, [][]string{
+		{"entity ID 1", "entity ID 2"},
+		{"entity ID 3", "entity ID 4"},
+		{"entity ID 5"},
+	}, pages)
 
-	entityIDs, err := s.GetEntities(userID)
+	// a page of exactly the remaining associations has no next page
+	page = &storage.Page{Size: 1, Token: storage.NewKeysetToken("entity ID 4")}
+	as, next, err := s.GetEntities(context.Background(), userID, page)
 	assert.Nil(t, err)
-	assert.Equal(t, []string{entityID1, entityID2}, entityIDs)
+	assert.Equal(t, []string{"entity ID 5"}, storage.EntityIDs(as))
+	assert.Empty(t, next)
 }
 
 func TestMemoryStorer_GetEntities_err(t *testing.T) {
 	s := New(storage.NewDefaultParameters(), zap.NewNop())
-	entityIDs, err := s.GetEntities("")
+	entityIDs, _, err := s.GetEntities(context.Background(), "", nil)
 	assert.Equal(t, api.ErrEmptyUserID, err)
 	assert.Nil(t, entityIDs)
+
+	page := &storage.Page{Size: 1, Token: "not a page token!"}
+	entityIDs, _, err = s.GetEntities(context.Background(), "some user ID", page)
+	assert.Equal(t, storage.ErrInvalidPageToken, err)
+	assert.Nil(t, entityIDs)
 }
 
-func TestMemoryStorer_CountEntities_ok(t *testing.T) {
-	params := storage.NewDefaultParameters()
-	lg := zap.NewNop()
+func TestMemoryStorer_GetEntitiesAsOf_ok(t *testing.T) {
+	userID := "some user ID"
+	entityID1, entityID2, entityID3 := "entity ID 1", "entity ID 2", "entity ID 3"
+	t0 := time.Now()
+	t1, t2, t3 := t0.Add(1*time.Second), t0.Add(2*time.Second), t0.Add(3*time.Second)
+	s := newTestStorer(
+		&storage.AssociationVersion{UserID: userID, EntityID: entityID1, AddedTime: t0},
+		&storage.AssociationVersion{UserID: userID, EntityID: entityID2, AddedTime: t0,
+			Removed: true, RemovedTime: t2},
+		&storage.AssociationVersion{UserID: userID, EntityID: entityID3, AddedTime: t2},
+	)
+
+	as, _, err := s.GetEntitiesAsOf(context.Background(), userID, t1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID1, entityID2}, storage.EntityIDs(as))
+
+	as, _, err = s.GetEntitiesAsOf(context.Background(), userID, t3, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID1, entityID3}, storage.EntityIDs(as))
+
+	as, _, err = s.GetEntitiesAsOf(context.Background(), userID, t0.Add(-1*time.Second), nil)
+	assert.Nil(t, err)
+	assert.Empty(t, as)
+}
+
+func TestMemoryStorer_GetEntitiesAsOf_err(t *testing.T) {
+	s := New(storage.NewDefaultParameters(), zap.NewNop())
+	entityIDs, _, err := s.GetEntitiesAsOf(context.Background(), "", time.Now(), nil)
+	assert.Equal(t, api.ErrEmptyUserID, err)
+	assert.Nil(t, entityIDs)
+}
+
+func TestMemoryStorer_GetUsers_ok(t *testing.T) {
+	userID1, userID2, userID3 := "some user ID", "another user ID", "a third user ID"
+	entityID1, entityID2 := "some entity ID", "another entity ID"
+	s := newTestStorer(
+		&storage.AssociationVersion{UserID: userID1, EntityID: entityID1},
+		&storage.AssociationVersion{UserID: userID2, EntityID: entityID1},
+		&storage.AssociationVersion{UserID: userID1, EntityID: entityID2},
+		&storage.AssociationVersion{UserID: userID3, EntityID: entityID1, Removed: true},
+	)
+
+	userIDs, _, err := s.GetUsers(context.Background(), entityID1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{userID2, userID1}, userIDs) // ordered by user ID
+}
+
+func TestMemoryStorer_GetUsers_err(t *testing.T) {
+	s := New(storage.NewDefaultParameters(), zap.NewNop())
+	userIDs, _, err := s.GetUsers(context.Background(), "", nil)
+	assert.Equal(t, api.ErrEmptyEntityID, err)
+	assert.Nil(t, userIDs)
+}
 
+func TestMemoryStorer_CountEntities_ok(t *testing.T) {
 	userID1, userID2 := "some user ID", "another user ID"
 	entityID1, entityID2 := "some entity ID", "another entity ID"
-	s := &storer{
-		params: params,
-		logger: lg,
-		userEntities: []*datastore.UserEntity{
-			{UserID: userID1, EntityID: entityID1},
-			{UserID: userID1, EntityID: entityID2},
-			{UserID: userID2, EntityID: entityID1},
-		},
-	}
+	s := newTestStorer(
+		&storage.AssociationVersion{UserID: userID1, EntityID: entityID1},
+		&storage.AssociationVersion{UserID: userID1, EntityID: entityID2},
+		&storage.AssociationVersion{UserID: userID2, EntityID: entityID1},
+	)
 
-	n, err := s.CountEntities(userID1)
+	n, err := s.CountEntities(context.Background(), userID1)
 	assert.Nil(t, err)
 	assert.Equal(t, 2, n)
 
-	n, err = s.CountEntities(userID2)
+	n, err = s.CountEntities(context.Background(), userID2)
 	assert.Nil(t, err)
 	assert.Equal(t, 1, n)
 }
 
 func TestMemoryStorer_CountEntities_err(t *testing.T) {
 	s := New(storage.NewDefaultParameters(), zap.NewNop())
-	n, err := s.CountEntities("")
+	n, err := s.CountEntities(context.Background(), "")
 	assert.Equal(t, api.ErrEmptyUserID, err)
 	assert.Zero(t, n)
 }
 
 func TestMemoryStorer_CountUsers_ok(t *testing.T) {
-	params := storage.NewDefaultParameters()
-	lg := zap.NewNop()
-
 	userID1, userID2 := "some user ID", "another user ID"
 	entityID1, entityID2 := "some entity ID", "another entity ID"
-	s := &storer{
-		params: params,
-		logger: lg,
-		userEntities: []*datastore.UserEntity{
-			{UserID: userID1, EntityID: entityID1},
-			{UserID: userID1, EntityID: entityID2},
-			{UserID: userID2, EntityID: entityID1},
-		},
-	}
+	s := newTestStorer(
+		&storage.AssociationVersion{UserID: userID1, EntityID: entityID1},
+		&storage.AssociationVersion{UserID: userID1, EntityID: entityID2},
+		&storage.AssociationVersion{UserID: userID2, EntityID: entityID1},
+	)
 
-	n, err := s.CountUsers(entityID1)
+	n, err := s.CountUsers(context.Background(), entityID1)
 	assert.Nil(t, err)
 	assert.Equal(t, 2, n)
 
-	n, err = s.CountUsers(entityID2)
+	n, err = s.CountUsers(context.Background(), entityID2)
 	assert.Nil(t, err)
 	assert.Equal(t, 1, n)
 }
 
 func TestMemoryStorer_CountUsers_err(t *testing.T) {
 	s := New(storage.NewDefaultParameters(), zap.NewNop())
-	n, err := s.CountUsers("")
+	n, err := s.CountUsers(context.Background(), "")
 	assert.Equal(t, api.ErrEmptyEntityID, err)
 	assert.Zero(t, n)
 }
+
+func TestMemoryStorer_GetHistory_ok(t *testing.T) {
+	userID1, userID2 := "some user ID", "another user ID"
+	entityID1, entityID2 := "some entity ID", "another entity ID"
+	t0 := time.Now()
+	t1, t2 := t0.Add(1*time.Second), t0.Add(2*time.Second)
+	s := newTestStorer(
+		&storage.AssociationVersion{UserID: userID1, EntityID: entityID1, AddedTime: t0,
+			Removed: true, RemovedTime: t2},
+		&storage.AssociationVersion{UserID: userID1, EntityID: entityID2, AddedTime: t1},
+		&storage.AssociationVersion{UserID: userID2, EntityID: entityID1, AddedTime: t1},
+	)
+
+	events, err := s.GetHistory(context.Background(), userID1, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.AssociationEvent{
+		{Type: api.EventType_ADDED, Time: t0, UserID: userID1, EntityID: entityID1},
+		{Type: api.EventType_ADDED, Time: t1, UserID: userID1, EntityID: entityID2},
+		{Type: api.EventType_REMOVED, Time: t2, UserID: userID1, EntityID: entityID1},
+	}, events)
+
+	events, err = s.GetHistory(context.Background(), "", entityID1)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.AssociationEvent{
+		{Type: api.EventType_ADDED, Time: t0, UserID: userID1, EntityID: entityID1},
+		{Type: api.EventType_ADDED, Time: t1, UserID: userID2, EntityID: entityID1},
+		{Type: api.EventType_REMOVED, Time: t2, UserID: userID1, EntityID: entityID1},
+	}, events)
+
+	events, err = s.GetHistory(context.Background(), userID2, entityID1)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.AssociationEvent{
+		{Type: api.EventType_ADDED, Time: t1, UserID: userID2, EntityID: entityID1},
+	}, events)
+}
+
+func TestMemoryStorer_GetHistory_err(t *testing.T) {
+	s := New(storage.NewDefaultParameters(), zap.NewNop())
+	events, err := s.GetHistory(context.Background(), "", "")
+	assert.Equal(t, api.ErrEmptyUserAndEntityID, err)
+	assert.Nil(t, events)
+}
+
+func TestMemoryStorer_Watch(t *testing.T) {
+	s := New(storage.NewDefaultParameters(), zap.NewNop())
+	userID, entityID := "some user", "some entity"
+	ctx := context.Background()
+	send := func(e *storage.AssociationEvent) error { return nil }
+	err := s.Watch(ctx, "", "", 0, send)
+	assert.Equal(t, api.ErrEmptyUserAndEntityID, err)
+
+	err = s.AddEntity(ctx, userID, entityID, api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+	err = s.UpdateRole(ctx, userID, entityID, api.Role_ADMIN)
+	assert.Nil(t, err)
+	err = s.RemoveEntity(ctx, userID, entityID)
+	assert.Nil(t, err)
+	err = s.AddEntity(ctx, "another user", entityID, api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+
+	errStop := errors.New("stop watch")
+	events := make([]*storage.AssociationEvent, 0)
+	err = s.Watch(ctx, userID, "", 1, func(e *storage.AssociationEvent) error {
+		events = append(events, e)
+		if len(events) == 2 {
+			return errStop
+		}
+		return nil
+	})
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, api.EventType_ROLE_UPDATED, events[0].Type)
+	assert.Equal(t, api.Role_ADMIN, events[0].Role)
+	assert.Equal(t, uint64(2), events[0].Revision)
+	assert.Equal(t, api.EventType_REMOVED, events[1].Type)
+	assert.Equal(t, uint64(3), events[1].Revision)
+}
+
+func TestMemoryStorer_PutGetUpdateUser(t *testing.T) {
+	s := New(storage.NewDefaultParameters(), zap.NewNop())
+	userID := "some user"
+
+	u, err := s.GetUser(context.Background(), userID)
+	assert.Equal(t, storage.ErrUserNotExists, err)
+	assert.Nil(t, u)
+	u, err = s.UpdateUser(context.Background(), &api.UserProfile{UserId: userID})
+	assert.Equal(t, storage.ErrUserNotExists, err)
+	assert.Nil(t, u)
+
+	put := &api.UserProfile{
+		UserId:      userID,
+		DisplayName: "Some User",
+		Attributes:  map[string]string{"key1": "value1"},
+	}
+	err = s.PutUser(context.Background(), put)
+	assert.Nil(t, err)
+	put.DisplayName = "changed after put"
+
+	u, err = s.GetUser(context.Background(), userID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Some User", u.DisplayName)
+
+	u, err = s.UpdateUser(context.Background(), &api.UserProfile{
+		UserId:     userID,
+		Email:      "some.user@example.com",
+		Attributes: map[string]string{"key1": "", "key2": "value2"},
+	})
+	assert.Nil(t, err)
+	expected := &api.UserProfile{
+		UserId:      userID,
+		DisplayName: "Some User",
+		Email:       "some.user@example.com",
+		Attributes:  map[string]string{"key2": "value2"},
+	}
+	assert.Equal(t, expected, u)
+	u, err = s.GetUser(context.Background(), userID)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, u)
+
+	// put replaces the whole profile
+	err = s.PutUser(context.Background(), &api.UserProfile{UserId: userID, Locale: "en-US"})
+	assert.Nil(t, err)
+	u, err = s.GetUser(context.Background(), userID)
+	assert.Nil(t, err)
+	assert.Equal(t, &api.UserProfile{UserId: userID, Locale: "en-US"}, u)
+}
+
+func TestMemoryStorer_User_err(t *testing.T) {
+	s := New(storage.NewDefaultParameters(), zap.NewNop())
+
+	err := s.PutUser(context.Background(), &api.UserProfile{})
+	assert.Equal(t, api.ErrEmptyUserID, err)
+
+	u, err := s.GetUser(context.Background(), "")
+	assert.Equal(t, api.ErrEmptyUserID, err)
+	assert.Nil(t, u)
+
+	u, err = s.UpdateUser(context.Background(), &api.UserProfile{})
+	assert.Equal(t, api.ErrEmptyUserID, err)
+	assert.Nil(t, u)
+}
+
+const (
+	// benchUsers and benchEntitiesPerUser give the size of the benchmark storer, which has
+	// benchUsers entities with benchEntitiesPerUser users each.
+	benchUsers           = 100000
+	benchEntitiesPerUser = 10
+)
+
+var benchStorer struct {
+	once sync.Once
+	s    storage.Storer
+}
+
+func BenchmarkMemoryStorer_AddEntity(b *testing.B) {
+	s := New(storage.NewDefaultParameters(), zap.NewNop())
+	limits := &storage.Limits{MaxUserEntities: benchEntitiesPerUser, MaxEntityUsers: b.N}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		userID := benchUserID(i / benchEntitiesPerUser)
+		err := s.AddEntity(ctx, userID, benchEntityID(i), api.Role_OWNER, limits)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMemoryStorer_GetEntities(b *testing.B) {
+	s := getBenchStorer(b)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := s.GetEntities(ctx, benchUserID(i%benchUsers), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMemoryStorer_GetEntities_parallel(b *testing.B) {
+	s := getBenchStorer(b)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if _, _, err := s.GetEntities(ctx, benchUserID(i%benchUsers), nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkMemoryStorer_CountUsers(b *testing.B) {
+	s := getBenchStorer(b)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.CountUsers(ctx, benchEntityID(i%benchUsers)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// getBenchStorer returns the storer shared by the read benchmarks, which has a million
+// associations, creating it on first use.
+func getBenchStorer(b *testing.B) storage.Storer {
+	benchStorer.once.Do(func() {
+		s := New(storage.NewDefaultParameters(), zap.NewNop())
+		limits := &storage.Limits{
+			MaxUserEntities: benchEntitiesPerUser,
+			MaxEntityUsers:  benchEntitiesPerUser,
+		}
+		as := make([]*storage.Association, 0, benchEntitiesPerUser)
+		for u := 0; u < benchUsers; u++ {
+			as = as[:0]
+			for e := u; e < u+benchEntitiesPerUser; e++ {
+				as = append(as, &storage.Association{
+					UserID:   benchUserID(u),
+					EntityID: benchEntityID(e % benchUsers),
+					Role:     api.Role_OWNER,
+				})
+			}
+			errs, err := s.AddEntities(context.Background(), as, limits)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, err := range errs {
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		benchStorer.s = s
+	})
+	return benchStorer.s
+}
+
+func benchUserID(i int) string {
+	return "user " + strconv.Itoa(i)
+}
+
+func benchEntityID(i int) string {
+	return "entity " + strconv.Itoa(i)
+}
+
+// newTestStorer creates a storer with the given association versions, which are current unless
+// removed.
+func newTestStorer(ues ...*storage.AssociationVersion) *storer {
+	s := New(storage.NewDefaultParameters(), zap.NewNop()).(*storer)
+	for _, ue := range ues {
+		s.insert(ue)
+	}
+	return s
+}
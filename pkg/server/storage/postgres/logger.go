@@ -3,17 +3,31 @@ package postgres
 import (
 	sq "github.com/Masterminds/squirrel"
 	errors2 "github.com/drausin/libri/libri/common/errors"
+	"github.com/elixirhealth/user/pkg/server/storage"
+	api "github.com/elixirhealth/user/pkg/userapi"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 const (
-	logEntityID  = "entity_id"
-	logUserID    = "user_id"
-	logNEntities = "n_entities"
-	logSQL       = "sql"
-	logArgs      = "args"
-	logCount     = "count"
+	logEntityID     = "entity_id"
+	logUserID       = "user_id"
+	logNEntities    = "n_entities"
+	logNUsers       = "n_users"
+	logSQL          = "sql"
+	logArgs         = "args"
+	logCount        = "count"
+	logNEvents      = "n_events"
+	logMax          = "max"
+	logRole         = "role"
+	logNAttributes  = "n_attributes"
+	logFromRevision = "from_revision"
+
+	logNAssociations = "n_associations"
+	logNAdded        = "n_added"
+	logNFailed       = "n_failed"
+	logNVersions     = "n_versions"
+	logOverwritten   = "overwritten"
 )
 
 func logUserEntityID(userID, entityID string) []zapcore.Field {
@@ -33,10 +47,56 @@ func logGettingEntities(q sq.SelectBuilder, userID string) []zapcore.Field {
 	}
 }
 
-func logGotEntities(userID string, entityIDs []string) []zapcore.Field {
+func logGotEntities(userID string, as []*storage.Association) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logUserID, userID),
+		zap.Int(logNEntities, len(as)),
+	}
+}
+
+func logUpdateRole(userID, entityID string, role api.Role) []zapcore.Field {
+	return append(logUserEntityID(userID, entityID), zap.Stringer(logRole, role))
+}
+
+func logWatch(userID, entityID string, fromRevision uint64) []zapcore.Field {
+	return append(logUserEntityID(userID, entityID), zap.Uint64(logFromRevision, fromRevision))
+}
+
+func logGettingUsers(q sq.SelectBuilder, entityID string) []zapcore.Field {
+	qSQL, args, err := q.ToSql()
+	errors2.MaybePanic(err)
+	return []zapcore.Field{
+		zap.String(logEntityID, entityID),
+		zap.String(logSQL, qSQL),
+		zap.Array(logArgs, queryArgs(args)),
+	}
+}
+
+func logGotUsers(entityID string, userIDs []string) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logEntityID, entityID),
+		zap.Int(logNUsers, len(userIDs)),
+	}
+}
+
+func logGettingHistory(q sq.SelectBuilder, userID, entityID string) []zapcore.Field {
+	qSQL, args, err := q.ToSql()
+	errors2.MaybePanic(err)
+	return []zapcore.Field{
+		zap.String(logUserID, userID),
+		zap.String(logEntityID, entityID),
+		zap.String(logSQL, qSQL),
+		zap.Array(logArgs, queryArgs(args)),
+	}
+}
+
+func logGotHistory(
+	userID, entityID string, events []*storage.AssociationEvent,
+) []zapcore.Field {
 	return []zapcore.Field{
 		zap.String(logUserID, userID),
-		zap.Int(logNEntities, len(entityIDs)),
+		zap.String(logEntityID, entityID),
+		zap.Int(logNEvents, len(events)),
 	}
 }
 
@@ -55,3 +115,50 @@ func (qas queryArgs) MarshalLogArray(enc zapcore.ArrayEncoder) error {
 	}
 	return nil
 }
+
+func logSetLimit(idKey, id string, max int) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(idKey, id),
+		zap.Int(logMax, max),
+	}
+}
+
+func logAddedEntities(as []*storage.Association, errs []error) []zapcore.Field {
+	nFailed := 0
+	for _, err := range errs {
+		if err != nil {
+			nFailed++
+		}
+	}
+	return []zapcore.Field{
+		zap.Int(logNAdded, len(as)-nFailed),
+		zap.Int(logNFailed, nFailed),
+	}
+}
+
+func logGotEntitiesBatch(entityIDs map[string][]string) []zapcore.Field {
+	nEntities := 0
+	for _, ids := range entityIDs {
+		nEntities += len(ids)
+	}
+	return []zapcore.Field{
+		zap.Int(logNUsers, len(entityIDs)),
+		zap.Int(logNEntities, nEntities),
+	}
+}
+
+func logUser(u *api.UserProfile) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logUserID, u.UserId),
+		zap.Int(logNAttributes, len(u.Attributes)),
+	}
+}
+
+func logImportedAssociation(
+	versions []*storage.AssociationVersion, overwritten bool,
+) []zapcore.Field {
+	return append(logUserEntityID(versions[0].UserID, versions[0].EntityID),
+		zap.Int(logNVersions, len(versions)),
+		zap.Bool(logOverwritten, overwritten),
+	)
+}
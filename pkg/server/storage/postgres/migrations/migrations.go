@@ -2,6 +2,20 @@
 // sources:
 // sql/001_add-initial-table.down.sql
 // sql/001_add-initial-table.up.sql
+// sql/002_add-transaction-period-index.down.sql
+// sql/002_add-transaction-period-index.up.sql
+// sql/003_add-limit-tables.down.sql
+// sql/003_add-limit-tables.up.sql
+// sql/004_add-role.down.sql
+// sql/004_add-role.up.sql
+// sql/005_add-user-profile-table.down.sql
+// sql/005_add-user-profile-table.up.sql
+// sql/006_add-entity-event-table.down.sql
+// sql/006_add-entity-event-table.up.sql
+// sql/007_add-import-event-setting.down.sql
+// sql/007_add-import-event-setting.up.sql
+// sql/008_add-entity-event-revision-lock.down.sql
+// sql/008_add-entity-event-revision-lock.up.sql
 // DO NOT EDIT!
 
 package migrations
@@ -109,6 +123,286 @@ func _001_addInitialTableUpSql() (*asset, error) {
 	return a, nil
 }
 
+var __002_addTransactionPeriodIndexDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x5c\x8e\x41\x4b\x03\x31\x10\x85\xef\xfb\x2b\x1e\xbd\xb4\x85\xdd\xfe\x81\x8a\x20\x6e\xc4\x42\xdd\xd5\xc5\xa2\xb7\x12\x93\xac\x1d\x1a\x92\x35\x93\x50\xfb\xef\x4d\xa4\x87\xea\x65\x98\x99\xf7\xe6\x9b\xd7\x34\x88\x07\x83\x31\x59\x8b\xe4\xe8\x2b\x19\x90\xd3\xe6\x1b\xd2\x5a\x7f\x62\x78\x67\xcf\xb9\x18\x04\x7f\xc2\x64\x02\x24\xb3\x57\x24\x23\x79\x57\x83\x7d\xde\x5b\x4b\xee\x13\x1f\x52\x1d\xa1\x89\x95\x0c\x9a\x0b\xb3\x6a\x1a\x1c\x88\xa3\x0f\x19\x30\x42\x59\xcf\x46\x17\x0c\xd7\x38\x1a\x33\x95\xa3\x5f\xba\x4a\x21\x18\x17\xaf\xc9\x5c\xb5\x62\x2b\x5e\x05\x1e\x86\xfe\x09\xb3\xc4\x26\xcc\x56\xd9\x43\xf1\x8c\xb7\x47\x31\x08\xa4\x29\x87\x59\xc4\x20\x1d\x4b\x55\x4e\xf6\x79\x26\xaf\x97\xb8\xb9\xc5\x9c\xdc\x48\x2e\x9b\xe7\xeb\xaa\x6a\x87\xfe\x19\x9b\xae\x15\xef\x7f\x41\xfb\x32\x64\xfd\x7e\x10\x77\xf9\xd3\xae\xdb\xbc\xec\xc4\xc5\x78\xe5\x40\xdf\xfd\x0b\xb0\xb8\xa8\xa4\x6b\x14\x21\x37\xcb\x75\xf5\x03\x00\x00\xff\xff\x03\x00\x7c\xe7\x0a\xeb\x49\x01\x00\x00")
+
+func _002_addTransactionPeriodIndexDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__002_addTransactionPeriodIndexDownSql,
+		"002_add-transaction-period-index.down.sql",
+	)
+}
+
+func _002_addTransactionPeriodIndexDownSql() (*asset, error) {
+	bytes, err := _002_addTransactionPeriodIndexDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "002_add-transaction-period-index.down.sql", size: 329, mode: os.FileMode(420), modTime: time.Unix(1792267263, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __002_addTransactionPeriodIndexUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x54\x8e\xcd\x0a\x82\x40\x14\x85\xf7\xf3\x14\x07\x37\x2a\x64\x2f\x20\x2d\x22\x07\x72\xa3\x25\x49\xed\xc4\xf4\x06\x03\x75\xc7\xe6\x27\xf0\xed\x1b\xc1\x45\xed\xce\xe1\xe3\xde\xef\x14\x4d\x7d\x42\x59\x15\xf2\x86\xc8\x5b\x32\xd1\x96\xd8\x29\x37\x77\x4b\xc9\x85\xc8\x32\x68\x7e\xce\x18\xbc\x31\x81\x20\x61\xed\x60\xe8\xa5\x3f\x34\xa6\xe8\xad\xd5\x83\xea\x9d\xd2\x6c\xc1\x44\x23\x9c\xc6\x9d\xe0\x59\xbd\x3d\x89\x43\x23\xf7\x17\x89\xb6\x2a\xcf\xad\x5c\x2d\x3f\xef\x51\x57\xff\x52\x24\x2b\x55\xe3\x06\x0b\x08\x21\x15\xc0\xf5\x28\x1b\x09\x3f\x4d\x64\x12\x67\x7a\xb6\xfd\xb0\x28\xbb\xd0\x95\x0e\x33\x76\x88\x15\x3f\x14\x87\xd3\x38\x17\x5f\x00\x00\x00\xff\xff\x03\x00\xe2\xf1\xfc\x87\xd3\x00\x00\x00")
+
+func _002_addTransactionPeriodIndexUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__002_addTransactionPeriodIndexUpSql,
+		"002_add-transaction-period-index.up.sql",
+	)
+}
+
+func _002_addTransactionPeriodIndexUpSql() (*asset, error) {
+	bytes, err := _002_addTransactionPeriodIndexUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "002_add-transaction-period-index.up.sql", size: 211, mode: os.FileMode(420), modTime: time.Unix(1792267263, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __003_addLimitTablesDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\x08\x71\x74\xf2\x71\x55\x50\x2a\x2d\x4e\x2d\x52\xd2\x4b\xcd\x2b\xc9\x2c\xa9\x8c\xcf\xc9\xcc\xcd\x2c\xb1\xe6\xe2\x72\xc1\x50\x00\x22\x61\xd2\x00\x00\x00\x00\xff\xff\x03\x00\x65\x59\xf2\x29\x3f\x00\x00\x00")
+
+func _003_addLimitTablesDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__003_addLimitTablesDownSql,
+		"003_add-limit-tables.down.sql",
+	)
+}
+
+func _003_addLimitTablesDownSql() (*asset, error) {
+	bytes, err := _003_addLimitTablesDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "003_add-limit-tables.down.sql", size: 63, mode: os.FileMode(420), modTime: time.Unix(1792268057, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __003_addLimitTablesUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\x8f\x4f\x0b\x82\x40\x10\xc5\xef\xfb\x29\x1e\x9e\x14\x32\xba\x07\xc1\x26\x4b\x8a\x66\xb1\x58\xe0\x49\x24\x37\x5a\x48\x0d\x77\xfb\xf7\xed\x5b\xd7\x82\x82\xf0\x32\x30\xef\xbd\x99\xdf\x8c\xef\xe3\x22\x3a\xff\xaa\x44\x87\xb2\xa9\x6c\x23\x1a\x2d\xf5\x13\xed\x4d\x74\x9d\xac\x84\x42\x7b\x84\x3e\x09\x98\x8c\x91\xfc\xbb\xd1\x50\x2a\xd5\x1e\x64\xa9\x65\xdb\xe0\x2c\x6b\xa9\x15\x09\x38\xa3\x19\x43\x46\x97\x09\x83\xd3\x6f\x74\xa6\x7d\x2d\xac\x0f\x97\x00\xb6\x95\x15\xf6\x94\x07\x21\xe5\xd8\xf2\x68\x4d\x79\x8e\x98\xe5\x13\x63\xd7\xe5\xa3\xb0\x70\x69\xa0\x51\x9a\xb1\x15\xe3\x48\x37\x19\xd2\x5d\x92\x20\x08\x59\x10\xc3\xfd\x09\x2d\x30\xf3\x88\x37\x27\x7f\xe1\xc3\x1f\x5f\xf8\xb7\x30\x7e\x40\x3f\x3b\x4e\x1f\x12\x1f\xf4\x0b\x00\x00\xff\xff\x03\x00\xef\x61\xd0\xc0\x42\x01\x00\x00")
+
+func _003_addLimitTablesUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__003_addLimitTablesUpSql,
+		"003_add-limit-tables.up.sql",
+	)
+}
+
+func _003_addLimitTablesUpSql() (*asset, error) {
+	bytes, err := _003_addLimitTablesUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "003_add-limit-tables.up.sql", size: 322, mode: os.FileMode(420), modTime: time.Unix(1792268057, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __004_addRoleDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x50\x2a\x2d\x4e\x2d\x52\xd2\x4b\xcd\x2b\xc9\x2c\xa9\xe4\x52\x50\x70\x09\xf2\x0f\x50\x70\xf6\xf7\x09\xf5\xf5\x53\x28\x2e\x2d\x48\x2d\x2a\x4e\x4d\x49\x4d\xd1\x41\x93\x29\xca\xcf\x49\x8d\x2f\x2d\x48\x49\x2c\xc1\x2e\x67\xcd\x05\x00\x00\x00\xff\xff\x03\x00\xc1\x31\xf2\x99\x64\x00\x00\x00")
+
+func _004_addRoleDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__004_addRoleDownSql,
+		"004_add-role.down.sql",
+	)
+}
+
+func _004_addRoleDownSql() (*asset, error) {
+	bytes, err := _004_addRoleDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "004_add-role.down.sql", size: 100, mode: os.FileMode(420), modTime: time.Unix(1792269090, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __004_addRoleUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\x8f\xc1\x6a\xc3\x30\x10\x44\xef\xfe\x8a\x21\xe7\x38\xf4\x9e\x93\x52\x2b\x50\xd8\xd8\xd0\xd8\xe7\x20\xa2\x0d\x11\xa4\x92\xd1\xca\x35\xf9\xfb\xaa\x32\x81\xd2\x40\x0e\x3a\x48\xfb\xe6\xed\xa8\xae\x11\xc3\x8d\x11\x2e\x48\x57\xc6\x24\x1c\x71\x09\xb1\x5c\xd8\x27\x97\xee\x5b\x98\x05\x99\x46\x6b\x12\xe3\x7c\x0b\xc2\x52\x80\xf3\x14\x63\x86\xf2\x78\x86\x11\xc8\x34\x72\x14\xb6\x6c\x61\xbc\xad\xea\x1a\xce\x67\x5f\x92\x6c\xf0\x3c\x17\x6c\x76\xe9\xba\x6c\x2a\x36\x5b\xd4\x6b\x48\x28\x8f\x65\x4f\x36\xe5\x36\xc6\xdf\x91\xdc\x17\xc3\x09\xc6\xc8\xd9\xf3\xcd\xb6\x52\xd4\xeb\x4f\xf4\x6a\x47\x1a\xab\xdf\xb2\xab\xcd\xd2\xb2\x02\x54\xd3\xe0\xbd\xa3\xe1\xd0\x2e\x9e\xe3\x41\x11\x7d\xb4\x3d\xda\x2e\x9f\x81\x08\x8d\xde\xab\x81\x7a\xbc\xad\x9f\xf1\xd3\xa3\xd0\xae\xeb\x48\xab\xf6\x39\xb5\x57\x74\xd4\xff\x92\x7f\xbe\xfc\x3a\xb7\xad\x7e\x00\x00\x00\xff\xff\x03\x00\xe9\xcb\xa2\x06\x6a\x01\x00\x00")
+
+func _004_addRoleUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__004_addRoleUpSql,
+		"004_add-role.up.sql",
+	)
+}
+
+func _004_addRoleUpSql() (*asset, error) {
+	bytes, err := _004_addRoleUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "004_add-role.up.sql", size: 362, mode: os.FileMode(420), modTime: time.Unix(1792269090, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __005_addUserProfileTableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\x08\x71\x74\xf2\x71\x55\x50\x2a\x2d\x4e\x2d\x52\xd2\x2b\x28\xca\x4f\xcb\xcc\x49\xb5\xe6\x02\x00\x00\x00\xff\xff\x03\x00\x8d\xad\xd1\x7b\x1b\x00\x00\x00")
+
+func _005_addUserProfileTableDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__005_addUserProfileTableDownSql,
+		"005_add-user-profile-table.down.sql",
+	)
+}
+
+func _005_addUserProfileTableDownSql() (*asset, error) {
+	bytes, err := _005_addUserProfileTableDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "005_add-user-profile-table.down.sql", size: 27, mode: os.FileMode(420), modTime: time.Unix(1792269453, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __005_addUserProfileTableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\xd0\xcd\x4a\x03\x31\x10\xc0\xf1\xfb\x3e\xc5\xd0\x4b\x5b\xe8\xfa\x02\x9e\xd2\x1a\xb1\xba\x1f\x25\x4d\x95\x7a\x59\xa2\x99\x75\x07\x93\xcd\x92\x64\x95\x2a\xbe\xbb\x6e\x91\x22\x28\xac\xe7\xf9\xcd\x7f\x60\xd2\x14\xfa\x80\x1e\x3a\xef\x6a\x32\x18\x16\xf0\x4a\xb1\x01\x15\xa3\xa7\x87\x3e\x62\x80\xc6\x19\x4d\xed\x13\xd4\x1e\x31\xad\x9d\xb7\xf0\x8c\x87\xf4\x45\x99\x1e\xa1\x53\xe4\x43\xb2\x12\x9c\x49\x0e\x92\x2d\x33\x0e\x93\x21\x37\x39\xfb\xee\xc1\x2c\x81\xe3\x81\x8a\x34\xdc\x32\xb1\xba\x62\x02\x36\x62\x9d\x33\xb1\x87\x1b\xbe\x5f\x7c\x8d\x35\x85\xce\xa8\x43\xd5\x2a\x8b\x27\x53\x94\x12\x8a\x5d\x96\xc1\x05\xbf\x64\xbb\x4c\xc2\x74\x3a\x58\xb4\x8a\xcc\x18\xea\x1a\xd7\x8e\x96\x8c\x7b\x54\x66\x54\x45\xb2\xf8\xf6\x8f\xda\x8f\x7f\x5d\x6f\xcb\x62\xf9\x87\x7b\xff\x38\x4a\xeb\x34\xd5\x84\xba\x1a\xd2\x20\xd7\x39\xdf\x4a\x96\x6f\xe4\xfd\xef\x95\xa2\xbc\x9b\xcd\x93\xf9\x79\xf2\x09\x00\x00\xff\xff\x03\x00\x5f\xf9\xb6\x08\xa5\x01\x00\x00")
+
+func _005_addUserProfileTableUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__005_addUserProfileTableUpSql,
+		"005_add-user-profile-table.up.sql",
+	)
+}
+
+func _005_addUserProfileTableUpSql() (*asset, error) {
+	bytes, err := _005_addUserProfileTableUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "005_add-user-profile-table.up.sql", size: 421, mode: os.FileMode(420), modTime: time.Unix(1792269453, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __006_addEntityEventTableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\x08\x09\xf2\x74\x77\x77\x0d\x52\xc8\xcb\x2f\xc9\x4c\xab\x8c\x4f\xcd\x2b\xc9\x2c\x01\x52\x65\x40\x86\x82\xbf\x9f\x82\x52\x69\x71\x6a\x91\x92\x1e\xb2\xb0\x35\x97\x0b\x48\xa3\x5b\xa8\x9f\x73\x88\x27\x42\x09\x16\x03\x34\x34\xa1\x6a\x61\x96\x14\xa5\x26\xe7\x17\xa5\xe0\xb7\x04\x87\xf1\x58\xb4\x22\x8c\x77\x74\xf2\x71\xc5\xee\x54\x00\x00\x00\x00\xff\xff\x03\x00\x85\x26\x62\xf8\xe4\x00\x00\x00")
+
+func _006_addEntityEventTableDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__006_addEntityEventTableDownSql,
+		"006_add-entity-event-table.down.sql",
+	)
+}
+
+func _006_addEntityEventTableDownSql() (*asset, error) {
+	bytes, err := _006_addEntityEventTableDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "006_add-entity-event-table.down.sql", size: 228, mode: os.FileMode(420), modTime: time.Unix(1792270060, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __006_addEntityEventTableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xb4\x54\x5d\x6f\x9b\x30\x14\x7d\xe7\x57\x5c\x4d\x91\x92\x48\xa4\xd2\xb6\xb7\x66\x9b\xe4\x26\x4e\x8a\x46\x20\x72\xa0\x5d\xf7\x82\x5c\x70\x13\x4f\x14\x33\xec\x34\xcb\xbf\xdf\xb5\x49\xfa\xb1\xd2\x6a\xd5\xb4\x07\x04\xf8\xde\x7b\xee\xf1\x39\xd7\x1e\x8d\x80\x6b\xad\x72\xc9\x8d\x54\x15\x88\x3b\x51\x19\x0d\xb2\x02\xb3\x11\xa0\x9a\x42\x34\xf6\x6b\x0f\x1b\x5e\xd7\xa2\x12\x85\x0f\x8d\xc8\xed\x7a\x01\xd7\x7b\xe0\x60\x1a\xb9\x5e\x63\x12\xd6\xbe\xdb\x6a\xd1\xbc\x3b\x41\x00\x69\xf6\x63\x10\x3c\xdf\x78\xa3\x11\x48\x0d\xf5\xf6\xba\x94\x7a\x83\x35\xaa\x05\xb6\x99\x59\x9b\x98\xb9\x96\x90\x6f\x78\x55\x89\x12\xb4\xb2\x1c\x9a\x3d\x60\x06\xbe\x21\xe7\x15\x54\xca\xc8\x9b\x3d\x48\x24\xb6\xe3\x26\xdf\x88\x46\x7b\x13\x46\x49\x42\x21\x21\x67\x21\x7d\xda\xf9\x00\x38\xf0\x00\xa9\xde\x49\x6d\xb7\x75\x16\xcc\x57\x94\x05\x24\x84\x25\x0b\x16\x84\x5d\xc1\x57\x7a\xe5\x63\x86\xd9\xd7\x02\x56\x0b\x12\x86\x41\x94\x40\x14\xe3\x93\x86\xa1\x8b\xc8\x5b\x01\x49\xb0\xa0\xab\x84\x2c\x96\xc9\xf7\x27\x41\xc7\x5f\x16\x70\x41\xd8\xe4\x9c\xb0\x27\xb1\x03\x8b\x17\xa2\x8d\x2a\x3b\x1a\x7a\xc3\xb1\x67\xb5\xc2\xcd\xca\x0a\xb1\x0d\x4a\xd5\xa8\x9d\xd5\x0e\x97\x78\x51\x48\xe7\x8e\x6a\xda\xfa\x6d\x5d\x70\x23\x7c\x8c\x15\x68\x41\x5e\x2a\x7d\xc8\x37\x1b\x6e\x50\x23\x5d\xf5\x0d\xe8\x6d\x8d\x42\x89\xa3\x53\x16\xfe\x51\xb1\x83\x46\x81\x6e\xd5\x1d\x2f\xc7\xad\xef\x4e\x0d\x0d\xb7\x56\x63\x67\x13\xaf\xe5\x09\xb5\x91\xc4\xca\x84\x89\x5b\x71\xaf\xfc\x2c\x8d\x26\x49\x10\x47\x47\xf1\xdb\xb1\x78\x62\xea\x60\x08\x8c\x26\x29\x8b\x56\x90\xb0\x60\x3e\xa7\x0c\xc8\x0a\x7a\x3d\xef\x8c\xce\x83\x08\xc5\x08\x66\x90\xcc\xb3\x78\x09\x9f\xa1\x1f\x44\xe8\x50\xd2\x87\xe4\x9c\xda\x10\x06\xdd\x02\xbe\x92\xb8\xdb\x60\xcb\xd6\x77\x3e\xf9\x47\x43\xfc\x07\xf5\x7d\xb7\xdb\xa1\x83\xba\x20\x61\x4a\x57\x30\x98\x90\x15\x85\x4b\x6c\x00\x11\xbd\x3c\xb1\xf1\xac\x55\xa3\x70\x6d\xe1\x23\xd0\x10\x33\xde\x03\x8d\xa6\x3e\x94\x6a\x27\x9a\x81\xcd\x34\x0d\xaf\x34\xcf\xad\x07\x19\x8a\x2a\x55\x31\xf4\x1d\x30\x38\xa0\xfb\xe6\xf6\xe7\x11\x81\x63\x13\x34\x17\x2c\x32\x6e\x77\x8b\xa7\xa8\x19\xc4\xe1\xb4\x0b\xd3\xca\x20\xab\x1b\x59\x21\x40\xdf\xe1\x93\x68\x7a\x28\x79\x81\x06\x7c\xfa\xd2\x55\xe3\xe6\x0a\x2b\x1e\xcd\xc0\xff\xd4\xf5\x83\xff\x3a\x4b\xff\x0d\x32\x21\xfb\x60\x66\xbf\xda\xd1\x71\xc7\x63\xec\xe1\xf2\xd8\xeb\xf5\x20\x24\xd1\x3c\x25\x73\x0a\x75\x59\xaf\xf5\xcf\x12\x8f\xcd\xf1\x26\x38\x4c\x58\xc7\x1c\x22\x18\x99\x25\x18\x3b\x6c\x3d\x66\x90\x2e\xa7\xb6\xe8\x61\x7c\xdb\x7c\xcc\x9c\x61\x94\x92\xc9\x39\xb0\xf8\x12\xe8\x37\x3a\x49\x31\x6f\xc9\xe2\x09\x9d\xa6\x8c\xbe\x3a\xed\x0f\x64\xfe\x3c\x1c\xed\x0d\xf6\xb6\xc3\xb1\xa4\x0c\xb9\x2c\xa0\x5e\x67\x6d\xf9\xa0\xff\xec\xda\xec\x5b\x37\x76\x99\x51\xd9\x0f\xad\x2a\x2b\xff\xf0\xf4\xd4\x88\x5f\x66\xf8\x0f\x0a\x76\x90\x7d\xa6\x60\xd4\x35\x3a\x7f\xad\x5e\xa7\x1c\x63\xef\x37\x00\x00\x00\xff\xff\x03\x00\x6b\xeb\x66\xb7\x8c\x06\x00\x00")
+
+func _006_addEntityEventTableUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__006_addEntityEventTableUpSql,
+		"006_add-entity-event-table.up.sql",
+	)
+}
+
+func _006_addEntityEventTableUpSql() (*asset, error) {
+	bytes, err := _006_addEntityEventTableUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "006_add-entity-event-table.up.sql", size: 1676, mode: os.FileMode(420), modTime: time.Unix(1792270060, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __007_addImportEventSettingDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xb4\x52\x51\x4b\xc3\x30\x10\x7e\xef\xaf\x38\x46\x61\x2d\x94\x81\xfa\x58\x15\x62\x97\xd5\x42\x49\x47\x9a\xba\xc7\x32\xd6\x28\x81\xda\xd6\x34\x55\xf6\xef\xbd\xc4\x4e\xf6\x30\x05\x1f\x7c\x49\x2e\xf7\x7d\xf7\x7d\x77\x47\x12\x4e\x89\xa0\x50\x70\xe0\x74\x9b\x93\x84\xc2\xa6\x62\x89\xc8\x0a\x06\x8b\x69\x94\x7a\xb1\xd2\xf2\xd0\xeb\xa6\x96\x9d\x51\xe6\x58\xcb\x77\x0c\x82\x10\xd9\xa2\xe2\xac\x04\xc1\xb3\x34\xa5\x1c\x48\x09\xbe\xef\x3d\xd0\x34\x63\x1e\x40\xb6\x01\x91\xd6\xc5\x16\xee\x60\x99\xb1\x92\x72\xb1\x04\xf1\x48\x2d\x84\xa0\x4b\xe0\x25\x8a\x93\xc7\xb9\x38\x04\xe6\x38\xc8\x08\x8c\x7a\xc5\xd3\xe2\xb5\x6a\x22\x98\x29\x36\xd4\x7d\x2b\x43\x27\xf5\x44\xf2\x8a\x96\x10\x24\xa4\xa4\xb0\x43\x03\x60\x74\xb7\xb2\x78\x3d\x0d\xcd\xde\xc8\xc6\xd9\xc2\x0d\xd0\x1c\x19\x57\x40\xd9\x3a\x82\xb6\xff\x90\x3a\xb0\x4c\xa3\xf7\xdd\xb8\x3f\x18\xd5\x77\xf5\x20\xb5\xea\x9b\x30\x72\xc2\xe0\x84\xbe\xcd\xed\xe3\xac\x81\x93\x49\x18\x23\x19\x95\x71\xdc\x69\xc0\xfa\xa0\xc8\xd7\x97\x34\xed\x1a\x54\xf7\xac\x3a\x14\x58\x3a\x7d\xc2\xd6\x73\xc9\x0f\x6d\xc0\xed\xfd\xa5\x1a\x56\x08\xe7\x3e\x4e\xc8\x1b\x65\x33\x0f\xf8\x5f\x7b\xbd\x8e\x7e\xef\x32\xfa\xc3\x9a\xb0\xfb\x6c\x63\xa3\xaf\xaf\x03\xac\xca\xf3\xd8\xc3\x74\xec\xf9\x3e\xe4\x84\xa5\x15\x49\x29\x0c\xed\xf0\x32\xbe\xb5\xb1\xf7\x09\x00\x00\xff\xff\x03\x00\x77\xaa\x62\x31\x9a\x02\x00\x00")
+
+func _007_addImportEventSettingDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__007_addImportEventSettingDownSql,
+		"007_add-import-event-setting.down.sql",
+	)
+}
+
+func _007_addImportEventSettingDownSql() (*asset, error) {
+	bytes, err := _007_addImportEventSettingDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "007_add-import-event-setting.down.sql", size: 666, mode: os.FileMode(420), modTime: time.Unix(1792277080, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __007_addImportEventSettingUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xb4\x52\x5b\x6b\x9c\x40\x14\x7e\xf7\x57\x7c\x84\x05\x5d\x70\x17\xda\x3e\xda\x04\xec\x66\x62\x05\xd1\xe0\x25\x79\x14\xd1\x49\x76\xc0\x38\x76\x66\xec\x92\x7f\xdf\x33\xea\x86\x2d\x6c\x0b\x7d\xe8\x8b\x97\x73\xbe\xdb\x39\x33\xbb\x1d\x9a\x01\xe2\x6d\x94\xca\x40\x73\xa3\x31\x69\xae\x6a\x3e\x18\x61\xde\xf7\x4b\x5d\x0c\xaf\x78\x91\x0a\x82\xba\x46\x35\x83\x6e\x5a\x23\xe4\x00\x2d\x61\x8e\x7c\x25\xf3\x0e\x4a\x9e\x34\x3a\x39\xb8\x06\x8a\xb7\x52\x75\xce\x6e\x67\x11\x42\xe1\x28\xb4\x91\x4a\xb4\x4d\x0f\xfe\x93\xc4\x75\x40\x6a\x2b\x4a\xa3\x81\x26\x8f\x9e\x2f\x3d\x9c\x84\x39\xce\xca\x8d\xd6\xb2\x15\x8d\x35\x73\x35\xda\x49\x29\xdb\xd6\xa6\x31\xdc\x39\xe4\x2c\x2c\x19\xb2\x1c\x39\x7b\x4c\xc2\x03\xc3\x43\x95\x1e\xca\x38\x4b\x71\x63\x47\xb8\xd9\x2f\xea\xeb\x28\xf5\x2c\xed\x6d\x09\x5d\x56\x79\x5a\xa0\xcc\xe3\x28\x62\x39\xc2\x02\x9b\x8d\xf3\x8d\x45\x71\xea\x00\xf1\xc3\xd9\xa6\xa6\x65\xd8\xc9\x3d\xf7\xea\x42\x5c\x9f\x56\x31\xf1\x2d\x6e\xe1\x52\x3a\x94\xdf\x99\xe5\x63\xd5\x47\x5a\x25\x49\x40\x05\x96\xde\x93\x68\xb0\x48\x97\x51\x9d\x3d\x5a\x46\x9c\x16\x2c\x2f\x2f\x58\x4b\x81\x5e\x65\x76\x8e\x7f\x99\x1b\x9e\x79\x1f\x39\x59\x8a\x37\x7a\xce\x81\x44\xe7\x63\x85\xd8\x4f\x25\x7b\xbe\x9d\xa5\x9e\xc2\xa4\x62\x05\xbc\x43\x58\x30\x3c\x93\x01\x52\xf6\xbc\xb7\xfd\x7a\x1a\xbb\xc6\x1e\x94\xb5\xc5\x17\xb0\x84\x10\x9f\x6c\x44\x1f\xbd\x3c\x71\xe5\x59\xe4\xc5\x09\xd7\x23\x57\x42\x76\x5b\x7f\x16\xc6\x2c\xf4\x61\x6e\x7f\x2e\x02\x9c\x4d\xb6\xf3\xd4\x49\x41\xe3\x4e\x23\xf1\xbd\x2c\xb9\xbf\xa6\x69\xd7\x20\x86\x17\x31\x90\x80\x3b\xeb\x87\xb4\xaa\x85\xf2\x87\x18\xf8\x7a\x77\x8d\x93\x66\xe5\xec\xae\x27\xc2\x69\xde\xad\x03\xfe\xaf\xbd\x7e\xf6\xff\x9e\xd2\xff\x87\x35\x7d\x5c\x8e\xdf\x6e\x0d\x95\x03\x67\xb3\x41\x12\xa6\x51\x15\x46\x0c\x63\x3f\xbe\xea\x1f\x7d\xe0\xfc\x02\x00\x00\xff\xff\x03\x00\xa3\xa1\x59\xb6\xae\x03\x00\x00")
+
+func _007_addImportEventSettingUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__007_addImportEventSettingUpSql,
+		"007_add-import-event-setting.up.sql",
+	)
+}
+
+func _007_addImportEventSettingUpSql() (*asset, error) {
+	bytes, err := _007_addImportEventSettingUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "007_add-import-event-setting.up.sql", size: 942, mode: os.FileMode(420), modTime: time.Unix(1792277080, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __008_addEntityEventRevisionLockDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\x08\x09\xf2\x74\x77\x77\x0d\x52\x48\x2c\x2e\xce\x4c\xcf\x8b\x4f\xcd\x2b\xc9\x2c\xa9\x8c\x4f\x2d\x03\x32\xe2\x8b\x52\xcb\x32\x8b\x33\xf3\xf3\x14\xfc\xfd\x14\x94\x4a\x8b\x53\x8b\x94\xf4\x90\xe5\xad\xb9\x5c\x40\x26\xb8\x85\xfa\x39\x87\x78\x22\x94\xe0\x33\x49\x43\xd3\x9a\xcb\xd1\x27\x04\x68\x5f\x88\xa3\x93\x8f\x2b\x36\x53\xb9\x14\x14\x20\x2a\x9c\xfd\x7d\x42\x7d\xfd\x14\xe0\x8e\x08\x76\x0d\x51\x70\x71\x75\x73\x0c\xf5\x09\x51\xc8\x4b\xad\x28\x29\x4b\xcc\xd1\x50\xc7\x62\x00\xdc\xb2\xf8\xe2\xd4\x42\x75\xa0\x85\x00\x00\x00\x00\xff\xff\x03\x00\xd7\xba\x1c\x88\xe8\x00\x00\x00")
+
+func _008_addEntityEventRevisionLockDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__008_addEntityEventRevisionLockDownSql,
+		"008_add-entity-event-revision-lock.down.sql",
+	)
+}
+
+func _008_addEntityEventRevisionLockDownSql() (*asset, error) {
+	bytes, err := _008_addEntityEventRevisionLockDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "008_add-entity-event-revision-lock.down.sql", size: 232, mode: os.FileMode(420), modTime: time.Unix(1792277752, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __008_addEntityEventRevisionLockUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x92\x41\x6f\xe2\x30\x10\x85\xef\xf9\x15\xa3\x0a\x09\x90\xa0\xea\xaa\xb7\xa6\x3d\x84\x60\x28\x52\x48\x2a\x93\x88\xbd\x45\xde\x64\x0a\x11\xc1\xa6\xb6\x81\xf2\xef\x77\x4c\x80\x74\xa5\xee\x6a\x73\x4a\x32\x33\xdf\x7b\x6f\xec\xe1\x10\x34\x1e\x2a\x53\x29\x69\x40\x68\x04\x61\x4c\xb5\x92\x58\x42\x25\xa1\x50\xdb\x6d\x65\x41\xe9\x12\xf5\x13\xa0\x28\xd6\x60\xb5\x90\x46\x14\x96\xfa\x69\xb0\xa0\x52\x25\x57\x20\x24\xe0\x01\xa5\x05\x2b\x36\x68\xc0\xae\xd1\x1b\xb6\x60\xa8\x55\xb1\x81\x9e\x28\xe9\x53\xe9\x53\xf3\x69\x76\xa2\x40\x78\xec\xc3\x2f\x7c\x57\xa4\x5b\x6a\x71\x74\x28\x9a\x6d\x58\x5d\xd3\x02\x84\x2c\x61\xad\xea\xd2\x00\xd9\xd9\x4b\x5b\xd5\x8e\x4f\xef\x8d\x43\x33\x00\xa3\x40\xaa\x8b\x89\xcb\x4f\x38\x56\x76\x0d\x82\xe4\x8e\xa8\x5b\x96\x5d\x93\x5b\x25\x29\x69\xad\x51\x94\xa7\x4b\xbb\xc5\x72\x70\xd6\x11\x0e\x7d\x14\x96\xc2\x6a\x34\xfb\xad\x33\xf5\xae\xd5\x96\x48\x37\x46\xa9\xd0\xc8\xae\x05\xb3\xa9\x76\x34\x74\x6a\x84\x0d\x4d\xba\x61\x25\xeb\xd3\x39\x47\x2d\x2c\x1a\x0b\x3f\x1e\xdc\x73\xe9\x39\x2f\x59\xa3\x15\x95\x5b\x32\x45\x6f\xb4\xd0\xfc\x55\xcd\x07\x4a\x4e\x09\xc8\xb3\x53\x38\x03\x4a\xac\x91\x1c\xd3\x69\x81\xc4\xe3\xb9\x74\x01\xbb\x23\xc1\xd2\x0b\xa2\x94\x71\x48\x83\x51\xc4\xe0\x6e\x6f\x50\xdf\xdd\x93\x7a\x65\x4f\x79\xb3\xa3\xa6\x1e\x26\x51\x36\x8f\xdb\x5c\x63\x9e\xbc\xc1\x98\x4d\x82\x2c\x4a\x7d\xcf\x0b\x39\x0b\x52\x06\x93\x2c\x0e\xd3\x59\x12\x5f\x41\xcd\x15\xc9\xbf\xf2\xf2\x2b\xa2\xd7\x07\xce\xd2\x8c\xc7\x0b\x48\xf9\x6c\x3a\x25\x91\x60\x01\x9d\x8e\x37\x62\xd3\x59\xec\x01\xbc\x31\x3e\x49\xf8\x1c\x76\xab\xfc\x7a\x21\xf2\x4f\xba\x50\xb9\xbb\x15\xbd\xc7\x01\x3c\xf4\x7d\x6a\x8b\xd9\xf2\xfe\x66\xeb\xe9\x85\x52\x7e\xda\x83\xa8\x7b\xdd\x6f\xc2\xdc\xc4\x73\x83\x1f\xdd\xf3\xf8\x98\x45\xcc\x39\xe7\xc9\xfc\xdb\xf8\xcb\x57\xc6\x59\x9b\xfb\xf9\xe5\x4f\xc1\xe1\xe5\xcc\x1c\xaa\x89\xe3\xea\xbe\xc7\xe2\xb1\xef\x75\x3a\x10\x05\xf1\x34\x0b\xa6\x0c\x76\xf5\x6e\x65\x3e\xea\x76\x57\xd7\xd0\xff\xda\x11\x41\x47\x8c\x96\xc0\x60\x16\x2f\x18\x4f\xa1\x5d\xed\xd7\x7e\x6a\xa3\x26\x60\x41\xf8\x0a\x3c\x59\x02\xfb\xc9\xc2\x8c\x24\xde\x78\x12\xb2\x71\xc6\xd9\xff\x9d\x87\xef\xfd\x06\x00\x00\xff\xff\x03\x00\xf4\xa2\xf4\x58\xe4\x03\x00\x00")
+
+func _008_addEntityEventRevisionLockUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__008_addEntityEventRevisionLockUpSql,
+		"008_add-entity-event-revision-lock.up.sql",
+	)
+}
+
+func _008_addEntityEventRevisionLockUpSql() (*asset, error) {
+	bytes, err := _008_addEntityEventRevisionLockUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "008_add-entity-event-revision-lock.up.sql", size: 996, mode: os.FileMode(420), modTime: time.Unix(1792277752, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
 // Asset loads and returns the asset for the given name.
 // It returns an error if the asset could not be found or
 // could not be loaded.
@@ -161,8 +455,22 @@ func AssetNames() []string {
 
 // _bindata is a table, holding each asset generator, mapped to its name.
 var _bindata = map[string]func() (*asset, error){
-	"001_add-initial-table.down.sql": _001_addInitialTableDownSql,
-	"001_add-initial-table.up.sql":   _001_addInitialTableUpSql,
+	"001_add-initial-table.down.sql":              _001_addInitialTableDownSql,
+	"001_add-initial-table.up.sql":                _001_addInitialTableUpSql,
+	"002_add-transaction-period-index.down.sql":   _002_addTransactionPeriodIndexDownSql,
+	"002_add-transaction-period-index.up.sql":     _002_addTransactionPeriodIndexUpSql,
+	"003_add-limit-tables.down.sql":               _003_addLimitTablesDownSql,
+	"003_add-limit-tables.up.sql":                 _003_addLimitTablesUpSql,
+	"004_add-role.down.sql":                       _004_addRoleDownSql,
+	"004_add-role.up.sql":                         _004_addRoleUpSql,
+	"005_add-user-profile-table.down.sql":         _005_addUserProfileTableDownSql,
+	"005_add-user-profile-table.up.sql":           _005_addUserProfileTableUpSql,
+	"006_add-entity-event-table.down.sql":         _006_addEntityEventTableDownSql,
+	"006_add-entity-event-table.up.sql":           _006_addEntityEventTableUpSql,
+	"007_add-import-event-setting.down.sql":       _007_addImportEventSettingDownSql,
+	"007_add-import-event-setting.up.sql":         _007_addImportEventSettingUpSql,
+	"008_add-entity-event-revision-lock.down.sql": _008_addEntityEventRevisionLockDownSql,
+	"008_add-entity-event-revision-lock.up.sql":   _008_addEntityEventRevisionLockUpSql,
 }
 
 // AssetDir returns the file names below a certain
@@ -206,8 +514,22 @@ type bintree struct {
 }
 
 var _bintree = &bintree{nil, map[string]*bintree{
-	"001_add-initial-table.down.sql": &bintree{_001_addInitialTableDownSql, map[string]*bintree{}},
-	"001_add-initial-table.up.sql":   &bintree{_001_addInitialTableUpSql, map[string]*bintree{}},
+	"001_add-initial-table.down.sql":              &bintree{_001_addInitialTableDownSql, map[string]*bintree{}},
+	"001_add-initial-table.up.sql":                &bintree{_001_addInitialTableUpSql, map[string]*bintree{}},
+	"002_add-transaction-period-index.down.sql":   &bintree{_002_addTransactionPeriodIndexDownSql, map[string]*bintree{}},
+	"002_add-transaction-period-index.up.sql":     &bintree{_002_addTransactionPeriodIndexUpSql, map[string]*bintree{}},
+	"003_add-limit-tables.down.sql":               &bintree{_003_addLimitTablesDownSql, map[string]*bintree{}},
+	"003_add-limit-tables.up.sql":                 &bintree{_003_addLimitTablesUpSql, map[string]*bintree{}},
+	"004_add-role.down.sql":                       &bintree{_004_addRoleDownSql, map[string]*bintree{}},
+	"004_add-role.up.sql":                         &bintree{_004_addRoleUpSql, map[string]*bintree{}},
+	"005_add-user-profile-table.down.sql":         &bintree{_005_addUserProfileTableDownSql, map[string]*bintree{}},
+	"005_add-user-profile-table.up.sql":           &bintree{_005_addUserProfileTableUpSql, map[string]*bintree{}},
+	"006_add-entity-event-table.down.sql":         &bintree{_006_addEntityEventTableDownSql, map[string]*bintree{}},
+	"006_add-entity-event-table.up.sql":           &bintree{_006_addEntityEventTableUpSql, map[string]*bintree{}},
+	"007_add-import-event-setting.down.sql":       &bintree{_007_addImportEventSettingDownSql, map[string]*bintree{}},
+	"007_add-import-event-setting.up.sql":         &bintree{_007_addImportEventSettingUpSql, map[string]*bintree{}},
+	"008_add-entity-event-revision-lock.down.sql": &bintree{_008_addEntityEventRevisionLockDownSql, map[string]*bintree{}},
+	"008_add-entity-event-revision-lock.up.sql":   &bintree{_008_addEntityEventRevisionLockUpSql, map[string]*bintree{}},
 }}
 
 // RestoreAsset restores an asset under the given directory
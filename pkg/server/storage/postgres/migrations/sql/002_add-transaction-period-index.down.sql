@@ -0,0 +1,7 @@
+-- the full unique index allows only one row per association, so rolling back discards the
+-- history of closed rows, keeping only current associations
+DELETE FROM "user".entity WHERE upper(transaction_period) <> 'infinity';
+
+DROP INDEX "user".entity_user;
+
+CREATE UNIQUE INDEX entity_user ON "user".entity (entity_id, user_id);
@@ -0,0 +1,5 @@
+DROP INDEX "user".entity_user;
+
+-- only current (not removed) associations need to be unique
+CREATE UNIQUE INDEX entity_user ON "user".entity (entity_id, user_id)
+  WHERE upper(transaction_period) = 'infinity';
@@ -0,0 +1,3 @@
+DROP TABLE "user".entity_limit;
+
+DROP TABLE "user".user_limit;
@@ -0,0 +1,10 @@
+-- per-user and per-entity overrides of the server-wide association limits
+CREATE TABLE "user".user_limit (
+  user_id VARCHAR PRIMARY KEY,
+  max_entities INTEGER NOT NULL CHECK (max_entities > 0)
+);
+
+CREATE TABLE "user".entity_limit (
+  entity_id VARCHAR PRIMARY KEY,
+  max_users INTEGER NOT NULL CHECK (max_users > 0)
+);
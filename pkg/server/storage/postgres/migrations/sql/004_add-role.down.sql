@@ -0,0 +1,4 @@
+ALTER TABLE "user".entity
+  DROP COLUMN superseded,
+  DROP COLUMN role_updated,
+  DROP COLUMN role;
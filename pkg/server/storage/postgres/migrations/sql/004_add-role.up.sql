@@ -0,0 +1,6 @@
+-- role of the user for the entity; a role update closes the current row as superseded and
+-- inserts a new row with the updated role, so the role as of any time is preserved
+ALTER TABLE "user".entity
+  ADD COLUMN role SMALLINT NOT NULL DEFAULT 0,
+  ADD COLUMN role_updated BOOLEAN NOT NULL DEFAULT FALSE,
+  ADD COLUMN superseded BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,11 @@
+-- user profiles, with attributes holding free-form key-value pairs
+CREATE TABLE "user".profile (
+  user_id VARCHAR PRIMARY KEY,
+  display_name VARCHAR NOT NULL DEFAULT '',
+  email VARCHAR NOT NULL DEFAULT '',
+  phone VARCHAR NOT NULL DEFAULT '',
+  locale VARCHAR NOT NULL DEFAULT '',
+  timezone VARCHAR NOT NULL DEFAULT '',
+  attributes JSONB NOT NULL DEFAULT '{}',
+  modified_time TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
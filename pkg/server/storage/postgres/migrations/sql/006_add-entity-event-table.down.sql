@@ -0,0 +1,5 @@
+DROP TRIGGER notify_entity_event ON "user".entity_event;
+DROP FUNCTION "user".notify_entity_event();
+DROP TRIGGER record_entity_event ON "user".entity;
+DROP FUNCTION "user".record_entity_event();
+DROP TABLE "user".entity_event;
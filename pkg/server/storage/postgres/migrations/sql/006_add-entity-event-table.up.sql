@@ -0,0 +1,43 @@
+-- association events in the order they happened, recorded by a trigger on "user".entity; each
+-- is published on the user_entity_event channel so every server can notify its watchers
+CREATE TABLE "user".entity_event (
+  revision BIGSERIAL PRIMARY KEY,
+  type SMALLINT NOT NULL,
+  time TIMESTAMPTZ NOT NULL,
+  user_id VARCHAR NOT NULL,
+  entity_id VARCHAR NOT NULL,
+  role SMALLINT NOT NULL
+);
+
+-- an inserted row is an addition or role update, and a closed row that wasn't superseded by a
+-- role update is a removal; event types match the api.EventType values
+CREATE FUNCTION "user".record_entity_event() RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    INSERT INTO "user".entity_event (type, time, user_id, entity_id, role)
+    VALUES (CASE WHEN NEW.role_updated THEN 3 ELSE 1 END, lower(NEW.transaction_period),
+      NEW.user_id, NEW.entity_id, NEW.role);
+  ELSIF upper(OLD.transaction_period) = 'infinity'
+    AND upper(NEW.transaction_period) <> 'infinity'
+    AND NOT NEW.superseded THEN
+    INSERT INTO "user".entity_event (type, time, user_id, entity_id, role)
+    VALUES (2, upper(NEW.transaction_period), NEW.user_id, NEW.entity_id, NEW.role);
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER record_entity_event
+  AFTER INSERT OR UPDATE ON "user".entity
+  FOR EACH ROW EXECUTE PROCEDURE "user".record_entity_event();
+
+CREATE FUNCTION "user".notify_entity_event() RETURNS TRIGGER AS $$
+BEGIN
+  PERFORM pg_notify('user_entity_event', row_to_json(NEW)::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_entity_event
+  AFTER INSERT ON "user".entity_event
+  FOR EACH ROW EXECUTE PROCEDURE "user".notify_entity_event();
@@ -0,0 +1,15 @@
+CREATE OR REPLACE FUNCTION "user".record_entity_event() RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    INSERT INTO "user".entity_event (type, time, user_id, entity_id, role)
+    VALUES (CASE WHEN NEW.role_updated THEN 3 ELSE 1 END, lower(NEW.transaction_period),
+      NEW.user_id, NEW.entity_id, NEW.role);
+  ELSIF upper(OLD.transaction_period) = 'infinity'
+    AND upper(NEW.transaction_period) <> 'infinity'
+    AND NOT NEW.superseded THEN
+    INSERT INTO "user".entity_event (type, time, user_id, entity_id, role)
+    VALUES (2, upper(NEW.transaction_period), NEW.user_id, NEW.entity_id, NEW.role);
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,4 @@
+DROP TRIGGER assign_entity_event_revision ON "user".entity_event;
+DROP FUNCTION "user".assign_entity_event_revision();
+ALTER TABLE "user".entity_event
+  ALTER COLUMN revision SET DEFAULT nextval('"user".entity_event_revision_seq');
@@ -0,0 +1,21 @@
+-- revisions are assigned in commit order: each transaction recording an event takes the
+-- revision lock (advisory lock space 3) before drawing the event's revision and holds it until
+-- it commits, so no event commits with a lower revision than one already committed, and a
+-- watch resuming from a revision doesn't skip any events
+--
+-- only the latest 100000 events are retained for watches resuming from a revision; older ones
+-- are deleted as new ones are recorded
+ALTER TABLE "user".entity_event ALTER COLUMN revision DROP DEFAULT;
+
+CREATE FUNCTION "user".assign_entity_event_revision() RETURNS TRIGGER AS $$
+BEGIN
+  PERFORM pg_advisory_xact_lock(3, 0);
+  NEW.revision := nextval('"user".entity_event_revision_seq');
+  DELETE FROM "user".entity_event WHERE revision <= NEW.revision - 100000;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER assign_entity_event_revision
+  BEFORE INSERT ON "user".entity_event
+  FOR EACH ROW EXECUTE PROCEDURE "user".assign_entity_event_revision();
@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elixirhealth/user/pkg/server/storage/postgres/migrations"
+	"github.com/mattes/migrate"
+	_ "github.com/mattes/migrate/database/postgres" // registers the postgres migrate driver
+	bindata "github.com/mattes/migrate/source/go-bindata"
+	"go.uber.org/zap"
+)
+
+// Migrator applies and reverts the migrations of the user schema.
+type Migrator interface {
+	// Up applies the migrations not yet applied.
+	Up() error
+
+	// Down reverts every applied migration.
+	Down() error
+}
+
+type migrator struct {
+	dbURL  string
+	logger *zap.Logger
+}
+
+// NewMigrator returns a Migrator of the Postgres DB at the given dbURL.
+func NewMigrator(dbURL string, logger *zap.Logger) Migrator {
+	return &migrator{dbURL: dbURL, logger: logger}
+}
+
+func (m *migrator) Up() error {
+	m.logger.Info("migrating up")
+	return m.run((*migrate.Migrate).Up)
+}
+
+func (m *migrator) Down() error {
+	m.logger.Info("migrating down")
+	return m.run((*migrate.Migrate).Down)
+}
+
+// run applies the migration step to the DB, where no change isn't an error.
+func (m *migrator) run(step func(*migrate.Migrate) error) error {
+	source, err := bindata.WithInstance(
+		bindata.Resource(migrations.AssetNames(), migrations.Asset),
+	)
+	if err != nil {
+		return err
+	}
+	inner, err := migrate.NewWithSourceInstance("go-bindata", source, m.dbURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _, _ = inner.Close() }()
+	inner.Log = &migrateLogger{logger: m.logger}
+	if err := step(inner); err != nil && err != migrate.ErrNoChange {
+		return err
+	}
+	return nil
+}
+
+// migrateLogger logs the messages of the migrate package.
+type migrateLogger struct {
+	logger *zap.Logger
+}
+
+func (l *migrateLogger) Printf(format string, v ...interface{}) {
+	l.logger.Info(strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+func (l *migrateLogger) Verbose() bool {
+	return false
+}
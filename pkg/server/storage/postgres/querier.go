@@ -0,0 +1,78 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// querier runs the queries built by the storer, so they can be faked in tests.
+type querier interface {
+	SelectQueryContext(ctx context.Context, b sq.SelectBuilder) (queryRows, error)
+	SelectQueryRowContext(ctx context.Context, b sq.SelectBuilder) sq.RowScanner
+	InsertExecContext(ctx context.Context, b sq.InsertBuilder) (sql.Result, error)
+	UpdateExecContext(ctx context.Context, b sq.UpdateBuilder) (sql.Result, error)
+	DeleteExecContext(ctx context.Context, b sq.DeleteBuilder) (sql.Result, error)
+}
+
+// queryRows is the result of a select query, which *sql.Rows implements.
+type queryRows interface {
+	Scan(dest ...interface{}) error
+	Next() bool
+	Close() error
+	Err() error
+}
+
+type querierImpl struct{}
+
+func newQuerier() querier {
+	return &querierImpl{}
+}
+
+func (q *querierImpl) SelectQueryContext(
+	ctx context.Context, b sq.SelectBuilder,
+) (queryRows, error) {
+	return b.QueryContext(ctx)
+}
+
+func (q *querierImpl) SelectQueryRowContext(
+	ctx context.Context, b sq.SelectBuilder,
+) sq.RowScanner {
+	return b.QueryRowContext(ctx)
+}
+
+func (q *querierImpl) InsertExecContext(
+	ctx context.Context, b sq.InsertBuilder,
+) (sql.Result, error) {
+	return b.ExecContext(ctx)
+}
+
+func (q *querierImpl) UpdateExecContext(
+	ctx context.Context, b sq.UpdateBuilder,
+) (sql.Result, error) {
+	return b.ExecContext(ctx)
+}
+
+func (q *querierImpl) DeleteExecContext(
+	ctx context.Context, b sq.DeleteBuilder,
+) (sql.Result, error) {
+	return b.ExecContext(ctx)
+}
+
+// colDest is a column to select along with the destination to scan its value into.
+type colDest struct {
+	col  string
+	dest interface{}
+}
+
+// splitColDests returns the columns and destinations of the given colDests, with capacity for
+// nExtra more destinations.
+func splitColDests(nExtra int, cds []*colDest) ([]string, []interface{}) {
+	cols := make([]string, len(cds))
+	dests := make([]interface{}, len(cds), len(cds)+nExtra)
+	for i, cd := range cds {
+		cols[i], dests[i] = cd.col, cd.dest
+	}
+	return cols, dests
+}
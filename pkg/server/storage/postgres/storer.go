@@ -3,42 +3,126 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	sq "github.com/Masterminds/squirrel"
 	errors2 "github.com/drausin/libri/libri/common/errors"
-	bstorage "github.com/elixirhealth/service-base/pkg/server/storage"
 	"github.com/elixirhealth/user/pkg/server/storage"
 	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 const (
-	userSchema  = `"user"`
-	entityTable = "entity"
+	userSchema       = `"user"`
+	entityTable      = "entity"
+	userLimitTable   = "user_limit"
+	entityLimitTable = "entity_limit"
+	profileTable     = "profile"
+	entityEventTable = "entity_event"
 
-	userIDCol   = "user_id"
-	entityIDCol = "entity_id"
+	transactionPeriodCol = "transaction_period"
+	userIDCol            = "user_id"
+	entityIDCol          = "entity_id"
+	maxEntitiesCol       = "max_entities"
+	maxUsersCol          = "max_users"
+	roleCol              = "role"
+	roleUpdatedCol       = "role_updated"
+	supersededCol        = "superseded"
+	displayNameCol       = "display_name"
+	emailCol             = "email"
+	phoneCol             = "phone"
+	localeCol            = "locale"
+	timezoneCol          = "timezone"
+	attributesCol        = "attributes"
+	modifiedTimeCol      = "modified_time"
+	revisionCol          = "revision"
+	typeCol              = "type"
+	timeCol              = "time"
 
 	count = "COUNT(*)"
+
+	// closedTransactionPeriod ends the transaction period of a row as of now
+	closedTransactionPeriod = "tstzrange(lower(transaction_period), NOW(), '[)')"
+
+	// lockSQL takes a transaction-scoped advisory lock on the ID within the given lock space
+	lockSQL = "SELECT pg_advisory_xact_lock($1, hashtext($2))"
+
+	// importingSQL sets whether the current transaction is importing, during which the
+	// triggers don't record the events of the association rows
+	importingSQL = "SELECT set_config('user_entity.importing', $1, true)"
+
+	// entityLockSpace and userLockSpace are the advisory lock spaces of the entity and user IDs;
+	// the entity event trigger takes its revision lock in space 3 (see migration 008)
+	entityLockSpace = 1
+	userLockSpace   = 2
+
+	// uniqueViolation and queryCanceled are the Postgres error codes for inserting a duplicate
+	// key and for a statement canceled on request
+	uniqueViolation = pq.ErrorCode("23505")
+	queryCanceled   = pq.ErrorCode("57014")
+
+	// entityEventChannel is the channel each new association event is sent on as JSON
+	entityEventChannel = "user_entity_event"
+
+	// minListenerReconnect and maxListenerReconnect bound the wait between attempts to
+	// reconnect the event listener
+	minListenerReconnect = 1 * time.Second
+	maxListenerReconnect = 1 * time.Minute
+
+	// addedTime and removedTime are the start and (possibly null) end of a row's transaction
+	// period
+	addedTime   = "lower(transaction_period)"
+	removedTime = "NULLIF(upper(transaction_period), 'infinity')"
+
+	// importedTransactionPeriod and importedClosedTransactionPeriod start the transaction
+	// period of an imported row at its added time and end it at its removed time, respectively
+	importedTransactionPeriod       = "tstzrange(?, 'infinity', '[)')"
+	importedClosedTransactionPeriod = "tstzrange(lower(transaction_period), ?, '[)')"
+
+	// exportOrder orders the rows of each association together, oldest first
+	exportOrder = "user_id, entity_id, lower(transaction_period), row_id"
 )
 
 var (
 	psql = sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 
-	fqEntityTable = userSchema + "." + entityTable
+	fqEntityTable      = userSchema + "." + entityTable
+	fqUserLimitTable   = userSchema + "." + userLimitTable
+	fqEntityLimitTable = userSchema + "." + entityLimitTable
+	fqProfileTable     = userSchema + "." + profileTable
+	fqEntityEventTable = userSchema + "." + entityEventTable
+
+	// profileCols are the user profile columns other than the user ID, in the order they are
+	// scanned
+	profileCols = []string{displayNameCol, emailCol, phoneCol, localeCol, timezoneCol,
+		attributesCol}
+
+	// current restricts to rows whose transaction period has not ended, i.e., that have not
+	// been removed
+	current = sq.Expr("upper(" + transactionPeriodCol + ") = 'infinity'")
 
 	errEmptyDBUrl            = errors.New("empty DB URL")
 	errUnexpectedStorageType = errors.New("unexpected storage type")
 )
 
 type storer struct {
-	params  *storage.Parameters
-	db      *sql.DB
-	dbCache sq.DBProxyContext
-	qr      bstorage.Querier
-	logger  *zap.Logger
+	params   *storage.Parameters
+	dbURL    string
+	db       *sql.DB
+	dbCache  sq.DBProxyContext
+	qr       querier
+	feed     *storage.Broadcaster
+	listener *pq.Listener
+	listenMu sync.Mutex
+	logger   *zap.Logger
 }
 
 // New creates a new storage.Storer backed by a Postgres DB at the given dbURL.
@@ -46,122 +130,1285 @@ func New(dbURL string, params *storage.Parameters, logger *zap.Logger) (storage.
 	if dbURL == "" {
 		return nil, errEmptyDBUrl
 	}
-	if params.Type != bstorage.Postgres {
+	if params.Type != storage.Postgres {
 		return nil, errUnexpectedStorageType
 	}
 	db, err := sql.Open("postgres", dbURL)
 	errors2.MaybePanic(err)
 	return &storer{
 		params:  params,
+		dbURL:   dbURL,
 		db:      db,
 		dbCache: sq.NewStmtCacher(db),
-		qr:      bstorage.NewQuerier(),
+		qr:      newQuerier(),
+		feed:    storage.NewBroadcaster(0),
 		logger:  logger,
 	}, nil
 }
 
-func (s *storer) AddEntity(userID, entityID string) error {
+func (s *storer) AddEntity(
+	ctx context.Context, userID, entityID string, role api.Role, limits *storage.Limits,
+) error {
 	if userID == "" {
 		return api.ErrEmptyUserID
 	}
 	if entityID == "" {
 		return api.ErrEmptyEntityID
 	}
-	q := psql.RunWith(s.dbCache).
-		Insert(fqEntityTable).
-		SetMap(getSQLValues(userID, entityID))
 	s.logger.Debug("adding entity", logUserEntityID(userID, entityID)...)
-	ctx, cancel := context.WithTimeout(context.Background(), s.params.AddQueryTimeout)
+	ctx, cancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
 	defer cancel()
-	_, err := s.qr.InsertExecContext(ctx, q)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	if err = s.addEntity(ctx, tx, userID, entityID, role, limits); err != nil {
+		s.rollback(tx)
+		return storageErr(ctx, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return storageErr(ctx, err)
+	}
 	s.logger.Debug("added entity", logUserEntityID(userID, entityID)...)
 	return nil
 }
 
-func (s *storer) GetEntities(userID string) ([]string, error) {
+// addEntity checks the limits and inserts the user-entity association within the given
+// transaction.
+func (s *storer) addEntity(
+	ctx context.Context,
+	tx *sql.Tx,
+	userID, entityID string,
+	role api.Role,
+	limits *storage.Limits,
+) error {
+	// serialize concurrent adds for the same entity or user; entity locks are always taken
+	// before user locks so two transactions can't deadlock on each other
+	if _, err := tx.ExecContext(ctx, lockSQL, entityLockSpace, entityID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, lockSQL, userLockSpace, userID); err != nil {
+		return err
+	}
+
+	// check user-entity association doesn't already exist
+	n, err := s.count(ctx, tx, sq.Eq{userIDCol: userID, entityIDCol: entityID},
+		"counted user-entity associations", logUserEntityID(userID, entityID)...)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return storage.ErrUserEntityExists
+	}
+	maxUsers, err := s.getLimit(ctx, tx, fqEntityLimitTable, maxUsersCol,
+		sq.Eq{entityIDCol: entityID}, limits.MaxEntityUsers)
+	if err != nil {
+		return err
+	}
+	nUsers, err := s.count(ctx, tx, sq.Eq{entityIDCol: entityID}, "counted users",
+		zap.String(logEntityID, entityID))
+	if err != nil {
+		return err
+	}
+	if nUsers+1 > maxUsers {
+		return storage.ErrTooManyEntityUsers
+	}
+	maxEntities, err := s.getLimit(ctx, tx, fqUserLimitTable, maxEntitiesCol,
+		sq.Eq{userIDCol: userID}, limits.MaxUserEntities)
+	if err != nil {
+		return err
+	}
+	nEntities, err := s.count(ctx, tx, sq.Eq{userIDCol: userID}, "counted entities",
+		zap.String(logUserID, userID))
+	if err != nil {
+		return err
+	}
+	if nEntities+1 > maxEntities {
+		return storage.ErrTooManyUserEntities
+	}
+
+	q := psql.RunWith(tx).
+		Insert(fqEntityTable).
+		SetMap(getSQLValues(userID, entityID, role))
+	_, err = s.qr.InsertExecContext(ctx, q)
+	return err
+}
+
+func (s *storer) AddEntities(
+	ctx context.Context, as []*storage.Association, limits *storage.Limits,
+) ([]error, error) {
+	s.logger.Debug("adding entities", zap.Int(logNAssociations, len(as)))
+	ctx, cancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	errs, err := s.addEntities(ctx, tx, as, limits)
+	if err != nil {
+		s.rollback(tx)
+		return nil, storageErr(ctx, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, storageErr(ctx, err)
+	}
+	s.logger.Debug("added entities", logAddedEntities(as, errs)...)
+	return errs, nil
+}
+
+// addEntities checks the limits for and inserts the user-entity associations within the given
+// transaction, returning the error for each association that couldn't be added.
+func (s *storer) addEntities(
+	ctx context.Context, tx *sql.Tx, as []*storage.Association, limits *storage.Limits,
+) ([]error, error) {
+	userIDs, entityIDs := distinctIDs(as)
+
+	// take the locks in the same (entities then users, each sorted) order as every other add
+	for _, entityID := range entityIDs {
+		if _, err := tx.ExecContext(ctx, lockSQL, entityLockSpace, entityID); err != nil {
+			return nil, err
+		}
+	}
+	for _, userID := range userIDs {
+		if _, err := tx.ExecContext(ctx, lockSQL, userLockSpace, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	counts, err := s.getAssociationCounts(ctx, tx, userIDs, entityIDs)
+	if err != nil {
+		return nil, err
+	}
+	errs := counts.CheckAdds(as, limits)
+
+	q := psql.RunWith(tx).
+		Insert(fqEntityTable).
+		Columns(userIDCol, entityIDCol, roleCol)
+	nAdded := 0
+	for i, a := range as {
+		if errs[i] == nil {
+			q = q.Values(a.UserID, a.EntityID, int32(a.Role))
+			nAdded++
+		}
+	}
+	if nAdded == 0 {
+		return errs, nil
+	}
+	if _, err := s.qr.InsertExecContext(ctx, q); err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// getAssociationCounts gets the current associations of the given users and the number of
+// current associations and limit overrides of the given users and entities.
+func (s *storer) getAssociationCounts(
+	ctx context.Context, tx *sql.Tx, userIDs, entityIDs []string,
+) (*storage.AssociationCounts, error) {
+	counts := storage.NewAssociationCounts()
+	existing, err := s.getAssociations(ctx, tx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range existing {
+		counts.Existing[a.ID()] = struct{}{}
+		counts.UserEntities[a.UserID]++
+	}
+	q := psql.RunWith(tx).
+		Select(entityIDCol, count).
+		From(fqEntityTable).
+		Where(sq.Eq{entityIDCol: entityIDs}).
+		Where(current).
+		GroupBy(entityIDCol)
+	if err := s.selectIDInts(ctx, q, counts.EntityUsers); err != nil {
+		return nil, err
+	}
+	q = psql.RunWith(tx).
+		Select(userIDCol, maxEntitiesCol).
+		From(fqUserLimitTable).
+		Where(sq.Eq{userIDCol: userIDs})
+	if err := s.selectIDInts(ctx, q, counts.MaxUserEntities); err != nil {
+		return nil, err
+	}
+	q = psql.RunWith(tx).
+		Select(entityIDCol, maxUsersCol).
+		From(fqEntityLimitTable).
+		Where(sq.Eq{entityIDCol: entityIDs})
+	if err := s.selectIDInts(ctx, q, counts.MaxEntityUsers); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// selectIDInts runs a query selecting (ID, integer) rows, storing them in the given map.
+func (s *storer) selectIDInts(ctx context.Context, q sq.SelectBuilder, dest map[string]int) error {
+	rows, err := s.qr.SelectQueryContext(ctx, q)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id string
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return err
+		}
+		dest[id] = n
+	}
+	return rows.Err()
+}
+
+func (s *storer) rollback(tx *sql.Tx) {
+	if err := tx.Rollback(); err != nil {
+		s.logger.Error("error rolling back transaction", zap.Error(err))
+	}
+}
+
+func (s *storer) RemoveEntity(ctx context.Context, userID, entityID string) error {
 	if userID == "" {
-		return nil, api.ErrEmptyUserID
+		return api.ErrEmptyUserID
+	}
+	if entityID == "" {
+		return api.ErrEmptyEntityID
+	}
+	q := psql.RunWith(s.dbCache).
+		Update(fqEntityTable).
+		Set(transactionPeriodCol, sq.Expr(closedTransactionPeriod)).
+		Where(sq.Eq{userIDCol: userID, entityIDCol: entityID}).
+		Where(current)
+	s.logger.Debug("removing entity", logUserEntityID(userID, entityID)...)
+	ctx, cancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
+	defer cancel()
+	r, err := s.qr.UpdateExecContext(ctx, q)
+	if err != nil {
+		return storageErr(ctx, err)
+	}
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return storage.ErrUserEntityNotExists
+	}
+	s.logger.Debug("removed entity", logUserEntityID(userID, entityID)...)
+	return nil
+}
+
+func (s *storer) GetEntities(
+	ctx context.Context, userID string, page *storage.Page,
+) ([]*storage.Association, string, error) {
+	if userID == "" {
+		return nil, "", api.ErrEmptyUserID
+	}
+	return s.getEntities(ctx, userID, current, page)
+}
+
+func (s *storer) GetEntitiesAsOf(
+	ctx context.Context, userID string, asOf time.Time, page *storage.Page,
+) ([]*storage.Association, string, error) {
+	if userID == "" {
+		return nil, "", api.ErrEmptyUserID
 	}
-	cols, _, _ := prepEntityScan()
+	period := sq.Expr(transactionPeriodCol+" @> ?::TIMESTAMPTZ", asOf)
+	return s.getEntities(ctx, userID, period, page)
+}
+
+// getEntities returns the page of the user's associations in the given period, ordered by
+// entity ID. Pages are keyset-paged on the entity ID, with one extra row selected to tell
+// whether there is a next page.
+func (s *storer) getEntities(
+	ctx context.Context, userID string, period sq.Sqlizer, page *storage.Page,
+) ([]*storage.Association, string, error) {
+	after, err := page.ParseKeysetToken()
+	if err != nil {
+		return nil, "", err
+	}
+	cols, _, _ := prepAssociationScan()
 	q := psql.RunWith(s.dbCache).
 		Select(cols...).
 		From(fqEntityTable).
-		Where(sq.Eq{userIDCol: userID})
+		Where(sq.Eq{userIDCol: userID}).
+		Where(period).
+		OrderBy(entityIDCol)
+	if after != "" {
+		q = q.Where(sq.Expr(entityIDCol+" > ?", after))
+	}
+	if page.Limited() {
+		q = q.Limit(uint64(page.Size + 1))
+	}
 	s.logger.Debug("getting entities", logGettingEntities(q, userID)...)
-	ctx, cancel := context.WithTimeout(context.Background(), s.params.GetQueryTimeout)
+	ctx, cancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
 	defer cancel()
 	rows, err := s.qr.SelectQueryContext(ctx, q)
 	if err != nil {
-		return nil, err
+		return nil, "", storageErr(ctx, err)
 	}
-	entityIDs := make([]string, 0)
+	as := make([]*storage.Association, 0)
 	for rows.Next() {
-		_, dest, create := prepEntityScan()
+		_, dest, create := prepAssociationScan()
 		if err := rows.Scan(dest...); err != nil {
-			return nil, err
+			return nil, "", err
 		}
-		entityIDs = append(entityIDs, create())
+		as = append(as, create())
 	}
 	if err := rows.Err(); err != nil {
+		return nil, "", storageErr(ctx, err)
+	}
+	next := ""
+	if page.Limited() && len(as) > page.Size {
+		as = as[:page.Size]
+		next = storage.NewKeysetToken(as[page.Size-1].EntityID)
+	}
+	s.logger.Debug("got entities", logGotEntities(userID, as)...)
+	return as, next, nil
+}
+
+func (s *storer) GetEntitiesBatch(
+	ctx context.Context, userIDs []string,
+) (map[string][]string, error) {
+	entityIDs := make(map[string][]string, len(userIDs))
+	for _, userID := range userIDs {
+		if userID == "" {
+			return nil, api.ErrEmptyUserID
+		}
+		entityIDs[userID] = make([]string, 0)
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
+	defer cancel()
+	as, err := s.getAssociations(ctx, s.dbCache, userIDs)
+	if err != nil {
 		return nil, err
 	}
-	s.logger.Debug("got entities", logGotEntities(userID, entityIDs)...)
+	for _, a := range as {
+		entityIDs[a.UserID] = append(entityIDs[a.UserID], a.EntityID)
+	}
+	s.logger.Debug("got entities for users", logGotEntitiesBatch(entityIDs)...)
 	return entityIDs, nil
 }
 
-func (s *storer) CountEntities(userID string) (int, error) {
+// getAssociations gets the current associations of the given users.
+func (s *storer) getAssociations(
+	ctx context.Context, runner sq.BaseRunner, userIDs []string,
+) ([]*storage.Association, error) {
+	cols, _, _ := prepAssociationScan()
+	q := psql.RunWith(runner).
+		Select(cols...).
+		From(fqEntityTable).
+		Where(sq.Eq{userIDCol: userIDs}).
+		Where(current)
+	rows, err := s.qr.SelectQueryContext(ctx, q)
+	if err != nil {
+		return nil, storageErr(ctx, err)
+	}
+	as := make([]*storage.Association, 0)
+	for rows.Next() {
+		_, dest, create := prepAssociationScan()
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		as = append(as, create())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, storageErr(ctx, err)
+	}
+	return as, nil
+}
+
+func (s *storer) GetUsers(
+	ctx context.Context, entityID string, page *storage.Page,
+) ([]string, string, error) {
+	if entityID == "" {
+		return nil, "", api.ErrEmptyEntityID
+	}
+	after, err := page.ParseKeysetToken()
+	if err != nil {
+		return nil, "", err
+	}
+	cols, _, _ := prepUserScan()
+	q := psql.RunWith(s.dbCache).
+		Select(cols...).
+		From(fqEntityTable).
+		Where(sq.Eq{entityIDCol: entityID}).
+		Where(current).
+		OrderBy(userIDCol)
+	if after != "" {
+		q = q.Where(sq.Expr(userIDCol+" > ?", after))
+	}
+	if page.Limited() {
+		q = q.Limit(uint64(page.Size + 1))
+	}
+	s.logger.Debug("getting users", logGettingUsers(q, entityID)...)
+	ctx, cancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
+	defer cancel()
+	rows, err := s.qr.SelectQueryContext(ctx, q)
+	if err != nil {
+		return nil, "", storageErr(ctx, err)
+	}
+	userIDs := make([]string, 0)
+	for rows.Next() {
+		_, dest, create := prepUserScan()
+		if err := rows.Scan(dest...); err != nil {
+			return nil, "", err
+		}
+		userIDs = append(userIDs, create())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", storageErr(ctx, err)
+	}
+	next := ""
+	if page.Limited() && len(userIDs) > page.Size {
+		userIDs = userIDs[:page.Size]
+		next = storage.NewKeysetToken(userIDs[page.Size-1])
+	}
+	s.logger.Debug("got users", logGotUsers(entityID, userIDs)...)
+	return userIDs, next, nil
+}
+
+func (s *storer) CountEntities(ctx context.Context, userID string) (int, error) {
 	if userID == "" {
 		return 0, api.ErrEmptyUserID
 	}
-	return s.count(sq.Eq{userIDCol: userID}, "counted entities", zap.String(logUserID, userID))
+	ctx, cancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
+	defer cancel()
+	return s.count(ctx, s.dbCache, sq.Eq{userIDCol: userID}, "counted entities",
+		zap.String(logUserID, userID))
 }
 
-func (s *storer) CountUsers(entityID string) (int, error) {
+func (s *storer) CountUsers(ctx context.Context, entityID string) (int, error) {
 	if entityID == "" {
 		return 0, api.ErrEmptyEntityID
 	}
-	return s.count(sq.Eq{entityIDCol: entityID}, "counted users",
+	ctx, cancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
+	defer cancel()
+	return s.count(ctx, s.dbCache, sq.Eq{entityIDCol: entityID}, "counted users",
 		zap.String(logEntityID, entityID))
 }
 
-func (s *storer) Close() error {
-	return s.db.Close()
+func (s *storer) GetHistory(
+	ctx context.Context, userID, entityID string,
+) ([]*storage.AssociationEvent, error) {
+	if userID == "" && entityID == "" {
+		return nil, api.ErrEmptyUserAndEntityID
+	}
+	pred := sq.Eq{}
+	if userID != "" {
+		pred[userIDCol] = userID
+	}
+	if entityID != "" {
+		pred[entityIDCol] = entityID
+	}
+	cols, _, _ := prepHistoryScan()
+	q := psql.RunWith(s.dbCache).
+		Select(cols...).
+		From(fqEntityTable).
+		Where(pred)
+	s.logger.Debug("getting history", logGettingHistory(q, userID, entityID)...)
+	ctx, cancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
+	defer cancel()
+	rows, err := s.qr.SelectQueryContext(ctx, q)
+	if err != nil {
+		return nil, storageErr(ctx, err)
+	}
+	events := make([]*storage.AssociationEvent, 0)
+	for rows.Next() {
+		_, dest, create := prepHistoryScan()
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		events = append(events, create()...)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, storageErr(ctx, err)
+	}
+	storage.SortEvents(events)
+	s.logger.Debug("got history", logGotHistory(userID, entityID, events)...)
+	return events, nil
+}
+
+func (s *storer) UpdateRole(ctx context.Context, userID, entityID string, role api.Role) error {
+	if userID == "" {
+		return api.ErrEmptyUserID
+	}
+	if entityID == "" {
+		return api.ErrEmptyEntityID
+	}
+	s.logger.Debug("updating role", logUpdateRole(userID, entityID, role)...)
+	ctx, cancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = s.updateRole(ctx, tx, userID, entityID, role); err != nil {
+		s.rollback(tx)
+		return storageErr(ctx, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return storageErr(ctx, err)
+	}
+	s.logger.Debug("updated role", logUpdateRole(userID, entityID, role)...)
+	return nil
+}
+
+// updateRole closes the current association's row as superseded and inserts one with the given
+// role in its place within the given transaction. Both happen as of the same transaction time,
+// so the association is active throughout.
+func (s *storer) updateRole(
+	ctx context.Context, tx *sql.Tx, userID, entityID string, role api.Role,
+) error {
+	pred := sq.Eq{userIDCol: userID, entityIDCol: entityID}
+	q := psql.RunWith(tx).
+		Select(roleCol).
+		From(fqEntityTable).
+		Where(pred).
+		Where(current).
+		Suffix("FOR UPDATE")
+	row := s.qr.SelectQueryRowContext(ctx, q)
+	var prevRole int
+	if err := row.Scan(&prevRole); err == sql.ErrNoRows {
+		return storage.ErrUserEntityNotExists
+	} else if err != nil {
+		return err
+	}
+	if api.Role(prevRole) == role {
+		return nil
+	}
+	uq := psql.RunWith(tx).
+		Update(fqEntityTable).
+		Set(transactionPeriodCol, sq.Expr(closedTransactionPeriod)).
+		Set(supersededCol, true).
+		Where(pred).
+		Where(current)
+	if _, err := s.qr.UpdateExecContext(ctx, uq); err != nil {
+		return err
+	}
+	values := getSQLValues(userID, entityID, role)
+	values[roleUpdatedCol] = true
+	iq := psql.RunWith(tx).
+		Insert(fqEntityTable).
+		SetMap(values)
+	_, err := s.qr.InsertExecContext(ctx, iq)
+	return err
+}
+
+func (s *storer) PutUser(ctx context.Context, u *api.UserProfile) error {
+	if u.UserId == "" {
+		return api.ErrEmptyUserID
+	}
+	values, err := getProfileSQLValues(u)
+	if err != nil {
+		return err
+	}
+	values[userIDCol] = u.UserId
+	updates := make([]string, 0, len(profileCols)+1)
+	for _, col := range append(profileCols, modifiedTimeCol) {
+		updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", col, col))
+	}
+	q := psql.RunWith(s.dbCache).
+		Insert(fqProfileTable).
+		SetMap(values).
+		Suffix(fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", userIDCol,
+			strings.Join(updates, ", ")))
+	ctx, cancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
+	defer cancel()
+	if _, err := s.qr.InsertExecContext(ctx, q); err != nil {
+		return storageErr(ctx, err)
+	}
+	s.logger.Debug("put user", logUser(u)...)
+	return nil
+}
+
+func (s *storer) GetUser(ctx context.Context, userID string) (*api.UserProfile, error) {
+	if userID == "" {
+		return nil, api.ErrEmptyUserID
+	}
+	q := psql.RunWith(s.dbCache).
+		Select(profileCols...).
+		From(fqProfileTable).
+		Where(sq.Eq{userIDCol: userID})
+	ctx, cancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
+	defer cancel()
+	u, err := s.getUser(ctx, q, userID)
+	if err != nil {
+		return nil, storageErr(ctx, err)
+	}
+	s.logger.Debug("got user", logUser(u)...)
+	return u, nil
+}
+
+func (s *storer) UpdateUser(
+	ctx context.Context, u *api.UserProfile,
+) (*api.UserProfile, error) {
+	if u.UserId == "" {
+		return nil, api.ErrEmptyUserID
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := s.updateUser(ctx, tx, u)
+	if err != nil {
+		s.rollback(tx)
+		return nil, storageErr(ctx, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, storageErr(ctx, err)
+	}
+	s.logger.Debug("updated user", logUser(updated)...)
+	return updated, nil
+}
+
+// updateUser locks the user's profile row, merges the update into it, and writes it back within
+// the given transaction.
+func (s *storer) updateUser(
+	ctx context.Context, tx *sql.Tx, u *api.UserProfile,
+) (*api.UserProfile, error) {
+	pred := sq.Eq{userIDCol: u.UserId}
+	q := psql.RunWith(tx).
+		Select(profileCols...).
+		From(fqProfileTable).
+		Where(pred).
+		Suffix("FOR UPDATE")
+	updated, err := s.getUser(ctx, q, u.UserId)
+	if err != nil {
+		return nil, err
+	}
+	storage.MergeUser(updated, u)
+	values, err := getProfileSQLValues(updated)
+	if err != nil {
+		return nil, err
+	}
+	uq := psql.RunWith(tx).
+		Update(fqProfileTable).
+		SetMap(values).
+		Where(pred)
+	if _, err := s.qr.UpdateExecContext(ctx, uq); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// getUser scans the profile columns selected by the query into the user's profile.
+func (s *storer) getUser(
+	ctx context.Context, q sq.SelectBuilder, userID string,
+) (*api.UserProfile, error) {
+	u := &api.UserProfile{UserId: userID}
+	var attributes []byte
+	row := s.qr.SelectQueryRowContext(ctx, q)
+	err := row.Scan(&u.DisplayName, &u.Email, &u.Phone, &u.Locale, &u.Timezone, &attributes)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrUserNotExists
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(attributes, &u.Attributes); err != nil {
+		return nil, err
+	}
+	if len(u.Attributes) == 0 {
+		u.Attributes = nil
+	}
+	return u, nil
+}
+
+func (s *storer) Watch(
+	ctx context.Context,
+	userID, entityID string,
+	fromRevision uint64,
+	send func(*storage.AssociationEvent) error,
+) error {
+	if userID == "" && entityID == "" {
+		return api.ErrEmptyUserAndEntityID
+	}
+	if err := s.listen(); err != nil {
+		return err
+	}
+	s.logger.Debug("watching associations", logWatch(userID, entityID, fromRevision)...)
+
+	// subscribe before getting the backlog so no events fall between the two
+	sub := s.feed.Subscribe(userID, entityID)
+	defer s.feed.Unsubscribe(sub)
+	backlog, err := s.getEvents(ctx, userID, entityID, fromRevision)
+	if err != nil {
+		return err
+	}
+	return sub.Stream(ctx, fromRevision, backlog, send)
+}
+
+// SubscribeChanges returns a Subscription to the events of all associations sent by the
+// triggers on the entity event table, whichever server made them.
+func (s *storer) SubscribeChanges() (*storage.Subscription, error) {
+	if err := s.listen(); err != nil {
+		return nil, err
+	}
+	return s.feed.Subscribe("", ""), nil
+}
+
+// UnsubscribeChanges ends the Subscription.
+func (s *storer) UnsubscribeChanges(sub *storage.Subscription) {
+	s.feed.Unsubscribe(sub)
+}
+
+// listen starts listening for the association events sent by the triggers on the entity event
+// table, if it isn't already, and forwarding them to the feed.
+func (s *storer) listen() error {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+	if s.listener != nil {
+		return nil
+	}
+	listener := pq.NewListener(s.dbURL, minListenerReconnect, maxListenerReconnect,
+		func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				s.logger.Error("event listener error", zap.Error(err))
+			}
+		})
+	if err := listener.Listen(entityEventChannel); err != nil {
+		_ = listener.Close()
+		return err
+	}
+	s.listener = listener
+	go s.forward(listener.Notify)
+	return nil
+}
+
+// forward passes the events from the notifications to the feed until the listener is closed. A
+// nil notification means the listener reconnected and may have missed events, so it interrupts
+// the current watches.
+func (s *storer) forward(notify <-chan *pq.Notification) {
+	for n := range notify {
+		if n == nil {
+			s.feed.Interrupt()
+			continue
+		}
+		ee := &entityEvent{}
+		if err := json.Unmarshal([]byte(n.Extra), ee); err != nil {
+			s.logger.Error("unable to parse association event", zap.Error(err))
+			s.feed.Interrupt()
+			continue
+		}
+		s.feed.Forward(ee.event())
+	}
 }
 
-func (s storer) count(pred interface{}, logMsg string, fields ...zapcore.Field) (int, error) {
+// getEvents returns the association events for the user ID and/or entity ID after fromRevision,
+// ordered by revision, or ErrFutureRevision if fromRevision is after the latest event and
+// ErrRevisionCompacted if some of the events after it are no longer retained. A zero
+// fromRevision returns none.
+//
+// The entity event trigger assigns revisions under a lock held until the transaction commits,
+// so events commit in revision order and a watch resuming from a revision doesn't skip any. It
+// also retains only the latest events (see migration 008).
+func (s *storer) getEvents(
+	ctx context.Context, userID, entityID string, fromRevision uint64,
+) ([]*storage.AssociationEvent, error) {
+	if fromRevision == 0 {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.params.GetQueryTimeout)
+	defer cancel()
+	var oldest, latest uint64
+	lq := psql.RunWith(s.dbCache).
+		Select("COALESCE(MIN("+revisionCol+"), 0)", "COALESCE(MAX("+revisionCol+"), 0)").
+		From(fqEntityEventTable)
+	if err := s.qr.SelectQueryRowContext(ctx, lq).Scan(&oldest, &latest); err != nil {
+		return nil, storageErr(ctx, err)
+	}
+	if fromRevision > latest {
+		return nil, storage.ErrFutureRevision
+	}
+	if fromRevision+1 < oldest {
+		return nil, storage.ErrRevisionCompacted
+	}
+	pred := sq.Eq{}
+	if userID != "" {
+		pred[userIDCol] = userID
+	}
+	if entityID != "" {
+		pred[entityIDCol] = entityID
+	}
+	cols, _, _ := prepEventScan()
 	q := psql.RunWith(s.dbCache).
+		Select(cols...).
+		From(fqEntityEventTable).
+		Where(pred).
+		Where(sq.Expr(revisionCol+" > ?", fromRevision)).
+		OrderBy(revisionCol)
+	rows, err := s.qr.SelectQueryContext(ctx, q)
+	if err != nil {
+		return nil, storageErr(ctx, err)
+	}
+	events := make([]*storage.AssociationEvent, 0)
+	for rows.Next() {
+		_, dest, create := prepEventScan()
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		events = append(events, create())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, storageErr(ctx, err)
+	}
+	return events, nil
+}
+
+func (s *storer) ExportAssociations(
+	ctx context.Context, send func([]*storage.AssociationVersion) error,
+) error {
+	cols, _, _ := prepVersionScan()
+	q := psql.RunWith(s.db).
+		Select(cols...).
+		From(fqEntityTable).
+		OrderBy(exportOrder)
+	s.logger.Debug("exporting associations")
+	rows, err := s.qr.SelectQueryContext(ctx, q)
+	if err != nil {
+		return storageErr(ctx, err)
+	}
+	defer func() { _ = rows.Close() }()
+	n := 0
+	var group []*storage.AssociationVersion
+	for rows.Next() {
+		_, dest, create := prepVersionScan()
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		ue := create()
+		if len(group) > 0 && (group[0].UserID != ue.UserID || group[0].EntityID != ue.EntityID) {
+			if err := send(group); err != nil {
+				return err
+			}
+			n++
+			group = nil
+		}
+		group = append(group, ue)
+	}
+	if err := rows.Err(); err != nil {
+		return storageErr(ctx, err)
+	}
+	if len(group) > 0 {
+		if err := send(group); err != nil {
+			return err
+		}
+		n++
+	}
+	s.logger.Debug("exported associations", zap.Int(logNAssociations, n))
+	return nil
+}
+
+func (s *storer) ImportAssociation(
+	ctx context.Context, versions []*storage.AssociationVersion, overwrite bool,
+) (bool, error) {
+	if len(versions) == 0 {
+		return false, nil
+	}
+	userID, entityID := versions[0].UserID, versions[0].EntityID
+	s.logger.Debug("importing association", logUserEntityID(userID, entityID)...)
+	ctx, cancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	exists, err := s.importAssociation(ctx, tx, versions, overwrite)
+	if err != nil {
+		s.rollback(tx)
+		return false, storageErr(ctx, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return false, storageErr(ctx, err)
+	}
+	s.logger.Debug("imported association", logImportedAssociation(versions, exists)...)
+	return exists, nil
+}
+
+// importAssociation inserts a row for each version of the association within the given
+// transaction, after deleting any existing rows if overwrite is set. Each row is inserted as
+// current and then closed if the version was removed. The triggers skip the rows' events while
+// importing, and a single event with the association's current state is recorded instead.
+func (s *storer) importAssociation(
+	ctx context.Context, tx *sql.Tx, versions []*storage.AssociationVersion, overwrite bool,
+) (bool, error) {
+	userID, entityID := versions[0].UserID, versions[0].EntityID
+	if _, err := tx.ExecContext(ctx, lockSQL, entityLockSpace, entityID); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, lockSQL, userLockSpace, userID); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, importingSQL, "on"); err != nil {
+		return false, err
+	}
+	pred := sq.Eq{userIDCol: userID, entityIDCol: entityID}
+	cq := psql.RunWith(tx).
 		Select(count).
 		From(fqEntityTable).
 		Where(pred)
-	ctx, cancel := context.WithTimeout(context.Background(), s.params.GetQueryTimeout)
+	var n int
+	if err := s.qr.SelectQueryRowContext(ctx, cq).Scan(&n); err != nil {
+		return false, err
+	}
+	exists := n > 0
+	if exists && !overwrite {
+		return false, storage.ErrUserEntityExists
+	}
+	if exists {
+		dq := psql.RunWith(tx).
+			Delete(fqEntityTable).
+			Where(pred)
+		if _, err := s.qr.DeleteExecContext(ctx, dq); err != nil {
+			return false, err
+		}
+	}
+	for _, ue := range versions {
+		values := getSQLValues(userID, entityID, ue.Role)
+		values[transactionPeriodCol] = sq.Expr(importedTransactionPeriod, ue.AddedTime)
+		values[roleUpdatedCol] = ue.RoleUpdated
+		iq := psql.RunWith(tx).
+			Insert(fqEntityTable).
+			SetMap(values)
+		if _, err := s.qr.InsertExecContext(ctx, iq); err != nil {
+			return false, err
+		}
+		if !ue.Removed {
+			continue
+		}
+		uq := psql.RunWith(tx).
+			Update(fqEntityTable).
+			Set(transactionPeriodCol,
+				sq.Expr(importedClosedTransactionPeriod, ue.RemovedTime)).
+			Set(supersededCol, ue.Superseded).
+			Where(pred).
+			Where(current)
+		if _, err := s.qr.UpdateExecContext(ctx, uq); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, importingSQL, "off"); err != nil {
+		return false, err
+	}
+	e := storage.ImportedEvent(versions)
+	values := getSQLValues(userID, entityID, e.Role)
+	values[typeCol] = int32(e.Type)
+	values[timeCol] = e.Time
+	eq := psql.RunWith(tx).
+		Insert(fqEntityEventTable).
+		SetMap(values)
+	if _, err := s.qr.InsertExecContext(ctx, eq); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (s *storer) Close() error {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+	if s.listener != nil {
+		if err := s.listener.Close(); err != nil {
+			return err
+		}
+		s.listener = nil
+	}
+	return s.db.Close()
+}
+
+func (s *storer) SetUserLimit(ctx context.Context, userID string, maxEntities int) error {
+	if userID == "" {
+		return api.ErrEmptyUserID
+	}
+	err := s.setLimit(ctx, fqUserLimitTable, userIDCol, maxEntitiesCol, userID, maxEntities)
+	if err != nil {
+		return err
+	}
+	s.logger.Debug("set user limit", logSetLimit(logUserID, userID, maxEntities)...)
+	return nil
+}
+
+func (s *storer) SetEntityLimit(ctx context.Context, entityID string, maxUsers int) error {
+	if entityID == "" {
+		return api.ErrEmptyEntityID
+	}
+	err := s.setLimit(ctx, fqEntityLimitTable, entityIDCol, maxUsersCol, entityID, maxUsers)
+	if err != nil {
+		return err
+	}
+	s.logger.Debug("set entity limit", logSetLimit(logEntityID, entityID, maxUsers)...)
+	return nil
+}
+
+// setLimit upserts the limit override for the ID or, if max is zero, deletes it.
+func (s *storer) setLimit(
+	ctx context.Context, fqTable, idCol, maxCol, id string, max int,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, s.params.AddQueryTimeout)
 	defer cancel()
+	if max == 0 {
+		q := psql.RunWith(s.dbCache).
+			Delete(fqTable).
+			Where(sq.Eq{idCol: id})
+		_, err := s.qr.DeleteExecContext(ctx, q)
+		return storageErr(ctx, err)
+	}
+	q := psql.RunWith(s.dbCache).
+		Insert(fqTable).
+		SetMap(map[string]interface{}{idCol: id, maxCol: max}).
+		Suffix(fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s = EXCLUDED.%s", idCol, maxCol,
+			maxCol))
+	_, err := s.qr.InsertExecContext(ctx, q)
+	return storageErr(ctx, err)
+}
+
+// getLimit returns the limit override matching the predicate, if one exists, and the given
+// default otherwise.
+func (s *storer) getLimit(
+	ctx context.Context,
+	runner sq.BaseRunner,
+	fqTable string,
+	maxCol string,
+	pred interface{},
+	dflt int,
+) (int, error) {
+	q := psql.RunWith(runner).
+		Select(maxCol).
+		From(fqTable).
+		Where(pred)
+	row := s.qr.SelectQueryRowContext(ctx, q)
+	var max int
+	if err := row.Scan(&max); err == sql.ErrNoRows {
+		return dflt, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if max == 0 {
+		// zero isn't a valid stored override (see the table constraint), so treat it as none
+		return dflt, nil
+	}
+	return max, nil
+}
+
+func (s *storer) count(
+	ctx context.Context,
+	runner sq.BaseRunner,
+	pred interface{},
+	logMsg string,
+	fields ...zapcore.Field,
+) (int, error) {
+	q := psql.RunWith(runner).
+		Select(count).
+		From(fqEntityTable).
+		Where(pred).
+		Where(current)
 	row := s.qr.SelectQueryRowContext(ctx, q)
 	var count int
 	if err := row.Scan(&count); err != nil {
-		return 0, err
+		return 0, storageErr(ctx, err)
 	}
 	fields = append(fields, zap.Int(logCount, count))
 	s.logger.Debug(logMsg, fields...)
 	return count, nil
 }
 
-func getSQLValues(userID, entityID string) map[string]interface{} {
+// getProfileSQLValues returns the profile column values of the user's profile, with the
+// attributes encoded as a JSON object.
+func getProfileSQLValues(u *api.UserProfile) (map[string]interface{}, error) {
+	attributes := u.Attributes
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	attributesJSON, err := json.Marshal(attributes)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		displayNameCol:  u.DisplayName,
+		emailCol:        u.Email,
+		phoneCol:        u.Phone,
+		localeCol:       u.Locale,
+		timezoneCol:     u.Timezone,
+		attributesCol:   string(attributesJSON),
+		modifiedTimeCol: sq.Expr("NOW()"),
+	}, nil
+}
+
+// storageErr translates Postgres errors into their storage equivalents. A unique violation can
+// only come from the index on current user-entity associations, and a canceled statement means
+// the query's context ended.
+func storageErr(ctx context.Context, err error) error {
+	pqErr, ok := err.(*pq.Error)
+	if !ok {
+		return err
+	}
+	switch pqErr.Code {
+	case uniqueViolation:
+		return storage.ErrUserEntityExists
+	case queryCanceled:
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// distinctIDs returns the distinct, non-empty user and entity IDs of the associations, each in
+// sorted order.
+func distinctIDs(as []*storage.Association) ([]string, []string) {
+	users, entities := make(map[string]struct{}), make(map[string]struct{})
+	for _, a := range as {
+		if a.UserID != "" {
+			users[a.UserID] = struct{}{}
+		}
+		if a.EntityID != "" {
+			entities[a.EntityID] = struct{}{}
+		}
+	}
+	return sortedKeys(users), sortedKeys(entities)
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func getSQLValues(userID, entityID string, role api.Role) map[string]interface{} {
 	return map[string]interface{}{
 		userIDCol:   userID,
 		entityIDCol: entityID,
+		roleCol:     int32(role),
 	}
 }
 
-func prepEntityScan() ([]string, []interface{}, func() string) {
-	var entityID string
-	cols, dests := bstorage.SplitColDests(0, []*bstorage.ColDest{
-		{entityIDCol, &entityID},
+func prepUserScan() ([]string, []interface{}, func() string) {
+	var userID string
+	cols, dests := splitColDests(0, []*colDest{
+		{userIDCol, &userID},
 	})
 	return cols, dests, func() string {
-		entityID = *dests[0].(*string)
-		return entityID
+		userID = *dests[0].(*string)
+		return userID
+	}
+}
+
+func prepAssociationScan() ([]string, []interface{}, func() *storage.Association) {
+	var userID, entityID string
+	var role int32
+	cols, dests := splitColDests(0, []*colDest{
+		{userIDCol, &userID},
+		{entityIDCol, &entityID},
+		{roleCol, &role},
+	})
+	return cols, dests, func() *storage.Association {
+		return &storage.Association{
+			UserID:   *dests[0].(*string),
+			EntityID: *dests[1].(*string),
+			Role:     api.Role(*dests[2].(*int32)),
+		}
+	}
+}
+
+func prepHistoryScan() ([]string, []interface{}, func() []*storage.AssociationEvent) {
+	var userID, entityID string
+	var role int32
+	var roleUpdated, superseded bool
+	var added time.Time
+	var removed pq.NullTime
+	cols, dests := splitColDests(0, []*colDest{
+		{userIDCol, &userID},
+		{entityIDCol, &entityID},
+		{roleCol, &role},
+		{roleUpdatedCol, &roleUpdated},
+		{supersededCol, &superseded},
+		{addedTime, &added},
+		{removedTime, &removed},
+	})
+	return cols, dests, func() []*storage.AssociationEvent {
+		// a row inserted by a role update starts with that update, and a row closed by one
+		// has no remove event since the update replaces it
+		addedType := api.EventType_ADDED
+		if roleUpdated {
+			addedType = api.EventType_ROLE_UPDATED
+		}
+		events := []*storage.AssociationEvent{{
+			Type:     addedType,
+			Time:     added,
+			UserID:   userID,
+			EntityID: entityID,
+			Role:     api.Role(role),
+		}}
+		if removed.Valid && !superseded {
+			events = append(events, &storage.AssociationEvent{
+				Type:     api.EventType_REMOVED,
+				Time:     removed.Time,
+				UserID:   userID,
+				EntityID: entityID,
+				Role:     api.Role(role),
+			})
+		}
+		return events
+	}
+}
+
+func prepVersionScan() ([]string, []interface{}, func() *storage.AssociationVersion) {
+	var userID, entityID string
+	var role int32
+	var roleUpdated, superseded bool
+	var added time.Time
+	var removed pq.NullTime
+	cols, dests := splitColDests(0, []*colDest{
+		{userIDCol, &userID},
+		{entityIDCol, &entityID},
+		{roleCol, &role},
+		{roleUpdatedCol, &roleUpdated},
+		{supersededCol, &superseded},
+		{addedTime, &added},
+		{removedTime, &removed},
+	})
+	return cols, dests, func() *storage.AssociationVersion {
+		return &storage.AssociationVersion{
+			UserID:      userID,
+			EntityID:    entityID,
+			Role:        api.Role(role),
+			Removed:     removed.Valid,
+			AddedTime:   added,
+			RemovedTime: removed.Time,
+			RoleUpdated: roleUpdated,
+			Superseded:  superseded,
+		}
+	}
+}
+
+func prepEventScan() ([]string, []interface{}, func() *storage.AssociationEvent) {
+	ee := &entityEvent{}
+	cols, dests := splitColDests(0, []*colDest{
+		{revisionCol, &ee.Revision},
+		{typeCol, &ee.Type},
+		{timeCol, &ee.Time},
+		{userIDCol, &ee.UserID},
+		{entityIDCol, &ee.EntityID},
+		{roleCol, &ee.Role},
+	})
+	return cols, dests, ee.event
+}
+
+// entityEvent is a row of the entity event table, which is also the JSON payload of its
+// notifications.
+type entityEvent struct {
+	Revision uint64    `json:"revision"`
+	Type     int32     `json:"type"`
+	Time     time.Time `json:"time"`
+	UserID   string    `json:"user_id"`
+	EntityID string    `json:"entity_id"`
+	Role     int32     `json:"role"`
+}
+
+func (ee *entityEvent) event() *storage.AssociationEvent {
+	return &storage.AssociationEvent{
+		Type:     api.EventType(ee.Type),
+		Time:     ee.Time,
+		UserID:   ee.UserID,
+		EntityID: ee.EntityID,
+		Role:     api.Role(ee.Role),
+		Revision: ee.Revision,
 	}
 }
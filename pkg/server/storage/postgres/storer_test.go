@@ -3,18 +3,21 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"testing"
+	"time"
 
 	sq "github.com/Masterminds/squirrel"
 	errors2 "github.com/drausin/libri/libri/common/errors"
 	"github.com/drausin/libri/libri/common/logging"
 	bstorage "github.com/elixirhealth/service-base/pkg/server/storage"
 	"github.com/elixirhealth/user/pkg/server/storage"
-	"github.com/elixirhealth/user/pkg/server/storage/postgres/migrations"
+	"github.com/elixirhealth/user/pkg/server/storage/storagetest"
 	api "github.com/elixirhealth/user/pkg/userapi"
-	"github.com/mattes/migrate/source/go-bindata"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
@@ -22,12 +25,14 @@ var (
 	errTest = errors.New("test error")
 )
 
+func init() {
+	sql.Register(fixedDriverName, &fixedDriver{})
+}
+
 func setUpPostgresTest() (string, func() error) {
 	dbURL, cleanup, err := bstorage.StartTestPostgres()
 	errors2.MaybePanic(err)
-	as := bindata.Resource(migrations.AssetNames(), migrations.Asset)
-	logger := &bstorage.LogLogger{}
-	m := bstorage.NewBindataMigrator(dbURL, as, logger)
+	m := NewMigrator(dbURL, zap.NewNop())
 	errors2.MaybePanic(m.Up())
 	return dbURL, func() error {
 		if err := m.Down(); err != nil {
@@ -37,104 +42,1029 @@ func setUpPostgresTest() (string, func() error) {
 	}
 }
 
-func TestStorer_AddGetCount(t *testing.T) {
-	dbURL, tearDown := setUpPostgresTest()
-	defer func() {
-		err := tearDown()
-		assert.Nil(t, err)
-	}()
-
+func TestStorer_Conformance(t *testing.T) {
+	dbURL, tearDown := setUpPostgresTest()
+	defer func() {
+		err := tearDown()
+		assert.Nil(t, err)
+	}()
+	m := NewMigrator(dbURL, zap.NewNop())
+	params := storage.NewDefaultParameters()
+	params.Type = storage.Postgres
+
+	storagetest.Run(t, func() storage.Storer {
+		// migrate down and back up so each test starts with empty tables
+		errors2.MaybePanic(m.Down())
+		errors2.MaybePanic(m.Up())
+		s, err := New(dbURL, params, zap.NewNop())
+		errors2.MaybePanic(err)
+		return s
+	})
+}
+
+func TestStorer_AddGetCount(t *testing.T) {
+	dbURL, tearDown := setUpPostgresTest()
+	defer func() {
+		err := tearDown()
+		assert.Nil(t, err)
+	}()
+
+	params := storage.NewDefaultParameters()
+	params.Type = storage.Postgres
+	lg := logging.NewDevLogger(zapcore.DebugLevel) // zap.NewNop()
+	userID1, userID2 := "user ID 1", "user ID 2"
+	entityID1, entityID2, entityID3 := "entity ID 1", "entity ID 2", "entity ID 3"
+
+	limits := &storage.Limits{MaxUserEntities: 2, MaxEntityUsers: 1}
+
+	s, err := New(dbURL, params, lg)
+	assert.Nil(t, err)
+
+	err = s.AddEntity(context.Background(), userID1, entityID1, api.Role_OWNER, limits)
+	assert.Nil(t, err)
+	err = s.AddEntity(context.Background(), userID1, entityID2, api.Role_OWNER, limits)
+	assert.Nil(t, err)
+	err = s.AddEntity(context.Background(), userID2, entityID3, api.Role_OWNER, limits)
+	assert.Nil(t, err)
+
+	// adding an existing association fails, as with the other storers
+	err = s.AddEntity(context.Background(), userID1, entityID1, api.Role_OWNER, limits)
+	assert.Equal(t, storage.ErrUserEntityExists, err)
+
+	// adding beyond either limit fails
+	err = s.AddEntity(context.Background(), userID1, entityID3, api.Role_OWNER, limits)
+	assert.Equal(t, storage.ErrTooManyEntityUsers, err)
+	err = s.AddEntity(context.Background(), userID1, "entity ID 4", api.Role_OWNER, limits)
+	assert.Equal(t, storage.ErrTooManyUserEntities, err)
+
+	as, _, err := s.GetEntities(context.Background(), userID1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID1, entityID2}, storage.EntityIDs(as))
+
+	nEntities, err := s.CountEntities(context.Background(), userID1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, nEntities)
+
+	nEntities, err = s.CountUsers(context.Background(), entityID2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, nEntities)
+
+	nUsers, err := s.CountUsers(context.Background(), entityID1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, nUsers)
+
+	userIDs, _, err := s.GetUsers(context.Background(), entityID3, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{userID2}, userIDs)
+
+	beforeRemove := time.Now()
+	err = s.RemoveEntity(context.Background(), userID1, entityID1)
+	assert.Nil(t, err)
+
+	as, _, err = s.GetEntities(context.Background(), userID1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID2}, storage.EntityIDs(as))
+
+	// the triggers record the removal after the add, which is revision 1
+	var removed *storage.AssociationEvent
+	errStop := errors.New("stop watch")
+	err = s.Watch(context.Background(), "", entityID1, 1, func(e *storage.AssociationEvent) error {
+		removed = e
+		return errStop
+	})
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, api.EventType_REMOVED, removed.Type)
+	assert.Equal(t, userID1, removed.UserID)
+	assert.True(t, removed.Revision > 1)
+
+	as, _, err = s.GetEntitiesAsOf(context.Background(), userID1, beforeRemove, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID1, entityID2}, storage.EntityIDs(as))
+
+	as, _, err = s.GetEntitiesAsOf(context.Background(), userID1, time.Now(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID2}, storage.EntityIDs(as))
+
+	nUsers, err = s.CountUsers(context.Background(), entityID1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, nUsers)
+
+	err = s.RemoveEntity(context.Background(), userID1, entityID1)
+	assert.Equal(t, storage.ErrUserEntityNotExists, err)
+
+	events, err := s.GetHistory(context.Background(), userID1, entityID1)
+	assert.Nil(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, api.EventType_ADDED, events[0].Type)
+	assert.Equal(t, api.EventType_REMOVED, events[1].Type)
+	assert.True(t, events[1].Time.After(beforeRemove))
+
+	events, err = s.GetHistory(context.Background(), userID1, "")
+	assert.Nil(t, err)
+	assert.Len(t, events, 3)
+
+	// removed association can be added again
+	err = s.AddEntity(context.Background(), userID1, entityID1, api.Role_OWNER, limits)
+	assert.Nil(t, err)
+
+	// role updates keep the role as of each point in time
+	beforeUpdate := time.Now()
+	err = s.UpdateRole(context.Background(), userID1, entityID2, api.Role_ADMIN)
+	assert.Nil(t, err)
+	err = s.UpdateRole(context.Background(), userID1, entityID2, api.Role_ADMIN) // no-op
+	assert.Nil(t, err)
+	err = s.UpdateRole(context.Background(), userID2, entityID1, api.Role_ADMIN)
+	assert.Equal(t, storage.ErrUserEntityNotExists, err)
+
+	as, _, err = s.GetEntities(context.Background(), userID1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Association{
+		{UserID: userID1, EntityID: entityID1, Role: api.Role_OWNER},
+		{UserID: userID1, EntityID: entityID2, Role: api.Role_ADMIN},
+	}, as)
+	as, _, err = s.GetEntitiesAsOf(context.Background(), userID1, beforeUpdate, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Association{
+		{UserID: userID1, EntityID: entityID1, Role: api.Role_OWNER},
+		{UserID: userID1, EntityID: entityID2, Role: api.Role_OWNER},
+	}, as)
+
+	// entities are keyset paged in entity ID order
+	page := &storage.Page{Size: 1}
+	as, next, err := s.GetEntities(context.Background(), userID1, page)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID1}, storage.EntityIDs(as))
+	assert.NotEmpty(t, next)
+	page.Token = next
+	as, next, err = s.GetEntities(context.Background(), userID1, page)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID2}, storage.EntityIDs(as))
+	assert.Empty(t, next)
+	page.Token = next
+	as, next, err = s.GetEntitiesAsOf(context.Background(), userID1, beforeUpdate, page)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{entityID1}, storage.EntityIDs(as))
+	assert.NotEmpty(t, next)
+	nEntities, err = s.CountEntities(context.Background(), userID1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, nEntities)
+
+	events, err = s.GetHistory(context.Background(), userID1, entityID2)
+	assert.Nil(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, api.EventType_ADDED, events[0].Type)
+	assert.Equal(t, api.Role_OWNER, events[0].Role)
+	assert.Equal(t, api.EventType_ROLE_UPDATED, events[1].Type)
+	assert.Equal(t, api.Role_ADMIN, events[1].Role)
+
+	// batch adds are checked against limits as a whole
+	errs, err := s.AddEntities(context.Background(), []*storage.Association{
+		{UserID: "user ID 5", EntityID: "entity ID 5"},
+		{UserID: "user ID 6", EntityID: "entity ID 5"},
+		{UserID: userID2, EntityID: entityID3},
+	}, limits)
+	assert.Nil(t, err)
+	assert.Equal(t, []error{
+		nil,
+		storage.ErrTooManyEntityUsers,
+		storage.ErrUserEntityExists,
+	}, errs)
+
+	batch, err := s.GetEntitiesBatch(context.Background(), []string{userID2, "user ID 5", "x"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		userID2:     {entityID3},
+		"user ID 5": {"entity ID 5"},
+		"x":         {},
+	}, batch)
+
+	// per-entity override raises the limit for just that entity
+	err = s.SetEntityLimit(context.Background(), entityID3, 2)
+	assert.Nil(t, err)
+	err = s.SetEntityLimit(context.Background(), entityID3, 3) // upsert
+	assert.Nil(t, err)
+	err = s.AddEntity(context.Background(), "user ID 3", entityID3, api.Role_OWNER, limits)
+	assert.Nil(t, err)
+	err = s.SetEntityLimit(context.Background(), entityID3, 0)
+	assert.Nil(t, err)
+	err = s.AddEntity(context.Background(), "user ID 4", entityID3, api.Role_OWNER, limits)
+	assert.Equal(t, storage.ErrTooManyEntityUsers, err)
+
+	// user profiles
+	u, err := s.GetUser(context.Background(), userID1)
+	assert.Equal(t, storage.ErrUserNotExists, err)
+	assert.Nil(t, u)
+	profile := &api.UserProfile{
+		UserId:      userID1,
+		DisplayName: "User 1",
+		Email:       "user1@example.com",
+		Attributes:  map[string]string{"key1": "value1"},
+	}
+	err = s.PutUser(context.Background(), profile)
+	assert.Nil(t, err)
+	u, err = s.GetUser(context.Background(), userID1)
+	assert.Nil(t, err)
+	assert.Equal(t, profile, u)
+
+	u, err = s.UpdateUser(context.Background(), &api.UserProfile{
+		UserId:     userID1,
+		Phone:      "555-555-5555",
+		Attributes: map[string]string{"key1": "", "key2": "value2"},
+	})
+	assert.Nil(t, err)
+	expected := &api.UserProfile{
+		UserId:      userID1,
+		DisplayName: "User 1",
+		Email:       "user1@example.com",
+		Phone:       "555-555-5555",
+		Attributes:  map[string]string{"key2": "value2"},
+	}
+	assert.Equal(t, expected, u)
+	u, err = s.GetUser(context.Background(), userID1)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, u)
+
+	// put replaces the whole profile
+	err = s.PutUser(context.Background(), &api.UserProfile{UserId: userID1, Locale: "en-US"})
+	assert.Nil(t, err)
+	u, err = s.GetUser(context.Background(), userID1)
+	assert.Nil(t, err)
+	assert.Equal(t, &api.UserProfile{UserId: userID1, Locale: "en-US"}, u)
+
+	u, err = s.UpdateUser(context.Background(), &api.UserProfile{UserId: userID2})
+	assert.Equal(t, storage.ErrUserNotExists, err)
+	assert.Nil(t, u)
+}
+
+func TestStorer_AddEntity_err(t *testing.T) {
+	userID, entityID := "some user ID", "some entity ID"
+	params := storage.NewDefaultParameters()
+	params.Type = storage.Postgres
+	lg := logging.NewDevLogger(zapcore.DebugLevel) // zap.NewNop()
+	limits := &storage.Limits{MaxUserEntities: 1, MaxEntityUsers: 1}
+	okDB, err := sql.Open(fixedDriverName, "")
+	assert.Nil(t, err)
+
+	cases := map[string]struct {
+		userID   string
+		entityID string
+		limits   *storage.Limits
+		s        *storer
+		expected error
+	}{
+		"bad user ID": {
+			userID:   "",
+			entityID: entityID,
+			s:        &storer{},
+			expected: api.ErrEmptyUserID,
+		},
+		"bad entity ID": {
+			userID:   userID,
+			entityID: "",
+			s:        &storer{},
+			expected: api.ErrEmptyEntityID,
+		},
+		"count err": {
+			userID:   userID,
+			entityID: entityID,
+			limits:   limits,
+			s: &storer{
+				params: params,
+				logger: lg,
+				db:     okDB,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{scanErr: errTest},
+				},
+			},
+			expected: errTest,
+		},
+		"already exists": {
+			userID:   userID,
+			entityID: entityID,
+			limits:   limits,
+			s: &storer{
+				params: params,
+				logger: lg,
+				db:     okDB,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{value: 1},
+				},
+			},
+			expected: storage.ErrUserEntityExists,
+		},
+		"too many entity users": {
+			userID:   userID,
+			entityID: entityID,
+			limits:   &storage.Limits{MaxUserEntities: 1, MaxEntityUsers: 0},
+			s: &storer{
+				params: params,
+				logger: lg,
+				db:     okDB,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{},
+				},
+			},
+			expected: storage.ErrTooManyEntityUsers,
+		},
+		"too many user entities": {
+			userID:   userID,
+			entityID: entityID,
+			limits:   &storage.Limits{MaxUserEntities: 0, MaxEntityUsers: 1},
+			s: &storer{
+				params: params,
+				logger: lg,
+				db:     okDB,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{},
+				},
+			},
+			expected: storage.ErrTooManyUserEntities,
+		},
+		"too many entity users for override": {
+			userID:   userID,
+			entityID: entityID,
+			limits:   limits,
+			s: &storer{
+				params: params,
+				logger: lg,
+				db:     okDB,
+				qr: &fixedQuerier{
+					// scanned as the existing count, the override, and the user count
+					selectRowResult: &fixedRowScanner{values: []int{0, 2, 2}},
+				},
+			},
+			expected: storage.ErrTooManyEntityUsers,
+		},
+		"insert err": {
+			userID:   userID,
+			entityID: entityID,
+			limits:   limits,
+			s: &storer{
+				params: params,
+				logger: lg,
+				db:     okDB,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{},
+					insertErr:       errTest,
+				},
+			},
+			expected: errTest,
+		},
+		"insert unique violation": {
+			userID:   userID,
+			entityID: entityID,
+			limits:   limits,
+			s: &storer{
+				params: params,
+				logger: lg,
+				db:     okDB,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{},
+					insertErr:       &pq.Error{Code: uniqueViolation},
+				},
+			},
+			expected: storage.ErrUserEntityExists,
+		},
+	}
+	for desc, c := range cases {
+		err := c.s.AddEntity(context.Background(), c.userID, c.entityID, api.Role_OWNER, c.limits)
+		assert.Equal(t, c.expected, err, desc)
+	}
+
+	// the caller's context bounds the transaction along with the configured timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := &storer{params: params, logger: lg, db: okDB, qr: &fixedQuerier{}}
+	err = s.AddEntity(ctx, userID, entityID, api.Role_OWNER, limits)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestStorer_forward(t *testing.T) {
+	s := &storer{feed: storage.NewBroadcaster(0), logger: zap.NewNop()}
+	notify := make(chan *pq.Notification, 3)
+	sub := s.feed.Subscribe("user 1", "")
+	notify <- &pq.Notification{
+		Extra: `{"revision":5,"type":2,"time":"2018-01-02T03:04:05.678+00:00",` +
+			`"user_id":"user 1","entity_id":"entity 1","role":1}`,
+	}
+	notify <- nil // reconnected
+	close(notify)
+	s.forward(notify)
+
+	sent := make([]*storage.AssociationEvent, 0)
+	err := sub.Stream(context.Background(), 0, nil, func(e *storage.AssociationEvent) error {
+		sent = append(sent, e)
+		return nil
+	})
+	assert.Equal(t, storage.ErrWatchInterrupted, err)
+	if assert.Len(t, sent, 1) {
+		assert.Equal(t, uint64(5), sent[0].Revision)
+		assert.Equal(t, api.EventType_REMOVED, sent[0].Type)
+		assert.Equal(t, "user 1", sent[0].UserID)
+		assert.Equal(t, "entity 1", sent[0].EntityID)
+		assert.Equal(t, api.Role_OWNER, sent[0].Role)
+		assert.Equal(t, 2018, sent[0].Time.Year())
+	}
+}
+
+func TestStorer_Watch_err(t *testing.T) {
+	s := &storer{feed: storage.NewBroadcaster(0), logger: zap.NewNop()}
+	err := s.Watch(context.Background(), "", "", 0, func(e *storage.AssociationEvent) error {
+		return nil
+	})
+	assert.Equal(t, api.ErrEmptyUserAndEntityID, err)
+}
+
+func TestStorer_getEvents_err(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	lg := zap.NewNop()
+	cases := map[string]struct {
+		s            *storer
+		fromRevision uint64
+		expected     error
+	}{
+		"revisions err": {
+			s: &storer{
+				params: params,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{scanErr: errTest},
+				},
+				logger: lg,
+			},
+			fromRevision: 1,
+			expected:     errTest,
+		},
+		"future revision": {
+			s: &storer{
+				params: params,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{values: []int{5, 10}},
+				},
+				logger: lg,
+			},
+			fromRevision: 11,
+			expected:     storage.ErrFutureRevision,
+		},
+		"compacted revision": {
+			s: &storer{
+				params: params,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{values: []int{5, 10}},
+				},
+				logger: lg,
+			},
+			fromRevision: 3,
+			expected:     storage.ErrRevisionCompacted,
+		},
+	}
+	for desc, c := range cases {
+		events, err := c.s.getEvents(context.Background(), "user 1", "", c.fromRevision)
+		assert.Equal(t, c.expected, err, desc)
+		assert.Nil(t, events, desc)
+	}
+}
+
+func TestStorageErr(t *testing.T) {
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cases := map[string]struct {
+		ctx      context.Context
+		err      error
+		expected error
+	}{
+		"non-pq err": {
+			ctx:      context.Background(),
+			err:      errTest,
+			expected: errTest,
+		},
+		"unique violation": {
+			ctx:      context.Background(),
+			err:      &pq.Error{Code: uniqueViolation},
+			expected: storage.ErrUserEntityExists,
+		},
+		"query canceled": {
+			ctx:      canceledCtx,
+			err:      &pq.Error{Code: queryCanceled},
+			expected: context.Canceled,
+		},
+		"query canceled by server": {
+			ctx:      context.Background(),
+			err:      &pq.Error{Code: queryCanceled},
+			expected: &pq.Error{Code: queryCanceled},
+		},
+	}
+	for desc, c := range cases {
+		err := storageErr(c.ctx, c.err)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestStorer_AddEntities(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	params.Type = storage.Postgres
+	lg := logging.NewDevLogger(zapcore.DebugLevel) // zap.NewNop()
+	okDB, err := sql.Open(fixedDriverName, "")
+	assert.Nil(t, err)
+	limits := &storage.Limits{MaxUserEntities: 1, MaxEntityUsers: 1}
+	as := []*storage.Association{
+		{UserID: "user 1", EntityID: "entity 1"},
+		{UserID: "user 2", EntityID: "entity 1"},
+		{UserID: "", EntityID: "entity 2"},
+	}
+
+	// no existing associations
+	s := &storer{
+		params: params,
+		logger: lg,
+		db:     okDB,
+		qr: &fixedQuerier{
+			selectResult: &fixedRowScanner{},
+		},
+	}
+	errs, err := s.AddEntities(context.Background(), as, limits)
+	assert.Nil(t, err)
+	assert.Equal(t, []error{nil, storage.ErrTooManyEntityUsers, api.ErrEmptyUserID}, errs)
+
+	cases := map[string]*fixedQuerier{
+		"select err": {selectErr: errTest},
+		"scan err": {
+			selectResult: &fixedRowScanner{next: true, scanErr: errTest},
+		},
+		"rows err": {
+			selectResult: &fixedRowScanner{errErr: errTest},
+		},
+		"insert err": {
+			selectResult: &fixedRowScanner{},
+			insertErr:    errTest,
+		},
+	}
+	for desc, qr := range cases {
+		s = &storer{params: params, logger: lg, db: okDB, qr: qr}
+		errs, err = s.AddEntities(context.Background(), as, limits)
+		assert.Equal(t, errTest, err, desc)
+		assert.Nil(t, errs, desc)
+	}
+}
+
+func TestStorer_RemoveEntity_err(t *testing.T) {
+	userID, entityID := "some user ID", "some entity ID"
+	params := storage.NewDefaultParameters()
+	params.Type = storage.Postgres
+	lg := logging.NewDevLogger(zapcore.DebugLevel) // zap.NewNop()
+
+	cases := map[string]struct {
+		userID   string
+		entityID string
+		s        *storer
+		expected error
+	}{
+		"bad user ID": {
+			userID:   "",
+			entityID: entityID,
+			s:        &storer{},
+			expected: api.ErrEmptyUserID,
+		},
+		"bad entity ID": {
+			userID:   userID,
+			entityID: "",
+			s:        &storer{},
+			expected: api.ErrEmptyEntityID,
+		},
+		"update err": {
+			userID:   userID,
+			entityID: entityID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				qr: &fixedQuerier{
+					updateErr: errTest,
+				},
+			},
+			expected: errTest,
+		},
+		"rows affected err": {
+			userID:   userID,
+			entityID: entityID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				qr: &fixedQuerier{
+					updateResult: &fixedSQLResult{rowsAffectedErr: errTest},
+				},
+			},
+			expected: errTest,
+		},
+		"not exists": {
+			userID:   userID,
+			entityID: entityID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				qr: &fixedQuerier{
+					updateResult: &fixedSQLResult{rowsAffected: 0},
+				},
+			},
+			expected: storage.ErrUserEntityNotExists,
+		},
+	}
+	for desc, c := range cases {
+		err := c.s.RemoveEntity(context.Background(), c.userID, c.entityID)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestStorer_UpdateRole_err(t *testing.T) {
+	userID, entityID := "some user ID", "some entity ID"
+	params := storage.NewDefaultParameters()
+	params.Type = storage.Postgres
+	lg := logging.NewDevLogger(zapcore.DebugLevel) // zap.NewNop()
+	okDB, err := sql.Open(fixedDriverName, "")
+	assert.Nil(t, err)
+
+	cases := map[string]struct {
+		userID   string
+		entityID string
+		s        *storer
+		expected error
+	}{
+		"bad user ID": {
+			userID:   "",
+			entityID: entityID,
+			s:        &storer{},
+			expected: api.ErrEmptyUserID,
+		},
+		"bad entity ID": {
+			userID:   userID,
+			entityID: "",
+			s:        &storer{},
+			expected: api.ErrEmptyEntityID,
+		},
+		"not exists": {
+			userID:   userID,
+			entityID: entityID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				db:     okDB,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{scanErr: sql.ErrNoRows},
+				},
+			},
+			expected: storage.ErrUserEntityNotExists,
+		},
+		"select err": {
+			userID:   userID,
+			entityID: entityID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				db:     okDB,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{scanErr: errTest},
+				},
+			},
+			expected: errTest,
+		},
+		"update err": {
+			userID:   userID,
+			entityID: entityID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				db:     okDB,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{value: int(api.Role_OWNER)},
+					updateErr:       errTest,
+				},
+			},
+			expected: errTest,
+		},
+		"insert err": {
+			userID:   userID,
+			entityID: entityID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				db:     okDB,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{value: int(api.Role_OWNER)},
+					updateResult:    &fixedSQLResult{rowsAffected: 1},
+					insertErr:       errTest,
+				},
+			},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		err := c.s.UpdateRole(context.Background(), c.userID, c.entityID, api.Role_ADMIN)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestStorer_PutUser_err(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	params.Type = storage.Postgres
+	lg := logging.NewDevLogger(zapcore.DebugLevel) // zap.NewNop()
+
+	s := &storer{}
+	err := s.PutUser(context.Background(), &api.UserProfile{})
+	assert.Equal(t, api.ErrEmptyUserID, err)
+
+	s = &storer{params: params, logger: lg, qr: &fixedQuerier{insertErr: errTest}}
+	err = s.PutUser(context.Background(), &api.UserProfile{UserId: "some user ID"})
+	assert.Equal(t, errTest, err)
+}
+
+func TestStorer_GetUser_err(t *testing.T) {
+	userID := "some user ID"
+	params := storage.NewDefaultParameters()
+	params.Type = storage.Postgres
+	lg := logging.NewDevLogger(zapcore.DebugLevel) // zap.NewNop()
+
+	cases := map[string]struct {
+		userID   string
+		s        *storer
+		expected error
+	}{
+		"bad user ID": {
+			userID:   "",
+			s:        &storer{},
+			expected: api.ErrEmptyUserID,
+		},
+		"not exists": {
+			userID: userID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{scanErr: sql.ErrNoRows},
+				},
+			},
+			expected: storage.ErrUserNotExists,
+		},
+		"scan err": {
+			userID: userID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{scanErr: errTest},
+				},
+			},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		u, err := c.s.GetUser(context.Background(), c.userID)
+		assert.Equal(t, c.expected, err, desc)
+		assert.Nil(t, u, desc)
+	}
+}
+
+func TestStorer_UpdateUser_err(t *testing.T) {
+	userID := "some user ID"
 	params := storage.NewDefaultParameters()
-	params.Type = bstorage.Postgres
+	params.Type = storage.Postgres
 	lg := logging.NewDevLogger(zapcore.DebugLevel) // zap.NewNop()
-	userID1, userID2 := "user ID 1", "user ID 2"
-	entityID1, entityID2, entityID3 := "entity ID 1", "entity ID 2", "entity ID 3"
-
-	s, err := New(dbURL, params, lg)
-	assert.Nil(t, err)
-
-	err = s.AddEntity(userID1, entityID1)
-	assert.Nil(t, err)
-	err = s.AddEntity(userID1, entityID2)
-	assert.Nil(t, err)
-	err = s.AddEntity(userID2, entityID3)
+	okDB, err := sql.Open(fixedDriverName, "")
 	assert.Nil(t, err)
 
-	entityIDs, err := s.GetEntities(userID1)
-	assert.Nil(t, err)
-	assert.Equal(t, []string{entityID1, entityID2}, entityIDs)
+	cases := map[string]struct {
+		userID   string
+		s        *storer
+		expected error
+	}{
+		"bad user ID": {
+			userID:   "",
+			s:        &storer{},
+			expected: api.ErrEmptyUserID,
+		},
+		"not exists": {
+			userID: userID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				db:     okDB,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{scanErr: sql.ErrNoRows},
+				},
+			},
+			expected: storage.ErrUserNotExists,
+		},
+		"scan err": {
+			userID: userID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				db:     okDB,
+				qr: &fixedQuerier{
+					selectRowResult: &fixedRowScanner{scanErr: errTest},
+				},
+			},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		u, err := c.s.UpdateUser(context.Background(), &api.UserProfile{UserId: c.userID})
+		assert.Equal(t, c.expected, err, desc)
+		assert.Nil(t, u, desc)
+	}
+}
 
-	nEntities, err := s.CountEntities(userID1)
-	assert.Nil(t, err)
-	assert.Equal(t, 2, nEntities)
+func TestStorer_GetEntities_err(t *testing.T) {
+	userID := "some user ID"
+	params := storage.NewDefaultParameters()
+	params.Type = storage.Postgres
+	lg := logging.NewDevLogger(zapcore.DebugLevel) // zap.NewNop()
 
-	nEntities, err = s.CountUsers(entityID2)
-	assert.Nil(t, err)
-	assert.Equal(t, 1, nEntities)
+	cases := map[string]struct {
+		userID   string
+		page     *storage.Page
+		s        *storer
+		expected error
+	}{
+		"bad user ID": {
+			userID:   "",
+			s:        &storer{},
+			expected: api.ErrEmptyUserID,
+		},
+		"invalid page token": {
+			userID:   userID,
+			page:     &storage.Page{Size: 1, Token: "not a page token!"},
+			s:        &storer{},
+			expected: storage.ErrInvalidPageToken,
+		},
+		"select err": {
+			userID: userID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				qr: &fixedQuerier{
+					selectErr: errTest,
+				},
+			},
+			expected: errTest,
+		},
+		"scan err": {
+			userID: userID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				qr: &fixedQuerier{
+					selectResult: &fixedRowScanner{
+						next:    true,
+						scanErr: errTest,
+					},
+				},
+			},
+			expected: errTest,
+		},
+		"err err": {
+			userID: userID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				qr: &fixedQuerier{
+					selectResult: &fixedRowScanner{
+						errErr: errTest,
+					},
+				},
+			},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		as, _, err := c.s.GetEntities(context.Background(), c.userID, c.page)
+		assert.Equal(t, c.expected, err, desc)
+		assert.Nil(t, as)
 
-	nUsers, err := s.CountUsers(entityID1)
-	assert.Nil(t, err)
-	assert.Equal(t, 1, nUsers)
+		as, _, err = c.s.GetEntitiesAsOf(context.Background(), c.userID, time.Now(), c.page)
+		assert.Equal(t, c.expected, err, desc)
+		assert.Nil(t, as)
+	}
 }
 
-func TestStorer_AddEntity_err(t *testing.T) {
-	userID, entityID := "some user ID", "some entity ID"
+func TestStorer_GetEntitiesBatch_err(t *testing.T) {
 	params := storage.NewDefaultParameters()
-	params.Type = bstorage.Postgres
+	params.Type = storage.Postgres
 	lg := logging.NewDevLogger(zapcore.DebugLevel) // zap.NewNop()
+	userIDs := []string{"some user ID"}
 
 	cases := map[string]struct {
-		userID   string
-		entityID string
+		userIDs  []string
 		s        *storer
 		expected error
 	}{
 		"bad user ID": {
-			userID:   "",
-			entityID: entityID,
+			userIDs:  []string{"some user ID", ""},
 			s:        &storer{},
 			expected: api.ErrEmptyUserID,
 		},
+		"select err": {
+			userIDs: userIDs,
+			s: &storer{
+				params: params,
+				logger: lg,
+				qr:     &fixedQuerier{selectErr: errTest},
+			},
+			expected: errTest,
+		},
+		"scan err": {
+			userIDs: userIDs,
+			s: &storer{
+				params: params,
+				logger: lg,
+				qr: &fixedQuerier{
+					selectResult: &fixedRowScanner{next: true, scanErr: errTest},
+				},
+			},
+			expected: errTest,
+		},
+	}
+	for desc, c := range cases {
+		entityIDs, err := c.s.GetEntitiesBatch(context.Background(), c.userIDs)
+		assert.Equal(t, c.expected, err, desc)
+		assert.Nil(t, entityIDs, desc)
+	}
+}
+
+func TestStorer_GetUsers_err(t *testing.T) {
+	entityID := "some entity ID"
+	params := storage.NewDefaultParameters()
+	params.Type = storage.Postgres
+	lg := logging.NewDevLogger(zapcore.DebugLevel) // zap.NewNop()
+
+	cases := map[string]struct {
+		entityID string
+		s        *storer
+		expected error
+	}{
 		"bad entity ID": {
-			userID:   userID,
 			entityID: "",
 			s:        &storer{},
 			expected: api.ErrEmptyEntityID,
 		},
-		"insert err": {
-			userID:   userID,
+		"select err": {
+			entityID: entityID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				qr: &fixedQuerier{
+					selectErr: errTest,
+				},
+			},
+			expected: errTest,
+		},
+		"scan err": {
+			entityID: entityID,
+			s: &storer{
+				params: params,
+				logger: lg,
+				qr: &fixedQuerier{
+					selectResult: &fixedRowScanner{
+						next:    true,
+						scanErr: errTest,
+					},
+				},
+			},
+			expected: errTest,
+		},
+		"err err": {
 			entityID: entityID,
 			s: &storer{
 				params: params,
 				logger: lg,
 				qr: &fixedQuerier{
-					insertErr: errTest,
+					selectResult: &fixedRowScanner{
+						errErr: errTest,
+					},
 				},
 			},
 			expected: errTest,
 		},
 	}
 	for desc, c := range cases {
-		err := c.s.AddEntity(c.userID, c.entityID)
+		userIDs, _, err := c.s.GetUsers(context.Background(), c.entityID, nil)
 		assert.Equal(t, c.expected, err, desc)
+		assert.Nil(t, userIDs)
 	}
 }
 
-func TestStorer_GetEntities_err(t *testing.T) {
+func TestStorer_GetHistory_err(t *testing.T) {
 	userID := "some user ID"
 	params := storage.NewDefaultParameters()
-	params.Type = bstorage.Postgres
+	params.Type = storage.Postgres
 	lg := logging.NewDevLogger(zapcore.DebugLevel) // zap.NewNop()
 
 	cases := map[string]struct {
 		userID   string
+		entityID string
 		s        *storer
 		expected error
 	}{
-		"bad user ID": {
-			userID:   "",
+		"bad user and entity ID": {
 			s:        &storer{},
-			expected: api.ErrEmptyUserID,
+			expected: api.ErrEmptyUserAndEntityID,
 		},
 		"select err": {
 			userID: userID,
@@ -176,16 +1106,36 @@ func TestStorer_GetEntities_err(t *testing.T) {
 		},
 	}
 	for desc, c := range cases {
-		entityIDs, err := c.s.GetEntities(c.userID)
+		events, err := c.s.GetHistory(context.Background(), c.userID, c.entityID)
 		assert.Equal(t, c.expected, err, desc)
-		assert.Nil(t, entityIDs)
+		assert.Nil(t, events, desc)
 	}
 }
 
+func TestStorer_SetLimit_err(t *testing.T) {
+	params := storage.NewDefaultParameters()
+	params.Type = storage.Postgres
+	lg := logging.NewDevLogger(zapcore.DebugLevel) // zap.NewNop()
+
+	s := &storer{}
+	assert.Equal(t, api.ErrEmptyUserID, s.SetUserLimit(context.Background(), "", 32))
+	assert.Equal(t, api.ErrEmptyEntityID, s.SetEntityLimit(context.Background(), "", 1024))
+
+	s = &storer{
+		params: params,
+		logger: lg,
+		qr:     &fixedQuerier{insertErr: errTest, deleteErr: errTest},
+	}
+	assert.Equal(t, errTest, s.SetUserLimit(context.Background(), "some user ID", 32))
+	assert.Equal(t, errTest, s.SetEntityLimit(context.Background(), "some entity ID", 1024))
+	assert.Equal(t, errTest, s.SetUserLimit(context.Background(), "some user ID", 0))
+	assert.Equal(t, errTest, s.SetEntityLimit(context.Background(), "some entity ID", 0))
+}
+
 func TestStorer_CountUsers_err(t *testing.T) {
 	userID := "some user ID"
 	params := storage.NewDefaultParameters()
-	params.Type = bstorage.Postgres
+	params.Type = storage.Postgres
 	lg := logging.NewDevLogger(zapcore.DebugLevel) // zap.NewNop()
 
 	cases := map[string]struct {
@@ -213,7 +1163,7 @@ func TestStorer_CountUsers_err(t *testing.T) {
 		},
 	}
 	for desc, c := range cases {
-		count, err := c.s.CountEntities(c.userID)
+		count, err := c.s.CountEntities(context.Background(), c.userID)
 		assert.Equal(t, c.expected, err, desc)
 		assert.Zero(t, count, desc)
 	}
@@ -222,7 +1172,7 @@ func TestStorer_CountUsers_err(t *testing.T) {
 func TestStorer_CountEntities_err(t *testing.T) {
 	entityID := "some entity ID"
 	params := storage.NewDefaultParameters()
-	params.Type = bstorage.Postgres
+	params.Type = storage.Postgres
 	lg := logging.NewDevLogger(zapcore.DebugLevel) // zap.NewNop()
 
 	cases := map[string]struct {
@@ -250,23 +1200,26 @@ func TestStorer_CountEntities_err(t *testing.T) {
 		},
 	}
 	for desc, c := range cases {
-		count, err := c.s.CountUsers(c.entityID)
+		count, err := c.s.CountUsers(context.Background(), c.entityID)
 		assert.Equal(t, c.expected, err, desc)
 		assert.Zero(t, count, desc)
 	}
 }
 
 type fixedQuerier struct {
-	selectResult    bstorage.QueryRows
+	selectResult    queryRows
 	selectErr       error
 	selectRowResult sq.RowScanner
 	insertResult    sql.Result
 	insertErr       error
+	updateResult    sql.Result
+	updateErr       error
+	deleteErr       error
 }
 
 func (f *fixedQuerier) SelectQueryContext(
 	ctx context.Context, b sq.SelectBuilder,
-) (bstorage.QueryRows, error) {
+) (queryRows, error) {
 	return f.selectResult, f.selectErr
 }
 
@@ -285,17 +1238,19 @@ func (f *fixedQuerier) InsertExecContext(
 func (f *fixedQuerier) UpdateExecContext(
 	ctx context.Context, b sq.UpdateBuilder,
 ) (sql.Result, error) {
-	panic("implement me")
+	return f.updateResult, f.updateErr
 }
 
 func (f *fixedQuerier) DeleteExecContext(
 	ctx context.Context, b sq.DeleteBuilder,
 ) (sql.Result, error) {
-	panic("implement me")
+	return nil, f.deleteErr
 }
 
 type fixedRowScanner struct {
 	next    bool
+	values  []int // scanned in order before falling back to value
+	value   int
 	scanErr error
 	errErr  error
 }
@@ -312,6 +1267,73 @@ func (f *fixedRowScanner) Err() error {
 	return f.errErr
 }
 
-func (f *fixedRowScanner) Scan(...interface{}) error {
-	return f.scanErr
+func (f *fixedRowScanner) Scan(dest ...interface{}) error {
+	if f.scanErr != nil {
+		return f.scanErr
+	}
+	for _, d := range dest {
+		if n, ok := d.(*uint64); ok && len(f.values) > 0 {
+			*n, f.values = uint64(f.values[0]), f.values[1:]
+		}
+	}
+	if len(dest) == 1 {
+		if n, ok := dest[0].(*int); ok {
+			*n = f.value
+			if len(f.values) > 0 {
+				*n, f.values = f.values[0], f.values[1:]
+			}
+		}
+	}
+	return nil
+}
+
+type fixedSQLResult struct {
+	rowsAffected    int64
+	rowsAffectedErr error
+}
+
+func (f *fixedSQLResult) LastInsertId() (int64, error) {
+	panic("implement me")
+}
+
+func (f *fixedSQLResult) RowsAffected() (int64, error) {
+	return f.rowsAffected, f.rowsAffectedErr
+}
+
+const fixedDriverName = "fixed"
+
+// fixedDriver is a database/sql driver whose transactions and statements always succeed without
+// doing anything, so that transaction handling can be tested alongside a fixedQuerier.
+type fixedDriver struct{}
+
+func (d *fixedDriver) Open(name string) (driver.Conn, error) {
+	return &fixedConn{}, nil
+}
+
+type fixedConn struct{}
+
+func (c *fixedConn) Prepare(query string) (driver.Stmt, error) {
+	panic("implement me")
+}
+
+func (c *fixedConn) Close() error {
+	return nil
+}
+
+func (c *fixedConn) Begin() (driver.Tx, error) {
+	return &fixedTx{}, nil
+}
+
+func (c *fixedConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+type fixedTx struct{}
+
+func (t *fixedTx) Commit() error {
+	return nil
+}
+
+func (t *fixedTx) Rollback() error {
+	return nil
 }
@@ -0,0 +1,959 @@
+// Package storagetest checks that a storage.Storer behaves as the interface specifies, so that
+// every backend can be run against the same conformance suite.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elixirhealth/user/pkg/server/storage"
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	// nConcurrent is the number of concurrent adds in the concurrency tests.
+	nConcurrent = 16
+
+	// tick separates operations whose times the tests compare, so backends storing times at
+	// coarser than nanosecond precision still order them.
+	tick = 10 * time.Millisecond
+)
+
+var testLimits = &storage.Limits{MaxUserEntities: 2, MaxEntityUsers: 2}
+
+// conformanceTest checks one aspect of a Storer, which starts out empty.
+type conformanceTest func(t *testing.T, s storage.Storer)
+
+// Run runs the conformance suite as subtests, each against a new Storer from newStorer, which
+// must return a Storer without any associations, limit overrides, or profiles. Each Storer is
+// closed when its subtest ends.
+func Run(t *testing.T, newStorer func() storage.Storer) {
+	tests := []struct {
+		name string
+		test conformanceTest
+	}{
+		{"AddGetCount", testAddGetCount},
+		{"AddEntity_exists", testAddEntityExists},
+		{"AddEntity_limits", testAddEntityLimits},
+		{"AddEntities", testAddEntities},
+		{"RemoveEntity", testRemoveEntity},
+		{"UpdateRole", testUpdateRole},
+		{"GetEntitiesBatch", testGetEntitiesBatch},
+		{"GetEntities_ordering", testGetEntitiesOrdering},
+		{"GetUsers_ordering", testGetUsersOrdering},
+		{"GetEntitiesAsOf", testGetEntitiesAsOf},
+		{"GetHistory", testGetHistory},
+		{"Users", testUsers},
+		{"Watch", testWatch},
+		{"ExportImport", testExportImport},
+		{"ImportWatch", testImportWatch},
+		{"emptyIDs", testEmptyIDs},
+		{"concurrentAdds", testConcurrentAdds},
+		{"concurrentDuplicateAdds", testConcurrentDuplicateAdds},
+	}
+	for _, c := range tests {
+		test := c.test
+		t.Run(c.name, func(t *testing.T) {
+			s := newStorer()
+			defer func() { assert.Nil(t, s.Close()) }()
+			test(t, s)
+		})
+	}
+}
+
+func testAddGetCount(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	addEntities(t, s, []*storage.Association{
+		{UserID: "user 1", EntityID: "entity 1", Role: api.Role_OWNER},
+		{UserID: "user 1", EntityID: "entity 2", Role: api.Role_ADMIN},
+		{UserID: "user 2", EntityID: "entity 1", Role: api.Role_CLINICIAN},
+	})
+
+	as, next, err := s.GetEntities(ctx, "user 1", nil)
+	assert.Nil(t, err)
+	assert.Empty(t, next)
+	assert.Equal(t, []*storage.Association{
+		{UserID: "user 1", EntityID: "entity 1", Role: api.Role_OWNER},
+		{UserID: "user 1", EntityID: "entity 2", Role: api.Role_ADMIN},
+	}, as)
+
+	userIDs, _, err := s.GetUsers(ctx, "entity 1", nil)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"user 1", "user 2"}, userIDs)
+
+	batch, err := s.GetEntitiesBatch(ctx, []string{"user 1", "user 2", "user 3"})
+	assert.Nil(t, err)
+	assert.Len(t, batch, 3)
+	assert.ElementsMatch(t, []string{"entity 1", "entity 2"}, batch["user 1"])
+	assert.ElementsMatch(t, []string{"entity 1"}, batch["user 2"])
+	assert.NotNil(t, batch["user 3"])
+	assert.Empty(t, batch["user 3"])
+
+	assertCounts(t, s, map[string]int{"user 1": 2, "user 2": 1, "user 3": 0},
+		map[string]int{"entity 1": 2, "entity 2": 1, "entity 3": 0})
+
+	// IDs that others begin with don't match them
+	as, _, err = s.GetEntities(ctx, "user", nil)
+	assert.Nil(t, err)
+	assert.Empty(t, as)
+	userIDs, _, err = s.GetUsers(ctx, "entity", nil)
+	assert.Nil(t, err)
+	assert.Empty(t, userIDs)
+}
+
+func testAddEntityExists(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	err := s.AddEntity(ctx, "user 1", "entity 1", api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+
+	// adding an existing association fails regardless of the role and leaves it unchanged
+	for _, role := range []api.Role{api.Role_OWNER, api.Role_ADMIN} {
+		err = s.AddEntity(ctx, "user 1", "entity 1", role, testLimits)
+		assert.Equal(t, storage.ErrUserEntityExists, err, role.String())
+	}
+	as, _, err := s.GetEntities(ctx, "user 1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Association{
+		{UserID: "user 1", EntityID: "entity 1", Role: api.Role_OWNER},
+	}, as)
+	assertCounts(t, s, map[string]int{"user 1": 1}, map[string]int{"entity 1": 1})
+}
+
+func testAddEntityLimits(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	addEntities(t, s, []*storage.Association{
+		{UserID: "user 1", EntityID: "entity 1"},
+		{UserID: "user 1", EntityID: "entity 2"},
+		{UserID: "user 2", EntityID: "entity 3"},
+		{UserID: "user 3", EntityID: "entity 3"},
+	})
+
+	// the entity's limit is checked before the user's
+	err := s.AddEntity(ctx, "user 1", "entity 3", api.Role_OWNER, testLimits)
+	assert.Equal(t, storage.ErrTooManyEntityUsers, err)
+	err = s.AddEntity(ctx, "user 1", "entity 4", api.Role_OWNER, testLimits)
+	assert.Equal(t, storage.ErrTooManyUserEntities, err)
+
+	// overrides raise or lower the limits
+	assert.Nil(t, s.SetUserLimit(ctx, "user 1", 3))
+	err = s.AddEntity(ctx, "user 1", "entity 4", api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+	assert.Nil(t, s.SetEntityLimit(ctx, "entity 5", 1))
+	err = s.AddEntity(ctx, "user 4", "entity 5", api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+	err = s.AddEntity(ctx, "user 5", "entity 5", api.Role_OWNER, testLimits)
+	assert.Equal(t, storage.ErrTooManyEntityUsers, err)
+
+	// a zero override restores the default
+	assert.Nil(t, s.SetEntityLimit(ctx, "entity 5", 0))
+	err = s.AddEntity(ctx, "user 5", "entity 5", api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+
+	// removed associations don't count toward the limits
+	assert.Nil(t, s.RemoveEntity(ctx, "user 2", "entity 3"))
+	err = s.AddEntity(ctx, "user 6", "entity 3", api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+}
+
+func testAddEntities(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	err := s.AddEntity(ctx, "user 1", "entity 1", api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+	assert.Nil(t, s.SetEntityLimit(ctx, "entity 3", 1))
+
+	errs, err := s.AddEntities(ctx, []*storage.Association{
+		{UserID: "user 1", EntityID: "entity 1"},
+		{UserID: "user 1", EntityID: "entity 2", Role: api.Role_ADMIN},
+		{UserID: "user 1", EntityID: "entity 3"},
+		{UserID: "user 2", EntityID: "entity 3"},
+		{UserID: "user 3", EntityID: "entity 3"},
+		{UserID: "user 2", EntityID: "entity 3"},
+		{UserID: "", EntityID: "entity 4"},
+		{UserID: "user 4", EntityID: ""},
+		{UserID: "user 4", EntityID: "entity 4", Role: api.Role(-1)},
+	}, testLimits)
+	assert.Nil(t, err)
+	assert.Equal(t, []error{
+		storage.ErrUserEntityExists,
+		nil,
+		storage.ErrTooManyUserEntities,
+		nil,
+		storage.ErrTooManyEntityUsers,
+		storage.ErrUserEntityExists,
+		api.ErrEmptyUserID,
+		api.ErrEmptyEntityID,
+		storage.ErrInvalidRole,
+	}, errs)
+
+	as, _, err := s.GetEntities(ctx, "user 1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Association{
+		{UserID: "user 1", EntityID: "entity 1", Role: api.Role_OWNER},
+		{UserID: "user 1", EntityID: "entity 2", Role: api.Role_ADMIN},
+	}, as)
+	assertCounts(t, s, map[string]int{"user 1": 2, "user 2": 1, "user 3": 0, "user 4": 0},
+		map[string]int{"entity 3": 1, "entity 4": 0})
+}
+
+func testRemoveEntity(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	err := s.RemoveEntity(ctx, "user 1", "entity 1")
+	assert.Equal(t, storage.ErrUserEntityNotExists, err)
+
+	err = s.AddEntity(ctx, "user 1", "entity 1", api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+	assert.Nil(t, s.RemoveEntity(ctx, "user 1", "entity 1"))
+	err = s.RemoveEntity(ctx, "user 1", "entity 1")
+	assert.Equal(t, storage.ErrUserEntityNotExists, err)
+	as, _, err := s.GetEntities(ctx, "user 1", nil)
+	assert.Nil(t, err)
+	assert.Empty(t, as)
+	userIDs, _, err := s.GetUsers(ctx, "entity 1", nil)
+	assert.Nil(t, err)
+	assert.Empty(t, userIDs)
+	assertCounts(t, s, map[string]int{"user 1": 0}, map[string]int{"entity 1": 0})
+
+	// a removed association can be added again
+	err = s.AddEntity(ctx, "user 1", "entity 1", api.Role_ADMIN, testLimits)
+	assert.Nil(t, err)
+	as, _, err = s.GetEntities(ctx, "user 1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Association{
+		{UserID: "user 1", EntityID: "entity 1", Role: api.Role_ADMIN},
+	}, as)
+}
+
+func testUpdateRole(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	err := s.UpdateRole(ctx, "user 1", "entity 1", api.Role_ADMIN)
+	assert.Equal(t, storage.ErrUserEntityNotExists, err)
+
+	err = s.AddEntity(ctx, "user 1", "entity 1", api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+	time.Sleep(tick)
+	assert.Nil(t, s.UpdateRole(ctx, "user 1", "entity 1", api.Role_ADMIN))
+
+	// updating to the current role does nothing
+	assert.Nil(t, s.UpdateRole(ctx, "user 1", "entity 1", api.Role_ADMIN))
+
+	as, _, err := s.GetEntities(ctx, "user 1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Association{
+		{UserID: "user 1", EntityID: "entity 1", Role: api.Role_ADMIN},
+	}, as)
+	assertCounts(t, s, map[string]int{"user 1": 1}, map[string]int{"entity 1": 1})
+
+	events, err := s.GetHistory(ctx, "user 1", "entity 1")
+	assert.Nil(t, err)
+	assert.Equal(t, []eventSummary{
+		{api.EventType_ADDED, "user 1", "entity 1", api.Role_OWNER},
+		{api.EventType_ROLE_UPDATED, "user 1", "entity 1", api.Role_ADMIN},
+	}, summarize(events))
+
+	// a removed association's role can't be updated
+	assert.Nil(t, s.RemoveEntity(ctx, "user 1", "entity 1"))
+	err = s.UpdateRole(ctx, "user 1", "entity 1", api.Role_OWNER)
+	assert.Equal(t, storage.ErrUserEntityNotExists, err)
+}
+
+func testGetEntitiesBatch(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	addEntities(t, s, []*storage.Association{
+		{UserID: "user 1", EntityID: "entity 1", Role: api.Role_OWNER},
+		{UserID: "user 1", EntityID: "entity 2", Role: api.Role_ADMIN},
+		{UserID: "user 2", EntityID: "entity 1", Role: api.Role_CLINICIAN},
+		{UserID: "user 2", EntityID: "entity 3", Role: api.Role_ADMIN},
+	})
+	assert.Nil(t, s.RemoveEntity(ctx, "user 1", "entity 2"))
+	assert.Nil(t, s.UpdateRole(ctx, "user 2", "entity 1", api.Role_ADMIN))
+	_, err := s.ImportAssociation(ctx, []*storage.AssociationVersion{
+		storage.NewAssociationVersion("user 3", "entity 1", api.Role_OWNER),
+	}, false)
+	assert.Nil(t, err)
+
+	// each user's entities in the batch are the same as those they get on their own
+	userIDs := []string{"user 1", "user 2", "user 3", "user 4"}
+	batch, err := s.GetEntitiesBatch(ctx, userIDs)
+	assert.Nil(t, err)
+	assert.Len(t, batch, len(userIDs))
+	for _, userID := range userIDs {
+		as, _, err := s.GetEntities(ctx, userID, nil)
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, storage.EntityIDs(as), batch[userID], userID)
+	}
+	assert.ElementsMatch(t, []string{"entity 1", "entity 3"}, batch["user 2"])
+}
+
+func testGetEntitiesOrdering(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	entityIDs := []string{"entity 3", "entity 1", "entity 12", "entity 5", "entity 2"}
+	limits := &storage.Limits{MaxUserEntities: len(entityIDs), MaxEntityUsers: 1}
+	for _, entityID := range entityIDs {
+		err := s.AddEntity(ctx, "user 1", entityID, api.Role_OWNER, limits)
+		assert.Nil(t, err)
+	}
+	sorted := append([]string{}, entityIDs...)
+	sort.Strings(sorted)
+
+	as, next, err := s.GetEntities(ctx, "user 1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, sorted, storage.EntityIDs(as))
+	assert.Empty(t, next)
+
+	page := &storage.Page{Size: 2}
+	var pages [][]string
+	for {
+		as, next, err = s.GetEntities(ctx, "user 1", page)
+		assert.Nil(t, err)
+		pages = append(pages, storage.EntityIDs(as))
+		if next == "" {
+			break
+		}
+		page.Token = next
+	}
+	assert.Equal(t, [][]string{
+		{"entity 1", "entity 12"},
+		{"entity 2", "entity 3"},
+		{"entity 5"},
+	}, pages)
+
+	// a page of exactly the remaining associations has no next page
+	page = &storage.Page{Size: 1, Token: storage.NewKeysetToken("entity 3")}
+	as, next, err = s.GetEntities(ctx, "user 1", page)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"entity 5"}, storage.EntityIDs(as))
+	assert.Empty(t, next)
+
+	page = &storage.Page{Size: 1, Token: "not a page token!"}
+	as, _, err = s.GetEntities(ctx, "user 1", page)
+	assert.Equal(t, storage.ErrInvalidPageToken, err)
+	assert.Nil(t, as)
+	as, _, err = s.GetEntitiesAsOf(ctx, "user 1", time.Now(), page)
+	assert.Equal(t, storage.ErrInvalidPageToken, err)
+	assert.Nil(t, as)
+}
+
+func testGetUsersOrdering(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	userIDs := []string{"user 3", "user 1", "user 12", "user 5", "user 2"}
+	limits := &storage.Limits{MaxUserEntities: 1, MaxEntityUsers: len(userIDs)}
+	for _, userID := range userIDs {
+		err := s.AddEntity(ctx, userID, "entity 1", api.Role_OWNER, limits)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, s.RemoveEntity(ctx, "user 5", "entity 1"))
+
+	users, next, err := s.GetUsers(ctx, "entity 1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user 1", "user 12", "user 2", "user 3"}, users)
+	assert.Empty(t, next)
+
+	page := &storage.Page{Size: 2}
+	var pages [][]string
+	for {
+		users, next, err = s.GetUsers(ctx, "entity 1", page)
+		assert.Nil(t, err)
+		pages = append(pages, users)
+		if next == "" {
+			break
+		}
+		page.Token = next
+	}
+	assert.Equal(t, [][]string{
+		{"user 1", "user 12"},
+		{"user 2", "user 3"},
+	}, pages)
+
+	page = &storage.Page{Size: 1, Token: "not a page token!"}
+	users, _, err = s.GetUsers(ctx, "entity 1", page)
+	assert.Equal(t, storage.ErrInvalidPageToken, err)
+	assert.Nil(t, users)
+}
+
+func testGetEntitiesAsOf(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	limits := &storage.Limits{MaxUserEntities: 3, MaxEntityUsers: 1}
+	t0 := now()
+	err := s.AddEntity(ctx, "user 1", "entity 1", api.Role_OWNER, limits)
+	assert.Nil(t, err)
+	err = s.AddEntity(ctx, "user 1", "entity 2", api.Role_OWNER, limits)
+	assert.Nil(t, err)
+	t1 := now()
+	assert.Nil(t, s.RemoveEntity(ctx, "user 1", "entity 2"))
+	err = s.AddEntity(ctx, "user 1", "entity 3", api.Role_OWNER, limits)
+	assert.Nil(t, err)
+	assert.Nil(t, s.UpdateRole(ctx, "user 1", "entity 1", api.Role_ADMIN))
+	t2 := now()
+
+	as, _, err := s.GetEntitiesAsOf(ctx, "user 1", t0, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, as)
+
+	as, _, err = s.GetEntitiesAsOf(ctx, "user 1", t1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Association{
+		{UserID: "user 1", EntityID: "entity 1", Role: api.Role_OWNER},
+		{UserID: "user 1", EntityID: "entity 2", Role: api.Role_OWNER},
+	}, as)
+
+	as, _, err = s.GetEntitiesAsOf(ctx, "user 1", t2, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Association{
+		{UserID: "user 1", EntityID: "entity 1", Role: api.Role_ADMIN},
+		{UserID: "user 1", EntityID: "entity 3", Role: api.Role_OWNER},
+	}, as)
+
+	page := &storage.Page{Size: 1}
+	as, next, err := s.GetEntitiesAsOf(ctx, "user 1", t2, page)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"entity 1"}, storage.EntityIDs(as))
+	page.Token = next
+	as, next, err = s.GetEntitiesAsOf(ctx, "user 1", t2, page)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"entity 3"}, storage.EntityIDs(as))
+	assert.Empty(t, next)
+}
+
+func testGetHistory(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	ops := []func() error{
+		func() error { return s.AddEntity(ctx, "user 1", "entity 1", api.Role_OWNER, testLimits) },
+		func() error { return s.AddEntity(ctx, "user 1", "entity 2", api.Role_OWNER, testLimits) },
+		func() error { return s.AddEntity(ctx, "user 2", "entity 1", api.Role_ADMIN, testLimits) },
+		func() error { return s.UpdateRole(ctx, "user 1", "entity 1", api.Role_ADMIN) },
+		func() error { return s.RemoveEntity(ctx, "user 1", "entity 1") },
+	}
+	for _, op := range ops {
+		assert.Nil(t, op())
+		time.Sleep(tick)
+	}
+
+	events, err := s.GetHistory(ctx, "user 1", "")
+	assert.Nil(t, err)
+	assert.Equal(t, []eventSummary{
+		{api.EventType_ADDED, "user 1", "entity 1", api.Role_OWNER},
+		{api.EventType_ADDED, "user 1", "entity 2", api.Role_OWNER},
+		{api.EventType_ROLE_UPDATED, "user 1", "entity 1", api.Role_ADMIN},
+		{api.EventType_REMOVED, "user 1", "entity 1", api.Role_ADMIN},
+	}, summarize(events))
+	assertTimeOrdered(t, events)
+
+	events, err = s.GetHistory(ctx, "", "entity 1")
+	assert.Nil(t, err)
+	assert.Equal(t, []eventSummary{
+		{api.EventType_ADDED, "user 1", "entity 1", api.Role_OWNER},
+		{api.EventType_ADDED, "user 2", "entity 1", api.Role_ADMIN},
+		{api.EventType_ROLE_UPDATED, "user 1", "entity 1", api.Role_ADMIN},
+		{api.EventType_REMOVED, "user 1", "entity 1", api.Role_ADMIN},
+	}, summarize(events))
+	assertTimeOrdered(t, events)
+
+	events, err = s.GetHistory(ctx, "user 2", "entity 1")
+	assert.Nil(t, err)
+	assert.Equal(t, []eventSummary{
+		{api.EventType_ADDED, "user 2", "entity 1", api.Role_ADMIN},
+	}, summarize(events))
+
+	events, err = s.GetHistory(ctx, "user 3", "")
+	assert.Nil(t, err)
+	assert.Empty(t, events)
+}
+
+func testUsers(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	u, err := s.GetUser(ctx, "user 1")
+	assert.Equal(t, storage.ErrUserNotExists, err)
+	assert.Nil(t, u)
+	u, err = s.UpdateUser(ctx, &api.UserProfile{UserId: "user 1", Email: "a@example.com"})
+	assert.Equal(t, storage.ErrUserNotExists, err)
+	assert.Nil(t, u)
+
+	err = s.PutUser(ctx, &api.UserProfile{
+		UserId:      "user 1",
+		DisplayName: "User One",
+		Attributes:  map[string]string{"key1": "value1", "key2": "value2"},
+	})
+	assert.Nil(t, err)
+	u, err = s.UpdateUser(ctx, &api.UserProfile{
+		UserId:     "user 1",
+		Email:      "user.one@example.com",
+		Attributes: map[string]string{"key1": "", "key3": "value3"},
+	})
+	assert.Nil(t, err)
+	expected := &api.UserProfile{
+		UserId:      "user 1",
+		DisplayName: "User One",
+		Email:       "user.one@example.com",
+		Attributes:  map[string]string{"key2": "value2", "key3": "value3"},
+	}
+	assertUser(t, expected, u)
+	u, err = s.GetUser(ctx, "user 1")
+	assert.Nil(t, err)
+	assertUser(t, expected, u)
+
+	// put replaces the whole profile
+	err = s.PutUser(ctx, &api.UserProfile{UserId: "user 1", Locale: "en-US"})
+	assert.Nil(t, err)
+	u, err = s.GetUser(ctx, "user 1")
+	assert.Nil(t, err)
+	assertUser(t, &api.UserProfile{UserId: "user 1", Locale: "en-US"}, u)
+}
+
+func testWatch(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+
+	// the first event's revision is at least 1, so watching from 1 includes all the later ones
+	err := s.AddEntity(ctx, "user 2", "entity 2", api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+	err = s.AddEntity(ctx, "user 1", "entity 1", api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+	assert.Nil(t, s.UpdateRole(ctx, "user 1", "entity 1", api.Role_ADMIN))
+	assert.Nil(t, s.RemoveEntity(ctx, "user 1", "entity 1"))
+
+	errStop := errors.New("stop watch")
+	events := make([]*storage.AssociationEvent, 0)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	err = s.Watch(ctx, "user 1", "", 1, func(e *storage.AssociationEvent) error {
+		events = append(events, e)
+		if len(events) == 3 {
+			return errStop
+		}
+		return nil
+	})
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, []eventSummary{
+		{api.EventType_ADDED, "user 1", "entity 1", api.Role_OWNER},
+		{api.EventType_ROLE_UPDATED, "user 1", "entity 1", api.Role_ADMIN},
+		{api.EventType_REMOVED, "user 1", "entity 1", api.Role_ADMIN},
+	}, summarize(events))
+	for i := 1; i < len(events); i++ {
+		assert.True(t, events[i].Revision > events[i-1].Revision, i)
+	}
+
+	// watching from after the latest event fails rather than skipping the events until then
+	err = s.Watch(ctx, "user 1", "", 1<<40, func(e *storage.AssociationEvent) error {
+		return errStop
+	})
+	assert.Equal(t, storage.ErrFutureRevision, err)
+}
+
+func testExportImport(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	addEntities(t, s, []*storage.Association{
+		{UserID: "user 2", EntityID: "entity 1", Role: api.Role_ADMIN},
+		{UserID: "user 1", EntityID: "entity 2", Role: api.Role_OWNER},
+		{UserID: "user 1", EntityID: "entity 1", Role: api.Role_OWNER},
+	})
+	time.Sleep(tick)
+	assert.Nil(t, s.UpdateRole(ctx, "user 1", "entity 1", api.Role_ADMIN))
+	time.Sleep(tick)
+	assert.Nil(t, s.RemoveEntity(ctx, "user 1", "entity 2"))
+
+	exported := exportAssociations(t, s)
+	assert.Equal(t, []versionSummary{
+		{"user 1", "entity 1", api.Role_OWNER, true, false, true},
+		{"user 1", "entity 1", api.Role_ADMIN, false, true, false},
+	}, summarizeVersions(exported[0]))
+	assert.Equal(t, []versionSummary{
+		{"user 1", "entity 2", api.Role_OWNER, true, false, false},
+	}, summarizeVersions(exported[1]))
+	assert.Equal(t, []versionSummary{
+		{"user 2", "entity 1", api.Role_ADMIN, false, false, false},
+	}, summarizeVersions(exported[2]))
+	assert.Len(t, exported, 3)
+
+	// importing existing associations fails unless overwriting, which leaves them as they were
+	for _, versions := range exported {
+		overwritten, err := s.ImportAssociation(ctx, versions, false)
+		assert.Equal(t, storage.ErrUserEntityExists, err)
+		assert.False(t, overwritten)
+		overwritten, err = s.ImportAssociation(ctx, versions, true)
+		assert.Nil(t, err)
+		assert.True(t, overwritten)
+	}
+	reexported := exportAssociations(t, s)
+	if assert.Len(t, reexported, len(exported)) {
+		for i, versions := range exported {
+			assertVersions(t, versions, reexported[i])
+		}
+	}
+
+	// a new association keeps its history, and one overwritten with a removed version is no
+	// longer current
+	t0 := time.Now().Add(-1 * time.Hour).Truncate(time.Second)
+	t1, t2 := t0.Add(1*time.Minute), t0.Add(2*time.Minute)
+	imported := []*storage.AssociationVersion{
+		{UserID: "user 3", EntityID: "entity 1", Role: api.Role_OWNER, AddedTime: t0,
+			Removed: true, RemovedTime: t1, Superseded: true},
+		{UserID: "user 3", EntityID: "entity 1", Role: api.Role_ADMIN, AddedTime: t1,
+			RoleUpdated: true},
+	}
+	overwritten, err := s.ImportAssociation(ctx, imported, false)
+	assert.Nil(t, err)
+	assert.False(t, overwritten)
+	removed := []*storage.AssociationVersion{
+		{UserID: "user 2", EntityID: "entity 1", Role: api.Role_ADMIN, AddedTime: t0,
+			Removed: true, RemovedTime: t2},
+	}
+	overwritten, err = s.ImportAssociation(ctx, removed, true)
+	assert.Nil(t, err)
+	assert.True(t, overwritten)
+
+	users, _, err := s.GetUsers(ctx, "entity 1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user 1", "user 3"}, users)
+	assertCounts(t, s,
+		map[string]int{"user 1": 1, "user 2": 0, "user 3": 1},
+		map[string]int{"entity 1": 2, "entity 2": 0},
+	)
+	as, _, err := s.GetEntitiesAsOf(ctx, "user 3", t0.Add(30*time.Second), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Association{
+		{UserID: "user 3", EntityID: "entity 1", Role: api.Role_OWNER},
+	}, as)
+	events, err := s.GetHistory(ctx, "", "entity 1")
+	assert.Nil(t, err)
+	assert.Contains(t, summarize(events),
+		eventSummary{api.EventType_ROLE_UPDATED, "user 3", "entity 1", api.Role_ADMIN})
+	assert.Contains(t, summarize(events),
+		eventSummary{api.EventType_REMOVED, "user 2", "entity 1", api.Role_ADMIN})
+	assertTimeOrdered(t, events)
+
+	exported = exportAssociations(t, s)
+	assert.Len(t, exported, 4)
+	assertVersions(t, removed, exported[2])
+	assertVersions(t, imported, exported[3])
+}
+
+func testImportWatch(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	err := s.AddEntity(ctx, "user 2", "entity 2", api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+
+	// watches get the imported association's current state rather than its history
+	t0 := time.Now().Add(-1 * time.Hour).Truncate(time.Second)
+	t1, t2 := t0.Add(1*time.Minute), t0.Add(2*time.Minute)
+	_, err = s.ImportAssociation(ctx, []*storage.AssociationVersion{
+		{UserID: "user 1", EntityID: "entity 1", Role: api.Role_OWNER, AddedTime: t0,
+			Removed: true, RemovedTime: t1, Superseded: true},
+		{UserID: "user 1", EntityID: "entity 1", Role: api.Role_ADMIN, AddedTime: t1,
+			RoleUpdated: true, Removed: true, RemovedTime: t2},
+	}, false)
+	assert.Nil(t, err)
+	err = s.AddEntity(ctx, "user 1", "entity 2", api.Role_OWNER, testLimits)
+	assert.Nil(t, err)
+
+	errStop := errors.New("stop watch")
+	events := make([]*storage.AssociationEvent, 0)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	err = s.Watch(ctx, "user 1", "", 1, func(e *storage.AssociationEvent) error {
+		events = append(events, e)
+		if len(events) == 2 {
+			return errStop
+		}
+		return nil
+	})
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, []eventSummary{
+		{api.EventType_REMOVED, "user 1", "entity 1", api.Role_ADMIN},
+		{api.EventType_ADDED, "user 1", "entity 2", api.Role_OWNER},
+	}, summarize(events))
+}
+
+func testEmptyIDs(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	cases := map[string]struct {
+		call     func() error
+		expected error
+	}{
+		"AddEntity user": {
+			call:     func() error { return s.AddEntity(ctx, "", "e", api.Role_OWNER, testLimits) },
+			expected: api.ErrEmptyUserID,
+		},
+		"AddEntity entity": {
+			call:     func() error { return s.AddEntity(ctx, "u", "", api.Role_OWNER, testLimits) },
+			expected: api.ErrEmptyEntityID,
+		},
+		"RemoveEntity user": {
+			call:     func() error { return s.RemoveEntity(ctx, "", "e") },
+			expected: api.ErrEmptyUserID,
+		},
+		"RemoveEntity entity": {
+			call:     func() error { return s.RemoveEntity(ctx, "u", "") },
+			expected: api.ErrEmptyEntityID,
+		},
+		"UpdateRole user": {
+			call:     func() error { return s.UpdateRole(ctx, "", "e", api.Role_ADMIN) },
+			expected: api.ErrEmptyUserID,
+		},
+		"UpdateRole entity": {
+			call:     func() error { return s.UpdateRole(ctx, "u", "", api.Role_ADMIN) },
+			expected: api.ErrEmptyEntityID,
+		},
+		"GetEntities": {
+			call: func() error {
+				_, _, err := s.GetEntities(ctx, "", nil)
+				return err
+			},
+			expected: api.ErrEmptyUserID,
+		},
+		"GetEntitiesAsOf": {
+			call: func() error {
+				_, _, err := s.GetEntitiesAsOf(ctx, "", time.Now(), nil)
+				return err
+			},
+			expected: api.ErrEmptyUserID,
+		},
+		"GetEntitiesBatch": {
+			call: func() error {
+				_, err := s.GetEntitiesBatch(ctx, []string{"u", ""})
+				return err
+			},
+			expected: api.ErrEmptyUserID,
+		},
+		"GetUsers": {
+			call: func() error {
+				_, _, err := s.GetUsers(ctx, "", nil)
+				return err
+			},
+			expected: api.ErrEmptyEntityID,
+		},
+		"CountEntities": {
+			call: func() error {
+				_, err := s.CountEntities(ctx, "")
+				return err
+			},
+			expected: api.ErrEmptyUserID,
+		},
+		"CountUsers": {
+			call: func() error {
+				_, err := s.CountUsers(ctx, "")
+				return err
+			},
+			expected: api.ErrEmptyEntityID,
+		},
+		"GetHistory": {
+			call: func() error {
+				_, err := s.GetHistory(ctx, "", "")
+				return err
+			},
+			expected: api.ErrEmptyUserAndEntityID,
+		},
+		"SetUserLimit": {
+			call:     func() error { return s.SetUserLimit(ctx, "", 1) },
+			expected: api.ErrEmptyUserID,
+		},
+		"SetEntityLimit": {
+			call:     func() error { return s.SetEntityLimit(ctx, "", 1) },
+			expected: api.ErrEmptyEntityID,
+		},
+		"PutUser": {
+			call:     func() error { return s.PutUser(ctx, &api.UserProfile{}) },
+			expected: api.ErrEmptyUserID,
+		},
+		"GetUser": {
+			call: func() error {
+				_, err := s.GetUser(ctx, "")
+				return err
+			},
+			expected: api.ErrEmptyUserID,
+		},
+		"UpdateUser": {
+			call: func() error {
+				_, err := s.UpdateUser(ctx, &api.UserProfile{})
+				return err
+			},
+			expected: api.ErrEmptyUserID,
+		},
+		"Watch": {
+			call: func() error {
+				return s.Watch(ctx, "", "", 0, func(*storage.AssociationEvent) error {
+					return nil
+				})
+			},
+			expected: api.ErrEmptyUserAndEntityID,
+		},
+	}
+	for desc, c := range cases {
+		assert.Equal(t, c.expected, c.call(), desc)
+	}
+}
+
+func testConcurrentAdds(t *testing.T, s storage.Storer) {
+	limits := &storage.Limits{MaxUserEntities: nConcurrent / 2, MaxEntityUsers: nConcurrent}
+	errs := concurrently(func(i int) error {
+		entityID := fmt.Sprintf("entity %d", i)
+		return s.AddEntity(context.Background(), "user 1", entityID, api.Role_OWNER, limits)
+	})
+
+	// the limit checks and inserts are atomic, so the adds never exceed the limit
+	nAdded := 0
+	for _, err := range errs {
+		if err == nil {
+			nAdded++
+		} else if err != storage.ErrTooManyUserEntities {
+			t.Logf("concurrent add failed: %v", err)
+		}
+	}
+	assert.True(t, nAdded > 0)
+	assert.True(t, nAdded <= limits.MaxUserEntities)
+	assertCounts(t, s, map[string]int{"user 1": nAdded}, nil)
+}
+
+func testConcurrentDuplicateAdds(t *testing.T, s storage.Storer) {
+	errs := concurrently(func(int) error {
+		return s.AddEntity(context.Background(), "user 1", "entity 1", api.Role_OWNER,
+			testLimits)
+	})
+
+	// at most one add of the same association succeeds
+	nAdded := 0
+	for _, err := range errs {
+		if err == nil {
+			nAdded++
+		} else if err != storage.ErrUserEntityExists {
+			t.Logf("concurrent add failed: %v", err)
+		}
+	}
+	assert.Equal(t, 1, nAdded)
+	assertCounts(t, s, map[string]int{"user 1": 1}, map[string]int{"entity 1": 1})
+}
+
+// concurrently calls the function nConcurrent times concurrently, returning the error of each
+// call.
+func concurrently(call func(i int) error) []error {
+	errs := make([]error, nConcurrent)
+	start := make(chan struct{})
+	wg := new(sync.WaitGroup)
+	for i := 0; i < nConcurrent; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = call(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+func addEntities(t *testing.T, s storage.Storer, as []*storage.Association) {
+	for _, a := range as {
+		err := s.AddEntity(context.Background(), a.UserID, a.EntityID, a.Role, testLimits)
+		assert.Nil(t, err)
+	}
+}
+
+func assertCounts(t *testing.T, s storage.Storer, userEntities, entityUsers map[string]int) {
+	for userID, expected := range userEntities {
+		n, err := s.CountEntities(context.Background(), userID)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, n, userID)
+	}
+	for entityID, expected := range entityUsers {
+		n, err := s.CountUsers(context.Background(), entityID)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, n, entityID)
+	}
+}
+
+// assertUser checks the profile's fields, treating nil and empty attributes the same since
+// backends may return either.
+func assertUser(t *testing.T, expected, actual *api.UserProfile) {
+	if !assert.NotNil(t, actual) {
+		return
+	}
+	assert.Equal(t, expected.UserId, actual.UserId)
+	assert.Equal(t, expected.DisplayName, actual.DisplayName)
+	assert.Equal(t, expected.Email, actual.Email)
+	assert.Equal(t, expected.Phone, actual.Phone)
+	assert.Equal(t, expected.Locale, actual.Locale)
+	assert.Equal(t, expected.Timezone, actual.Timezone)
+	assert.Equal(t, len(expected.Attributes), len(actual.Attributes))
+	for k, v := range expected.Attributes {
+		assert.Equal(t, v, actual.Attributes[k], k)
+	}
+}
+
+func assertTimeOrdered(t *testing.T, events []*storage.AssociationEvent) {
+	for i := 1; i < len(events); i++ {
+		assert.False(t, events[i].Time.Before(events[i-1].Time), i)
+	}
+}
+
+// eventSummary is an association event without the time and revision, which vary by backend.
+type eventSummary struct {
+	Type     api.EventType
+	UserID   string
+	EntityID string
+	Role     api.Role
+}
+
+func summarize(events []*storage.AssociationEvent) []eventSummary {
+	summaries := make([]eventSummary, len(events))
+	for i, e := range events {
+		summaries[i] = eventSummary{e.Type, e.UserID, e.EntityID, e.Role}
+	}
+	return summaries
+}
+
+func exportAssociations(t *testing.T, s storage.Storer) [][]*storage.AssociationVersion {
+	exported := make([][]*storage.AssociationVersion, 0)
+	err := s.ExportAssociations(context.Background(),
+		func(versions []*storage.AssociationVersion) error {
+			exported = append(exported, versions)
+			return nil
+		})
+	assert.Nil(t, err)
+	return exported
+}
+
+// versionSummary is an association version without its times, which vary by backend.
+type versionSummary struct {
+	UserID      string
+	EntityID    string
+	Role        api.Role
+	Removed     bool
+	RoleUpdated bool
+	Superseded  bool
+}
+
+func summarizeVersions(versions []*storage.AssociationVersion) []versionSummary {
+	summaries := make([]versionSummary, len(versions))
+	for i, v := range versions {
+		summaries[i] = versionSummary{v.UserID, v.EntityID, v.Role, v.Removed, v.RoleUpdated,
+			v.Superseded}
+	}
+	return summaries
+}
+
+// assertVersions checks the versions' fields, comparing times as instants since backends may
+// return them in a different location.
+func assertVersions(t *testing.T, expected, actual []*storage.AssociationVersion) {
+	if !assert.Equal(t, summarizeVersions(expected), summarizeVersions(actual)) {
+		return
+	}
+	for i, v := range expected {
+		assert.True(t, v.AddedTime.Equal(actual[i].AddedTime), i)
+		assert.Equal(t, v.Removed, actual[i].Removed, i)
+		if v.Removed {
+			assert.True(t, v.RemovedTime.Equal(actual[i].RemovedTime), i)
+		}
+	}
+}
+
+// now returns the current time, separated from the operations before and after it by a tick.
+func now() time.Time {
+	time.Sleep(tick)
+	defer time.Sleep(tick)
+	return time.Now()
+}
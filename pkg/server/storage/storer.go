@@ -1,35 +1,403 @@
 package storage
 
 import (
+	"context"
+	"encoding/base64"
+	"sort"
 	"time"
 
-	bstorage "github.com/elixirhealth/service-base/pkg/server/storage"
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/pkg/errors"
 	"go.uber.org/zap/zapcore"
 )
 
+// Type is a kind of storage backend.
+type Type int
+
+const (
+	// Unspecified indicates when no storage type is set.
+	Unspecified Type = iota
+
+	// Memory indicates ephemeral, in-memory storage.
+	Memory
+
+	// DataStore indicates GCP DataStore storage.
+	DataStore
+
+	// Postgres indicates Postgres storage.
+	Postgres
+
+	// Local indicates embedded on-disk storage.
+	Local
+)
+
+func (t Type) String() string {
+	switch t {
+	case Memory:
+		return "Memory"
+	case DataStore:
+		return "DataStore"
+	case Postgres:
+		return "Postgres"
+	case Local:
+		return "Local"
+	default:
+		return "Unspecified"
+	}
+}
+
 var (
 	// DefaultType is the default storage type.
-	DefaultType = bstorage.Memory
+	DefaultType = Memory
 
 	// DefaultQueryTimeout is the default timeout for DataStore queries.
 	DefaultQueryTimeout = 1 * time.Second
+
+	// ErrTooManyUserEntities indicates when associating another entity ID with a given user ID
+	// would put the total associated entities above the max value.
+	ErrTooManyUserEntities = errors.New("too many associated entities for user ID")
+
+	// ErrTooManyEntityUsers indicates when associating another user ID with the given entity ID
+	// would put the total associated users above the max value.
+	ErrTooManyEntityUsers = errors.New("too many associated users for entity ID")
+
+	// ErrUserEntityExists indicates when a (user ID, entity ID) association already exists.
+	ErrUserEntityExists = errors.New("user-entity association already exists")
+
+	// ErrUserEntityNotExists indicates when a (user ID, entity ID) association does not exist
+	// or has already been removed.
+	ErrUserEntityNotExists = errors.New("user-entity association does not exist")
+
+	// ErrInvalidRole indicates when a role isn't one of the defined api.Role values.
+	ErrInvalidRole = api.ErrInvalidRole
+
+	// ErrInvalidPageToken indicates when a page token isn't one returned with a previous page.
+	ErrInvalidPageToken = api.ErrInvalidPageToken
+
+	// ErrUserNotExists indicates when a user has no stored profile.
+	ErrUserNotExists = errors.New("user profile does not exist")
 )
 
-// Storer stores and retrieves user attributes.
+// Storer stores and retrieves user attributes. Each method is bound by the given context as well
+// as by the relevant query timeout in Parameters, whichever ends first.
 type Storer interface {
-	AddEntity(userID, entityID string) error
-	GetEntities(userID string) ([]string, error)
-	CountEntities(userID string) (int, error)
-	CountUsers(entityID string) (int, error)
+	UserStorer
+
+	// AddEntity associates the entity ID with the user ID if doing so does not put either above
+	// its limit, which is the override set via SetUserLimit or SetEntityLimit if one exists and
+	// the given default limit otherwise. The limit checks and the insert happen atomically.
+	AddEntity(
+		ctx context.Context, userID, entityID string, role api.Role, limits *Limits,
+	) error
+
+	// AddEntities adds each association as AddEntity would, returning the error (nil if it was
+	// added) for each in the same order. Limits are checked against the existing associations
+	// plus those earlier in the batch. The second return value is non-nil only when the batch
+	// as a whole failed, in which case none of the associations were added.
+	AddEntities(ctx context.Context, as []*Association, limits *Limits) ([]error, error)
+
+	RemoveEntity(ctx context.Context, userID, entityID string) error
+
+	// GetEntities returns a page of the user's current associations, each with its entity ID
+	// and role, ordered by entity ID. The second return value is the token for the next page,
+	// which is empty when there are no more associations. A nil page returns all of them.
+	GetEntities(ctx context.Context, userID string, page *Page) ([]*Association, string, error)
+
+	// GetEntitiesAsOf returns a page of the user's associations, with the roles they had, at
+	// the given time, as GetEntities does for the current associations.
+	GetEntitiesAsOf(
+		ctx context.Context, userID string, asOf time.Time, page *Page,
+	) ([]*Association, string, error)
+
+	// GetEntitiesBatch returns the entity IDs currently associated with each of the user IDs.
+	GetEntitiesBatch(ctx context.Context, userIDs []string) (map[string][]string, error)
+
+	// GetUsers returns a page of the IDs of the users currently associated with the entity,
+	// ordered by user ID, along with the token for the next page, as GetEntities does.
+	GetUsers(ctx context.Context, entityID string, page *Page) ([]string, string, error)
+
+	CountEntities(ctx context.Context, userID string) (int, error)
+	CountUsers(ctx context.Context, entityID string) (int, error)
+	GetHistory(ctx context.Context, userID, entityID string) ([]*AssociationEvent, error)
+
+	// UpdateRole changes the role of the user's current association with the entity, recording
+	// a ROLE_UPDATED event in its history. Updating to the association's current role does
+	// nothing.
+	UpdateRole(ctx context.Context, userID, entityID string, role api.Role) error
+
+	// SetUserLimit overrides the max number of entities the user can be associated with. A zero
+	// maxEntities removes any existing override.
+	SetUserLimit(ctx context.Context, userID string, maxEntities int) error
+
+	// SetEntityLimit overrides the max number of users the entity can be associated with. A zero
+	// maxUsers removes any existing override.
+	SetEntityLimit(ctx context.Context, entityID string, maxUsers int) error
+
+	// Watch sends each association event for the user ID and/or entity ID, either of which may
+	// be empty to match any, as it happens until the context is done or sending fails. A
+	// non-zero fromRevision first sends the retained events after that revision, or returns
+	// ErrRevisionCompacted if some of them are no longer retained and ErrFutureRevision if it's
+	// after the latest event. Watch returns ErrWatchInterrupted if it had to stop before the
+	// context was done.
+	Watch(
+		ctx context.Context,
+		userID, entityID string,
+		fromRevision uint64,
+		send func(*AssociationEvent) error,
+	) error
+
+	// ExportAssociations sends the versions of each association, oldest first, with the
+	// associations ordered by user ID and then entity ID. It is bound only by the given context
+	// since it reads every association, and it stops if sending fails.
+	ExportAssociations(ctx context.Context, send func([]*AssociationVersion) error) error
+
+	// ImportAssociation stores the given versions of a single association, oldest first, as
+	// they are, including their times, and sends watches a single event with its current state
+	// (see ImportedEvent) rather than its history. If the association already has versions, it
+	// returns ErrUserEntityExists unless overwrite is set, in which case the existing versions
+	// are replaced. The returned bool indicates whether they were. Limits aren't checked.
+	ImportAssociation(
+		ctx context.Context, versions []*AssociationVersion, overwrite bool,
+	) (bool, error)
+
 	Close() error
 }
 
+// ChangeFeed is implemented by the Storers whose backends send the association events of every
+// Storer sharing them, so that each server can see the changes made through the others.
+type ChangeFeed interface {
+	// SubscribeChanges returns a Subscription to the events of all associations, which ends
+	// with ErrWatchInterrupted if events may have been missed.
+	SubscribeChanges() (*Subscription, error)
+
+	// UnsubscribeChanges ends the Subscription.
+	UnsubscribeChanges(sub *Subscription)
+}
+
+// UserStorer stores and retrieves user profiles. Each method is bound by the given context as
+// well as by the relevant query timeout in Parameters, whichever ends first.
+type UserStorer interface {
+	// PutUser creates the user's profile or replaces it if one already exists.
+	PutUser(ctx context.Context, u *api.UserProfile) error
+
+	// GetUser returns the user's profile or ErrUserNotExists if it has none.
+	GetUser(ctx context.Context, userID string) (*api.UserProfile, error)
+
+	// UpdateUser atomically merges the given profile into the user's existing one (see
+	// MergeUser), returning the result, or returns ErrUserNotExists if the user has no profile.
+	UpdateUser(ctx context.Context, u *api.UserProfile) (*api.UserProfile, error)
+}
+
+// MergeUser updates the existing profile with the non-empty fields of the update. Attributes are
+// merged key by key, with an empty value removing the attribute.
+func MergeUser(existing, update *api.UserProfile) {
+	mergeField(&existing.DisplayName, update.DisplayName)
+	mergeField(&existing.Email, update.Email)
+	mergeField(&existing.Phone, update.Phone)
+	mergeField(&existing.Locale, update.Locale)
+	mergeField(&existing.Timezone, update.Timezone)
+	if len(update.Attributes) > 0 && existing.Attributes == nil {
+		existing.Attributes = make(map[string]string)
+	}
+	for k, v := range update.Attributes {
+		if v == "" {
+			delete(existing.Attributes, k)
+			continue
+		}
+		existing.Attributes[k] = v
+	}
+}
+
+func mergeField(existing *string, update string) {
+	if update != "" {
+		*existing = update
+	}
+}
+
+// Page selects a page of ordered results.
+type Page struct {
+	// Size is the max number of results in the page, with zero meaning no limit.
+	Size int
+
+	// Token is the next page token returned with the previous page, or empty for the first
+	// page.
+	Token string
+}
+
+// Limited returns whether the page limits the number of results.
+func (p *Page) Limited() bool {
+	return p != nil && p.Size > 0
+}
+
+// NewKeysetToken returns a page token for keyset paging, where the next page continues after the
+// given ID.
+func NewKeysetToken(lastID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(lastID))
+}
+
+// ParseKeysetToken returns the ID after which the page continues, which is empty for the first
+// page.
+func (p *Page) ParseKeysetToken() (string, error) {
+	if p == nil {
+		return "", nil
+	}
+	lastID, err := base64.RawURLEncoding.DecodeString(p.Token)
+	if err != nil {
+		return "", ErrInvalidPageToken
+	}
+	return string(lastID), nil
+}
+
+// Limits bounds the number of associations a single user or entity can have.
+type Limits struct {
+	// MaxUserEntities is the maximum number of entities a user can be associated with.
+	MaxUserEntities int
+
+	// MaxEntityUsers is the maximum number of users that can be associated with a single
+	// entity.
+	MaxEntityUsers int
+}
+
+// Association is a user-entity association along with the user's role for the entity.
+type Association struct {
+	UserID   string
+	EntityID string
+	Role     api.Role
+}
+
+// AssociationID identifies a user-entity association regardless of its role.
+type AssociationID struct {
+	UserID   string
+	EntityID string
+}
+
+// ID returns the association's user and entity IDs.
+func (a *Association) ID() AssociationID {
+	return AssociationID{UserID: a.UserID, EntityID: a.EntityID}
+}
+
+// EntityIDs returns the entity IDs of the associations, in the same order.
+func EntityIDs(as []*Association) []string {
+	entityIDs := make([]string, len(as))
+	for i, a := range as {
+		entityIDs[i] = a.EntityID
+	}
+	return entityIDs
+}
+
+// Validate checks that the user and entity IDs are populated and that the role is defined.
+func (a *Association) Validate() error {
+	if a.UserID == "" {
+		return api.ErrEmptyUserID
+	}
+	if a.EntityID == "" {
+		return api.ErrEmptyEntityID
+	}
+	if _, in := api.Role_name[int32(a.Role)]; !in {
+		return ErrInvalidRole
+	}
+	return nil
+}
+
+// AssociationCounts describes the existing associations of the users and entities in a batch of
+// associations to add.
+type AssociationCounts struct {
+	// Existing contains the current associations.
+	Existing map[AssociationID]struct{}
+
+	// EntityUsers and UserEntities are the number of current associations for each entity and
+	// user ID, respectively.
+	EntityUsers  map[string]int
+	UserEntities map[string]int
+
+	// MaxEntityUsers and MaxUserEntities are the limit overrides for each entity and user ID,
+	// respectively.
+	MaxEntityUsers  map[string]int
+	MaxUserEntities map[string]int
+}
+
+// NewAssociationCounts creates a new, empty *AssociationCounts.
+func NewAssociationCounts() *AssociationCounts {
+	return &AssociationCounts{
+		Existing:        make(map[AssociationID]struct{}),
+		EntityUsers:     make(map[string]int),
+		UserEntities:    make(map[string]int),
+		MaxEntityUsers:  make(map[string]int),
+		MaxUserEntities: make(map[string]int),
+	}
+}
+
+// CheckAdds returns the error, if any, that prevents each association from being added, given
+// the default limits and the associations before it in the batch that can be added. The counts
+// are updated as if those associations were added.
+func (c *AssociationCounts) CheckAdds(as []*Association, limits *Limits) []error {
+	errs := make([]error, len(as))
+	for i, a := range as {
+		if errs[i] = a.Validate(); errs[i] != nil {
+			continue
+		}
+		if _, in := c.Existing[a.ID()]; in {
+			errs[i] = ErrUserEntityExists
+			continue
+		}
+		maxUsers := orDefault(c.MaxEntityUsers[a.EntityID], limits.MaxEntityUsers)
+		if c.EntityUsers[a.EntityID]+1 > maxUsers {
+			errs[i] = ErrTooManyEntityUsers
+			continue
+		}
+		maxEntities := orDefault(c.MaxUserEntities[a.UserID], limits.MaxUserEntities)
+		if c.UserEntities[a.UserID]+1 > maxEntities {
+			errs[i] = ErrTooManyUserEntities
+			continue
+		}
+		c.Existing[a.ID()] = struct{}{}
+		c.EntityUsers[a.EntityID]++
+		c.UserEntities[a.UserID]++
+	}
+	return errs
+}
+
+func orDefault(override, dflt int) int {
+	if override > 0 {
+		return override
+	}
+	return dflt
+}
+
+// AssociationEvent is the addition, removal, or role update of a user-entity association at a
+// point in time. The role is the association's role as of the event. The revision orders events
+// sent by Watch and is zero for those from GetHistory.
+type AssociationEvent struct {
+	Type     api.EventType
+	Time     time.Time
+	UserID   string
+	EntityID string
+	Role     api.Role
+	Revision uint64
+}
+
+// SortEvents sorts the given events by ascending time, keeping the original order of events with
+// the same time.
+func SortEvents(events []*AssociationEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+}
+
 // Parameters defines the parameters of the Storer.
 type Parameters struct {
-	Type              bstorage.Type
+	Type              Type
 	AddQueryTimeout   time.Duration
 	GetQueryTimeout   time.Duration
 	CountQueryTimeout time.Duration
+
+	// CacheSize is the max number of entity lists and counts to cache (see NewCaching), with
+	// zero disabling the cache. The cache serves reads, not the limit checks of adds. A backend
+	// shared with other servers needs a ChangeFeed for caching.
+	CacheSize int
+
+	// CacheTTL is how long a cached entity list or count is used before it's read again.
+	CacheTTL time.Duration
 }
 
 // NewDefaultParameters returns a *Parameters object with default values.
@@ -39,6 +407,8 @@ func NewDefaultParameters() *Parameters {
 		AddQueryTimeout:   DefaultQueryTimeout,
 		GetQueryTimeout:   DefaultQueryTimeout,
 		CountQueryTimeout: DefaultQueryTimeout,
+		CacheSize:         DefaultCacheSize,
+		CacheTTL:          DefaultCacheTTL,
 	}
 }
 
@@ -47,5 +417,7 @@ func (p *Parameters) MarshalLogObject(oe zapcore.ObjectEncoder) error {
 	oe.AddString(logType, p.Type.String())
 	oe.AddDuration(logAddQueryTimeout, p.AddQueryTimeout)
 	oe.AddDuration(logGetQueryTimeout, p.GetQueryTimeout)
+	oe.AddInt(logCacheSize, p.CacheSize)
+	oe.AddDuration(logCacheTTL, p.CacheTTL)
 	return nil
 }
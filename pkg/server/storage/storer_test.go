@@ -2,7 +2,9 @@ package storage
 
 import (
 	"testing"
+	"time"
 
+	api "github.com/elixirhealth/user/pkg/userapi"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -11,3 +13,124 @@ func TestNewDefaultParameters(t *testing.T) {
 	assert.NotNil(t, p)
 	// TODO assert.NotEmpty on other params
 }
+
+func TestType_String(t *testing.T) {
+	assert.Equal(t, "Local", Local.String())
+	assert.Equal(t, "Postgres", Postgres.String())
+	assert.Equal(t, "Unspecified", Type(-1).String())
+}
+
+func TestSortEvents(t *testing.T) {
+	t0 := time.Now()
+	e1 := &AssociationEvent{Type: api.EventType_ADDED, Time: t0}
+	e2 := &AssociationEvent{Type: api.EventType_REMOVED, Time: t0.Add(2 * time.Second)}
+	e3 := &AssociationEvent{Type: api.EventType_ADDED, Time: t0.Add(1 * time.Second)}
+	e4 := &AssociationEvent{Type: api.EventType_REMOVED, Time: t0.Add(1 * time.Second)}
+	events := []*AssociationEvent{e1, e2, e3, e4}
+	SortEvents(events)
+	assert.Equal(t, []*AssociationEvent{e1, e3, e4, e2}, events)
+}
+
+func TestAssociation_Validate(t *testing.T) {
+	cases := map[string]struct {
+		a        *Association
+		expected error
+	}{
+		"ok":              {a: &Association{UserID: "user", EntityID: "entity"}},
+		"empty user ID":   {a: &Association{EntityID: "entity"}, expected: api.ErrEmptyUserID},
+		"empty entity ID": {a: &Association{UserID: "user"}, expected: api.ErrEmptyEntityID},
+	}
+	for desc, c := range cases {
+		assert.Equal(t, c.expected, c.a.Validate(), desc)
+	}
+}
+
+func TestAssociationCounts_CheckAdds(t *testing.T) {
+	limits := &Limits{MaxUserEntities: 2, MaxEntityUsers: 2}
+	c := NewAssociationCounts()
+	c.Existing[AssociationID{UserID: "user 1", EntityID: "entity 1"}] = struct{}{}
+	c.EntityUsers["entity 1"] = 1
+	c.UserEntities["user 1"] = 1
+	c.MaxEntityUsers["entity 2"] = 1
+
+	as := []*Association{
+		{UserID: "user 1", EntityID: "entity 1"}, // exists
+		{UserID: "", EntityID: "entity 1"},       // invalid
+		{UserID: "user 2", EntityID: "entity 1"}, // ok
+		{UserID: "user 3", EntityID: "entity 1"}, // entity 1 now at limit
+		{UserID: "user 2", EntityID: "entity 1"}, // added earlier in batch
+		{UserID: "user 1", EntityID: "entity 2"}, // ok
+		{UserID: "user 1", EntityID: "entity 3"}, // user 1 now at limit
+		{UserID: "user 3", EntityID: "entity 2"}, // entity 2 at override limit
+	}
+	expected := []error{
+		ErrUserEntityExists,
+		api.ErrEmptyUserID,
+		nil,
+		ErrTooManyEntityUsers,
+		ErrUserEntityExists,
+		nil,
+		ErrTooManyUserEntities,
+		ErrTooManyEntityUsers,
+	}
+	errs := c.CheckAdds(as, limits)
+	assert.Equal(t, expected, errs)
+	assert.Equal(t, 2, c.EntityUsers["entity 1"])
+	assert.Equal(t, 1, c.EntityUsers["entity 2"])
+	assert.Equal(t, 2, c.UserEntities["user 1"])
+}
+
+func TestMergeUser(t *testing.T) {
+	existing := &api.UserProfile{
+		UserId:      "some user ID",
+		DisplayName: "Some User",
+		Email:       "some.user@example.com",
+		Attributes:  map[string]string{"key1": "value1", "key2": "value2"},
+	}
+	MergeUser(existing, &api.UserProfile{
+		UserId:     "some user ID",
+		Email:      "other.user@example.com",
+		Timezone:   "America/New_York",
+		Attributes: map[string]string{"key1": "", "key3": "value3"},
+	})
+	assert.Equal(t, &api.UserProfile{
+		UserId:      "some user ID",
+		DisplayName: "Some User",
+		Email:       "other.user@example.com",
+		Timezone:    "America/New_York",
+		Attributes:  map[string]string{"key2": "value2", "key3": "value3"},
+	}, existing)
+
+	existing = &api.UserProfile{UserId: "some user ID"}
+	MergeUser(existing, &api.UserProfile{Attributes: map[string]string{"key1": "value1"}})
+	assert.Equal(t, map[string]string{"key1": "value1"}, existing.Attributes)
+}
+
+func TestPage_Limited(t *testing.T) {
+	var page *Page
+	assert.False(t, page.Limited())
+	assert.False(t, (&Page{}).Limited())
+	assert.True(t, (&Page{Size: 1}).Limited())
+}
+
+func TestPage_ParseKeysetToken(t *testing.T) {
+	var page *Page
+	lastID, err := page.ParseKeysetToken()
+	assert.Nil(t, err)
+	assert.Empty(t, lastID)
+
+	page = &Page{Size: 2}
+	lastID, err = page.ParseKeysetToken()
+	assert.Nil(t, err)
+	assert.Empty(t, lastID)
+
+	page.Token = NewKeysetToken("some entity ID")
+	lastID, err = page.ParseKeysetToken()
+	assert.Nil(t, err)
+	assert.Equal(t, "some entity ID", lastID)
+
+	page.Token = "not base64!"
+	lastID, err = page.ParseKeysetToken()
+	assert.Equal(t, ErrInvalidPageToken, err)
+	assert.Empty(t, lastID)
+}
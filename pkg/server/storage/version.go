@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	api "github.com/elixirhealth/user/pkg/userapi"
+)
+
+// AssociationVersion is a user-entity association with a given role, from when it was added until
+// it was removed. Updating the role marks the version as removed and superseded and adds a new
+// one with the updated role, so that the role as of any point in time is preserved. Backends
+// without their own history of associations store these versions, converting them to and from
+// their own representations as needed.
+type AssociationVersion struct {
+	UserID      string
+	EntityID    string
+	Role        api.Role
+	Removed     bool
+	AddedTime   time.Time
+	RemovedTime time.Time
+
+	// RoleUpdated and Superseded indicate whether the version was added or removed,
+	// respectively, by a role update rather than by an add or remove
+	RoleUpdated bool
+	Superseded  bool
+}
+
+// NewAssociationVersion creates a new *AssociationVersion from the given user and entity ID and
+// role, added as of now.
+func NewAssociationVersion(userID, entityID string, role api.Role) *AssociationVersion {
+	return newAssociationVersionAt(userID, entityID, role, time.Now())
+}
+
+func newAssociationVersionAt(
+	userID, entityID string, role api.Role, now time.Time,
+) *AssociationVersion {
+	return &AssociationVersion{
+		UserID:    userID,
+		EntityID:  entityID,
+		Role:      role,
+		AddedTime: now,
+	}
+}
+
+// MarkRemoved marks the version as removed as of now.
+func (v *AssociationVersion) MarkRemoved() {
+	v.markRemovedAt(time.Now())
+}
+
+func (v *AssociationVersion) markRemovedAt(now time.Time) {
+	v.Removed = true
+	v.RemovedTime = now
+}
+
+// UpdateRole marks the version as superseded as of now and returns the version that replaces it
+// with the given role.
+func (v *AssociationVersion) UpdateRole(role api.Role) *AssociationVersion {
+	now := time.Now()
+	v.markRemovedAt(now)
+	v.Superseded = true
+	updated := newAssociationVersionAt(v.UserID, v.EntityID, role, now)
+	updated.RoleUpdated = true
+	return updated
+}
+
+// ModifiedTime returns when the version was last modified, i.e., when it was removed or, if it
+// hasn't been, when it was added.
+func (v *AssociationVersion) ModifiedTime() time.Time {
+	if v.Removed {
+		return v.RemovedTime
+	}
+	return v.AddedTime
+}
+
+// Association returns the user-entity association with its role.
+func (v *AssociationVersion) Association() *Association {
+	return &Association{
+		UserID:   v.UserID,
+		EntityID: v.EntityID,
+		Role:     v.Role,
+	}
+}
+
+// ActiveAt returns whether the version was active (i.e., added and not yet removed) at the given
+// time.
+func (v *AssociationVersion) ActiveAt(t time.Time) bool {
+	if v.AddedTime.After(t) {
+		return false
+	}
+	return !v.Removed || v.RemovedTime.After(t)
+}
+
+// Events returns the add (or role update) and, if removed, remove events for the version. A
+// superseded version has no remove event since the role update replaces it.
+func (v *AssociationVersion) Events() []*AssociationEvent {
+	added := api.EventType_ADDED
+	if v.RoleUpdated {
+		added = api.EventType_ROLE_UPDATED
+	}
+	events := []*AssociationEvent{{
+		Type:     added,
+		Time:     v.AddedTime,
+		UserID:   v.UserID,
+		EntityID: v.EntityID,
+		Role:     v.Role,
+	}}
+	if v.Removed && !v.Superseded {
+		events = append(events, &AssociationEvent{
+			Type:     api.EventType_REMOVED,
+			Time:     v.RemovedTime,
+			UserID:   v.UserID,
+			EntityID: v.EntityID,
+			Role:     v.Role,
+		})
+	}
+	return events
+}
+
+// LastEvent returns the version's most recent event.
+func (v *AssociationVersion) LastEvent() *AssociationEvent {
+	events := v.Events()
+	return events[len(events)-1]
+}
+
+// SortVersions sorts the versions by user ID, entity ID, and then added time, keeping the original
+// order of versions of the same association added at the same time.
+func SortVersions(versions []*AssociationVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		vi, vj := versions[i], versions[j]
+		if vi.UserID != vj.UserID {
+			return vi.UserID < vj.UserID
+		}
+		if vi.EntityID != vj.EntityID {
+			return vi.EntityID < vj.EntityID
+		}
+		return vi.AddedTime.Before(vj.AddedTime)
+	})
+}
+
+// GroupVersions splits the versions, sorted as by SortVersions, into the versions of each
+// association.
+func GroupVersions(versions []*AssociationVersion) [][]*AssociationVersion {
+	groups := make([][]*AssociationVersion, 0)
+	start := 0
+	for i := 1; i <= len(versions); i++ {
+		if i == len(versions) || versions[i].UserID != versions[start].UserID ||
+			versions[i].EntityID != versions[start].EntityID {
+			groups = append(groups, versions[start:i])
+			start = i
+		}
+	}
+	return groups
+}
+
+// ImportedEvent returns the event sent to watches for an imported association, given its
+// versions oldest first: the last event of its last version, i.e., its current state, rather
+// than its whole history.
+func ImportedEvent(versions []*AssociationVersion) *AssociationEvent {
+	return versions[len(versions)-1].LastEvent()
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssociationVersion_ActiveAt(t *testing.T) {
+	t0 := time.Now()
+	t1, t2 := t0.Add(1*time.Second), t0.Add(2*time.Second)
+	v := &AssociationVersion{AddedTime: t1}
+	assert.False(t, v.ActiveAt(t0))
+	assert.True(t, v.ActiveAt(t1))
+	assert.True(t, v.ActiveAt(t2))
+
+	v = &AssociationVersion{AddedTime: t0, Removed: true, RemovedTime: t1}
+	assert.True(t, v.ActiveAt(t0))
+	assert.False(t, v.ActiveAt(t1))
+	assert.False(t, v.ActiveAt(t2))
+}
+
+func TestAssociationVersion_Events(t *testing.T) {
+	userID, entityID := "some user", "some entity"
+	v := NewAssociationVersion(userID, entityID, api.Role_OWNER)
+	assert.Equal(t, v.AddedTime, v.ModifiedTime())
+	events := v.Events()
+	assert.Len(t, events, 1)
+	assert.Equal(t, api.EventType_ADDED, events[0].Type)
+
+	updated := v.UpdateRole(api.Role_ADMIN)
+	assert.Len(t, v.Events(), 1) // superseded, so not removed
+	assert.Equal(t, v.RemovedTime, v.ModifiedTime())
+	assert.Equal(t, v.RemovedTime, updated.AddedTime)
+	events = updated.Events()
+	assert.Len(t, events, 1)
+	assert.Equal(t, api.EventType_ROLE_UPDATED, events[0].Type)
+	assert.Equal(t, api.Role_ADMIN, events[0].Role)
+
+	updated.MarkRemoved()
+	events = updated.Events()
+	assert.Len(t, events, 2)
+	assert.Equal(t, api.EventType_REMOVED, events[1].Type)
+	assert.Equal(t, api.Role_ADMIN, events[1].Role)
+	assert.Equal(t, events[1], updated.LastEvent())
+}
+
+func TestSortVersions_GroupVersions(t *testing.T) {
+	t0 := time.Now()
+	t1 := t0.Add(1 * time.Second)
+	versions := []*AssociationVersion{
+		{UserID: "user 2", EntityID: "entity 1", AddedTime: t0},
+		{UserID: "user 1", EntityID: "entity 2", AddedTime: t1},
+		{UserID: "user 1", EntityID: "entity 1", AddedTime: t1},
+		{UserID: "user 1", EntityID: "entity 2", AddedTime: t0},
+	}
+	SortVersions(versions)
+	groups := GroupVersions(versions)
+	assert.Len(t, groups, 3)
+	assert.Equal(t, []*AssociationVersion{
+		{UserID: "user 1", EntityID: "entity 1", AddedTime: t1},
+	}, groups[0])
+	assert.Equal(t, []*AssociationVersion{
+		{UserID: "user 1", EntityID: "entity 2", AddedTime: t0},
+		{UserID: "user 1", EntityID: "entity 2", AddedTime: t1},
+	}, groups[1])
+	assert.Equal(t, []*AssociationVersion{
+		{UserID: "user 2", EntityID: "entity 1", AddedTime: t0},
+	}, groups[2])
+
+	assert.Empty(t, GroupVersions(nil))
+}
+
+func TestImportedEvent(t *testing.T) {
+	v1 := NewAssociationVersion("some user", "some entity", api.Role_OWNER)
+	v2 := v1.UpdateRole(api.Role_ADMIN)
+	e := ImportedEvent([]*AssociationVersion{v1, v2})
+	assert.Equal(t, api.EventType_ROLE_UPDATED, e.Type)
+	assert.Equal(t, api.Role_ADMIN, e.Role)
+
+	v2.MarkRemoved()
+	e = ImportedEvent([]*AssociationVersion{v1, v2})
+	assert.Equal(t, api.EventType_REMOVED, e.Type)
+	assert.Equal(t, v2.RemovedTime, e.Time)
+}
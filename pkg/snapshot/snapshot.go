@@ -0,0 +1,206 @@
+// Package snapshot reads and writes snapshot files of exported associations. A snapshot begins
+// with a header giving the version of its format and continues with one record per association,
+// each with its full history.
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+)
+
+// Format is how the header and records of a snapshot are encoded.
+type Format string
+
+const (
+	// NDJSON encodes the header and each record as JSON on its own line, with the proto field
+	// names and timestamps in RFC 3339 format.
+	NDJSON Format = "ndjson"
+
+	// Proto encodes the header and each record as a binary protobuf message preceded by its
+	// length as a varint.
+	Proto Format = "proto"
+
+	// Version is the version of the snapshot format the Writer writes, which is the newest one
+	// the Reader reads.
+	Version = 1
+
+	// maxMessageSize bounds the length of a Proto message the Reader reads, so that a corrupt
+	// length can't exhaust memory.
+	maxMessageSize = 64 << 20
+)
+
+var (
+	// ErrUnknownFormat indicates when a format isn't one of the defined Formats.
+	ErrUnknownFormat = errors.New("unknown snapshot format")
+
+	// ErrMissingHeader indicates when a snapshot doesn't begin with a header.
+	ErrMissingHeader = errors.New("missing snapshot header")
+
+	// ErrUnsupportedVersion indicates when a snapshot's format version is newer than Version.
+	ErrUnsupportedVersion = errors.New("unsupported snapshot version")
+
+	// ErrMessageTooLarge indicates when a Proto message's length is above the max size.
+	ErrMessageTooLarge = errors.New("snapshot message too large")
+)
+
+// ParseFormat returns the Format with the given name or ErrUnknownFormat if there isn't one.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(name); f {
+	case NDJSON, Proto:
+		return f, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// Writer writes a snapshot. Its writes are buffered, so Flush must be called after the last
+// record.
+type Writer struct {
+	w         *bufio.Writer
+	format    Format
+	marshaler *jsonpb.Marshaler
+}
+
+// NewWriter creates a new *Writer of a snapshot in the given format, first writing its header
+// with the given creation time.
+func NewWriter(w io.Writer, format Format, created time.Time) (*Writer, error) {
+	if _, err := ParseFormat(string(format)); err != nil {
+		return nil, err
+	}
+	createdTime, err := ptypes.TimestampProto(created)
+	if err != nil {
+		return nil, err
+	}
+	sw := &Writer{
+		w:         bufio.NewWriter(w),
+		format:    format,
+		marshaler: &jsonpb.Marshaler{OrigName: true},
+	}
+	header := &api.SnapshotHeader{Version: Version, CreatedTime: createdTime}
+	if err := sw.write(header); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+// Write writes the association record.
+func (w *Writer) Write(r *api.AssociationRecord) error {
+	return w.write(r)
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *Writer) write(m proto.Message) error {
+	if w.format == NDJSON {
+		if err := w.marshaler.Marshal(w.w, m); err != nil {
+			return err
+		}
+		return w.w.WriteByte('\n')
+	}
+	buf, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if _, err := w.w.Write(proto.EncodeVarint(uint64(len(buf)))); err != nil {
+		return err
+	}
+	_, err = w.w.Write(buf)
+	return err
+}
+
+// Reader reads a snapshot.
+type Reader struct {
+	r           *bufio.Reader
+	format      Format
+	unmarshaler *jsonpb.Unmarshaler
+	header      *api.SnapshotHeader
+}
+
+// NewReader creates a new *Reader of a snapshot in the given format, first reading its header and
+// checking that its version is supported.
+func NewReader(r io.Reader, format Format) (*Reader, error) {
+	if _, err := ParseFormat(string(format)); err != nil {
+		return nil, err
+	}
+	sr := &Reader{
+		r:      bufio.NewReader(r),
+		format: format,
+
+		// fields added in later versions of the same format are ignored
+		unmarshaler: &jsonpb.Unmarshaler{AllowUnknownFields: true},
+		header:      &api.SnapshotHeader{},
+	}
+	if err := sr.read(sr.header); err == io.EOF {
+		return nil, ErrMissingHeader
+	} else if err != nil {
+		return nil, err
+	}
+	if sr.header.Version == 0 {
+		return nil, ErrMissingHeader
+	}
+	if sr.header.Version > Version {
+		return nil, ErrUnsupportedVersion
+	}
+	return sr, nil
+}
+
+// Header returns the snapshot's header.
+func (r *Reader) Header() *api.SnapshotHeader {
+	return r.header
+}
+
+// Read returns the next association record or io.EOF after the last one.
+func (r *Reader) Read() (*api.AssociationRecord, error) {
+	record := &api.AssociationRecord{}
+	if err := r.read(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (r *Reader) read(m proto.Message) error {
+	if r.format == NDJSON {
+		return r.readLine(m)
+	}
+	size, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return err
+	}
+	if size > maxMessageSize {
+		return ErrMessageTooLarge
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r.r, buf); err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
+	return proto.Unmarshal(buf, m)
+}
+
+// readLine reads the next non-blank line into the message.
+func (r *Reader) readLine(m proto.Message) error {
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return r.unmarshaler.Unmarshal(bytes.NewReader(line), m)
+		}
+		if err == io.EOF {
+			return io.EOF
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package snapshot
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/elixirhealth/user/pkg/userapi"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("ndjson")
+	assert.Nil(t, err)
+	assert.Equal(t, NDJSON, f)
+
+	f, err = ParseFormat("proto")
+	assert.Nil(t, err)
+	assert.Equal(t, Proto, f)
+
+	f, err = ParseFormat("csv")
+	assert.Equal(t, ErrUnknownFormat, err)
+	assert.Empty(t, f)
+}
+
+func TestWriter_Reader(t *testing.T) {
+	created := time.Unix(1500000000, 0).UTC()
+	records := []*api.AssociationRecord{
+		newRecord("user 1", "entity 1", api.Role_CLINICIAN, api.Role_ADMIN),
+		newRecord("user 1", "entity 2", api.Role_OWNER),
+	}
+	records[1].Versions[0].RemovedTime = ptypes.TimestampNow()
+	for _, format := range []Format{NDJSON, Proto} {
+		buf := new(bytes.Buffer)
+		w, err := NewWriter(buf, format, created)
+		assert.Nil(t, err, string(format))
+		for _, r := range records {
+			assert.Nil(t, w.Write(r), string(format))
+		}
+		assert.Nil(t, w.Flush(), string(format))
+
+		r, err := NewReader(buf, format)
+		assert.Nil(t, err, string(format))
+		assert.Equal(t, uint32(Version), r.Header().Version, string(format))
+		readCreated, err := ptypes.Timestamp(r.Header().CreatedTime)
+		assert.Nil(t, err, string(format))
+		assert.True(t, created.Equal(readCreated), string(format))
+		for _, expected := range records {
+			read, err := r.Read()
+			assert.Nil(t, err, string(format))
+			assert.True(t, proto.Equal(expected, read), string(format))
+		}
+		read, err := r.Read()
+		assert.Equal(t, io.EOF, err, string(format))
+		assert.Nil(t, read, string(format))
+	}
+}
+
+func TestNewWriter_err(t *testing.T) {
+	w, err := NewWriter(new(bytes.Buffer), Format("csv"), time.Now())
+	assert.Equal(t, ErrUnknownFormat, err)
+	assert.Nil(t, w)
+}
+
+func TestNewReader_err(t *testing.T) {
+	newerHeader, err := proto.Marshal(&api.SnapshotHeader{Version: Version + 1})
+	assert.Nil(t, err)
+	newer := append(proto.EncodeVarint(uint64(len(newerHeader))), newerHeader...)
+
+	cases := map[string]struct {
+		r        io.Reader
+		format   Format
+		expected error
+	}{
+		"unknown format": {
+			r:        strings.NewReader(""),
+			format:   Format("csv"),
+			expected: ErrUnknownFormat,
+		},
+		"empty ndjson": {
+			r:        strings.NewReader("\n\n"),
+			format:   NDJSON,
+			expected: ErrMissingHeader,
+		},
+		"empty proto": {
+			r:        strings.NewReader(""),
+			format:   Proto,
+			expected: ErrMissingHeader,
+		},
+		"no version": {
+			r:        strings.NewReader(`{"created_time": "2017-07-14T02:40:00Z"}` + "\n"),
+			format:   NDJSON,
+			expected: ErrMissingHeader,
+		},
+		"unsupported version": {
+			r:        strings.NewReader(`{"version": 2}` + "\n"),
+			format:   NDJSON,
+			expected: ErrUnsupportedVersion,
+		},
+		"unsupported proto version": {
+			r:        bytes.NewReader(newer),
+			format:   Proto,
+			expected: ErrUnsupportedVersion,
+		},
+		"too large": {
+			r:        bytes.NewReader(proto.EncodeVarint(maxMessageSize + 1)),
+			format:   Proto,
+			expected: ErrMessageTooLarge,
+		},
+		"truncated": {
+			r:        bytes.NewReader(proto.EncodeVarint(8)),
+			format:   Proto,
+			expected: io.ErrUnexpectedEOF,
+		},
+	}
+	for desc, c := range cases {
+		r, err := NewReader(c.r, c.format)
+		assert.Equal(t, c.expected, err, desc)
+		assert.Nil(t, r, desc)
+	}
+}
+
+func TestReader_Read_err(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, Proto, time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, w.Write(newRecord("user 1", "entity 1", api.Role_CLINICIAN)))
+	assert.Nil(t, w.Flush())
+	r, err := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), Proto)
+	assert.Nil(t, err)
+
+	record, err := r.Read()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Nil(t, record)
+
+	r, err = NewReader(strings.NewReader(`{"version": 1}`+"\n"+`{"user_id": 1}`), NDJSON)
+	assert.Nil(t, err)
+	record, err = r.Read()
+	assert.NotNil(t, err)
+	assert.Nil(t, record)
+}
+
+// newRecord returns a record of an association with a version for each role, the last current.
+func newRecord(userID, entityID string, roles ...api.Role) *api.AssociationRecord {
+	r := &api.AssociationRecord{UserId: userID, EntityId: entityID}
+	t := time.Unix(1500000000, 0)
+	for i, role := range roles {
+		added, _ := ptypes.TimestampProto(t.Add(time.Duration(i) * time.Second))
+		v := &api.AssociationVersion{Role: role, AddedTime: added, RoleUpdated: i > 0}
+		if i < len(roles)-1 {
+			v.RemovedTime, _ = ptypes.TimestampProto(t.Add(time.Duration(i+1) * time.Second))
+			v.Superseded = true
+		}
+		r.Versions = append(r.Versions, v)
+	}
+	return r
+}
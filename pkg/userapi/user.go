@@ -1,6 +1,40 @@
 package userapi
 
-import "github.com/pkg/errors"
+import (
+	"net/mail"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+)
+
+const (
+	// MaxBatchSize is the maximum number of items in a single batch request.
+	MaxBatchSize = 128
+
+	// MaxPageSize is the maximum number of items in a single page.
+	MaxPageSize = 1000
+
+	// MaxImportSize is the maximum number of association records in a single import stream,
+	// which is checked as a whole before any association is stored.
+	MaxImportSize = 100000
+
+	// UserEntitiesQuotaSubject is the QuotaFailure violation subject of a ResourceExhausted
+	// status when a user already has the max number of associated entities.
+	UserEntitiesQuotaSubject = "user-entities"
+
+	// EntityUsersQuotaSubject is the QuotaFailure violation subject of a ResourceExhausted
+	// status when an entity already has the max number of associated users.
+	EntityUsersQuotaSubject = "entity-users"
+
+	// UserEntityResourceType is the ResourceInfo resource type of an AlreadyExists or NotFound
+	// status for a user-entity association.
+	UserEntityResourceType = "user-entity"
+
+	// UserResourceType is the ResourceInfo resource type of a NotFound status for a user
+	// profile.
+	UserResourceType = "user"
+)
 
 var (
 	// ErrEmptyUserID denotes when the user ID field is empty.
@@ -8,10 +42,78 @@ var (
 
 	// ErrEmptyEntityID denotes when the entity ID field is empty.
 	ErrEmptyEntityID = errors.New("empty entity ID field")
+
+	// ErrEmptyUserAndEntityID denotes when both the user ID and entity ID fields are empty.
+	ErrEmptyUserAndEntityID = errors.New("empty user ID and entity ID fields")
+
+	// ErrInvalidAsOf denotes when the as-of timestamp field is present but not a valid time.
+	ErrInvalidAsOf = errors.New("invalid as-of timestamp field")
+
+	// ErrEmptyRole denotes when the role field is unspecified where a role is required.
+	ErrEmptyRole = errors.New("empty role field")
+
+	// ErrInvalidRole denotes when the role field isn't one of the defined roles.
+	ErrInvalidRole = errors.New("invalid role field")
+
+	// ErrEmptyUser denotes when the user profile field is missing.
+	ErrEmptyUser = errors.New("empty user profile field")
+
+	// ErrInvalidEmail denotes when the email field is present but not a bare email address.
+	ErrInvalidEmail = errors.New("invalid email field")
+
+	// ErrEmptyAttributeKey denotes when one of the user profile attributes has an empty key.
+	ErrEmptyAttributeKey = errors.New("empty attribute key")
+
+	// ErrInvalidPageToken denotes when the page token field isn't one returned with a previous
+	// page.
+	ErrInvalidPageToken = errors.New("invalid page token field")
+
+	// ErrEmptyBatch denotes when a batch request has no items.
+	ErrEmptyBatch = errors.New("empty batch")
+
+	// ErrBatchTooLarge denotes when a batch request has more than MaxBatchSize items.
+	ErrBatchTooLarge = errors.New("batch has too many items")
+
+	// ErrInvalidConflictMode denotes when the import options are missing or their conflict
+	// mode isn't one of the defined modes.
+	ErrInvalidConflictMode = errors.New("invalid conflict mode field")
+
+	// ErrEmptyVersions denotes when an association record has no versions.
+	ErrEmptyVersions = errors.New("empty association versions field")
+
+	// ErrInvalidVersions denotes when an association record's versions have missing or invalid
+	// times, are out of order, or overlap.
+	ErrInvalidVersions = errors.New("invalid association versions field")
+
+	// ErrDuplicateAssociation denotes when an import stream has more than one record for the
+	// same user and entity.
+	ErrDuplicateAssociation = errors.New("duplicate association record")
+
+	// ErrImportTooLarge denotes when an import stream has more than MaxImportSize association
+	// records.
+	ErrImportTooLarge = errors.New("import has too many association records")
 )
 
-// ValidateAddEntityRequest checks that the entity and user ID fields are populated.
+// ValidateAddEntityRequest checks that the entity and user ID fields are populated and that the
+// role, if present, is defined.
 func ValidateAddEntityRequest(rq *AddEntityRequest) error {
+	if rq.EntityId == "" {
+		return ErrEmptyEntityID
+	}
+	if rq.UserId == "" {
+		return ErrEmptyUserID
+	}
+	return validateRole(rq.Role)
+}
+
+// ValidateAddEntitiesRequest checks that the batch of associations is neither empty nor too
+// large. The individual associations are validated separately so that each can fail on its own.
+func ValidateAddEntitiesRequest(rq *AddEntitiesRequest) error {
+	return validateBatchSize(len(rq.Associations))
+}
+
+// ValidateRemoveEntityRequest checks that the entity and user ID fields are populated.
+func ValidateRemoveEntityRequest(rq *RemoveEntityRequest) error {
 	if rq.EntityId == "" {
 		return ErrEmptyEntityID
 	}
@@ -21,10 +123,192 @@ func ValidateAddEntityRequest(rq *AddEntityRequest) error {
 	return nil
 }
 
-// ValidateGetEntitiesRequest checks that the user ID fields are populated.
+// ValidateGetEntitiesRequest checks that the user ID fields are populated and that the as-of
+// timestamp, if present, is valid.
 func ValidateGetEntitiesRequest(rq *GetEntitiesRequest) error {
 	if rq.UserId == "" {
 		return ErrEmptyUserID
 	}
+	if rq.AsOf != nil {
+		if _, err := ptypes.Timestamp(rq.AsOf); err != nil {
+			return ErrInvalidAsOf
+		}
+	}
+	return nil
+}
+
+// ValidateGetEntitiesBatchRequest checks that the batch of user IDs is neither empty nor too
+// large. The individual user IDs are validated separately so that each can fail on its own.
+func ValidateGetEntitiesBatchRequest(rq *GetEntitiesBatchRequest) error {
+	return validateBatchSize(len(rq.UserIds))
+}
+
+// ValidateGetUsersRequest checks that the entity ID field is populated.
+func ValidateGetUsersRequest(rq *GetUsersRequest) error {
+	if rq.EntityId == "" {
+		return ErrEmptyEntityID
+	}
+	return nil
+}
+
+// ValidateGetAssociationHistoryRequest checks that at least one of the user ID and entity ID
+// fields is populated.
+func ValidateGetAssociationHistoryRequest(rq *GetAssociationHistoryRequest) error {
+	if rq.UserId == "" && rq.EntityId == "" {
+		return ErrEmptyUserAndEntityID
+	}
+	return nil
+}
+
+// ValidateWatchEntitiesRequest checks that at least one of the user ID and entity ID fields is
+// populated.
+func ValidateWatchEntitiesRequest(rq *WatchEntitiesRequest) error {
+	if rq.UserId == "" && rq.EntityId == "" {
+		return ErrEmptyUserAndEntityID
+	}
+	return nil
+}
+
+// ValidateSetUserLimitRequest checks that the user ID field is populated.
+func ValidateSetUserLimitRequest(rq *SetUserLimitRequest) error {
+	if rq.UserId == "" {
+		return ErrEmptyUserID
+	}
+	return nil
+}
+
+// ValidateSetEntityLimitRequest checks that the entity ID field is populated.
+func ValidateSetEntityLimitRequest(rq *SetEntityLimitRequest) error {
+	if rq.EntityId == "" {
+		return ErrEmptyEntityID
+	}
+	return nil
+}
+
+// ValidateUpdateRoleRequest checks that the entity and user ID fields are populated and that the
+// role is specified and defined.
+func ValidateUpdateRoleRequest(rq *UpdateRoleRequest) error {
+	if rq.EntityId == "" {
+		return ErrEmptyEntityID
+	}
+	if rq.UserId == "" {
+		return ErrEmptyUserID
+	}
+	if rq.Role == Role_UNSPECIFIED_ROLE {
+		return ErrEmptyRole
+	}
+	return validateRole(rq.Role)
+}
+
+// ValidatePutUserRequest checks that the user profile and its user ID are populated and that its
+// email and attributes, if present, are valid.
+func ValidatePutUserRequest(rq *PutUserRequest) error {
+	return validateUserProfile(rq.User)
+}
+
+// ValidateGetUserRequest checks that the user ID field is populated.
+func ValidateGetUserRequest(rq *GetUserRequest) error {
+	if rq.UserId == "" {
+		return ErrEmptyUserID
+	}
+	return nil
+}
+
+// ValidateUpdateUserRequest checks that the user profile and its user ID are populated and that
+// its email and attributes, if present, are valid.
+func ValidateUpdateUserRequest(rq *UpdateUserRequest) error {
+	return validateUserProfile(rq.User)
+}
+
+// ValidateImportAssociationsRequest checks that the first request of an import stream has a
+// defined conflict mode and that each request has at most MaxBatchSize valid association records.
+func ValidateImportAssociationsRequest(rq *ImportAssociationsRequest, first bool) error {
+	if first {
+		switch rq.Options.GetConflictMode() {
+		case ConflictMode_SKIP, ConflictMode_OVERWRITE, ConflictMode_FAIL:
+		default:
+			return ErrInvalidConflictMode
+		}
+	}
+	if len(rq.Associations) > MaxBatchSize {
+		return ErrBatchTooLarge
+	}
+	for _, r := range rq.Associations {
+		if err := ValidateAssociationRecord(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateAssociationRecord checks that the user and entity ID fields are populated and that the
+// versions are ordered oldest first without overlapping, each with a defined role and valid
+// times, and that only the last is current.
+func ValidateAssociationRecord(r *AssociationRecord) error {
+	if r.EntityId == "" {
+		return ErrEmptyEntityID
+	}
+	if r.UserId == "" {
+		return ErrEmptyUserID
+	}
+	if len(r.Versions) == 0 {
+		return ErrEmptyVersions
+	}
+	var prevRemoved time.Time
+	for i, v := range r.Versions {
+		if err := validateRole(v.Role); err != nil {
+			return err
+		}
+		added, err := ptypes.Timestamp(v.AddedTime)
+		if err != nil || added.Before(prevRemoved) {
+			return ErrInvalidVersions
+		}
+		if v.RemovedTime == nil {
+			if i < len(r.Versions)-1 {
+				return ErrInvalidVersions
+			}
+			continue
+		}
+		removed, err := ptypes.Timestamp(v.RemovedTime)
+		if err != nil || !removed.After(added) {
+			return ErrInvalidVersions
+		}
+		prevRemoved = removed
+	}
+	return nil
+}
+
+func validateUserProfile(u *UserProfile) error {
+	if u == nil {
+		return ErrEmptyUser
+	}
+	if u.UserId == "" {
+		return ErrEmptyUserID
+	}
+	if u.Email != "" {
+		if addr, err := mail.ParseAddress(u.Email); err != nil || addr.Address != u.Email {
+			return ErrInvalidEmail
+		}
+	}
+	if _, in := u.Attributes[""]; in {
+		return ErrEmptyAttributeKey
+	}
+	return nil
+}
+
+func validateRole(role Role) error {
+	if _, in := Role_name[int32(role)]; !in {
+		return ErrInvalidRole
+	}
+	return nil
+}
+
+func validateBatchSize(n int) error {
+	if n == 0 {
+		return ErrEmptyBatch
+	}
+	if n > MaxBatchSize {
+		return ErrBatchTooLarge
+	}
 	return nil
 }
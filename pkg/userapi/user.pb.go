@@ -10,14 +10,51 @@ It is generated from these files:
 It has these top-level messages:
 	AddEntityRequest
 	AddEntityResponse
+	AddEntitiesRequest
+	AddEntitiesResponse
+	AddEntityResult
+	RemoveEntityRequest
+	RemoveEntityResponse
 	GetEntitiesRequest
 	GetEntitiesResponse
+	GetEntitiesBatchRequest
+	GetEntitiesBatchResponse
+	UserEntities
+	GetUsersRequest
+	GetUsersResponse
+	GetAssociationHistoryRequest
+	GetAssociationHistoryResponse
+	AssociationEvent
+	WatchEntitiesRequest
+	WatchEntitiesResponse
+	SetUserLimitRequest
+	SetUserLimitResponse
+	SetEntityLimitRequest
+	SetEntityLimitResponse
+	UpdateRoleRequest
+	UpdateRoleResponse
+	UserProfile
+	PutUserRequest
+	PutUserResponse
+	GetUserRequest
+	GetUserResponse
+	UpdateUserRequest
+	UpdateUserResponse
+	ExportAssociationsRequest
+	ExportAssociationsResponse
+	ImportAssociationsRequest
+	ImportOptions
+	ImportAssociationsResponse
+	AssociationRecord
+	AssociationVersion
+	SnapshotHeader
 */
 package userapi
 
 import proto "github.com/golang/protobuf/proto"
 import fmt "fmt"
 import math "math"
+import google_protobuf "github.com/golang/protobuf/ptypes/timestamp"
 
 import (
 	context "golang.org/x/net/context"
@@ -35,9 +72,102 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+type EventType int32
+
+const (
+	EventType_UNSPECIFIED_EVENT_TYPE EventType = 0
+	EventType_ADDED                  EventType = 1
+	EventType_REMOVED                EventType = 2
+	EventType_ROLE_UPDATED           EventType = 3
+)
+
+var EventType_name = map[int32]string{
+	0: "UNSPECIFIED_EVENT_TYPE",
+	1: "ADDED",
+	2: "REMOVED",
+	3: "ROLE_UPDATED",
+}
+var EventType_value = map[string]int32{
+	"UNSPECIFIED_EVENT_TYPE": 0,
+	"ADDED":                  1,
+	"REMOVED":                2,
+	"ROLE_UPDATED":           3,
+}
+
+func (x EventType) String() string {
+	return proto.EnumName(EventType_name, int32(x))
+}
+func (EventType) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+type Role int32
+
+const (
+	Role_UNSPECIFIED_ROLE   Role = 0
+	Role_OWNER              Role = 1
+	Role_ADMIN              Role = 2
+	Role_CLINICIAN          Role = 3
+	Role_READ_ONLY_DELEGATE Role = 4
+)
+
+var Role_name = map[int32]string{
+	0: "UNSPECIFIED_ROLE",
+	1: "OWNER",
+	2: "ADMIN",
+	3: "CLINICIAN",
+	4: "READ_ONLY_DELEGATE",
+}
+var Role_value = map[string]int32{
+	"UNSPECIFIED_ROLE":   0,
+	"OWNER":              1,
+	"ADMIN":              2,
+	"CLINICIAN":          3,
+	"READ_ONLY_DELEGATE": 4,
+}
+
+func (x Role) String() string {
+	return proto.EnumName(Role_name, int32(x))
+}
+func (Role) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+type ConflictMode int32
+
+const (
+	ConflictMode_UNSPECIFIED_CONFLICT_MODE ConflictMode = 0
+	// keep the existing association and its history
+	ConflictMode_SKIP ConflictMode = 1
+	// replace the existing association's history with the imported one
+	ConflictMode_OVERWRITE ConflictMode = 2
+	// fail the import with an AlreadyExists error naming the first existing association,
+	// without importing any
+	ConflictMode_FAIL ConflictMode = 3
+)
+
+var ConflictMode_name = map[int32]string{
+	0: "UNSPECIFIED_CONFLICT_MODE",
+	1: "SKIP",
+	2: "OVERWRITE",
+	3: "FAIL",
+}
+var ConflictMode_value = map[string]int32{
+	"UNSPECIFIED_CONFLICT_MODE": 0,
+	"SKIP":                      1,
+	"OVERWRITE":                 2,
+	"FAIL":                      3,
+}
+
+func (x ConflictMode) String() string {
+	return proto.EnumName(ConflictMode_name, int32(x))
+}
+func (ConflictMode) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
 type AddEntityRequest struct {
 	UserId   string `protobuf:"bytes,1,opt,name=user_id,json=userId" json:"user_id,omitempty"`
 	EntityId string `protobuf:"bytes,2,opt,name=entity_id,json=entityId" json:"entity_id,omitempty"`
+	// whether an existing association succeeds (without being created) rather than failing,
+	// so that retried adds are idempotent
+	IfNotExists bool `protobuf:"varint,3,opt,name=if_not_exists,json=ifNotExists" json:"if_not_exists,omitempty"`
+	// role of the user for the entity
+	Role Role `protobuf:"varint,4,opt,name=role,enum=userapi.Role" json:"role,omitempty"`
 }
 
 func (m *AddEntityRequest) Reset()                    { *m = AddEntityRequest{} }
@@ -59,7 +189,23 @@ func (m *AddEntityRequest) GetEntityId() string {
 	return ""
 }
 
+func (m *AddEntityRequest) GetIfNotExists() bool {
+	if m != nil {
+		return m.IfNotExists
+	}
+	return false
+}
+
+func (m *AddEntityRequest) GetRole() Role {
+	if m != nil {
+		return m.Role
+	}
+	return Role_UNSPECIFIED_ROLE
+}
+
 type AddEntityResponse struct {
+	// whether a new association was created, false if it already existed
+	Created bool `protobuf:"varint,1,opt,name=created" json:"created,omitempty"`
 }
 
 func (m *AddEntityResponse) Reset()                    { *m = AddEntityResponse{} }
@@ -67,14 +213,137 @@ func (m *AddEntityResponse) String() string            { return proto.CompactTex
 func (*AddEntityResponse) ProtoMessage()               {}
 func (*AddEntityResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
 
+func (m *AddEntityResponse) GetCreated() bool {
+	if m != nil {
+		return m.Created
+	}
+	return false
+}
+
+type AddEntitiesRequest struct {
+	Associations []*AddEntityRequest `protobuf:"bytes,1,rep,name=associations" json:"associations,omitempty"`
+}
+
+func (m *AddEntitiesRequest) Reset()                    { *m = AddEntitiesRequest{} }
+func (m *AddEntitiesRequest) String() string            { return proto.CompactTextString(m) }
+func (*AddEntitiesRequest) ProtoMessage()               {}
+func (*AddEntitiesRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *AddEntitiesRequest) GetAssociations() []*AddEntityRequest {
+	if m != nil {
+		return m.Associations
+	}
+	return nil
+}
+
+type AddEntitiesResponse struct {
+	// results for each of the request associations, in the same order
+	Results []*AddEntityResult `protobuf:"bytes,1,rep,name=results" json:"results,omitempty"`
+}
+
+func (m *AddEntitiesResponse) Reset()                    { *m = AddEntitiesResponse{} }
+func (m *AddEntitiesResponse) String() string            { return proto.CompactTextString(m) }
+func (*AddEntitiesResponse) ProtoMessage()               {}
+func (*AddEntitiesResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *AddEntitiesResponse) GetResults() []*AddEntityResult {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
+
+type AddEntityResult struct {
+	UserId   string `protobuf:"bytes,1,opt,name=user_id,json=userId" json:"user_id,omitempty"`
+	EntityId string `protobuf:"bytes,2,opt,name=entity_id,json=entityId" json:"entity_id,omitempty"`
+	// description of why the association couldn't be added, empty if it was added
+	Error string `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+	// gRPC status code of the error, OK (0) if the association was added
+	Code uint32 `protobuf:"varint,4,opt,name=code" json:"code,omitempty"`
+}
+
+func (m *AddEntityResult) Reset()                    { *m = AddEntityResult{} }
+func (m *AddEntityResult) String() string            { return proto.CompactTextString(m) }
+func (*AddEntityResult) ProtoMessage()               {}
+func (*AddEntityResult) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *AddEntityResult) GetUserId() string {
+	if m != nil {
+		return m.UserId
+	}
+	return ""
+}
+
+func (m *AddEntityResult) GetEntityId() string {
+	if m != nil {
+		return m.EntityId
+	}
+	return ""
+}
+
+func (m *AddEntityResult) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func (m *AddEntityResult) GetCode() uint32 {
+	if m != nil {
+		return m.Code
+	}
+	return 0
+}
+
+type RemoveEntityRequest struct {
+	UserId   string `protobuf:"bytes,1,opt,name=user_id,json=userId" json:"user_id,omitempty"`
+	EntityId string `protobuf:"bytes,2,opt,name=entity_id,json=entityId" json:"entity_id,omitempty"`
+}
+
+func (m *RemoveEntityRequest) Reset()                    { *m = RemoveEntityRequest{} }
+func (m *RemoveEntityRequest) String() string            { return proto.CompactTextString(m) }
+func (*RemoveEntityRequest) ProtoMessage()               {}
+func (*RemoveEntityRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *RemoveEntityRequest) GetUserId() string {
+	if m != nil {
+		return m.UserId
+	}
+	return ""
+}
+
+func (m *RemoveEntityRequest) GetEntityId() string {
+	if m != nil {
+		return m.EntityId
+	}
+	return ""
+}
+
+type RemoveEntityResponse struct {
+}
+
+func (m *RemoveEntityResponse) Reset()                    { *m = RemoveEntityResponse{} }
+func (m *RemoveEntityResponse) String() string            { return proto.CompactTextString(m) }
+func (*RemoveEntityResponse) ProtoMessage()               {}
+func (*RemoveEntityResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
 type GetEntitiesRequest struct {
 	UserId string `protobuf:"bytes,1,opt,name=user_id,json=userId" json:"user_id,omitempty"`
+	// if set, the entities associated with the user at this time are returned instead of those
+	// currently associated
+	AsOf *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=as_of,json=asOf" json:"as_of,omitempty"`
+	// max number of entity IDs to return; zero returns all of them in one response, as before
+	// paging, and values above the max of 1000 are treated as 1000
+	PageSize uint32 `protobuf:"varint,3,opt,name=page_size,json=pageSize" json:"page_size,omitempty"`
+	// next_page_token from the previous response, or empty for the first page; the other
+	// request fields must be the same as for the previous page
+	PageToken string `protobuf:"bytes,4,opt,name=page_token,json=pageToken" json:"page_token,omitempty"`
 }
 
 func (m *GetEntitiesRequest) Reset()                    { *m = GetEntitiesRequest{} }
 func (m *GetEntitiesRequest) String() string            { return proto.CompactTextString(m) }
 func (*GetEntitiesRequest) ProtoMessage()               {}
-func (*GetEntitiesRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+func (*GetEntitiesRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
 
 func (m *GetEntitiesRequest) GetUserId() string {
 	if m != nil {
@@ -83,14 +352,39 @@ func (m *GetEntitiesRequest) GetUserId() string {
 	return ""
 }
 
+func (m *GetEntitiesRequest) GetAsOf() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.AsOf
+	}
+	return nil
+}
+
+func (m *GetEntitiesRequest) GetPageSize() uint32 {
+	if m != nil {
+		return m.PageSize
+	}
+	return 0
+}
+
+func (m *GetEntitiesRequest) GetPageToken() string {
+	if m != nil {
+		return m.PageToken
+	}
+	return ""
+}
+
 type GetEntitiesResponse struct {
 	EntityIds []string `protobuf:"bytes,1,rep,name=entity_ids,json=entityIds" json:"entity_ids,omitempty"`
+	// role of the user for each of the entity_ids, in the same order
+	Roles []Role `protobuf:"varint,2,rep,packed,name=roles,enum=userapi.Role" json:"roles,omitempty"`
+	// token for getting the next page, empty if there are no more entity IDs
+	NextPageToken string `protobuf:"bytes,3,opt,name=next_page_token,json=nextPageToken" json:"next_page_token,omitempty"`
 }
 
 func (m *GetEntitiesResponse) Reset()                    { *m = GetEntitiesResponse{} }
 func (m *GetEntitiesResponse) String() string            { return proto.CompactTextString(m) }
 func (*GetEntitiesResponse) ProtoMessage()               {}
-func (*GetEntitiesResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+func (*GetEntitiesResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
 
 func (m *GetEntitiesResponse) GetEntityIds() []string {
 	if m != nil {
@@ -99,138 +393,1675 @@ func (m *GetEntitiesResponse) GetEntityIds() []string {
 	return nil
 }
 
-func init() {
-	proto.RegisterType((*AddEntityRequest)(nil), "userapi.AddEntityRequest")
-	proto.RegisterType((*AddEntityResponse)(nil), "userapi.AddEntityResponse")
-	proto.RegisterType((*GetEntitiesRequest)(nil), "userapi.GetEntitiesRequest")
-	proto.RegisterType((*GetEntitiesResponse)(nil), "userapi.GetEntitiesResponse")
+func (m *GetEntitiesResponse) GetRoles() []Role {
+	if m != nil {
+		return m.Roles
+	}
+	return nil
 }
 
-// Reference imports to suppress errors if they are not otherwise used.
-var _ context.Context
-var _ grpc.ClientConn
+func (m *GetEntitiesResponse) GetNextPageToken() string {
+	if m != nil {
+		return m.NextPageToken
+	}
+	return ""
+}
 
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the grpc package it is being compiled against.
-const _ = grpc.SupportPackageIsVersion4
+type GetEntitiesBatchRequest struct {
+	UserIds []string `protobuf:"bytes,1,rep,name=user_ids,json=userIds" json:"user_ids,omitempty"`
+}
 
-// Client API for User service
+func (m *GetEntitiesBatchRequest) Reset()                    { *m = GetEntitiesBatchRequest{} }
+func (m *GetEntitiesBatchRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetEntitiesBatchRequest) ProtoMessage()               {}
+func (*GetEntitiesBatchRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
 
-type UserClient interface {
-	// AddEntity associates an entity ID with the given user ID.
-	AddEntity(ctx context.Context, in *AddEntityRequest, opts ...grpc.CallOption) (*AddEntityResponse, error)
-	// GetEntities returns a list of entity IDs associated with the given user ID.
-	GetEntities(ctx context.Context, in *GetEntitiesRequest, opts ...grpc.CallOption) (*GetEntitiesResponse, error)
+func (m *GetEntitiesBatchRequest) GetUserIds() []string {
+	if m != nil {
+		return m.UserIds
+	}
+	return nil
 }
 
-type userClient struct {
-	cc *grpc.ClientConn
+type GetEntitiesBatchResponse struct {
+	// results for each of the request user IDs, in the same order
+	Results []*UserEntities `protobuf:"bytes,1,rep,name=results" json:"results,omitempty"`
 }
 
-func NewUserClient(cc *grpc.ClientConn) UserClient {
-	return &userClient{cc}
+func (m *GetEntitiesBatchResponse) Reset()                    { *m = GetEntitiesBatchResponse{} }
+func (m *GetEntitiesBatchResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetEntitiesBatchResponse) ProtoMessage()               {}
+func (*GetEntitiesBatchResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *GetEntitiesBatchResponse) GetResults() []*UserEntities {
+	if m != nil {
+		return m.Results
+	}
+	return nil
 }
 
-func (c *userClient) AddEntity(ctx context.Context, in *AddEntityRequest, opts ...grpc.CallOption) (*AddEntityResponse, error) {
-	out := new(AddEntityResponse)
-	err := grpc.Invoke(ctx, "/userapi.User/AddEntity", in, out, c.cc, opts...)
-	if err != nil {
-		return nil, err
+type UserEntities struct {
+	UserId    string   `protobuf:"bytes,1,opt,name=user_id,json=userId" json:"user_id,omitempty"`
+	EntityIds []string `protobuf:"bytes,2,rep,name=entity_ids,json=entityIds" json:"entity_ids,omitempty"`
+	// description of why the entities couldn't be gotten, empty if they were
+	Error string `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+	// gRPC status code of the error, OK (0) if the entities were gotten
+	Code uint32 `protobuf:"varint,4,opt,name=code" json:"code,omitempty"`
+}
+
+func (m *UserEntities) Reset()                    { *m = UserEntities{} }
+func (m *UserEntities) String() string            { return proto.CompactTextString(m) }
+func (*UserEntities) ProtoMessage()               {}
+func (*UserEntities) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *UserEntities) GetUserId() string {
+	if m != nil {
+		return m.UserId
 	}
-	return out, nil
+	return ""
 }
 
-func (c *userClient) GetEntities(ctx context.Context, in *GetEntitiesRequest, opts ...grpc.CallOption) (*GetEntitiesResponse, error) {
-	out := new(GetEntitiesResponse)
-	err := grpc.Invoke(ctx, "/userapi.User/GetEntities", in, out, c.cc, opts...)
-	if err != nil {
-		return nil, err
+func (m *UserEntities) GetEntityIds() []string {
+	if m != nil {
+		return m.EntityIds
 	}
-	return out, nil
+	return nil
 }
 
-// Server API for User service
+func (m *UserEntities) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
 
-type UserServer interface {
-	// AddEntity associates an entity ID with the given user ID.
-	AddEntity(context.Context, *AddEntityRequest) (*AddEntityResponse, error)
-	// GetEntities returns a list of entity IDs associated with the given user ID.
-	GetEntities(context.Context, *GetEntitiesRequest) (*GetEntitiesResponse, error)
+func (m *UserEntities) GetCode() uint32 {
+	if m != nil {
+		return m.Code
+	}
+	return 0
 }
 
-func RegisterUserServer(s *grpc.Server, srv UserServer) {
-	s.RegisterService(&_User_serviceDesc, srv)
+type GetUsersRequest struct {
+	EntityId string `protobuf:"bytes,1,opt,name=entity_id,json=entityId" json:"entity_id,omitempty"`
+	// max number of user IDs to return; zero returns all of them in one response, and values
+	// above the max of 1000 are treated as 1000
+	PageSize uint32 `protobuf:"varint,2,opt,name=page_size,json=pageSize" json:"page_size,omitempty"`
+	// next_page_token from the previous response, or empty for the first page; the entity_id
+	// must be the same as for the previous page
+	PageToken string `protobuf:"bytes,3,opt,name=page_token,json=pageToken" json:"page_token,omitempty"`
 }
 
-func _User_AddEntity_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(AddEntityRequest)
-	if err := dec(in); err != nil {
-		return nil, err
+func (m *GetUsersRequest) Reset()                    { *m = GetUsersRequest{} }
+func (m *GetUsersRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetUsersRequest) ProtoMessage()               {}
+func (*GetUsersRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *GetUsersRequest) GetEntityId() string {
+	if m != nil {
+		return m.EntityId
 	}
-	if interceptor == nil {
-		return srv.(UserServer).AddEntity(ctx, in)
+	return ""
+}
+
+func (m *GetUsersRequest) GetPageSize() uint32 {
+	if m != nil {
+		return m.PageSize
 	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/userapi.User/AddEntity",
+	return 0
+}
+
+func (m *GetUsersRequest) GetPageToken() string {
+	if m != nil {
+		return m.PageToken
 	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(UserServer).AddEntity(ctx, req.(*AddEntityRequest))
+	return ""
+}
+
+type GetUsersResponse struct {
+	// user IDs in ascending order
+	UserIds []string `protobuf:"bytes,1,rep,name=user_ids,json=userIds" json:"user_ids,omitempty"`
+	// token for getting the next page, empty if there are no more user IDs
+	NextPageToken string `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken" json:"next_page_token,omitempty"`
+}
+
+func (m *GetUsersResponse) Reset()                    { *m = GetUsersResponse{} }
+func (m *GetUsersResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetUsersResponse) ProtoMessage()               {}
+func (*GetUsersResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *GetUsersResponse) GetUserIds() []string {
+	if m != nil {
+		return m.UserIds
 	}
-	return interceptor(ctx, in, info, handler)
+	return nil
 }
 
-func _User_GetEntities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(GetEntitiesRequest)
-	if err := dec(in); err != nil {
-		return nil, err
+func (m *GetUsersResponse) GetNextPageToken() string {
+	if m != nil {
+		return m.NextPageToken
 	}
-	if interceptor == nil {
-		return srv.(UserServer).GetEntities(ctx, in)
+	return ""
+}
+
+type GetAssociationHistoryRequest struct {
+	// at least one of user_id and entity_id must be given; when both are given, only events for
+	// that (user ID, entity ID) association are returned
+	UserId   string `protobuf:"bytes,1,opt,name=user_id,json=userId" json:"user_id,omitempty"`
+	EntityId string `protobuf:"bytes,2,opt,name=entity_id,json=entityId" json:"entity_id,omitempty"`
+}
+
+func (m *GetAssociationHistoryRequest) Reset()                    { *m = GetAssociationHistoryRequest{} }
+func (m *GetAssociationHistoryRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetAssociationHistoryRequest) ProtoMessage()               {}
+func (*GetAssociationHistoryRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+func (m *GetAssociationHistoryRequest) GetUserId() string {
+	if m != nil {
+		return m.UserId
 	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/userapi.User/GetEntities",
+	return ""
+}
+
+func (m *GetAssociationHistoryRequest) GetEntityId() string {
+	if m != nil {
+		return m.EntityId
 	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(UserServer).GetEntities(ctx, req.(*GetEntitiesRequest))
+	return ""
+}
+
+type GetAssociationHistoryResponse struct {
+	Events []*AssociationEvent `protobuf:"bytes,1,rep,name=events" json:"events,omitempty"`
+}
+
+func (m *GetAssociationHistoryResponse) Reset()                    { *m = GetAssociationHistoryResponse{} }
+func (m *GetAssociationHistoryResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetAssociationHistoryResponse) ProtoMessage()               {}
+func (*GetAssociationHistoryResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+func (m *GetAssociationHistoryResponse) GetEvents() []*AssociationEvent {
+	if m != nil {
+		return m.Events
 	}
-	return interceptor(ctx, in, info, handler)
+	return nil
 }
 
-var _User_serviceDesc = grpc.ServiceDesc{
-	ServiceName: "userapi.User",
-	HandlerType: (*UserServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "AddEntity",
-			Handler:    _User_AddEntity_Handler,
-		},
-		{
+type AssociationEvent struct {
+	Type     EventType                  `protobuf:"varint,1,opt,name=type,enum=userapi.EventType" json:"type,omitempty"`
+	Time     *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=time" json:"time,omitempty"`
+	UserId   string                     `protobuf:"bytes,3,opt,name=user_id,json=userId" json:"user_id,omitempty"`
+	EntityId string                     `protobuf:"bytes,4,opt,name=entity_id,json=entityId" json:"entity_id,omitempty"`
+	// role of the user for the entity as of the event
+	Role Role `protobuf:"varint,5,opt,name=role,enum=userapi.Role" json:"role,omitempty"`
+}
+
+func (m *AssociationEvent) Reset()                    { *m = AssociationEvent{} }
+func (m *AssociationEvent) String() string            { return proto.CompactTextString(m) }
+func (*AssociationEvent) ProtoMessage()               {}
+func (*AssociationEvent) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
+
+func (m *AssociationEvent) GetType() EventType {
+	if m != nil {
+		return m.Type
+	}
+	return EventType_UNSPECIFIED_EVENT_TYPE
+}
+
+func (m *AssociationEvent) GetTime() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Time
+	}
+	return nil
+}
+
+func (m *AssociationEvent) GetUserId() string {
+	if m != nil {
+		return m.UserId
+	}
+	return ""
+}
+
+func (m *AssociationEvent) GetEntityId() string {
+	if m != nil {
+		return m.EntityId
+	}
+	return ""
+}
+
+func (m *AssociationEvent) GetRole() Role {
+	if m != nil {
+		return m.Role
+	}
+	return Role_UNSPECIFIED_ROLE
+}
+
+type WatchEntitiesRequest struct {
+	// at least one of user_id and entity_id must be given; when both are given, only events for
+	// that (user ID, entity ID) association are sent
+	UserId   string `protobuf:"bytes,1,opt,name=user_id,json=userId" json:"user_id,omitempty"`
+	EntityId string `protobuf:"bytes,2,opt,name=entity_id,json=entityId" json:"entity_id,omitempty"`
+	// if non-zero, the events after this revision are sent before new ones, so that a watch can
+	// resume from the revision of the last event it received; a revision after the server's
+	// latest event, e.g., one from before it restarted, fails with OUT_OF_RANGE, after which the
+	// watch must start over
+	FromRevision uint64 `protobuf:"varint,3,opt,name=from_revision,json=fromRevision" json:"from_revision,omitempty"`
+}
+
+func (m *WatchEntitiesRequest) Reset()                    { *m = WatchEntitiesRequest{} }
+func (m *WatchEntitiesRequest) String() string            { return proto.CompactTextString(m) }
+func (*WatchEntitiesRequest) ProtoMessage()               {}
+func (*WatchEntitiesRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{17} }
+
+func (m *WatchEntitiesRequest) GetUserId() string {
+	if m != nil {
+		return m.UserId
+	}
+	return ""
+}
+
+func (m *WatchEntitiesRequest) GetEntityId() string {
+	if m != nil {
+		return m.EntityId
+	}
+	return ""
+}
+
+func (m *WatchEntitiesRequest) GetFromRevision() uint64 {
+	if m != nil {
+		return m.FromRevision
+	}
+	return 0
+}
+
+type WatchEntitiesResponse struct {
+	Event *AssociationEvent `protobuf:"bytes,1,opt,name=event" json:"event,omitempty"`
+	// position of the event in the change feed, increasing with each event
+	Revision uint64 `protobuf:"varint,2,opt,name=revision" json:"revision,omitempty"`
+}
+
+func (m *WatchEntitiesResponse) Reset()                    { *m = WatchEntitiesResponse{} }
+func (m *WatchEntitiesResponse) String() string            { return proto.CompactTextString(m) }
+func (*WatchEntitiesResponse) ProtoMessage()               {}
+func (*WatchEntitiesResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{18} }
+
+func (m *WatchEntitiesResponse) GetEvent() *AssociationEvent {
+	if m != nil {
+		return m.Event
+	}
+	return nil
+}
+
+func (m *WatchEntitiesResponse) GetRevision() uint64 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
+type SetUserLimitRequest struct {
+	UserId string `protobuf:"bytes,1,opt,name=user_id,json=userId" json:"user_id,omitempty"`
+	// max number of entities the user may be associated with; zero removes any existing
+	// override so the server-wide limit applies again
+	MaxEntities uint32 `protobuf:"varint,2,opt,name=max_entities,json=maxEntities" json:"max_entities,omitempty"`
+}
+
+func (m *SetUserLimitRequest) Reset()                    { *m = SetUserLimitRequest{} }
+func (m *SetUserLimitRequest) String() string            { return proto.CompactTextString(m) }
+func (*SetUserLimitRequest) ProtoMessage()               {}
+func (*SetUserLimitRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{19} }
+
+func (m *SetUserLimitRequest) GetUserId() string {
+	if m != nil {
+		return m.UserId
+	}
+	return ""
+}
+
+func (m *SetUserLimitRequest) GetMaxEntities() uint32 {
+	if m != nil {
+		return m.MaxEntities
+	}
+	return 0
+}
+
+type SetUserLimitResponse struct {
+}
+
+func (m *SetUserLimitResponse) Reset()                    { *m = SetUserLimitResponse{} }
+func (m *SetUserLimitResponse) String() string            { return proto.CompactTextString(m) }
+func (*SetUserLimitResponse) ProtoMessage()               {}
+func (*SetUserLimitResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{20} }
+
+type SetEntityLimitRequest struct {
+	EntityId string `protobuf:"bytes,1,opt,name=entity_id,json=entityId" json:"entity_id,omitempty"`
+	// max number of users the entity may be associated with; zero removes any existing
+	// override so the server-wide limit applies again
+	MaxUsers uint32 `protobuf:"varint,2,opt,name=max_users,json=maxUsers" json:"max_users,omitempty"`
+}
+
+func (m *SetEntityLimitRequest) Reset()                    { *m = SetEntityLimitRequest{} }
+func (m *SetEntityLimitRequest) String() string            { return proto.CompactTextString(m) }
+func (*SetEntityLimitRequest) ProtoMessage()               {}
+func (*SetEntityLimitRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{21} }
+
+func (m *SetEntityLimitRequest) GetEntityId() string {
+	if m != nil {
+		return m.EntityId
+	}
+	return ""
+}
+
+func (m *SetEntityLimitRequest) GetMaxUsers() uint32 {
+	if m != nil {
+		return m.MaxUsers
+	}
+	return 0
+}
+
+type SetEntityLimitResponse struct {
+}
+
+func (m *SetEntityLimitResponse) Reset()                    { *m = SetEntityLimitResponse{} }
+func (m *SetEntityLimitResponse) String() string            { return proto.CompactTextString(m) }
+func (*SetEntityLimitResponse) ProtoMessage()               {}
+func (*SetEntityLimitResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{22} }
+
+type UpdateRoleRequest struct {
+	UserId   string `protobuf:"bytes,1,opt,name=user_id,json=userId" json:"user_id,omitempty"`
+	EntityId string `protobuf:"bytes,2,opt,name=entity_id,json=entityId" json:"entity_id,omitempty"`
+	Role     Role   `protobuf:"varint,3,opt,name=role,enum=userapi.Role" json:"role,omitempty"`
+}
+
+func (m *UpdateRoleRequest) Reset()                    { *m = UpdateRoleRequest{} }
+func (m *UpdateRoleRequest) String() string            { return proto.CompactTextString(m) }
+func (*UpdateRoleRequest) ProtoMessage()               {}
+func (*UpdateRoleRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{23} }
+
+func (m *UpdateRoleRequest) GetUserId() string {
+	if m != nil {
+		return m.UserId
+	}
+	return ""
+}
+
+func (m *UpdateRoleRequest) GetEntityId() string {
+	if m != nil {
+		return m.EntityId
+	}
+	return ""
+}
+
+func (m *UpdateRoleRequest) GetRole() Role {
+	if m != nil {
+		return m.Role
+	}
+	return Role_UNSPECIFIED_ROLE
+}
+
+type UpdateRoleResponse struct {
+}
+
+func (m *UpdateRoleResponse) Reset()                    { *m = UpdateRoleResponse{} }
+func (m *UpdateRoleResponse) String() string            { return proto.CompactTextString(m) }
+func (*UpdateRoleResponse) ProtoMessage()               {}
+func (*UpdateRoleResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{24} }
+
+type UserProfile struct {
+	UserId      string `protobuf:"bytes,1,opt,name=user_id,json=userId" json:"user_id,omitempty"`
+	DisplayName string `protobuf:"bytes,2,opt,name=display_name,json=displayName" json:"display_name,omitempty"`
+	Email       string `protobuf:"bytes,3,opt,name=email" json:"email,omitempty"`
+	Phone       string `protobuf:"bytes,4,opt,name=phone" json:"phone,omitempty"`
+	// preferred locale as a BCP 47 language tag, e.g., "en-US"
+	Locale string `protobuf:"bytes,5,opt,name=locale" json:"locale,omitempty"`
+	// IANA time zone name, e.g., "America/New_York"
+	Timezone string `protobuf:"bytes,6,opt,name=timezone" json:"timezone,omitempty"`
+	// free-form attributes not covered by the fields above
+	Attributes map[string]string `protobuf:"bytes,7,rep,name=attributes" json:"attributes,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *UserProfile) Reset()                    { *m = UserProfile{} }
+func (m *UserProfile) String() string            { return proto.CompactTextString(m) }
+func (*UserProfile) ProtoMessage()               {}
+func (*UserProfile) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{25} }
+
+func (m *UserProfile) GetUserId() string {
+	if m != nil {
+		return m.UserId
+	}
+	return ""
+}
+
+func (m *UserProfile) GetDisplayName() string {
+	if m != nil {
+		return m.DisplayName
+	}
+	return ""
+}
+
+func (m *UserProfile) GetEmail() string {
+	if m != nil {
+		return m.Email
+	}
+	return ""
+}
+
+func (m *UserProfile) GetPhone() string {
+	if m != nil {
+		return m.Phone
+	}
+	return ""
+}
+
+func (m *UserProfile) GetLocale() string {
+	if m != nil {
+		return m.Locale
+	}
+	return ""
+}
+
+func (m *UserProfile) GetTimezone() string {
+	if m != nil {
+		return m.Timezone
+	}
+	return ""
+}
+
+func (m *UserProfile) GetAttributes() map[string]string {
+	if m != nil {
+		return m.Attributes
+	}
+	return nil
+}
+
+type PutUserRequest struct {
+	User *UserProfile `protobuf:"bytes,1,opt,name=user" json:"user,omitempty"`
+}
+
+func (m *PutUserRequest) Reset()                    { *m = PutUserRequest{} }
+func (m *PutUserRequest) String() string            { return proto.CompactTextString(m) }
+func (*PutUserRequest) ProtoMessage()               {}
+func (*PutUserRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{26} }
+
+func (m *PutUserRequest) GetUser() *UserProfile {
+	if m != nil {
+		return m.User
+	}
+	return nil
+}
+
+type PutUserResponse struct {
+}
+
+func (m *PutUserResponse) Reset()                    { *m = PutUserResponse{} }
+func (m *PutUserResponse) String() string            { return proto.CompactTextString(m) }
+func (*PutUserResponse) ProtoMessage()               {}
+func (*PutUserResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{27} }
+
+type GetUserRequest struct {
+	UserId string `protobuf:"bytes,1,opt,name=user_id,json=userId" json:"user_id,omitempty"`
+}
+
+func (m *GetUserRequest) Reset()                    { *m = GetUserRequest{} }
+func (m *GetUserRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetUserRequest) ProtoMessage()               {}
+func (*GetUserRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{28} }
+
+func (m *GetUserRequest) GetUserId() string {
+	if m != nil {
+		return m.UserId
+	}
+	return ""
+}
+
+type GetUserResponse struct {
+	User *UserProfile `protobuf:"bytes,1,opt,name=user" json:"user,omitempty"`
+}
+
+func (m *GetUserResponse) Reset()                    { *m = GetUserResponse{} }
+func (m *GetUserResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetUserResponse) ProtoMessage()               {}
+func (*GetUserResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{29} }
+
+func (m *GetUserResponse) GetUser() *UserProfile {
+	if m != nil {
+		return m.User
+	}
+	return nil
+}
+
+type UpdateUserRequest struct {
+	// profile whose non-empty fields replace those of the existing profile; attributes are
+	// merged key by key, and an attribute with an empty value is removed
+	User *UserProfile `protobuf:"bytes,1,opt,name=user" json:"user,omitempty"`
+}
+
+func (m *UpdateUserRequest) Reset()                    { *m = UpdateUserRequest{} }
+func (m *UpdateUserRequest) String() string            { return proto.CompactTextString(m) }
+func (*UpdateUserRequest) ProtoMessage()               {}
+func (*UpdateUserRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{30} }
+
+func (m *UpdateUserRequest) GetUser() *UserProfile {
+	if m != nil {
+		return m.User
+	}
+	return nil
+}
+
+type UpdateUserResponse struct {
+	// profile after the update
+	User *UserProfile `protobuf:"bytes,1,opt,name=user" json:"user,omitempty"`
+}
+
+func (m *UpdateUserResponse) Reset()                    { *m = UpdateUserResponse{} }
+func (m *UpdateUserResponse) String() string            { return proto.CompactTextString(m) }
+func (*UpdateUserResponse) ProtoMessage()               {}
+func (*UpdateUserResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{31} }
+
+func (m *UpdateUserResponse) GetUser() *UserProfile {
+	if m != nil {
+		return m.User
+	}
+	return nil
+}
+
+type ExportAssociationsRequest struct {
+}
+
+func (m *ExportAssociationsRequest) Reset()                    { *m = ExportAssociationsRequest{} }
+func (m *ExportAssociationsRequest) String() string            { return proto.CompactTextString(m) }
+func (*ExportAssociationsRequest) ProtoMessage()               {}
+func (*ExportAssociationsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{32} }
+
+type ExportAssociationsResponse struct {
+	Association *AssociationRecord `protobuf:"bytes,1,opt,name=association" json:"association,omitempty"`
+}
+
+func (m *ExportAssociationsResponse) Reset()                    { *m = ExportAssociationsResponse{} }
+func (m *ExportAssociationsResponse) String() string            { return proto.CompactTextString(m) }
+func (*ExportAssociationsResponse) ProtoMessage()               {}
+func (*ExportAssociationsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{33} }
+
+func (m *ExportAssociationsResponse) GetAssociation() *AssociationRecord {
+	if m != nil {
+		return m.Association
+	}
+	return nil
+}
+
+type ImportAssociationsRequest struct {
+	// options for the whole import, which only the first request of the stream sets
+	Options *ImportOptions `protobuf:"bytes,1,opt,name=options" json:"options,omitempty"`
+	// associations to import, each with its full history
+	Associations []*AssociationRecord `protobuf:"bytes,2,rep,name=associations" json:"associations,omitempty"`
+}
+
+func (m *ImportAssociationsRequest) Reset()                    { *m = ImportAssociationsRequest{} }
+func (m *ImportAssociationsRequest) String() string            { return proto.CompactTextString(m) }
+func (*ImportAssociationsRequest) ProtoMessage()               {}
+func (*ImportAssociationsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{34} }
+
+func (m *ImportAssociationsRequest) GetOptions() *ImportOptions {
+	if m != nil {
+		return m.Options
+	}
+	return nil
+}
+
+func (m *ImportAssociationsRequest) GetAssociations() []*AssociationRecord {
+	if m != nil {
+		return m.Associations
+	}
+	return nil
+}
+
+type ImportOptions struct {
+	// how to import an association that already exists, i.e., that has any history
+	ConflictMode ConflictMode `protobuf:"varint,1,opt,name=conflict_mode,json=conflictMode,enum=userapi.ConflictMode" json:"conflict_mode,omitempty"`
+	// whether to only count what would be imported, overwritten, and skipped without changing
+	// any associations
+	DryRun bool `protobuf:"varint,2,opt,name=dry_run,json=dryRun" json:"dry_run,omitempty"`
+}
+
+func (m *ImportOptions) Reset()                    { *m = ImportOptions{} }
+func (m *ImportOptions) String() string            { return proto.CompactTextString(m) }
+func (*ImportOptions) ProtoMessage()               {}
+func (*ImportOptions) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{35} }
+
+func (m *ImportOptions) GetConflictMode() ConflictMode {
+	if m != nil {
+		return m.ConflictMode
+	}
+	return ConflictMode_UNSPECIFIED_CONFLICT_MODE
+}
+
+func (m *ImportOptions) GetDryRun() bool {
+	if m != nil {
+		return m.DryRun
+	}
+	return false
+}
+
+type ImportAssociationsResponse struct {
+	// number of associations that didn't exist before, that replaced existing ones, and that
+	// were skipped since they already existed; for a dry run, the numbers that would have been
+	NImported    uint64 `protobuf:"varint,1,opt,name=n_imported,json=nImported" json:"n_imported,omitempty"`
+	NOverwritten uint64 `protobuf:"varint,2,opt,name=n_overwritten,json=nOverwritten" json:"n_overwritten,omitempty"`
+	NSkipped     uint64 `protobuf:"varint,3,opt,name=n_skipped,json=nSkipped" json:"n_skipped,omitempty"`
+}
+
+func (m *ImportAssociationsResponse) Reset()                    { *m = ImportAssociationsResponse{} }
+func (m *ImportAssociationsResponse) String() string            { return proto.CompactTextString(m) }
+func (*ImportAssociationsResponse) ProtoMessage()               {}
+func (*ImportAssociationsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{36} }
+
+func (m *ImportAssociationsResponse) GetNImported() uint64 {
+	if m != nil {
+		return m.NImported
+	}
+	return 0
+}
+
+func (m *ImportAssociationsResponse) GetNOverwritten() uint64 {
+	if m != nil {
+		return m.NOverwritten
+	}
+	return 0
+}
+
+func (m *ImportAssociationsResponse) GetNSkipped() uint64 {
+	if m != nil {
+		return m.NSkipped
+	}
+	return 0
+}
+
+// AssociationRecord is a user-entity association with its full history, as exported and
+// imported.
+type AssociationRecord struct {
+	UserId   string `protobuf:"bytes,1,opt,name=user_id,json=userId" json:"user_id,omitempty"`
+	EntityId string `protobuf:"bytes,2,opt,name=entity_id,json=entityId" json:"entity_id,omitempty"`
+	// versions of the association, oldest first; only the last may be current
+	Versions []*AssociationVersion `protobuf:"bytes,3,rep,name=versions" json:"versions,omitempty"`
+}
+
+func (m *AssociationRecord) Reset()                    { *m = AssociationRecord{} }
+func (m *AssociationRecord) String() string            { return proto.CompactTextString(m) }
+func (*AssociationRecord) ProtoMessage()               {}
+func (*AssociationRecord) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{37} }
+
+func (m *AssociationRecord) GetUserId() string {
+	if m != nil {
+		return m.UserId
+	}
+	return ""
+}
+
+func (m *AssociationRecord) GetEntityId() string {
+	if m != nil {
+		return m.EntityId
+	}
+	return ""
+}
+
+func (m *AssociationRecord) GetVersions() []*AssociationVersion {
+	if m != nil {
+		return m.Versions
+	}
+	return nil
+}
+
+// AssociationVersion is an association with a given role from when it was added until it was
+// removed. A role update removes the version as superseded and adds one with the new role.
+type AssociationVersion struct {
+	Role      Role                       `protobuf:"varint,1,opt,name=role,enum=userapi.Role" json:"role,omitempty"`
+	AddedTime *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=added_time,json=addedTime" json:"added_time,omitempty"`
+	// unset if the version is current
+	RemovedTime *google_protobuf.Timestamp `protobuf:"bytes,3,opt,name=removed_time,json=removedTime" json:"removed_time,omitempty"`
+	// whether the version was added or removed, respectively, by a role update rather than by
+	// an add or remove
+	RoleUpdated bool `protobuf:"varint,4,opt,name=role_updated,json=roleUpdated" json:"role_updated,omitempty"`
+	Superseded  bool `protobuf:"varint,5,opt,name=superseded" json:"superseded,omitempty"`
+}
+
+func (m *AssociationVersion) Reset()                    { *m = AssociationVersion{} }
+func (m *AssociationVersion) String() string            { return proto.CompactTextString(m) }
+func (*AssociationVersion) ProtoMessage()               {}
+func (*AssociationVersion) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{38} }
+
+func (m *AssociationVersion) GetRole() Role {
+	if m != nil {
+		return m.Role
+	}
+	return Role_UNSPECIFIED_ROLE
+}
+
+func (m *AssociationVersion) GetAddedTime() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.AddedTime
+	}
+	return nil
+}
+
+func (m *AssociationVersion) GetRemovedTime() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.RemovedTime
+	}
+	return nil
+}
+
+func (m *AssociationVersion) GetRoleUpdated() bool {
+	if m != nil {
+		return m.RoleUpdated
+	}
+	return false
+}
+
+func (m *AssociationVersion) GetSuperseded() bool {
+	if m != nil {
+		return m.Superseded
+	}
+	return false
+}
+
+// SnapshotHeader begins a snapshot file of exported associations.
+type SnapshotHeader struct {
+	// version of the snapshot format
+	Version uint32 `protobuf:"varint,1,opt,name=version" json:"version,omitempty"`
+	// when the export started
+	CreatedTime *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=created_time,json=createdTime" json:"created_time,omitempty"`
+}
+
+func (m *SnapshotHeader) Reset()                    { *m = SnapshotHeader{} }
+func (m *SnapshotHeader) String() string            { return proto.CompactTextString(m) }
+func (*SnapshotHeader) ProtoMessage()               {}
+func (*SnapshotHeader) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{39} }
+
+func (m *SnapshotHeader) GetVersion() uint32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *SnapshotHeader) GetCreatedTime() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.CreatedTime
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*AddEntityRequest)(nil), "userapi.AddEntityRequest")
+	proto.RegisterType((*AddEntityResponse)(nil), "userapi.AddEntityResponse")
+	proto.RegisterType((*AddEntitiesRequest)(nil), "userapi.AddEntitiesRequest")
+	proto.RegisterType((*AddEntitiesResponse)(nil), "userapi.AddEntitiesResponse")
+	proto.RegisterType((*AddEntityResult)(nil), "userapi.AddEntityResult")
+	proto.RegisterType((*RemoveEntityRequest)(nil), "userapi.RemoveEntityRequest")
+	proto.RegisterType((*RemoveEntityResponse)(nil), "userapi.RemoveEntityResponse")
+	proto.RegisterType((*GetEntitiesRequest)(nil), "userapi.GetEntitiesRequest")
+	proto.RegisterType((*GetEntitiesResponse)(nil), "userapi.GetEntitiesResponse")
+	proto.RegisterType((*GetEntitiesBatchRequest)(nil), "userapi.GetEntitiesBatchRequest")
+	proto.RegisterType((*GetEntitiesBatchResponse)(nil), "userapi.GetEntitiesBatchResponse")
+	proto.RegisterType((*UserEntities)(nil), "userapi.UserEntities")
+	proto.RegisterType((*GetUsersRequest)(nil), "userapi.GetUsersRequest")
+	proto.RegisterType((*GetUsersResponse)(nil), "userapi.GetUsersResponse")
+	proto.RegisterType((*GetAssociationHistoryRequest)(nil), "userapi.GetAssociationHistoryRequest")
+	proto.RegisterType((*GetAssociationHistoryResponse)(nil), "userapi.GetAssociationHistoryResponse")
+	proto.RegisterType((*AssociationEvent)(nil), "userapi.AssociationEvent")
+	proto.RegisterType((*WatchEntitiesRequest)(nil), "userapi.WatchEntitiesRequest")
+	proto.RegisterType((*WatchEntitiesResponse)(nil), "userapi.WatchEntitiesResponse")
+	proto.RegisterType((*SetUserLimitRequest)(nil), "userapi.SetUserLimitRequest")
+	proto.RegisterType((*SetUserLimitResponse)(nil), "userapi.SetUserLimitResponse")
+	proto.RegisterType((*SetEntityLimitRequest)(nil), "userapi.SetEntityLimitRequest")
+	proto.RegisterType((*SetEntityLimitResponse)(nil), "userapi.SetEntityLimitResponse")
+	proto.RegisterType((*UpdateRoleRequest)(nil), "userapi.UpdateRoleRequest")
+	proto.RegisterType((*UpdateRoleResponse)(nil), "userapi.UpdateRoleResponse")
+	proto.RegisterType((*UserProfile)(nil), "userapi.UserProfile")
+	proto.RegisterType((*PutUserRequest)(nil), "userapi.PutUserRequest")
+	proto.RegisterType((*PutUserResponse)(nil), "userapi.PutUserResponse")
+	proto.RegisterType((*GetUserRequest)(nil), "userapi.GetUserRequest")
+	proto.RegisterType((*GetUserResponse)(nil), "userapi.GetUserResponse")
+	proto.RegisterType((*UpdateUserRequest)(nil), "userapi.UpdateUserRequest")
+	proto.RegisterType((*UpdateUserResponse)(nil), "userapi.UpdateUserResponse")
+	proto.RegisterType((*ExportAssociationsRequest)(nil), "userapi.ExportAssociationsRequest")
+	proto.RegisterType((*ExportAssociationsResponse)(nil), "userapi.ExportAssociationsResponse")
+	proto.RegisterType((*ImportAssociationsRequest)(nil), "userapi.ImportAssociationsRequest")
+	proto.RegisterType((*ImportOptions)(nil), "userapi.ImportOptions")
+	proto.RegisterType((*ImportAssociationsResponse)(nil), "userapi.ImportAssociationsResponse")
+	proto.RegisterType((*AssociationRecord)(nil), "userapi.AssociationRecord")
+	proto.RegisterType((*AssociationVersion)(nil), "userapi.AssociationVersion")
+	proto.RegisterType((*SnapshotHeader)(nil), "userapi.SnapshotHeader")
+	proto.RegisterEnum("userapi.EventType", EventType_name, EventType_value)
+	proto.RegisterEnum("userapi.Role", Role_name, Role_value)
+	proto.RegisterEnum("userapi.ConflictMode", ConflictMode_name, ConflictMode_value)
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for User service
+
+type UserClient interface {
+	// AddEntity associates an entity ID with the given user ID.
+	AddEntity(ctx context.Context, in *AddEntityRequest, opts ...grpc.CallOption) (*AddEntityResponse, error)
+	// AddEntities associates each entity ID with its user ID, reporting the outcome of each
+	// association separately.
+	AddEntities(ctx context.Context, in *AddEntitiesRequest, opts ...grpc.CallOption) (*AddEntitiesResponse, error)
+	// RemoveEntity dissociates an entity ID from the given user ID.
+	RemoveEntity(ctx context.Context, in *RemoveEntityRequest, opts ...grpc.CallOption) (*RemoveEntityResponse, error)
+	// GetEntities returns a page of the entity IDs associated with the given user ID, ordered by
+	// entity ID, optionally as of a point in time in the past.
+	GetEntities(ctx context.Context, in *GetEntitiesRequest, opts ...grpc.CallOption) (*GetEntitiesResponse, error)
+	// GetEntitiesBatch returns the entity IDs associated with each of the given user IDs.
+	GetEntitiesBatch(ctx context.Context, in *GetEntitiesBatchRequest, opts ...grpc.CallOption) (*GetEntitiesBatchResponse, error)
+	// GetUsers returns a page of the user IDs associated with the given entity ID, ordered by
+	// user ID.
+	GetUsers(ctx context.Context, in *GetUsersRequest, opts ...grpc.CallOption) (*GetUsersResponse, error)
+	// GetAssociationHistory returns the time-ordered add, remove, and role update events for the
+	// associations of the given user ID and/or entity ID.
+	GetAssociationHistory(ctx context.Context, in *GetAssociationHistoryRequest, opts ...grpc.CallOption) (*GetAssociationHistoryResponse, error)
+	// WatchEntities streams the add, remove, and role update events for the associations of the
+	// given user ID and/or entity ID as they happen, optionally first sending those since a
+	// revision.
+	WatchEntities(ctx context.Context, in *WatchEntitiesRequest, opts ...grpc.CallOption) (User_WatchEntitiesClient, error)
+	// SetUserLimit overrides the max number of entities the given user ID may be associated with.
+	SetUserLimit(ctx context.Context, in *SetUserLimitRequest, opts ...grpc.CallOption) (*SetUserLimitResponse, error)
+	// SetEntityLimit overrides the max number of users the given entity ID may be associated
+	// with.
+	SetEntityLimit(ctx context.Context, in *SetEntityLimitRequest, opts ...grpc.CallOption) (*SetEntityLimitResponse, error)
+	// UpdateRole changes the role of the given user ID for the associated entity ID.
+	UpdateRole(ctx context.Context, in *UpdateRoleRequest, opts ...grpc.CallOption) (*UpdateRoleResponse, error)
+	// PutUser creates the profile of a user or replaces it if one already exists.
+	PutUser(ctx context.Context, in *PutUserRequest, opts ...grpc.CallOption) (*PutUserResponse, error)
+	// GetUser returns the profile of the given user ID.
+	GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*GetUserResponse, error)
+	// UpdateUser merges the populated fields of the given profile into the user's existing
+	// profile.
+	UpdateUser(ctx context.Context, in *UpdateUserRequest, opts ...grpc.CallOption) (*UpdateUserResponse, error)
+	// ExportAssociations streams every association with its full history, ordered by user ID
+	// and then entity ID, so it can be backed up or imported into another server.
+	ExportAssociations(ctx context.Context, in *ExportAssociationsRequest, opts ...grpc.CallOption) (User_ExportAssociationsClient, error)
+	// ImportAssociations stores the streamed associations with their history as they are,
+	// resolving any that already exist according to the conflict mode. The whole stream, of
+	// at most 100,000 records with no two for the same user and entity, is checked before any
+	// association is stored, and watches get a single event with each imported association's
+	// current state.
+	ImportAssociations(ctx context.Context, opts ...grpc.CallOption) (User_ImportAssociationsClient, error)
+}
+
+type userClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewUserClient(cc *grpc.ClientConn) UserClient {
+	return &userClient{cc}
+}
+
+func (c *userClient) AddEntity(ctx context.Context, in *AddEntityRequest, opts ...grpc.CallOption) (*AddEntityResponse, error) {
+	out := new(AddEntityResponse)
+	err := grpc.Invoke(ctx, "/userapi.User/AddEntity", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userClient) AddEntities(ctx context.Context, in *AddEntitiesRequest, opts ...grpc.CallOption) (*AddEntitiesResponse, error) {
+	out := new(AddEntitiesResponse)
+	err := grpc.Invoke(ctx, "/userapi.User/AddEntities", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userClient) RemoveEntity(ctx context.Context, in *RemoveEntityRequest, opts ...grpc.CallOption) (*RemoveEntityResponse, error) {
+	out := new(RemoveEntityResponse)
+	err := grpc.Invoke(ctx, "/userapi.User/RemoveEntity", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userClient) GetEntities(ctx context.Context, in *GetEntitiesRequest, opts ...grpc.CallOption) (*GetEntitiesResponse, error) {
+	out := new(GetEntitiesResponse)
+	err := grpc.Invoke(ctx, "/userapi.User/GetEntities", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userClient) GetEntitiesBatch(ctx context.Context, in *GetEntitiesBatchRequest, opts ...grpc.CallOption) (*GetEntitiesBatchResponse, error) {
+	out := new(GetEntitiesBatchResponse)
+	err := grpc.Invoke(ctx, "/userapi.User/GetEntitiesBatch", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userClient) GetUsers(ctx context.Context, in *GetUsersRequest, opts ...grpc.CallOption) (*GetUsersResponse, error) {
+	out := new(GetUsersResponse)
+	err := grpc.Invoke(ctx, "/userapi.User/GetUsers", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userClient) GetAssociationHistory(ctx context.Context, in *GetAssociationHistoryRequest, opts ...grpc.CallOption) (*GetAssociationHistoryResponse, error) {
+	out := new(GetAssociationHistoryResponse)
+	err := grpc.Invoke(ctx, "/userapi.User/GetAssociationHistory", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userClient) WatchEntities(ctx context.Context, in *WatchEntitiesRequest, opts ...grpc.CallOption) (User_WatchEntitiesClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_User_serviceDesc.Streams[0], c.cc, "/userapi.User/WatchEntities", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &userWatchEntitiesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type User_WatchEntitiesClient interface {
+	Recv() (*WatchEntitiesResponse, error)
+	grpc.ClientStream
+}
+
+type userWatchEntitiesClient struct {
+	grpc.ClientStream
+}
+
+func (x *userWatchEntitiesClient) Recv() (*WatchEntitiesResponse, error) {
+	m := new(WatchEntitiesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *userClient) SetUserLimit(ctx context.Context, in *SetUserLimitRequest, opts ...grpc.CallOption) (*SetUserLimitResponse, error) {
+	out := new(SetUserLimitResponse)
+	err := grpc.Invoke(ctx, "/userapi.User/SetUserLimit", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userClient) SetEntityLimit(ctx context.Context, in *SetEntityLimitRequest, opts ...grpc.CallOption) (*SetEntityLimitResponse, error) {
+	out := new(SetEntityLimitResponse)
+	err := grpc.Invoke(ctx, "/userapi.User/SetEntityLimit", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userClient) UpdateRole(ctx context.Context, in *UpdateRoleRequest, opts ...grpc.CallOption) (*UpdateRoleResponse, error) {
+	out := new(UpdateRoleResponse)
+	err := grpc.Invoke(ctx, "/userapi.User/UpdateRole", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userClient) PutUser(ctx context.Context, in *PutUserRequest, opts ...grpc.CallOption) (*PutUserResponse, error) {
+	out := new(PutUserResponse)
+	err := grpc.Invoke(ctx, "/userapi.User/PutUser", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userClient) GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*GetUserResponse, error) {
+	out := new(GetUserResponse)
+	err := grpc.Invoke(ctx, "/userapi.User/GetUser", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userClient) UpdateUser(ctx context.Context, in *UpdateUserRequest, opts ...grpc.CallOption) (*UpdateUserResponse, error) {
+	out := new(UpdateUserResponse)
+	err := grpc.Invoke(ctx, "/userapi.User/UpdateUser", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userClient) ExportAssociations(ctx context.Context, in *ExportAssociationsRequest, opts ...grpc.CallOption) (User_ExportAssociationsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_User_serviceDesc.Streams[1], c.cc, "/userapi.User/ExportAssociations", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &userExportAssociationsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type User_ExportAssociationsClient interface {
+	Recv() (*ExportAssociationsResponse, error)
+	grpc.ClientStream
+}
+
+type userExportAssociationsClient struct {
+	grpc.ClientStream
+}
+
+func (x *userExportAssociationsClient) Recv() (*ExportAssociationsResponse, error) {
+	m := new(ExportAssociationsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *userClient) ImportAssociations(ctx context.Context, opts ...grpc.CallOption) (User_ImportAssociationsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_User_serviceDesc.Streams[2], c.cc, "/userapi.User/ImportAssociations", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &userImportAssociationsClient{stream}
+	return x, nil
+}
+
+type User_ImportAssociationsClient interface {
+	Send(*ImportAssociationsRequest) error
+	CloseAndRecv() (*ImportAssociationsResponse, error)
+	grpc.ClientStream
+}
+
+type userImportAssociationsClient struct {
+	grpc.ClientStream
+}
+
+func (x *userImportAssociationsClient) Send(m *ImportAssociationsRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *userImportAssociationsClient) CloseAndRecv() (*ImportAssociationsResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportAssociationsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Server API for User service
+
+type UserServer interface {
+	// AddEntity associates an entity ID with the given user ID.
+	AddEntity(context.Context, *AddEntityRequest) (*AddEntityResponse, error)
+	// AddEntities associates each entity ID with its user ID, reporting the outcome of each
+	// association separately.
+	AddEntities(context.Context, *AddEntitiesRequest) (*AddEntitiesResponse, error)
+	// RemoveEntity dissociates an entity ID from the given user ID.
+	RemoveEntity(context.Context, *RemoveEntityRequest) (*RemoveEntityResponse, error)
+	// GetEntities returns a page of the entity IDs associated with the given user ID, ordered by
+	// entity ID, optionally as of a point in time in the past.
+	GetEntities(context.Context, *GetEntitiesRequest) (*GetEntitiesResponse, error)
+	// GetEntitiesBatch returns the entity IDs associated with each of the given user IDs.
+	GetEntitiesBatch(context.Context, *GetEntitiesBatchRequest) (*GetEntitiesBatchResponse, error)
+	// GetUsers returns a page of the user IDs associated with the given entity ID, ordered by
+	// user ID.
+	GetUsers(context.Context, *GetUsersRequest) (*GetUsersResponse, error)
+	// GetAssociationHistory returns the time-ordered add, remove, and role update events for the
+	// associations of the given user ID and/or entity ID.
+	GetAssociationHistory(context.Context, *GetAssociationHistoryRequest) (*GetAssociationHistoryResponse, error)
+	// WatchEntities streams the add, remove, and role update events for the associations of the
+	// given user ID and/or entity ID as they happen, optionally first sending those since a
+	// revision.
+	WatchEntities(*WatchEntitiesRequest, User_WatchEntitiesServer) error
+	// SetUserLimit overrides the max number of entities the given user ID may be associated with.
+	SetUserLimit(context.Context, *SetUserLimitRequest) (*SetUserLimitResponse, error)
+	// SetEntityLimit overrides the max number of users the given entity ID may be associated
+	// with.
+	SetEntityLimit(context.Context, *SetEntityLimitRequest) (*SetEntityLimitResponse, error)
+	// UpdateRole changes the role of the given user ID for the associated entity ID.
+	UpdateRole(context.Context, *UpdateRoleRequest) (*UpdateRoleResponse, error)
+	// PutUser creates the profile of a user or replaces it if one already exists.
+	PutUser(context.Context, *PutUserRequest) (*PutUserResponse, error)
+	// GetUser returns the profile of the given user ID.
+	GetUser(context.Context, *GetUserRequest) (*GetUserResponse, error)
+	// UpdateUser merges the populated fields of the given profile into the user's existing
+	// profile.
+	UpdateUser(context.Context, *UpdateUserRequest) (*UpdateUserResponse, error)
+	// ExportAssociations streams every association with its full history, ordered by user ID
+	// and then entity ID, so it can be backed up or imported into another server.
+	ExportAssociations(*ExportAssociationsRequest, User_ExportAssociationsServer) error
+	// ImportAssociations stores the streamed associations with their history as they are,
+	// resolving any that already exist according to the conflict mode. The whole stream, of
+	// at most 100,000 records with no two for the same user and entity, is checked before any
+	// association is stored, and watches get a single event with each imported association's
+	// current state.
+	ImportAssociations(User_ImportAssociationsServer) error
+}
+
+func RegisterUserServer(s *grpc.Server, srv UserServer) {
+	s.RegisterService(&_User_serviceDesc, srv)
+}
+
+func _User_AddEntity_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddEntityRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServer).AddEntity(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userapi.User/AddEntity",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServer).AddEntity(ctx, req.(*AddEntityRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _User_AddEntities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddEntitiesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServer).AddEntities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userapi.User/AddEntities",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServer).AddEntities(ctx, req.(*AddEntitiesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _User_RemoveEntity_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveEntityRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServer).RemoveEntity(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userapi.User/RemoveEntity",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServer).RemoveEntity(ctx, req.(*RemoveEntityRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _User_GetEntities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEntitiesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServer).GetEntities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userapi.User/GetEntities",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServer).GetEntities(ctx, req.(*GetEntitiesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _User_GetEntitiesBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEntitiesBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServer).GetEntitiesBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userapi.User/GetEntitiesBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServer).GetEntitiesBatch(ctx, req.(*GetEntitiesBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _User_GetUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServer).GetUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userapi.User/GetUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServer).GetUsers(ctx, req.(*GetUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _User_GetAssociationHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAssociationHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServer).GetAssociationHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userapi.User/GetAssociationHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServer).GetAssociationHistory(ctx, req.(*GetAssociationHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _User_WatchEntities_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchEntitiesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UserServer).WatchEntities(m, &userWatchEntitiesServer{stream})
+}
+
+type User_WatchEntitiesServer interface {
+	Send(*WatchEntitiesResponse) error
+	grpc.ServerStream
+}
+
+type userWatchEntitiesServer struct {
+	grpc.ServerStream
+}
+
+func (x *userWatchEntitiesServer) Send(m *WatchEntitiesResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _User_SetUserLimit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetUserLimitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServer).SetUserLimit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userapi.User/SetUserLimit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServer).SetUserLimit(ctx, req.(*SetUserLimitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _User_SetEntityLimit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetEntityLimitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServer).SetEntityLimit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userapi.User/SetEntityLimit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServer).SetEntityLimit(ctx, req.(*SetEntityLimitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _User_UpdateRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateRoleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServer).UpdateRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userapi.User/UpdateRole",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServer).UpdateRole(ctx, req.(*UpdateRoleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _User_PutUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServer).PutUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userapi.User/PutUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServer).PutUser(ctx, req.(*PutUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _User_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServer).GetUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userapi.User/GetUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServer).GetUser(ctx, req.(*GetUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _User_UpdateUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServer).UpdateUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/userapi.User/UpdateUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServer).UpdateUser(ctx, req.(*UpdateUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _User_ExportAssociations_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportAssociationsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UserServer).ExportAssociations(m, &userExportAssociationsServer{stream})
+}
+
+type User_ExportAssociationsServer interface {
+	Send(*ExportAssociationsResponse) error
+	grpc.ServerStream
+}
+
+type userExportAssociationsServer struct {
+	grpc.ServerStream
+}
+
+func (x *userExportAssociationsServer) Send(m *ExportAssociationsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _User_ImportAssociations_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(UserServer).ImportAssociations(&userImportAssociationsServer{stream})
+}
+
+type User_ImportAssociationsServer interface {
+	SendAndClose(*ImportAssociationsResponse) error
+	Recv() (*ImportAssociationsRequest, error)
+	grpc.ServerStream
+}
+
+type userImportAssociationsServer struct {
+	grpc.ServerStream
+}
+
+func (x *userImportAssociationsServer) SendAndClose(m *ImportAssociationsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *userImportAssociationsServer) Recv() (*ImportAssociationsRequest, error) {
+	m := new(ImportAssociationsRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var _User_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "userapi.User",
+	HandlerType: (*UserServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddEntity",
+			Handler:    _User_AddEntity_Handler,
+		},
+		{
+			MethodName: "AddEntities",
+			Handler:    _User_AddEntities_Handler,
+		},
+		{
+			MethodName: "RemoveEntity",
+			Handler:    _User_RemoveEntity_Handler,
+		},
+		{
 			MethodName: "GetEntities",
 			Handler:    _User_GetEntities_Handler,
 		},
+		{
+			MethodName: "GetEntitiesBatch",
+			Handler:    _User_GetEntitiesBatch_Handler,
+		},
+		{
+			MethodName: "GetUsers",
+			Handler:    _User_GetUsers_Handler,
+		},
+		{
+			MethodName: "GetAssociationHistory",
+			Handler:    _User_GetAssociationHistory_Handler,
+		},
+		{
+			MethodName: "SetUserLimit",
+			Handler:    _User_SetUserLimit_Handler,
+		},
+		{
+			MethodName: "SetEntityLimit",
+			Handler:    _User_SetEntityLimit_Handler,
+		},
+		{
+			MethodName: "UpdateRole",
+			Handler:    _User_UpdateRole_Handler,
+		},
+		{
+			MethodName: "PutUser",
+			Handler:    _User_PutUser_Handler,
+		},
+		{
+			MethodName: "GetUser",
+			Handler:    _User_GetUser_Handler,
+		},
+		{
+			MethodName: "UpdateUser",
+			Handler:    _User_UpdateUser_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEntities",
+			Handler:       _User_WatchEntities_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ExportAssociations",
+			Handler:       _User_ExportAssociations_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ImportAssociations",
+			Handler:       _User_ImportAssociations_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "pkg/userapi/user.proto",
 }
 
 func init() { proto.RegisterFile("pkg/userapi/user.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 214 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x12, 0x2b, 0xc8, 0x4e, 0xd7,
-	0x2f, 0x2d, 0x4e, 0x2d, 0x4a, 0x2c, 0xc8, 0x04, 0xd3, 0x7a, 0x05, 0x45, 0xf9, 0x25, 0xf9, 0x42,
-	0xec, 0x50, 0x31, 0x25, 0x0f, 0x2e, 0x01, 0xc7, 0x94, 0x14, 0xd7, 0xbc, 0x92, 0xcc, 0x92, 0xca,
-	0xa0, 0xd4, 0xc2, 0xd2, 0xd4, 0xe2, 0x12, 0x21, 0x71, 0x2e, 0xb0, 0x74, 0x7c, 0x66, 0x8a, 0x04,
-	0xa3, 0x02, 0xa3, 0x06, 0x67, 0x10, 0x1b, 0x88, 0xeb, 0x99, 0x22, 0x24, 0xcd, 0xc5, 0x99, 0x0a,
-	0x56, 0x09, 0x92, 0x62, 0x02, 0x4b, 0x71, 0x40, 0x04, 0x3c, 0x53, 0x94, 0x84, 0xb9, 0x04, 0x91,
-	0x4c, 0x2a, 0x2e, 0xc8, 0xcf, 0x2b, 0x4e, 0x55, 0xd2, 0xe5, 0x12, 0x72, 0x4f, 0x2d, 0x01, 0x0b,
-	0x66, 0xa6, 0x16, 0x13, 0xb2, 0x40, 0xc9, 0x84, 0x4b, 0x18, 0x45, 0x39, 0xc4, 0x14, 0x21, 0x59,
-	0x2e, 0x2e, 0xb8, 0xbd, 0xc5, 0x12, 0x8c, 0x0a, 0xcc, 0x1a, 0x9c, 0x41, 0x9c, 0x30, 0x8b, 0x8b,
-	0x8d, 0x66, 0x30, 0x72, 0xb1, 0x84, 0x16, 0xa7, 0x16, 0x09, 0xb9, 0x70, 0x71, 0xc2, 0x9d, 0x20,
-	0x24, 0xa9, 0x07, 0xf5, 0xa3, 0x1e, 0xba, 0x07, 0xa5, 0xa4, 0xb0, 0x49, 0x41, 0x5d, 0xcc, 0x20,
-	0xe4, 0xc5, 0xc5, 0x8d, 0xe4, 0x08, 0x21, 0x69, 0xb8, 0x62, 0x4c, 0x9f, 0x48, 0xc9, 0x60, 0x97,
-	0x84, 0x99, 0x95, 0xc4, 0x06, 0x0e, 0x6e, 0x63, 0x40, 0x00, 0x00, 0x00, 0xff, 0xff, 0x66, 0xb1,
-	0x99, 0x24, 0x88, 0x01, 0x00, 0x00,
+	// 1757 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x17, 0xdd, 0x72, 0xdb, 0x4a,
+	0xb9, 0xb2, 0x9d, 0xc4, 0xfe, 0x6c, 0x27, 0xea, 0x36, 0x4d, 0x1d, 0xa5, 0xe9, 0x49, 0x55, 0xe8,
+	0x84, 0xce, 0x90, 0x1c, 0x02, 0x33, 0x40, 0xa1, 0x9d, 0x31, 0xb1, 0x9a, 0x23, 0x9a, 0xd8, 0xe9,
+	0xda, 0x69, 0xa7, 0xdc, 0x68, 0x54, 0x6b, 0xd3, 0x8a, 0xda, 0x92, 0x2a, 0xc9, 0x21, 0xee, 0x0c,
+	0x17, 0x70, 0xcb, 0x30, 0xdc, 0xf3, 0x10, 0xbc, 0x04, 0xaf, 0xc4, 0x03, 0x30, 0xbb, 0x5a, 0xad,
+	0xd7, 0xb2, 0x94, 0x64, 0xd2, 0x73, 0x65, 0xef, 0xf7, 0xff, 0xaf, 0xef, 0x83, 0x8d, 0xe0, 0xf3,
+	0xc7, 0xfd, 0x49, 0x44, 0x42, 0x3b, 0x70, 0xd9, 0xef, 0x5e, 0x10, 0xfa, 0xb1, 0x8f, 0x56, 0x38,
+	0x4c, 0xfb, 0xee, 0xa3, 0xef, 0x7f, 0x1c, 0x91, 0x7d, 0x06, 0xfe, 0x30, 0x39, 0xdf, 0x8f, 0xdd,
+	0x31, 0x89, 0x62, 0x7b, 0x1c, 0x24, 0x94, 0xfa, 0xbf, 0x14, 0x50, 0xdb, 0x8e, 0x63, 0x78, 0xb1,
+	0x1b, 0x4f, 0x31, 0xf9, 0x32, 0x21, 0x51, 0x8c, 0x1e, 0x00, 0x13, 0x60, 0xb9, 0x4e, 0x4b, 0xd9,
+	0x51, 0x76, 0x6b, 0x78, 0x99, 0x3e, 0x4d, 0x07, 0x6d, 0x41, 0x8d, 0x30, 0x4a, 0x8a, 0x2a, 0x31,
+	0x54, 0x35, 0x01, 0x98, 0x0e, 0xd2, 0xa1, 0xe9, 0x9e, 0x5b, 0x9e, 0x1f, 0x5b, 0xe4, 0xd2, 0x8d,
+	0xe2, 0xa8, 0x55, 0xde, 0x51, 0x76, 0xab, 0xb8, 0xee, 0x9e, 0x77, 0xfd, 0xd8, 0x60, 0x20, 0xf4,
+	0x18, 0x2a, 0xa1, 0x3f, 0x22, 0xad, 0xca, 0x8e, 0xb2, 0xbb, 0x7a, 0xd0, 0xdc, 0xe3, 0x76, 0xee,
+	0x61, 0x7f, 0x44, 0x30, 0x43, 0xe9, 0x3f, 0x87, 0xbb, 0x92, 0x41, 0x51, 0xe0, 0x7b, 0x11, 0x41,
+	0x2d, 0x58, 0x19, 0x86, 0xc4, 0x8e, 0x49, 0x62, 0x51, 0x15, 0xa7, 0x4f, 0xbd, 0x0f, 0x28, 0x25,
+	0x77, 0x49, 0x94, 0x7a, 0xf0, 0x02, 0x1a, 0x76, 0x14, 0xf9, 0x43, 0xd7, 0x8e, 0x5d, 0xdf, 0x8b,
+	0x5a, 0xca, 0x4e, 0x79, 0xb7, 0x7e, 0xb0, 0x29, 0xf4, 0x65, 0x5d, 0xc6, 0x73, 0xe4, 0xba, 0x09,
+	0xf7, 0xe6, 0x84, 0x72, 0x2b, 0x0e, 0x60, 0x25, 0x24, 0xd1, 0x64, 0x14, 0xa7, 0x02, 0x5b, 0x79,
+	0x02, 0x29, 0x01, 0x4e, 0x09, 0xf5, 0x2f, 0xb0, 0x96, 0xc1, 0xdd, 0x32, 0xbc, 0xeb, 0xb0, 0x44,
+	0xc2, 0xd0, 0x0f, 0x59, 0x58, 0x6b, 0x38, 0x79, 0x20, 0x04, 0x95, 0xa1, 0xef, 0x24, 0x01, 0x6d,
+	0x62, 0xf6, 0x5f, 0x7f, 0x0d, 0xf7, 0x30, 0x19, 0xfb, 0x17, 0xe4, 0x47, 0xc8, 0xaa, 0xbe, 0x01,
+	0xeb, 0xf3, 0xc2, 0x92, 0x58, 0xe8, 0xff, 0x56, 0x00, 0x1d, 0x91, 0x38, 0x1b, 0xf8, 0x42, 0x25,
+	0xfb, 0xb0, 0x64, 0x47, 0x96, 0x7f, 0xce, 0x14, 0xd4, 0x0f, 0xb4, 0xbd, 0xa4, 0x32, 0xf7, 0xd2,
+	0xca, 0xdc, 0x1b, 0xa4, 0x95, 0x89, 0x2b, 0x76, 0xd4, 0x3b, 0xa7, 0x56, 0x05, 0xf6, 0x47, 0x62,
+	0x45, 0xee, 0x57, 0xc2, 0x7c, 0x6e, 0xe2, 0x2a, 0x05, 0xf4, 0xdd, 0xaf, 0x04, 0x6d, 0x03, 0x30,
+	0x64, 0xec, 0x7f, 0x26, 0x1e, 0x73, 0xbe, 0x86, 0x19, 0xf9, 0x80, 0x02, 0xf4, 0xbf, 0x29, 0x70,
+	0x6f, 0xce, 0x38, 0x9e, 0xc0, 0x6d, 0x00, 0xe1, 0x69, 0x92, 0xc3, 0x1a, 0xae, 0xa5, 0xae, 0x46,
+	0xe8, 0x09, 0x2c, 0xd1, 0x12, 0x8c, 0x5a, 0xa5, 0x9d, 0xf2, 0x62, 0x79, 0x26, 0x38, 0xf4, 0x14,
+	0xd6, 0x3c, 0x72, 0x19, 0x5b, 0x92, 0xfe, 0x24, 0x23, 0x4d, 0x0a, 0x3e, 0x15, 0x36, 0xfc, 0x0a,
+	0x1e, 0x48, 0x26, 0xfc, 0xc1, 0x8e, 0x87, 0x9f, 0xd2, 0x20, 0x6d, 0x42, 0x95, 0x07, 0x29, 0x35,
+	0x62, 0x25, 0x89, 0x52, 0xa4, 0xbf, 0x86, 0xd6, 0x22, 0x17, 0xb7, 0x7e, 0x3f, 0x5b, 0x7e, 0xf7,
+	0x85, 0x81, 0x67, 0x11, 0x09, 0x85, 0xb7, 0xa2, 0xf6, 0x02, 0x68, 0xc8, 0x88, 0xe2, 0xe4, 0xcc,
+	0xc7, 0xa5, 0x94, 0x8d, 0xcb, 0xcd, 0x4b, 0xef, 0xcf, 0xb0, 0x76, 0x44, 0x62, 0xaa, 0x54, 0x54,
+	0xc4, 0x5c, 0x75, 0x29, 0x99, 0xa2, 0x9e, 0x4b, 0x72, 0xe9, 0xca, 0x24, 0x97, 0xb3, 0x49, 0x3e,
+	0x03, 0x75, 0xa6, 0x8b, 0x87, 0xa8, 0x38, 0xb2, 0x79, 0x79, 0x2b, 0xe5, 0xe5, 0x6d, 0x00, 0x0f,
+	0x8f, 0x48, 0xdc, 0x9e, 0x8d, 0x83, 0x1f, 0xdc, 0x28, 0xf6, 0xc3, 0x6f, 0x6c, 0x23, 0x0c, 0xdb,
+	0x05, 0x52, 0xb9, 0xe5, 0xbf, 0x80, 0x65, 0x72, 0x41, 0xbc, 0x38, 0x67, 0x56, 0xcd, 0x98, 0x0c,
+	0x4a, 0x81, 0x39, 0xa1, 0xfe, 0x5f, 0x3a, 0xbb, 0x33, 0x48, 0xf4, 0x14, 0x2a, 0xf1, 0x34, 0x20,
+	0xcc, 0xb6, 0xd5, 0x03, 0x24, 0xa4, 0x30, 0xec, 0x60, 0x1a, 0x10, 0xcc, 0xf0, 0x68, 0x0f, 0x2a,
+	0xf4, 0x5b, 0x70, 0x93, 0x76, 0xa4, 0x74, 0xb2, 0xdb, 0xe5, 0x62, 0xb7, 0x2b, 0x99, 0xfc, 0xa6,
+	0xf3, 0x7e, 0xa9, 0x78, 0xde, 0x7f, 0x81, 0xf5, 0x77, 0xb4, 0xcc, 0x6f, 0x3c, 0x49, 0xae, 0x9c,
+	0x92, 0x4f, 0xa0, 0x79, 0x1e, 0xfa, 0x63, 0x2b, 0x24, 0x17, 0x6e, 0xe4, 0xfa, 0x49, 0xd9, 0x54,
+	0x70, 0x83, 0x02, 0x31, 0x87, 0xe9, 0x0e, 0xdc, 0xcf, 0xa8, 0x14, 0x1d, 0xb6, 0xc4, 0x62, 0xcb,
+	0x34, 0x5e, 0x99, 0x83, 0x84, 0x0e, 0x69, 0x50, 0x15, 0x9a, 0x4a, 0x4c, 0x93, 0x78, 0xeb, 0x6f,
+	0xe0, 0x5e, 0x3f, 0xa9, 0xcf, 0x63, 0x77, 0xec, 0xc6, 0xd7, 0xfa, 0xf5, 0x18, 0x1a, 0x63, 0xfb,
+	0xd2, 0x22, 0xdc, 0x28, 0xde, 0x0e, 0xf5, 0xb1, 0x7d, 0x99, 0xda, 0x49, 0x87, 0xf1, 0xbc, 0x48,
+	0x3e, 0x8c, 0xdf, 0xc0, 0xfd, 0x3e, 0x9f, 0x1a, 0xd3, 0x39, 0x65, 0xd7, 0x35, 0x1f, 0x55, 0x48,
+	0xd5, 0xa7, 0xda, 0xaa, 0x63, 0xfb, 0x92, 0x75, 0x94, 0xde, 0x82, 0x8d, 0xac, 0x48, 0xae, 0x6c,
+	0x04, 0x77, 0xcf, 0x02, 0xc7, 0x8e, 0x09, 0x4b, 0xe2, 0x37, 0x65, 0x2b, 0x2d, 0x8f, 0x72, 0x71,
+	0x79, 0xac, 0x03, 0x92, 0xb5, 0x71, 0x1b, 0xfe, 0x53, 0x82, 0x3a, 0xb5, 0xf3, 0x34, 0xf4, 0xcf,
+	0xdd, 0x11, 0xb9, 0x32, 0xa8, 0x8e, 0x1b, 0x05, 0x23, 0x7b, 0x6a, 0x79, 0x36, 0x2f, 0xf7, 0x1a,
+	0xae, 0x73, 0x58, 0xd7, 0x1e, 0x13, 0x36, 0xdd, 0xc6, 0xb6, 0x3b, 0x12, 0xd3, 0x8d, 0x3e, 0x28,
+	0x34, 0xf8, 0xe4, 0x7b, 0x84, 0x97, 0x74, 0xf2, 0x40, 0x1b, 0xb0, 0x3c, 0xf2, 0x87, 0x36, 0xaf,
+	0xe8, 0x1a, 0xe6, 0x2f, 0x5a, 0x07, 0xb4, 0x4b, 0xbe, 0x52, 0x86, 0xe5, 0xc4, 0xc9, 0xf4, 0x8d,
+	0x3a, 0x00, 0x76, 0x1c, 0x87, 0xee, 0x87, 0x49, 0x4c, 0xa2, 0xd6, 0x0a, 0xeb, 0xee, 0x9f, 0xcc,
+	0x4d, 0x6e, 0xee, 0xc5, 0x5e, 0x5b, 0x90, 0x19, 0x5e, 0x1c, 0x4e, 0xb1, 0xc4, 0xa7, 0xbd, 0x80,
+	0xb5, 0x0c, 0x1a, 0xa9, 0x50, 0xfe, 0x4c, 0xa6, 0xdc, 0x61, 0xfa, 0x97, 0x1a, 0x7d, 0x61, 0x8f,
+	0x26, 0xa9, 0x9b, 0xc9, 0xe3, 0x79, 0xe9, 0x37, 0x8a, 0xfe, 0x1c, 0x56, 0x4f, 0x27, 0xac, 0x72,
+	0xd2, 0x8c, 0xed, 0x42, 0x85, 0xda, 0xc0, 0x4b, 0x7d, 0x3d, 0xcf, 0x20, 0xcc, 0x28, 0xf4, 0xbb,
+	0xb0, 0x26, 0x78, 0x79, 0xfc, 0x7f, 0x06, 0xab, 0x47, 0x64, 0x4e, 0x5c, 0x51, 0x06, 0xf4, 0xdf,
+	0x89, 0x4f, 0x82, 0x68, 0xb3, 0x9b, 0xab, 0x7e, 0x91, 0xd6, 0xda, 0xed, 0x2c, 0x7f, 0x99, 0x16,
+	0xcf, 0x2d, 0xd5, 0x6f, 0xc1, 0xa6, 0x71, 0x19, 0xf8, 0xa1, 0x3c, 0xb8, 0xd3, 0x01, 0xa5, 0xff,
+	0x09, 0xb4, 0x3c, 0x24, 0x57, 0xf2, 0x7b, 0xa8, 0x4b, 0x2b, 0x25, 0xd7, 0xa5, 0xe5, 0x0d, 0x14,
+	0x4c, 0x86, 0x7e, 0xe8, 0x60, 0x99, 0x5c, 0xff, 0xa7, 0x02, 0x9b, 0xe6, 0xb8, 0x40, 0x33, 0xfa,
+	0x1e, 0x56, 0xfc, 0x20, 0x5d, 0x6c, 0xa9, 0xdc, 0x0d, 0x21, 0x37, 0x61, 0xea, 0x25, 0x58, 0x9c,
+	0x92, 0xa1, 0x97, 0x99, 0x7d, 0xb8, 0xb4, 0x53, 0xbe, 0xc6, 0x9c, 0xf9, 0x85, 0xd8, 0x81, 0xe6,
+	0x9c, 0x64, 0xf4, 0x1c, 0x9a, 0x43, 0xdf, 0x3b, 0x1f, 0xb9, 0xc3, 0xd8, 0x1a, 0xd3, 0x2d, 0x20,
+	0xf9, 0xde, 0xcc, 0x36, 0x92, 0x43, 0x8e, 0x3d, 0xf1, 0x1d, 0x82, 0x1b, 0x43, 0xe9, 0x45, 0x4b,
+	0xc5, 0x09, 0xa7, 0x56, 0x38, 0x49, 0x66, 0x66, 0x15, 0x2f, 0x3b, 0xe1, 0x14, 0x4f, 0x3c, 0xfd,
+	0xaf, 0xa0, 0x99, 0xe3, 0xc2, 0x88, 0x6e, 0x03, 0x78, 0x96, 0xcb, 0xf0, 0xfc, 0x0c, 0xa8, 0xe0,
+	0x9a, 0x67, 0x72, 0x00, 0x9d, 0xfc, 0x9e, 0xe5, 0x5f, 0x90, 0xf0, 0x2f, 0xa1, 0x1b, 0xc7, 0x24,
+	0x9d, 0xc7, 0x0d, 0xaf, 0x37, 0x83, 0xd1, 0x69, 0xe4, 0x59, 0xd1, 0x67, 0x37, 0x08, 0x88, 0xc3,
+	0x3f, 0x0d, 0x55, 0xaf, 0x9f, 0xbc, 0xf5, 0xbf, 0x2b, 0x70, 0x77, 0x21, 0x10, 0xb7, 0x9c, 0x6c,
+	0xbf, 0x86, 0xea, 0x05, 0x09, 0x23, 0x16, 0xec, 0x32, 0x0b, 0xf6, 0x56, 0x5e, 0xb0, 0xdf, 0x26,
+	0x34, 0x58, 0x10, 0xeb, 0xff, 0x53, 0x00, 0x2d, 0x12, 0x88, 0x49, 0xa9, 0x14, 0x4e, 0x4a, 0xf4,
+	0x5b, 0x00, 0xdb, 0x71, 0x88, 0x63, 0xdd, 0xf0, 0xbb, 0x5e, 0x63, 0xd4, 0xf4, 0x4d, 0xcf, 0xa5,
+	0x90, 0x2d, 0xf9, 0x9c, 0xb9, 0x7c, 0x2d, 0x73, 0x9d, 0xd3, 0x33, 0xf6, 0xc7, 0xd0, 0xa0, 0x16,
+	0x58, 0x13, 0xd6, 0x6b, 0xc9, 0x16, 0x50, 0xc5, 0x75, 0x0a, 0x4b, 0xda, 0xcf, 0x41, 0x8f, 0x00,
+	0xa2, 0x49, 0x40, 0xc2, 0x88, 0x38, 0xc4, 0x61, 0xc3, 0xb3, 0x8a, 0x25, 0x88, 0xee, 0xc2, 0x6a,
+	0xdf, 0xb3, 0x83, 0xe8, 0x93, 0x1f, 0xff, 0x40, 0x6c, 0x87, 0x84, 0xf4, 0xe4, 0xe3, 0x41, 0x61,
+	0x4e, 0x37, 0x71, 0xfa, 0xa4, 0xd6, 0xf2, 0xeb, 0xef, 0xa6, 0xae, 0xd6, 0x39, 0x3d, 0x85, 0x3c,
+	0x7b, 0x03, 0x35, 0xb1, 0x0c, 0x21, 0x0d, 0x36, 0xce, 0xba, 0xfd, 0x53, 0xe3, 0xd0, 0x7c, 0x65,
+	0x1a, 0x1d, 0xcb, 0x78, 0x6b, 0x74, 0x07, 0xd6, 0xe0, 0xfd, 0xa9, 0xa1, 0xde, 0x41, 0x35, 0x58,
+	0x6a, 0x77, 0x3a, 0x46, 0x47, 0x55, 0x50, 0x1d, 0x56, 0xb0, 0x71, 0xd2, 0x7b, 0x6b, 0x74, 0xd4,
+	0x12, 0x52, 0xa1, 0x81, 0x7b, 0xc7, 0x86, 0x75, 0x76, 0xda, 0x69, 0x0f, 0x8c, 0x8e, 0x5a, 0x7e,
+	0xf6, 0x1e, 0x2a, 0x34, 0x11, 0x68, 0x1d, 0x54, 0x59, 0x1a, 0xa5, 0x4a, 0xe4, 0xf4, 0xde, 0x75,
+	0x0d, 0xac, 0x2a, 0x89, 0xc8, 0x13, 0xb3, 0xab, 0x96, 0x50, 0x13, 0x6a, 0x87, 0xc7, 0x66, 0xd7,
+	0x3c, 0x34, 0xdb, 0x5d, 0xb5, 0x8c, 0x36, 0x00, 0x61, 0xa3, 0xdd, 0xb1, 0x7a, 0xdd, 0xe3, 0xf7,
+	0x56, 0xc7, 0x38, 0x36, 0x8e, 0xda, 0x03, 0x43, 0xad, 0x3c, 0x3b, 0x85, 0x86, 0xdc, 0x4a, 0x68,
+	0x1b, 0x36, 0x65, 0x15, 0x87, 0xbd, 0xee, 0xab, 0x63, 0xf3, 0x70, 0x60, 0x9d, 0xf4, 0x3a, 0x54,
+	0x57, 0x15, 0x2a, 0xfd, 0xd7, 0xe6, 0xa9, 0xaa, 0x50, 0xf9, 0xbd, 0xb7, 0x06, 0x7e, 0x87, 0xcd,
+	0x81, 0xa1, 0x96, 0x28, 0xe2, 0x55, 0xdb, 0x3c, 0x56, 0xcb, 0x07, 0xff, 0x00, 0xa8, 0xd0, 0x51,
+	0x87, 0x3a, 0x50, 0x13, 0xa7, 0x29, 0x2a, 0xbe, 0x8d, 0x35, 0x2d, 0x0f, 0xc5, 0x3f, 0x04, 0x77,
+	0xd0, 0x1f, 0xa1, 0x2e, 0xdd, 0xca, 0x68, 0x6b, 0x81, 0x78, 0xb6, 0xd3, 0x69, 0x0f, 0xf3, 0x91,
+	0x42, 0xd6, 0x09, 0x34, 0xe4, 0x63, 0x13, 0xcd, 0xe8, 0x73, 0x0e, 0x5a, 0x6d, 0xbb, 0x00, 0x2b,
+	0x9b, 0x26, 0x1d, 0x53, 0x92, 0x69, 0x8b, 0x87, 0xab, 0xf6, 0x30, 0x1f, 0x29, 0x64, 0xbd, 0x07,
+	0x55, 0x42, 0xb0, 0xc3, 0x0c, 0xed, 0xe4, 0xf1, 0xc8, 0x97, 0x9e, 0xf6, 0xf8, 0x0a, 0x0a, 0x21,
+	0xba, 0x0d, 0xd5, 0xf4, 0x90, 0x41, 0x2d, 0x99, 0x41, 0xbe, 0xa3, 0xb4, 0xcd, 0x1c, 0x8c, 0x10,
+	0xf1, 0x09, 0xee, 0xe7, 0x9e, 0x17, 0xe8, 0xa7, 0x32, 0x57, 0xe1, 0x51, 0xa3, 0x3d, 0xbd, 0x8e,
+	0x4c, 0x68, 0xc2, 0xd0, 0x9c, 0xdb, 0x9d, 0xd1, 0x2c, 0x0b, 0x79, 0x6b, 0xbc, 0xf6, 0xa8, 0x08,
+	0x9d, 0x4a, 0xfc, 0x5e, 0xa1, 0x69, 0x97, 0xd7, 0x5a, 0x29, 0xed, 0x39, 0x0b, 0xb4, 0xb6, 0x5d,
+	0x80, 0x15, 0x26, 0xf6, 0x61, 0x75, 0x7e, 0x75, 0x45, 0x8f, 0x64, 0x96, 0xc5, 0x35, 0x59, 0xfb,
+	0xae, 0x10, 0x2f, 0x84, 0x1e, 0x01, 0xcc, 0xf6, 0x50, 0x34, 0x6b, 0x89, 0x85, 0x55, 0x58, 0xdb,
+	0xca, 0xc5, 0x09, 0x41, 0x2f, 0x61, 0x85, 0x6f, 0x53, 0xe8, 0x81, 0xa0, 0x9c, 0xdf, 0xcd, 0xb4,
+	0xd6, 0x22, 0x42, 0xe6, 0x3f, 0x22, 0x59, 0xfe, 0x23, 0x52, 0xc0, 0x9f, 0x59, 0xbd, 0x64, 0x47,
+	0x98, 0x88, 0xac, 0x23, 0xb2, 0x94, 0xad, 0x5c, 0x9c, 0x10, 0x64, 0x03, 0x5a, 0xdc, 0x7f, 0x90,
+	0x2e, 0x98, 0x0a, 0x37, 0x27, 0xed, 0xc9, 0x95, 0x34, 0x52, 0x61, 0xd8, 0x80, 0xcc, 0xf1, 0x15,
+	0x2a, 0xcc, 0xf1, 0xf5, 0x2a, 0x8a, 0x37, 0x0a, 0xfd, 0xce, 0xae, 0xf2, 0x61, 0x99, 0x7d, 0x2e,
+	0x7e, 0xf9, 0xff, 0x01, 0x00, 0x3d, 0x58, 0x8a, 0x32, 0x4b, 0x15, 0x00, 0x00,
 }
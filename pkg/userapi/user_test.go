@@ -3,6 +3,8 @@ package userapi
 import (
 	"testing"
 
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -31,6 +33,22 @@ func TestValidateAddEntityRequest(t *testing.T) {
 			},
 			expected: ErrEmptyUserID,
 		},
+		"ok role": {
+			rq: &AddEntityRequest{
+				UserId:   userID,
+				EntityId: entityID,
+				Role:     Role_CLINICIAN,
+			},
+			expected: nil,
+		},
+		"invalid role": {
+			rq: &AddEntityRequest{
+				UserId:   userID,
+				EntityId: entityID,
+				Role:     Role(-1),
+			},
+			expected: ErrInvalidRole,
+		},
 	}
 	for desc, c := range cases {
 		err := ValidateAddEntityRequest(c.rq)
@@ -38,6 +56,38 @@ func TestValidateAddEntityRequest(t *testing.T) {
 	}
 }
 
+func TestValidateRemoveEntityRequest(t *testing.T) {
+	userID, entityID := "some user ID", "some entity ID"
+	cases := map[string]struct {
+		rq       *RemoveEntityRequest
+		expected error
+	}{
+		"ok": {
+			rq: &RemoveEntityRequest{
+				UserId:   userID,
+				EntityId: entityID,
+			},
+			expected: nil,
+		},
+		"empty entity ID": {
+			rq: &RemoveEntityRequest{
+				UserId: userID,
+			},
+			expected: ErrEmptyEntityID,
+		},
+		"empty user ID": {
+			rq: &RemoveEntityRequest{
+				EntityId: entityID,
+			},
+			expected: ErrEmptyUserID,
+		},
+	}
+	for desc, c := range cases {
+		err := ValidateRemoveEntityRequest(c.rq)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
 func TestValidateGetEntitiesRequest(t *testing.T) {
 	userID := "some user ID"
 	cases := map[string]struct {
@@ -50,13 +100,487 @@ func TestValidateGetEntitiesRequest(t *testing.T) {
 			},
 			expected: nil,
 		},
+		"ok as of": {
+			rq: &GetEntitiesRequest{
+				UserId: userID,
+				AsOf:   ptypes.TimestampNow(),
+			},
+			expected: nil,
+		},
 		"empty user ID": {
 			rq:       &GetEntitiesRequest{},
 			expected: ErrEmptyUserID,
 		},
+		"invalid as of": {
+			rq: &GetEntitiesRequest{
+				UserId: userID,
+				AsOf:   &timestamp.Timestamp{Nanos: -1},
+			},
+			expected: ErrInvalidAsOf,
+		},
 	}
 	for desc, c := range cases {
 		err := ValidateGetEntitiesRequest(c.rq)
 		assert.Equal(t, c.expected, err, desc)
 	}
 }
+
+func TestValidateGetUsersRequest(t *testing.T) {
+	entityID := "some entity ID"
+	cases := map[string]struct {
+		rq       *GetUsersRequest
+		expected error
+	}{
+		"ok": {
+			rq: &GetUsersRequest{
+				EntityId: entityID,
+			},
+			expected: nil,
+		},
+		"empty entity ID": {
+			rq:       &GetUsersRequest{},
+			expected: ErrEmptyEntityID,
+		},
+	}
+	for desc, c := range cases {
+		err := ValidateGetUsersRequest(c.rq)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestValidateGetAssociationHistoryRequest(t *testing.T) {
+	userID, entityID := "some user ID", "some entity ID"
+	cases := map[string]struct {
+		rq       *GetAssociationHistoryRequest
+		expected error
+	}{
+		"ok user ID": {
+			rq:       &GetAssociationHistoryRequest{UserId: userID},
+			expected: nil,
+		},
+		"ok entity ID": {
+			rq:       &GetAssociationHistoryRequest{EntityId: entityID},
+			expected: nil,
+		},
+		"ok both": {
+			rq:       &GetAssociationHistoryRequest{UserId: userID, EntityId: entityID},
+			expected: nil,
+		},
+		"empty user and entity ID": {
+			rq:       &GetAssociationHistoryRequest{},
+			expected: ErrEmptyUserAndEntityID,
+		},
+	}
+	for desc, c := range cases {
+		err := ValidateGetAssociationHistoryRequest(c.rq)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestValidateSetUserLimitRequest(t *testing.T) {
+	cases := map[string]struct {
+		rq       *SetUserLimitRequest
+		expected error
+	}{
+		"ok": {
+			rq:       &SetUserLimitRequest{UserId: "some user ID", MaxEntities: 32},
+			expected: nil,
+		},
+		"ok clear override": {
+			rq:       &SetUserLimitRequest{UserId: "some user ID"},
+			expected: nil,
+		},
+		"empty user ID": {
+			rq:       &SetUserLimitRequest{MaxEntities: 32},
+			expected: ErrEmptyUserID,
+		},
+	}
+	for desc, c := range cases {
+		err := ValidateSetUserLimitRequest(c.rq)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestValidateSetEntityLimitRequest(t *testing.T) {
+	cases := map[string]struct {
+		rq       *SetEntityLimitRequest
+		expected error
+	}{
+		"ok": {
+			rq:       &SetEntityLimitRequest{EntityId: "some entity ID", MaxUsers: 1024},
+			expected: nil,
+		},
+		"ok clear override": {
+			rq:       &SetEntityLimitRequest{EntityId: "some entity ID"},
+			expected: nil,
+		},
+		"empty entity ID": {
+			rq:       &SetEntityLimitRequest{MaxUsers: 1024},
+			expected: ErrEmptyEntityID,
+		},
+	}
+	for desc, c := range cases {
+		err := ValidateSetEntityLimitRequest(c.rq)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestValidateAddEntitiesRequest(t *testing.T) {
+	okAssociation := &AddEntityRequest{UserId: "some user ID", EntityId: "some entity ID"}
+	tooMany := make([]*AddEntityRequest, MaxBatchSize+1)
+	for i := range tooMany {
+		tooMany[i] = okAssociation
+	}
+	cases := map[string]struct {
+		rq       *AddEntitiesRequest
+		expected error
+	}{
+		"ok": {
+			rq:       &AddEntitiesRequest{Associations: []*AddEntityRequest{okAssociation}},
+			expected: nil,
+		},
+		"ok with invalid association": {
+			rq:       &AddEntitiesRequest{Associations: []*AddEntityRequest{{}}},
+			expected: nil,
+		},
+		"empty": {
+			rq:       &AddEntitiesRequest{},
+			expected: ErrEmptyBatch,
+		},
+		"too large": {
+			rq:       &AddEntitiesRequest{Associations: tooMany},
+			expected: ErrBatchTooLarge,
+		},
+	}
+	for desc, c := range cases {
+		err := ValidateAddEntitiesRequest(c.rq)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestValidateGetEntitiesBatchRequest(t *testing.T) {
+	cases := map[string]struct {
+		rq       *GetEntitiesBatchRequest
+		expected error
+	}{
+		"ok": {
+			rq:       &GetEntitiesBatchRequest{UserIds: []string{"some user ID", ""}},
+			expected: nil,
+		},
+		"empty": {
+			rq:       &GetEntitiesBatchRequest{},
+			expected: ErrEmptyBatch,
+		},
+		"too large": {
+			rq:       &GetEntitiesBatchRequest{UserIds: make([]string, MaxBatchSize+1)},
+			expected: ErrBatchTooLarge,
+		},
+	}
+	for desc, c := range cases {
+		err := ValidateGetEntitiesBatchRequest(c.rq)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestValidateUpdateRoleRequest(t *testing.T) {
+	userID, entityID := "some user ID", "some entity ID"
+	cases := map[string]struct {
+		rq       *UpdateRoleRequest
+		expected error
+	}{
+		"ok": {
+			rq:       &UpdateRoleRequest{UserId: userID, EntityId: entityID, Role: Role_ADMIN},
+			expected: nil,
+		},
+		"empty entity ID": {
+			rq:       &UpdateRoleRequest{UserId: userID, Role: Role_ADMIN},
+			expected: ErrEmptyEntityID,
+		},
+		"empty user ID": {
+			rq:       &UpdateRoleRequest{EntityId: entityID, Role: Role_ADMIN},
+			expected: ErrEmptyUserID,
+		},
+		"empty role": {
+			rq:       &UpdateRoleRequest{UserId: userID, EntityId: entityID},
+			expected: ErrEmptyRole,
+		},
+		"invalid role": {
+			rq:       &UpdateRoleRequest{UserId: userID, EntityId: entityID, Role: Role(99)},
+			expected: ErrInvalidRole,
+		},
+	}
+	for desc, c := range cases {
+		err := ValidateUpdateRoleRequest(c.rq)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestValidatePutUserRequest(t *testing.T) {
+	userID := "some user ID"
+	cases := map[string]struct {
+		rq       *PutUserRequest
+		expected error
+	}{
+		"ok": {
+			rq: &PutUserRequest{
+				User: &UserProfile{
+					UserId:      userID,
+					DisplayName: "Some User",
+					Email:       "some.user@example.com",
+					Attributes:  map[string]string{"some key": "some value"},
+				},
+			},
+			expected: nil,
+		},
+		"ok only user ID": {
+			rq:       &PutUserRequest{User: &UserProfile{UserId: userID}},
+			expected: nil,
+		},
+		"empty user": {
+			rq:       &PutUserRequest{},
+			expected: ErrEmptyUser,
+		},
+		"empty user ID": {
+			rq:       &PutUserRequest{User: &UserProfile{DisplayName: "Some User"}},
+			expected: ErrEmptyUserID,
+		},
+		"invalid email": {
+			rq:       &PutUserRequest{User: &UserProfile{UserId: userID, Email: "some user"}},
+			expected: ErrInvalidEmail,
+		},
+		"email with name": {
+			rq: &PutUserRequest{
+				User: &UserProfile{UserId: userID, Email: "Some User <some.user@example.com>"},
+			},
+			expected: ErrInvalidEmail,
+		},
+		"empty attribute key": {
+			rq: &PutUserRequest{
+				User: &UserProfile{UserId: userID, Attributes: map[string]string{"": "value"}},
+			},
+			expected: ErrEmptyAttributeKey,
+		},
+	}
+	for desc, c := range cases {
+		err := ValidatePutUserRequest(c.rq)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestValidateGetUserRequest(t *testing.T) {
+	cases := map[string]struct {
+		rq       *GetUserRequest
+		expected error
+	}{
+		"ok": {
+			rq:       &GetUserRequest{UserId: "some user ID"},
+			expected: nil,
+		},
+		"empty user ID": {
+			rq:       &GetUserRequest{},
+			expected: ErrEmptyUserID,
+		},
+	}
+	for desc, c := range cases {
+		err := ValidateGetUserRequest(c.rq)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestValidateUpdateUserRequest(t *testing.T) {
+	cases := map[string]struct {
+		rq       *UpdateUserRequest
+		expected error
+	}{
+		"ok": {
+			rq: &UpdateUserRequest{
+				User: &UserProfile{UserId: "some user ID", Timezone: "America/New_York"},
+			},
+			expected: nil,
+		},
+		"empty user": {
+			rq:       &UpdateUserRequest{},
+			expected: ErrEmptyUser,
+		},
+		"empty user ID": {
+			rq:       &UpdateUserRequest{User: &UserProfile{Locale: "en-US"}},
+			expected: ErrEmptyUserID,
+		},
+	}
+	for desc, c := range cases {
+		err := ValidateUpdateUserRequest(c.rq)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestValidateWatchEntitiesRequest(t *testing.T) {
+	cases := map[string]struct {
+		rq       *WatchEntitiesRequest
+		expected error
+	}{
+		"ok user ID": {
+			rq:       &WatchEntitiesRequest{UserId: "some user ID"},
+			expected: nil,
+		},
+		"ok entity ID": {
+			rq:       &WatchEntitiesRequest{EntityId: "some entity ID", FromRevision: 2},
+			expected: nil,
+		},
+		"empty user and entity ID": {
+			rq:       &WatchEntitiesRequest{FromRevision: 2},
+			expected: ErrEmptyUserAndEntityID,
+		},
+	}
+	for desc, c := range cases {
+		err := ValidateWatchEntitiesRequest(c.rq)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestValidateImportAssociationsRequest(t *testing.T) {
+	okOptions := &ImportOptions{ConflictMode: ConflictMode_SKIP}
+	okRecord := &AssociationRecord{
+		UserId:   "some user ID",
+		EntityId: "some entity ID",
+		Versions: []*AssociationVersion{{AddedTime: ptypes.TimestampNow()}},
+	}
+	tooMany := make([]*AssociationRecord, MaxBatchSize+1)
+	for i := range tooMany {
+		tooMany[i] = okRecord
+	}
+	cases := map[string]struct {
+		rq       *ImportAssociationsRequest
+		first    bool
+		expected error
+	}{
+		"ok first": {
+			rq: &ImportAssociationsRequest{
+				Options:      okOptions,
+				Associations: []*AssociationRecord{okRecord},
+			},
+			first:    true,
+			expected: nil,
+		},
+		"ok options only": {
+			rq:       &ImportAssociationsRequest{Options: okOptions},
+			first:    true,
+			expected: nil,
+		},
+		"ok later": {
+			rq:       &ImportAssociationsRequest{Associations: []*AssociationRecord{okRecord}},
+			expected: nil,
+		},
+		"missing options": {
+			rq:       &ImportAssociationsRequest{Associations: []*AssociationRecord{okRecord}},
+			first:    true,
+			expected: ErrInvalidConflictMode,
+		},
+		"unspecified conflict mode": {
+			rq:       &ImportAssociationsRequest{Options: &ImportOptions{DryRun: true}},
+			first:    true,
+			expected: ErrInvalidConflictMode,
+		},
+		"undefined conflict mode": {
+			rq: &ImportAssociationsRequest{
+				Options: &ImportOptions{ConflictMode: ConflictMode(-1)},
+			},
+			first:    true,
+			expected: ErrInvalidConflictMode,
+		},
+		"too large": {
+			rq:       &ImportAssociationsRequest{Associations: tooMany},
+			expected: ErrBatchTooLarge,
+		},
+		"invalid record": {
+			rq:       &ImportAssociationsRequest{Associations: []*AssociationRecord{{}}},
+			expected: ErrEmptyEntityID,
+		},
+	}
+	for desc, c := range cases {
+		err := ValidateImportAssociationsRequest(c.rq, c.first)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}
+
+func TestValidateAssociationRecord(t *testing.T) {
+	t0 := ptypes.TimestampNow()
+	t1 := &timestamp.Timestamp{Seconds: t0.Seconds + 1}
+	t2 := &timestamp.Timestamp{Seconds: t0.Seconds + 2}
+	record := func(versions ...*AssociationVersion) *AssociationRecord {
+		return &AssociationRecord{
+			UserId:   "some user ID",
+			EntityId: "some entity ID",
+			Versions: versions,
+		}
+	}
+	cases := map[string]struct {
+		r        *AssociationRecord
+		expected error
+	}{
+		"ok current": {
+			r:        record(&AssociationVersion{Role: Role_OWNER, AddedTime: t0}),
+			expected: nil,
+		},
+		"ok history": {
+			r: record(
+				&AssociationVersion{Role: Role_OWNER, AddedTime: t0, RemovedTime: t1,
+					Superseded: true},
+				&AssociationVersion{Role: Role_ADMIN, AddedTime: t1, RemovedTime: t2,
+					RoleUpdated: true},
+			),
+			expected: nil,
+		},
+		"empty user ID": {
+			r: &AssociationRecord{
+				EntityId: "some entity ID",
+				Versions: []*AssociationVersion{{AddedTime: t0}},
+			},
+			expected: ErrEmptyUserID,
+		},
+		"empty entity ID": {
+			r: &AssociationRecord{
+				UserId:   "some user ID",
+				Versions: []*AssociationVersion{{AddedTime: t0}},
+			},
+			expected: ErrEmptyEntityID,
+		},
+		"empty versions": {
+			r:        record(),
+			expected: ErrEmptyVersions,
+		},
+		"invalid role": {
+			r:        record(&AssociationVersion{Role: Role(-1), AddedTime: t0}),
+			expected: ErrInvalidRole,
+		},
+		"missing added time": {
+			r:        record(&AssociationVersion{}),
+			expected: ErrInvalidVersions,
+		},
+		"removed before added": {
+			r:        record(&AssociationVersion{AddedTime: t1, RemovedTime: t0}),
+			expected: ErrInvalidVersions,
+		},
+		"removed when added": {
+			r:        record(&AssociationVersion{AddedTime: t0, RemovedTime: t0}),
+			expected: ErrInvalidVersions,
+		},
+		"current not last": {
+			r: record(
+				&AssociationVersion{AddedTime: t0},
+				&AssociationVersion{AddedTime: t1},
+			),
+			expected: ErrInvalidVersions,
+		},
+		"overlapping": {
+			r: record(
+				&AssociationVersion{AddedTime: t0, RemovedTime: t2},
+				&AssociationVersion{AddedTime: t1},
+			),
+			expected: ErrInvalidVersions,
+		},
+	}
+	for desc, c := range cases {
+		err := ValidateAssociationRecord(c.r)
+		assert.Equal(t, c.expected, err, desc)
+	}
+}